@@ -13,6 +13,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -29,6 +30,10 @@ const (
 	// the HTTP token.
 	HTTPTokenEnvName = "CONSUL_HTTP_TOKEN"
 
+	// HTTPTokenFileEnvName defines an environment variable name which sets
+	// the path to a file containing the HTTP token.
+	HTTPTokenFileEnvName = "CONSUL_HTTP_TOKEN_FILE"
+
 	// HTTPAuthEnvName defines an environment variable name which sets
 	// the HTTP authentication header.
 	HTTPAuthEnvName = "CONSUL_HTTP_AUTH"
@@ -40,6 +45,31 @@ const (
 	// HTTPSSLVerifyEnvName defines an environment variable name which sets
 	// whether or not to disable certificate checking.
 	HTTPSSLVerifyEnvName = "CONSUL_HTTP_SSL_VERIFY"
+
+	// HTTPCAFileEnvName defines an environment variable name which sets
+	// the CA file to use for talking to Consul over TLS.
+	HTTPCAFileEnvName = "CONSUL_CACERT"
+
+	// HTTPCAPathEnvName defines an environment variable name which sets
+	// the path to a directory of CA certs to use for talking to Consul
+	// over TLS.
+	HTTPCAPathEnvName = "CONSUL_CAPATH"
+
+	// HTTPClientCertEnvName defines an environment variable name which
+	// sets the client cert file to use for talking to Consul over TLS.
+	HTTPClientCertEnvName = "CONSUL_CLIENT_CERT"
+
+	// HTTPClientKeyEnvName defines an environment variable name which
+	// sets the client key file to use for talking to Consul over TLS.
+	HTTPClientKeyEnvName = "CONSUL_CLIENT_KEY"
+
+	// HTTPTLSServerNameEnvName defines an environment variable name which
+	// sets the server name to use as the SNI host when connecting via TLS.
+	HTTPTLSServerNameEnvName = "CONSUL_TLS_SERVER_NAME"
+
+	// HTTPNamespaceEnvName defines an environment variable name which sets
+	// the namespace to use for requests to Consul Enterprise.
+	HTTPNamespaceEnvName = "CONSUL_NAMESPACE"
 )
 
 // QueryOptions are used to parameterize a query
@@ -48,6 +78,10 @@ type QueryOptions struct {
 	// by the Config
 	Datacenter string
 
+	// Providing a namespace overwrites the namespace provided by the
+	// Config. Namespaces are only supported by Consul Enterprise.
+	Namespace string
+
 	// AllowStale allows any Consul server (non-leader) to service
 	// a read. This allows for lower latency and higher throughput
 	AllowStale bool
@@ -87,6 +121,10 @@ type WriteOptions struct {
 	// by the Config
 	Datacenter string
 
+	// Providing a namespace overwrites the namespace provided by the
+	// Config. Namespaces are only supported by Consul Enterprise.
+	Namespace string
+
 	// Token is used to provide a per-request ACL token
 	// which overrides the agent's default token.
 	Token string
@@ -138,6 +176,10 @@ type Config struct {
 	// Datacenter to use. If not provided, the default agent datacenter is used.
 	Datacenter string
 
+	// Namespace to use. If not provided, the namespace of the token is used.
+	// Namespaces are only supported by Consul Enterprise.
+	Namespace string
+
 	// HttpClient is the client to use. Default will be
 	// used if not provided.
 	HttpClient *http.Client
@@ -166,6 +208,11 @@ type TLSConfig struct {
 	// communication, defaults to the system bundle if not specified.
 	CAFile string
 
+	// CAPath is the optional path to a directory of CA certificates to use
+	// for Consul communication, along with any CAFile. Defaults to the
+	// system bundle if neither is specified.
+	CAPath string
+
 	// CertFile is the optional path to the certificate for Consul
 	// communication. If this is set then you need to also set KeyFile.
 	CertFile string
@@ -216,6 +263,10 @@ func defaultConfig(transportFn func() *http.Transport) *Config {
 		config.Token = token
 	}
 
+	if ns := os.Getenv(HTTPNamespaceEnvName); ns != "" {
+		config.Namespace = ns
+	}
+
 	if auth := os.Getenv(HTTPAuthEnvName); auth != "" {
 		var username, password string
 		if strings.Contains(auth, ":") {
@@ -298,15 +349,21 @@ func SetupTLSConfig(tlsConfig *TLSConfig) (*tls.Config, error) {
 		tlsClientConfig.Certificates = []tls.Certificate{tlsCert}
 	}
 
-	if tlsConfig.CAFile != "" {
-		data, err := ioutil.ReadFile(tlsConfig.CAFile)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read CA file: %v", err)
-		}
-
+	if tlsConfig.CAFile != "" || tlsConfig.CAPath != "" {
 		caPool := x509.NewCertPool()
-		if !caPool.AppendCertsFromPEM(data) {
-			return nil, fmt.Errorf("failed to parse CA certificate")
+		if tlsConfig.CAFile != "" {
+			data, err := ioutil.ReadFile(tlsConfig.CAFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read CA file: %v", err)
+			}
+			if !caPool.AppendCertsFromPEM(data) {
+				return nil, fmt.Errorf("failed to parse CA certificate")
+			}
+		}
+		if tlsConfig.CAPath != "" {
+			if err := appendCAPath(caPool, tlsConfig.CAPath); err != nil {
+				return nil, err
+			}
 		}
 		tlsClientConfig.RootCAs = caPool
 	}
@@ -314,6 +371,34 @@ func SetupTLSConfig(tlsConfig *TLSConfig) (*tls.Config, error) {
 	return tlsClientConfig, nil
 }
 
+// appendCAPath adds the certificates from every file in the given directory
+// to the pool. Files which don't contain any PEM certificates are skipped,
+// but at least one certificate must be found.
+func appendCAPath(caPool *x509.CertPool, path string) error {
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		return fmt.Errorf("failed to read CA path: %v", err)
+	}
+
+	found := false
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(path, file.Name()))
+		if err != nil {
+			return fmt.Errorf("failed to read CA file: %v", err)
+		}
+		if caPool.AppendCertsFromPEM(data) {
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("no CA certificates found in %s", path)
+	}
+	return nil
+}
+
 // Client provides a client to the Consul API
 type Client struct {
 	config Config
@@ -373,6 +458,9 @@ func (r *request) setQueryOptions(q *QueryOptions) {
 	if q.Datacenter != "" {
 		r.params.Set("dc", q.Datacenter)
 	}
+	if q.Namespace != "" {
+		r.params.Set("ns", q.Namespace)
+	}
 	if q.AllowStale {
 		r.params.Set("stale", "")
 	}
@@ -434,6 +522,9 @@ func (r *request) setWriteOptions(q *WriteOptions) {
 	if q.Datacenter != "" {
 		r.params.Set("dc", q.Datacenter)
 	}
+	if q.Namespace != "" {
+		r.params.Set("ns", q.Namespace)
+	}
 	if q.Token != "" {
 		r.header.Set("X-Consul-Token", q.Token)
 	}
@@ -488,6 +579,9 @@ func (c *Client) newRequest(method, path string) *request {
 	if c.config.Datacenter != "" {
 		r.params.Set("dc", c.config.Datacenter)
 	}
+	if c.config.Namespace != "" {
+		r.params.Set("ns", c.config.Namespace)
+	}
 	if c.config.WaitTime != 0 {
 		r.params.Set("wait", durToMsec(r.config.WaitTime))
 	}
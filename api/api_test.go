@@ -86,6 +86,8 @@ func TestDefaultConfig_env(t *testing.T) {
 	defer os.Setenv(HTTPSSLEnvName, "")
 	os.Setenv(HTTPSSLVerifyEnvName, "0")
 	defer os.Setenv(HTTPSSLVerifyEnvName, "")
+	os.Setenv(HTTPNamespaceEnvName, "team-a")
+	defer os.Setenv(HTTPNamespaceEnvName, "")
 
 	for i, config := range []*Config{DefaultConfig(), DefaultNonPooledConfig()} {
 		if config.Address != addr {
@@ -94,6 +96,9 @@ func TestDefaultConfig_env(t *testing.T) {
 		if config.Token != token {
 			t.Errorf("expected %q to be %q", config.Token, token)
 		}
+		if config.Namespace != "team-a" {
+			t.Errorf("expected %q to be %q", config.Namespace, "team-a")
+		}
 		if config.HttpAuth == nil {
 			t.Fatalf("expected HttpAuth to be enabled")
 		}
@@ -213,6 +218,23 @@ func TestSetupTLSConfig(t *testing.T) {
 	if cc.RootCAs == nil {
 		t.Fatalf("didn't load root CAs")
 	}
+
+	// A CA path loads the certs from every file in the directory.
+	tlsConfig = &TLSConfig{
+		CAPath: "../test/hostname",
+	}
+	cc, err = SetupTLSConfig(tlsConfig)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if cc.RootCAs == nil || len(cc.RootCAs.Subjects()) != 2 {
+		t.Fatalf("didn't load root CAs")
+	}
+
+	tlsConfig.CAPath = "../test/snapshot"
+	if _, err := SetupTLSConfig(tlsConfig); err == nil {
+		t.Fatalf("expected an error for a path without certs")
+	}
 }
 
 func TestSetQueryOptions(t *testing.T) {
@@ -229,6 +251,7 @@ func TestSetQueryOptions(t *testing.T) {
 		WaitTime:          100 * time.Second,
 		Token:             "12345",
 		Near:              "nodex",
+		Namespace:         "team-a",
 	}
 	r.setQueryOptions(q)
 
@@ -253,6 +276,9 @@ func TestSetQueryOptions(t *testing.T) {
 	if r.params.Get("near") != "nodex" {
 		t.Fatalf("bad: %v", r.params)
 	}
+	if r.params.Get("ns") != "team-a" {
+		t.Fatalf("bad: %v", r.params)
+	}
 }
 
 func TestSetWriteOptions(t *testing.T) {
@@ -263,6 +289,7 @@ func TestSetWriteOptions(t *testing.T) {
 	r := c.newRequest("GET", "/v1/kv/foo")
 	q := &WriteOptions{
 		Datacenter: "foo",
+		Namespace:  "team-a",
 		Token:      "23456",
 	}
 	r.setWriteOptions(q)
@@ -270,6 +297,9 @@ func TestSetWriteOptions(t *testing.T) {
 	if r.params.Get("dc") != "foo" {
 		t.Fatalf("bad: %v", r.params)
 	}
+	if r.params.Get("ns") != "team-a" {
+		t.Fatalf("bad: %v", r.params)
+	}
 	if r.header.Get("X-Consul-Token") != "23456" {
 		t.Fatalf("bad: %v", r.header)
 	}
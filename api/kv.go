@@ -174,8 +174,10 @@ func (k *KV) getInternal(key string, params map[string]string, q *QueryOptions)
 		resp.Body.Close()
 		return nil, qm, nil
 	} else if resp.StatusCode != 200 {
+		var buf bytes.Buffer
+		io.Copy(&buf, resp.Body)
 		resp.Body.Close()
-		return nil, nil, fmt.Errorf("Unexpected response code: %d", resp.StatusCode)
+		return nil, nil, fmt.Errorf("Unexpected response code: %d (%s)", resp.StatusCode, buf.Bytes())
 	}
 	return resp, qm, nil
 }
@@ -0,0 +1,225 @@
+package command
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+)
+
+// ACLCommand is a Command implementation that just shows help for the
+// subcommands nested below it.
+type ACLCommand struct {
+	Ui cli.Ui
+}
+
+func (c *ACLCommand) Run(args []string) int {
+	return cli.RunResultHelp
+}
+
+func (c *ACLCommand) Help() string {
+	helpText := `
+Usage: consul acl <subcommand> [options] [args]
+
+  This command has subcommands for managing the ACL tokens which control
+  access to Consul. Most of them need a management token, given with -token
+  or the CONSUL_HTTP_TOKEN environment variable.
+
+  List the tokens, with their secrets hidden:
+
+      $ consul acl token list
+
+  Create a client token with the rules in a file, printing its SecretID:
+
+      $ consul acl token create -description=web -rules-file=web.hcl
+
+  For more examples, ask for subcommand help or view the documentation.
+
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *ACLCommand) Synopsis() string {
+	return "Manages ACL tokens"
+}
+
+// ACLTokenCommand is a Command implementation that just shows help for the
+// subcommands nested below it.
+type ACLTokenCommand struct {
+	Ui cli.Ui
+}
+
+func (c *ACLTokenCommand) Run(args []string) int {
+	return cli.RunResultHelp
+}
+
+func (c *ACLTokenCommand) Help() string {
+	helpText := `
+Usage: consul acl token <subcommand> [options] [args]
+
+  This command has subcommands for creating, reading, updating, and deleting
+  ACL tokens. A token's SecretID is what's passed as -token to use it, so it's
+  only shown when the token is created, or with -show-secrets.
+
+  Commands which take the SecretID of a token also accept a unique prefix of
+  it, such as the one shown by "consul acl token list".
+
+  List the tokens:
+
+      $ consul acl token list
+
+  Change the rules of a token, reading them from stdin:
+
+      $ consul acl token update -rules-file=- 3f4a9c2e < web.hcl
+
+  Delete a token:
+
+      $ consul acl token delete 3f4a9c2e
+
+  For more examples, ask for subcommand help or view the documentation.
+
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *ACLTokenCommand) Synopsis() string {
+	return "Manages ACL tokens"
+}
+
+// aclAnonymousID is the ID of the token used by requests without one, which
+// isn't a secret.
+const aclAnonymousID = "anonymous"
+
+// aclTokenDenial describes a request which needs a management token, as all
+// the ACL endpoints apart from reading a single token do.
+func aclTokenDenial(op string) aclDenial {
+	return aclDenial{
+		op:   op,
+		need: "ACL tokens can only be listed and changed with a management token.",
+	}
+}
+
+// aclTokenArg returns the SecretID or prefix from the arguments, reporting
+// an error if there isn't exactly one.
+func aclTokenArg(ui cli.Ui, args []string) (string, bool) {
+	switch len(args) {
+	case 0:
+		ui.Error("Error! Missing SECRET_ID argument")
+		return "", false
+	case 1:
+	default:
+		ui.Error(fmt.Sprintf("Too many arguments (expected 1, got %d)", len(args)))
+		return "", false
+	}
+	if args[0] == "" {
+		ui.Error("Error! The SecretID can't be empty")
+		return "", false
+	}
+	return args[0], true
+}
+
+// aclFindToken looks up the token with the given SecretID. If there's no such
+// token, the ID is taken as a prefix, such as one shown by a list without
+// -show-secrets, which must match exactly one token. Finding a token by prefix
+// needs a management token, since all the tokens are listed. It returns nil
+// if no token matches.
+func aclFindToken(client *api.Client, id string, q *api.QueryOptions) (*api.ACLEntry, error) {
+	token, _, err := client.ACL().Info(id, q)
+	if err != nil || token != nil {
+		return token, err
+	}
+
+	tokens, _, err := client.ACL().List(q)
+	if err != nil {
+		return nil, err
+	}
+	var matches []*api.ACLEntry
+	for _, t := range tokens {
+		if strings.HasPrefix(t.ID, id) {
+			matches = append(matches, t)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, nil
+	case 1:
+		return matches[0], nil
+	default:
+		return nil, fmt.Errorf("%d tokens have SecretIDs starting with %q, give more of it", len(matches), id)
+	}
+}
+
+// aclMaskSecret hides the SecretID of a token for listing. Long enough IDs,
+// such as generated UUIDs, keep their first 8 characters, which is enough to
+// pick the token out with a prefix while leaving it useless as a secret.
+// Shorter ones are hidden completely, apart from the anonymous token's.
+func aclMaskSecret(id string) string {
+	switch {
+	case id == aclAnonymousID:
+		return id
+	case len(id) >= 32:
+		return id[:8] + "..."
+	default:
+		return "<hidden>"
+	}
+}
+
+// aclReadRules reads the rules for a token from a file, or from stdin for
+// "-".
+func aclReadRules(path string) (string, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return "", fmt.Errorf("Failed to read rules: %s", err)
+	}
+	return string(data), nil
+}
+
+// aclTokenOutput is a token printed with -format=json. The fields are named
+// for what they hold, rather than as in the API, where the SecretID is the
+// token's ID and its description is its name.
+type aclTokenOutput struct {
+	SecretID    string
+	Description string
+	Type        string
+	Rules       string
+	CreateIndex uint64
+	ModifyIndex uint64
+}
+
+// newACLTokenOutput returns the JSON form of a token, with its SecretID
+// masked unless showSecret is set.
+func newACLTokenOutput(token *api.ACLEntry, showSecret bool) *aclTokenOutput {
+	id := token.ID
+	if !showSecret {
+		id = aclMaskSecret(id)
+	}
+	return &aclTokenOutput{
+		SecretID:    id,
+		Description: token.Name,
+		Type:        token.Type,
+		Rules:       token.Rules,
+		CreateIndex: token.CreateIndex,
+		ModifyIndex: token.ModifyIndex,
+	}
+}
+
+// aclTokensByDescription sorts tokens by description, and then by ID.
+type aclTokensByDescription []*api.ACLEntry
+
+func (s aclTokensByDescription) Len() int      { return len(s) }
+func (s aclTokensByDescription) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s aclTokensByDescription) Less(i, j int) bool {
+	if s[i].Name != s[j].Name {
+		return s[i].Name < s[j].Name
+	}
+	return s[i].ID < s[j].ID
+}
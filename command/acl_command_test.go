@@ -0,0 +1,127 @@
+package command
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/command/agent"
+	"github.com/mitchellh/cli"
+)
+
+func TestACLCommand_implements(t *testing.T) {
+	var _ cli.Command = &ACLCommand{}
+	var _ cli.Command = &ACLTokenCommand{}
+}
+
+func TestACLCommand_noTabs(t *testing.T) {
+	assertNoTabs(t, new(ACLCommand))
+	assertNoTabs(t, new(ACLTokenCommand))
+}
+
+// testACLAgent starts an agent with ACLs enabled for the ACL tests, returning
+// a client using the "root" master token.
+func testACLAgent(t *testing.T) (*agentWrapper, *api.Client) {
+	srv := testAgentWithConfig(t, func(c *agent.Config) {
+		c.ACLDatacenter = "dc1"
+		c.ACLDefaultPolicy = "deny"
+		c.ACLMasterToken = "root"
+	})
+	waitForLeader(t, srv.httpAddr)
+
+	client, err := api.NewClient(&api.Config{Address: srv.httpAddr, Token: "root"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return srv, client
+}
+
+// testACLTokenCreate creates a token for the ACL tests, returning its ID.
+func testACLTokenCreate(t *testing.T, client *api.Client, entry *api.ACLEntry) string {
+	id, _, err := client.ACL().Create(entry, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return id
+}
+
+func TestACLFindToken(t *testing.T) {
+	srv, client := testACLAgent(t)
+	defer srv.Shutdown()
+
+	for _, id := range []string{
+		"3f4a9c2e-0000-4000-8000-000000000001",
+		"3f4a9c2e-0000-4000-8000-000000000002",
+		"7b1d0e55-0000-4000-8000-000000000003",
+	} {
+		testACLTokenCreate(t, client, &api.ACLEntry{ID: id, Type: api.ACLClientType})
+	}
+
+	cases := map[string]struct {
+		id       string
+		expected string
+		err      string
+	}{
+		"whole ID":  {"3f4a9c2e-0000-4000-8000-000000000002", "3f4a9c2e-0000-4000-8000-000000000002", ""},
+		"prefix":    {"7b1d", "7b1d0e55-0000-4000-8000-000000000003", ""},
+		"anonymous": {"anonymous", "anonymous", ""},
+		"no match":  {"9999", "", ""},
+		"several":   {"3f4a9c2e", "", `2 tokens have SecretIDs starting with "3f4a9c2e", give more of it`},
+	}
+
+	for name, tc := range cases {
+		token, err := aclFindToken(client, tc.id, nil)
+		if tc.err != "" {
+			if err == nil || err.Error() != tc.err {
+				t.Fatalf("%s: bad: %v", name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: err: %v", name, err)
+		}
+		if tc.expected == "" {
+			if token != nil {
+				t.Fatalf("%s: bad: %#v", name, token)
+			}
+			continue
+		}
+		if token == nil || token.ID != tc.expected {
+			t.Fatalf("%s: bad: %#v", name, token)
+		}
+	}
+}
+
+func TestACLMaskSecret(t *testing.T) {
+	cases := map[string]string{
+		"3f4a9c2e-0000-4000-8000-000000000001": "3f4a9c2e...",
+		"anonymous":                            "anonymous",
+		"root":                                 "<hidden>",
+		"":                                     "<hidden>",
+	}
+	for id, expected := range cases {
+		if actual := aclMaskSecret(id); actual != expected {
+			t.Fatalf("%q: expected %q, got %q", id, expected, actual)
+		}
+	}
+}
+
+func TestACLTokenArg(t *testing.T) {
+	cases := map[string]struct {
+		args   []string
+		output string
+	}{
+		"missing":  {nil, "Missing SECRET_ID argument"},
+		"empty":    {[]string{""}, "The SecretID can't be empty"},
+		"too many": {[]string{"a", "b"}, "Too many arguments (expected 1, got 2)"},
+	}
+	for name, tc := range cases {
+		ui := new(cli.MockUi)
+		if _, ok := aclTokenArg(ui, tc.args); ok {
+			t.Fatalf("%s: expected an error", name)
+		}
+		if output := ui.ErrorWriter.String(); !strings.Contains(output, tc.output) {
+			t.Fatalf("%s: expected %q to contain %q", name, output, tc.output)
+		}
+	}
+}
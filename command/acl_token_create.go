@@ -0,0 +1,147 @@
+package command
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+)
+
+// ACLTokenCreateCommand is a Command implementation that is used to create an
+// ACL token.
+type ACLTokenCreateCommand struct {
+	Ui cli.Ui
+}
+
+func (c *ACLTokenCreateCommand) Synopsis() string {
+	return "Creates a new ACL token"
+}
+
+func (c *ACLTokenCreateCommand) Help() string {
+	helpText := `
+Usage: consul acl token create [options]
+
+  Creates an ACL token and prints its SecretID, so that it can be captured by
+  a script. This needs a management token.
+
+      $ consul acl token create -description=web -rules-file=web.hcl
+
+  The rules can also be read from stdin:
+
+      $ consul acl token create -description=web -rules-file=- < web.hcl
+
+  The SecretID is what's passed as -token to use the new token, so it should
+  be kept safe. It's only shown again by "consul acl token read" and "consul
+  acl token list" with -show-secrets.
+
+  For a full list of options and examples, please see the Consul documentation.
+
+` + apiOptsText + `
+
+ACL Token Create Options:
+
+  -description=<string>   Description of the token, which is shown when
+                          listing the tokens.
+
+  -format=<string>        Output format, either "text" or "json". With "json",
+                          the new token is printed as an object, including its
+                          SecretID. The default value is "text".
+
+  -rules-file=<path>      Path to a file holding the ACL rules of the token,
+                          in HCL or JSON. Use "-" to read them from stdin.
+                          Without this, the token has no rules, so it can
+                          only do what the default policy allows.
+
+  -type=<string>          Type of the token, either "client", which is limited
+                          by its rules, or "management", which can do
+                          anything, including managing ACLs. The default
+                          value is "client".
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *ACLTokenCreateCommand) Run(args []string) (code int) {
+	cmdFlags, apiFlags := NewAPIFlagSet("create", c.Ui)
+	defer apiFlags.CheckTimeout(c.Ui, &code)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	description := cmdFlags.String("description", "", "")
+	format := cmdFlags.String("format", "text", "")
+	rulesFile := cmdFlags.String("rules-file", "", "")
+	tokenType := cmdFlags.String("type", api.ACLClientType, "")
+	if err := parseFlags(c.Ui, cmdFlags, args); err != nil {
+		return 1
+	}
+
+	if args = cmdFlags.Args(); len(args) > 0 {
+		c.Ui.Error(fmt.Sprintf("Too many arguments (expected 0, got %d)", len(args)))
+		return 1
+	}
+	if *format != "text" && *format != "json" {
+		c.Ui.Error(fmt.Sprintf("Error! Unsupported format %q (expected text or json)", *format))
+		return 1
+	}
+	if err := checkACLTokenType(*tokenType, *rulesFile); err != nil {
+		c.Ui.Error(fmt.Sprintf("Error! %s", err))
+		return 1
+	}
+
+	entry := &api.ACLEntry{
+		Name: *description,
+		Type: *tokenType,
+	}
+	if *rulesFile != "" {
+		rules, err := aclReadRules(*rulesFile)
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Error! %s", err))
+			return 1
+		}
+		entry.Rules = rules
+	}
+
+	// Create and test the HTTP client
+	client, err := apiFlags.Client()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+		return 1
+	}
+
+	id, _, err := client.ACL().Create(entry, apiFlags.WriteOptions())
+	if err != nil {
+		c.Ui.Error(apiFlags.errorMessage("Error creating ACL token", err, aclTokenDenial("create an ACL token")))
+		return exitCommError
+	}
+
+	c.Ui.Warn("Warning! This is the only time the SecretID is shown, unless -show-secrets is given " +
+		"when reading or listing tokens. Anyone who has it can use the token, so keep it safe")
+	if *format == "json" {
+		// The token is read back for its indexes. The SecretID is the part
+		// that matters, so it's still printed if that fails.
+		token, _, err := client.ACL().Info(id, apiFlags.QueryOptions())
+		if err != nil || token == nil {
+			entry.ID = id
+			token = entry
+		}
+		return printJSON(c.Ui, newACLTokenOutput(token, true))
+	}
+	c.Ui.Output(id)
+	return 0
+}
+
+// checkACLTokenType checks the type given for a token. Management tokens can
+// do anything, so giving them rules is an error rather than silently having
+// no effect.
+func checkACLTokenType(tokenType, rulesFile string) error {
+	switch tokenType {
+	case api.ACLClientType:
+		return nil
+	case api.ACLManagementType:
+		if rulesFile != "" {
+			return fmt.Errorf("Cannot specify -rules-file with -type=management, since management " +
+				"tokens can do anything")
+		}
+		return nil
+	default:
+		return fmt.Errorf("Unsupported type %q (expected client or management)", tokenType)
+	}
+}
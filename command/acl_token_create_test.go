@@ -0,0 +1,130 @@
+package command
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+)
+
+func TestACLTokenCreateCommand_implements(t *testing.T) {
+	var _ cli.Command = &ACLTokenCreateCommand{}
+}
+
+func TestACLTokenCreateCommand_noTabs(t *testing.T) {
+	assertNoTabs(t, new(ACLTokenCreateCommand))
+}
+
+func TestACLTokenCreateCommand_Run(t *testing.T) {
+	srv, client := testACLAgent(t)
+	defer srv.Shutdown()
+
+	dir, err := ioutil.TempDir("", "acl")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	rules := `key "web/" { policy = "write" }`
+	rulesFile := filepath.Join(dir, "web.hcl")
+	if err := ioutil.WriteFile(rulesFile, []byte(rules), 0600); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	ui := new(cli.MockUi)
+	c := &ACLTokenCreateCommand{Ui: ui}
+	args := []string{"-http-addr=" + srv.httpAddr, "-token=root", "-description=web", "-rules-file=" + rulesFile}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if !strings.Contains(ui.ErrorWriter.String(), "Warning! This is the only time the SecretID is shown") {
+		t.Fatalf("bad: %#v", ui.ErrorWriter.String())
+	}
+
+	id := strings.TrimSpace(ui.OutputWriter.String())
+	token, _, err := client.ACL().Info(id, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if token == nil || token.Name != "web" || token.Type != api.ACLClientType || token.Rules != rules {
+		t.Fatalf("bad: %#v", token)
+	}
+
+	// With -format=json, the whole SecretID is printed along with the rest
+	// of the token.
+	ui = new(cli.MockUi)
+	c = &ACLTokenCreateCommand{Ui: ui}
+	args = []string{"-http-addr=" + srv.httpAddr, "-token=root", "-type=management", "-format=json"}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	var out aclTokenOutput
+	if err := json.Unmarshal(ui.OutputWriter.Bytes(), &out); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if out.Type != api.ACLManagementType || out.CreateIndex == 0 {
+		t.Fatalf("bad: %#v", out)
+	}
+	if token, _, err := client.ACL().Info(out.SecretID, nil); err != nil || token == nil {
+		t.Fatalf("bad: %#v %v", token, err)
+	}
+}
+
+func TestACLTokenCreateCommand_Validation(t *testing.T) {
+	cases := map[string]struct {
+		args   []string
+		output string
+	}{
+		"args": {
+			[]string{"foo"},
+			"Too many arguments (expected 0, got 1)",
+		},
+		"format": {
+			[]string{"-format=yaml"},
+			`Unsupported format "yaml" (expected text or json)`,
+		},
+		"type": {
+			[]string{"-type=admin"},
+			`Unsupported type "admin" (expected client or management)`,
+		},
+		"management rules": {
+			[]string{"-type=management", "-rules-file=web.hcl"},
+			"Cannot specify -rules-file with -type=management",
+		},
+		"rules file": {
+			[]string{"-rules-file=" + filepath.Join("does", "not", "exist.hcl")},
+			"Failed to read rules",
+		},
+	}
+
+	for name, tc := range cases {
+		ui := new(cli.MockUi)
+		c := &ACLTokenCreateCommand{Ui: ui}
+		if code := c.Run(tc.args); code != 1 {
+			t.Fatalf("%s: bad: %d", name, code)
+		}
+		if output := ui.ErrorWriter.String(); !strings.Contains(output, tc.output) {
+			t.Fatalf("%s: expected %q to contain %q", name, output, tc.output)
+		}
+	}
+}
+
+func TestACLTokenCreateCommand_PermissionDenied(t *testing.T) {
+	srv, _ := testACLAgent(t)
+	defer srv.Shutdown()
+
+	ui := new(cli.MockUi)
+	c := &ACLTokenCreateCommand{Ui: ui}
+	if code := c.Run([]string{"-http-addr=" + srv.httpAddr}); code != exitCommError {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	output := ui.ErrorWriter.String()
+	if !strings.Contains(output, "Permission denied to create an ACL token") ||
+		!strings.Contains(output, "management token") {
+		t.Fatalf("bad: %#v", output)
+	}
+}
@@ -0,0 +1,140 @@
+package command
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mattn/go-isatty"
+	"github.com/mitchellh/cli"
+)
+
+// ACLTokenDeleteCommand is a Command implementation that is used to delete an
+// ACL token.
+type ACLTokenDeleteCommand struct {
+	Ui cli.Ui
+
+	// testStdinTerminal overrides the check for whether stdin is a
+	// terminal, for testing.
+	testStdinTerminal *bool
+}
+
+func (c *ACLTokenDeleteCommand) Synopsis() string {
+	return "Deletes an ACL token"
+}
+
+func (c *ACLTokenDeleteCommand) Help() string {
+	helpText := `
+Usage: consul acl token delete [options] SECRET_ID
+
+  Deletes the ACL token with the given SecretID, or unique prefix of it, after
+  asking for confirmation, which can be skipped with the -force option. When
+  not running interactively, -force is required. This needs a management
+  token. If there's no such token, the command exits with status 2.
+
+      $ consul acl token delete 3f4a9c2e
+
+  The anonymous token, used by requests without a token, can't be deleted.
+
+  For a full list of options and examples, please see the Consul documentation.
+
+` + apiOptsText + `
+
+ACL Token Delete Options:
+
+  -force                  Delete the token without asking for confirmation.
+                          This is required when not running interactively.
+                          The default value is false.
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *ACLTokenDeleteCommand) Run(args []string) (code int) {
+	cmdFlags, apiFlags := NewAPIFlagSet("delete", c.Ui)
+	defer apiFlags.CheckTimeout(c.Ui, &code)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	force := cmdFlags.Bool("force", false, "")
+	if err := parseFlags(c.Ui, cmdFlags, args); err != nil {
+		return 1
+	}
+
+	id, ok := aclTokenArg(c.Ui, cmdFlags.Args())
+	if !ok {
+		return 1
+	}
+	if id == aclAnonymousID {
+		c.Ui.Error("Error! The anonymous token can't be deleted")
+		return 1
+	}
+
+	// Create and test the HTTP client
+	client, err := apiFlags.Client()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+		return 1
+	}
+
+	// Deleting a token which doesn't exist succeeds, so it's looked up first
+	// to report a mistyped SecretID, and to show what will be deleted.
+	token, err := aclFindToken(client, id, apiFlags.QueryOptions())
+	if err != nil {
+		c.Ui.Error(apiFlags.errorMessage("Error reading ACL token", err, aclTokenDenial("find the ACL token")))
+		return exitCommError
+	}
+	if token == nil {
+		c.Ui.Error(fmt.Sprintf("Error! No ACL token with SecretID %q", id))
+		return exitNotFound
+	}
+	if token.ID == aclAnonymousID {
+		c.Ui.Error("Error! The anonymous token can't be deleted")
+		return 1
+	}
+
+	if !*force && !c.confirm(token) {
+		return 1
+	}
+
+	if _, err := client.ACL().Destroy(token.ID, apiFlags.WriteOptions()); err != nil {
+		c.Ui.Error(apiFlags.errorMessage("Error deleting ACL token", err, aclTokenDenial("delete an ACL token")))
+		return exitCommError
+	}
+
+	apiFlags.report(c.Ui, fmt.Sprintf("Success! Deleted ACL token: %s", aclMaskSecret(token.ID)))
+	return 0
+}
+
+// confirm asks the user to approve deleting the token, reporting why not if
+// they don't.
+func (c *ACLTokenDeleteCommand) confirm(token *api.ACLEntry) bool {
+	if !c.stdinIsTerminal() {
+		c.Ui.Error("Error! Refusing to delete an ACL token without confirmation. " +
+			"Use -force to skip the prompt when not running interactively.")
+		return false
+	}
+
+	description := token.Name
+	if description == "" {
+		description = "no description"
+	}
+	query := fmt.Sprintf("Delete %s token %s (%s)? Only 'yes' will be accepted to approve.",
+		token.Type, aclMaskSecret(token.ID), description)
+	answer, err := c.Ui.Ask(query)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error reading confirmation: %s", err))
+		return false
+	}
+	if strings.TrimSpace(answer) != "yes" {
+		c.Ui.Error("Delete cancelled, the ACL token was not deleted")
+		return false
+	}
+	return true
+}
+
+// stdinIsTerminal returns true if the command can prompt the user for input.
+func (c *ACLTokenDeleteCommand) stdinIsTerminal() bool {
+	if c.testStdinTerminal != nil {
+		return *c.testStdinTerminal
+	}
+	return isatty.IsTerminal(os.Stdin.Fd())
+}
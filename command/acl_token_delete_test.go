@@ -0,0 +1,95 @@
+package command
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+)
+
+func TestACLTokenDeleteCommand_implements(t *testing.T) {
+	var _ cli.Command = &ACLTokenDeleteCommand{}
+}
+
+func TestACLTokenDeleteCommand_noTabs(t *testing.T) {
+	assertNoTabs(t, new(ACLTokenDeleteCommand))
+}
+
+func TestACLTokenDeleteCommand_confirm(t *testing.T) {
+	srv, client := testACLAgent(t)
+	defer srv.Shutdown()
+
+	id := testACLTokenCreate(t, client, &api.ACLEntry{Name: "web", Type: api.ACLClientType})
+	masked := id[:8] + "..."
+
+	terminal, notTerminal := true, false
+	cases := map[string]struct {
+		terminal *bool
+		input    string
+		code     int
+		output   string
+	}{
+		"not a terminal": {
+			&notTerminal,
+			"",
+			1,
+			"Refusing to delete an ACL token without confirmation",
+		},
+		"declined": {
+			&terminal,
+			"no\n",
+			1,
+			"Delete client token " + masked + " (web)?",
+		},
+		"confirmed": {
+			&terminal,
+			"yes\n",
+			0,
+			"Success! Deleted ACL token: " + masked,
+		},
+	}
+
+	for _, name := range []string{"not a terminal", "declined", "confirmed"} {
+		tc := cases[name]
+		ui := new(cli.MockUi)
+		ui.InputReader = strings.NewReader(tc.input)
+		c := &ACLTokenDeleteCommand{Ui: ui, testStdinTerminal: tc.terminal}
+
+		code := c.Run([]string{"-http-addr=" + srv.httpAddr, "-token=root", id[:8]})
+		if code != tc.code {
+			t.Fatalf("%s: bad: %d. %#v", name, code, ui.ErrorWriter.String())
+		}
+
+		output := ui.OutputWriter.String() + ui.ErrorWriter.String()
+		if !strings.Contains(output, tc.output) {
+			t.Fatalf("%s: expected %q to contain %q", name, output, tc.output)
+		}
+
+		token, _, err := client.ACL().Info(id, nil)
+		if err != nil {
+			t.Fatalf("%s: err: %v", name, err)
+		}
+		if deleted := token == nil; deleted != (tc.code == 0) {
+			t.Fatalf("%s: bad: %#v", name, token)
+		}
+	}
+
+	// Once it's gone, the token isn't found.
+	ui := new(cli.MockUi)
+	c := &ACLTokenDeleteCommand{Ui: ui}
+	if code := c.Run([]string{"-http-addr=" + srv.httpAddr, "-token=root", "-force", id}); code != exitNotFound {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+}
+
+func TestACLTokenDeleteCommand_anonymous(t *testing.T) {
+	ui := new(cli.MockUi)
+	c := &ACLTokenDeleteCommand{Ui: ui}
+	if code := c.Run([]string{"-force", "anonymous"}); code != 1 {
+		t.Fatalf("bad: %d", code)
+	}
+	if output := ui.ErrorWriter.String(); !strings.Contains(output, "The anonymous token can't be deleted") {
+		t.Fatalf("bad: %#v", output)
+	}
+}
@@ -0,0 +1,101 @@
+package command
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mitchellh/cli"
+	"github.com/ryanuber/columnize"
+)
+
+// ACLTokenListCommand is a Command implementation that is used to list the
+// ACL tokens.
+type ACLTokenListCommand struct {
+	Ui cli.Ui
+}
+
+func (c *ACLTokenListCommand) Synopsis() string {
+	return "Lists the ACL tokens"
+}
+
+func (c *ACLTokenListCommand) Help() string {
+	helpText := `
+Usage: consul acl token list [options]
+
+  Lists the ACL tokens as a table, sorted by description, with the type of
+  each token. This needs a management token.
+
+      $ consul acl token list
+
+  The SecretIDs are cut down to their first 8 characters, which is enough to
+  give them to the other commands, unless -show-secrets is given.
+
+  For a full list of options and examples, please see the Consul documentation.
+
+` + apiOptsText + `
+
+ACL Token List Options:
+
+  -format=<string>        Output format, either "text" or "json". With "json",
+                          the tokens are printed as an array of objects,
+                          including their rules. The default value is "text".
+
+  -show-secrets           Show the whole SecretID of each token. The default
+                          value is false.
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *ACLTokenListCommand) Run(args []string) (code int) {
+	cmdFlags, apiFlags := NewAPIFlagSet("list", c.Ui)
+	defer apiFlags.CheckTimeout(c.Ui, &code)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	format := cmdFlags.String("format", "text", "")
+	showSecrets := cmdFlags.Bool("show-secrets", false, "")
+	if err := parseFlags(c.Ui, cmdFlags, args); err != nil {
+		return 1
+	}
+
+	if args = cmdFlags.Args(); len(args) > 0 {
+		c.Ui.Error(fmt.Sprintf("Too many arguments (expected 0, got %d)", len(args)))
+		return 1
+	}
+	if *format != "text" && *format != "json" {
+		c.Ui.Error(fmt.Sprintf("Error! Unsupported format %q (expected text or json)", *format))
+		return 1
+	}
+
+	// Create and test the HTTP client
+	client, err := apiFlags.Client()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+		return 1
+	}
+
+	tokens, _, err := client.ACL().List(apiFlags.QueryOptions())
+	if err != nil {
+		c.Ui.Error(apiFlags.errorMessage("Error listing ACL tokens", err, aclTokenDenial("list ACL tokens")))
+		return exitCommError
+	}
+	sort.Sort(aclTokensByDescription(tokens))
+
+	if *format == "json" {
+		out := make([]*aclTokenOutput, len(tokens))
+		for i, token := range tokens {
+			out[i] = newACLTokenOutput(token, *showSecrets)
+		}
+		return printJSON(c.Ui, out)
+	}
+
+	result := []string{"SecretID|Description|Type"}
+	for _, token := range tokens {
+		id := token.ID
+		if !*showSecrets {
+			id = aclMaskSecret(id)
+		}
+		result = append(result, fmt.Sprintf("%s|%s|%s", id, token.Name, token.Type))
+	}
+	c.Ui.Output(columnize.SimpleFormat(result))
+	return 0
+}
@@ -0,0 +1,75 @@
+package command
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+)
+
+func TestACLTokenListCommand_implements(t *testing.T) {
+	var _ cli.Command = &ACLTokenListCommand{}
+}
+
+func TestACLTokenListCommand_noTabs(t *testing.T) {
+	assertNoTabs(t, new(ACLTokenListCommand))
+}
+
+func TestACLTokenListCommand_Run(t *testing.T) {
+	srv, client := testACLAgent(t)
+	defer srv.Shutdown()
+
+	web := testACLTokenCreate(t, client, &api.ACLEntry{Name: "web", Type: api.ACLClientType})
+	db := testACLTokenCreate(t, client, &api.ACLEntry{Name: "db", Type: api.ACLClientType})
+
+	ui := new(cli.MockUi)
+	c := &ACLTokenListCommand{Ui: ui}
+	if code := c.Run([]string{"-http-addr=" + srv.httpAddr, "-token=root"}); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	output := ui.OutputWriter.String()
+	if strings.Contains(output, web) || strings.Contains(output, db) || strings.Contains(output, "root") {
+		t.Fatalf("bad: secrets shown: %#v", output)
+	}
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) != 5 || !strings.HasPrefix(lines[0], "SecretID") {
+		t.Fatalf("bad: %#v", output)
+	}
+	// Sorted by description, after the built in anonymous and master tokens.
+	for i, prefix := range []string{"anonymous", "<hidden>", db[:8] + "...", web[:8] + "..."} {
+		if !strings.HasPrefix(lines[i+1], prefix) {
+			t.Fatalf("bad: line %d: %#v", i+1, lines[i+1])
+		}
+	}
+
+	// With -show-secrets and -format=json, the whole SecretIDs are shown.
+	ui = new(cli.MockUi)
+	c = &ACLTokenListCommand{Ui: ui}
+	args := []string{"-http-addr=" + srv.httpAddr, "-token=root", "-show-secrets", "-format=json"}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	var out []aclTokenOutput
+	if err := json.Unmarshal(ui.OutputWriter.Bytes(), &out); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(out) != 4 || out[2].SecretID != db || out[3].SecretID != web {
+		t.Fatalf("bad: %#v", out)
+	}
+}
+
+func TestACLTokenListCommand_PermissionDenied(t *testing.T) {
+	srv, _ := testACLAgent(t)
+	defer srv.Shutdown()
+
+	ui := new(cli.MockUi)
+	c := &ACLTokenListCommand{Ui: ui}
+	if code := c.Run([]string{"-http-addr=" + srv.httpAddr}); code != exitCommError {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if output := ui.ErrorWriter.String(); !strings.Contains(output, "Permission denied to list ACL tokens") {
+		t.Fatalf("bad: %#v", output)
+	}
+}
@@ -0,0 +1,131 @@
+package command
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+)
+
+// ACLTokenReadCommand is a Command implementation that is used to show the
+// details of an ACL token.
+type ACLTokenReadCommand struct {
+	Ui cli.Ui
+}
+
+func (c *ACLTokenReadCommand) Synopsis() string {
+	return "Shows the details of an ACL token"
+}
+
+func (c *ACLTokenReadCommand) Help() string {
+	helpText := `
+Usage: consul acl token read [options] SECRET_ID
+
+  Shows the details and rules of the ACL token with the given SecretID, or
+  unique prefix of it. If there's no such token, the command exits with
+  status 2.
+
+      $ consul acl token read 3f4a9c2e
+
+  Finding a token by a prefix of its SecretID needs a management token. The
+  SecretID is cut down to its first 8 characters unless -show-secrets is
+  given.
+
+  For a full list of options and examples, please see the Consul documentation.
+
+` + apiOptsText + `
+
+ACL Token Read Options:
+
+  -format=<string>        Output format, either "text" or "json". With "json",
+                          the token is printed as an object. The default
+                          value is "text".
+
+  -show-secrets           Show the whole SecretID of the token. The default
+                          value is false.
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *ACLTokenReadCommand) Run(args []string) (code int) {
+	cmdFlags, apiFlags := NewAPIFlagSet("read", c.Ui)
+	defer apiFlags.CheckTimeout(c.Ui, &code)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	format := cmdFlags.String("format", "text", "")
+	showSecrets := cmdFlags.Bool("show-secrets", false, "")
+	if err := parseFlags(c.Ui, cmdFlags, args); err != nil {
+		return 1
+	}
+
+	id, ok := aclTokenArg(c.Ui, cmdFlags.Args())
+	if !ok {
+		return 1
+	}
+	if *format != "text" && *format != "json" {
+		c.Ui.Error(fmt.Sprintf("Error! Unsupported format %q (expected text or json)", *format))
+		return 1
+	}
+
+	// Create and test the HTTP client
+	client, err := apiFlags.Client()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+		return 1
+	}
+
+	token, err := aclFindToken(client, id, apiFlags.QueryOptions())
+	if err != nil {
+		c.Ui.Error(apiFlags.errorMessage("Error reading ACL token", err, aclTokenDenial("find the ACL token")))
+		return exitCommError
+	}
+	if token == nil {
+		c.Ui.Error(fmt.Sprintf("Error! No ACL token with SecretID %q", id))
+		return exitNotFound
+	}
+
+	out := newACLTokenOutput(token, *showSecrets)
+	if *format == "json" {
+		return printJSON(c.Ui, out)
+	}
+	text, err := formatACLToken(out)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error rendering ACL token: %s", err))
+		return 1
+	}
+	c.Ui.Output(text)
+	return 0
+}
+
+// formatACLToken formats a token as a table of its fields, followed by its
+// rules. Management tokens can do anything, so they have no rules to show.
+func formatACLToken(token *aclTokenOutput) (string, error) {
+	var b bytes.Buffer
+	tw := tabwriter.NewWriter(&b, 0, 2, 6, ' ', 0)
+	fmt.Fprintf(tw, "SecretID\t%s\n", token.SecretID)
+	if token.Description == "" {
+		fmt.Fprint(tw, "Description\t-\n")
+	} else {
+		fmt.Fprintf(tw, "Description\t%s\n", token.Description)
+	}
+	fmt.Fprintf(tw, "Type\t%s\n", token.Type)
+	fmt.Fprintf(tw, "CreateIndex\t%d\n", token.CreateIndex)
+	fmt.Fprintf(tw, "ModifyIndex\t%d", token.ModifyIndex)
+	if err := tw.Flush(); err != nil {
+		return "", err
+	}
+
+	switch {
+	case token.Type == api.ACLManagementType:
+	case strings.TrimSpace(token.Rules) == "":
+		b.WriteString("\n\nRules:\n  (none)")
+	default:
+		b.WriteString("\n\nRules:")
+		for _, line := range strings.Split(strings.TrimRight(token.Rules, "\n"), "\n") {
+			b.WriteString("\n  " + line)
+		}
+	}
+	return b.String(), nil
+}
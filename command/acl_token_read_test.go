@@ -0,0 +1,113 @@
+package command
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+)
+
+func TestACLTokenReadCommand_implements(t *testing.T) {
+	var _ cli.Command = &ACLTokenReadCommand{}
+}
+
+func TestACLTokenReadCommand_noTabs(t *testing.T) {
+	assertNoTabs(t, new(ACLTokenReadCommand))
+}
+
+func TestACLTokenReadCommand_Run(t *testing.T) {
+	srv, client := testACLAgent(t)
+	defer srv.Shutdown()
+
+	id := testACLTokenCreate(t, client, &api.ACLEntry{
+		Name:  "web",
+		Type:  api.ACLClientType,
+		Rules: "key \"web/\" {\n  policy = \"write\"\n}\n",
+	})
+	masked := id[:8] + "..."
+
+	cases := map[string]struct {
+		args     []string
+		expected []string
+		hidden   string
+	}{
+		"masked": {
+			[]string{id},
+			[]string{"SecretID", masked, "Description", "web", "Type", "client",
+				"Rules:\n  key \"web/\" {\n    policy = \"write\"\n  }"},
+			id,
+		},
+		"prefix": {
+			[]string{id[:8]},
+			[]string{masked},
+			id,
+		},
+		"show secrets": {
+			[]string{"-show-secrets", id[:8]},
+			[]string{id},
+			"",
+		},
+		"management": {
+			[]string{"root"},
+			[]string{"<hidden>", "management"},
+			"Rules:",
+		},
+	}
+
+	for name, tc := range cases {
+		ui := new(cli.MockUi)
+		c := &ACLTokenReadCommand{Ui: ui}
+		args := append([]string{"-http-addr=" + srv.httpAddr, "-token=root"}, tc.args...)
+		if code := c.Run(args); code != 0 {
+			t.Fatalf("%s: bad: %d. %#v", name, code, ui.ErrorWriter.String())
+		}
+		output := ui.OutputWriter.String()
+		for _, expected := range tc.expected {
+			if !strings.Contains(output, expected) {
+				t.Fatalf("%s: expected %q to contain %q", name, output, expected)
+			}
+		}
+		if tc.hidden != "" && strings.Contains(output, tc.hidden) {
+			t.Fatalf("%s: expected %q not to contain %q", name, output, tc.hidden)
+		}
+	}
+}
+
+func TestACLTokenReadCommand_JSON(t *testing.T) {
+	srv, client := testACLAgent(t)
+	defer srv.Shutdown()
+
+	id := testACLTokenCreate(t, client, &api.ACLEntry{Name: "web", Type: api.ACLClientType})
+
+	ui := new(cli.MockUi)
+	c := &ACLTokenReadCommand{Ui: ui}
+	args := []string{"-http-addr=" + srv.httpAddr, "-token=root", "-format=json", id}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	var out aclTokenOutput
+	if err := json.Unmarshal(ui.OutputWriter.Bytes(), &out); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if out.SecretID != id[:8]+"..." || out.Description != "web" || out.Type != api.ACLClientType ||
+		out.CreateIndex == 0 {
+		t.Fatalf("bad: %#v", out)
+	}
+}
+
+func TestACLTokenReadCommand_notFound(t *testing.T) {
+	srv, _ := testACLAgent(t)
+	defer srv.Shutdown()
+
+	ui := new(cli.MockUi)
+	c := &ACLTokenReadCommand{Ui: ui}
+	args := []string{"-http-addr=" + srv.httpAddr, "-token=root", "9999"}
+	if code := c.Run(args); code != exitNotFound {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if output := ui.ErrorWriter.String(); !strings.Contains(output, `No ACL token with SecretID "9999"`) {
+		t.Fatalf("bad: %#v", output)
+	}
+}
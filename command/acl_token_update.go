@@ -0,0 +1,152 @@
+package command
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+)
+
+// ACLTokenUpdateCommand is a Command implementation that is used to change an
+// ACL token.
+type ACLTokenUpdateCommand struct {
+	Ui cli.Ui
+}
+
+func (c *ACLTokenUpdateCommand) Synopsis() string {
+	return "Changes an ACL token"
+}
+
+func (c *ACLTokenUpdateCommand) Help() string {
+	helpText := `
+Usage: consul acl token update [options] SECRET_ID
+
+  Changes the description, rules, or type of the ACL token with the given
+  SecretID, or unique prefix of it. Only what's given is changed, and the
+  SecretID stays the same. This needs a management token. If there's no such
+  token, the command exits with status 2.
+
+      $ consul acl token update -rules-file=web.hcl 3f4a9c2e
+
+  For a full list of options and examples, please see the Consul documentation.
+
+` + apiOptsText + `
+
+ACL Token Update Options:
+
+  -description=<string>   New description of the token. An empty value
+                          removes the description.
+
+  -format=<string>        Output format, either "text" or "json". With "json",
+                          the updated token is printed as an object, with its
+                          SecretID cut down to its first 8 characters. The
+                          default value is "text".
+
+  -rules-file=<path>      Path to a file holding the new ACL rules of the
+                          token, in HCL or JSON, which replace all of its
+                          current rules. Use "-" to read them from stdin.
+
+  -type=<string>          New type of the token, either "client" or
+                          "management". A client token made into a management
+                          token loses its rules.
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *ACLTokenUpdateCommand) Run(args []string) (code int) {
+	cmdFlags, apiFlags := NewAPIFlagSet("update", c.Ui)
+	defer apiFlags.CheckTimeout(c.Ui, &code)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	description := cmdFlags.String("description", "", "")
+	format := cmdFlags.String("format", "text", "")
+	rulesFile := cmdFlags.String("rules-file", "", "")
+	tokenType := cmdFlags.String("type", "", "")
+	if err := parseFlags(c.Ui, cmdFlags, args); err != nil {
+		return 1
+	}
+
+	id, ok := aclTokenArg(c.Ui, cmdFlags.Args())
+	if !ok {
+		return 1
+	}
+	if *format != "text" && *format != "json" {
+		c.Ui.Error(fmt.Sprintf("Error! Unsupported format %q (expected text or json)", *format))
+		return 1
+	}
+
+	// An empty description is a change, so what was given is told apart
+	// from the defaults by visiting the flags which were set.
+	set := make(map[string]bool)
+	cmdFlags.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if !set["description"] && !set["rules-file"] && !set["type"] {
+		c.Ui.Error("Error! Nothing to update. Give -description, -rules-file, or -type")
+		return 1
+	}
+	if set["type"] {
+		if err := checkACLTokenType(*tokenType, *rulesFile); err != nil {
+			c.Ui.Error(fmt.Sprintf("Error! %s", err))
+			return 1
+		}
+	}
+
+	var rules string
+	if set["rules-file"] {
+		var err error
+		if rules, err = aclReadRules(*rulesFile); err != nil {
+			c.Ui.Error(fmt.Sprintf("Error! %s", err))
+			return 1
+		}
+	}
+
+	// Create and test the HTTP client
+	client, err := apiFlags.Client()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+		return 1
+	}
+
+	// An update replaces the whole token, so the current one is read first
+	// to keep what isn't being changed.
+	token, err := aclFindToken(client, id, apiFlags.QueryOptions())
+	if err != nil {
+		c.Ui.Error(apiFlags.errorMessage("Error reading ACL token", err, aclTokenDenial("find the ACL token")))
+		return exitCommError
+	}
+	if token == nil {
+		c.Ui.Error(fmt.Sprintf("Error! No ACL token with SecretID %q", id))
+		return exitNotFound
+	}
+
+	if set["description"] {
+		token.Name = *description
+	}
+	if set["type"] {
+		token.Type = *tokenType
+	}
+	if set["rules-file"] {
+		if token.Type == api.ACLManagementType {
+			c.Ui.Error("Error! Cannot specify -rules-file for a management token, since it can do anything")
+			return 1
+		}
+		token.Rules = rules
+	}
+	if token.Type == api.ACLManagementType {
+		token.Rules = ""
+	}
+
+	if _, err := client.ACL().Update(token, apiFlags.WriteOptions()); err != nil {
+		c.Ui.Error(apiFlags.errorMessage("Error updating ACL token", err, aclTokenDenial("update an ACL token")))
+		return exitCommError
+	}
+
+	if *format == "json" {
+		if updated, _, err := client.ACL().Info(token.ID, apiFlags.QueryOptions()); err == nil && updated != nil {
+			token = updated
+		}
+		return printJSON(c.Ui, newACLTokenOutput(token, false))
+	}
+	apiFlags.report(c.Ui, fmt.Sprintf("Success! Updated ACL token: %s", aclMaskSecret(token.ID)))
+	return 0
+}
@@ -0,0 +1,131 @@
+package command
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+)
+
+func TestACLTokenUpdateCommand_implements(t *testing.T) {
+	var _ cli.Command = &ACLTokenUpdateCommand{}
+}
+
+func TestACLTokenUpdateCommand_noTabs(t *testing.T) {
+	assertNoTabs(t, new(ACLTokenUpdateCommand))
+}
+
+func TestACLTokenUpdateCommand_Run(t *testing.T) {
+	srv, client := testACLAgent(t)
+	defer srv.Shutdown()
+
+	dir, err := ioutil.TempDir("", "acl")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	rules := `key "db/" { policy = "read" }`
+	rulesFile := filepath.Join(dir, "db.hcl")
+	if err := ioutil.WriteFile(rulesFile, []byte(rules), 0600); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	id := testACLTokenCreate(t, client, &api.ACLEntry{
+		Name:  "web",
+		Type:  api.ACLClientType,
+		Rules: `key "web/" { policy = "write" }`,
+	})
+
+	cases := []struct {
+		args     []string
+		expected api.ACLEntry
+	}{
+		{
+			[]string{"-rules-file=" + rulesFile},
+			api.ACLEntry{Name: "web", Type: api.ACLClientType, Rules: rules},
+		},
+		{
+			[]string{"-description="},
+			api.ACLEntry{Name: "", Type: api.ACLClientType, Rules: rules},
+		},
+		{
+			[]string{"-description=admin", "-type=management"},
+			api.ACLEntry{Name: "admin", Type: api.ACLManagementType},
+		},
+	}
+
+	for i, tc := range cases {
+		ui := new(cli.MockUi)
+		c := &ACLTokenUpdateCommand{Ui: ui}
+		args := append([]string{"-http-addr=" + srv.httpAddr, "-token=root"}, tc.args...)
+		args = append(args, id[:8])
+		if code := c.Run(args); code != 0 {
+			t.Fatalf("%d: bad: %d. %#v", i, code, ui.ErrorWriter.String())
+		}
+		if output := ui.OutputWriter.String(); output != "Success! Updated ACL token: "+id[:8]+"...\n" {
+			t.Fatalf("%d: bad: %#v", i, output)
+		}
+
+		token, _, err := client.ACL().Info(id, nil)
+		if err != nil {
+			t.Fatalf("%d: err: %v", i, err)
+		}
+		if token.Name != tc.expected.Name || token.Type != tc.expected.Type || token.Rules != tc.expected.Rules {
+			t.Fatalf("%d: bad: %#v", i, token)
+		}
+	}
+
+	// The token is now a management token, which can't be given rules.
+	ui := new(cli.MockUi)
+	c := &ACLTokenUpdateCommand{Ui: ui}
+	args := []string{"-http-addr=" + srv.httpAddr, "-token=root", "-rules-file=" + rulesFile, id}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("bad: %d", code)
+	}
+	if output := ui.ErrorWriter.String(); !strings.Contains(output, "Cannot specify -rules-file for a management token") {
+		t.Fatalf("bad: %#v", output)
+	}
+}
+
+func TestACLTokenUpdateCommand_Validation(t *testing.T) {
+	cases := map[string]struct {
+		args   []string
+		output string
+	}{
+		"no ID": {
+			[]string{"-description=web"},
+			"Missing SECRET_ID argument",
+		},
+		"nothing": {
+			[]string{"3f4a9c2e"},
+			"Nothing to update",
+		},
+		"format": {
+			[]string{"-format=yaml", "-description=web", "3f4a9c2e"},
+			`Unsupported format "yaml" (expected text or json)`,
+		},
+		"type": {
+			[]string{"-type=admin", "3f4a9c2e"},
+			`Unsupported type "admin" (expected client or management)`,
+		},
+		"management rules": {
+			[]string{"-type=management", "-rules-file=web.hcl", "3f4a9c2e"},
+			"Cannot specify -rules-file with -type=management",
+		},
+	}
+
+	for name, tc := range cases {
+		ui := new(cli.MockUi)
+		c := &ACLTokenUpdateCommand{Ui: ui}
+		if code := c.Run(tc.args); code != 1 {
+			t.Fatalf("%s: bad: %d", name, code)
+		}
+		if output := ui.ErrorWriter.String(); !strings.Contains(output, tc.output) {
+			t.Fatalf("%s: expected %q to contain %q", name, output, tc.output)
+		}
+	}
+}
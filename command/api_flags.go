@@ -0,0 +1,914 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+)
+
+// exitRequestTimeout is the exit code when a request to the agent timed out
+// with -timeout, which is a communication error like any other failed
+// request.
+const exitRequestTimeout = exitCommError
+
+// exitCancelled is the exit code when a command was cancelled by an interrupt,
+// which is the code a shell gives a process killed by SIGINT.
+const exitCancelled = 130
+
+// APIFlags holds the values of the flags shared by the commands which talk
+// to the HTTP API of the Consul agent, which are described by apiOptsText.
+type APIFlags struct {
+	// Datacenter is the datacenter to send requests to, or empty for the
+	// datacenter of the agent.
+	Datacenter string
+
+	// Namespace is the namespace to send requests to, given with
+	// -namespace. This overrides the CONSUL_NAMESPACE environment variable.
+	// Namespaces are only supported by Consul Enterprise, so Client fails
+	// for any namespace but "default" when the agent is another version.
+	Namespace string
+
+	// Token is the ACL token given with -token. This overrides the
+	// CONSUL_HTTP_TOKEN environment variable.
+	Token string
+
+	// TokenFile is the path of a file to read the ACL token from, given with
+	// -token-file. This overrides the CONSUL_HTTP_TOKEN_FILE environment
+	// variable, and can't be used along with -token.
+	TokenFile string
+
+	// Stale allows reads to be served by any server.
+	Stale bool
+
+	// Consistent makes the leader check that it's still the leader with a
+	// quorum of servers before serving a read, so the result can't be
+	// stale. This can't be combined with Stale.
+	Consistent bool
+
+	// Timeout limits how long each request to the agent may take, or zero
+	// for no limit.
+	Timeout time.Duration
+
+	// Retry is the number of times to retry a request which fails with a
+	// transient error, and RetryInterval is the wait before the first
+	// retry, which doubles after each one.
+	Retry         int
+	RetryInterval time.Duration
+
+	// Quiet leaves out the messages reporting what a command did, such as
+	// "Success! Deleted key: foo", so only data is printed. The exit code
+	// tells whether the command worked.
+	Quiet bool
+
+	// Verbose logs each request to the agent on stderr, and shows the raw
+	// error from the agent along with the explanation of a request denied
+	// by ACLs. Some commands also use it to print more detail of their own.
+	Verbose bool
+
+	// NoKeepAlive closes the connection to the agent after each request,
+	// rather than keeping it open for the next one.
+	NoKeepAlive bool
+
+	// jobs is the number of requests the command makes at once, which is
+	// how many idle connections to the agent are kept for reuse. Commands
+	// with a -jobs flag set it before making the client, and it's
+	// kvDefaultJobs otherwise.
+	jobs int
+
+	httpAddr *string
+	tls      *HTTPTLSFlags
+	ui       cli.Ui
+
+	// socket is the path of the agent's Unix domain socket, for a unix://
+	// address.
+	socket string
+
+	// address and token are the agent's address as given, and the ACL
+	// token in use, as found by ClientConfig for the audit log.
+	address string
+	token   string
+
+	// flagSet is used to tell whether -http-addr was given.
+	flagSet *flag.FlagSet
+
+	// timedOut is set to 1 once a request times out.
+	timedOut int32
+
+	// ctx is the context of every request made by the client, which is
+	// cancelled by Cancel to cut short the requests in flight.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewAPIFlagSet returns a flagset for the named command with the API flags
+// registered, along with the values they will be parsed into. Commands add
+// their own flags to the returned flagset. Retries are logged to the given
+// UI.
+func NewAPIFlagSet(name string, ui cli.Ui) (*flag.FlagSet, *APIFlags) {
+	f := flag.NewFlagSet(name, flag.ContinueOnError)
+	a := &APIFlags{ui: ui, flagSet: f}
+	a.ctx, a.cancel = context.WithCancel(context.Background())
+	f.StringVar(&a.Datacenter, "datacenter", "", "")
+	f.StringVar(&a.Namespace, "namespace", "", "")
+	f.StringVar(&a.Token, "token", "", "")
+	f.StringVar(&a.TokenFile, "token-file", "", "")
+	f.BoolVar(&a.Stale, "stale", false, "")
+	f.BoolVar(&a.Consistent, "consistent", false, "")
+	f.DurationVar(&a.Timeout, "timeout", 0, "")
+	f.IntVar(&a.Retry, "retry", 0, "")
+	f.DurationVar(&a.RetryInterval, "retry-interval", time.Second, "")
+	f.BoolVar(&a.Quiet, "quiet", false, "")
+	f.BoolVar(&a.Verbose, "verbose", false, "")
+	f.BoolVar(&a.NoKeepAlive, "no-keepalive", false, "")
+	a.httpAddr = HTTPAddrFlag(f)
+	a.tls = HTTPTLSFlag(f)
+	return f, a
+}
+
+// ClientConfig returns the client configuration for the parsed flags. The
+// datacenter, namespace, and token are set on the configuration so they
+// apply to every request. The address is taken from the first of -http-addr,
+// CONSUL_HTTP_ADDR, and the default address which is set, and the token
+// from the first of -token, -token-file, CONSUL_HTTP_TOKEN, and
+// CONSUL_HTTP_TOKEN_FILE.
+func (a *APIFlags) ClientConfig() (*api.Config, error) {
+	conf := api.DefaultConfig()
+	conf.Datacenter = a.Datacenter
+	if a.Namespace != "" {
+		conf.Namespace = a.Namespace
+	}
+
+	// The default config already has the address from the environment, so
+	// only replace it when the flag was given.
+	a.flagSet.Visit(func(f *flag.Flag) {
+		if f.Name == "http-addr" {
+			conf.Address = *a.httpAddr
+		}
+	})
+
+	// A unix:// address is dialed here rather than left to the api package,
+	// which replaces the whole HTTP client for one, and would drop the TLS
+	// settings and the transports added below.
+	addr, socket, err := parseHTTPAddr(conf.Address)
+	if err != nil {
+		return nil, err
+	}
+	a.address, conf.Address = conf.Address, addr
+
+	tokenFile := a.TokenFile
+	switch {
+	case a.Token != "" && a.TokenFile != "":
+		return nil, fmt.Errorf("Cannot specify both -token and -token-file")
+	case a.Token != "":
+		conf.Token = a.Token
+	case tokenFile == "" && conf.Token == "":
+		tokenFile = os.Getenv(api.HTTPTokenFileEnvName)
+	}
+	if tokenFile != "" {
+		token, err := readTokenFile(tokenFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.Token = token
+	}
+	a.token = conf.Token
+
+	if err := a.tls.Configure(conf); err != nil {
+		return nil, fmt.Errorf("Invalid TLS options: %s", err)
+	}
+
+	// Configure always sets up a new transport, so this doesn't change the
+	// default one. It only speaks HTTP/1.1, which doesn't share a connection
+	// between requests in flight, so one is kept idle for each job. Without
+	// that, parallel requests open a new connection for all but one of them,
+	// which can run out of local ports over a large tree.
+	transport := conf.HttpClient.Transport.(*http.Transport)
+	jobs := a.jobs
+	if jobs < 1 {
+		jobs = kvDefaultJobs
+	}
+	transport.MaxIdleConnsPerHost = jobs
+	transport.DisableKeepAlives = a.NoKeepAlive
+	if socket != "" {
+		transport.Dial = func(_, _ string) (net.Conn, error) {
+			return net.Dial("unix", socket)
+		}
+		a.socket = socket
+	}
+	if a.Quiet && a.Verbose {
+		return nil, fmt.Errorf("Cannot specify both -quiet and -verbose")
+	}
+	if a.Stale && a.Consistent {
+		return nil, fmt.Errorf("Cannot specify both -stale and -consistent")
+	}
+
+	// Each attempt is logged, so this goes underneath the timeouts and
+	// retries.
+	if a.Verbose {
+		conf.HttpClient.Transport = &verboseTransport{
+			base:  conf.HttpClient.Transport,
+			flags: a,
+		}
+	}
+	conf.HttpClient.Transport = &txnStatusTransport{base: conf.HttpClient.Transport}
+	if a.Timeout < 0 {
+		return nil, fmt.Errorf("-timeout must not be negative")
+	}
+	if a.Timeout > 0 {
+		conf.HttpClient.Transport = &timeoutTransport{
+			base:  conf.HttpClient.Transport,
+			flags: a,
+		}
+	}
+
+	// Each attempt gets its own timeout.
+	switch {
+	case a.Retry < 0:
+		return nil, fmt.Errorf("-retry must not be negative")
+	case a.RetryInterval <= 0:
+		return nil, fmt.Errorf("-retry-interval must be positive")
+	case a.Retry > 0:
+		conf.HttpClient.Transport = &retryTransport{
+			base:  conf.HttpClient.Transport,
+			flags: a,
+		}
+	}
+
+	// Cancelling stops the retries too, so this goes on top.
+	conf.HttpClient.Transport = &cancelTransport{
+		base: conf.HttpClient.Transport,
+		ctx:  a.ctx,
+	}
+	return conf, nil
+}
+
+// parseHTTPAddr checks the address of the agent, returning it ready for the
+// client configuration. An http:// prefix is removed, while https:// is kept
+// for the TLS flags to turn on TLS. For a unix:// address, the path of the
+// socket is returned as well, and the address is replaced by a host name
+// for the requests, since the socket is dialed instead.
+func parseHTTPAddr(addr string) (string, string, error) {
+	host := addr
+	switch {
+	case strings.HasPrefix(addr, "unix://"):
+		socket := strings.TrimPrefix(addr, "unix://")
+		if socket == "" {
+			return "", "", fmt.Errorf("Invalid HTTP address %q: missing the path to the socket, "+
+				"such as unix:///var/run/consul.sock", addr)
+		}
+		return "localhost", socket, nil
+	case strings.HasPrefix(addr, "unix:"):
+		return "", "", fmt.Errorf("Invalid HTTP address %q: a socket must be given as unix:// "+
+			"followed by its path, such as unix:///var/run/consul.sock", addr)
+	case strings.HasPrefix(addr, "http://"):
+		host = strings.TrimPrefix(addr, "http://")
+	case strings.HasPrefix(addr, "https://"):
+		host = strings.TrimPrefix(addr, "https://")
+	case strings.Contains(addr, "://"):
+		return "", "", fmt.Errorf("Invalid HTTP address %q: the scheme must be http, https, or unix", addr)
+	}
+
+	if host == "" || strings.ContainsAny(host, "/ ") {
+		return "", "", fmt.Errorf("Invalid HTTP address %q: expected a host and port, such as 127.0.0.1:8500", addr)
+	}
+	return strings.TrimPrefix(addr, "http://"), "", nil
+}
+
+// readTokenFile returns the ACL token stored in the given file, without any
+// trailing whitespace.
+func readTokenFile(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("Failed to read token file: %s", err)
+	}
+	token := strings.TrimRightFunc(string(data), unicode.IsSpace)
+	if token == "" {
+		return "", fmt.Errorf("Token file %s is empty", path)
+	}
+	return token, nil
+}
+
+// Client returns an API client for the parsed flags. If a namespace other
+// than "default" is given, the agent is checked to support namespaces first,
+// since other agents ignore the namespace of a request rather than rejecting
+// it, which would read or write the wrong keys.
+func (a *APIFlags) Client() (*api.Client, error) {
+	conf, err := a.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	client, err := api.NewClient(conf)
+	if err != nil {
+		return nil, err
+	}
+	if ns := conf.Namespace; ns != "" && ns != "default" {
+		if err := checkNamespaces(client, ns); err != nil {
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+// checkNamespaces returns an error unless the agent is Consul Enterprise,
+// which is the only version that supports namespaces. This is told from the
+// version the agent reports, which is marked "ent" by Enterprise, so reading
+// it needs agent read access.
+func checkNamespaces(client *api.Client, ns string) error {
+	self, err := client.Agent().Self()
+	if err != nil {
+		return fmt.Errorf("Failed to check whether the agent supports namespaces, "+
+			"which is needed to use namespace %q: %s", ns, err)
+	}
+	config := self["Config"]
+	version, _ := config["Version"].(string)
+	metadata, _ := config["VersionMetadata"].(string)
+	if metadata == "ent" || strings.HasSuffix(version, "+ent") {
+		return nil
+	}
+	return fmt.Errorf("Cannot use namespace %q: namespaces are only supported by "+
+		"Consul Enterprise, but the agent is Consul %s", ns, version)
+}
+
+// QueryOptions returns the options for reads made with the parsed flags.
+func (a *APIFlags) QueryOptions() *api.QueryOptions {
+	return &api.QueryOptions{
+		Datacenter:        a.Datacenter,
+		Namespace:         a.Namespace,
+		AllowStale:        a.Stale,
+		RequireConsistent: a.Consistent,
+	}
+}
+
+// WriteOptions returns the options for writes made with the parsed flags.
+func (a *APIFlags) WriteOptions() *api.WriteOptions {
+	return &api.WriteOptions{
+		Datacenter: a.Datacenter,
+		Namespace:  a.Namespace,
+	}
+}
+
+// report prints a message saying what the command did, such as "Success!
+// Deleted key: foo", unless -quiet was given. Data is printed with the UI
+// directly, so it's never left out.
+func (a *APIFlags) report(ui cli.Ui, msg string) {
+	if !a.Quiet {
+		ui.Info(msg)
+	}
+}
+
+// note prints a message about the progress of the command to stderr, such as
+// how many keys are about to be deleted, unless -quiet was given. This keeps
+// it out of any data written to stdout.
+func (a *APIFlags) note(ui cli.Ui, msg string) {
+	if !a.Quiet {
+		ui.Warn(msg)
+	}
+}
+
+// CheckTimeout reports a failure caused by a request which timed out, and
+// changes the exit code to exitRequestTimeout. Commands defer it with their
+// named exit code.
+func (a *APIFlags) CheckTimeout(ui cli.Ui, code *int) {
+	if *code != 0 && atomic.LoadInt32(&a.timedOut) == 1 {
+		ui.Error(fmt.Sprintf("Error! Request timed out after %s", a.Timeout))
+		*code = exitRequestTimeout
+	}
+}
+
+// Cancel cuts short the requests in flight, and makes any later ones fail
+// straight away, so a command which is interrupted can clean up and exit.
+func (a *APIFlags) Cancel() {
+	a.cancel()
+}
+
+// Cancelled returns true once Cancel has been called. Commands check it when
+// a request fails, to tell an interrupt from an error.
+func (a *APIFlags) Cancelled() bool {
+	return a.ctx.Err() != nil
+}
+
+// WatchShutdown calls Cancel when the shutdown channel fires, such as on an
+// interrupt, until the returned function is called. Commands which don't
+// watch the channel themselves defer it after parsing their flags.
+func (a *APIFlags) WatchShutdown(shutdownCh <-chan struct{}) func() {
+	stopCh := make(chan struct{})
+	go func() {
+		select {
+		case <-shutdownCh:
+			a.Cancel()
+		case <-stopCh:
+		}
+	}()
+	return func() { close(stopCh) }
+}
+
+// aclDenial describes a request which the agent may deny because of ACLs:
+// what the request does, and what the token needs for it to be allowed.
+type aclDenial struct {
+	op   string
+	need string
+}
+
+// kvDenial describes a request on the given key or prefix, which needs the
+// given KV policy.
+func kvDenial(op, key, policy string) aclDenial {
+	return aclDenial{
+		op:   op,
+		need: fmt.Sprintf("The token needs an ACL rule such as:\n\n    key %q { policy = %q }", key, policy),
+	}
+}
+
+// snapshotDenial describes a snapshot request, which needs a management
+// token since a snapshot holds all the ACLs.
+func snapshotDenial(op string) aclDenial {
+	return aclDenial{
+		op:   op,
+		need: "Snapshots can only be saved and restored with a management token.",
+	}
+}
+
+// catalogDenial describes a read of the catalog, which needs the given kind
+// of policy, such as "node" or "service". The catalog leaves out whatever the
+// token can't read rather than denying the request, so this mostly comes up
+// when the token itself isn't valid.
+func catalogDenial(op, kind string) aclDenial {
+	return aclDenial{
+		op:   op,
+		need: fmt.Sprintf("The token needs an ACL rule such as:\n\n    %s \"\" { policy = \"read\" }", kind),
+	}
+}
+
+// eventDenial describes a request on the user events with the given name,
+// which needs the given event policy. Events the token can't read are left
+// out of a list rather than denied.
+func eventDenial(op, name, policy string) aclDenial {
+	return aclDenial{
+		op:   op,
+		need: fmt.Sprintf("The token needs an ACL rule such as:\n\n    event %q { policy = %q }", name, policy),
+	}
+}
+
+// sessionDenial describes a request on the sessions of the given node, which
+// needs the given session policy.
+func sessionDenial(op, node, policy string) aclDenial {
+	return aclDenial{
+		op:   op,
+		need: fmt.Sprintf("The token needs an ACL rule such as:\n\n    session %q { policy = %q }", node, policy),
+	}
+}
+
+// queryDenial describes a request on the prepared queries with the given
+// name or prefix, which needs the given query policy. Queries without a name
+// can only be changed with the token which created them, or a management
+// token.
+func queryDenial(op, name, policy string) aclDenial {
+	if name == "" && policy == "write" {
+		return aclDenial{
+			op:   op,
+			need: "Prepared queries without a name can only be changed with the token which created them, or a management token.",
+		}
+	}
+	return aclDenial{
+		op:   op,
+		need: fmt.Sprintf("The token needs an ACL rule such as:\n\n    query %q { policy = %q }", name, policy),
+	}
+}
+
+// agentDenial describes a request which changes the agent itself, such as
+// joining it to a cluster, which needs the given agent policy for its node.
+func agentDenial(op, policy string) aclDenial {
+	return aclDenial{
+		op:   op,
+		need: fmt.Sprintf("The token needs an ACL rule for the agent's node such as:\n\n    agent \"\" { policy = %q }", policy),
+	}
+}
+
+// operatorDenial describes a request on the cluster's Raft configuration,
+// which needs the given operator policy.
+func operatorDenial(op, policy string) aclDenial {
+	return aclDenial{
+		op:   op,
+		need: fmt.Sprintf("The token needs an ACL rule such as:\n\n    operator = %q", policy),
+	}
+}
+
+// errorMessage formats an error from a request to the agent after msg. If the
+// agent denied the request because of ACLs, the raw error is replaced by an
+// explanation of what was denied and what the token needs. The raw error is
+// still shown with -verbose.
+func (a *APIFlags) errorMessage(msg string, err error, denial aclDenial) string {
+	if !isPermissionDenied(err) {
+		// The socket's permissions are set by the agent, not by ACLs.
+		if a.socket != "" && strings.Contains(err.Error(), "connect: permission denied") {
+			return fmt.Sprintf("%s: %s\n\nThe agent's socket %s can't be opened by this user. "+
+				"Its owner, group, and mode are set by the unix_sockets options in the agent's "+
+				"configuration.", msg, err, a.socket)
+		}
+		return fmt.Sprintf("%s: %s", msg, err)
+	}
+
+	text := fmt.Sprintf("Error! Permission denied to %s. %s", denial.op, denial.need)
+	if strings.Contains(err.Error(), "ACL not found") {
+		// No rule will help if the token itself doesn't exist.
+		text = fmt.Sprintf("Error! Permission denied to %s, since the ACL token was not found. "+
+			"Check the token given by -token, -token-file, or the environment.", denial.op)
+	}
+	if a.Verbose {
+		text += fmt.Sprintf("\n\nError from the agent: %s", err)
+	}
+	return text
+}
+
+// blockingQuery runs the given query, which returns the index of its
+// result. With block set, the query is then repeated as a blocking query
+// until the index moves and changed, if given, reports that the result has
+// changed, or until the wait time runs out. It returns 0 to carry on with the
+// result, or the exit code for the command after reporting any error, which
+// is 2 if the wait timed out with no change, the same as for something which
+// doesn't exist. An error from ACLs is explained using the given denial.
+func (a *APIFlags) blockingQuery(ui cli.Ui, denial aclDenial, q *api.QueryOptions, block bool,
+	wait time.Duration, query func(*api.QueryOptions) (uint64, error), changed func() bool) int {
+	index, err := query(q)
+	if err != nil {
+		ui.Error(a.errorMessage("Error querying Consul agent", err, denial))
+		return exitCommError
+	}
+	if !block {
+		return 0
+	}
+
+	deadline := time.Now().Add(wait)
+	for {
+		remaining := deadline.Sub(time.Now())
+		if remaining <= 0 {
+			ui.Error(fmt.Sprintf("Timed out after %s waiting for a change", wait))
+			return exitNotFound
+		}
+
+		q.WaitIndex = index
+		q.WaitTime = remaining
+		newIndex, err := query(q)
+		if err != nil {
+			ui.Error(a.errorMessage("Error querying Consul agent", err, denial))
+			return exitCommError
+		}
+		if newIndex != index && (changed == nil || changed()) {
+			return 0
+		}
+		index = newIndex
+	}
+}
+
+// isPermissionDenied returns true if the error is a 403 response from the
+// agent, or an operation in a transaction which was denied by ACLs.
+func isPermissionDenied(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "Unexpected response code: 403") ||
+		strings.Contains(msg, "Permission denied")
+}
+
+// timeoutTransport limits each request, including reading the response
+// body, to the -timeout, so a hung agent or a stalled stream can't block
+// forever. Blocking queries are given their wait time on top, since the
+// agent holds them open on purpose.
+type timeoutTransport struct {
+	base  http.RoundTripper
+	flags *APIFlags
+}
+
+func (t *timeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	timeout := t.flags.Timeout
+	if wait, err := time.ParseDuration(req.URL.Query().Get("wait")); err == nil {
+		// The agent adds up to wait/16 of jitter to blocking queries.
+		timeout += wait + wait/16
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		t.check(ctx)
+		cancel()
+		return nil, err
+	}
+	resp.Body = &timeoutBody{resp.Body, ctx, cancel, t}
+	return resp, nil
+}
+
+// check records the timeout if the request's deadline has passed.
+func (t *timeoutTransport) check(ctx context.Context) {
+	if ctx.Err() == context.DeadlineExceeded {
+		atomic.StoreInt32(&t.flags.timedOut, 1)
+	}
+}
+
+// timeoutBody releases the request's context once the body is closed, and
+// records a timeout while reading it.
+type timeoutBody struct {
+	io.ReadCloser
+	ctx       context.Context
+	cancel    context.CancelFunc
+	transport *timeoutTransport
+}
+
+func (b *timeoutBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		b.transport.check(b.ctx)
+	}
+	return n, err
+}
+
+func (b *timeoutBody) Close() error {
+	b.cancel()
+	return b.ReadCloser.Close()
+}
+
+// cancelTransport sends each request with the context from the API flags, so
+// that cancelling it stops the request, including reading a streamed body.
+type cancelTransport struct {
+	base http.RoundTripper
+	ctx  context.Context
+}
+
+func (t *cancelTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.base.RoundTrip(req.WithContext(t.ctx))
+}
+
+// txnStatusTransport turns a response to a transaction which is neither a
+// success nor a rollback into a *kvTxnStatusError. The api package reports
+// these without the status code, which is needed to tell a server error,
+// which can be retried, from a request denied by ACLs.
+type txnStatusTransport struct {
+	base http.RoundTripper
+}
+
+func (t *txnStatusTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || req.URL.Path != "/v1/txn" ||
+		resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusConflict {
+		return resp, err
+	}
+
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return nil, &kvTxnStatusError{code: resp.StatusCode, body: string(body)}
+}
+
+// kvTxnStatusError is the error for a transaction which failed with the given
+// status code and response body.
+type kvTxnStatusError struct {
+	code int
+	body string
+}
+
+func (e *kvTxnStatusError) Error() string {
+	return fmt.Sprintf("Unexpected response code: %d (%s)", e.code, e.body)
+}
+
+// kvTxn runs the transaction like the api package's Txn, but a transaction
+// which failed is reported with its status code, by the *kvTxnStatusError
+// from txnStatusTransport, rather than wrapped in the request's URL.
+func kvTxn(client *api.Client, ops api.KVTxnOps, q *api.QueryOptions) (bool, *api.KVTxnResponse, error) {
+	ok, resp, _, err := client.KV().Txn(ops, q)
+	if uerr, isURL := err.(*url.Error); isURL {
+		if serr, isStatus := uerr.Err.(*kvTxnStatusError); isStatus {
+			err = serr
+		}
+	}
+	return ok, resp, err
+}
+
+// verboseTransport logs each request to stderr with -verbose, along with the
+// status, the index of the result and how up to date the server which served
+// it was if the agent says, and the time taken to get the response. The time
+// doesn't include reading the body, which may be streamed, as for a snapshot.
+type verboseTransport struct {
+	base  http.RoundTripper
+	flags *APIFlags
+
+	// l serializes the logging, since some commands make requests in
+	// parallel.
+	l sync.Mutex
+}
+
+func (t *verboseTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	elapsed := time.Since(start)
+
+	var msg string
+	if err != nil {
+		msg = fmt.Sprintf("Request %s %s failed after %s: %s", req.Method, req.URL.RequestURI(), elapsed, err)
+	} else {
+		msg = fmt.Sprintf("Request %s %s: %d in %s", req.Method, req.URL.RequestURI(), resp.StatusCode, elapsed)
+		if meta := verboseQueryMeta(resp.Header); len(meta) > 0 {
+			msg += fmt.Sprintf(" (%s)", strings.Join(meta, ", "))
+		}
+	}
+
+	t.l.Lock()
+	t.flags.ui.Warn(msg)
+	t.l.Unlock()
+	return resp, err
+}
+
+// verboseQueryMeta describes the query metadata the agent sent with a
+// response: the index of the result, whether the server which served it
+// knew of a leader, and how long it was since it last heard from the leader,
+// which is how stale the result may be. Responses to writes have none of it.
+func verboseQueryMeta(header http.Header) []string {
+	var meta []string
+	if index := header.Get("X-Consul-Index"); index != "" {
+		meta = append(meta, "index "+index)
+	}
+	switch header.Get("X-Consul-KnownLeader") {
+	case "true":
+		meta = append(meta, "known leader")
+	case "false":
+		meta = append(meta, "no known leader")
+	}
+	if last, err := strconv.ParseUint(header.Get("X-Consul-LastContact"), 10, 64); err == nil {
+		meta = append(meta, fmt.Sprintf("last contact %s", time.Duration(last)*time.Millisecond))
+	}
+	return meta
+}
+
+// maxRetryWait caps the wait between retries as it doubles.
+const maxRetryWait = time.Minute
+
+// retryWait is called after an operation failed on the given attempt,
+// counting from zero, with an error worth retrying. If there are retries
+// left, it logs the message and waits before returning true, so the caller
+// can try again. The wait is cut short if the context is done, returning
+// false.
+func (a *APIFlags) retryWait(ctx context.Context, attempt int, msg string) bool {
+	if attempt >= a.Retry {
+		return false
+	}
+
+	wait := a.RetryInterval << uint(attempt)
+	if wait > maxRetryWait || wait <= 0 {
+		wait = maxRetryWait
+	}
+	a.ui.Warn(fmt.Sprintf("%s (retrying in %s, attempt %d of %d)", msg, wait, attempt+1, a.Retry))
+
+	select {
+	case <-time.After(wait):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// isTransientError returns true if the error looks like it could go away by
+// itself: a refused, dropped, or reset connection, a timeout, or a 5xx
+// response from the agent. Errors from TLS and 4xx responses, including
+// ACL errors, are not transient.
+func isTransientError(err error) bool {
+	if uerr, ok := err.(*url.Error); ok {
+		err = uerr.Err
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "connection refused") ||
+		strings.Contains(msg, "connection reset") ||
+		strings.Contains(msg, "unexpected EOF") ||
+		strings.HasPrefix(msg, "Unexpected response code: 5")
+}
+
+// retryTransport retries requests which fail with a transient error, up to
+// -retry times. Only requests which are safe to send again are retried:
+// reads, and KV writes and transactions which don't depend on the current
+// state of a key. Snapshots are left to the snapshot commands, since they
+// stream their data.
+type retryTransport struct {
+	base  http.RoundTripper
+	flags *APIFlags
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, ok, err := idempotentRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return t.base.RoundTrip(req)
+	}
+
+	for attempt := 0; ; attempt++ {
+		r := req.Clone(req.Context())
+		if body != nil {
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+
+		resp, err := t.base.RoundTrip(r)
+		if err == nil && resp.StatusCode < 500 {
+			return resp, nil
+		}
+
+		failure := err
+		if err == nil {
+			failure = fmt.Errorf("Unexpected response code: %d", resp.StatusCode)
+		}
+		if attempt >= t.flags.Retry || !isTransientError(failure) {
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		msg := fmt.Sprintf("Request %s %s failed: %s", req.Method, req.URL.Path, failure)
+		if !t.flags.retryWait(req.Context(), attempt, msg) {
+			return nil, failure
+		}
+	}
+}
+
+// idempotentRequest returns true if the request can be sent again without
+// changing its outcome, along with its body, which has been read so it can
+// be sent again.
+func idempotentRequest(req *http.Request) ([]byte, bool, error) {
+	query := req.URL.Query()
+	switch {
+	case strings.HasPrefix(req.URL.Path, "/v1/snapshot"):
+		return nil, false, nil
+	case req.Method == "GET" || req.Method == "HEAD":
+		return nil, true, nil
+	case strings.HasPrefix(req.URL.Path, "/v1/kv/"):
+		if req.Method != "PUT" && req.Method != "DELETE" {
+			return nil, false, nil
+		}
+		for _, param := range []string{"cas", "acquire", "release"} {
+			if _, ok := query[param]; ok {
+				return nil, false, nil
+			}
+		}
+	case req.URL.Path == "/v1/txn" && req.Method == "PUT":
+	default:
+		return nil, false, nil
+	}
+
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = ioutil.ReadAll(req.Body); err != nil {
+			return nil, false, err
+		}
+		req.Body.Close()
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	if req.URL.Path != "/v1/txn" {
+		return body, true, nil
+	}
+
+	// Operations which check a lock or an index can't be repeated, since
+	// the first attempt may have gone through.
+	var ops []struct {
+		KV *struct {
+			Verb string
+		}
+	}
+	if err := json.Unmarshal(body, &ops); err != nil {
+		return body, false, nil
+	}
+	for _, op := range ops {
+		if op.KV == nil {
+			return body, false, nil
+		}
+		switch op.KV.Verb {
+		case "set", "delete", "delete-tree", "get", "get-tree", "check-index", "check-session":
+		default:
+			return body, false, nil
+		}
+	}
+	return body, true, nil
+}
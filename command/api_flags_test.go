@@ -0,0 +1,1061 @@
+package command
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/command/agent"
+	"github.com/mitchellh/cli"
+)
+
+func TestNewAPIFlagSet(t *testing.T) {
+	var lock sync.Mutex
+	var tokens, dcs []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		tokens = append(tokens, r.Header.Get("X-Consul-Token"))
+		dcs = append(dcs, r.URL.Query().Get("dc"))
+		lock.Unlock()
+
+		// Enough for the commands to make their first request, they're
+		// free to fail after that.
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+	addr := strings.TrimPrefix(srv.URL, "http://")
+
+	dir, err := ioutil.TempDir("", "consul")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tokenFile := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("file\n"), 0600); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	commands := map[string]struct {
+		cmd  func(ui cli.Ui) cli.Command
+		args []string
+	}{
+		"kv get": {
+			func(ui cli.Ui) cli.Command { return &KVGetCommand{Ui: ui} },
+			[]string{"foo"},
+		},
+		"kv put": {
+			func(ui cli.Ui) cli.Command { return &KVPutCommand{Ui: ui} },
+			[]string{"foo", "bar"},
+		},
+		"kv delete": {
+			func(ui cli.Ui) cli.Command { return &KVDeleteCommand{Ui: ui} },
+			[]string{"foo"},
+		},
+		"kv export": {
+			func(ui cli.Ui) cli.Command { return &KVExportCommand{Ui: ui} },
+			[]string{"foo"},
+		},
+		"kv import": {
+			func(ui cli.Ui) cli.Command { return &KVImportCommand{Ui: ui} },
+			[]string{`[{"key":"foo","flags":0,"value":"YmFy"}]`},
+		},
+		"kv copy": {
+			func(ui cli.Ui) cli.Command { return &KVCopyCommand{Ui: ui} },
+			[]string{"foo", "bar"},
+		},
+		"kv diff": {
+			func(ui cli.Ui) cli.Command { return &KVDiffCommand{Ui: ui} },
+			[]string{"foo", "bar"},
+		},
+		"snapshot save": {
+			func(ui cli.Ui) cli.Command {
+				return &SnapshotSaveCommand{Ui: ui}
+			},
+			[]string{"-retries=0", filepath.Join(dir, "backup.snap")},
+		},
+		"snapshot restore": {
+			func(ui cli.Ui) cli.Command { return &SnapshotRestoreCommand{Ui: ui} },
+			[]string{"-force", "test-fixtures/snapshot/backup.snap"},
+		},
+	}
+
+	cases := map[string]struct {
+		env     string
+		envFile string
+		args    []string
+		token   string
+		dc      string
+	}{
+		"no token":              {"", "", nil, "", ""},
+		"env token":             {"env", "", nil, "env", ""},
+		"token flag":            {"", "", []string{"-token=flag"}, "flag", ""},
+		"flag beats env":        {"env", "", []string{"-token=flag"}, "flag", ""},
+		"env token file":        {"", tokenFile, nil, "file", ""},
+		"env token beats file":  {"env", tokenFile, nil, "env", ""},
+		"token file flag":       {"", "", []string{"-token-file=" + tokenFile}, "file", ""},
+		"token file beats env":  {"env", "", []string{"-token-file=" + tokenFile}, "file", ""},
+		"token flag beats file": {"", tokenFile, []string{"-token=flag"}, "flag", ""},
+		"datacenter flag":       {"", "", []string{"-datacenter=dc2"}, "", "dc2"},
+	}
+
+	for cmdName, command := range commands {
+		for name, tc := range cases {
+			os.Clearenv()
+			if tc.env != "" {
+				os.Setenv("CONSUL_HTTP_TOKEN", tc.env)
+			}
+			if tc.envFile != "" {
+				os.Setenv("CONSUL_HTTP_TOKEN_FILE", tc.envFile)
+			}
+
+			lock.Lock()
+			tokens, dcs = nil, nil
+			lock.Unlock()
+
+			ui := new(cli.MockUi)
+			args := append([]string{"-http-addr=" + addr}, tc.args...)
+			command.cmd(ui).Run(append(args, command.args...))
+
+			lock.Lock()
+			if len(tokens) == 0 {
+				t.Fatalf("%s, %s: no requests made: %s", cmdName, name, ui.ErrorWriter.String())
+			}
+			for i := range tokens {
+				if tokens[i] != tc.token || dcs[i] != tc.dc {
+					t.Fatalf("%s, %s: bad: token %q, dc %q", cmdName, name, tokens[i], dcs[i])
+				}
+			}
+			lock.Unlock()
+		}
+	}
+
+	// The address is taken from CONSUL_HTTP_ADDR unless -http-addr is
+	// given. The test server isn't on the default port, so any requests
+	// made went to the right place.
+	addrCases := map[string]struct {
+		env  string
+		args []string
+	}{
+		"env addr":            {addr, nil},
+		"flag beats env addr": {"127.0.0.1:1", []string{"-http-addr=" + addr}},
+	}
+	for cmdName, command := range commands {
+		for name, tc := range addrCases {
+			os.Clearenv()
+			os.Setenv("CONSUL_HTTP_ADDR", tc.env)
+
+			lock.Lock()
+			tokens, dcs = nil, nil
+			lock.Unlock()
+
+			ui := new(cli.MockUi)
+			command.cmd(ui).Run(append(tc.args, command.args...))
+
+			lock.Lock()
+			if len(tokens) == 0 {
+				t.Fatalf("%s, %s: no requests made: %s", cmdName, name, ui.ErrorWriter.String())
+			}
+			lock.Unlock()
+		}
+	}
+	os.Clearenv()
+}
+
+func TestAPIFlags_Namespace(t *testing.T) {
+	var lock sync.Mutex
+	var namespaces []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Answer like a Consul Enterprise agent.
+		if r.URL.Path == "/v1/agent/self" {
+			fmt.Fprintf(w, `{"Config": {"Version": "0.7.3+ent"}}`)
+			return
+		}
+		lock.Lock()
+		namespaces = append(namespaces, r.URL.Query().Get("ns"))
+		lock.Unlock()
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+	addr := strings.TrimPrefix(srv.URL, "http://")
+
+	cases := map[string]struct {
+		env  string
+		args []string
+		ns   string
+	}{
+		"no namespace":      {"", nil, ""},
+		"env namespace":     {"team-a", nil, "team-a"},
+		"namespace flag":    {"", []string{"-namespace=team-b"}, "team-b"},
+		"flag beats env":    {"team-a", []string{"-namespace=team-b"}, "team-b"},
+		"default namespace": {"", []string{"-namespace=default"}, "default"},
+	}
+
+	for cmdName, command := range namespaceCommands {
+		for name, tc := range cases {
+			os.Clearenv()
+			if tc.env != "" {
+				os.Setenv("CONSUL_NAMESPACE", tc.env)
+			}
+
+			lock.Lock()
+			namespaces = nil
+			lock.Unlock()
+
+			ui := new(cli.MockUi)
+			args := append([]string{"-http-addr=" + addr}, tc.args...)
+			code := command.cmd(ui).Run(append(args, command.args...))
+			if code == 0 {
+				t.Fatalf("%s, %s: bad: %d", cmdName, name, code)
+			}
+
+			lock.Lock()
+			if len(namespaces) == 0 {
+				t.Fatalf("%s, %s: no requests made: %s", cmdName, name, ui.ErrorWriter.String())
+			}
+			for _, ns := range namespaces {
+				if ns != tc.ns {
+					t.Fatalf("%s, %s: bad: namespace %q", cmdName, name, ns)
+				}
+			}
+			lock.Unlock()
+		}
+	}
+	os.Clearenv()
+}
+
+func TestAPIFlags_NamespaceUnsupported(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	// The agent ignores namespaces, so each command must fail before it
+	// touches the key, which would otherwise be the one in the default
+	// namespace.
+	cases := map[string]struct {
+		env  string
+		args []string
+		ok   bool
+	}{
+		"env namespace":     {"team-a", nil, false},
+		"namespace flag":    {"", []string{"-namespace=team-b"}, false},
+		"default namespace": {"", []string{"-namespace=default"}, true},
+	}
+
+	for cmdName, command := range namespaceCommands {
+		for name, tc := range cases {
+			if _, err := client.KV().Put(&api.KVPair{Key: "foo", Value: []byte("orig")}, nil); err != nil {
+				t.Fatalf("err: %v", err)
+			}
+
+			os.Clearenv()
+			if tc.env != "" {
+				os.Setenv("CONSUL_NAMESPACE", tc.env)
+			}
+			ui := new(cli.MockUi)
+			args := append([]string{"-http-addr=" + srv.httpAddr}, tc.args...)
+			code := command.cmd(ui).Run(append(args, command.args...))
+			os.Clearenv()
+
+			output := ui.ErrorWriter.String()
+			if tc.ok {
+				if code != 0 {
+					t.Fatalf("%s, %s: bad: %d. %#v", cmdName, name, code, output)
+				}
+				continue
+			}
+			if code != 1 {
+				t.Fatalf("%s, %s: bad: %d. %#v", cmdName, name, code, output)
+			}
+			if !strings.Contains(output, "namespaces are only supported by Consul Enterprise") {
+				t.Fatalf("%s, %s: bad: %#v", cmdName, name, output)
+			}
+			if out := ui.OutputWriter.String(); out != "" {
+				t.Fatalf("%s, %s: bad: %#v", cmdName, name, out)
+			}
+
+			pair, _, err := client.KV().Get("foo", nil)
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			if pair == nil || string(pair.Value) != "orig" {
+				t.Fatalf("%s, %s: bad: %#v", cmdName, name, pair)
+			}
+		}
+	}
+}
+
+// namespaceCommands are the commands run by the namespace tests, each with
+// arguments that read or write the key "foo".
+var namespaceCommands = map[string]struct {
+	cmd  func(ui cli.Ui) cli.Command
+	args []string
+}{
+	"kv get": {
+		func(ui cli.Ui) cli.Command { return &KVGetCommand{Ui: ui} },
+		[]string{"foo"},
+	},
+	"kv put": {
+		func(ui cli.Ui) cli.Command { return &KVPutCommand{Ui: ui} },
+		[]string{"foo", "bar"},
+	},
+	"kv delete": {
+		func(ui cli.Ui) cli.Command { return &KVDeleteCommand{Ui: ui} },
+		[]string{"foo"},
+	},
+	"kv export": {
+		func(ui cli.Ui) cli.Command { return &KVExportCommand{Ui: ui} },
+		[]string{"foo"},
+	},
+	"kv import": {
+		func(ui cli.Ui) cli.Command { return &KVImportCommand{Ui: ui} },
+		[]string{`[{"key":"foo","flags":0,"value":"YmFy"}]`},
+	},
+}
+
+func TestAPIFlags_TokenFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "consul")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tokenFile := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("secret \r\n\n"), 0600); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	empty := filepath.Join(dir, "empty")
+	if err := ioutil.WriteFile(empty, []byte("\n"), 0600); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	cases := map[string]struct {
+		args  []string
+		token string
+		err   string
+	}{
+		"trimmed": {
+			[]string{"-token-file=" + tokenFile},
+			"secret",
+			"",
+		},
+		"both": {
+			[]string{"-token=flag", "-token-file=" + tokenFile},
+			"",
+			"Cannot specify both -token and -token-file",
+		},
+		"missing": {
+			[]string{"-token-file=" + filepath.Join(dir, "nope")},
+			"",
+			"Failed to read token file",
+		},
+		"empty": {
+			[]string{"-token-file=" + empty},
+			"",
+			"is empty",
+		},
+	}
+
+	for name, tc := range cases {
+		f, apiFlags := NewAPIFlagSet("test", new(cli.MockUi))
+		if err := f.Parse(tc.args); err != nil {
+			t.Fatalf("%s: err: %v", name, err)
+		}
+
+		conf, err := apiFlags.ClientConfig()
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("%s: bad: %v", name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: err: %v", name, err)
+		}
+		if conf.Token != tc.token {
+			t.Fatalf("%s: bad: %q", name, conf.Token)
+		}
+	}
+}
+
+func TestAPIFlags_Timeout(t *testing.T) {
+	stop := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/kv/hang":
+			<-stop
+		case "/v1/kv/block":
+			// Answer a blocking query after longer than the timeout,
+			// but within its wait time.
+			if r.URL.Query().Get("index") == "" {
+				w.Header().Set("X-Consul-Index", "1")
+				w.Write([]byte(`[{"Key":"block","Value":"YmFy","ModifyIndex":1}]`))
+				return
+			}
+			time.Sleep(300 * time.Millisecond)
+			w.Header().Set("X-Consul-Index", "2")
+			w.Write([]byte(`[{"Key":"block","Value":"YmF6","ModifyIndex":2}]`))
+		case "/v1/snapshot":
+			// Start the stream, then stall partway through.
+			w.Header().Set("X-Consul-Index", "1")
+			w.Write(bytes.Repeat([]byte("x"), 1024))
+			w.(http.Flusher).Flush()
+			<-stop
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	defer close(stop)
+	addr := "-http-addr=" + strings.TrimPrefix(srv.URL, "http://")
+
+	dir, err := ioutil.TempDir("", "consul")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	getCmd := func(ui cli.Ui) cli.Command { return &KVGetCommand{Ui: ui} }
+	cases := map[string]struct {
+		cmd  func(ui cli.Ui) cli.Command
+		args []string
+		code int
+	}{
+		"kv get": {
+			getCmd,
+			[]string{"-timeout=100ms", "hang"},
+			exitRequestTimeout,
+		},
+		"kv get -block": {
+			getCmd,
+			[]string{"-timeout=100ms", "-block", "-wait=2s", "block"},
+			0,
+		},
+		"snapshot save": {
+			func(ui cli.Ui) cli.Command {
+				return &SnapshotSaveCommand{Ui: ui}
+			},
+			[]string{"-timeout=200ms", "-retries=0", filepath.Join(dir, "backup.snap")},
+			exitRequestTimeout,
+		},
+		"negative": {
+			getCmd,
+			[]string{"-timeout=-1s", "hang"},
+			1,
+		},
+	}
+
+	for name, tc := range cases {
+		ui := new(cli.MockUi)
+		code := tc.cmd(ui).Run(append([]string{addr}, tc.args...))
+		if code != tc.code {
+			t.Fatalf("%s: bad: %d. %#v", name, code, ui.ErrorWriter.String())
+		}
+		timedOut := strings.Contains(ui.ErrorWriter.String(), "Request timed out after")
+		if timedOut != (tc.code == exitRequestTimeout) {
+			t.Fatalf("%s: bad: %#v", name, ui.ErrorWriter.String())
+		}
+	}
+}
+
+func TestAPIFlags_Retry(t *testing.T) {
+	// The fake agent fails the first requests in the given way, then
+	// succeeds.
+	var lock sync.Mutex
+	var requests, failures int
+	var failure string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		requests++
+		fail := requests <= failures
+		lock.Unlock()
+
+		if fail {
+			switch failure {
+			case "drop":
+				conn, _, err := w.(http.Hijacker).Hijack()
+				if err != nil {
+					t.Errorf("err: %v", err)
+					return
+				}
+				conn.Close()
+			case "acl":
+				w.WriteHeader(http.StatusForbidden)
+				w.Write([]byte("Permission denied"))
+			default:
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte("No cluster leader"))
+			}
+			return
+		}
+
+		switch {
+		case r.Method == "GET":
+			w.Header().Set("X-Consul-Index", "1")
+			w.Write([]byte(`[{"Key":"foo","Value":"YmFy","ModifyIndex":1}]`))
+		case r.URL.Path == "/v1/txn":
+			w.Write([]byte(`{"Results":[],"Errors":null}`))
+		default:
+			w.Write([]byte("true"))
+		}
+	}))
+	defer srv.Close()
+	addr := "-http-addr=" + strings.TrimPrefix(srv.URL, "http://")
+
+	getCmd := func(ui cli.Ui) cli.Command { return &KVGetCommand{Ui: ui} }
+	putCmd := func(ui cli.Ui) cli.Command { return &KVPutCommand{Ui: ui} }
+	cases := map[string]struct {
+		cmd      func(ui cli.Ui) cli.Command
+		args     []string
+		failure  string
+		failures int
+		code     int
+		requests int
+	}{
+		"get": {
+			getCmd,
+			[]string{"-retry=2", "foo"},
+			"", 2, 0, 3,
+		},
+		"get dropped connection": {
+			getCmd,
+			[]string{"-retry=2", "foo"},
+			"drop", 2, 0, 3,
+		},
+		"get retries exhausted": {
+			getCmd,
+			[]string{"-retry=2", "foo"},
+			"", 3, exitCommError, 3,
+		},
+		"get without -retry": {
+			getCmd,
+			[]string{"foo"},
+			"", 1, exitCommError, 1,
+		},
+		"get acl error": {
+			getCmd,
+			[]string{"-retry=2", "foo"},
+			"acl", 1, exitCommError, 1,
+		},
+		"put": {
+			putCmd,
+			[]string{"-retry=2", "foo", "bar"},
+			"", 2, 0, 3,
+		},
+		"put -acquire": {
+			putCmd,
+			[]string{"-retry=2", "-acquire", "-session=abc", "foo", "bar"},
+			"", 1, 1, 1,
+		},
+		"import": {
+			func(ui cli.Ui) cli.Command { return &KVImportCommand{Ui: ui} },
+			[]string{"-retry=2", `[{"key":"foo","flags":0,"value":"YmFy"}]`},
+			"", 2, 0, 3,
+		},
+		"negative": {
+			getCmd,
+			[]string{"-retry=-1", "foo"},
+			"", 0, 1, 0,
+		},
+		"bad interval": {
+			getCmd,
+			[]string{"-retry=1", "-retry-interval=0s", "foo"},
+			"", 0, 1, 0,
+		},
+	}
+
+	for name, tc := range cases {
+		lock.Lock()
+		requests, failures, failure = 0, tc.failures, tc.failure
+		lock.Unlock()
+
+		ui := new(cli.MockUi)
+		args := append([]string{addr, "-retry-interval=1ms"}, tc.args...)
+		if code := tc.cmd(ui).Run(args); code != tc.code {
+			t.Fatalf("%s: bad: %d. %#v", name, code, ui.ErrorWriter.String())
+		}
+
+		lock.Lock()
+		if requests != tc.requests {
+			t.Fatalf("%s: bad: made %d requests", name, requests)
+		}
+		lock.Unlock()
+
+		// Each retry is logged with the wait before it, which doubles.
+		output := ui.ErrorWriter.String()
+		retries := tc.requests - 1
+		if retries < 0 {
+			retries = 0
+		}
+		if n := strings.Count(output, "retrying in"); n != retries {
+			t.Fatalf("%s: bad: %d retries logged: %#v", name, n, output)
+		}
+		if retries == 2 && !strings.Contains(output, "retrying in 1ms, attempt 1 of 2") ||
+			retries == 2 && !strings.Contains(output, "retrying in 2ms, attempt 2 of 2") {
+			t.Fatalf("%s: bad: %#v", name, output)
+		}
+	}
+}
+
+func TestAPIFlags_PermissionDenied(t *testing.T) {
+	srv := testAgentWithConfig(t, func(c *agent.Config) {
+		c.ACLDatacenter = "dc1"
+		c.ACLDefaultPolicy = "deny"
+		c.ACLMasterToken = "root"
+	})
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	client, err := api.NewClient(&api.Config{Address: srv.httpAddr, Token: "root"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := client.KV().Put(&api.KVPair{Key: "app/foo", Value: []byte("bar")}, nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	token, _, err := client.ACL().Create(&api.ACLEntry{
+		Name:  "read only",
+		Type:  api.ACLClientType,
+		Rules: `key "app/" { policy = "read" }`,
+	}, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	cases := map[string]struct {
+		cmd      func(ui cli.Ui) cli.Command
+		args     []string
+		expected string
+	}{
+		"put": {
+			func(ui cli.Ui) cli.Command { return &KVPutCommand{Ui: ui} },
+			[]string{"app/foo", "bar"},
+			`Permission denied to write to app/foo. The token needs an ACL rule such as:
+
+    key "app/foo" { policy = "write" }`,
+		},
+		"put several": {
+			func(ui cli.Ui) cli.Command { return &KVPutCommand{Ui: ui} },
+			[]string{"-pairs", "app/a=1", "app/b=2"},
+			`key "app/a" { policy = "write" }`,
+		},
+		"delete": {
+			func(ui cli.Ui) cli.Command { return &KVDeleteCommand{Ui: ui} },
+			[]string{"app/foo"},
+			`key "app/foo" { policy = "write" }`,
+		},
+		"delete recurse": {
+			func(ui cli.Ui) cli.Command { return &KVDeleteCommand{Ui: ui} },
+			[]string{"-recurse", "-force", "app/"},
+			`Permission denied to delete keys under app/.`,
+		},
+		"import": {
+			func(ui cli.Ui) cli.Command { return &KVImportCommand{Ui: ui} },
+			[]string{"-atomic", `[{"key":"app/x","flags":0,"value":"YmFy"}]`},
+			`key "app/x" { policy = "write" }`,
+		},
+		"snapshot save": {
+			func(ui cli.Ui) cli.Command { return &SnapshotSaveCommand{Ui: ui, testStdout: ioutil.Discard} },
+			[]string{"-"},
+			"Permission denied to save a snapshot. Snapshots can only be saved and " +
+				"restored with a management token.",
+		},
+	}
+
+	for name, tc := range cases {
+		for _, verbose := range []bool{false, true} {
+			args := []string{"-http-addr=" + srv.httpAddr, "-token=" + token}
+			if verbose {
+				args = append(args, "-verbose")
+			}
+
+			ui := new(cli.MockUi)
+			if code := tc.cmd(ui).Run(append(args, tc.args...)); code == 0 {
+				t.Fatalf("%s: bad: %d", name, code)
+			}
+			output := ui.ErrorWriter.String()
+			if !strings.Contains(output, tc.expected) {
+				t.Fatalf("%s: expected %q to contain %q", name, output, tc.expected)
+			}
+
+			// The raw error is only shown with -verbose.
+			if strings.Contains(output, "Error from the agent: ") != verbose {
+				t.Fatalf("%s: bad: %#v", name, output)
+			}
+		}
+	}
+
+	// A token which doesn't exist can't be fixed with a rule.
+	ui := new(cli.MockUi)
+	c := &KVPutCommand{Ui: ui}
+	if code := c.Run([]string{"-http-addr=" + srv.httpAddr, "-token=nope", "app/foo", "bar"}); code == 0 {
+		t.Fatalf("bad: %d", code)
+	}
+	if output := ui.ErrorWriter.String(); !strings.Contains(output, "ACL token was not found") {
+		t.Fatalf("bad: %#v", output)
+	}
+}
+
+func TestAPIFlags_QuietVerbose(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	dir, err := ioutil.TempDir("", "quiet")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	put := func() {
+		for _, k := range []string{"foo", "tree/a", "tree/b"} {
+			if _, err := client.KV().Put(&api.KVPair{Key: k, Value: []byte("bar")}, nil); err != nil {
+				t.Fatalf("err: %v", err)
+			}
+		}
+	}
+
+	// Data always goes to stdout, and everything else is either left out
+	// with -quiet or goes to stderr.
+	cases := []struct {
+		name   string
+		cmd    func(ui cli.Ui) cli.Command
+		args   []string
+		code   int
+		stdout string
+		stderr string
+	}{
+		{
+			"put",
+			func(ui cli.Ui) cli.Command { return &KVPutCommand{Ui: ui} },
+			[]string{"foo", "bar"},
+			0, "Success! Data written to: foo\n", "",
+		},
+		{
+			"put -quiet",
+			func(ui cli.Ui) cli.Command { return &KVPutCommand{Ui: ui} },
+			[]string{"-quiet", "foo", "bar"},
+			0, "", "",
+		},
+		{
+			"put -verbose",
+			func(ui cli.Ui) cli.Command { return &KVPutCommand{Ui: ui} },
+			[]string{"-verbose", "foo", "bar"},
+			0, "Success! Data written to: foo\n", `^Request PUT /v1/kv/foo: 200 in \S+\n$`,
+		},
+		{
+			"get -quiet",
+			func(ui cli.Ui) cli.Command { return &KVGetCommand{Ui: ui} },
+			[]string{"-quiet", "foo"},
+			0, "bar\n", "",
+		},
+		{
+			"get -verbose",
+			func(ui cli.Ui) cli.Command { return &KVGetCommand{Ui: ui} },
+			[]string{"-verbose", "foo"},
+			0, "bar\n", `^Request GET /v1/kv/foo: 200 in \S+ \(index \d+, known leader, last contact 0s\)\n$`,
+		},
+		{
+			"get -verbose missing",
+			func(ui cli.Ui) cli.Command { return &KVGetCommand{Ui: ui} },
+			[]string{"-verbose", "nope"},
+			exitNotFound, "", `^Request GET /v1/kv/nope: 404 in \S+ \(index \d+, known leader, last contact 0s\)\nError! No key exists at: nope\n$`,
+		},
+		{
+			"delete -recurse",
+			func(ui cli.Ui) cli.Command { return &KVDeleteCommand{Ui: ui} },
+			[]string{"-recurse", "-force", "tree/"},
+			0, "Success! Deleted 2 keys with prefix: tree/\n", `^Deleting 2 keys with prefix: tree/\n$`,
+		},
+		{
+			"delete -recurse -quiet",
+			func(ui cli.Ui) cli.Command { return &KVDeleteCommand{Ui: ui} },
+			[]string{"-recurse", "-force", "-quiet", "tree/"},
+			0, "", "",
+		},
+		{
+			"delete -recurse -dry-run -quiet",
+			func(ui cli.Ui) cli.Command { return &KVDeleteCommand{Ui: ui} },
+			[]string{"-recurse", "-dry-run", "-quiet", "tree/"},
+			0, "tree/a\ntree/b\n", "",
+		},
+		{
+			"import -quiet",
+			func(ui cli.Ui) cli.Command { return &KVImportCommand{Ui: ui} },
+			[]string{"-quiet", `[{"key":"new","flags":0,"value":"YmFy"}]`},
+			0, "", "",
+		},
+		{
+			"snapshot save -quiet",
+			func(ui cli.Ui) cli.Command { return &SnapshotSaveCommand{Ui: ui} },
+			[]string{"-quiet", filepath.Join(dir, "backup.snap")},
+			0, "", "",
+		},
+		{
+			"-quiet and -verbose",
+			func(ui cli.Ui) cli.Command { return &KVGetCommand{Ui: ui} },
+			[]string{"-quiet", "-verbose", "foo"},
+			1, "", "Cannot specify both -quiet and -verbose",
+		},
+		{
+			"get -consistent -verbose",
+			func(ui cli.Ui) cli.Command { return &KVGetCommand{Ui: ui} },
+			[]string{"-consistent", "-verbose", "foo"},
+			0, "bar\n", `^Request GET /v1/kv/foo\?consistent=: 200 in \S+ \(index \d+, known leader, last contact 0s\)\n$`,
+		},
+		{
+			"get -stale -verbose",
+			func(ui cli.Ui) cli.Command { return &KVGetCommand{Ui: ui} },
+			[]string{"-stale", "-verbose", "foo"},
+			0, "bar\n", `^Request GET /v1/kv/foo\?stale=: 200 in \S+ \(index \d+, known leader, last contact \S+\)\n$`,
+		},
+		{
+			"-stale and -consistent",
+			func(ui cli.Ui) cli.Command { return &KVGetCommand{Ui: ui} },
+			[]string{"-stale", "-consistent", "foo"},
+			1, "", "Cannot specify both -stale and -consistent",
+		},
+	}
+
+	for _, tc := range cases {
+		put()
+
+		ui := new(cli.MockUi)
+		args := append([]string{"-http-addr=" + srv.httpAddr}, tc.args...)
+		if code := tc.cmd(ui).Run(args); code != tc.code {
+			t.Fatalf("%s: bad: %d. %#v", tc.name, code, ui.ErrorWriter.String())
+		}
+
+		var stdout, stderr string
+		if ui.OutputWriter != nil {
+			stdout, stderr = ui.OutputWriter.String(), ui.ErrorWriter.String()
+		}
+		if stdout != tc.stdout {
+			t.Fatalf("%s: bad stdout: %q", tc.name, stdout)
+		}
+		if !regexp.MustCompile(tc.stderr).MatchString(stderr) || (tc.stderr == "" && stderr != "") {
+			t.Fatalf("%s: bad stderr: %q", tc.name, stderr)
+		}
+	}
+}
+
+func TestAPIFlags_KeepAlive(t *testing.T) {
+	var conns int32
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Consul-Index", "1")
+		w.Write([]byte(`[{"Key":"foo","Value":"YmFy","ModifyIndex":1}]`))
+	}))
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+
+	// The jobs make their requests in rounds, so each round's connections
+	// are all returned at once and should be kept for the next round.
+	const jobs, rounds = 4, 25
+	run := func(args ...string) int32 {
+		atomic.StoreInt32(&conns, 0)
+		cmdFlags, apiFlags := NewAPIFlagSet("test", new(cli.MockUi))
+		args = append([]string{"-http-addr=" + srv.Listener.Addr().String()}, args...)
+		if err := cmdFlags.Parse(args); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		apiFlags.jobs = jobs
+		client, err := apiFlags.Client()
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+
+		errCh := make(chan error, jobs)
+		for i := 0; i < rounds; i++ {
+			var wg sync.WaitGroup
+			for j := 0; j < jobs; j++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if _, _, err := client.KV().Get("foo", nil); err != nil {
+						errCh <- err
+					}
+				}()
+			}
+			wg.Wait()
+			select {
+			case err := <-errCh:
+				t.Fatalf("err: %v", err)
+			default:
+			}
+		}
+		return atomic.LoadInt32(&conns)
+	}
+
+	if n := run(); n > jobs {
+		t.Fatalf("bad: %d connections for %d jobs", n, jobs)
+	}
+	if n := run("-no-keepalive"); n != jobs*rounds {
+		t.Fatalf("bad: %d connections for %d requests", n, jobs*rounds)
+	}
+}
+
+func TestParseHTTPAddr(t *testing.T) {
+	cases := []struct {
+		in     string
+		addr   string
+		socket string
+		err    string
+	}{
+		{in: "127.0.0.1:8500", addr: "127.0.0.1:8500"},
+		{in: "consul.service:8500", addr: "consul.service:8500"},
+		{in: "http://127.0.0.1:8500", addr: "127.0.0.1:8500"},
+		{in: "https://127.0.0.1:8501", addr: "https://127.0.0.1:8501"},
+		{in: "unix:///var/run/consul.sock", addr: "localhost", socket: "/var/run/consul.sock"},
+		{in: "unix://consul.sock", addr: "localhost", socket: "consul.sock"},
+		{in: "unix://", err: "missing the path to the socket"},
+		{in: "unix:/var/run/consul.sock", err: "a socket must be given as unix:// followed by its path"},
+		{in: "tcp://127.0.0.1:8500", err: "the scheme must be http, https, or unix"},
+		{in: "http://", err: "expected a host and port"},
+		{in: "127.0.0.1:8500/v1", err: "expected a host and port"},
+		{in: "https://127.0.0.1:8501/", err: "expected a host and port"},
+	}
+
+	for _, tc := range cases {
+		addr, socket, err := parseHTTPAddr(tc.in)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("%s: expected error containing %q, got %v", tc.in, tc.err, err)
+			}
+			if !strings.Contains(err.Error(), fmt.Sprintf("%q", tc.in)) {
+				t.Fatalf("%s: error doesn't name the address: %v", tc.in, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: err: %v", tc.in, err)
+		}
+		if addr != tc.addr || socket != tc.socket {
+			t.Fatalf("%s: bad: %q %q", tc.in, addr, socket)
+		}
+	}
+}
+
+// testUnixServer serves the handler on a Unix domain socket in a new
+// temporary directory, returning the path to the socket and a function to
+// shut it down.
+func testUnixServer(t *testing.T, handler http.Handler) (string, func()) {
+	dir, err := ioutil.TempDir("", "consul-sock")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	path := filepath.Join(dir, "consul.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("err: %v", err)
+	}
+	go http.Serve(l, handler)
+	return path, func() {
+		l.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestAPIFlags_UnixSocket(t *testing.T) {
+	srv := testAgent(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	// Proxy a socket to the agent's HTTP address.
+	proxy := httputil.NewSingleHostReverseProxy(&url.URL{Scheme: "http", Host: srv.httpAddr})
+	path, stop := testUnixServer(t, proxy)
+	defer stop()
+
+	run := func(c cli.Command, ui *cli.MockUi, args ...string) {
+		args = append([]string{"-http-addr=unix://" + path}, args...)
+		if code := c.Run(args); code != 0 {
+			t.Fatalf("%v: bad: %d. %#v", args, code, ui.ErrorWriter.String())
+		}
+	}
+
+	ui := new(cli.MockUi)
+	run(&KVPutCommand{Ui: ui}, ui, "app/foo", "bar")
+	if output := ui.OutputWriter.String(); !strings.Contains(output, "Success! Data written to: app/foo") {
+		t.Fatalf("bad: %#v", output)
+	}
+
+	// The other transports still apply over the socket.
+	ui = new(cli.MockUi)
+	run(&KVGetCommand{Ui: ui}, ui, "-verbose", "-timeout=5s", "app/foo")
+	if output := ui.OutputWriter.String(); output != "bar\n" {
+		t.Fatalf("bad: %#v", output)
+	}
+	if output := ui.ErrorWriter.String(); !strings.Contains(output, "Request GET /v1/kv/app/foo: 200 in ") {
+		t.Fatalf("bad: %#v", output)
+	}
+
+	ui = new(cli.MockUi)
+	run(&KVDeleteCommand{Ui: ui}, ui, "app/foo")
+	if output := ui.OutputWriter.String(); !strings.Contains(output, "Success! Deleted key: app/foo") {
+		t.Fatalf("bad: %#v", output)
+	}
+	client, err := api.NewClient(&api.Config{Address: srv.httpAddr})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if pair, _, err := client.KV().Get("app/foo", nil); err != nil || pair != nil {
+		t.Fatalf("bad: %#v %v", pair, err)
+	}
+
+	// A malformed address is reported before any requests.
+	ui = new(cli.MockUi)
+	c := &KVGetCommand{Ui: ui}
+	if code := c.Run([]string{"-http-addr=unix:" + path, "app/foo"}); code != 1 {
+		t.Fatalf("bad: %d", code)
+	}
+	if output := ui.ErrorWriter.String(); !strings.Contains(output, "a socket must be given as unix://") {
+		t.Fatalf("bad: %#v", output)
+	}
+}
+
+func TestAPIFlags_UnixSocket_PermissionDenied(t *testing.T) {
+	// An agent which denies every request by ACLs.
+	path, stop := testUnixServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("Permission denied"))
+	}))
+	defer stop()
+
+	ui := new(cli.MockUi)
+	c := &KVPutCommand{Ui: ui}
+	if code := c.Run([]string{"-http-addr=unix://" + path, "app/foo", "bar"}); code == 0 {
+		t.Fatalf("bad: %d", code)
+	}
+	if output := ui.ErrorWriter.String(); !strings.Contains(output, `key "app/foo" { policy = "write" }`) {
+		t.Fatalf("bad: %#v", output)
+	}
+
+	// The socket itself can't be opened, which ACLs can't fix.
+	_, a := NewAPIFlagSet("test", new(cli.MockUi))
+	a.socket = path
+	err := fmt.Errorf("Put http://localhost/v1/kv/app/foo: dial unix %s: connect: permission denied", path)
+	msg := a.errorMessage("Error! Failed writing data", err, kvDenial("write to app/foo", "app/foo", "write"))
+	if !strings.Contains(msg, "connect: permission denied") ||
+		!strings.Contains(msg, "The agent's socket "+path+" can't be opened by this user") {
+		t.Fatalf("bad: %#v", msg)
+	}
+}
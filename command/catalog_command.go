@@ -0,0 +1,52 @@
+package command
+
+import (
+	"strings"
+
+	"github.com/mitchellh/cli"
+)
+
+// CatalogCommand is a Command implementation that just shows help for
+// the subcommands nested below it.
+type CatalogCommand struct {
+	Ui cli.Ui
+}
+
+func (c *CatalogCommand) Run(args []string) int {
+	return cli.RunResultHelp
+}
+
+func (c *CatalogCommand) Help() string {
+	helpText := `
+Usage: consul catalog <subcommand> [options] [args]
+
+  This command has subcommands for listing what's registered in the catalog:
+  the known datacenters, and the nodes and services in a datacenter. Each
+  can print JSON with -format=json, and nodes and services can wait for the
+  result to change with -block.
+
+  List the known datacenters:
+
+      $ consul catalog datacenters
+
+  List the nodes, with their addresses:
+
+      $ consul catalog nodes -detailed
+
+  List the nodes providing a service, nearest first:
+
+      $ consul catalog nodes -service=redis -near=_agent
+
+  List the services, with their tags:
+
+      $ consul catalog services -tags
+
+  For more examples, ask for subcommand help or view the documentation.
+
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *CatalogCommand) Synopsis() string {
+	return "Lists the datacenters, nodes, and services in the catalog"
+}
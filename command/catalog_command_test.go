@@ -0,0 +1,57 @@
+package command
+
+import (
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+)
+
+func TestCatalogCommand_implements(t *testing.T) {
+	var _ cli.Command = &CatalogCommand{}
+}
+
+func TestCatalogCommand_noTabs(t *testing.T) {
+	assertNoTabs(t, new(CatalogCommand))
+}
+
+// testCatalogRegister registers some nodes and services for the catalog
+// tests: web-1 and web-2 provide "web", with different tags, and db-1
+// provides "db" along with a second instance of "web".
+func testCatalogRegister(t *testing.T, client *api.Client) {
+	regs := []*api.CatalogRegistration{
+		{
+			Node:            "web-2",
+			Address:         "10.0.0.2",
+			TaggedAddresses: map[string]string{"wan": "198.18.0.2", "lan": "10.0.0.2"},
+			Service:         &api.AgentService{ID: "web", Service: "web", Tags: []string{"v2", "primary"}},
+		},
+		{
+			Node:     "web-1",
+			Address:  "10.0.0.1",
+			NodeMeta: map[string]string{"rack": "r1"},
+			Service:  &api.AgentService{ID: "web", Service: "web", Tags: []string{"v1"}},
+		},
+		{
+			Node:    "db-1",
+			Address: "10.0.0.3",
+			Service: &api.AgentService{ID: "db", Service: "db"},
+		},
+		{
+			Node:    "db-1",
+			Address: "10.0.0.3",
+			Service: &api.AgentService{ID: "web-a", Service: "web", Tags: []string{"v1"}},
+		},
+		{
+			Node:    "db-1",
+			Address: "10.0.0.3",
+			Service: &api.AgentService{ID: "web-b", Service: "web", Tags: []string{"v1", "canary"}},
+		},
+	}
+	for _, reg := range regs {
+		reg.Datacenter = "dc1"
+		if _, err := client.Catalog().Register(reg, nil); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package command
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mitchellh/cli"
+)
+
+// CatalogDatacentersCommand is a Command implementation that is used to list
+// the datacenters known to the Consul servers.
+type CatalogDatacentersCommand struct {
+	Ui cli.Ui
+}
+
+func (c *CatalogDatacentersCommand) Synopsis() string {
+	return "Lists the known datacenters"
+}
+
+func (c *CatalogDatacentersCommand) Help() string {
+	helpText := `
+Usage: consul catalog datacenters [options]
+
+  Lists the datacenters known to the Consul servers, one per line, sorted by
+  name. This includes datacenters joined over the WAN that are unreachable.
+
+      $ consul catalog datacenters
+
+  Unlike the nodes and services, the list of datacenters can't be waited on
+  with a blocking query.
+
+  For a full list of options and examples, please see the Consul documentation.
+
+` + apiOptsText + `
+
+Catalog Datacenters Options:
+
+  -format=<string>        Output format, either "text" or "json". With "json",
+                          the datacenters are printed as an array of names.
+                          The default value is "text".
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *CatalogDatacentersCommand) Run(args []string) (code int) {
+	cmdFlags, apiFlags := NewAPIFlagSet("datacenters", c.Ui)
+	defer apiFlags.CheckTimeout(c.Ui, &code)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	format := cmdFlags.String("format", "text", "")
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+
+	if args = cmdFlags.Args(); len(args) > 0 {
+		c.Ui.Error(fmt.Sprintf("Too many arguments (expected 0, got %d)", len(args)))
+		return 1
+	}
+	if *format != "text" && *format != "json" {
+		c.Ui.Error(fmt.Sprintf("Error! Unsupported format %q (expected text or json)", *format))
+		return 1
+	}
+
+	// Create and test the HTTP client
+	client, err := apiFlags.Client()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+		return 1
+	}
+
+	dcs, err := client.Catalog().Datacenters()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error querying Consul agent: %s", err))
+		return exitCommError
+	}
+	sort.Strings(dcs)
+
+	if *format == "json" {
+		if dcs == nil {
+			dcs = []string{}
+		}
+		return printJSON(c.Ui, dcs)
+	}
+	for _, dc := range dcs {
+		c.Ui.Output(dc)
+	}
+	return 0
+}
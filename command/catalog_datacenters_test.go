@@ -0,0 +1,77 @@
+package command
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/cli"
+)
+
+func TestCatalogDatacentersCommand_implements(t *testing.T) {
+	var _ cli.Command = &CatalogDatacentersCommand{}
+}
+
+func TestCatalogDatacentersCommand_noTabs(t *testing.T) {
+	assertNoTabs(t, new(CatalogDatacentersCommand))
+}
+
+func TestCatalogDatacentersCommand_Validation(t *testing.T) {
+	cases := map[string]struct {
+		args   []string
+		output string
+	}{
+		"too many args": {
+			[]string{"foo"},
+			"Too many arguments",
+		},
+		"bad format": {
+			[]string{"-format=yaml"},
+			"Unsupported format",
+		},
+	}
+
+	for name, tc := range cases {
+		ui := new(cli.MockUi)
+		c := &CatalogDatacentersCommand{Ui: ui}
+
+		if code := c.Run(tc.args); code != 1 {
+			t.Fatalf("%s: bad: %d", name, code)
+		}
+		if output := ui.ErrorWriter.String(); !strings.Contains(output, tc.output) {
+			t.Fatalf("%s: expected %q to contain %q", name, output, tc.output)
+		}
+	}
+}
+
+func TestCatalogDatacentersCommand_Run(t *testing.T) {
+	srv := testAgent(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	cases := map[string]struct {
+		args   []string
+		output string
+	}{
+		"text": {
+			[]string{},
+			"dc1\n",
+		},
+		"json": {
+			[]string{"-format=json"},
+			"[\n  \"dc1\"\n]\n",
+		},
+	}
+
+	for name, tc := range cases {
+		ui := new(cli.MockUi)
+		c := &CatalogDatacentersCommand{Ui: ui}
+
+		code := c.Run(append([]string{"-http-addr=" + srv.httpAddr}, tc.args...))
+		if code != 0 {
+			t.Fatalf("%s: bad: %d. %#v", name, code, ui.ErrorWriter.String())
+		}
+		if output := ui.OutputWriter.String(); output != tc.output {
+			t.Fatalf("%s: bad: %#v", name, output)
+		}
+	}
+}
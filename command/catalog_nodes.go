@@ -0,0 +1,213 @@
+package command
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+	"github.com/ryanuber/columnize"
+)
+
+// CatalogNodesCommand is a Command implementation that is used to list the
+// nodes in the catalog.
+type CatalogNodesCommand struct {
+	Ui cli.Ui
+}
+
+func (c *CatalogNodesCommand) Synopsis() string {
+	return "Lists the nodes in the catalog"
+}
+
+func (c *CatalogNodesCommand) Help() string {
+	helpText := `
+Usage: consul catalog nodes [options]
+
+  Lists the nodes registered in the catalog, one per line, sorted by name:
+
+      $ consul catalog nodes
+
+  To list only the nodes providing a service, with their addresses:
+
+      $ consul catalog nodes -service=redis -detailed
+
+  To sort the nodes by their round trip time from a node, nearest first:
+
+      $ consul catalog nodes -near=web-1
+
+  For a full list of options and examples, please see the Consul documentation.
+
+` + apiOptsText + `
+
+Catalog Nodes Options:
+
+  -block                  Wait for the nodes to change before printing them,
+                          using a blocking query. If there is no change within
+                          the -wait time, the command exits with status 2.
+                          The default value is false.
+
+  -detailed               Print a table of the nodes with their addresses,
+                          tagged addresses, and metadata, rather than just
+                          their names. The default value is false.
+
+  -format=<string>        Output format, either "text" or "json". With "json",
+                          the nodes are printed as an array of objects with
+                          their addresses, tagged addresses, and metadata.
+                          The default value is "text".
+
+  -near=<node>            Sort the nodes by their estimated round trip time
+                          from the given node, nearest first. Use "_agent" for
+                          the node of the agent being queried. The default is
+                          to sort them by name.
+
+  -service=<name>         Only list the nodes providing the given service.
+
+  -wait=<duration>        Maximum time to wait for a change with -block. The
+                          servers may cap this. The default value is 10m.
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *CatalogNodesCommand) Run(args []string) (code int) {
+	cmdFlags, apiFlags := NewAPIFlagSet("nodes", c.Ui)
+	defer apiFlags.CheckTimeout(c.Ui, &code)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	block := cmdFlags.Bool("block", false, "")
+	detailed := cmdFlags.Bool("detailed", false, "")
+	format := cmdFlags.String("format", "text", "")
+	near := cmdFlags.String("near", "", "")
+	service := cmdFlags.String("service", "", "")
+	wait := cmdFlags.Duration("wait", 10*time.Minute, "")
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+
+	if args = cmdFlags.Args(); len(args) > 0 {
+		c.Ui.Error(fmt.Sprintf("Too many arguments (expected 0, got %d)", len(args)))
+		return 1
+	}
+	if *format != "text" && *format != "json" {
+		c.Ui.Error(fmt.Sprintf("Error! Unsupported format %q (expected text or json)", *format))
+		return 1
+	}
+	if *detailed && *format != "text" {
+		c.Ui.Error("Error! Cannot combine -detailed with -format=json, which always has the details")
+		return 1
+	}
+	if *wait <= 0 {
+		c.Ui.Error("Error! -wait must be positive")
+		return 1
+	}
+
+	// Create and test the HTTP client
+	client, err := apiFlags.Client()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+		return 1
+	}
+
+	q := apiFlags.QueryOptions()
+	q.Near = *near
+
+	var nodes []*api.Node
+	query := func(q *api.QueryOptions) (uint64, error) {
+		var qm *api.QueryMeta
+		var err error
+		if *service != "" {
+			nodes, qm, err = catalogServiceNodes(client, *service, q)
+		} else {
+			nodes, qm, err = client.Catalog().Nodes(q)
+		}
+		if err != nil {
+			return 0, err
+		}
+		return qm.LastIndex, nil
+	}
+	denial := catalogDenial("list nodes", "node")
+	if *service != "" {
+		denial = catalogDenial("list the nodes providing "+*service, "service")
+	}
+	if code := apiFlags.blockingQuery(c.Ui, denial, q, *block, *wait, query, nil); code != 0 {
+		return code
+	}
+
+	// The servers sort the nodes by distance with -near, so that order is
+	// kept.
+	if *near == "" {
+		sort.Sort(catalogNodesByName(nodes))
+	}
+
+	switch {
+	case *format == "json":
+		if nodes == nil {
+			nodes = []*api.Node{}
+		}
+		return printJSON(c.Ui, nodes)
+	case *detailed:
+		if len(nodes) == 0 {
+			return 0
+		}
+		result := []string{"Node|Address|Tagged Addresses|Meta"}
+		for _, node := range nodes {
+			result = append(result, fmt.Sprintf("%s|%s|%s|%s", node.Node, node.Address,
+				formatCatalogMap(node.TaggedAddresses), formatCatalogMap(node.Meta)))
+		}
+		c.Ui.Output(columnize.SimpleFormat(result))
+	default:
+		for _, node := range nodes {
+			c.Ui.Output(node.Node)
+		}
+	}
+	return 0
+}
+
+// catalogServiceNodes returns the nodes providing the given service, in the
+// order the servers gave them. A node with several instances of the service
+// is only listed once.
+func catalogServiceNodes(client *api.Client, service string, q *api.QueryOptions) ([]*api.Node, *api.QueryMeta, error) {
+	instances, qm, err := client.Catalog().Service(service, "", q)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var nodes []*api.Node
+	seen := make(map[string]struct{})
+	for _, instance := range instances {
+		if _, ok := seen[instance.Node]; ok {
+			continue
+		}
+		seen[instance.Node] = struct{}{}
+		nodes = append(nodes, &api.Node{
+			Node:            instance.Node,
+			Address:         instance.Address,
+			TaggedAddresses: instance.TaggedAddresses,
+			Meta:            instance.NodeMeta,
+		})
+	}
+	return nodes, qm, nil
+}
+
+// formatCatalogMap formats tagged addresses or metadata for a table, as
+// comma-separated key=value pairs sorted by key.
+func formatCatalogMap(m map[string]string) string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = k + "=" + m[k]
+	}
+	return strings.Join(pairs, ", ")
+}
+
+// catalogNodesByName sorts nodes by name.
+type catalogNodesByName []*api.Node
+
+func (n catalogNodesByName) Len() int           { return len(n) }
+func (n catalogNodesByName) Swap(i, j int)      { n[i], n[j] = n[j], n[i] }
+func (n catalogNodesByName) Less(i, j int) bool { return n[i].Node < n[j].Node }
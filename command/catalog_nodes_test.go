@@ -0,0 +1,164 @@
+package command
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+)
+
+func TestCatalogNodesCommand_implements(t *testing.T) {
+	var _ cli.Command = &CatalogNodesCommand{}
+}
+
+func TestCatalogNodesCommand_noTabs(t *testing.T) {
+	assertNoTabs(t, new(CatalogNodesCommand))
+}
+
+func TestCatalogNodesCommand_Validation(t *testing.T) {
+	cases := map[string]struct {
+		args   []string
+		output string
+	}{
+		"too many args": {
+			[]string{"foo"},
+			"Too many arguments",
+		},
+		"bad format": {
+			[]string{"-format=yaml"},
+			"Unsupported format",
+		},
+		"detailed json": {
+			[]string{"-detailed", "-format=json"},
+			"Cannot combine -detailed with -format=json",
+		},
+		"bad wait": {
+			[]string{"-block", "-wait=0s"},
+			"-wait must be positive",
+		},
+	}
+
+	for name, tc := range cases {
+		ui := new(cli.MockUi)
+		c := &CatalogNodesCommand{Ui: ui}
+
+		if code := c.Run(tc.args); code != 1 {
+			t.Fatalf("%s: bad: %d", name, code)
+		}
+		if output := ui.ErrorWriter.String(); !strings.Contains(output, tc.output) {
+			t.Fatalf("%s: expected %q to contain %q", name, output, tc.output)
+		}
+	}
+}
+
+func TestCatalogNodesCommand_Run(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+	testCatalogRegister(t, client)
+
+	self, err := client.Agent().NodeName()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	run := func(args ...string) string {
+		ui := new(cli.MockUi)
+		c := &CatalogNodesCommand{Ui: ui}
+		if code := c.Run(append([]string{"-http-addr=" + srv.httpAddr}, args...)); code != 0 {
+			t.Fatalf("%v: bad: %d. %#v", args, code, ui.ErrorWriter.String())
+		}
+		if ui.OutputWriter == nil {
+			return ""
+		}
+		return ui.OutputWriter.String()
+	}
+
+	// The agent's own node sorts first, since its name is capitalized.
+	if output := run(); output != self+"\ndb-1\nweb-1\nweb-2\n" {
+		t.Fatalf("bad: %#v", output)
+	}
+
+	// A node with several instances of the service is listed once.
+	if output := run("-service=web"); output != "db-1\nweb-1\nweb-2\n" {
+		t.Fatalf("bad: %#v", output)
+	}
+	if output := run("-service=nope"); output != "" {
+		t.Fatalf("bad: %#v", output)
+	}
+
+	output := run("-detailed", "-service=web")
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[0], "Node") ||
+		!strings.Contains(lines[2], "10.0.0.1") || !strings.HasSuffix(lines[2], "rack=r1") ||
+		!strings.Contains(lines[3], "lan=10.0.0.2, wan=198.18.0.2") {
+		t.Fatalf("bad: %#v", output)
+	}
+
+	var nodes []*api.Node
+	if err := json.Unmarshal([]byte(run("-format=json", "-service=web")), &nodes); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	expected := []*api.Node{
+		{Node: "db-1", Address: "10.0.0.3", TaggedAddresses: map[string]string{}, Meta: map[string]string{}},
+		{Node: "web-1", Address: "10.0.0.1", TaggedAddresses: map[string]string{}, Meta: map[string]string{"rack": "r1"}},
+		{Node: "web-2", Address: "10.0.0.2", TaggedAddresses: map[string]string{"lan": "10.0.0.2", "wan": "198.18.0.2"},
+			Meta: map[string]string{}},
+	}
+	for _, node := range nodes {
+		if node.TaggedAddresses == nil {
+			node.TaggedAddresses = map[string]string{}
+		}
+		if node.Meta == nil {
+			node.Meta = map[string]string{}
+		}
+	}
+	if !reflect.DeepEqual(nodes, expected) {
+		t.Fatalf("bad: %s", run("-format=json", "-service=web"))
+	}
+	if output := run("-format=json", "-service=nope"); output != "[]\n" {
+		t.Fatalf("bad: %#v", output)
+	}
+
+	// Sorting by distance keeps every node.
+	if lines := strings.Split(strings.TrimSpace(run("-near=_agent")), "\n"); len(lines) != 4 {
+		t.Fatalf("bad: %#v", lines)
+	}
+}
+
+func TestCatalogNodesCommand_Block(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	// With no change, the wait times out.
+	ui := new(cli.MockUi)
+	c := &CatalogNodesCommand{Ui: ui}
+	if code := c.Run([]string{"-http-addr=" + srv.httpAddr, "-block", "-wait=200ms"}); code != 2 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if output := ui.ErrorWriter.String(); !strings.Contains(output, "Timed out after 200ms") {
+		t.Fatalf("bad: %#v", output)
+	}
+
+	// A new node ends the wait.
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		reg := &api.CatalogRegistration{Datacenter: "dc1", Node: "late", Address: "10.0.0.9"}
+		if _, err := client.Catalog().Register(reg, nil); err != nil {
+			t.Errorf("err: %v", err)
+		}
+	}()
+	ui = new(cli.MockUi)
+	c = &CatalogNodesCommand{Ui: ui}
+	if code := c.Run([]string{"-http-addr=" + srv.httpAddr, "-block", "-wait=10s"}); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if output := ui.OutputWriter.String(); !strings.HasSuffix(output, "\nlate\n") {
+		t.Fatalf("bad: %#v", output)
+	}
+}
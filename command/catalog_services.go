@@ -0,0 +1,188 @@
+package command
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+	"github.com/ryanuber/columnize"
+)
+
+// CatalogServicesCommand is a Command implementation that is used to list the
+// services in the catalog.
+type CatalogServicesCommand struct {
+	Ui cli.Ui
+}
+
+func (c *CatalogServicesCommand) Synopsis() string {
+	return "Lists the services in the catalog"
+}
+
+func (c *CatalogServicesCommand) Help() string {
+	helpText := `
+Usage: consul catalog services [options]
+
+  Lists the services registered in the catalog, one per line, sorted by name:
+
+      $ consul catalog services
+
+  To list the services provided by a node, with their tags:
+
+      $ consul catalog services -node=web-1 -tags
+
+  For a full list of options and examples, please see the Consul documentation.
+
+` + apiOptsText + `
+
+Catalog Services Options:
+
+  -block                  Wait for the services to change before printing
+                          them, using a blocking query. If there is no change
+                          within the -wait time, the command exits with
+                          status 2. The default value is false.
+
+  -format=<string>        Output format, either "text" or "json". With "json",
+                          the services are printed as an object mapping each
+                          service name to its sorted tags. The default value
+                          is "text".
+
+  -node=<name>            Only list the services provided by the given node.
+                          If there's no such node, the command exits with
+                          status 2.
+
+  -tags                   Print the tags of each service after its name. The
+                          tags of every instance of a service are merged. The
+                          default value is false.
+
+  -wait=<duration>        Maximum time to wait for a change with -block. The
+                          servers may cap this. The default value is 10m.
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *CatalogServicesCommand) Run(args []string) (code int) {
+	cmdFlags, apiFlags := NewAPIFlagSet("services", c.Ui)
+	defer apiFlags.CheckTimeout(c.Ui, &code)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	block := cmdFlags.Bool("block", false, "")
+	format := cmdFlags.String("format", "text", "")
+	node := cmdFlags.String("node", "", "")
+	tags := cmdFlags.Bool("tags", false, "")
+	wait := cmdFlags.Duration("wait", 10*time.Minute, "")
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+
+	if args = cmdFlags.Args(); len(args) > 0 {
+		c.Ui.Error(fmt.Sprintf("Too many arguments (expected 0, got %d)", len(args)))
+		return 1
+	}
+	if *format != "text" && *format != "json" {
+		c.Ui.Error(fmt.Sprintf("Error! Unsupported format %q (expected text or json)", *format))
+		return 1
+	}
+	if *tags && *format != "text" {
+		c.Ui.Error("Error! Cannot combine -tags with -format=json, which always has the tags")
+		return 1
+	}
+	if *wait <= 0 {
+		c.Ui.Error("Error! -wait must be positive")
+		return 1
+	}
+
+	// Create and test the HTTP client
+	client, err := apiFlags.Client()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+		return 1
+	}
+
+	// The services are kept as a map from each name to its tags, which is
+	// what the catalog gives back when listing every service.
+	var services map[string][]string
+	found := true
+	query := func(q *api.QueryOptions) (uint64, error) {
+		if *node == "" {
+			var qm *api.QueryMeta
+			var err error
+			services, qm, err = client.Catalog().Services(q)
+			if err != nil {
+				return 0, err
+			}
+			return qm.LastIndex, nil
+		}
+
+		catalogNode, qm, err := client.Catalog().Node(*node, q)
+		if err != nil {
+			return 0, err
+		}
+		services, found = nil, catalogNode != nil
+		if found {
+			services = make(map[string][]string)
+			for _, service := range catalogNode.Services {
+				services[service.Service] = append(services[service.Service], service.Tags...)
+			}
+		}
+		return qm.LastIndex, nil
+	}
+	denial := catalogDenial("list services", "service")
+	if *node != "" {
+		denial = catalogDenial("list the services on "+*node, "node")
+	}
+	if code := apiFlags.blockingQuery(c.Ui, denial, apiFlags.QueryOptions(), *block, *wait,
+		query, nil); code != 0 {
+		return code
+	}
+	if !found {
+		c.Ui.Error(fmt.Sprintf("Error! No node named %q in the catalog", *node))
+		return exitNotFound
+	}
+
+	names := make([]string, 0, len(services))
+	for name, serviceTags := range services {
+		names = append(names, name)
+		services[name] = uniqueSortedStrings(serviceTags)
+	}
+	sort.Strings(names)
+
+	switch {
+	case *format == "json":
+		if services == nil {
+			services = make(map[string][]string)
+		}
+		return printJSON(c.Ui, services)
+	case *tags:
+		if len(names) == 0 {
+			return 0
+		}
+		result := []string{"Service|Tags"}
+		for _, name := range names {
+			result = append(result, fmt.Sprintf("%s|%s", name, strings.Join(services[name], ",")))
+		}
+		c.Ui.Output(columnize.SimpleFormat(result))
+	default:
+		for _, name := range names {
+			c.Ui.Output(name)
+		}
+	}
+	return 0
+}
+
+// uniqueSortedStrings returns the strings sorted, without duplicates. The
+// result is never nil, so it's printed as an empty array in JSON.
+func uniqueSortedStrings(in []string) []string {
+	out := make([]string, 0, len(in))
+	seen := make(map[string]struct{}, len(in))
+	for _, s := range in {
+		if _, ok := seen[s]; ok {
+			continue
+		}
+		seen[s] = struct{}{}
+		out = append(out, s)
+	}
+	sort.Strings(out)
+	return out
+}
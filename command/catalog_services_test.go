@@ -0,0 +1,108 @@
+package command
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/cli"
+)
+
+func TestCatalogServicesCommand_implements(t *testing.T) {
+	var _ cli.Command = &CatalogServicesCommand{}
+}
+
+func TestCatalogServicesCommand_noTabs(t *testing.T) {
+	assertNoTabs(t, new(CatalogServicesCommand))
+}
+
+func TestCatalogServicesCommand_Validation(t *testing.T) {
+	cases := map[string]struct {
+		args   []string
+		output string
+	}{
+		"too many args": {
+			[]string{"foo"},
+			"Too many arguments",
+		},
+		"bad format": {
+			[]string{"-format=yaml"},
+			"Unsupported format",
+		},
+		"tags json": {
+			[]string{"-tags", "-format=json"},
+			"Cannot combine -tags with -format=json",
+		},
+		"bad wait": {
+			[]string{"-block", "-wait=-1s"},
+			"-wait must be positive",
+		},
+	}
+
+	for name, tc := range cases {
+		ui := new(cli.MockUi)
+		c := &CatalogServicesCommand{Ui: ui}
+
+		if code := c.Run(tc.args); code != 1 {
+			t.Fatalf("%s: bad: %d", name, code)
+		}
+		if output := ui.ErrorWriter.String(); !strings.Contains(output, tc.output) {
+			t.Fatalf("%s: expected %q to contain %q", name, output, tc.output)
+		}
+	}
+}
+
+func TestCatalogServicesCommand_Run(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+	testCatalogRegister(t, client)
+
+	run := func(args ...string) (int, string, string) {
+		ui := new(cli.MockUi)
+		c := &CatalogServicesCommand{Ui: ui}
+		code := c.Run(append([]string{"-http-addr=" + srv.httpAddr}, args...))
+		return code, ui.OutputWriter.String(), ui.ErrorWriter.String()
+	}
+
+	cases := map[string]struct {
+		args   []string
+		output string
+	}{
+		"all": {
+			[]string{},
+			"consul\ndb\nweb\n",
+		},
+		"node": {
+			[]string{"-node=db-1"},
+			"db\nweb\n",
+		},
+		"tags": {
+			[]string{"-tags", "-node=db-1"},
+			"Service  Tags\ndb       \nweb      canary,v1\n",
+		},
+		"json": {
+			[]string{"-format=json", "-node=web-2"},
+			"{\n  \"web\": [\n    \"primary\",\n    \"v2\"\n  ]\n}\n",
+		},
+	}
+	for name, tc := range cases {
+		code, output, errors := run(tc.args...)
+		if code != 0 {
+			t.Fatalf("%s: bad: %d. %#v", name, code, errors)
+		}
+		if output != tc.output {
+			t.Fatalf("%s: bad: %#v", name, output)
+		}
+	}
+
+	// Tags are merged across every instance.
+	_, output, _ := run("-tags")
+	if !strings.Contains(output, "web      canary,primary,v1,v2\n") {
+		t.Fatalf("bad: %#v", output)
+	}
+
+	code, output, errors := run("-node=nope")
+	if code != 2 || output != "" || !strings.Contains(errors, `No node named "nope"`) {
+		t.Fatalf("bad: %d %#v %#v", code, output, errors)
+	}
+}
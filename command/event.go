@@ -24,6 +24,10 @@ Usage: consul event [options] [payload]
   a name, but a payload is optional. Events support filtering using
   regular expressions on node name, service, and tag definitions.
 
+  The fire subcommand takes the name as an argument instead of -name, and
+  can read the payload from a file or stdin. The list subcommand shows the
+  recent events.
+
 Options:
 
   -http-addr=127.0.0.1:8500  HTTP address of the Consul agent.
@@ -121,6 +125,10 @@ func (c *EventCommand) Run(args []string) int {
 		ServiceFilter: service,
 		TagFilter:     tag,
 	}
+	if err := checkEventSize(params); err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
 	opts := &consulapi.WriteOptions{
 		Datacenter: datacenter,
 		Token:      token,
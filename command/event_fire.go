@@ -0,0 +1,221 @@
+package command
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/command/agent"
+	"github.com/hashicorp/go-msgpack/codec"
+	"github.com/hashicorp/serf/serf"
+	"github.com/mitchellh/cli"
+)
+
+const (
+	// eventNamePrefix is put in front of the name of a user event by the
+	// servers before it's sent over gossip, where it counts towards the
+	// size limit.
+	eventNamePrefix = "consul:event:"
+
+	// eventIDLength is the length of the UUID the agent gives each event.
+	eventIDLength = 36
+)
+
+// EventFireCommand is a Command implementation that is used to fire a user
+// event.
+type EventFireCommand struct {
+	Ui cli.Ui
+
+	// testStdin is the input for testing.
+	testStdin io.Reader
+}
+
+func (c *EventFireCommand) Synopsis() string {
+	return "Fires a new user event"
+}
+
+func (c *EventFireCommand) Help() string {
+	helpText := `
+Usage: consul event fire [options] NAME [PAYLOAD]
+
+  Fires a user event with the given name across a datacenter, and prints the
+  ID of the event. The payload is optional, and is read from a file if
+  prefixed with "@", or from stdin if "-":
+
+      $ consul event fire deploy @release.json
+
+  The nodes which handle the event can be limited with regular expressions on
+  the names of the nodes, and on the services and tags they provide:
+
+      $ consul event fire -service=web -tag=canary deploy v1.2.3
+
+  Events are sent over gossip, which limits the size of an event to 512 bytes
+  once it's encoded, including the name and filters. The payload should be
+  kept small, and an event which is too large is rejected before it's sent.
+
+  For a full list of options and examples, please see the Consul documentation.
+
+` + apiOptsText + `
+
+Event Fire Options:
+
+  -node=<regexp>          Regular expression to filter on node names.
+
+  -service=<regexp>       Regular expression to filter on the names of the
+                          services on a node.
+
+  -tag=<regexp>           Regular expression to filter on the tags of the
+                          services on a node. Must be used with -service.
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *EventFireCommand) Run(args []string) (code int) {
+	cmdFlags, apiFlags := NewAPIFlagSet("fire", c.Ui)
+	defer apiFlags.CheckTimeout(c.Ui, &code)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	node := cmdFlags.String("node", "", "")
+	service := cmdFlags.String("service", "", "")
+	tag := cmdFlags.String("tag", "", "")
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+
+	params := &api.UserEvent{
+		NodeFilter:    *node,
+		ServiceFilter: *service,
+		TagFilter:     *tag,
+	}
+	args = cmdFlags.Args()
+	switch len(args) {
+	case 0:
+		c.Ui.Error("Error! Missing NAME argument")
+		return 1
+	case 1:
+		params.Name = args[0]
+	case 2:
+		params.Name = args[0]
+		var stdin io.Reader = os.Stdin
+		if c.testStdin != nil {
+			stdin = c.testStdin
+		}
+		payload, err := eventPayload(args[1], stdin)
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Error! %s", err))
+			return 1
+		}
+		params.Payload = payload
+	default:
+		c.Ui.Error(fmt.Sprintf("Too many arguments (expected 1 or 2, got %d)", len(args)))
+		return 1
+	}
+
+	if params.Name == "" {
+		c.Ui.Error("Error! The event name can't be empty")
+		return 1
+	}
+	if err := checkEventFilters(params); err != nil {
+		c.Ui.Error(fmt.Sprintf("Error! %s", err))
+		return 1
+	}
+	if err := checkEventSize(params); err != nil {
+		c.Ui.Error(fmt.Sprintf("Error! %s", err))
+		return 1
+	}
+
+	// Create and test the HTTP client
+	client, err := apiFlags.Client()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+		return 1
+	}
+
+	id, _, err := client.Event().Fire(params, apiFlags.WriteOptions())
+	if err != nil {
+		c.Ui.Error(apiFlags.errorMessage("Error firing event", err,
+			eventDenial("fire "+params.Name, params.Name, "write")))
+		return exitCommError
+	}
+
+	c.Ui.Output(fmt.Sprintf("Event ID: %s", id))
+	return 0
+}
+
+// eventPayload returns the payload given by the argument, which is read from
+// a file if prefixed with "@", or from stdin if "-".
+func eventPayload(arg string, stdin io.Reader) ([]byte, error) {
+	switch {
+	case arg == "-":
+		var b bytes.Buffer
+		if _, err := io.Copy(&b, stdin); err != nil {
+			return nil, fmt.Errorf("Failed to read stdin: %s", err)
+		}
+		return b.Bytes(), nil
+	case strings.HasPrefix(arg, "@"):
+		data, err := ioutil.ReadFile(arg[1:])
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read file: %s", err)
+		}
+		return data, nil
+	default:
+		return []byte(arg), nil
+	}
+}
+
+// checkEventFilters checks the filters of an event the same way the agent
+// does, so that a bad filter is caught before the event is sent.
+func checkEventFilters(params *api.UserEvent) error {
+	if params.TagFilter != "" && params.ServiceFilter == "" {
+		return fmt.Errorf("Cannot provide tag filter without service filter")
+	}
+	filters := []struct {
+		kind string
+		re   string
+	}{
+		{"node", params.NodeFilter},
+		{"service", params.ServiceFilter},
+		{"tag", params.TagFilter},
+	}
+	for _, filter := range filters {
+		if filter.re == "" {
+			continue
+		}
+		if _, err := regexp.Compile(filter.re); err != nil {
+			return fmt.Errorf("Invalid %s filter: %v", filter.kind, err)
+		}
+	}
+	return nil
+}
+
+// checkEventSize returns an error if the event would be too large to send
+// over gossip. The limit covers the name of the event along with the whole
+// event encoded by the agent, so the event is encoded the same way here,
+// with a stand-in for the ID the agent gives it.
+func checkEventSize(params *api.UserEvent) error {
+	event := &agent.UserEvent{
+		ID:            strings.Repeat("0", eventIDLength),
+		Name:          params.Name,
+		Payload:       params.Payload,
+		NodeFilter:    params.NodeFilter,
+		ServiceFilter: params.ServiceFilter,
+		TagFilter:     params.TagFilter,
+		Version:       1,
+	}
+	var buf bytes.Buffer
+	handle := &codec.MsgpackHandle{RawToString: true, WriteExt: true}
+	if err := codec.NewEncoder(&buf, handle).Encode(event); err != nil {
+		return fmt.Errorf("Failed to encode event: %s", err)
+	}
+
+	size := len(eventNamePrefix) + len(params.Name) + buf.Len()
+	if size > serf.UserEventSizeLimit {
+		return fmt.Errorf("Event is too large (%d > %d bytes once encoded, with a %d byte payload)",
+			size, serf.UserEventSizeLimit, len(params.Payload))
+	}
+	return nil
+}
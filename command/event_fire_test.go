@@ -0,0 +1,176 @@
+package command
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/testutil"
+	"github.com/mitchellh/cli"
+)
+
+func TestEventFireCommand_implements(t *testing.T) {
+	var _ cli.Command = &EventFireCommand{}
+}
+
+func TestEventFireCommand_noTabs(t *testing.T) {
+	assertNoTabs(t, new(EventFireCommand))
+}
+
+func TestEventFireCommand_Validation(t *testing.T) {
+	cases := map[string]struct {
+		args   []string
+		output string
+	}{
+		"no name": {
+			[]string{},
+			"Missing NAME argument",
+		},
+		"empty name": {
+			[]string{""},
+			"name can't be empty",
+		},
+		"too many args": {
+			[]string{"deploy", "v1", "v2"},
+			"Too many arguments",
+		},
+		"tag without service": {
+			[]string{"-tag=canary", "deploy"},
+			"Cannot provide tag filter without service filter",
+		},
+		"bad node filter": {
+			[]string{"-node=web-(", "deploy"},
+			"Invalid node filter",
+		},
+		"missing file": {
+			[]string{"deploy", "@/nope/missing"},
+			"Failed to read file",
+		},
+		"too large": {
+			[]string{"deploy", strings.Repeat("x", 512)},
+			"Event is too large",
+		},
+	}
+
+	for name, tc := range cases {
+		ui := new(cli.MockUi)
+		c := &EventFireCommand{Ui: ui}
+
+		if code := c.Run(tc.args); code != 1 {
+			t.Fatalf("%s: bad: %d", name, code)
+		}
+		if output := ui.ErrorWriter.String(); !strings.Contains(output, tc.output) {
+			t.Fatalf("%s: expected %q to contain %q", name, output, tc.output)
+		}
+	}
+}
+
+func TestEventFireCommand_Run(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	// The agent only keeps an event which passes its filters.
+	service := &api.AgentServiceRegistration{Name: "web", Tags: []string{"canary"}}
+	if err := client.Agent().ServiceRegister(service); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	f, err := ioutil.TempFile("", "consul-event")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString("from a file"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	f.Close()
+
+	cases := []struct {
+		args    []string
+		stdin   string
+		payload string
+	}{
+		{[]string{"plain"}, "", ""},
+		{[]string{"arg", "from an arg"}, "", "from an arg"},
+		{[]string{"file", "@" + f.Name()}, "", "from a file"},
+		{[]string{"stdin", "-"}, "from stdin", "from stdin"},
+		{[]string{"-service=web", "-tag=canary", "filtered", "v2"}, "", "v2"},
+	}
+
+	ids := make(map[string]string)
+	for _, tc := range cases {
+		ui := new(cli.MockUi)
+		c := &EventFireCommand{Ui: ui, testStdin: strings.NewReader(tc.stdin)}
+
+		args := append([]string{"-http-addr=" + srv.httpAddr}, tc.args...)
+		if code := c.Run(args); code != 0 {
+			t.Fatalf("%v: bad: %d. %#v", tc.args, code, ui.ErrorWriter.String())
+		}
+		output := ui.OutputWriter.String()
+		if !strings.HasPrefix(output, "Event ID: ") {
+			t.Fatalf("%v: bad: %#v", tc.args, output)
+		}
+		ids[strings.TrimSpace(strings.TrimPrefix(output, "Event ID: "))] = tc.payload
+	}
+
+	testutil.WaitForResult(func() (bool, error) {
+		events, _, err := client.Event().List("", nil)
+		if err != nil {
+			return false, err
+		}
+		if len(events) != len(ids) {
+			return false, fmt.Errorf("bad: %#v", events)
+		}
+		for _, event := range events {
+			payload, ok := ids[event.ID]
+			if !ok || string(event.Payload) != payload {
+				return false, fmt.Errorf("bad: %#v", event)
+			}
+		}
+		return true, nil
+	}, func(err error) {
+		t.Fatalf("err: %v", err)
+	})
+}
+
+func TestEventFireCommand_sizeLimit(t *testing.T) {
+	srv := testAgent(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	// Find the largest payload which passes the check, which must be taken
+	// by the agent, while one more byte must not pass.
+	params := &api.UserEvent{Name: "size", ServiceFilter: "web"}
+	var payload string
+	for n := 1; ; n++ {
+		params.Payload = []byte(strings.Repeat("x", n))
+		if err := checkEventSize(params); err != nil {
+			break
+		}
+		payload = string(params.Payload)
+	}
+	if len(payload) < 300 {
+		t.Fatalf("bad: %d", len(payload))
+	}
+
+	ui := new(cli.MockUi)
+	c := &EventFireCommand{Ui: ui}
+	args := []string{"-http-addr=" + srv.httpAddr, "-service=web", "size", payload}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+
+	ui = new(cli.MockUi)
+	c = &EventFireCommand{Ui: ui}
+	args = []string{"-http-addr=" + srv.httpAddr, "-service=web", "size", payload + "x"}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("bad: %d", code)
+	}
+	if output := ui.ErrorWriter.String(); !strings.Contains(output, "Event is too large") {
+		t.Fatalf("bad: %#v", output)
+	}
+}
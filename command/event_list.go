@@ -0,0 +1,138 @@
+package command
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/mitchellh/cli"
+	"github.com/ryanuber/columnize"
+)
+
+// EventListCommand is a Command implementation that is used to list the
+// recent user events.
+type EventListCommand struct {
+	Ui cli.Ui
+}
+
+func (c *EventListCommand) Synopsis() string {
+	return "Lists the recent user events"
+}
+
+func (c *EventListCommand) Help() string {
+	helpText := `
+Usage: consul event list [options]
+
+  Lists the user events most recently received by the agent, oldest first,
+  with their payloads. Events are sent over gossip and aren't stored by the
+  servers, so each agent only knows about the last 256 events it received.
+
+      $ consul event list -name=deploy
+
+  For a full list of options and examples, please see the Consul documentation.
+
+` + apiOptsText + `
+
+Event List Options:
+
+  -format=<string>        Output format, either "text" or "json". With "json",
+                          the events are printed as an array of objects, with
+                          the payloads as strings. The default value is
+                          "text".
+
+  -name=<name>            Only list the events with the given name.
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *EventListCommand) Run(args []string) (code int) {
+	cmdFlags, apiFlags := NewAPIFlagSet("list", c.Ui)
+	defer apiFlags.CheckTimeout(c.Ui, &code)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	format := cmdFlags.String("format", "text", "")
+	name := cmdFlags.String("name", "", "")
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+
+	if args = cmdFlags.Args(); len(args) > 0 {
+		c.Ui.Error(fmt.Sprintf("Too many arguments (expected 0, got %d)", len(args)))
+		return 1
+	}
+	if *format != "text" && *format != "json" {
+		c.Ui.Error(fmt.Sprintf("Error! Unsupported format %q (expected text or json)", *format))
+		return 1
+	}
+
+	// Create and test the HTTP client
+	client, err := apiFlags.Client()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+		return 1
+	}
+
+	events, _, err := client.Event().List(*name, apiFlags.QueryOptions())
+	if err != nil {
+		denial := eventDenial("list events", "", "read")
+		if *name != "" {
+			denial = eventDenial("list the "+*name+" events", *name, "read")
+		}
+		c.Ui.Error(apiFlags.errorMessage("Error querying Consul agent", err, denial))
+		return exitCommError
+	}
+
+	if *format == "json" {
+		// The payloads are decoded, rather than left as base64 like the
+		// HTTP API gives them.
+		entries := make([]eventListEntry, len(events))
+		for i, event := range events {
+			entries[i] = eventListEntry{
+				ID:            event.ID,
+				Name:          event.Name,
+				Payload:       string(event.Payload),
+				NodeFilter:    event.NodeFilter,
+				ServiceFilter: event.ServiceFilter,
+				TagFilter:     event.TagFilter,
+				Version:       event.Version,
+				LTime:         event.LTime,
+			}
+		}
+		return printJSON(c.Ui, entries)
+	}
+
+	if len(events) == 0 {
+		return 0
+	}
+	result := []string{"ID|Name|Payload"}
+	for _, event := range events {
+		result = append(result, fmt.Sprintf("%s|%s|%s", event.ID, event.Name, formatEventPayload(event.Payload)))
+	}
+	c.Ui.Output(columnize.SimpleFormat(result))
+	return 0
+}
+
+// eventListEntry is an event printed with -format=json.
+type eventListEntry struct {
+	ID            string
+	Name          string
+	Payload       string
+	NodeFilter    string
+	ServiceFilter string
+	TagFilter     string
+	Version       int
+	LTime         uint64
+}
+
+// formatEventPayload formats a payload for a table. A payload which isn't
+// printable text on one line is quoted, with any "|" escaped as well, so it
+// can't break up the table.
+func formatEventPayload(payload []byte) string {
+	s := string(payload)
+	if utf8.Valid(payload) && !strings.ContainsRune(s, '|') &&
+		strings.IndexFunc(s, func(r rune) bool { return !unicode.IsPrint(r) }) < 0 {
+		return s
+	}
+	return strings.Replace(strconv.Quote(s), "|", `\x7c`, -1)
+}
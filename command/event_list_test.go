@@ -0,0 +1,116 @@
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/testutil"
+	"github.com/mitchellh/cli"
+)
+
+func TestEventListCommand_implements(t *testing.T) {
+	var _ cli.Command = &EventListCommand{}
+}
+
+func TestEventListCommand_noTabs(t *testing.T) {
+	assertNoTabs(t, new(EventListCommand))
+}
+
+func TestEventListCommand_Validation(t *testing.T) {
+	cases := map[string]struct {
+		args   []string
+		output string
+	}{
+		"too many args": {
+			[]string{"deploy"},
+			"Too many arguments",
+		},
+		"bad format": {
+			[]string{"-format=yaml"},
+			"Unsupported format",
+		},
+	}
+
+	for name, tc := range cases {
+		ui := new(cli.MockUi)
+		c := &EventListCommand{Ui: ui}
+
+		if code := c.Run(tc.args); code != 1 {
+			t.Fatalf("%s: bad: %d", name, code)
+		}
+		if output := ui.ErrorWriter.String(); !strings.Contains(output, tc.output) {
+			t.Fatalf("%s: expected %q to contain %q", name, output, tc.output)
+		}
+	}
+}
+
+func TestEventListCommand_Run(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	// Nothing is printed before any events are fired.
+	ui := new(cli.MockUi)
+	c := &EventListCommand{Ui: ui}
+	if code := c.Run([]string{"-http-addr=" + srv.httpAddr}); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if ui.OutputWriter != nil && ui.OutputWriter.String() != "" {
+		t.Fatalf("bad: %#v", ui.OutputWriter.String())
+	}
+
+	for _, event := range []*api.UserEvent{
+		{Name: "deploy", Payload: []byte("v1.2.3")},
+		{Name: "restart", Payload: []byte("a|b\n")},
+	} {
+		fire := &EventFireCommand{Ui: new(cli.MockUi)}
+		if code := fire.Run([]string{"-http-addr=" + srv.httpAddr, event.Name, string(event.Payload)}); code != 0 {
+			t.Fatalf("bad: %d", code)
+		}
+	}
+	testutil.WaitForResult(func() (bool, error) {
+		events, _, err := client.Event().List("", nil)
+		if err != nil {
+			return false, err
+		}
+		return len(events) == 2, fmt.Errorf("bad: %#v", events)
+	}, func(err error) {
+		t.Fatalf("err: %v", err)
+	})
+
+	// The payloads are printed in a table, quoting one which would break
+	// it up.
+	ui = new(cli.MockUi)
+	c = &EventListCommand{Ui: ui}
+	if code := c.Run([]string{"-http-addr=" + srv.httpAddr}); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	lines := strings.Split(strings.TrimSpace(ui.OutputWriter.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "ID") {
+		t.Fatalf("bad: %#v", lines)
+	}
+	if !strings.Contains(lines[1], "deploy") || !strings.HasSuffix(lines[1], "  v1.2.3") {
+		t.Fatalf("bad: %#v", lines[1])
+	}
+	if !strings.Contains(lines[2], "restart") || !strings.HasSuffix(lines[2], `  "a\x7cb\n"`) {
+		t.Fatalf("bad: %#v", lines[2])
+	}
+
+	// The JSON output can be filtered by name, and has the payload
+	// decoded.
+	ui = new(cli.MockUi)
+	c = &EventListCommand{Ui: ui}
+	if code := c.Run([]string{"-http-addr=" + srv.httpAddr, "-format=json", "-name=restart"}); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	var entries []eventListEntry
+	if err := json.Unmarshal(ui.OutputWriter.Bytes(), &entries); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name != "restart" || entries[0].Payload != "a|b\n" || entries[0].ID == "" {
+		t.Fatalf("bad: %#v", entries)
+	}
+}
@@ -0,0 +1,87 @@
+package command
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mitchellh/cli"
+)
+
+// HealthChecksCommand is a Command implementation that is used to list the
+// health checks of a service.
+type HealthChecksCommand struct {
+	Ui cli.Ui
+}
+
+func (c *HealthChecksCommand) Synopsis() string {
+	return "Lists the health checks of a service"
+}
+
+func (c *HealthChecksCommand) Help() string {
+	helpText := `
+Usage: consul health checks [options] SERVICE
+
+  Lists the health checks of every instance of the given service, printed as
+  a table sorted by node and check ID:
+
+      $ consul health checks redis
+
+  Only the checks registered against the service are listed, not those of
+  the nodes it runs on. Use "consul health service" to see both.
+
+  For a full list of options and examples, please see the Consul documentation.
+
+` + apiOptsText + `
+
+Health Checks Options:
+
+  -format=<string>        Output format, either "text" or "json". With "json",
+                          the checks are printed as an array of objects,
+                          including the output of each check. The default
+                          value is "text".
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *HealthChecksCommand) Run(args []string) (code int) {
+	cmdFlags, apiFlags := NewAPIFlagSet("checks", c.Ui)
+	defer apiFlags.CheckTimeout(c.Ui, &code)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	format := cmdFlags.String("format", "text", "")
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+
+	var service string
+	args = cmdFlags.Args()
+	switch len(args) {
+	case 0:
+		c.Ui.Error("Error! Missing SERVICE argument")
+		return 1
+	case 1:
+		service = args[0]
+	default:
+		c.Ui.Error(fmt.Sprintf("Too many arguments (expected 1, got %d)", len(args)))
+		return 1
+	}
+
+	if *format != "text" && *format != "json" {
+		c.Ui.Error(fmt.Sprintf("Error! Unsupported format %q (expected text or json)", *format))
+		return 1
+	}
+
+	// Create and test the HTTP client
+	client, err := apiFlags.Client()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+		return 1
+	}
+
+	checks, _, err := client.Health().Checks(service, apiFlags.QueryOptions())
+	if err != nil {
+		c.Ui.Error(apiFlags.errorMessage("Error querying Consul agent", err,
+			catalogDenial("list the checks of "+service, "service")))
+		return exitCommError
+	}
+	return printHealthChecks(c.Ui, checks, *format)
+}
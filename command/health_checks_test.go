@@ -0,0 +1,80 @@
+package command
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/cli"
+)
+
+func TestHealthChecksCommand_implements(t *testing.T) {
+	var _ cli.Command = &HealthChecksCommand{}
+}
+
+func TestHealthChecksCommand_noTabs(t *testing.T) {
+	assertNoTabs(t, new(HealthChecksCommand))
+}
+
+func TestHealthChecksCommand_Validation(t *testing.T) {
+	cases := map[string]struct {
+		args   []string
+		output string
+	}{
+		"no service": {
+			[]string{},
+			"Missing SERVICE argument",
+		},
+		"too many args": {
+			[]string{"web", "db"},
+			"Too many arguments",
+		},
+		"bad format": {
+			[]string{"-format=yaml", "web"},
+			"Unsupported format",
+		},
+	}
+
+	for name, tc := range cases {
+		ui := new(cli.MockUi)
+		c := &HealthChecksCommand{Ui: ui}
+
+		if code := c.Run(tc.args); code != 1 {
+			t.Fatalf("%s: bad: %d", name, code)
+		}
+		if output := ui.ErrorWriter.String(); !strings.Contains(output, tc.output) {
+			t.Fatalf("%s: expected %q to contain %q", name, output, tc.output)
+		}
+	}
+}
+
+func TestHealthChecksCommand_Run(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+	testHealthRegister(t, client)
+
+	ui := new(cli.MockUi)
+	c := &HealthChecksCommand{Ui: ui}
+	if code := c.Run([]string{"-http-addr=" + srv.httpAddr, "web"}); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+
+	// The node's own check isn't included.
+	output := ui.OutputWriter.String()
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) != 3 ||
+		strings.Join(strings.Fields(lines[1]), " ") != "web-1 service:web web check passing web" ||
+		strings.Join(strings.Fields(lines[2]), " ") != "web-2 service:web web check critical web" {
+		t.Fatalf("bad: %#v", output)
+	}
+
+	// An unknown service has no checks.
+	ui = new(cli.MockUi)
+	c = &HealthChecksCommand{Ui: ui}
+	if code := c.Run([]string{"-http-addr=" + srv.httpAddr, "-format=json", "nope"}); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if output := ui.OutputWriter.String(); output != "[]\n" {
+		t.Fatalf("bad: %#v", output)
+	}
+}
@@ -0,0 +1,47 @@
+package command
+
+import (
+	"strings"
+
+	"github.com/mitchellh/cli"
+)
+
+// HealthCommand is a Command implementation that just shows help for
+// the subcommands nested below it.
+type HealthCommand struct {
+	Ui cli.Ui
+}
+
+func (c *HealthCommand) Run(args []string) int {
+	return cli.RunResultHelp
+}
+
+func (c *HealthCommand) Help() string {
+	helpText := `
+Usage: consul health <subcommand> [options] [args]
+
+  This command has subcommands for querying the health of the nodes and
+  services in the catalog. Each prints a table by default, or JSON with
+  -format=json.
+
+  List the checks which are critical:
+
+      $ consul health state critical
+
+  List the checks for a service:
+
+      $ consul health checks redis
+
+  List the instances of a service, failing if none are passing:
+
+      $ consul health service -passing-only redis
+
+  For more examples, ask for subcommand help or view the documentation.
+
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *HealthCommand) Synopsis() string {
+	return "Queries the health of nodes and services"
+}
@@ -0,0 +1,53 @@
+package command
+
+import (
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+)
+
+func TestHealthCommand_implements(t *testing.T) {
+	var _ cli.Command = &HealthCommand{}
+}
+
+func TestHealthCommand_noTabs(t *testing.T) {
+	assertNoTabs(t, new(HealthCommand))
+}
+
+// testHealthRegister registers the nodes and checks for the health tests:
+// "web" runs on web-1, where it's passing, and on web-2, where it's critical
+// and the node itself has a warning.
+func testHealthRegister(t *testing.T, client *api.Client) {
+	regs := []*api.CatalogRegistration{
+		{
+			Node:    "web-1",
+			Address: "10.0.0.1",
+			Service: &api.AgentService{ID: "web", Service: "web", Tags: []string{"v1"}, Port: 8080},
+			Check: &api.AgentCheck{CheckID: "service:web", Name: "web check", Status: api.HealthPassing,
+				ServiceID: "web"},
+		},
+		{
+			Node:    "web-2",
+			Address: "10.0.0.2",
+			Service: &api.AgentService{ID: "web", Service: "web", Tags: []string{"v2"}, Port: 8080,
+				Address: "10.0.1.2"},
+			Check: &api.AgentCheck{CheckID: "service:web", Name: "web check", Status: api.HealthCritical,
+				Output: "connection refused", ServiceID: "web"},
+		},
+		{
+			Node:    "web-2",
+			Address: "10.0.0.2",
+			Check:   &api.AgentCheck{CheckID: "mem", Name: "memory", Status: api.HealthWarning},
+		},
+	}
+	for _, reg := range regs {
+		reg.Datacenter = "dc1"
+		if reg.Check != nil {
+			reg.Check.Node = reg.Node
+		}
+		if _, err := client.Catalog().Register(reg, nil); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package command
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+	"github.com/ryanuber/columnize"
+)
+
+// HealthServiceCommand is a Command implementation that is used to list the
+// instances of a service along with their health.
+type HealthServiceCommand struct {
+	Ui cli.Ui
+}
+
+func (c *HealthServiceCommand) Synopsis() string {
+	return "Lists the instances of a service and their health"
+}
+
+func (c *HealthServiceCommand) Help() string {
+	helpText := `
+Usage: consul health service [options] SERVICE
+
+  Lists the instances of the given service, printed as a table sorted by node
+  and service ID. The status of each instance is the worst of its checks,
+  including those of its node:
+
+      $ consul health service redis
+
+  With -passing-only, only the instances whose checks are all passing are
+  listed, and the command exits with status 2 if there are none. This can be
+  used to gate a deploy on the health of a service:
+
+      $ consul health service -passing-only -tag=v2 web
+
+  For a full list of options and examples, please see the Consul documentation.
+
+` + apiOptsText + `
+
+Health Service Options:
+
+  -format=<string>        Output format, either "text" or "json". With "json",
+                          the instances are printed as an array of objects,
+                          each with the node, service, and checks. The default
+                          value is "text".
+
+  -passing-only           Only list the instances whose checks are all passing,
+                          and exit with status 2 if there are none. The
+                          default value is false.
+
+  -tag=<tag>              Only list the instances with the given tag.
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *HealthServiceCommand) Run(args []string) (code int) {
+	cmdFlags, apiFlags := NewAPIFlagSet("service", c.Ui)
+	defer apiFlags.CheckTimeout(c.Ui, &code)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	format := cmdFlags.String("format", "text", "")
+	passingOnly := cmdFlags.Bool("passing-only", false, "")
+	tag := cmdFlags.String("tag", "", "")
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+
+	var service string
+	args = cmdFlags.Args()
+	switch len(args) {
+	case 0:
+		c.Ui.Error("Error! Missing SERVICE argument")
+		return 1
+	case 1:
+		service = args[0]
+	default:
+		c.Ui.Error(fmt.Sprintf("Too many arguments (expected 1, got %d)", len(args)))
+		return 1
+	}
+
+	if *format != "text" && *format != "json" {
+		c.Ui.Error(fmt.Sprintf("Error! Unsupported format %q (expected text or json)", *format))
+		return 1
+	}
+
+	// Create and test the HTTP client
+	client, err := apiFlags.Client()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+		return 1
+	}
+
+	entries, _, err := client.Health().Service(service, *tag, *passingOnly, apiFlags.QueryOptions())
+	if err != nil {
+		c.Ui.Error(apiFlags.errorMessage("Error querying Consul agent", err,
+			catalogDenial("list the instances of "+service, "service")))
+		return exitCommError
+	}
+	sort.Sort(healthEntriesByNode(entries))
+
+	if *format == "json" {
+		if entries == nil {
+			entries = []*api.ServiceEntry{}
+		}
+		if code := printJSON(c.Ui, entries); code != 0 {
+			return code
+		}
+	} else if len(entries) > 0 {
+		result := []string{"Node|Address|Service ID|Port|Tags|Status"}
+		for _, entry := range entries {
+			address := entry.Service.Address
+			if address == "" {
+				address = entry.Node.Address
+			}
+			result = append(result, fmt.Sprintf("%s|%s|%s|%d|%s|%s",
+				entry.Node.Node, address, entry.Service.ID, entry.Service.Port,
+				strings.Join(entry.Service.Tags, ","), entry.Checks.AggregatedStatus()))
+		}
+		c.Ui.Output(columnize.SimpleFormat(result))
+	}
+
+	if *passingOnly && len(entries) == 0 {
+		desc := service
+		if *tag != "" {
+			desc = fmt.Sprintf("%s with tag %q", service, *tag)
+		}
+		c.Ui.Error(fmt.Sprintf("Error! No passing instances of %s", desc))
+		return exitNotFound
+	}
+	return 0
+}
+
+// healthEntriesByNode sorts service instances by node, and then by service
+// ID.
+type healthEntriesByNode []*api.ServiceEntry
+
+func (e healthEntriesByNode) Len() int      { return len(e) }
+func (e healthEntriesByNode) Swap(i, j int) { e[i], e[j] = e[j], e[i] }
+func (e healthEntriesByNode) Less(i, j int) bool {
+	if e[i].Node.Node != e[j].Node.Node {
+		return e[i].Node.Node < e[j].Node.Node
+	}
+	return e[i].Service.ID < e[j].Service.ID
+}
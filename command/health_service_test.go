@@ -0,0 +1,105 @@
+package command
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+)
+
+func TestHealthServiceCommand_implements(t *testing.T) {
+	var _ cli.Command = &HealthServiceCommand{}
+}
+
+func TestHealthServiceCommand_noTabs(t *testing.T) {
+	assertNoTabs(t, new(HealthServiceCommand))
+}
+
+func TestHealthServiceCommand_Validation(t *testing.T) {
+	cases := map[string]struct {
+		args   []string
+		output string
+	}{
+		"no service": {
+			[]string{},
+			"Missing SERVICE argument",
+		},
+		"too many args": {
+			[]string{"web", "db"},
+			"Too many arguments",
+		},
+		"bad format": {
+			[]string{"-format=yaml", "web"},
+			"Unsupported format",
+		},
+	}
+
+	for name, tc := range cases {
+		ui := new(cli.MockUi)
+		c := &HealthServiceCommand{Ui: ui}
+
+		if code := c.Run(tc.args); code != 1 {
+			t.Fatalf("%s: bad: %d", name, code)
+		}
+		if output := ui.ErrorWriter.String(); !strings.Contains(output, tc.output) {
+			t.Fatalf("%s: expected %q to contain %q", name, output, tc.output)
+		}
+	}
+}
+
+func TestHealthServiceCommand_Run(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+	testHealthRegister(t, client)
+
+	run := func(args ...string) (int, string, string) {
+		ui := new(cli.MockUi)
+		c := &HealthServiceCommand{Ui: ui}
+		code := c.Run(append([]string{"-http-addr=" + srv.httpAddr}, args...))
+		return code, ui.OutputWriter.String(), ui.ErrorWriter.String()
+	}
+
+	// The service address is shown in place of the node's, and the status
+	// is the worst of the instance's checks.
+	code, output, errors := run("web")
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, errors)
+	}
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) != 3 ||
+		strings.Join(strings.Fields(lines[1]), " ") != "web-1 10.0.0.1 web 8080 v1 passing" ||
+		strings.Join(strings.Fields(lines[2]), " ") != "web-2 10.0.1.2 web 8080 v2 critical" {
+		t.Fatalf("bad: %#v", output)
+	}
+
+	code, output, errors = run("-passing-only", "-format=json", "web")
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, errors)
+	}
+	var entries []*api.ServiceEntry
+	if err := json.Unmarshal([]byte(output), &entries); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Node.Node != "web-1" {
+		t.Fatalf("bad: %#v", output)
+	}
+
+	// With no passing instances, -passing-only fails so it can gate a
+	// deploy.
+	code, _, errors = run("-passing-only", "-tag=v2", "web")
+	if code != 2 || !strings.Contains(errors, `No passing instances of web with tag "v2"`) {
+		t.Fatalf("bad: %d %#v", code, errors)
+	}
+	code, _, errors = run("-passing-only", "nope")
+	if code != 2 || !strings.Contains(errors, "No passing instances of nope") {
+		t.Fatalf("bad: %d %#v", code, errors)
+	}
+
+	// Without -passing-only, no instances isn't an error.
+	if code, _, errors := run("nope"); code != 0 {
+		t.Fatalf("bad: %d %#v", code, errors)
+	}
+}
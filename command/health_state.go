@@ -0,0 +1,129 @@
+package command
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+	"github.com/ryanuber/columnize"
+)
+
+// HealthStateCommand is a Command implementation that is used to list the
+// health checks in a given state.
+type HealthStateCommand struct {
+	Ui cli.Ui
+}
+
+func (c *HealthStateCommand) Synopsis() string {
+	return "Lists the health checks in a given state"
+}
+
+func (c *HealthStateCommand) Help() string {
+	helpText := `
+Usage: consul health state [options] STATE
+
+  Lists the health checks in the given state, which is one of "passing",
+  "warning", "critical", or "any" for every check. The checks are printed
+  as a table sorted by node and check ID:
+
+      $ consul health state critical
+
+  For a full list of options and examples, please see the Consul documentation.
+
+` + apiOptsText + `
+
+Health State Options:
+
+  -format=<string>        Output format, either "text" or "json". With "json",
+                          the checks are printed as an array of objects,
+                          including the output of each check. The default
+                          value is "text".
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *HealthStateCommand) Run(args []string) (code int) {
+	cmdFlags, apiFlags := NewAPIFlagSet("state", c.Ui)
+	defer apiFlags.CheckTimeout(c.Ui, &code)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	format := cmdFlags.String("format", "text", "")
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+
+	var state string
+	args = cmdFlags.Args()
+	switch len(args) {
+	case 0:
+		c.Ui.Error("Error! Missing STATE argument")
+		return 1
+	case 1:
+		state = args[0]
+	default:
+		c.Ui.Error(fmt.Sprintf("Too many arguments (expected 1, got %d)", len(args)))
+		return 1
+	}
+
+	switch state {
+	case api.HealthPassing, api.HealthWarning, api.HealthCritical, api.HealthAny:
+	default:
+		c.Ui.Error(fmt.Sprintf("Error! Unsupported state %q (expected passing, warning, critical, or any)", state))
+		return 1
+	}
+	if *format != "text" && *format != "json" {
+		c.Ui.Error(fmt.Sprintf("Error! Unsupported format %q (expected text or json)", *format))
+		return 1
+	}
+
+	// Create and test the HTTP client
+	client, err := apiFlags.Client()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+		return 1
+	}
+
+	checks, _, err := client.Health().State(state, apiFlags.QueryOptions())
+	if err != nil {
+		c.Ui.Error(apiFlags.errorMessage("Error querying Consul agent", err,
+			catalogDenial("list the "+state+" checks", "node")))
+		return exitCommError
+	}
+	return printHealthChecks(c.Ui, checks, *format)
+}
+
+// printHealthChecks prints the checks sorted by node and check ID, either as
+// a table or as JSON, returning the exit code.
+func printHealthChecks(ui cli.Ui, checks api.HealthChecks, format string) int {
+	sort.Sort(healthChecksByNode(checks))
+	if format == "json" {
+		if checks == nil {
+			checks = api.HealthChecks{}
+		}
+		return printJSON(ui, checks)
+	}
+
+	if len(checks) == 0 {
+		return 0
+	}
+	result := []string{"Node|Check ID|Name|Status|Service"}
+	for _, check := range checks {
+		result = append(result, fmt.Sprintf("%s|%s|%s|%s|%s",
+			check.Node, check.CheckID, check.Name, check.Status, check.ServiceName))
+	}
+	ui.Output(columnize.SimpleFormat(result))
+	return 0
+}
+
+// healthChecksByNode sorts checks by node, and then by check ID.
+type healthChecksByNode api.HealthChecks
+
+func (c healthChecksByNode) Len() int      { return len(c) }
+func (c healthChecksByNode) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
+func (c healthChecksByNode) Less(i, j int) bool {
+	if c[i].Node != c[j].Node {
+		return c[i].Node < c[j].Node
+	}
+	return c[i].CheckID < c[j].CheckID
+}
@@ -0,0 +1,95 @@
+package command
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+)
+
+func TestHealthStateCommand_implements(t *testing.T) {
+	var _ cli.Command = &HealthStateCommand{}
+}
+
+func TestHealthStateCommand_noTabs(t *testing.T) {
+	assertNoTabs(t, new(HealthStateCommand))
+}
+
+func TestHealthStateCommand_Validation(t *testing.T) {
+	cases := map[string]struct {
+		args   []string
+		output string
+	}{
+		"no state": {
+			[]string{},
+			"Missing STATE argument",
+		},
+		"too many args": {
+			[]string{"passing", "critical"},
+			"Too many arguments",
+		},
+		"bad state": {
+			[]string{"broken"},
+			"Unsupported state",
+		},
+		"bad format": {
+			[]string{"-format=yaml", "any"},
+			"Unsupported format",
+		},
+	}
+
+	for name, tc := range cases {
+		ui := new(cli.MockUi)
+		c := &HealthStateCommand{Ui: ui}
+
+		if code := c.Run(tc.args); code != 1 {
+			t.Fatalf("%s: bad: %d", name, code)
+		}
+		if output := ui.ErrorWriter.String(); !strings.Contains(output, tc.output) {
+			t.Fatalf("%s: expected %q to contain %q", name, output, tc.output)
+		}
+	}
+}
+
+func TestHealthStateCommand_Run(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+	testHealthRegister(t, client)
+
+	run := func(args ...string) string {
+		ui := new(cli.MockUi)
+		c := &HealthStateCommand{Ui: ui}
+		if code := c.Run(append([]string{"-http-addr=" + srv.httpAddr}, args...)); code != 0 {
+			t.Fatalf("%v: bad: %d. %#v", args, code, ui.ErrorWriter.String())
+		}
+		return ui.OutputWriter.String()
+	}
+
+	output := run("critical")
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "Node") ||
+		strings.Join(strings.Fields(lines[1]), " ") != "web-2 service:web web check critical web" {
+		t.Fatalf("bad: %#v", output)
+	}
+
+	// Every check is listed with "any", sorted by node and then check ID.
+	var checks api.HealthChecks
+	if err := json.Unmarshal([]byte(run("-format=json", "any")), &checks); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	var ids []string
+	for _, check := range checks {
+		if strings.HasPrefix(check.Node, "web-") {
+			ids = append(ids, check.Node+"/"+check.CheckID)
+		}
+	}
+	if strings.Join(ids, " ") != "web-1/service:web web-2/mem web-2/service:web" {
+		t.Fatalf("bad: %#v", ids)
+	}
+	if output := run("-format=json", "warning"); !strings.Contains(output, `"CheckID": "mem"`) {
+		t.Fatalf("bad: %#v", output)
+	}
+}
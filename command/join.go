@@ -1,10 +1,11 @@
 package command
 
 import (
-	"flag"
+	"errors"
 	"fmt"
-	"github.com/mitchellh/cli"
 	"strings"
+
+	"github.com/mitchellh/cli"
 )
 
 // JoinCommand is a Command implementation that tells a running Consul
@@ -15,30 +16,54 @@ type JoinCommand struct {
 
 func (c *JoinCommand) Help() string {
 	helpText := `
-Usage: consul join [options] address ...
+Usage: consul join [options] ADDRESS...
 
   Tells a running Consul agent (with "consul agent") to join the cluster
-  by specifying at least one existing member.
+  by specifying at least one existing member:
+
+      $ consul join 10.0.1.10 10.0.1.11
+
+  Each address is joined in turn, and the result for each is reported. The
+  command succeeds if at least one join succeeded, since the rest of the
+  cluster is found through gossip from there, unless -strict is given.
+
+  For a full list of options and examples, please see the Consul documentation.
+
+` + apiOptsText + `
+
+Join Options:
+
+  -rpc-addr=<address>     Deprecated. Use the agent's RPC address, such as
+                          127.0.0.1:8400, instead of its HTTP API, as before.
+                          This is also used if only CONSUL_RPC_ADDR is set.
+                          The other HTTP API options don't apply with it.
 
-Options:
+  -strict                 Fail if any of the addresses can't be joined,
+                          rather than only if none of them can. The default
+                          value is false.
 
-  -rpc-addr=127.0.0.1:8400  RPC address of the Consul agent.
-  -wan                      Joins a server to another server in the WAN pool
+  -wan                    Join a server to other servers in the WAN pool,
+                          which connects datacenters. The default value is
+                          false.
 `
 	return strings.TrimSpace(helpText)
 }
 
-func (c *JoinCommand) Run(args []string) int {
-	var wan bool
-
-	cmdFlags := flag.NewFlagSet("join", flag.ContinueOnError)
+func (c *JoinCommand) Run(args []string) (code int) {
+	cmdFlags, apiFlags := NewAPIFlagSet("join", c.Ui)
+	defer apiFlags.CheckTimeout(c.Ui, &code)
 	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
-	cmdFlags.BoolVar(&wan, "wan", false, "wan")
-	rpcAddr := RPCAddrFlag(cmdFlags)
-	if err := cmdFlags.Parse(args); err != nil {
+	strict := cmdFlags.Bool("strict", false, "")
+	wan := cmdFlags.Bool("wan", false, "")
+	rpcAddr := cmdFlags.String("rpc-addr", "", "")
+	if err := parseFlags(c.Ui, cmdFlags, args); err != nil {
 		return 1
 	}
 
+	rpcAddress, ok := rpcAddrDeprecated(c.Ui, cmdFlags, *rpcAddr)
+	if !ok {
+		return 1
+	}
 	addrs := cmdFlags.Args()
 	if len(addrs) == 0 {
 		c.Ui.Error("At least one address to join must be specified.")
@@ -47,21 +72,59 @@ func (c *JoinCommand) Run(args []string) int {
 		return 1
 	}
 
-	client, err := RPCClient(*rpcAddr)
-	if err != nil {
-		c.Ui.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
-		return 1
+	// Each address is joined on its own, so one which can't be reached
+	// doesn't hide whether the others worked.
+	var join func(addr string) error
+	if rpcAddress != "" {
+		client, err := RPCClient(rpcAddress)
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+			return 1
+		}
+		defer client.Close()
+		join = func(addr string) error {
+			if _, err := client.Join([]string{addr}, *wan); err != nil {
+				return fmt.Errorf("Error joining %s: %s", addr, err)
+			}
+			return nil
+		}
+	} else {
+		// Create and test the HTTP client
+		client, err := apiFlags.Client()
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+			return 1
+		}
+		join = func(addr string) error {
+			if err := client.Agent().Join(addr, *wan); err != nil {
+				return errors.New(apiFlags.errorMessage(fmt.Sprintf("Error joining %s", addr), err,
+					agentDenial("join "+addr, "write")))
+			}
+			return nil
+		}
 	}
-	defer client.Close()
 
-	n, err := client.Join(addrs, wan)
-	if err != nil {
-		c.Ui.Error(fmt.Sprintf("Error joining the cluster: %s", err))
-		return 1
+	joined := 0
+	for _, addr := range addrs {
+		if err := join(addr); err != nil {
+			c.Ui.Error(err.Error())
+			continue
+		}
+		joined++
+		apiFlags.report(c.Ui, fmt.Sprintf("Joined: %s", addr))
 	}
 
+	if joined == 0 {
+		c.Ui.Error("Error! Failed to join any of the addresses")
+		return 1
+	}
+	if joined < len(addrs) && *strict {
+		c.Ui.Error(fmt.Sprintf("Error! Failed to join %d of %d addresses with -strict",
+			len(addrs)-joined, len(addrs)))
+		return 1
+	}
 	c.Ui.Output(fmt.Sprintf(
-		"Successfully joined cluster by contacting %d nodes.", n))
+		"Successfully joined cluster by contacting %d nodes.", joined))
 	return 0
 }
 
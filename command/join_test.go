@@ -72,3 +72,94 @@ func TestJoinCommandRun_noAddrs(t *testing.T) {
 		t.Fatalf("bad: %#v", ui.ErrorWriter.String())
 	}
 }
+
+func TestJoinCommandRun_partial(t *testing.T) {
+	a1 := testAgent(t)
+	a2 := testAgent(t)
+	defer a1.Shutdown()
+	defer a2.Shutdown()
+
+	good := fmt.Sprintf("127.0.0.1:%d", a2.config.Ports.SerfLan)
+	bad := "127.0.0.1:1"
+
+	// One of the addresses is enough, unless -strict is given.
+	cases := map[string]struct {
+		args []string
+		code int
+		err  string
+	}{
+		"partial": {
+			[]string{bad, good},
+			0,
+			"Error joining " + bad,
+		},
+		"strict": {
+			[]string{"-strict", bad, good},
+			1,
+			"Failed to join 1 of 2 addresses with -strict",
+		},
+		"none": {
+			[]string{bad},
+			1,
+			"Failed to join any of the addresses",
+		},
+	}
+
+	for name, tc := range cases {
+		ui := new(cli.MockUi)
+		c := &JoinCommand{Ui: ui}
+		args := append([]string{"-http-addr=" + a1.httpAddr}, tc.args...)
+		if code := c.Run(args); code != tc.code {
+			t.Fatalf("%s: bad: %d. %#v", name, code, ui.ErrorWriter.String())
+		}
+		if output := ui.ErrorWriter.String(); !strings.Contains(output, tc.err) {
+			t.Fatalf("%s: bad: %#v", name, output)
+		}
+		if tc.code == 0 && !strings.Contains(ui.OutputWriter.String(), "Joined: "+good) {
+			t.Fatalf("%s: bad: %#v", name, ui.OutputWriter.String())
+		}
+	}
+
+	if len(a1.agent.LANMembers()) != 2 {
+		t.Fatalf("bad: %#v", a1.agent.LANMembers())
+	}
+}
+
+func TestJoinCommandRun_rpcAddr(t *testing.T) {
+	a1 := testAgent(t)
+	a2 := testAgent(t)
+	defer a1.Shutdown()
+	defer a2.Shutdown()
+
+	// The deprecated RPC address still reports each address.
+	good := fmt.Sprintf("127.0.0.1:%d", a2.config.Ports.SerfLan)
+	ui := new(cli.MockUi)
+	c := &JoinCommand{Ui: ui}
+	args := []string{"-rpc-addr=" + a1.addr, "-strict", "127.0.0.1:1", good}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	output := ui.ErrorWriter.String()
+	for _, expected := range []string{
+		"Warning! Using the agent's RPC address " + a1.addr,
+		"Error joining 127.0.0.1:1",
+		"Failed to join 1 of 2 addresses with -strict",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Fatalf("bad: %#v", output)
+		}
+	}
+	if !strings.Contains(ui.OutputWriter.String(), "Joined: "+good) {
+		t.Fatalf("bad: %#v", ui.OutputWriter.String())
+	}
+
+	ui = new(cli.MockUi)
+	c = &JoinCommand{Ui: ui}
+	args = []string{"-rpc-addr=" + a1.addr, "-http-addr=" + a1.httpAddr, good}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("bad: %d", code)
+	}
+	if !strings.Contains(ui.ErrorWriter.String(), "Cannot specify both -rpc-addr and -http-addr") {
+		t.Fatalf("bad: %#v", ui.ErrorWriter.String())
+	}
+}
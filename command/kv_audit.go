@@ -0,0 +1,176 @@
+package command
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-syslog"
+	"github.com/mitchellh/cli"
+)
+
+// kvAuditLogEnvName is the environment variable giving the audit log for
+// commands run without -audit-log.
+const kvAuditLogEnvName = "CONSUL_CLI_AUDIT_LOG"
+
+// kvAuditOptsText describes the flags registered by newKVAuditFlags.
+var kvAuditOptsText = strings.TrimSpace(`
+KV Audit Options:
+
+  -audit-log=<path>       Append a line of JSON to the given file describing
+                          each delete before it's made, with the time, the
+                          keys, the number of keys, the agent's address, and
+                          the name and a hash of the ACL token, but never the
+                          token itself. Use "syslog" to send the line to
+                          syslog instead. This can also be specified via the
+                          CONSUL_CLI_AUDIT_LOG environment variable. If the
+                          line can't be written, nothing is deleted.
+
+  -audit-best-effort      Delete even if the audit log can't be written,
+                          with a warning. The default value is false.
+`)
+
+// kvAuditFlags holds the flags which record destructive operations in an
+// audit log.
+type kvAuditFlags struct {
+	path       string
+	bestEffort bool
+}
+
+// newKVAuditFlags registers the audit flags on the given flagset, returning
+// the values they will be parsed into.
+func newKVAuditFlags(f *flag.FlagSet) *kvAuditFlags {
+	a := &kvAuditFlags{}
+	f.StringVar(&a.path, "audit-log", "", "")
+	f.BoolVar(&a.bestEffort, "audit-best-effort", false, "")
+	return a
+}
+
+// kvAuditEntry is a line of the audit log. Key is set for a single key and
+// Prefix for a recursive delete, while Keys lists the keys read from stdin
+// or about to be pruned.
+type kvAuditEntry struct {
+	Time      time.Time `json:"timestamp"`
+	Operation string    `json:"operation"`
+
+	Key    string   `json:"key,omitempty"`
+	Prefix string   `json:"prefix,omitempty"`
+	Keys   []string `json:"keys,omitempty"`
+
+	Recurse     bool   `json:"recurse"`
+	CAS         bool   `json:"cas"`
+	ModifyIndex uint64 `json:"modify_index,omitempty"`
+	Filter      string `json:"filter,omitempty"`
+
+	// KeysAffected is the number of keys about to be deleted. A delete of
+	// a single key counts it whether or not it exists, since the agent
+	// doesn't say.
+	KeysAffected int `json:"keys_affected"`
+
+	Agent      string `json:"agent"`
+	Datacenter string `json:"datacenter,omitempty"`
+	Namespace  string `json:"namespace,omitempty"`
+
+	// Token identifies the ACL token by the start of its SHA-256, since
+	// these tokens have no ID apart from the secret, and TokenName is its
+	// name, if the agent would give it. Both are left out if no token was
+	// given, so the agent's own token was used.
+	Token     string `json:"token,omitempty"`
+	TokenName string `json:"token_name,omitempty"`
+}
+
+// enabled returns true if there's an audit log to write to.
+func (a *kvAuditFlags) enabled() bool {
+	return a.target() != ""
+}
+
+// target returns the path of the audit log, or "syslog".
+func (a *kvAuditFlags) target() string {
+	if a.path != "" {
+		return a.path
+	}
+	return os.Getenv(kvAuditLogEnvName)
+}
+
+// record writes the entry to the audit log, if there is one, filling in who
+// is making the change and where. It returns false if the command must stop
+// before making the change, having reported why.
+func (a *kvAuditFlags) record(ui cli.Ui, client *api.Client, apiFlags *APIFlags, entry *kvAuditEntry) bool {
+	if !a.enabled() {
+		return true
+	}
+
+	entry.Time = time.Now().UTC()
+	entry.Agent = apiFlags.address
+	entry.Datacenter = apiFlags.Datacenter
+	entry.Namespace = apiFlags.Namespace
+	if token := apiFlags.token; token != "" {
+		sum := sha256.Sum256([]byte(token))
+		entry.Token = "sha256:" + hex.EncodeToString(sum[:8])
+
+		// Anyone with a token can look it up, but this fails when ACLs
+		// are disabled, which leaves the name out.
+		if acl, _, err := client.ACL().Info(token, apiFlags.QueryOptions()); err == nil && acl != nil {
+			entry.TokenName = acl.Name
+		}
+	}
+
+	err := kvAuditWrite(a.target(), entry)
+	switch {
+	case err == nil:
+		return true
+	case a.bestEffort:
+		ui.Warn(fmt.Sprintf("Warning! Failed to write the audit log: %s", err))
+		return true
+	default:
+		ui.Error(fmt.Sprintf("Error! Failed to write the audit log, so nothing was deleted: %s. "+
+			"Use -audit-best-effort to delete anyway", err))
+		return false
+	}
+}
+
+// kvAuditWrite appends the entry to the audit log at the given path as a
+// line of JSON, or sends it to syslog. The file is locked while the line is
+// written, so commands run at the same time don't interleave their lines.
+func kvAuditWrite(target string, entry *kvAuditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	if target == "syslog" {
+		l, err := gsyslog.NewLogger(gsyslog.LOG_NOTICE, "AUTH", "consul")
+		if err != nil {
+			return err
+		}
+		defer l.Close()
+		_, err = l.Write(line)
+		return err
+	}
+
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+
+	// The line has to reach the disk before the delete is made.
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,69 @@
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestKVAuditWrite_concurrent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "consul")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	log := filepath.Join(dir, "audit.log")
+
+	// Long lines are more likely to be split up if they're not locked.
+	keys := make([]string, 2000)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("app/some/long/key/%04d", i)
+	}
+
+	var wg sync.WaitGroup
+	errCh := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			entry := &kvAuditEntry{Operation: "delete-keys", Keys: keys, KeysAffected: i}
+			errCh <- kvAuditWrite(log, entry)
+		}(i)
+	}
+	wg.Wait()
+	close(errCh)
+	for err := range errCh {
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	data, err := ioutil.ReadFile(log)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != 20 {
+		t.Fatalf("bad: %d lines", len(lines))
+	}
+	seen := make(map[int]bool)
+	for _, line := range lines {
+		var entry kvAuditEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if len(entry.Keys) != len(keys) || seen[entry.KeysAffected] {
+			t.Fatalf("bad: %#v", entry)
+		}
+		seen[entry.KeysAffected] = true
+	}
+
+	if info, err := os.Stat(log); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("bad: %v, %v", info, err)
+	}
+}
@@ -0,0 +1,14 @@
+// +build !windows
+
+package command
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive lock on the file, waiting for any other holder
+// to let go. The lock is released when the file is closed.
+func lockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+}
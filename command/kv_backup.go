@@ -0,0 +1,259 @@
+package command
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+)
+
+// KVBackupCommand is a Command implementation that is used to copy a tree in
+// the key-value store to a timestamped backup tree, so it can be rolled back
+// later with the rollback command.
+type KVBackupCommand struct {
+	Ui cli.Ui
+}
+
+func (c *KVBackupCommand) Synopsis() string {
+	return "Copies a tree in the KV store to a timestamped backup"
+}
+
+func (c *KVBackupCommand) Help() string {
+	helpText := `
+Usage: consul kv backup [options] PREFIX
+
+  Copies every key under PREFIX to a backup tree in the key-value store,
+  under the -dest prefix followed by PREFIX and the time of the backup:
+
+      $ consul kv backup -dest=backups/ app/config/
+
+  This copies "app/config/db" to "backups/app/config/<time>/db", where <time>
+  is the current UTC time in RFC 3339 format, such as 2017-03-01T09:30:00Z.
+  The keys are read in a single request, so the backup is a consistent copy
+  of the tree, and written with their flags in transactions of up to 64 keys.
+  If a transaction fails, the partial backup is deleted.
+
+  Use "consul kv rollback" to restore the tree from a backup. The destination
+  can't be under PREFIX, since each backup would then include the ones before
+  it.
+
+  For a full list of options and examples, please see the Consul documentation.
+
+` + apiOptsText + `
+
+KV Backup Options:
+
+  -dest=<prefix>          Prefix to write the backups under. This is required.
+
+  -dry-run                List the keys that would be written, one per line,
+                          and the old backups -retain would delete, without
+                          changing anything. The default value is false.
+
+  -retain=<int>           Number of backups of PREFIX to keep under -dest,
+                          including the new one. Older backups are deleted
+                          once the new one is written. The default value is
+                          0, which keeps every backup.
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *KVBackupCommand) Run(args []string) (code int) {
+	cmdFlags, apiFlags := NewAPIFlagSet("backup", c.Ui)
+	defer apiFlags.CheckTimeout(c.Ui, &code)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	dest := cmdFlags.String("dest", "", "")
+	retain := cmdFlags.Int("retain", 0, "")
+	dryRun := cmdFlags.Bool("dry-run", false, "")
+	if err := parseFlags(c.Ui, cmdFlags, args); err != nil {
+		return 1
+	}
+
+	var prefix string
+	args = cmdFlags.Args()
+	switch len(args) {
+	case 0:
+		c.Ui.Error("Error! Missing PREFIX argument")
+		return 1
+	case 1:
+		prefix = normalizeKVPrefix(args[0])
+	default:
+		c.Ui.Error(fmt.Sprintf("Too many arguments (expected 1, got %d)", len(args)))
+		return 1
+	}
+
+	// Report every problem with the arguments at once
+	destPrefix := normalizeKVPrefix(*dest)
+	var errs []string
+	switch {
+	case prefix == "":
+		errs = append(errs, "Error! Cannot back up the whole key-value store, since it includes the backups")
+	case destPrefix == "":
+		errs = append(errs, "Error! Missing -dest prefix")
+	case strings.HasPrefix(destPrefix, prefix):
+		errs = append(errs, fmt.Sprintf("Error! The destination %s is under the prefix %s being backed up",
+			destPrefix, prefix))
+	}
+	if *retain < 0 {
+		errs = append(errs, "Error! -retain must not be negative")
+	}
+	if len(errs) > 0 {
+		for _, err := range errs {
+			c.Ui.Error(err)
+		}
+		return 1
+	}
+
+	// Create and test the HTTP client
+	client, err := apiFlags.Client()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+		return 1
+	}
+
+	qo := apiFlags.QueryOptions()
+	pairs, _, err := client.KV().List(prefix, qo)
+	if err != nil {
+		c.Ui.Error(apiFlags.errorMessage("Error querying Consul agent", err,
+			kvDenial("read keys under "+prefix, prefix, "read")))
+		return exitCommError
+	}
+	if len(pairs) == 0 {
+		c.Ui.Error(fmt.Sprintf("Error! No keys exist with prefix: %s", prefix))
+		return exitNotFound
+	}
+
+	// Two backups in the same second would land in the same tree, so refuse
+	// rather than mix them.
+	base := destPrefix + prefix
+	root := base + time.Now().UTC().Format(time.RFC3339) + "/"
+	existing, _, err := client.KV().Keys(root, "/", qo)
+	if err != nil {
+		c.Ui.Error(apiFlags.errorMessage("Error querying Consul agent", err,
+			kvDenial("read keys under "+root, root, "read")))
+		return exitCommError
+	}
+	if len(existing) > 0 {
+		c.Ui.Error(fmt.Sprintf("Error! A backup already exists at %s", root))
+		return 1
+	}
+
+	backup := make([]*api.KVPair, 0, len(pairs))
+	for _, pair := range pairs {
+		backup = append(backup, &api.KVPair{
+			Key:   root + strings.TrimPrefix(pair.Key, prefix),
+			Flags: pair.Flags,
+			Value: pair.Value,
+		})
+	}
+
+	var expired []string
+	if *retain > 0 {
+		backups, err := kvListBackups(client, base, qo)
+		if err != nil {
+			c.Ui.Error(apiFlags.errorMessage("Error querying Consul agent", err,
+				kvDenial("read keys under "+base, base, "read")))
+			return exitCommError
+		}
+		// The new backup counts towards the ones kept.
+		if n := len(backups) + 1 - *retain; n > 0 {
+			expired = backups[:n]
+		}
+	}
+
+	if *dryRun {
+		for _, pair := range backup {
+			c.Ui.Info(pair.Key)
+		}
+		c.Ui.Warn(fmt.Sprintf("Would back up %d %s with prefix %s to %s",
+			len(backup), pluralKeys(len(backup)), prefix, root))
+		for _, old := range expired {
+			c.Ui.Warn(fmt.Sprintf("Would delete old backup: %s", old))
+		}
+		return 0
+	}
+
+	if err := kvWriteTxn(client, backup, qo); err != nil {
+		key := kvDeniedKey(err, root)
+		c.Ui.Error(apiFlags.errorMessage(fmt.Sprintf("Error! Failed writing backup %s", root),
+			err, kvDenial("write to "+key, key, "write")))
+		if _, err := client.KV().DeleteTree(root, apiFlags.WriteOptions()); err != nil {
+			c.Ui.Error(fmt.Sprintf("Error! Failed deleting the partial backup %s: %s", root, err))
+		}
+		return 1
+	}
+	apiFlags.report(c.Ui, fmt.Sprintf("Success! Backed up %d %s with prefix %s to %s",
+		len(backup), pluralKeys(len(backup)), prefix, root))
+
+	for _, old := range expired {
+		if _, err := client.KV().DeleteTree(old, apiFlags.WriteOptions()); err != nil {
+			c.Ui.Error(apiFlags.errorMessage(fmt.Sprintf("Error! Failed deleting old backup %s", old),
+				err, kvDenial("delete keys under "+old, old, "write")))
+			return 1
+		}
+		apiFlags.report(c.Ui, fmt.Sprintf("Deleted old backup: %s", old))
+	}
+	return 0
+}
+
+// kvListBackups returns the roots of the backup trees directly under base,
+// oldest first. Only the folders named with a UTC time in RFC 3339 format,
+// as written by a backup, are included, so anything else under base, such as
+// the backups of a longer prefix, is left out. Those names sort in time
+// order.
+func kvListBackups(client *api.Client, base string, q *api.QueryOptions) ([]string, error) {
+	keys, _, err := client.KV().Keys(base, "/", q)
+	if err != nil {
+		return nil, err
+	}
+
+	var roots []string
+	for _, key := range keys {
+		name := strings.TrimPrefix(key, base)
+		if !strings.HasSuffix(name, "/") {
+			continue
+		}
+		name = strings.TrimSuffix(name, "/")
+		if taken, err := time.Parse(time.RFC3339, name); err != nil || taken.UTC().Format(time.RFC3339) != name {
+			continue
+		}
+		roots = append(roots, key)
+	}
+	sort.Strings(roots)
+	return roots, nil
+}
+
+// kvWriteTxn writes the pairs with their flags in transactions of up to
+// kvMaxTxnOps keys. All the batches are planned before anything is written,
+// so data which can't fit in a transaction is caught up front.
+func kvWriteTxn(client *api.Client, pairs []*api.KVPair, q *api.QueryOptions) error {
+	batches, err := kvTxnBatches(pairs)
+	if err != nil {
+		return err
+	}
+
+	for _, batch := range batches {
+		ops := make(api.KVTxnOps, 0, len(batch))
+		keys := make([]string, 0, len(batch))
+		for _, pair := range batch {
+			ops = append(ops, &api.KVTxnOp{
+				Verb:  api.KVSet,
+				Key:   pair.Key,
+				Flags: pair.Flags,
+				Value: pair.Value,
+			})
+			keys = append(keys, pair.Key)
+		}
+
+		ok, resp, err := kvTxn(client, ops, q)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return newKVTxnError(keys, resp)
+		}
+	}
+	return nil
+}
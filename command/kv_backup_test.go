@@ -0,0 +1,184 @@
+package command
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+)
+
+func TestKVBackupCommand_implements(t *testing.T) {
+	var _ cli.Command = &KVBackupCommand{}
+}
+
+func TestKVBackupCommand_noTabs(t *testing.T) {
+	assertNoTabs(t, new(KVBackupCommand))
+}
+
+func TestKVBackupCommand_Validation(t *testing.T) {
+	cases := map[string]struct {
+		args []string
+		errs []string
+	}{
+		"no prefix": {
+			[]string{"-dest=backups"},
+			[]string{"Error! Missing PREFIX argument"},
+		},
+		"extra args": {
+			[]string{"-dest=backups", "foo", "bar"},
+			[]string{"Too many arguments (expected 1, got 2)"},
+		},
+		"no -dest": {
+			[]string{"app"},
+			[]string{"Error! Missing -dest prefix"},
+		},
+		"whole store": {
+			[]string{"-dest=backups", "/"},
+			[]string{"Error! Cannot back up the whole key-value store, since it includes the backups"},
+		},
+		"-dest under the prefix": {
+			[]string{"-dest=app/backups", "app"},
+			[]string{"Error! The destination app/backups/ is under the prefix app/ being backed up"},
+		},
+		"-dest is the prefix": {
+			[]string{"-dest=app/", "app"},
+			[]string{"Error! The destination app/ is under the prefix app/ being backed up"},
+		},
+		"everything at once": {
+			[]string{"-retain=-1", "app"},
+			[]string{"Error! Missing -dest prefix", "Error! -retain must not be negative"},
+		},
+	}
+
+	for name, tc := range cases {
+		ui := new(cli.MockUi)
+		c := &KVBackupCommand{Ui: ui}
+
+		if code := c.Run(tc.args); code != 1 {
+			t.Errorf("%s: bad: %d", name, code)
+		}
+		output := ui.ErrorWriter.String()
+		if expected := strings.Join(tc.errs, "\n") + "\n"; output != expected {
+			t.Errorf("%s: expected %q, got %q", name, expected, output)
+		}
+	}
+}
+
+func TestKVBackupCommand_Run(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	for _, pair := range []*api.KVPair{
+		{Key: "app/config/db", Value: []byte("postgres"), Flags: 42},
+		{Key: "app/config/feature/", Value: nil},
+		{Key: "app/config/feature/on", Value: []byte("true")},
+		{Key: "app/configuration", Value: []byte("not included")},
+		// Backups of the prefix from before, and other things under the
+		// destination which aren't backups of it.
+		{Key: "backups/app/config/2017-01-01T00:00:00Z/db", Value: []byte("mysql")},
+		{Key: "backups/app/config/2017-02-01T00:00:00Z/db", Value: []byte("mysql")},
+		{Key: "backups/app/config/2017-03-01T00:00:00Z/db", Value: []byte("mysql")},
+		{Key: "backups/app/config/2017-04-01T00:00:00+01:00/db", Value: []byte("not a backup")},
+		{Key: "backups/app/config/notes", Value: []byte("not a backup")},
+		{Key: "backups/app/config/sub/2017-01-01T00:00:00Z/x", Value: []byte("nested")},
+	} {
+		if _, err := client.KV().Put(pair, nil); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	run := func(args ...string) (int, string, string) {
+		ui := new(cli.MockUi)
+		c := &KVBackupCommand{Ui: ui}
+		code := c.Run(append([]string{"-http-addr=" + srv.httpAddr}, args...))
+		var output string
+		if ui.OutputWriter != nil {
+			output = ui.OutputWriter.String()
+		}
+		return code, output, ui.ErrorWriter.String()
+	}
+	backups := func() []string {
+		keys, _, err := client.KV().Keys("backups/app/config/", "/", nil)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		return keys
+	}
+	before := backups()
+
+	// A dry run lists the new keys and the backups -retain would delete,
+	// without writing anything.
+	code, output, errors := run("-dest=backups", "-retain=2", "-dry-run", "app/config")
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, errors)
+	}
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("bad: %#v", output)
+	}
+	root := strings.TrimSuffix(lines[0], "db")
+	if !strings.HasPrefix(root, "backups/app/config/") || lines[1] != root+"feature/" ||
+		lines[2] != root+"feature/on" {
+		t.Fatalf("bad: %#v", output)
+	}
+	for _, expected := range []string{
+		"Would back up 3 keys with prefix app/config/ to " + root,
+		"Would delete old backup: backups/app/config/2017-01-01T00:00:00Z/",
+		"Would delete old backup: backups/app/config/2017-02-01T00:00:00Z/",
+	} {
+		if !strings.Contains(errors, expected) {
+			t.Fatalf("expected %q to contain %q", errors, expected)
+		}
+	}
+	if strings.Contains(errors, "2017-03-01") {
+		t.Fatalf("bad: %#v", errors)
+	}
+	if after := backups(); !reflect.DeepEqual(after, before) {
+		t.Fatalf("bad: %#v", after)
+	}
+
+	code, output, errors = run("-dest=backups/", "-retain=2", "app/config/")
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, errors)
+	}
+	for _, expected := range []string{
+		"Success! Backed up 3 keys with prefix app/config/ to backups/app/config/",
+		"Deleted old backup: backups/app/config/2017-01-01T00:00:00Z/",
+		"Deleted old backup: backups/app/config/2017-02-01T00:00:00Z/",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Fatalf("expected %q to contain %q", output, expected)
+		}
+	}
+
+	pairs, _, err := client.KV().List(root, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(pairs) != 3 {
+		t.Fatalf("bad: %#v", pairs)
+	}
+	if pairs[0].Key != root+"db" || string(pairs[0].Value) != "postgres" || pairs[0].Flags != 42 {
+		t.Fatalf("bad: %#v", pairs[0])
+	}
+
+	expected := []string{
+		"backups/app/config/2017-03-01T00:00:00Z/",
+		"backups/app/config/2017-04-01T00:00:00+01:00/",
+		root,
+		"backups/app/config/notes",
+		"backups/app/config/sub/",
+	}
+	if after := backups(); !reflect.DeepEqual(after, expected) {
+		t.Fatalf("bad: %#v", after)
+	}
+
+	// A prefix with nothing under it can't be backed up.
+	code, _, errors = run("-dest=backups", "nope")
+	if code != exitNotFound || !strings.Contains(errors, "Error! No keys exist with prefix: nope/") {
+		t.Fatalf("bad: %d %#v", code, errors)
+	}
+}
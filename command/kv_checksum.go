@@ -0,0 +1,177 @@
+package command
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+)
+
+// KVChecksumCommand is a Command implementation that is used to compute a
+// checksum of a tree in the key-value store.
+type KVChecksumCommand struct {
+	Ui cli.Ui
+}
+
+func (c *KVChecksumCommand) Synopsis() string {
+	return "Prints a checksum of a tree in the KV store"
+}
+
+func (c *KVChecksumCommand) Help() string {
+	helpText := `
+Usage: consul kv checksum [options] [PREFIX]
+
+  Prints a SHA-256 checksum of the keys under the given prefix, along with
+  their flags and values. The checksum only changes when the tree does, so it
+  can be used to detect changes without exporting the tree:
+
+      $ consul kv checksum app/
+
+  Keys are hashed relative to the prefix, so a copy of the tree under another
+  prefix, or in another datacenter, has the same checksum. With no prefix, the
+  whole key-value store is hashed.
+
+  To check a tree against a checksum taken earlier:
+
+      $ consul kv checksum -compare=<digest> app/
+
+  This exits with status 0 if the checksums match, 2 if they don't, 1 for
+  invalid usage or other errors, and 3 if the request to the Consul agent
+  failed.
+
+  The checksum is computed as follows, and won't change between versions. The
+  keys are sorted by their bytes, relative to the prefix, and for each key the
+  following is written to a single SHA-256 hash:
+
+      - the length of the key, as a big-endian 64 bit integer
+      - the key
+      - the flags, as a big-endian 64 bit integer
+      - the length of the value, as a big-endian 64 bit integer
+      - the value
+
+  The checksum is the hash in lowercase hexadecimal. The hash of each key given
+  by -detailed is computed the same way, for that key alone. Sessions and
+  indexes are not included.
+
+  For a full list of options and examples, please see the Consul documentation.
+
+` + apiOptsText + `
+
+KV Checksum Options:
+
+  -compare=<digest>       Compare the checksum against the given digest, and
+                          exit with status 2 if they don't match.
+
+  -detailed               Print the hash of each key before the checksum of the
+                          tree, one per line in the form "<hash>  <key>", with
+                          the key relative to the prefix. The default value is
+                          false.
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *KVChecksumCommand) Run(args []string) (code int) {
+	cmdFlags, apiFlags := NewAPIFlagSet("checksum", c.Ui)
+	defer apiFlags.CheckTimeout(c.Ui, &code)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	detailed := cmdFlags.Bool("detailed", false, "")
+	compare := cmdFlags.String("compare", "", "")
+	if err := parseFlags(c.Ui, cmdFlags, args); err != nil {
+		return 1
+	}
+
+	var prefix string
+	args = cmdFlags.Args()
+	switch len(args) {
+	case 0:
+	case 1:
+		prefix = args[0]
+	default:
+		c.Ui.Error(fmt.Sprintf("Too many arguments (expected 0 or 1, got %d)", len(args)))
+		return 1
+	}
+	prefix = normalizeKVPrefix(prefix)
+
+	expected := strings.ToLower(*compare)
+	if expected != "" {
+		if b, err := hex.DecodeString(expected); err != nil || len(b) != sha256.Size {
+			c.Ui.Error(fmt.Sprintf("Error! Invalid -compare digest %q (expected %d hex digits)",
+				*compare, 2*sha256.Size))
+			return 1
+		}
+	}
+
+	// Create and test the HTTP client
+	client, err := apiFlags.Client()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+		return 1
+	}
+
+	pairs, _, err := client.KV().List(prefix, apiFlags.QueryOptions())
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error querying Consul agent: %s", err))
+		return exitCommError
+	}
+
+	sum, keys := kvChecksum(prefix, pairs)
+	if *detailed {
+		for _, k := range keys {
+			c.Ui.Output(fmt.Sprintf("%s  %s", k.sum, k.key))
+		}
+	}
+	c.Ui.Output(sum)
+
+	if expected != "" && sum != expected {
+		c.Ui.Error(fmt.Sprintf("Checksum mismatch: expected %s, got %s", expected, sum))
+		return 2
+	}
+	return 0
+}
+
+// kvKeySum is the hash of a single key, relative to the prefix.
+type kvKeySum struct {
+	key string
+	sum string
+}
+
+// kvChecksum returns the checksum of the pairs under the given prefix, along
+// with the hash of each key, sorted by key. The definition in the help text
+// must be kept in step with this, and the result must never change for the
+// same pairs, since checksums are stored and compared later.
+func kvChecksum(prefix string, pairs api.KVPairs) (string, []*kvKeySum) {
+	sorted := make(api.KVPairs, len(pairs))
+	copy(sorted, pairs)
+	sort.Sort(kvPairsByKey(sorted))
+
+	tree := sha256.New()
+	keys := make([]*kvKeySum, 0, len(sorted))
+	for _, pair := range sorted {
+		key := strings.TrimPrefix(pair.Key, prefix)
+		writeKVChecksum(tree, key, pair)
+
+		h := sha256.New()
+		writeKVChecksum(h, key, pair)
+		keys = append(keys, &kvKeySum{key: key, sum: hex.EncodeToString(h.Sum(nil))})
+	}
+	return hex.EncodeToString(tree.Sum(nil)), keys
+}
+
+// writeKVChecksum writes a single key to the hash.
+func writeKVChecksum(h hash.Hash, key string, pair *api.KVPair) {
+	var n [8]byte
+	binary.BigEndian.PutUint64(n[:], uint64(len(key)))
+	h.Write(n[:])
+	h.Write([]byte(key))
+	binary.BigEndian.PutUint64(n[:], pair.Flags)
+	h.Write(n[:])
+	binary.BigEndian.PutUint64(n[:], uint64(len(pair.Value)))
+	h.Write(n[:])
+	h.Write(pair.Value)
+}
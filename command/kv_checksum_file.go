@@ -0,0 +1,53 @@
+package command
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// writeChecksumFile writes the SHA-256 sum of an export to the given path in
+// the format used by sha256sum, so it can also be checked with
+// "sha256sum -c". The name is that of the export, or "-" for stdout. Like
+// the export, it's written to a temporary file which is renamed into place.
+func writeChecksumFile(path, name string, sum []byte) error {
+	line := fmt.Sprintf("%s  %s\n", hex.EncodeToString(sum), name)
+	return kvRenderWriteFile(path, []byte(line))
+}
+
+// readChecksumFile returns the SHA-256 sum in a checksum file written by
+// writeChecksumFile or sha256sum. The file must hold a single sum, since
+// there's nothing to pick one from a list by.
+func readChecksumFile(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("Checksum file not found: %s", path)
+		}
+		return nil, fmt.Errorf("Failed to read checksum file: %s", err)
+	}
+
+	var sum []byte
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if sum != nil {
+			return nil, fmt.Errorf("Checksum file %s has more than one checksum", path)
+		}
+		sum, err = hex.DecodeString(fields[0])
+		if err != nil || len(sum) != 32 {
+			return nil, fmt.Errorf("Checksum file %s doesn't start with a SHA-256 sum", path)
+		}
+	}
+	if sum == nil {
+		return nil, fmt.Errorf("Checksum file %s is empty", path)
+	}
+	return sum, nil
+}
@@ -0,0 +1,181 @@
+package command
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+)
+
+func TestKVChecksumCommand_implements(t *testing.T) {
+	var _ cli.Command = &KVChecksumCommand{}
+}
+
+func TestKVChecksumCommand_noTabs(t *testing.T) {
+	assertNoTabs(t, new(KVChecksumCommand))
+}
+
+func TestKVChecksumCommand_Validation(t *testing.T) {
+	cases := map[string]struct {
+		args   []string
+		output string
+	}{
+		"too many args": {
+			[]string{"foo", "bar"},
+			"Too many arguments",
+		},
+		"bad digest": {
+			[]string{"-compare=xyz", "foo"},
+			"Invalid -compare digest",
+		},
+		"short digest": {
+			[]string{"-compare=abcd", "foo"},
+			"Invalid -compare digest",
+		},
+	}
+
+	for name, tc := range cases {
+		ui := new(cli.MockUi)
+		c := &KVChecksumCommand{Ui: ui}
+
+		if code := c.Run(tc.args); code != 1 {
+			t.Fatalf("%s: bad: %d", name, code)
+		}
+		if output := ui.ErrorWriter.String(); !strings.Contains(output, tc.output) {
+			t.Fatalf("%s: expected %q to contain %q", name, output, tc.output)
+		}
+	}
+}
+
+// The checksums are stored and compared later, so these must never change.
+// If this test fails, the hash definition has changed, and old checksums
+// will no longer match.
+const (
+	testKVChecksumGolden = "b7935d4c224fd6c66e672449f7b8c268ae4150e0209eefe31aadb6491717a949"
+	testKVChecksumEmpty  = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+func TestKVChecksum_golden(t *testing.T) {
+	pairs := api.KVPairs{
+		{Key: "app/z", Flags: 1<<64 - 1, Value: []byte("hello\n")},
+		{Key: "app/b/c", Flags: 42, Value: []byte{0x00, 0xff}},
+		{Key: "app/a", Value: []byte("1")},
+		{Key: "app/b/"},
+	}
+	sum, keys := kvChecksum("app/", pairs)
+	if sum != testKVChecksumGolden {
+		t.Fatalf("bad: %s", sum)
+	}
+
+	expected := []kvKeySum{
+		{"a", "b3d016d1fc62a911d3e2a9ed5a7a7d84399b4abafc53f7ac7b85bfb418148c22"},
+		{"b/", "1a308db2f8c581967e997839cf1dbd9b7747644693f39ec6957ade04132858a4"},
+		{"b/c", "c2181093e24ad91f4457bebcd7945e7a13c3f9015ce51101ba95d422eada5736"},
+		{"z", "d0e8a1fb6cfe5601fdddabbc9eb236a7020ce842fba878c7e9b63420b0dc2282"},
+	}
+	if len(keys) != len(expected) {
+		t.Fatalf("bad: %d keys", len(keys))
+	}
+	for i, k := range keys {
+		if *k != expected[i] {
+			t.Fatalf("bad: %d: %#v", i, k)
+		}
+	}
+
+	// The input isn't modified, and nothing at all has the hash of no input.
+	if pairs[0].Key != "app/z" {
+		t.Fatalf("bad: %#v", pairs)
+	}
+	if sum, _ := kvChecksum("app/", nil); sum != testKVChecksumEmpty {
+		t.Fatalf("bad: %s", sum)
+	}
+}
+
+func TestKVChecksumCommand_Run(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	for _, prefix := range []string{"app/", "copy/"} {
+		for _, pair := range []*api.KVPair{
+			{Key: prefix + "a", Value: []byte("1")},
+			{Key: prefix + "b/"},
+			{Key: prefix + "b/c", Flags: 42, Value: []byte{0x00, 0xff}},
+			{Key: prefix + "z", Flags: 1<<64 - 1, Value: []byte("hello\n")},
+		} {
+			if _, err := client.KV().Put(pair, nil); err != nil {
+				t.Fatalf("err: %v", err)
+			}
+		}
+	}
+
+	run := func(args ...string) (int, string, string) {
+		ui := new(cli.MockUi)
+		c := &KVChecksumCommand{Ui: ui}
+		code := c.Run(append([]string{"-http-addr=" + srv.httpAddr}, args...))
+		return code, ui.OutputWriter.String(), ui.ErrorWriter.String()
+	}
+
+	cases := map[string]struct {
+		args   []string
+		code   int
+		output string
+	}{
+		"prefix": {
+			[]string{"app"},
+			0,
+			testKVChecksumGolden + "\n",
+		},
+		"other prefix": {
+			[]string{"copy/"},
+			0,
+			testKVChecksumGolden + "\n",
+		},
+		"missing prefix": {
+			[]string{"nope"},
+			0,
+			testKVChecksumEmpty + "\n",
+		},
+		"detailed": {
+			[]string{"-detailed", "app"},
+			0,
+			"b3d016d1fc62a911d3e2a9ed5a7a7d84399b4abafc53f7ac7b85bfb418148c22  a\n" +
+				"1a308db2f8c581967e997839cf1dbd9b7747644693f39ec6957ade04132858a4  b/\n" +
+				"c2181093e24ad91f4457bebcd7945e7a13c3f9015ce51101ba95d422eada5736  b/c\n" +
+				"d0e8a1fb6cfe5601fdddabbc9eb236a7020ce842fba878c7e9b63420b0dc2282  z\n" +
+				testKVChecksumGolden + "\n",
+		},
+		"compare match": {
+			[]string{"-compare=" + strings.ToUpper(testKVChecksumGolden), "app"},
+			0,
+			testKVChecksumGolden + "\n",
+		},
+		"compare mismatch": {
+			[]string{"-compare=" + testKVChecksumEmpty, "app"},
+			2,
+			testKVChecksumGolden + "\n",
+		},
+	}
+
+	for name, tc := range cases {
+		code, output, errors := run(tc.args...)
+		if code != tc.code {
+			t.Fatalf("%s: bad: %d. %#v", name, code, errors)
+		}
+		if output != tc.output {
+			t.Fatalf("%s: bad: %#v", name, output)
+		}
+		if tc.code == 2 && !strings.Contains(errors, "Checksum mismatch") {
+			t.Fatalf("%s: bad: %#v", name, errors)
+		}
+	}
+
+	// Any change to a flag or value changes the checksum.
+	if _, err := client.KV().Put(&api.KVPair{Key: "copy/b/c", Flags: 43, Value: []byte{0x00, 0xff}}, nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if code, output, _ := run("-compare="+testKVChecksumGolden, "copy"); code != 2 || output == testKVChecksumGolden+"\n" {
+		t.Fatalf("bad: %d %#v", code, output)
+	}
+}
@@ -40,6 +40,10 @@ Usage: consul kv <subcommand> [options] [args]
 
       $ consul kv delete redis/config/connections
 
+  The get, delete and render commands, and import with -verify, exit with
+  status 0 on success, 1 for invalid usage or other errors, 2 if the key asked
+  for doesn't exist, or 3 if the request to the Consul agent failed.
+
   For more examples, ask for subcommand help or view the documentation.
 
 `
@@ -50,6 +54,29 @@ func (c *KVCommand) Synopsis() string {
 	return "Interact with the key-value store"
 }
 
+// Exit codes for the KV commands, so scripts can tell a missing key apart from
+// an agent which can't be reached. Invalid usage and other errors exit with 1.
+const (
+	// exitNotFound is the exit code when the key or prefix asked for doesn't
+	// exist.
+	exitNotFound = 2
+
+	// exitCommError is the exit code when a request to the agent failed, such
+	// as when it couldn't be reached or returned an error.
+	exitCommError = 3
+)
+
+const (
+	// kvMaxTxnOps is the maximum number of operations the agent will accept
+	// in a single transaction.
+	kvMaxTxnOps = 64
+
+	// kvMaxValueSize is the largest value the agent will accept for a key.
+	// This is also the limit on the combined size of all the values in a
+	// transaction.
+	kvMaxValueSize = 512 * 1024
+)
+
 var apiOptsText = strings.TrimSpace(`
 API Options:
 
@@ -57,20 +84,116 @@ API Options:
                           be an IP address or DNS address, but it must include
                           the port. This can also be specified via the
                           CONSUL_HTTP_ADDR environment variable. The default
-                          value is 127.0.0.1:8500.
+                          value is 127.0.0.1:8500. To use TLS, prefix the
+                          address with https:// or set the CONSUL_HTTP_SSL
+                          environment variable to true. To connect over a
+                          Unix domain socket, give unix:// followed by the
+                          path to the socket, such as
+                          unix:///var/run/consul.sock.
 
   -datacenter=<name>      Name of the datacenter to query. If unspecified, the
                           query will default to the datacenter of the Consul
                           agent at the HTTP address.
 
+  -namespace=<name>       Namespace to use in the request, for Consul
+                          Enterprise. This can also be specified via the
+                          CONSUL_NAMESPACE environment variable. If
+                          unspecified, the namespace of the token is used.
+                          Any namespace but "default" is an error if the
+                          agent isn't Consul Enterprise.
+
   -token=<value>          ACL token to use in the request. This can also be
                           specified via the CONSUL_HTTP_TOKEN environment
                           variable. If unspecified, the query will default to
                           the token of the Consul agent at the HTTP address.
 
+  -token-file=<path>      Path to a file containing the ACL token to use in
+                          the request, so it isn't visible in the process
+                          list or shell history. Trailing whitespace is
+                          removed. This can also be specified via the
+                          CONSUL_HTTP_TOKEN_FILE environment variable, and
+                          can't be combined with -token.
+
   -stale                  Permit any Consul server (non-leader) to respond to
                           this request. This allows for lower latency and higher
                           throughput, but can result in stale data. This option
                           has no effect on non-read operations. The default
                           value is false.
+
+  -consistent             Require the leader to check with a quorum of
+                          servers that it's still the leader before
+                          responding, so the data can't be stale. This is
+                          slower than the default, and can't be combined with
+                          -stale. This option has no effect on non-read
+                          operations. The default value is false.
+
+  -timeout=<duration>     Maximum time to wait for each request to the agent,
+                          including sending or receiving any data, such as a
+                          snapshot. Blocking queries are allowed their wait
+                          time on top of this. If a request times out, the
+                          command exits with status 3. The default value is 0,
+                          which means no timeout.
+
+  -retry=<int>            Number of times to retry a request which fails with
+                          a connection error or a 5xx response from the agent,
+                          such as while it's restarting. 4xx responses,
+                          including ACL errors, are not retried. Only requests
+                          which are safe to repeat are retried: reads, and
+                          writes which don't depend on the current state of
+                          the key. CAS writes read the key again before they
+                          are retried, and lock operations and snapshot
+                          restores are never retried. The default value is 0.
+
+  -retry-interval=<dur>   Time to wait before the first retry. The wait
+                          doubles after each retry, up to a minute. The
+                          default value is 1s.
+
+  -no-keepalive           Close the connection to the agent after each
+                          request, rather than reusing it for the next one.
+                          This can help with proxies that mishandle
+                          persistent connections. The default value is
+                          false.
+
+  -quiet                  Don't print the messages saying what the command
+                          did, such as "Success! Deleted key: foo", so only
+                          data is printed. The exit code tells whether the
+                          command worked. Errors and warnings are still
+                          printed. The default value is false.
+
+  -verbose                Log each request to the agent on stderr, with the
+                          response status and the time taken, and for reads
+                          the index of the result, whether the server knew
+                          of a leader, and how long since it last heard from
+                          it. Also show the raw error from the agent
+                          when a request is denied by ACLs, along with the
+                          explanation of the ACL rule needed. This can't be
+                          combined with -quiet. The default value is false.
+
+  -ca-file=<path>         Path to a CA file to use for TLS when communicating
+                          with Consul. This can also be specified via the
+                          CONSUL_CACERT environment variable.
+
+  -ca-path=<path>         Path to a directory of CA certificates to use for
+                          TLS when communicating with Consul. This can also be
+                          specified via the CONSUL_CAPATH environment variable.
+
+  -client-cert=<path>     Path to a client cert file to use for TLS when
+                          communicating with Consul. This requires -client-key
+                          and can also be specified via the CONSUL_CLIENT_CERT
+                          environment variable.
+
+  -client-key=<path>      Path to a client key file to use for TLS when
+                          communicating with Consul. This requires -client-cert
+                          and can also be specified via the CONSUL_CLIENT_KEY
+                          environment variable.
+
+  -tls-server-name=<name> Server name to use as the SNI host and to verify the
+                          agent's certificate against. This can also be
+                          specified via the CONSUL_TLS_SERVER_NAME environment
+                          variable.
+
+  -insecure               Disable verification of the agent's TLS certificate.
+                          This can also be set by setting the
+                          CONSUL_HTTP_SSL_VERIFY environment variable to false.
+                          The default value is false.
 `)
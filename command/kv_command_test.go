@@ -3,6 +3,7 @@ package command
 import (
 	"testing"
 
+	"github.com/hashicorp/consul/api"
 	"github.com/mitchellh/cli"
 )
 
@@ -13,3 +14,67 @@ func TestKVCommand_implements(t *testing.T) {
 func TestKVCommand_noTabs(t *testing.T) {
 	assertNoTabs(t, new(KVCommand))
 }
+
+func TestKVCommand_exitCodes(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	if _, err := client.KV().Put(&api.KVPair{Key: "foo", Value: []byte("bar")}, nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// An agent which has been stopped can't be reached at all.
+	stopped := testAgent(t)
+	stopped.Shutdown()
+
+	get := func(ui cli.Ui) cli.Command { return &KVGetCommand{Ui: ui} }
+	del := func(ui cli.Ui) cli.Command { return &KVDeleteCommand{Ui: ui} }
+	imp := func(ui cli.Ui) cli.Command { return &KVImportCommand{Ui: ui} }
+	cases := map[string]struct {
+		cmd     func(ui cli.Ui) cli.Command
+		args    []string
+		running int
+		stopped int
+	}{
+		"get": {
+			get, []string{"foo"}, 0, exitCommError,
+		},
+		"get missing": {
+			get, []string{"nope"}, exitNotFound, exitCommError,
+		},
+		"get usage": {
+			get, []string{"-raw", "-recurse", "foo"}, 1, 1,
+		},
+		"delete missing": {
+			del, []string{"-recurse", "-fail-if-missing", "nope/"}, exitNotFound, exitCommError,
+		},
+		"delete": {
+			del, []string{"nope"}, 0, exitCommError,
+		},
+		"import verify": {
+			imp, []string{"-verify-only", `[{"key":"foo","flags":0,"value":"YmFy"}]`}, 0, exitCommError,
+		},
+		"import verify missing": {
+			imp, []string{"-verify-only", `[{"key":"nope","flags":0,"value":"YmFy"}]`}, exitNotFound, exitCommError,
+		},
+		"import verify mismatch": {
+			imp, []string{"-verify-only", `[{"key":"foo","flags":1,"value":"YmFy"}]`}, 1, exitCommError,
+		},
+	}
+
+	for name, tc := range cases {
+		for _, addr := range []string{srv.httpAddr, stopped.httpAddr} {
+			expected := tc.running
+			if addr == stopped.httpAddr {
+				expected = tc.stopped
+			}
+
+			ui := new(cli.MockUi)
+			code := tc.cmd(ui).Run(append([]string{"-http-addr=" + addr}, tc.args...))
+			if code != expected {
+				t.Fatalf("%s, %s: bad: %d. %#v", name, addr, code, ui.ErrorWriter.String())
+			}
+		}
+	}
+}
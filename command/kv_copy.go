@@ -0,0 +1,200 @@
+package command
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+)
+
+// KVCopyCommand is a Command implementation that is used to copy or move a
+// key or tree in the key-value store.
+type KVCopyCommand struct {
+	Ui cli.Ui
+
+	// testAfterCopy is called after the keys are copied and before any are
+	// deleted by a move, for testing.
+	testAfterCopy func()
+}
+
+func (c *KVCopyCommand) Synopsis() string {
+	return "Copies or moves data in the KV store"
+}
+
+func (c *KVCopyCommand) Help() string {
+	helpText := `
+Usage: consul kv copy [options] SRC DST
+
+  Copies the key SRC to the key DST, preserving its flags:
+
+      $ consul kv copy redis/config/connections redis/config/max-connections
+
+  With the -recurse option, every key starting with SRC is copied, with SRC
+  replaced by DST:
+
+      $ consul kv copy -recurse app/prod/ app/staging/
+
+  Data can be copied to another datacenter through the same agent using the
+  "-dest-datacenter" option. To move data rather than copy it, use "-move" to
+  delete the source once it has been copied. A source key which is changed
+  while it is being moved is not deleted, so no data is lost.
+
+  For a full list of options and examples, please see the Consul documentation.
+
+` + apiOptsText + `
+
+KV Copy Options:
+
+  -dest-datacenter=<dc>   Datacenter to copy the data to. This defaults to the
+                          -datacenter value, which is the datacenter the data
+                          is read from.
+
+  -move                   Delete the source keys after they have been copied.
+                          Each key is deleted with a check-and-set against the
+                          index it was read at, so a key changed since it was
+                          copied is left in place. The default value is false.
+
+  -recurse                Copy every key starting with SRC, instead of the
+                          single key SRC. The default value is false.
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *KVCopyCommand) Run(args []string) (code int) {
+	cmdFlags, apiFlags := NewAPIFlagSet("copy", c.Ui)
+	defer apiFlags.CheckTimeout(c.Ui, &code)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	destDatacenter := cmdFlags.String("dest-datacenter", "", "")
+	recurse := cmdFlags.Bool("recurse", false, "")
+	move := cmdFlags.Bool("move", false, "")
+	if err := parseFlags(c.Ui, cmdFlags, args); err != nil {
+		return 1
+	}
+
+	// Check for arg validation
+	args = cmdFlags.Args()
+	switch len(args) {
+	case 0, 1:
+		c.Ui.Error("Error! Missing SRC and DST arguments")
+		return 1
+	case 2:
+	default:
+		c.Ui.Error(fmt.Sprintf("Too many arguments (expected 2, got %d)", len(args)))
+		return 1
+	}
+
+	// This is just a "nice" thing to do. Since pairs cannot start with a /,
+	// but users will likely put "/" or "/foo", lets go ahead and strip that
+	// for them here.
+	src := strings.TrimPrefix(args[0], "/")
+	dst := strings.TrimPrefix(args[1], "/")
+	if (src == "" && !*recurse) || dst == "" {
+		c.Ui.Error("Error! Missing SRC or DST key")
+		return 1
+	}
+
+	if *destDatacenter == "" {
+		*destDatacenter = apiFlags.Datacenter
+	}
+
+	// Copying a tree into itself would never finish, and copying a key onto
+	// itself would do nothing, or delete it with -move.
+	if *destDatacenter == apiFlags.Datacenter {
+		if src == dst {
+			c.Ui.Error("Error! SRC and DST are the same")
+			return 1
+		}
+		if *recurse && (strings.HasPrefix(dst, src) || strings.HasPrefix(src, dst)) {
+			c.Ui.Error(fmt.Sprintf("Error! SRC %q and DST %q overlap", src, dst))
+			return 1
+		}
+	}
+
+	// Create and test the HTTP client
+	client, err := apiFlags.Client()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+		return 1
+	}
+
+	qo := apiFlags.QueryOptions()
+	var pairs api.KVPairs
+	if *recurse {
+		pairs, _, err = client.KV().List(src, qo)
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Error querying Consul agent: %s", err))
+			return 1
+		}
+		if len(pairs) == 0 {
+			c.Ui.Error(fmt.Sprintf("Error! No keys exist with prefix: %s", src))
+			return 1
+		}
+	} else {
+		pair, _, err := client.KV().Get(src, qo)
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Error querying Consul agent: %s", err))
+			return 1
+		}
+		if pair == nil {
+			c.Ui.Error(fmt.Sprintf("Error! No key exists at: %s", src))
+			return 1
+		}
+		pairs = api.KVPairs{pair}
+	}
+
+	wo := &api.WriteOptions{
+		Datacenter: *destDatacenter,
+	}
+	for _, pair := range pairs {
+		dstKey := dst + strings.TrimPrefix(pair.Key, src)
+		if _, err := client.KV().Put(&api.KVPair{
+			Key:   dstKey,
+			Flags: pair.Flags,
+			Value: pair.Value,
+		}, wo); err != nil {
+			c.Ui.Error(fmt.Sprintf("Error! Failed writing data for key %s: %s", dstKey, err))
+			return 1
+		}
+		apiFlags.report(c.Ui, fmt.Sprintf("Copied: %s to %s", pair.Key, dstKey))
+	}
+
+	if !*move {
+		apiFlags.report(c.Ui, fmt.Sprintf("Success! Copied %d %s from %s to %s", len(pairs), pluralKeys(len(pairs)), src, dst))
+		return 0
+	}
+
+	if c.testAfterCopy != nil {
+		c.testAfterCopy()
+	}
+
+	// Only delete keys which haven't changed since they were copied, so a
+	// concurrent write is never lost.
+	wo = apiFlags.WriteOptions()
+	var kept []string
+	for _, pair := range pairs {
+		ok, _, err := client.KV().DeleteCAS(&api.KVPair{
+			Key:         pair.Key,
+			ModifyIndex: pair.ModifyIndex,
+		}, wo)
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Error! Failed deleting key %s: %s", pair.Key, err))
+			return 1
+		}
+		if !ok {
+			kept = append(kept, pair.Key)
+		}
+	}
+
+	if len(kept) > 0 {
+		for _, k := range kept {
+			c.Ui.Error(fmt.Sprintf("Not deleted: %s", k))
+		}
+		c.Ui.Error(fmt.Sprintf("Error! Copied %d %s, but left %d %s in place which changed during the move",
+			len(pairs), pluralKeys(len(pairs)), len(kept), pluralKeys(len(kept))))
+		return 1
+	}
+
+	apiFlags.report(c.Ui, fmt.Sprintf("Success! Moved %d %s from %s to %s", len(pairs), pluralKeys(len(pairs)), src, dst))
+	return 0
+}
@@ -0,0 +1,192 @@
+package command
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+)
+
+func TestKVCopyCommand_implements(t *testing.T) {
+	var _ cli.Command = &KVCopyCommand{}
+}
+
+func TestKVCopyCommand_noTabs(t *testing.T) {
+	assertNoTabs(t, new(KVCopyCommand))
+}
+
+func TestKVCopyCommand_Validation(t *testing.T) {
+	cases := map[string]struct {
+		args   []string
+		output string
+	}{
+		"no args": {
+			[]string{},
+			"Missing SRC and DST arguments",
+		},
+		"too many args": {
+			[]string{"foo", "bar", "baz"},
+			"Too many arguments",
+		},
+		"same key": {
+			[]string{"foo", "/foo"},
+			"SRC and DST are the same",
+		},
+		"dst under src": {
+			[]string{"-recurse", "app/", "app/copy/"},
+			"overlap",
+		},
+		"src under dst": {
+			[]string{"-recurse", "app/prod/", "app/"},
+			"overlap",
+		},
+		"copy everything": {
+			[]string{"-recurse", "", "backup/"},
+			"overlap",
+		},
+	}
+
+	for name, tc := range cases {
+		ui := new(cli.MockUi)
+		c := &KVCopyCommand{Ui: ui}
+
+		code := c.Run(tc.args)
+		if code != 1 {
+			t.Errorf("%s: expected exit 1, got %d", name, code)
+		}
+		if output := ui.ErrorWriter.String(); !strings.Contains(output, tc.output) {
+			t.Errorf("%s: expected %q to contain %q", name, output, tc.output)
+		}
+	}
+}
+
+func TestKVCopyCommand_Run(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	if _, err := client.KV().Put(&api.KVPair{Key: "foo", Flags: 42, Value: []byte("bar")}, nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	ui := new(cli.MockUi)
+	c := &KVCopyCommand{Ui: ui}
+
+	code := c.Run([]string{"-http-addr=" + srv.httpAddr, "foo", "baz"})
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+
+	pair, _, err := client.KV().Get("baz", nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if pair == nil || pair.Flags != 42 || string(pair.Value) != "bar" {
+		t.Fatalf("bad: %#v", pair)
+	}
+
+	// Missing keys are an error.
+	ui = new(cli.MockUi)
+	c = &KVCopyCommand{Ui: ui}
+	code = c.Run([]string{"-http-addr=" + srv.httpAddr, "nope", "baz"})
+	if code != 1 {
+		t.Fatalf("bad: %d", code)
+	}
+	if !strings.Contains(ui.ErrorWriter.String(), "No key exists at: nope") {
+		t.Fatalf("bad: %#v", ui.ErrorWriter.String())
+	}
+}
+
+func TestKVCopyCommand_RecurseMove(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	keys := map[string]string{
+		"prod/a":   "a",
+		"prod/b/c": "c",
+	}
+	for k, v := range keys {
+		if _, err := client.KV().Put(&api.KVPair{Key: k, Value: []byte(v)}, nil); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	ui := new(cli.MockUi)
+	c := &KVCopyCommand{Ui: ui}
+
+	code := c.Run([]string{"-http-addr=" + srv.httpAddr, "-recurse", "-move", "prod/", "staging/"})
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if !strings.Contains(ui.OutputWriter.String(), "Success! Moved 2 keys from prod/ to staging/") {
+		t.Fatalf("bad: %#v", ui.OutputWriter.String())
+	}
+
+	for k, v := range keys {
+		pair, _, err := client.KV().Get("staging/"+strings.TrimPrefix(k, "prod/"), nil)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if pair == nil || string(pair.Value) != v {
+			t.Fatalf("bad: %#v", pair)
+		}
+	}
+
+	remaining, _, err := client.KV().Keys("prod/", "", nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(remaining) != 0 {
+		t.Fatalf("bad: %#v", remaining)
+	}
+}
+
+func TestKVCopyCommand_MoveConflict(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	for _, k := range []string{"prod/a", "prod/b"} {
+		if _, err := client.KV().Put(&api.KVPair{Key: k, Value: []byte("old")}, nil); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	// Change one of the keys after it has been copied, as if another writer
+	// got in while the move was running.
+	ui := new(cli.MockUi)
+	c := &KVCopyCommand{
+		Ui: ui,
+		testAfterCopy: func() {
+			if _, err := client.KV().Put(&api.KVPair{Key: "prod/b", Value: []byte("new")}, nil); err != nil {
+				t.Fatalf("err: %v", err)
+			}
+		},
+	}
+
+	code := c.Run([]string{"-http-addr=" + srv.httpAddr, "-recurse", "-move", "prod/", "staging/"})
+	if code != 1 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if !strings.Contains(ui.ErrorWriter.String(), "Not deleted: prod/b") {
+		t.Fatalf("bad: %#v", ui.ErrorWriter.String())
+	}
+
+	// The new value must survive, and the unchanged key is still moved.
+	pair, _, err := client.KV().Get("prod/b", nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if pair == nil || string(pair.Value) != "new" {
+		t.Fatalf("bad: %#v", pair)
+	}
+	pair, _, err = client.KV().Get("prod/a", nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if pair != nil {
+		t.Fatalf("bad: %#v", pair)
+	}
+}
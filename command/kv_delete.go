@@ -1,11 +1,19 @@
 package command
 
 import (
-	"flag"
+	"bufio"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/hashicorp/consul/api"
+	"github.com/mattn/go-isatty"
 	"github.com/mitchellh/cli"
 )
 
@@ -13,6 +21,13 @@ import (
 // prefix of keys from the key-value store.
 type KVDeleteCommand struct {
 	Ui cli.Ui
+
+	// testStdin is the input for testing.
+	testStdin io.Reader
+
+	// testStdinTerminal overrides the check for whether stdin is a
+	// terminal, for testing.
+	testStdinTerminal *bool
 }
 
 func (c *KVDeleteCommand) Help() string {
@@ -31,35 +46,151 @@ Usage: consul kv delete [options] KEY_OR_PREFIX
       $ consul kv delete -recurse foo
 
   This will delete the keys named "foo", "food", and "foo/bar/zip" if they
-  existed.
+  existed, and report how many keys were deleted. Recursive deletes ask for
+  confirmation first, which can be skipped with the -force option. When not
+  running interactively, -force is required.
+
+  To preview the keys a recursive delete would remove, add -dry-run:
+
+      $ consul kv delete -recurse -dry-run foo
+
+  To delete a prefix only if none of its keys have been modified since a given
+  index, combine -recurse with -cas:
+
+      $ consul kv delete -recurse -cas -modify-index=844 -force foo/
+
+  Only keys which existed when the prefix was listed are deleted, and if a key
+  changes after its batch was checked, the delete stops at that batch.
+
+  To delete only the keys under a prefix with the given flags, such as the
+  entries a team tags with the value 42 in the low byte of their flags:
+
+      $ consul kv delete -recurse -flags=42 -flags-mask=0xff foo/
+
+  Since a prefix can't be deleted by flags in one request, the keys are
+  listed first, and each matching key is deleted with a CAS operation so that
+  a key which changes in between is left alone.
+
+  To delete only the keys under a prefix whose path matches a pattern, give a
+  glob with -match or a regular expression with -regex. The pattern is
+  applied to the full key path, not the part after the prefix:
+
+      $ consul kv delete -recurse -match='app/*/cache/*' app/
+
+  Like deleting by flags, the keys are listed first and the matching keys are
+  deleted with CAS operations, in transactions of up to 64 keys.
+
+  To delete a list of keys, one per line, pass "-" as the key to read them from
+  stdin. The keys are deleted in batches using transactions:
+
+      $ cat keys.txt | consul kv delete -
+
+  To record deletes in an audit log, -format=json prints the result as JSON,
+  including the Raft index at which the keys were deleted. The agent doesn't
+  return the index of a delete, so it's looked up with a consistent read of
+  the key or prefix afterwards, and is the index of the latest write under
+  it. With -verbose, the index is also reported after the usual message.
+
+  To keep a durable record of who deleted what, give a file with -audit-log,
+  and a line of JSON is appended to it before each delete is made. If the
+  line can't be written, nothing is deleted:
+
+      $ consul kv delete -recurse -audit-log=/var/log/consul-kv.log app/
 
 ` + apiOptsText + `
 
+` + kvKeyOptsText + `
+
+` + kvFlagsFilterOptsText + `
+
+  The flags filter only works with -recurse.
+
+` + kvAuditOptsText + `
+
 KV Delete Options:
 
   -cas                    Perform a Check-And-Set operation. Specifying this
                           value also requires the -modify-index flag to be set.
-                          The default value is false.
+                          With -recurse, every key under the prefix must have
+                          a ModifyIndex no greater than -modify-index, or the
+                          ModifyIndex given for it by -verify-file, and each
+                          key is deleted with a CAS operation against the
+                          index that was read. Up to 64 keys are deleted in
+                          one transaction, so a prefix with more keys than
+                          that is checked in full first, then deleted in
+                          batches. The default value is false.
+
+  -dry-run                List the keys that a recursive delete would remove,
+                          one per line, without deleting anything. This can
+                          only be used with -recurse. The default value is
+                          false.
+
+  -fail-if-missing        Exit with status 2 if no keys match the prefix given
+                          with -recurse, instead of reporting that there was
+                          nothing to delete. The default value is false.
+
+  -match=<glob>           Only delete the keys under the prefix whose full
+                          path matches this glob pattern, such as
+                          "app/*/cache/*". A "*" matches any characters except
+                          "/", "?" matches one character, and "[...]" matches
+                          a range as in a shell. The pattern must match the
+                          whole key, including the prefix. This can only be
+                          used with -recurse.
+
+  -force                  Delete keys recursively without asking for
+                          confirmation. This is required for recursive deletes
+                          when stdin is not a terminal. The default value is
+                          false.
+
+  -format=<string>        Output format for a delete which doesn't fail,
+                          either "text" or "json". With "json", an object is
+                          printed for audit logs, with the operation, the key
+                          or prefix, the number of keys deleted, the Raft
+                          index of the delete, the time it finished, and how
+                          long its requests took in nanoseconds. This can't
+                          be used with -dry-run. The default value is "text".
 
   -modify-index=<int>     Unsigned integer representing the ModifyIndex of the
                           key. This is used in combination with the -cas flag.
 
   -recurse                Recursively delete all keys with the path. The default
                           value is false.
+
+  -regex=<regexp>         Only delete the keys under the prefix whose full
+                          path matches this regular expression. The match is
+                          unanchored, so "cache" matches any key containing
+                          it; use "^" and "$" to match the whole key. This can
+                          only be used with -recurse, and not with -match.
+
+  -verify-file=<path>     Path to an export of the prefix, from "consul kv
+                          export", to check a recursive CAS delete against
+                          instead of -modify-index. Every key under the prefix
+                          must be in the export with the same ModifyIndex,
+                          and every key in the export must still exist. The
+                          export can be JSON or YAML, and compressed with gzip.
 `
 	return strings.TrimSpace(helpText)
 }
 
-func (c *KVDeleteCommand) Run(args []string) int {
-	cmdFlags := flag.NewFlagSet("get", flag.ContinueOnError)
+func (c *KVDeleteCommand) Run(args []string) (code int) {
+	cmdFlags, apiFlags := NewAPIFlagSet("get", c.Ui)
+	defer apiFlags.CheckTimeout(c.Ui, &code)
 	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
-	datacenter := cmdFlags.String("datacenter", "", "")
-	token := cmdFlags.String("token", "", "")
 	cas := cmdFlags.Bool("cas", false, "")
 	modifyIndex := cmdFlags.Uint64("modify-index", 0, "")
 	recurse := cmdFlags.Bool("recurse", false, "")
-	httpAddr := HTTPAddrFlag(cmdFlags)
-	if err := cmdFlags.Parse(args); err != nil {
+	failIfMissing := cmdFlags.Bool("fail-if-missing", false, "")
+	force := cmdFlags.Bool("force", false, "")
+	dryRun := cmdFlags.Bool("dry-run", false, "")
+	verifyFile := cmdFlags.String("verify-file", "", "")
+	format := cmdFlags.String("format", "text", "")
+	keyFlags := newKVKeyFlags(cmdFlags)
+	flagsFilter := newKVFlagsFilter(cmdFlags)
+	keyMatch := &kvKeyMatch{}
+	cmdFlags.StringVar(&keyMatch.glob, "match", "", "")
+	cmdFlags.StringVar(&keyMatch.expr, "regex", "", "")
+	audit := newKVAuditFlags(cmdFlags)
+	if err := parseFlags(c.Ui, cmdFlags, args); err != nil {
 		return 1
 	}
 
@@ -77,91 +208,805 @@ func (c *KVDeleteCommand) Run(args []string) int {
 		return 1
 	}
 
-	// This is just a "nice" thing to do. Since pairs cannot start with a /, but
-	// users will likely put "/" or "/foo", lets go ahead and strip that for them
-	// here.
-	if len(key) > 0 && key[0] == '/' {
-		key = key[1:]
-	}
-
-	// If the key is empty and we are not doing a recursive delete, this is an
-	// error.
-	if key == "" && !*recurse {
-		c.Ui.Error("Error! Missing KEY argument")
+	// Keys can't start with a slash, but users will likely put "/" or "/foo",
+	// so that's stripped along with anything else that needs normalizing.
+	key, err := keyFlags.check(c.Ui, key)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error! %s", err))
 		return 1
 	}
 
-	// ModifyIndex is required for CAS
-	if *cas && *modifyIndex == 0 {
-		c.Ui.Error("Must specify -modify-index with -cas!")
-		return 1
+	// Report every problem with the arguments at once
+	stdin := key == "-"
+	errs := kvDeleteValidate(key, *cas, *modifyIndex, *recurse, *dryRun, *verifyFile)
+	if flagsFilter.enabled() && (!*recurse || stdin) {
+		errs = append(errs, "Can only specify -flags or -flags-mask with -recurse!")
 	}
-
-	// Specifying a ModifyIndex for a non-CAS operation is not possible.
-	if *modifyIndex != 0 && !*cas {
-		c.Ui.Error("Cannot specify -modify-index without -cas!")
+	if err := flagsFilter.validate(); err != nil {
+		errs = append(errs, fmt.Sprintf("Error! %s", err))
 	}
-
-	// It is not valid to use a CAS and recurse in the same call
-	if *recurse && *cas {
-		c.Ui.Error("Cannot specify both -cas and -recurse!")
+	if keyMatch.enabled() && (!*recurse || stdin) {
+		errs = append(errs, "Can only specify -match or -regex with -recurse!")
+	}
+	if err := keyMatch.compile(); err != nil {
+		errs = append(errs, fmt.Sprintf("Error! %s", err))
+	}
+	if *format != "text" && *format != "json" {
+		errs = append(errs, fmt.Sprintf("Error! Unsupported format %q (expected text or json)", *format))
+	}
+	if *format == "json" && *dryRun {
+		errs = append(errs, "Cannot specify -format=json with -dry-run!")
+	}
+	if audit.bestEffort && !audit.enabled() {
+		errs = append(errs, "Can only specify -audit-best-effort with -audit-log or "+kvAuditLogEnvName+"!")
+	}
+	if len(errs) > 0 {
+		for _, err := range errs {
+			c.Ui.Error(err)
+		}
 		return 1
 	}
 
-	// Create and test the HTTP client
-	conf := api.DefaultConfig()
-	conf.Address = *httpAddr
-	if *token != "" {
-		conf.Token = *token
+	// Read the indexes to check against up front, so a bad file fails
+	// before any requests
+	var expected map[string]uint64
+	if *verifyFile != "" {
+		var err error
+		if expected, err = kvReadIndexes(*verifyFile, key, flagsFilter, keyMatch); err != nil {
+			c.Ui.Error(fmt.Sprintf("Error! %s", err))
+			return 1
+		}
 	}
-	client, err := api.NewClient(conf)
+
+	// Create and test the HTTP client
+	client, err := apiFlags.Client()
 	if err != nil {
 		c.Ui.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
 		return 1
 	}
 
-	wo := &api.WriteOptions{
-		Datacenter: *datacenter,
+	wo := apiFlags.WriteOptions()
+
+	// Describe the filters on a recursive delete for messages
+	var filters []string
+	if flagsFilter.enabled() {
+		filters = append(filters, flagsFilter.String())
 	}
+	if keyMatch.enabled() {
+		filters = append(filters, keyMatch.String())
+	}
+	filterDesc := strings.Join(filters, ", ")
+	asJSON := *format == "json"
 
 	switch {
-	case *recurse:
-		if _, err := client.KV().DeleteTree(key, wo); err != nil {
+	case stdin:
+		keys, err := c.keysFromStdin(keyFlags)
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Error! %s", err))
+			return 1
+		}
+
+		if !audit.record(c.Ui, client, apiFlags, &kvAuditEntry{
+			Operation:    "delete-keys",
+			Keys:         keys,
+			KeysAffected: len(keys),
+		}) {
+			return 1
+		}
+
+		total, failed := len(keys), 0
+		start := time.Now()
+		// A denial by ACLs is explained once, after the keys are listed.
+		var denied error
+		var deniedKey string
+		for batch := keys; len(batch) > 0; {
+			n := kvDeleteBatchSize
+			if n > len(batch) {
+				n = len(batch)
+			}
+
+			if err := kvDeleteBatch(client, batch[:n], wo); err != nil {
+				for _, k := range batch[:n] {
+					c.Ui.Error(fmt.Sprintf("Error! Did not delete key %s: %s", k, err))
+				}
+				failed += n
+				if denied == nil && isPermissionDenied(err) {
+					denied, deniedKey = err, kvDeniedKey(err, batch[0])
+				}
+			}
+			batch = batch[n:]
+		}
+
+		deleted := total - failed
+		if denied != nil {
+			c.Ui.Error(apiFlags.errorMessage("Error! Did not delete keys", denied,
+				kvDenial("delete "+deniedKey, deniedKey, "write")))
+		}
+		if failed > 0 {
+			c.Ui.Error(fmt.Sprintf("Error! Deleted %d %s, failed to delete %d %s",
+				deleted, pluralKeys(deleted), failed, pluralKeys(failed)))
+			return 1
+		}
+
+		result := &kvDeleteResult{
+			Operation:   "delete-keys",
+			Keys:        keys,
+			KeysDeleted: deleted,
+			RequestTime: time.Since(start),
+		}
+		return c.done(client, apiFlags, asJSON, result, kvCommonPrefix(keys),
+			fmt.Sprintf("Success! Deleted %d %s", deleted, pluralKeys(deleted)))
+	case *recurse && *cas:
+		pairs, _, err := client.KV().List(key, apiFlags.QueryOptions())
+		if err != nil {
+			c.Ui.Error(apiFlags.errorMessage("Error querying Consul agent", err,
+				kvDenial("read keys under "+key, key, "read")))
+			return exitCommError
+		}
+		pairs, skipped := flagsFilter.filter(pairs)
+		pairs, unmatched := keyMatch.filter(pairs)
+		if skipped += unmatched; skipped > 0 {
+			apiFlags.note(c.Ui, fmt.Sprintf("Skipping %d %s not matching (%s)", skipped, pluralKeys(skipped), filterDesc))
+		}
+
+		if err := kvCheckIndexes(pairs, *modifyIndex, expected); err != nil {
 			c.Ui.Error(fmt.Sprintf("Error! Did not delete prefix %s: %s", key, err))
 			return 1
 		}
 
-		c.Ui.Info(fmt.Sprintf("Success! Deleted keys with prefix: %s", key))
-		return 0
+		result := &kvDeleteResult{Operation: "delete-tree-cas", Prefix: key}
+		if len(pairs) == 0 {
+			if *failIfMissing {
+				c.Ui.Error(fmt.Sprintf("Error! No keys exist with prefix: %s", key))
+				return exitNotFound
+			}
+			return c.done(client, apiFlags, asJSON, result, key,
+				fmt.Sprintf("No keys to delete with prefix: %s", key))
+		}
+
+		if !*force && !c.confirm(key, len(pairs)) {
+			return 1
+		}
+		if !audit.record(c.Ui, client, apiFlags, &kvAuditEntry{
+			Operation:    "delete-tree-cas",
+			Prefix:       key,
+			Recurse:      true,
+			CAS:          true,
+			ModifyIndex:  *modifyIndex,
+			Filter:       filterDesc,
+			KeysAffected: len(pairs),
+		}) {
+			return 1
+		}
+
+		apiFlags.note(c.Ui, fmt.Sprintf("Deleting %d %s with prefix: %s", len(pairs), pluralKeys(len(pairs)), key))
+		start := time.Now()
+		deleted, err := kvDeleteTreeCAS(client, pairs, wo)
+		if err != nil {
+			k := kvDeniedKey(err, key)
+			c.Ui.Error(apiFlags.errorMessage(fmt.Sprintf("Error! Did not delete prefix %s", key),
+				err, kvDenial("delete "+k, k, "write")))
+			if deleted > 0 {
+				c.Ui.Error(fmt.Sprintf("Deleted %d of %d %s before the failure",
+					deleted, len(pairs), pluralKeys(len(pairs))))
+			}
+			if _, ok := err.(*kvCASError); ok {
+				return 1
+			}
+			return exitCommError
+		}
+
+		result.KeysDeleted, result.RequestTime = deleted, time.Since(start)
+		return c.done(client, apiFlags, asJSON, result, key,
+			fmt.Sprintf("Success! Deleted %d %s with prefix: %s", deleted, pluralKeys(deleted), key))
+	case *recurse && (flagsFilter.enabled() || keyMatch.enabled()):
+		// DeleteTree can't filter by flags or pattern, so the entries are
+		// listed and the matching ones deleted with CAS operations, which
+		// leave alone any key that changed after it was listed, since its
+		// flags may have changed too.
+		pairs, _, err := client.KV().List(key, apiFlags.QueryOptions())
+		if err != nil {
+			c.Ui.Error(apiFlags.errorMessage("Error querying Consul agent", err,
+				kvDenial("read keys under "+key, key, "read")))
+			return exitCommError
+		}
+		total := len(pairs)
+		pairs, skipped := flagsFilter.filter(pairs)
+		pairs, unmatched := keyMatch.filter(pairs)
+		skipped += unmatched
+
+		// A prefix with no keys is reported apart from keys that are all
+		// filtered out, since the latter usually means a wrong pattern.
+		result := &kvDeleteResult{Operation: "delete-tree-cas", Prefix: key}
+		if len(pairs) == 0 {
+			missing, msg := fmt.Sprintf("No keys exist with prefix: %s", key),
+				fmt.Sprintf("No keys to delete with prefix: %s", key)
+			if total > 0 {
+				match := fmt.Sprintf("of the %d %s with prefix %s match (%s)",
+					total, pluralKeys(total), key, filterDesc)
+				missing, msg = "None "+match, "No keys to delete, none "+match
+			}
+			switch {
+			case *failIfMissing:
+				c.Ui.Error("Error! " + missing)
+				return exitNotFound
+			case *dryRun:
+				apiFlags.note(c.Ui, msg)
+			default:
+				return c.done(client, apiFlags, asJSON, result, key, msg)
+			}
+			return 0
+		}
+
+		if *dryRun {
+			for _, pair := range pairs {
+				c.Ui.Info(pair.Key)
+			}
+			return 0
+		}
+
+		if skipped > 0 {
+			apiFlags.note(c.Ui, fmt.Sprintf("Skipping %d %s not matching (%s)", skipped, pluralKeys(skipped), filterDesc))
+		}
+
+		if !*force && !c.confirm(key, len(pairs)) {
+			return 1
+		}
+		if !audit.record(c.Ui, client, apiFlags, &kvAuditEntry{
+			Operation:    "delete-tree-cas",
+			Prefix:       key,
+			Recurse:      true,
+			Filter:       filterDesc,
+			KeysAffected: len(pairs),
+		}) {
+			return 1
+		}
+
+		apiFlags.note(c.Ui, fmt.Sprintf("Deleting %d %s with prefix: %s (%s)",
+			len(pairs), pluralKeys(len(pairs)), key, filterDesc))
+		start := time.Now()
+		deleted, err := kvDeleteTreeCAS(client, pairs, wo)
+		if err != nil {
+			k := kvDeniedKey(err, key)
+			c.Ui.Error(apiFlags.errorMessage(fmt.Sprintf("Error! Did not delete prefix %s", key),
+				err, kvDenial("delete "+k, k, "write")))
+			if deleted > 0 {
+				c.Ui.Error(fmt.Sprintf("Deleted %d of %d %s before the failure",
+					deleted, len(pairs), pluralKeys(len(pairs))))
+			}
+			if _, ok := err.(*kvCASError); ok {
+				return 1
+			}
+			return exitCommError
+		}
+
+		result.KeysDeleted, result.RequestTime = deleted, time.Since(start)
+		return c.done(client, apiFlags, asJSON, result, key, fmt.Sprintf("Success! Deleted %d %s with prefix: %s (%s)",
+			deleted, pluralKeys(deleted), key, filterDesc))
+	case *recurse:
+		// List the matching keys first so we can report what's being
+		// removed, and so a mistyped prefix doesn't silently succeed.
+		keys, _, err := client.KV().Keys(key, "", apiFlags.QueryOptions())
+		if err != nil {
+			c.Ui.Error(apiFlags.errorMessage("Error querying Consul agent", err,
+				kvDenial("read keys under "+key, key, "read")))
+			return exitCommError
+		}
+
+		if len(keys) == 0 && *failIfMissing {
+			c.Ui.Error(fmt.Sprintf("Error! No keys exist with prefix: %s", key))
+			return exitNotFound
+		}
+
+		// Print the keys without any decoration so the output can be fed
+		// back into a bulk delete.
+		if *dryRun {
+			for _, k := range keys {
+				c.Ui.Info(k)
+			}
+			return 0
+		}
+
+		result := &kvDeleteResult{Operation: "delete-tree", Prefix: key}
+		if len(keys) == 0 {
+			return c.done(client, apiFlags, asJSON, result, key,
+				fmt.Sprintf("No keys to delete with prefix: %s", key))
+		}
+
+		if !*force && !c.confirm(key, len(keys)) {
+			return 1
+		}
+		if !audit.record(c.Ui, client, apiFlags, &kvAuditEntry{
+			Operation:    "delete-tree",
+			Prefix:       key,
+			Recurse:      true,
+			KeysAffected: len(keys),
+		}) {
+			return 1
+		}
+
+		apiFlags.note(c.Ui, fmt.Sprintf("Deleting %d %s with prefix: %s", len(keys), pluralKeys(len(keys)), key))
+		wm, err := client.KV().DeleteTree(key, wo)
+		if err != nil {
+			c.Ui.Error(apiFlags.errorMessage(fmt.Sprintf("Error! Did not delete prefix %s", key),
+				err, kvDenial("delete keys under "+key, key, "write")))
+			return exitCommError
+		}
+
+		result.KeysDeleted, result.RequestTime = len(keys), wm.RequestTime
+		return c.done(client, apiFlags, asJSON, result, key,
+			fmt.Sprintf("Success! Deleted %d %s with prefix: %s", len(keys), pluralKeys(len(keys)), key))
 	case *cas:
 		pair := &api.KVPair{
 			Key:         key,
 			ModifyIndex: *modifyIndex,
 		}
+		if !audit.record(c.Ui, client, apiFlags, &kvAuditEntry{
+			Operation:    "delete-cas",
+			Key:          key,
+			CAS:          true,
+			ModifyIndex:  *modifyIndex,
+			KeysAffected: 1,
+		}) {
+			return 1
+		}
 
-		success, _, err := client.KV().DeleteCAS(pair, wo)
+		success, wm, err := client.KV().DeleteCAS(pair, wo)
 		if err != nil {
-			c.Ui.Error(fmt.Sprintf("Error! Did not delete key %s: %s", key, err))
-			return 1
+			c.Ui.Error(apiFlags.errorMessage(fmt.Sprintf("Error! Did not delete key %s", key),
+				err, kvDenial("delete "+key, key, "write")))
+			return exitCommError
 		}
 		if !success {
 			c.Ui.Error(fmt.Sprintf("Error! Did not delete key %s: CAS failed", key))
 			return 1
 		}
 
-		c.Ui.Info(fmt.Sprintf("Success! Deleted key: %s", key))
-		return 0
+		result := &kvDeleteResult{Operation: "delete-cas", Key: key, KeysDeleted: 1, RequestTime: wm.RequestTime}
+		return c.done(client, apiFlags, asJSON, result, key, fmt.Sprintf("Success! Deleted key: %s", key))
 	default:
-		if _, err := client.KV().Delete(key, wo); err != nil {
-			c.Ui.Error(fmt.Sprintf("Error deleting key %s: %s", key, err))
+		if !audit.record(c.Ui, client, apiFlags, &kvAuditEntry{Operation: "delete", Key: key, KeysAffected: 1}) {
 			return 1
 		}
+		wm, err := client.KV().Delete(key, wo)
+		if err != nil {
+			c.Ui.Error(apiFlags.errorMessage(fmt.Sprintf("Error deleting key %s", key),
+				err, kvDenial("delete "+key, key, "write")))
+			return exitCommError
+		}
+
+		// The agent doesn't say whether the key existed, so it's counted
+		// as deleted either way.
+		result := &kvDeleteResult{Operation: "delete", Key: key, KeysDeleted: 1, RequestTime: wm.RequestTime}
+		return c.done(client, apiFlags, asJSON, result, key, fmt.Sprintf("Success! Deleted key: %s", key))
+	}
+}
+
+// kvDeleteResult is a delete which didn't fail, as printed with -format=json
+// for audit logs. Only one of Key, Prefix, and Keys is set, for deleting a
+// key, a prefix, and a list of keys from stdin respectively.
+type kvDeleteResult struct {
+	// Operation is one of "delete", "delete-cas", "delete-tree",
+	// "delete-tree-cas" for a recursive delete done with CAS operations,
+	// as with -cas or a filter, or "delete-keys".
+	Operation string
+
+	Key    string   `json:",omitempty"`
+	Prefix string   `json:",omitempty"`
+	Keys   []string `json:",omitempty"`
+
+	KeysDeleted int
+
+	// Index is the Raft index at which the keys were deleted, as found by
+	// kvDeleteIndex, or zero if nothing was deleted or it couldn't be
+	// found.
+	Index uint64 `json:",omitempty"`
+
+	// Time is when the delete finished, by the local clock, and
+	// RequestTime is how long the requests for it took.
+	Time        time.Time
+	RequestTime time.Duration
+}
+
+// done reports a delete which didn't fail, as the message or, with
+// -format=json, as the result. With -format=json or -verbose, the index of
+// the delete is looked up first, which takes another request since the agent
+// doesn't return it for deletes.
+func (c *KVDeleteCommand) done(client *api.Client, apiFlags *APIFlags, asJSON bool, result *kvDeleteResult,
+	prefix string, msg string) int {
+	result.Time = time.Now().UTC()
+	if result.KeysDeleted > 0 && (asJSON || apiFlags.Verbose) {
+		index, err := kvDeleteIndex(client, prefix, apiFlags.QueryOptions())
+		if err != nil {
+			c.Ui.Warn(fmt.Sprintf("Warning! Failed to look up the index of the delete: %s", err))
+		}
+		result.Index = index
+	}
+
+	if asJSON {
+		return printJSON(c.Ui, result)
+	}
+	apiFlags.report(c.Ui, msg)
+	if result.Index > 0 {
+		apiFlags.note(c.Ui, fmt.Sprintf("Deleted at index %d, the requests took %s", result.Index, result.RequestTime))
+	}
+	return 0
+}
 
-		c.Ui.Info(fmt.Sprintf("Success! Deleted key: %s", key))
-		return 0
+// kvDeleteIndex looks up the Raft index at which the keys under a prefix were
+// deleted. A consistent listing of the prefix has the highest index of its
+// keys and of the tombstones left by deletes, which is the delete's unless
+// something else under the prefix was written since. Only the top level of
+// the prefix is listed, since the index covers the keys left out too.
+func kvDeleteIndex(client *api.Client, prefix string, q *api.QueryOptions) (uint64, error) {
+	q.AllowStale, q.RequireConsistent = false, true
+	_, meta, err := client.KV().Keys(prefix, "/", q)
+	if err != nil {
+		return 0, err
 	}
+	return meta.LastIndex, nil
 }
 
 func (c *KVDeleteCommand) Synopsis() string {
 	return "Removes data from the KV store"
 }
+
+// confirm asks the user to approve deleting the given number of keys under
+// the prefix, reporting why not if they don't.
+func (c *KVDeleteCommand) confirm(prefix string, n int) bool {
+	if !c.stdinIsTerminal() {
+		c.Ui.Error("Error! Refusing to delete keys recursively without confirmation. " +
+			"Use -force to skip the prompt when not running interactively.")
+		return false
+	}
+
+	query := fmt.Sprintf("Delete %d %s with prefix %q? Only 'yes' will be accepted to approve.",
+		n, pluralKeys(n), prefix)
+	answer, err := c.Ui.Ask(query)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error reading confirmation: %s", err))
+		return false
+	}
+	if strings.TrimSpace(answer) != "yes" {
+		c.Ui.Error("Delete cancelled, no keys were deleted")
+		return false
+	}
+	return true
+}
+
+// keysFromStdin reads a list of keys to delete, one per line. Blank lines
+// are skipped, whitespace around each key is trimmed, and the keys are
+// normalized as with the KEY argument.
+func (c *KVDeleteCommand) keysFromStdin(keyFlags *kvKeyFlags) ([]string, error) {
+	var stdin io.Reader = os.Stdin
+	if c.testStdin != nil {
+		stdin = c.testStdin
+	}
+
+	var keys []string
+	scanner := bufio.NewScanner(stdin)
+	for line := 1; scanner.Scan(); line++ {
+		key := strings.TrimSpace(scanner.Text())
+		if key == "" {
+			continue
+		}
+		key, err := keyFlags.check(c.Ui, key)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err)
+		}
+		keys = append(keys, key)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Failed to read stdin: %s", err)
+	}
+	return keys, nil
+}
+
+// kvDeleteBatchSize is the number of keys deleted per transaction when
+// reading keys from stdin.
+const kvDeleteBatchSize = kvMaxTxnOps
+
+// kvDeleteValidate checks the combination of arguments for a delete, and
+// returns a message for each problem found.
+func kvDeleteValidate(key string, cas bool, modifyIndex uint64, recurse, dryRun bool, verifyFile string) []string {
+	var errs []string
+
+	// If the key is empty and we are not doing a recursive delete, this is an
+	// error.
+	if key == "" && !recurse {
+		errs = append(errs, "Error! Missing KEY argument")
+	}
+
+	// ModifyIndex is required for CAS, unless the indexes for a recursive
+	// delete come from a file
+	switch {
+	case cas && recurse && modifyIndex == 0 && verifyFile == "":
+		errs = append(errs, "Must specify -modify-index or -verify-file with -cas and -recurse!")
+	case cas && !recurse && modifyIndex == 0:
+		errs = append(errs, "Must specify -modify-index with -cas!")
+	}
+	if verifyFile != "" && !(cas && recurse) {
+		errs = append(errs, "Can only specify -verify-file with -cas and -recurse!")
+	}
+	if verifyFile != "" && modifyIndex != 0 {
+		errs = append(errs, "Cannot specify both -modify-index and -verify-file!")
+	}
+
+	// Specifying a ModifyIndex for a non-CAS operation is not possible.
+	if modifyIndex != 0 && !cas {
+		errs = append(errs, "Cannot specify -modify-index without -cas!")
+	}
+
+	// A dry run only makes sense for a recursive delete
+	if dryRun && (cas || !recurse) {
+		errs = append(errs, "Can only specify -dry-run with -recurse!")
+	}
+
+	// Reading the keys from stdin only supports plain deletes
+	if key == "-" && (recurse || cas) {
+		errs = append(errs, "Cannot specify -cas or -recurse when reading keys from stdin!")
+	}
+
+	return errs
+}
+
+// kvDeleteBatch deletes the given keys in a single transaction.
+func kvDeleteBatch(client *api.Client, keys []string, wo *api.WriteOptions) error {
+	ops := make(api.KVTxnOps, 0, len(keys))
+	for _, k := range keys {
+		ops = append(ops, &api.KVTxnOp{
+			Verb: api.KVDelete,
+			Key:  k,
+		})
+	}
+
+	ok, resp, err := kvTxn(client, ops, &api.QueryOptions{
+		Datacenter: wo.Datacenter,
+		Token:      wo.Token,
+	})
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return newKVTxnError(keys, resp)
+	}
+	return nil
+}
+
+// kvCASError is returned when a key changed before a recursive CAS delete.
+type kvCASError struct {
+	error
+}
+
+// kvCheckIndexes checks that none of the pairs under a prefix have been
+// modified. Without expected indexes, no pair may have a ModifyIndex greater
+// than the given index. Otherwise every pair must have the expected index,
+// and every expected key must exist. The first key which fails is reported.
+func kvCheckIndexes(pairs api.KVPairs, index uint64, expected map[string]uint64) error {
+	if expected == nil {
+		for _, pair := range pairs {
+			if pair.ModifyIndex > index {
+				return &kvCASError{fmt.Errorf("key %s was modified at index %d, after %d",
+					pair.Key, pair.ModifyIndex, index)}
+			}
+		}
+		return nil
+	}
+
+	live := make(map[string]struct{}, len(pairs))
+	for _, pair := range pairs {
+		live[pair.Key] = struct{}{}
+		want, ok := expected[pair.Key]
+		switch {
+		case !ok:
+			return &kvCASError{fmt.Errorf("key %s was added since the export", pair.Key)}
+		case pair.ModifyIndex != want:
+			return &kvCASError{fmt.Errorf("key %s was modified at index %d, but was at %d in the export",
+				pair.Key, pair.ModifyIndex, want)}
+		}
+	}
+
+	keys := make([]string, 0, len(expected))
+	for key := range expected {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if _, ok := live[key]; !ok {
+			return &kvCASError{fmt.Errorf("key %s was deleted since the export", key)}
+		}
+	}
+	return nil
+}
+
+// kvDeleteTreeCAS deletes the pairs with CAS operations against their
+// ModifyIndex, in transactions of up to kvMaxTxnOps keys. When there's more
+// than one batch, every key is checked before any are deleted, to make it
+// unlikely that a change is only found after some batches are gone. It
+// returns the number of keys deleted, which may be non-zero on error.
+func kvDeleteTreeCAS(client *api.Client, pairs api.KVPairs, wo *api.WriteOptions) (int, error) {
+	var batches []api.KVPairs
+	for len(pairs) > 0 {
+		n := kvMaxTxnOps
+		if n > len(pairs) {
+			n = len(pairs)
+		}
+		batches = append(batches, pairs[:n])
+		pairs = pairs[n:]
+	}
+
+	q := &api.QueryOptions{
+		Datacenter: wo.Datacenter,
+		Token:      wo.Token,
+	}
+	run := func(verb api.KVOp, batch api.KVPairs) error {
+		ops := make(api.KVTxnOps, 0, len(batch))
+		for _, pair := range batch {
+			ops = append(ops, &api.KVTxnOp{
+				Verb:  verb,
+				Key:   pair.Key,
+				Index: pair.ModifyIndex,
+			})
+		}
+
+		ok, resp, err := kvTxn(client, ops, q)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			if len(resp.Errors) == 0 {
+				return &kvCASError{fmt.Errorf("transaction rolled back")}
+			}
+			keys := make([]string, 0, len(batch))
+			for _, pair := range batch {
+				keys = append(keys, pair.Key)
+			}
+			if err := newKVTxnError(keys, resp); err.denied != "" {
+				return err
+			}
+			e := resp.Errors[0]
+			return &kvCASError{fmt.Errorf("key %s changed before it was deleted: %s",
+				batch[e.OpIndex].Key, e.What)}
+		}
+		return nil
+	}
+
+	if len(batches) > 1 {
+		for _, batch := range batches {
+			if err := run(api.KVCheckIndex, batch); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	deleted := 0
+	for _, batch := range batches {
+		if err := run(api.KVDeleteCAS, batch); err != nil {
+			return deleted, err
+		}
+		deleted += len(batch)
+	}
+	return deleted, nil
+}
+
+// kvReadIndexes reads the ModifyIndex of each key from an export given with
+// -verify-file. Every key must be under the prefix and have an index. Keys
+// that don't pass the flags filter or match the pattern are left out, as they
+// are from the keys being deleted.
+func kvReadIndexes(file, prefix string, flagsFilter *kvFlagsFilter, keyMatch *kvKeyMatch) (map[string]uint64, error) {
+	raw, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read verify file: %s", err)
+	}
+	data, err := gunzipIfCompressed(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	format := "yaml"
+	if strings.HasPrefix(strings.TrimSpace(data), "[") {
+		format = "json"
+	}
+	entries, err := decodeKVEntries(format, data)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot unmarshal verify file: %s", err)
+	}
+
+	indexes := make(map[string]uint64, len(entries))
+	for _, entry := range entries {
+		switch {
+		case !strings.HasPrefix(entry.Key, prefix):
+			return nil, fmt.Errorf("Key %s in the verify file is not under the prefix %s", entry.Key, prefix)
+		case entry.Deleted:
+			continue
+		case entry.ModifyIndex == 0:
+			return nil, fmt.Errorf("Key %s in the verify file has no modify_index", entry.Key)
+		case !flagsFilter.match(entry.Flags), !keyMatch.match(entry.Key):
+			continue
+		}
+		indexes[entry.Key] = entry.ModifyIndex
+	}
+	return indexes, nil
+}
+
+// kvKeyMatch holds the -match and -regex flags, which pick out the keys to
+// delete under a prefix by a pattern applied to the full key path.
+type kvKeyMatch struct {
+	glob string
+	expr string
+	re   *regexp.Regexp
+}
+
+// enabled returns true if either pattern was given.
+func (m *kvKeyMatch) enabled() bool {
+	return m.glob != "" || m.expr != ""
+}
+
+// compile checks the patterns, and must be called before match.
+func (m *kvKeyMatch) compile() error {
+	switch {
+	case m.glob != "" && m.expr != "":
+		return fmt.Errorf("Cannot specify both -match and -regex")
+	case m.glob != "":
+		if _, err := path.Match(m.glob, ""); err != nil {
+			return fmt.Errorf("Invalid -match pattern %q: %s", m.glob, err)
+		}
+	case m.expr != "":
+		re, err := regexp.Compile(m.expr)
+		if err != nil {
+			return fmt.Errorf("Invalid -regex %q: %s", m.expr, err)
+		}
+		m.re = re
+	}
+	return nil
+}
+
+// match returns true if the key matches the pattern, which every key does
+// if no pattern was given. A glob must match the whole key, while a regular
+// expression may match anywhere in it unless it's anchored.
+func (m *kvKeyMatch) match(key string) bool {
+	switch {
+	case m.glob != "":
+		ok, _ := path.Match(m.glob, key)
+		return ok
+	case m.re != nil:
+		return m.re.MatchString(key)
+	}
+	return true
+}
+
+// filter returns the pairs which match, in the same order, along with the
+// number left out. The given slice is reused.
+func (m *kvKeyMatch) filter(pairs api.KVPairs) (api.KVPairs, int) {
+	kept := pairs[:0]
+	for _, pair := range pairs {
+		if m.match(pair.Key) {
+			kept = append(kept, pair)
+		}
+	}
+	return kept, len(pairs) - len(kept)
+}
+
+// String describes the pattern for messages, such as `key matches "a/*"`.
+func (m *kvKeyMatch) String() string {
+	if m.glob != "" {
+		return fmt.Sprintf("key matches %q", m.glob)
+	}
+	return fmt.Sprintf("key matches regex %q", m.expr)
+}
+
+// stdinIsTerminal returns true if the command can prompt the user for input.
+func (c *KVDeleteCommand) stdinIsTerminal() bool {
+	if c.testStdinTerminal != nil {
+		return *c.testStdinTerminal
+	}
+	return isatty.IsTerminal(os.Stdin.Fd())
+}
+
+// pluralKeys returns the right form of "key" for the given count.
+func pluralKeys(n int) string {
+	if n == 1 {
+		return "key"
+	}
+	return "keys"
+}
@@ -1,9 +1,20 @@
 package command
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/hashicorp/consul/api"
 	"github.com/mitchellh/cli"
@@ -18,56 +29,170 @@ func TestKVDeleteCommand_noTabs(t *testing.T) {
 }
 
 func TestKVDeleteCommand_Validation(t *testing.T) {
-	ui := new(cli.MockUi)
-	c := &KVDeleteCommand{Ui: ui}
-
 	cases := map[string]struct {
-		args   []string
-		output string
+		args []string
+		errs []string
 	}{
-		"-cas and -recurse": {
-			[]string{"-cas", "-modify-index", "2", "-recurse", "foo"},
-			"Cannot specify both",
-		},
 		"-cas no -modify-index": {
 			[]string{"-cas", "foo"},
-			"Must specify -modify-index",
+			[]string{"Must specify -modify-index with -cas!"},
 		},
 		"-modify-index no -cas": {
 			[]string{"-modify-index", "2", "foo"},
-			"Cannot specify -modify-index without",
+			[]string{"Cannot specify -modify-index without -cas!"},
+		},
+		"-cas and -recurse": {
+			[]string{"-cas", "-recurse", "foo"},
+			[]string{"Must specify -modify-index or -verify-file with -cas and -recurse!"},
+		},
+		"-recurse and -modify-index": {
+			[]string{"-recurse", "-modify-index", "2", "foo"},
+			[]string{"Cannot specify -modify-index without -cas!"},
+		},
+		"-verify-file without -cas": {
+			[]string{"-recurse", "-verify-file=export.json", "foo"},
+			[]string{"Can only specify -verify-file with -cas and -recurse!"},
+		},
+		"-verify-file and -modify-index": {
+			[]string{"-cas", "-recurse", "-modify-index", "2", "-verify-file=export.json", "foo"},
+			[]string{"Cannot specify both -modify-index and -verify-file!"},
+		},
+		"everything at once": {
+			[]string{"-cas", "-dry-run"},
+			[]string{
+				"Error! Missing KEY argument",
+				"Must specify -modify-index with -cas!",
+				"Can only specify -dry-run with -recurse!",
+			},
+		},
+		"-flags without -recurse": {
+			[]string{"-flags=1", "foo"},
+			[]string{"Can only specify -flags or -flags-mask with -recurse!"},
+		},
+		"-flags-mask with stdin": {
+			[]string{"-flags-mask=0xff", "-"},
+			[]string{"Can only specify -flags or -flags-mask with -recurse!"},
+		},
+		"-flags outside of -flags-mask": {
+			[]string{"-recurse", "-flags=0x100", "-flags-mask=0xff", "foo"},
+			[]string{"Error! -flags=0x100 has bits outside of -flags-mask=0xff, so no entry can match"},
+		},
+		"-match without -recurse": {
+			[]string{"-match=foo/*", "foo"},
+			[]string{"Can only specify -match or -regex with -recurse!"},
+		},
+		"-regex with stdin": {
+			[]string{"-regex=foo", "-"},
+			[]string{"Can only specify -match or -regex with -recurse!"},
+		},
+		"-match and -regex": {
+			[]string{"-recurse", "-match=foo/*", "-regex=foo", "foo"},
+			[]string{"Error! Cannot specify both -match and -regex"},
+		},
+		"bad -match": {
+			[]string{"-recurse", "-match=foo/[", "foo"},
+			[]string{`Error! Invalid -match pattern "foo/[": syntax error in pattern`},
+		},
+		"bad -regex": {
+			[]string{"-recurse", "-regex=foo(", "foo"},
+			[]string{"Error! Invalid -regex \"foo(\": error parsing regexp: missing closing ): `foo(`"},
+		},
+		"bad -format": {
+			[]string{"-format=yaml", "foo"},
+			[]string{`Error! Unsupported format "yaml" (expected text or json)`},
+		},
+		"-format=json with -dry-run": {
+			[]string{"-recurse", "-dry-run", "-format=json", "foo"},
+			[]string{"Cannot specify -format=json with -dry-run!"},
+		},
+		"-audit-best-effort without -audit-log": {
+			[]string{"-audit-best-effort", "foo"},
+			[]string{"Can only specify -audit-best-effort with -audit-log or CONSUL_CLI_AUDIT_LOG!"},
 		},
 		"no key": {
 			[]string{},
-			"Missing KEY argument",
+			[]string{"Error! Missing KEY argument"},
 		},
 		"extra args": {
 			[]string{"foo", "bar", "baz"},
-			"Too many arguments",
+			[]string{"Too many arguments (expected 1, got 3)"},
 		},
 	}
 
 	for name, tc := range cases {
-		// Ensure our buffer is always clear
-		if ui.ErrorWriter != nil {
-			ui.ErrorWriter.Reset()
-		}
-		if ui.OutputWriter != nil {
-			ui.OutputWriter.Reset()
-		}
+		ui := new(cli.MockUi)
+		c := &KVDeleteCommand{Ui: ui}
 
 		code := c.Run(tc.args)
-		if code == 0 {
-			t.Errorf("%s: expected non-zero exit", name)
+		if code != 1 {
+			t.Errorf("%s: bad: %d", name, code)
 		}
 
 		output := ui.ErrorWriter.String()
-		if !strings.Contains(output, tc.output) {
-			t.Errorf("%s: expected %q to contain %q", name, output, tc.output)
+		if expected := strings.Join(tc.errs, "\n") + "\n"; output != expected {
+			t.Errorf("%s: expected %q, got %q", name, expected, output)
+		}
+	}
+
+	// The valid combinations don't report anything.
+	valid := map[string]struct {
+		key             string
+		cas             bool
+		modifyIndex     uint64
+		recurse, dryRun bool
+		verifyFile      string
+	}{
+		"key":                        {"foo", false, 0, false, false, ""},
+		"-cas":                       {"foo", true, 2, false, false, ""},
+		"-recurse":                   {"foo", false, 0, true, false, ""},
+		"-recurse no key":            {"", false, 0, true, false, ""},
+		"-recurse -dry-run":          {"foo", false, 0, true, true, ""},
+		"-recurse -cas":              {"foo", true, 2, true, false, ""},
+		"-recurse -cas -verify-file": {"foo", true, 0, true, false, "export.json"},
+		"stdin":                      {"-", false, 0, false, false, ""},
+	}
+	for name, tc := range valid {
+		if errs := kvDeleteValidate(tc.key, tc.cas, tc.modifyIndex, tc.recurse, tc.dryRun, tc.verifyFile); len(errs) != 0 {
+			t.Errorf("%s: bad: %v", name, errs)
 		}
 	}
 }
 
+func TestKVDeleteCommand_ModifyIndexWithoutCAS(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	pair := &api.KVPair{
+		Key:   "foo",
+		Value: []byte("bar"),
+	}
+	if _, err := client.KV().Put(pair, nil); err != nil {
+		t.Fatalf("err: %#v", err)
+	}
+
+	ui := new(cli.MockUi)
+	c := &KVDeleteCommand{Ui: ui}
+
+	args := []string{
+		"-http-addr=" + srv.httpAddr,
+		"-modify-index=1",
+		"foo",
+	}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("bad: %d", code)
+	}
+
+	// The key must not have been deleted without the guard.
+	pair, _, err := client.KV().Get("foo", nil)
+	if err != nil {
+		t.Fatalf("err: %#v", err)
+	}
+	if pair == nil {
+		t.Fatalf("bad: key was deleted")
+	}
+}
+
 func TestKVDeleteCommand_Run(t *testing.T) {
 	srv, client := testAgentWithAPIClient(t)
 	defer srv.Shutdown()
@@ -128,6 +253,7 @@ func TestKVDeleteCommand_Recurse(t *testing.T) {
 	args := []string{
 		"-http-addr=" + srv.httpAddr,
 		"-recurse",
+		"-force",
 		"foo",
 	}
 
@@ -147,6 +273,221 @@ func TestKVDeleteCommand_Recurse(t *testing.T) {
 	}
 }
 
+func TestKVDeleteCommand_RecurseFlags(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	put := func() {
+		for _, pair := range []*api.KVPair{
+			{Key: "foo/a", Flags: 42},
+			{Key: "foo/b", Flags: 42 | 1<<8},
+			{Key: "foo/c", Flags: 7},
+			{Key: "food", Flags: 42},
+		} {
+			if _, err := client.KV().Put(pair, nil); err != nil {
+				t.Fatalf("err: %v", err)
+			}
+		}
+	}
+
+	cases := map[string]struct {
+		args      []string
+		code      int
+		output    string
+		remaining []string
+	}{
+		"exact": {
+			[]string{"-flags=42"},
+			0,
+			"Success! Deleted 2 keys with prefix: foo (flags == 42)",
+			[]string{"foo/b", "foo/c"},
+		},
+		"masked": {
+			[]string{"-flags=42", "-flags-mask=0xff"},
+			0,
+			"Success! Deleted 3 keys with prefix: foo (flags & 0xff == 42)",
+			[]string{"foo/c"},
+		},
+		"dry run": {
+			[]string{"-flags=42", "-flags-mask=0xff", "-dry-run"},
+			0,
+			"foo/a\nfoo/b\nfood\n",
+			[]string{"foo/a", "foo/b", "foo/c", "food"},
+		},
+		"no match": {
+			[]string{"-flags=1"},
+			0,
+			"No keys to delete, none of the 4 keys with prefix foo match (flags == 1)",
+			[]string{"foo/a", "foo/b", "foo/c", "food"},
+		},
+		"no match -fail-if-missing": {
+			[]string{"-flags=1", "-fail-if-missing"},
+			exitNotFound,
+			"Error! None of the 4 keys with prefix foo match (flags == 1)",
+			[]string{"foo/a", "foo/b", "foo/c", "food"},
+		},
+	}
+
+	for name, tc := range cases {
+		put()
+
+		ui := new(cli.MockUi)
+		c := &KVDeleteCommand{Ui: ui}
+
+		args := append([]string{"-http-addr=" + srv.httpAddr, "-recurse", "-force"}, tc.args...)
+		code := c.Run(append(args, "foo"))
+		if code != tc.code {
+			t.Fatalf("%s: bad: %d. %#v", name, code, ui.ErrorWriter.String())
+		}
+
+		var output string
+		if ui.OutputWriter != nil {
+			output = ui.OutputWriter.String()
+		}
+		output += ui.ErrorWriter.String()
+		if !strings.Contains(output, tc.output) {
+			t.Fatalf("%s: expected %q to contain %q", name, output, tc.output)
+		}
+
+		remaining, _, err := client.KV().Keys("", "", nil)
+		if err != nil {
+			t.Fatalf("%s: err: %v", name, err)
+		}
+		if !reflect.DeepEqual(remaining, tc.remaining) {
+			t.Fatalf("%s: bad: %#v", name, remaining)
+		}
+
+		if _, err := client.KV().DeleteTree("", nil); err != nil {
+			t.Fatalf("%s: err: %v", name, err)
+		}
+	}
+}
+
+func TestKVDeleteCommand_RecurseMatch(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	all := []string{
+		"app/api/cache/a",
+		"app/api/cache/b/c",
+		"app/api/config",
+		"app/web/cache/a",
+		"app/web/mycache",
+		"other/cache/a",
+	}
+	put := func() {
+		for _, k := range all {
+			if _, err := client.KV().Put(&api.KVPair{Key: k, Flags: 42}, nil); err != nil {
+				t.Fatalf("err: %v", err)
+			}
+		}
+	}
+
+	cases := map[string]struct {
+		args      []string
+		code      int
+		output    string
+		remaining []string
+	}{
+		"glob": {
+			[]string{"-match=app/*/cache/*"},
+			0,
+			`Success! Deleted 2 keys with prefix: app/ (key matches "app/*/cache/*")`,
+			[]string{"app/api/cache/b/c", "app/api/config", "app/web/mycache", "other/cache/a"},
+		},
+		"glob is applied to the full path": {
+			[]string{"-match=*/cache/*"},
+			0,
+			`No keys to delete, none of the 5 keys with prefix app/ match (key matches "*/cache/*")`,
+			all,
+		},
+		"regex is unanchored": {
+			[]string{"-regex=cache"},
+			0,
+			`Success! Deleted 4 keys with prefix: app/ (key matches regex "cache")`,
+			[]string{"app/api/config", "other/cache/a"},
+		},
+		"anchored regex": {
+			[]string{"-regex=^app/[^/]+/cache/[^/]+$"},
+			0,
+			"Success! Deleted 2 keys with prefix: app/",
+			[]string{"app/api/cache/b/c", "app/api/config", "app/web/mycache", "other/cache/a"},
+		},
+		"regex at the end": {
+			[]string{"-regex=cache$"},
+			0,
+			"Success! Deleted 1 key with prefix: app/",
+			[]string{"app/api/cache/a", "app/api/cache/b/c", "app/api/config", "app/web/cache/a", "other/cache/a"},
+		},
+		"with flags": {
+			[]string{"-match=app/*/cache/*", "-flags=7"},
+			0,
+			`none of the 5 keys with prefix app/ match (flags == 7, key matches "app/*/cache/*")`,
+			all,
+		},
+		"dry run": {
+			[]string{"-regex=cache", "-dry-run"},
+			0,
+			"app/api/cache/a\napp/api/cache/b/c\napp/web/cache/a\napp/web/mycache\n",
+			all,
+		},
+		"dry run no match": {
+			[]string{"-match=app/nope/*", "-dry-run"},
+			0,
+			`No keys to delete, none of the 5 keys with prefix app/ match (key matches "app/nope/*")`,
+			all,
+		},
+		"no match -fail-if-missing": {
+			[]string{"-match=app/nope/*", "-fail-if-missing"},
+			exitNotFound,
+			`Error! None of the 5 keys with prefix app/ match (key matches "app/nope/*")`,
+			all,
+		},
+		"cas": {
+			[]string{"-match=app/*/cache/*", "-cas", "-modify-index=1000000"},
+			0,
+			"Skipping 3 keys not matching",
+			[]string{"app/api/cache/b/c", "app/api/config", "app/web/mycache", "other/cache/a"},
+		},
+	}
+
+	for name, tc := range cases {
+		put()
+
+		ui := new(cli.MockUi)
+		c := &KVDeleteCommand{Ui: ui}
+
+		args := append([]string{"-http-addr=" + srv.httpAddr, "-recurse", "-force"}, tc.args...)
+		code := c.Run(append(args, "app/"))
+		if code != tc.code {
+			t.Fatalf("%s: bad: %d. %#v", name, code, ui.ErrorWriter.String())
+		}
+
+		var output string
+		if ui.OutputWriter != nil {
+			output = ui.OutputWriter.String()
+		}
+		output += ui.ErrorWriter.String()
+		if !strings.Contains(output, tc.output) {
+			t.Fatalf("%s: expected %q to contain %q", name, output, tc.output)
+		}
+
+		remaining, _, err := client.KV().Keys("", "", nil)
+		if err != nil {
+			t.Fatalf("%s: err: %v", name, err)
+		}
+		if !reflect.DeepEqual(remaining, tc.remaining) {
+			t.Fatalf("%s: bad: %#v", name, remaining)
+		}
+
+		if _, err := client.KV().DeleteTree("", nil); err != nil {
+			t.Fatalf("%s: err: %v", name, err)
+		}
+	}
+}
+
 func TestKVDeleteCommand_CAS(t *testing.T) {
 	srv, client := testAgentWithAPIClient(t)
 	defer srv.Shutdown()
@@ -205,3 +546,605 @@ func TestKVDeleteCommand_CAS(t *testing.T) {
 		t.Fatalf("bad: %#v", data)
 	}
 }
+
+func TestKVDeleteCommand_RecurseCAS(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	// More keys than fit in one transaction.
+	for i := 0; i < kvMaxTxnOps+6; i++ {
+		if _, err := client.KV().Put(&api.KVPair{Key: fmt.Sprintf("tree/%03d", i)}, nil); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	pairs, meta, err := client.KV().List("tree/", nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	index := meta.LastIndex
+
+	// Change a key in the last batch.
+	if _, err := client.KV().Put(&api.KVPair{Key: "tree/066", Value: []byte("x")}, nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	run := func(args ...string) (int, *cli.MockUi) {
+		ui := new(cli.MockUi)
+		c := &KVDeleteCommand{Ui: ui}
+		args = append([]string{"-http-addr=" + srv.httpAddr, "-recurse", "-cas", "-force"}, args...)
+		return c.Run(args), ui
+	}
+
+	code, ui := run("-modify-index="+strconv.FormatUint(index, 10), "tree/")
+	if code != 1 {
+		t.Fatalf("bad: %d", code)
+	}
+	if !strings.Contains(ui.ErrorWriter.String(), "key tree/066 was modified at index") {
+		t.Fatalf("bad: %#v", ui.ErrorWriter.String())
+	}
+	if keys, _, _ := client.KV().Keys("tree/", "", nil); len(keys) != len(pairs) {
+		t.Fatalf("bad: %d keys left", len(keys))
+	}
+
+	// A change after the check is caught by the transactions, before any
+	// batch is deleted.
+	stale := append(api.KVPairs{}, pairs...)
+	deleted, err := kvDeleteTreeCAS(client, stale, &api.WriteOptions{})
+	if err == nil || deleted != 0 || !strings.Contains(err.Error(), "key tree/066 changed") {
+		t.Fatalf("bad: %d %v", deleted, err)
+	}
+	if _, ok := err.(*kvCASError); !ok {
+		t.Fatalf("bad: %#v", err)
+	}
+
+	_, meta, err = client.KV().List("tree/", nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	code, ui = run("-modify-index="+strconv.FormatUint(meta.LastIndex, 10), "tree/")
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if !strings.Contains(ui.OutputWriter.String(), fmt.Sprintf("Deleted %d keys", len(pairs))) {
+		t.Fatalf("bad: %#v", ui.OutputWriter.String())
+	}
+	if keys, _, _ := client.KV().Keys("tree/", "", nil); len(keys) != 0 {
+		t.Fatalf("bad: %d keys left", len(keys))
+	}
+}
+
+func TestKVDeleteCommand_RecurseCAS_verifyFile(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	dir, err := ioutil.TempDir("", "consul")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "export.json")
+
+	put := func(key, value string) {
+		if _, err := client.KV().Put(&api.KVPair{Key: key, Value: []byte(value)}, nil); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	export := func() {
+		ui := new(cli.MockUi)
+		c := &KVExportCommand{Ui: ui}
+		if code := c.Run([]string{"-http-addr=" + srv.httpAddr, "-output=" + file, "app/"}); code != 0 {
+			t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+		}
+	}
+	run := func() (int, string) {
+		ui := new(cli.MockUi)
+		c := &KVDeleteCommand{Ui: ui}
+		args := []string{"-http-addr=" + srv.httpAddr, "-recurse", "-cas", "-force", "-verify-file=" + file, "app/"}
+		return c.Run(args), ui.ErrorWriter.String()
+	}
+
+	put("app/a", "a")
+	put("app/b", "b")
+	export()
+
+	cases := []struct {
+		change func()
+		output string
+	}{
+		{func() { put("app/b", "changed") }, "key app/b was modified at index"},
+		{func() { put("app/c", "c") }, "key app/c was added since the export"},
+		{func() { client.KV().Delete("app/a", nil) }, "key app/a was deleted since the export"},
+	}
+	for _, tc := range cases {
+		export()
+		tc.change()
+		code, output := run()
+		if code != 1 || !strings.Contains(output, tc.output) {
+			t.Fatalf("bad: %d %#v", code, output)
+		}
+	}
+
+	// With nothing changed since the export, the keys are deleted.
+	export()
+	if code, output := run(); code != 0 {
+		t.Fatalf("bad: %d %#v", code, output)
+	}
+	if keys, _, _ := client.KV().Keys("app/", "", nil); len(keys) != 0 {
+		t.Fatalf("bad: %#v", keys)
+	}
+
+	// The export must cover the prefix and have the indexes.
+	if err := ioutil.WriteFile(file, []byte(`[{"key":"other/a","flags":0,"value":"","modify_index":5}]`), 0644); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if code, output := run(); code != 1 || !strings.Contains(output, "not under the prefix app/") {
+		t.Fatalf("bad: %d %#v", code, output)
+	}
+	if err := ioutil.WriteFile(file, []byte(`[{"key":"app/a","flags":0,"value":""}]`), 0644); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if code, output := run(); code != 1 || !strings.Contains(output, "has no modify_index") {
+		t.Fatalf("bad: %d %#v", code, output)
+	}
+}
+
+func TestKVDeleteCommand_Recurse_count(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	if _, err := client.KV().Put(&api.KVPair{Key: "single/a"}, nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	for i := 0; i < 200; i++ {
+		pair := &api.KVPair{Key: "large/" + strconv.Itoa(i)}
+		if _, err := client.KV().Put(pair, nil); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	cases := map[string]struct {
+		args   []string
+		code   int
+		output string
+	}{
+		"no match": {
+			[]string{"-recurse", "nope"},
+			0,
+			"No keys to delete with prefix: nope",
+		},
+		"no match with -fail-if-missing": {
+			[]string{"-recurse", "-fail-if-missing", "nope"},
+			exitNotFound,
+			"No keys exist with prefix: nope",
+		},
+		"single key": {
+			[]string{"-recurse", "single"},
+			0,
+			"Success! Deleted 1 key with prefix: single",
+		},
+		"large tree": {
+			[]string{"-recurse", "-fail-if-missing", "large/"},
+			0,
+			"Success! Deleted 200 keys with prefix: large/",
+		},
+	}
+
+	for name, tc := range cases {
+		ui := new(cli.MockUi)
+		c := &KVDeleteCommand{Ui: ui}
+
+		code := c.Run(append([]string{"-http-addr=" + srv.httpAddr, "-force"}, tc.args...))
+		if code != tc.code {
+			t.Fatalf("%s: bad: %d. %#v", name, code, ui.ErrorWriter.String())
+		}
+
+		output := ui.OutputWriter.String() + ui.ErrorWriter.String()
+		if !strings.Contains(output, tc.output) {
+			t.Fatalf("%s: expected %q to contain %q", name, output, tc.output)
+		}
+	}
+
+	keys, _, err := client.KV().Keys("", "", nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(keys) != 0 {
+		t.Fatalf("bad: %#v", keys)
+	}
+}
+
+func TestKVDeleteCommand_Recurse_confirm(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	keys := []string{"foo/a", "foo/b"}
+	for _, k := range keys {
+		if _, err := client.KV().Put(&api.KVPair{Key: k}, nil); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	terminal, notTerminal := true, false
+	cases := map[string]struct {
+		terminal *bool
+		input    string
+		code     int
+		output   string
+	}{
+		"not a terminal": {
+			&notTerminal,
+			"",
+			1,
+			"Refusing to delete keys recursively without confirmation",
+		},
+		"declined": {
+			&terminal,
+			"no\n",
+			1,
+			"Delete cancelled",
+		},
+		"confirmed": {
+			&terminal,
+			"yes\n",
+			0,
+			"Success! Deleted 2 keys with prefix: foo/",
+		},
+	}
+
+	for _, name := range []string{"not a terminal", "declined", "confirmed"} {
+		tc := cases[name]
+		ui := new(cli.MockUi)
+		ui.InputReader = strings.NewReader(tc.input)
+		c := &KVDeleteCommand{Ui: ui, testStdinTerminal: tc.terminal}
+
+		args := []string{
+			"-http-addr=" + srv.httpAddr,
+			"-recurse",
+			"foo/",
+		}
+
+		code := c.Run(args)
+		if code != tc.code {
+			t.Fatalf("%s: bad: %d. %#v", name, code, ui.ErrorWriter.String())
+		}
+
+		output := ui.OutputWriter.String() + ui.ErrorWriter.String()
+		if !strings.Contains(output, tc.output) {
+			t.Fatalf("%s: expected %q to contain %q", name, output, tc.output)
+		}
+
+		pairs, _, err := client.KV().List("foo/", nil)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if deleted := len(pairs) == 0; deleted != (tc.code == 0) {
+			t.Fatalf("%s: bad: %#v", name, pairs)
+		}
+	}
+}
+
+func TestKVDeleteCommand_Stdin(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	// Write more keys than fit in a single transaction, and pass some with
+	// leading slashes and blank lines in between.
+	var input bytes.Buffer
+	for i := 0; i < 150; i++ {
+		k := "foo/" + strconv.Itoa(i)
+		if _, err := client.KV().Put(&api.KVPair{Key: k}, nil); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if i%2 == 0 {
+			input.WriteString("/")
+		}
+		input.WriteString(k + "\n\n")
+	}
+	if _, err := client.KV().Put(&api.KVPair{Key: "keep"}, nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	ui := new(cli.MockUi)
+	c := &KVDeleteCommand{
+		Ui:        ui,
+		testStdin: &input,
+	}
+
+	args := []string{
+		"-http-addr=" + srv.httpAddr,
+		"-",
+	}
+
+	code := c.Run(args)
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if !strings.Contains(ui.OutputWriter.String(), "Success! Deleted 150 keys") {
+		t.Fatalf("bad: %#v", ui.OutputWriter.String())
+	}
+
+	keys, _, err := client.KV().Keys("", "", nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(keys) != 1 || keys[0] != "keep" {
+		t.Fatalf("bad: %#v", keys)
+	}
+}
+
+func TestKVDeleteCommand_JSON(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	cases := []struct {
+		name      string
+		args      []string
+		stdin     string
+		operation string
+		key       string
+		prefix    string
+		deleted   int
+	}{
+		{"delete", []string{"foo/a"}, "", "delete", "foo/a", "", 1},
+		{"delete -cas", []string{"-cas", "-modify-index=%d", "foo/a"}, "", "delete-cas", "foo/a", "", 1},
+		{"delete -recurse", []string{"-recurse", "-force", "foo/"}, "", "delete-tree", "", "foo/", 2},
+		{"delete -recurse -cas", []string{"-recurse", "-cas", "-modify-index=%d", "-force", "foo/"}, "",
+			"delete-tree-cas", "", "foo/", 2},
+		{"delete -recurse -match", []string{"-recurse", "-match=foo/b", "-force", "foo/"}, "",
+			"delete-tree-cas", "", "foo/", 1},
+		{"delete -recurse nothing", []string{"-recurse", "-force", "nope/"}, "", "delete-tree", "", "nope/", 0},
+		{"delete stdin", []string{"-"}, "foo/a\nfoo/b\n", "delete-keys", "", "", 2},
+	}
+
+	for _, tc := range cases {
+		// foo/a is written last, so the index of the tree is its
+		// ModifyIndex for -cas.
+		for _, k := range []string{"foo/b", "foo/a"} {
+			if _, err := client.KV().Put(&api.KVPair{Key: k, Value: []byte("x")}, nil); err != nil {
+				t.Fatalf("err: %v", err)
+			}
+		}
+		_, qm, err := client.KV().List("foo/", nil)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		index := qm.LastIndex
+
+		args := []string{"-http-addr=" + srv.httpAddr, "-format=json"}
+		for _, arg := range tc.args {
+			if strings.Contains(arg, "%d") {
+				arg = fmt.Sprintf(arg, index)
+			}
+			args = append(args, arg)
+		}
+		ui := new(cli.MockUi)
+		c := &KVDeleteCommand{Ui: ui, testStdin: strings.NewReader(tc.stdin)}
+		if code := c.Run(args); code != 0 {
+			t.Fatalf("%s: bad: %d. %#v", tc.name, code, ui.ErrorWriter.String())
+		}
+
+		var result kvDeleteResult
+		if err := json.Unmarshal(ui.OutputWriter.Bytes(), &result); err != nil {
+			t.Fatalf("%s: err: %v", tc.name, err)
+		}
+		if result.Operation != tc.operation || result.Key != tc.key || result.Prefix != tc.prefix ||
+			result.KeysDeleted != tc.deleted || result.Time.IsZero() {
+			t.Fatalf("%s: bad: %#v", tc.name, result)
+		}
+
+		// The index is that of the delete, which is the latest write.
+		if tc.deleted > 0 {
+			_, qm, err := client.KV().List("foo/", nil)
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			if result.Index <= index || result.Index != qm.LastIndex {
+				t.Fatalf("%s: bad index: %d (before %d, after %d)", tc.name, result.Index, index, qm.LastIndex)
+			}
+		} else if result.Index != 0 {
+			t.Fatalf("%s: bad: %#v", tc.name, result)
+		}
+		if _, err := client.KV().DeleteTree("foo/", nil); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	// With -verbose, the index is reported after the usual message.
+	if _, err := client.KV().Put(&api.KVPair{Key: "foo/a"}, nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	ui := new(cli.MockUi)
+	c := &KVDeleteCommand{Ui: ui}
+	if code := c.Run([]string{"-http-addr=" + srv.httpAddr, "-verbose", "foo/a"}); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if ui.OutputWriter.String() != "Success! Deleted key: foo/a\n" {
+		t.Fatalf("bad: %#v", ui.OutputWriter.String())
+	}
+	if !regexp.MustCompile(`(?m)^Deleted at index \d+, the requests took \S+$`).MatchString(ui.ErrorWriter.String()) {
+		t.Fatalf("bad: %#v", ui.ErrorWriter.String())
+	}
+}
+
+func TestKVDeleteCommand_Stdin_validation(t *testing.T) {
+	for _, flag := range []string{"-recurse", "-cas"} {
+		ui := new(cli.MockUi)
+		c := &KVDeleteCommand{Ui: ui}
+
+		code := c.Run([]string{flag, "-modify-index=1", "-"})
+		if code != 1 {
+			t.Fatalf("%s: bad: %d", flag, code)
+		}
+		if !strings.Contains(ui.ErrorWriter.String(), "when reading keys from stdin") {
+			t.Fatalf("%s: bad: %#v", flag, ui.ErrorWriter.String())
+		}
+	}
+}
+
+func TestKVDeleteCommand_DryRun(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	keys := []string{"foo/a", "foo/b", "food"}
+	for _, k := range keys {
+		if _, err := client.KV().Put(&api.KVPair{Key: k}, nil); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	ui := new(cli.MockUi)
+	c := &KVDeleteCommand{Ui: ui}
+
+	args := []string{
+		"-http-addr=" + srv.httpAddr,
+		"-recurse",
+		"-dry-run",
+		"foo",
+	}
+
+	code := c.Run(args)
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+
+	expected := strings.Join(keys, "\n") + "\n"
+	if output := ui.OutputWriter.String(); output != expected {
+		t.Fatalf("bad: %q", output)
+	}
+
+	// Nothing should have been deleted.
+	remaining, _, err := client.KV().Keys("", "", nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(remaining) != len(keys) {
+		t.Fatalf("bad: %#v", remaining)
+	}
+}
+
+func TestKVDeleteCommand_DryRun_validation(t *testing.T) {
+	cases := map[string][]string{
+		"without -recurse": {"-dry-run", "foo"},
+		"with -cas":        {"-dry-run", "-cas", "-modify-index=1", "foo"},
+	}
+
+	for name, args := range cases {
+		ui := new(cli.MockUi)
+		c := &KVDeleteCommand{Ui: ui}
+
+		code := c.Run(args)
+		if code != 1 {
+			t.Fatalf("%s: bad: %d", name, code)
+		}
+		if !strings.Contains(ui.ErrorWriter.String(), "Can only specify -dry-run with -recurse") {
+			t.Fatalf("%s: bad: %#v", name, ui.ErrorWriter.String())
+		}
+	}
+}
+
+func TestKVDeleteCommand_AuditLog(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	for _, k := range []string{"foo/a", "foo/b", "bar", "baz"} {
+		if _, err := client.KV().Put(&api.KVPair{Key: k, Value: []byte("x")}, nil); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "consul")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	log := filepath.Join(dir, "audit.log")
+
+	run := func(args ...string) (int, *cli.MockUi) {
+		ui := new(cli.MockUi)
+		c := &KVDeleteCommand{Ui: ui}
+		return c.Run(append([]string{"-http-addr=" + srv.httpAddr}, args...)), ui
+	}
+	exists := func(key string) bool {
+		pair, _, err := client.KV().Get(key, nil)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		return pair != nil
+	}
+
+	if code, ui := run("-audit-log="+log, "-recurse", "-force", "foo/"); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if code, ui := run("-audit-log="+log, "-token=secret-token", "-datacenter=dc1", "bar"); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+
+	// The log can also be given in the environment. The line is written
+	// before the delete is made, so it's there even when the CAS fails.
+	os.Setenv("CONSUL_CLI_AUDIT_LOG", log)
+	code, ui := run("-cas", "-modify-index=1", "baz")
+	os.Unsetenv("CONSUL_CLI_AUDIT_LOG")
+	if code != 1 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+
+	data, err := ioutil.ReadFile(log)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if strings.Contains(string(data), "secret-token") {
+		t.Fatalf("bad: %s", data)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("bad: %q", lines)
+	}
+	var entries []*kvAuditEntry
+	for _, line := range lines {
+		var entry kvAuditEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if entry.Time.IsZero() || entry.Agent != srv.httpAddr {
+			t.Fatalf("bad: %s", line)
+		}
+		entry.Time, entry.Agent = time.Time{}, ""
+		entries = append(entries, &entry)
+	}
+	sum := sha256.Sum256([]byte("secret-token"))
+	expected := []*kvAuditEntry{
+		{Operation: "delete-tree", Prefix: "foo/", Recurse: true, KeysAffected: 2},
+		{Operation: "delete", Key: "bar", KeysAffected: 1, Datacenter: "dc1",
+			Token: "sha256:" + hex.EncodeToString(sum[:8])},
+		{Operation: "delete-cas", Key: "baz", CAS: true, ModifyIndex: 1, KeysAffected: 1},
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Fatalf("bad: %s", data)
+	}
+
+	// Nothing is deleted if the line can't be written, unless that's
+	// allowed.
+	bad := filepath.Join(dir, "missing", "audit.log")
+	code, ui = run("-audit-log="+bad, "baz")
+	if code != 1 || !exists("baz") {
+		t.Fatalf("bad: %d", code)
+	}
+	if output := ui.ErrorWriter.String(); !strings.Contains(output, "Failed to write the audit log, so nothing was deleted") {
+		t.Fatalf("bad: %#v", output)
+	}
+	code, ui = run("-audit-log="+bad, "-audit-best-effort", "baz")
+	if code != 0 || exists("baz") {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if output := ui.ErrorWriter.String(); !strings.Contains(output, "Warning! Failed to write the audit log") {
+		t.Fatalf("bad: %#v", output)
+	}
+}
@@ -0,0 +1,442 @@
+package command
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+)
+
+// KVDiffCommand is a Command implementation that is used to compare two KV
+// trees, or a KV tree and an export file.
+type KVDiffCommand struct {
+	Ui cli.Ui
+}
+
+func (c *KVDiffCommand) Synopsis() string {
+	return "Compares two trees in the KV store, or a tree and an export"
+}
+
+func (c *KVDiffCommand) Help() string {
+	helpText := `
+Usage: consul kv diff [options] LEFT RIGHT
+
+  Compares two trees in the key-value store and reports the keys which were
+  added, removed, or changed between them. Keys are compared relative to each
+  prefix, so trees at different paths can be compared:
+
+      $ consul kv diff app/prod app/staging
+
+  The trees can also be read from different datacenters:
+
+      $ consul kv diff -left-datacenter=dc1 -right-datacenter=dc2 app app
+
+  Either side can instead be a file written by "consul kv export", given by
+  prefixing the filename with the "@" symbol. The keys in the file are taken
+  to be under the prefix given on the other side, unless -file-prefix is set:
+
+      $ consul kv diff app/prod @backup.json
+
+  The command exits with status 0 if the trees are the same, 2 if there are
+  differences, and 1 if an error occurred.
+
+  For a full list of options and examples, please see the Consul documentation.
+
+` + apiOptsText + `
+
+KV Diff Options:
+
+  -file-prefix=<string>   Prefix the keys in an export file were exported
+                          from. This defaults to the prefix on the other side
+                          of the comparison.
+
+  -format=<string>        Output format. One of "text" or "json". The "text"
+                          format shows each difference in the style of a
+                          unified diff. The "json" format lists each added,
+                          removed, or changed key along with its flags and
+                          value on each side. The default value is "text".
+
+  -left-datacenter=<dc>   Datacenter to read the left tree from. This defaults
+                          to the -datacenter value.
+
+  -right-datacenter=<dc>  Datacenter to read the right tree from. This
+                          defaults to the -datacenter value.
+
+  Values which are not valid UTF-8 text are compared by their SHA-256 hash and
+  are not shown.
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *KVDiffCommand) Run(args []string) (code int) {
+	cmdFlags, apiFlags := NewAPIFlagSet("diff", c.Ui)
+	defer apiFlags.CheckTimeout(c.Ui, &code)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	leftDC := cmdFlags.String("left-datacenter", "", "")
+	rightDC := cmdFlags.String("right-datacenter", "", "")
+	filePrefix := cmdFlags.String("file-prefix", "", "")
+	format := cmdFlags.String("format", "text", "")
+	if err := parseFlags(c.Ui, cmdFlags, args); err != nil {
+		return 1
+	}
+
+	if *format != "text" && *format != "json" {
+		c.Ui.Error(fmt.Sprintf("Error! Unsupported format %q (expected text or json)", *format))
+		return 1
+	}
+
+	// Check for arg validation
+	args = cmdFlags.Args()
+	switch len(args) {
+	case 0, 1:
+		c.Ui.Error("Error! Missing LEFT and RIGHT arguments")
+		return 1
+	case 2:
+	default:
+		c.Ui.Error(fmt.Sprintf("Too many arguments (expected 2, got %d)", len(args)))
+		return 1
+	}
+	left, right := args[0], args[1]
+
+	leftFile := strings.HasPrefix(left, "@")
+	rightFile := strings.HasPrefix(right, "@")
+	if leftFile && rightFile {
+		c.Ui.Error("Error! At most one side can be an export file")
+		return 1
+	}
+	if *filePrefix != "" && !leftFile && !rightFile {
+		c.Ui.Error("Error! Can only specify -file-prefix when comparing against an export file")
+		return 1
+	}
+
+	// Create and test the HTTP client
+	client, err := apiFlags.Client()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+		return 1
+	}
+
+	// Work out the datacenter for each side, falling back to -datacenter.
+	if *leftDC == "" {
+		*leftDC = apiFlags.Datacenter
+	}
+	if *rightDC == "" {
+		*rightDC = apiFlags.Datacenter
+	}
+
+	// A file's keys are relative to the prefix on the other side, unless
+	// given explicitly.
+	filePfx := *filePrefix
+	if filePfx == "" {
+		if leftFile {
+			filePfx = right
+		} else {
+			filePfx = left
+		}
+	}
+
+	load := func(arg, dc string) (*kvDiffTree, error) {
+		if strings.HasPrefix(arg, "@") {
+			return kvDiffTreeFromFile(arg[1:], filePfx)
+		}
+		return kvDiffTreeFromKV(client, arg, &api.QueryOptions{
+			Datacenter:        dc,
+			AllowStale:        apiFlags.Stale,
+			RequireConsistent: apiFlags.Consistent,
+		})
+	}
+
+	leftTree, err := load(left, *leftDC)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error reading %s: %s", left, err))
+		return 1
+	}
+	rightTree, err := load(right, *rightDC)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error reading %s: %s", right, err))
+		return 1
+	}
+
+	diffs := diffKVTrees(leftTree, rightTree)
+
+	if *format == "json" {
+		if diffs == nil {
+			diffs = []*kvDiffEntry{}
+		}
+		b, err := json.MarshalIndent(diffs, "", "  ")
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Error encoding differences: %s", err))
+			return 1
+		}
+		c.Ui.Output(string(b))
+	} else {
+		var added, removed, changed int
+		for _, d := range diffs {
+			switch d.Change {
+			case kvDiffAdded:
+				added++
+			case kvDiffRemoved:
+				removed++
+			case kvDiffChanged:
+				changed++
+			}
+			c.Ui.Output(d.unified())
+		}
+		c.Ui.Output(fmt.Sprintf("%d added, %d removed, %d changed", added, removed, changed))
+	}
+
+	if len(diffs) > 0 {
+		return 2
+	}
+	return 0
+}
+
+const (
+	kvDiffAdded   = "added"
+	kvDiffRemoved = "removed"
+	kvDiffChanged = "changed"
+)
+
+// kvDiffTree is one side of a comparison. Pairs are indexed by their key
+// relative to the prefix.
+type kvDiffTree struct {
+	prefix string
+	pairs  map[string]*api.KVPair
+}
+
+// kvDiffTreeFromKV reads the tree under the given prefix from the KV store.
+func kvDiffTreeFromKV(client *api.Client, prefix string, q *api.QueryOptions) (*kvDiffTree, error) {
+	prefix = normalizeKVPrefix(prefix)
+	pairs, _, err := client.KV().List(prefix, q)
+	if err != nil {
+		return nil, err
+	}
+
+	tree := &kvDiffTree{prefix: prefix, pairs: make(map[string]*api.KVPair)}
+	for _, pair := range pairs {
+		tree.pairs[strings.TrimPrefix(pair.Key, prefix)] = pair
+	}
+	return tree, nil
+}
+
+// kvDiffTreeFromFile reads the tree from an export file whose keys are all
+// under the given prefix.
+func kvDiffTreeFromFile(file, prefix string) (*kvDiffTree, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("File not found: %s", file)
+		}
+		return nil, fmt.Errorf("Failed to read file: %s", err)
+	}
+	entries, err := decodeKVEntries("json", string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	prefix = normalizeKVPrefix(prefix)
+	tree := &kvDiffTree{prefix: prefix, pairs: make(map[string]*api.KVPair)}
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Key, prefix) {
+			return nil, fmt.Errorf("Key %q does not have prefix %q, use -file-prefix to set it", entry.Key, prefix)
+		}
+		if entry.Deleted {
+			continue
+		}
+
+		value, err := base64.StdEncoding.DecodeString(entry.Value)
+		if err != nil {
+			return nil, fmt.Errorf("failed base 64 decoding value for key %s: %s", entry.Key, err)
+		}
+		tree.pairs[strings.TrimPrefix(entry.Key, prefix)] = &api.KVPair{
+			Key:   entry.Key,
+			Flags: entry.Flags,
+			Value: value,
+		}
+	}
+	return tree, nil
+}
+
+// kvDiffEntry describes a key which differs between the two sides.
+type kvDiffEntry struct {
+	Key    string      `json:"key"`
+	Change string      `json:"change"`
+	Left   *kvDiffSide `json:"left,omitempty"`
+	Right  *kvDiffSide `json:"right,omitempty"`
+}
+
+// kvDiffSide is the state of a key on one side of a comparison. Binary values
+// are only given by their hash.
+type kvDiffSide struct {
+	Key    string `json:"key"`
+	Flags  uint64 `json:"flags"`
+	Value  string `json:"value,omitempty"`
+	Binary bool   `json:"binary,omitempty"`
+	SHA256 string `json:"sha256"`
+}
+
+func newKVDiffSide(pair *api.KVPair) *kvDiffSide {
+	side := &kvDiffSide{
+		Key:    pair.Key,
+		Flags:  pair.Flags,
+		Binary: isBinary(pair.Value),
+		SHA256: fmt.Sprintf("%x", sha256.Sum256(pair.Value)),
+	}
+	if !side.Binary {
+		side.Value = string(pair.Value)
+	}
+	return side
+}
+
+// diffKVTrees returns the keys which differ between the two trees, sorted by
+// their relative key.
+func diffKVTrees(left, right *kvDiffTree) []*kvDiffEntry {
+	keys := make(map[string]struct{})
+	for k := range left.pairs {
+		keys[k] = struct{}{}
+	}
+	for k := range right.pairs {
+		keys[k] = struct{}{}
+	}
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	var diffs []*kvDiffEntry
+	for _, k := range sorted {
+		l, inLeft := left.pairs[k]
+		r, inRight := right.pairs[k]
+		switch {
+		case !inLeft:
+			diffs = append(diffs, &kvDiffEntry{Key: k, Change: kvDiffAdded, Right: newKVDiffSide(r)})
+		case !inRight:
+			diffs = append(diffs, &kvDiffEntry{Key: k, Change: kvDiffRemoved, Left: newKVDiffSide(l)})
+		case l.Flags != r.Flags || !bytes.Equal(l.Value, r.Value):
+			diffs = append(diffs, &kvDiffEntry{
+				Key:    k,
+				Change: kvDiffChanged,
+				Left:   newKVDiffSide(l),
+				Right:  newKVDiffSide(r),
+			})
+		}
+	}
+	return diffs
+}
+
+// unified renders the difference in the style of a unified diff.
+func (d *kvDiffEntry) unified() string {
+	var b bytes.Buffer
+	if d.Left != nil {
+		fmt.Fprintf(&b, "--- %s\n", d.Left.Key)
+	} else {
+		fmt.Fprintf(&b, "--- /dev/null\n")
+	}
+	if d.Right != nil {
+		fmt.Fprintf(&b, "+++ %s\n", d.Right.Key)
+	} else {
+		fmt.Fprintf(&b, "+++ /dev/null\n")
+	}
+
+	if d.Left != nil && d.Right != nil && d.Left.Flags != d.Right.Flags {
+		fmt.Fprintf(&b, "@@ flags %d => %d @@\n", d.Left.Flags, d.Right.Flags)
+	}
+
+	switch {
+	case (d.Left != nil && d.Left.Binary) || (d.Right != nil && d.Right.Binary):
+		if d.Left == nil || d.Right == nil || d.Left.SHA256 != d.Right.SHA256 {
+			fmt.Fprintf(&b, "Binary values differ\n")
+		}
+	case d.Left == nil:
+		for _, line := range splitLines(d.Right.Value) {
+			fmt.Fprintf(&b, "+%s\n", line)
+		}
+	case d.Right == nil:
+		for _, line := range splitLines(d.Left.Value) {
+			fmt.Fprintf(&b, "-%s\n", line)
+		}
+	case d.Left.Value != d.Right.Value:
+		b.WriteString(diffLines(splitLines(d.Left.Value), splitLines(d.Right.Value)))
+	}
+
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// splitLines splits a value into lines, without a trailing empty line for
+// a final newline.
+func splitLines(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(value, "\n"), "\n")
+}
+
+// kvDiffMaxLines limits the size of the line-by-line comparison of two
+// values, beyond which the values are shown as entirely replaced.
+const kvDiffMaxLines = 1000
+
+// diffLines returns a line-by-line diff of two values, with each line
+// prefixed by " ", "-", or "+". It uses the longest common subsequence of
+// the lines, which is fine for the size of values in the KV store.
+func diffLines(a, b []string) string {
+	var out bytes.Buffer
+	if len(a) > kvDiffMaxLines || len(b) > kvDiffMaxLines {
+		for _, line := range a {
+			fmt.Fprintf(&out, "-%s\n", line)
+		}
+		for _, line := range b {
+			fmt.Fprintf(&out, "+%s\n", line)
+		}
+		return out.String()
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:]
+	// and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			fmt.Fprintf(&out, " %s\n", a[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			fmt.Fprintf(&out, "-%s\n", a[i])
+			i++
+		default:
+			fmt.Fprintf(&out, "+%s\n", b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		fmt.Fprintf(&out, "-%s\n", a[i])
+	}
+	for ; j < len(b); j++ {
+		fmt.Fprintf(&out, "+%s\n", b[j])
+	}
+	return out.String()
+}
@@ -0,0 +1,235 @@
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+)
+
+func TestKVDiffCommand_implements(t *testing.T) {
+	var _ cli.Command = &KVDiffCommand{}
+}
+
+func TestKVDiffCommand_noTabs(t *testing.T) {
+	assertNoTabs(t, new(KVDiffCommand))
+}
+
+func TestKVDiffCommand_Validation(t *testing.T) {
+	cases := map[string]struct {
+		args   []string
+		output string
+	}{
+		"no args": {
+			[]string{},
+			"Missing LEFT and RIGHT arguments",
+		},
+		"too many args": {
+			[]string{"foo", "bar", "baz"},
+			"Too many arguments",
+		},
+		"two files": {
+			[]string{"@foo.json", "@bar.json"},
+			"At most one side can be an export file",
+		},
+		"file prefix without file": {
+			[]string{"-file-prefix=foo", "foo", "bar"},
+			"Can only specify -file-prefix",
+		},
+		"bad format": {
+			[]string{"-format=xml", "foo", "bar"},
+			"Unsupported format",
+		},
+	}
+
+	for name, tc := range cases {
+		ui := new(cli.MockUi)
+		c := &KVDiffCommand{Ui: ui}
+
+		code := c.Run(tc.args)
+		if code != 1 {
+			t.Errorf("%s: expected exit 1, got %d", name, code)
+		}
+		if output := ui.ErrorWriter.String(); !strings.Contains(output, tc.output) {
+			t.Errorf("%s: expected %q to contain %q", name, output, tc.output)
+		}
+	}
+}
+
+func TestKVDiffCommand_Run(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	for _, pair := range []*api.KVPair{
+		{Key: "prod/same", Value: []byte("same")},
+		{Key: "prod/removed", Value: []byte("old\n")},
+		{Key: "prod/config", Value: []byte("a\nb\nc\n")},
+		{Key: "prod/flags", Flags: 1, Value: []byte("x")},
+		{Key: "prod/binary", Value: []byte{0, 1, 2}},
+		{Key: "staging/same", Value: []byte("same")},
+		{Key: "staging/added", Value: []byte("new")},
+		{Key: "staging/config", Value: []byte("a\nB\nc\n")},
+		{Key: "staging/flags", Flags: 2, Value: []byte("x")},
+		{Key: "staging/binary", Value: []byte{0, 1, 3}},
+	} {
+		if _, err := client.KV().Put(pair, nil); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	ui := new(cli.MockUi)
+	c := &KVDiffCommand{Ui: ui}
+
+	code := c.Run([]string{"-http-addr=" + srv.httpAddr, "prod", "staging"})
+	if code != 2 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+
+	expected := `--- /dev/null
++++ staging/added
++new
+--- prod/binary
++++ staging/binary
+Binary values differ
+--- prod/config
++++ staging/config
+ a
+-b
++B
+ c
+--- prod/flags
++++ staging/flags
+@@ flags 1 => 2 @@
+--- prod/removed
++++ /dev/null
+-old
+1 added, 1 removed, 3 changed
+`
+	if output := ui.OutputWriter.String(); output != expected {
+		t.Fatalf("bad: %s", output)
+	}
+
+	// The JSON format lists the same changes.
+	ui = new(cli.MockUi)
+	c = &KVDiffCommand{Ui: ui}
+
+	code = c.Run([]string{"-http-addr=" + srv.httpAddr, "-format=json", "prod", "staging"})
+	if code != 2 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+
+	var diffs []*kvDiffEntry
+	if err := json.Unmarshal(ui.OutputWriter.Bytes(), &diffs); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	var changes []string
+	for _, d := range diffs {
+		changes = append(changes, d.Key+":"+d.Change)
+	}
+	if got := strings.Join(changes, " "); got != "added:added binary:changed config:changed flags:changed removed:removed" {
+		t.Fatalf("bad: %s", got)
+	}
+	if b := diffs[1]; !b.Left.Binary || b.Left.Value != "" || b.Left.SHA256 == b.Right.SHA256 {
+		t.Fatalf("bad: %#v %#v", b.Left, b.Right)
+	}
+	if cfg := diffs[2]; cfg.Left.Value != "a\nb\nc\n" || cfg.Right.Value != "a\nB\nc\n" {
+		t.Fatalf("bad: %#v %#v", cfg.Left, cfg.Right)
+	}
+
+	// A tree is the same as itself.
+	ui = new(cli.MockUi)
+	c = &KVDiffCommand{Ui: ui}
+
+	code = c.Run([]string{"-http-addr=" + srv.httpAddr, "prod", "prod"})
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if output := ui.OutputWriter.String(); output != "0 added, 0 removed, 0 changed\n" {
+		t.Fatalf("bad: %#v", output)
+	}
+}
+
+func TestKVDiffCommand_File(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	for _, pair := range []*api.KVPair{
+		{Key: "prod/a", Value: []byte("a")},
+		{Key: "staging/a", Value: []byte("a")},
+	} {
+		if _, err := client.KV().Put(pair, nil); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	// Export prod so it can be compared against.
+	ui := new(cli.MockUi)
+	stdout := new(bytes.Buffer)
+	export := &KVExportCommand{Ui: ui, testStdout: stdout}
+	if code := export.Run([]string{"-http-addr=" + srv.httpAddr, "prod"}); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+
+	dir, err := ioutil.TempDir("", "kv-diff")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	file := path.Join(dir, "prod.json")
+	if err := ioutil.WriteFile(file, stdout.Bytes(), 0600); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if _, err := client.KV().Put(&api.KVPair{Key: "prod/a", Value: []byte("b")}, nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	cases := map[string]struct {
+		args   []string
+		code   int
+		output string
+	}{
+		"changed since export": {
+			[]string{"prod", "@" + file},
+			2,
+			"--- prod/a\n+++ prod/a\n-b\n+a\n0 added, 0 removed, 1 changed\n",
+		},
+		"other prefix": {
+			[]string{"-file-prefix=prod", "@" + file, "staging"},
+			0,
+			"0 added, 0 removed, 0 changed\n",
+		},
+	}
+
+	for name, tc := range cases {
+		ui := new(cli.MockUi)
+		c := &KVDiffCommand{Ui: ui}
+
+		code := c.Run(append([]string{"-http-addr=" + srv.httpAddr}, tc.args...))
+		if code != tc.code {
+			t.Fatalf("%s: bad: %d. %#v", name, code, ui.ErrorWriter.String())
+		}
+		if output := ui.OutputWriter.String(); output != tc.output {
+			t.Fatalf("%s: bad: %#v", name, output)
+		}
+	}
+
+	// Keys in the file must be under the prefix.
+	ui = new(cli.MockUi)
+	c := &KVDiffCommand{Ui: ui}
+	code := c.Run([]string{"-http-addr=" + srv.httpAddr, "staging", "@" + file})
+	if code != 1 {
+		t.Fatalf("bad: %d", code)
+	}
+	if !strings.Contains(ui.ErrorWriter.String(), "does not have prefix") {
+		t.Fatalf("bad: %#v", ui.ErrorWriter.String())
+	}
+}
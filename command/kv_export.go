@@ -1,20 +1,37 @@
 package command
 
 import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
-	"flag"
 	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/command/agent"
 	"github.com/mitchellh/cli"
 )
 
 // KVExportCommand is a Command implementation that is used to export
 // a KV tree as JSON
 type KVExportCommand struct {
-	Ui cli.Ui
+	Ui         cli.Ui
+	ShutdownCh <-chan struct{}
+
+	// testStdout is the output for the exported data, for testing. The data
+	// never goes through the Ui, which may decorate what it prints.
+	testStdout io.Writer
 }
 
 func (c *KVExportCommand) Synopsis() string {
@@ -23,7 +40,7 @@ func (c *KVExportCommand) Synopsis() string {
 
 func (c *KVExportCommand) Help() string {
 	helpText := `
-Usage: consul kv export [KEY_OR_PREFIX]
+Usage: consul kv export [options] [KEY_OR_PREFIX]
 
   Retrieves key-value pairs for the given prefix from Consul's key-value store,
   and writes a JSON representation to stdout. This can be used with the command
@@ -31,25 +48,257 @@ Usage: consul kv export [KEY_OR_PREFIX]
 
       $ consul kv export vault
 
+  To get a more readable listing of the same tree, one key per line:
+
+      $ consul kv export -format=flat vault
+
+  To write a compressed export to a file, which "consul kv import" can read
+  without decompressing it first:
+
+      $ consul kv export -gzip -output=vault.json vault
+
+  To write a SHA-256 sum of the export alongside it, which can be checked with
+  "consul kv import -checksum" or "sha256sum -c":
+
+      $ consul kv export -output=vault.json -checksum=vault.json.sha256 vault
+
+  To export only the entries a team tags with the value 42 in the low byte of
+  their flags:
+
+      $ consul kv export -flags=42 -flags-mask=0xff vault
+
+  To only export the tree when it has changed since an earlier export, pass
+  the index printed by that export back in with -since-index:
+
+      $ consul kv export -wait-for-change -output=vault.json vault
+      Index: 1234
+      $ consul kv export -wait-for-change -since-index=1234 -output=vault.json vault
+
+  To keep an export in version control as the source of truth, including the
+  keys which were deleted since it was last written:
+
+      $ consul kv export -merge-deletions=vault.json vault > vault.new.json
+
+  To share an export without its secrets, replace the values under some keys,
+  and mask passwords in the rest:
+
+      $ consul kv export -redact=app/db/ -redact-pattern='password=(\S+)' app
+
+  If the export is interrupted, the requests in progress are cancelled, the
+  file given with -output is left untouched, and the command exits with
+  status 130.
+
   For a full list of options and examples, please see the Consul documentation.
 
 ` + apiOptsText + `
 
+` + kvKeyOptsText + `
+
+` + kvFlagsFilterOptsText + `
+
 KV Export Options:
 
-  None.
+  -checksum=<path>        Write the SHA-256 sum of the export to the given
+                          file, in the format used by sha256sum. The sum is
+                          of the exact bytes written to stdout or -output,
+                          after any compression. The file is only written
+                          once the export is complete.
+
+  -exclude=<pattern>      Skip keys starting with the given prefix. The "*"
+                          character can be used to match any part of a single
+                          path segment, such as "app/*/secrets/". This can be
+                          specified multiple times. A summary of the number
+                          of excluded keys is written to stderr.
+
+  -flags-as-string        Write the flags of each entry as a string, such as
+                          "flags": "18446744073709551615", instead of a
+                          number. Tools which read JSON numbers as floating
+                          point, such as JavaScript and jq, change flags above
+                          2^53, but leave strings untouched. Imports accept
+                          either form. The default value is false.
+
+  -folders-only           Only export folders, keys ending in "/" with an
+                          empty value, for tools which rebuild the structure
+                          of the tree. A summary of the number of other keys
+                          skipped is written to stderr. The default value is
+                          false.
+
+  -gzip                   Compress the output with gzip. With -output, a ".gz"
+                          extension is added to the file name if it's
+                          missing. The default value is false.
+
+  -format=<string>        Output format. One of "json", "yaml", or "flat". The
+                          "json" and "yaml" formats include the key, flags, and
+                          base64 encoded value of each entry and can be read
+                          by "consul kv import". The "flat" format writes one
+                          "key=value" line per entry with the raw value, or
+                          "key=base64:<value>" if the value is not printable.
+                          The default value is "json".
+
+  -pretty                 Indent the JSON output. With -pretty=false, each
+                          entry is written on a single line instead, which
+                          keeps diffs between exports small. This only
+                          applies to the json format. The default value is
+                          true.
+
+  -include-locked         Export keys held by a lock without listing them as
+                          warnings. The default value is false.
+
+  -output=<path>          Write the export to the given file instead of
+                          stdout. The data is written to a temporary file
+                          which is renamed into place once it's complete, so
+                          a failed export never leaves a partial file. A new
+                          file is only readable by the current user.
+
+  -jobs=<int>             Number of requests used to fetch values in parallel.
+                          Entries are always written in key order, so the
+                          output is the same for any setting, and as many
+                          connections to the agent are kept open for reuse.
+                          The default value is 4.
+
+  -merge-deletions=<path> Path to an earlier export of the same prefix, in the
+                          json or yaml format. Each key in it which no longer
+                          exists is written as a tombstone, an entry with
+                          "deleted": true, so "consul kv import" deletes the
+                          key. Tombstones in the earlier export are kept while
+                          their keys are still gone, and excluded keys are
+                          left out. Only supported with the json and yaml
+                          formats.
+
+  -redact=<pattern>       Replace the value of each key matching the pattern
+                          with "<redacted>", and mark the entry as redacted so
+                          "consul kv import" refuses to write it back. The
+                          pattern matches the same way as -exclude. This can
+                          be specified multiple times. A summary of the number
+                          of redacted keys is written to stderr.
+
+  -redact-pattern=<expr>  Replace the parts of each value matching the
+                          regular expression with "<redacted>", and mark the
+                          entry as redacted. If the expression has groups,
+                          only the text they match is replaced, so
+                          "password=(\S+)" keeps the "password=". This can
+                          be specified multiple times.
+
+  -since-index=<index>    With -wait-for-change, the index printed by an
+                          earlier export. If the tree hasn't changed since
+                          then, the command waits for it to change, and exits
+                          with status 2 without exporting anything if it
+                          doesn't change within the -wait time. If the index
+                          has gone backwards, such as after a snapshot
+                          restore, the tree is exported right away.
+
+  -skip-folders           Leave folders, keys ending in "/" with an empty
+                          value, out of the export. Keys ending in "/" which
+                          have a value are still exported. A summary of the
+                          number of folders skipped is written to stderr. The
+                          default value is false.
+
+  -skip-locked            Leave keys held by a lock out of the export. The
+                          default value is false.
+
+  -wait=<duration>        Maximum time to wait for a change with -since-index.
+                          The default value is 10m.
+
+  -wait-for-change        Print the index of the tree to stderr after the
+                          export, as "Index: <index>", so it can be passed to
+                          -since-index next time. The default value is false.
+
+  Entries are always sorted by key, and their fields are always written in the
+  same order, so exporting the same data twice gives identical output.
+
+  Each exported entry also records the ModifyIndex the key had at the time of
+  the export. This is informational only and is not restored on import.
+
+  Folders, keys ending in "/" with an empty value, are marked with
+  "is_folder": true, which is ignored by "consul kv import".
+
+  Keys held by a lock also record the ID of the session holding it in the
+  "session" field. Sessions can't be moved between clusters, so the locks are
+  not restored on import. By default, locked keys are exported and listed on
+  stderr.
 `
 	return strings.TrimSpace(helpText)
 }
 
-func (c *KVExportCommand) Run(args []string) int {
-	cmdFlags := flag.NewFlagSet("export", flag.ContinueOnError)
+func (c *KVExportCommand) Run(args []string) (code int) {
+	cmdFlags, apiFlags := NewAPIFlagSet("export", c.Ui)
+	defer apiFlags.CheckTimeout(c.Ui, &code)
+
+	format := cmdFlags.String("format", "json", "")
+	pretty := cmdFlags.Bool("pretty", true, "")
+	flagsAsString := cmdFlags.Bool("flags-as-string", false, "")
+	jobs := cmdFlags.Int("jobs", kvDefaultJobs, "")
+	includeLocked := cmdFlags.Bool("include-locked", false, "")
+	skipLocked := cmdFlags.Bool("skip-locked", false, "")
+	output := cmdFlags.String("output", "", "")
+	gzipOutput := cmdFlags.Bool("gzip", false, "")
+	checksum := cmdFlags.String("checksum", "", "")
+	waitForChange := cmdFlags.Bool("wait-for-change", false, "")
+	sinceIndex := cmdFlags.Uint64("since-index", 0, "")
+	wait := cmdFlags.Duration("wait", 10*time.Minute, "")
+	mergeDeletions := cmdFlags.String("merge-deletions", "", "")
+	skipFolders := cmdFlags.Bool("skip-folders", false, "")
+	foldersOnly := cmdFlags.Bool("folders-only", false, "")
+	var excludes []string
+	cmdFlags.Var((*agent.AppendSliceValue)(&excludes), "exclude", "")
+	var redacts, redactPatterns []string
+	cmdFlags.Var((*agent.AppendSliceValue)(&redacts), "redact", "")
+	cmdFlags.Var((*agent.AppendSliceValue)(&redactPatterns), "redact-pattern", "")
+	keyFlags := newKVKeyFlags(cmdFlags)
+	flagsFilter := newKVFlagsFilter(cmdFlags)
+	if err := parseFlags(c.Ui, cmdFlags, args); err != nil {
+		return 1
+	}
+	defer apiFlags.WatchShutdown(c.ShutdownCh)()
+
+	// The output is only opened once the export is ready to start, so a
+	// file isn't created if the arguments are bad.
+	var out *kvExportOutput
+	w, err := newKVEntryWriter(*format, *pretty, *flagsAsString, func(line string) { out.WriteLine(line) })
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error! %s", err))
+		return 1
+	}
+
+	if *jobs < 1 {
+		c.Ui.Error("Error! -jobs must be at least 1")
+		return 1
+	}
+
+	if *includeLocked && *skipLocked {
+		c.Ui.Error("Error! Cannot specify both -include-locked and -skip-locked")
+		return 1
+	}
 
-	datacenter := cmdFlags.String("datacenter", "", "")
-	token := cmdFlags.String("token", "", "")
-	stale := cmdFlags.Bool("stale", false, "")
-	httpAddr := HTTPAddrFlag(cmdFlags)
-	if err := cmdFlags.Parse(args); err != nil {
+	if *sinceIndex != 0 && !*waitForChange {
+		c.Ui.Error("Error! Can only specify -since-index with -wait-for-change")
+		return 1
+	}
+	if *wait <= 0 {
+		c.Ui.Error("Error! -wait must be positive")
+		return 1
+	}
+	if err := flagsFilter.validate(); err != nil {
+		c.Ui.Error(fmt.Sprintf("Error! %s", err))
+		return 1
+	}
+	if *mergeDeletions != "" && *format == "flat" {
+		c.Ui.Error("Error! -merge-deletions is only supported with the json and yaml formats")
+		return 1
+	}
+	if *skipFolders && *foldersOnly {
+		c.Ui.Error("Error! Cannot specify both -skip-folders and -folders-only")
+		return 1
+	}
+
+	exclude, err := newKVKeyFilter("exclude", excludes)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error! %s", err))
+		return 1
+	}
+	redactor, err := newKVRedactor(redacts, redactPatterns)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error! %s", err))
 		return 1
 	}
 
@@ -66,54 +315,670 @@ func (c *KVExportCommand) Run(args []string) int {
 		return 1
 	}
 
-	// This is just a "nice" thing to do. Since pairs cannot start with a /, but
-	// users will likely put "/" or "/foo", lets go ahead and strip that for them
-	// here.
-	if len(key) > 0 && key[0] == '/' {
-		key = key[1:]
+	// Keys can't start with a slash, but users will likely put "/" or "/foo",
+	// so that's stripped along with anything else that needs normalizing.
+	key, err = keyFlags.check(c.Ui, key)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error! %s", err))
+		return 1
+	}
+
+	var previous []*kvExportEntry
+	if *mergeDeletions != "" {
+		if previous, err = kvReadExport(*mergeDeletions); err != nil {
+			c.Ui.Error(fmt.Sprintf("Error! %s", err))
+			return 1
+		}
 	}
 
 	// Create and test the HTTP client
-	conf := api.DefaultConfig()
-	conf.Address = *httpAddr
-	if *token != "" {
-		conf.Token = *token
-	}
-	client, err := api.NewClient(conf)
+	apiFlags.jobs = *jobs
+	client, err := apiFlags.Client()
 	if err != nil {
 		c.Ui.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
 		return 1
 	}
 
-	pairs, _, err := client.KV().List(key, &api.QueryOptions{
-		Datacenter: *datacenter,
-		AllowStale: *stale,
-	})
+	qo := apiFlags.QueryOptions()
+
+	// Only the key names are listed up front. Values are fetched and written
+	// out in chunks so that memory use stays bounded on very large trees.
+	denial := kvDenial("read keys under "+key, key, "read")
+	keys, meta, err := client.KV().Keys(key, "", qo)
 	if err != nil {
-		c.Ui.Error(fmt.Sprintf("Error querying Consul agent: %s", err))
+		if apiFlags.Cancelled() {
+			c.Ui.Error("Cancelled while listing keys, nothing was exported")
+			return exitCancelled
+		}
+		c.Ui.Error(apiFlags.errorMessage("Error querying Consul agent", err, denial))
 		return 1
 	}
 
-	exported := make([]*kvExportEntry, len(pairs))
-	for i, pair := range pairs {
-		exported[i] = toExportEntry(pair)
+	index := meta.LastIndex
+	if *sinceIndex != 0 {
+		if index == *sinceIndex {
+			keys, index, err = c.waitForChange(client, key, *sinceIndex, *wait, qo)
+			if err != nil {
+				if apiFlags.Cancelled() {
+					c.Ui.Error("Cancelled while waiting for a change, nothing was exported")
+					return exitCancelled
+				}
+				c.Ui.Error(apiFlags.errorMessage("Error querying Consul agent", err, denial))
+				return 1
+			}
+		}
+		if index == *sinceIndex {
+			c.Ui.Warn(fmt.Sprintf("Index: %d", index))
+			c.Ui.Error(fmt.Sprintf("No change after %s", *wait))
+			return exitNotFound
+		}
+		if index < *sinceIndex {
+			c.Ui.Warn(fmt.Sprintf("Warning! The index went backwards from %d to %d, such as "+
+				"after a snapshot restore, so the tree is exported again", *sinceIndex, index))
+		}
+	}
+
+	// Sort the keys here rather than relying on the order the servers list
+	// them in, so the output is stable.
+	sort.Strings(keys)
+
+	// Keys in the earlier export which are gone now get tombstones, which
+	// are written in key order along with the rest of the entries. Keys
+	// which still exist but are left out, such as by -flags, aren't gone.
+	tombstones := kvTombstones(previous, keys, key, func(k string) bool {
+		return len(excludes) > 0 && exclude(k)
+	})
+	deleted := len(tombstones)
+	writeTombstones := func(before string) error {
+		for len(tombstones) > 0 && (before == "" || tombstones[0] < before) {
+			if err := w.WriteEntry(&kvExportEntry{Key: tombstones[0], Deleted: true}); err != nil {
+				return fmt.Errorf("Error exporting KV data: %s", err)
+			}
+			tombstones = tombstones[1:]
+		}
+		return nil
 	}
 
-	marshaled, err := json.MarshalIndent(exported, "", "\t")
+	excluded := 0
+	if len(excludes) > 0 {
+		filtered := keys[:0]
+		for _, k := range keys {
+			if exclude(k) {
+				excluded++
+				continue
+			}
+			filtered = append(filtered, k)
+		}
+		keys = filtered
+	}
+	total := len(keys)
+
+	path := *output
+	if *gzipOutput && path != "" && !strings.HasSuffix(path, ".gz") {
+		path += ".gz"
+	}
+	if *checksum != "" && *checksum == path {
+		c.Ui.Error("Error! -checksum must be a different file from -output")
+		return 1
+	}
+	out, err = c.createOutput(path, *gzipOutput, *checksum)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error! %s", err))
+		return 1
+	}
+	defer out.Abort()
+
+	// The flags are only known once the values are fetched, so entries are
+	// filtered by them as they're written.
+	var locked []string
+	filtered, written, redacted, folders := 0, 0, 0, 0
+	err = kvFetchParallel(client, keys, qo, *jobs, func(pairs api.KVPairs) error {
+		for _, pair := range pairs {
+			if err := writeTombstones(pair.Key); err != nil {
+				return err
+			}
+			if !flagsFilter.match(pair.Flags) {
+				filtered++
+				total--
+				continue
+			}
+			if isFolder := kvIsFolder(pair.Key, pair.Value); (*skipFolders && isFolder) || (*foldersOnly && !isFolder) {
+				folders++
+				total--
+				continue
+			}
+			if pair.Session != "" {
+				locked = append(locked, pair.Key)
+				if *skipLocked {
+					total--
+					continue
+				}
+			}
+			entry := toExportEntry(pair)
+			if value, ok := redactor.redact(pair.Key, pair.Value); ok {
+				entry.Value = base64.StdEncoding.EncodeToString(value)
+				entry.Redacted = true
+				redacted++
+			}
+			if err := w.WriteEntry(entry); err != nil {
+				return fmt.Errorf("Error exporting KV data: %s", err)
+			}
+			written++
+		}
+		return nil
+	})
 	if err != nil {
+		if apiFlags.Cancelled() {
+			// A file is removed by the deferred Abort, while what went to
+			// stdout can't be taken back.
+			msg := fmt.Sprintf("Cancelled after %d of %d %s (%d bytes)",
+				written, len(keys), pluralKeys(len(keys)), out.n)
+			if path != "" {
+				msg += fmt.Sprintf(", %s was not written", path)
+			}
+			c.Ui.Error(msg)
+			return exitCancelled
+		}
+		c.Ui.Error(err.Error())
+		return 1
+	}
+	if err := writeTombstones(""); err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+	if err := w.Flush(); err != nil {
 		c.Ui.Error(fmt.Sprintf("Error exporting KV data: %s", err))
 		return 1
 	}
+	if err := out.Commit(); err != nil {
+		c.Ui.Error(fmt.Sprintf("Error writing export: %s", err))
+		return 1
+	}
+
+	if *waitForChange {
+		c.Ui.Warn(fmt.Sprintf("Index: %d", index))
+	}
+
+	if len(excludes) > 0 {
+		c.Ui.Warn(fmt.Sprintf("Exported %d %s, excluded %d %s",
+			total, pluralKeys(total), excluded, pluralKeys(excluded)))
+	}
+	if flagsFilter.enabled() {
+		c.Ui.Warn(fmt.Sprintf("Exported %d %s with %s, skipped %d with other flags",
+			total, pluralKeys(total), flagsFilter, filtered))
+	}
+	if *skipFolders {
+		c.Ui.Warn(fmt.Sprintf("Exported %d %s, skipped %d folder %s",
+			total, pluralKeys(total), folders, pluralKeys(folders)))
+	}
+	if *foldersOnly {
+		c.Ui.Warn(fmt.Sprintf("Exported %d folder %s, skipped %d other %s",
+			total, pluralKeys(total), folders, pluralKeys(folders)))
+	}
+	if len(redacts) > 0 || len(redactPatterns) > 0 {
+		c.Ui.Warn(fmt.Sprintf("Redacted the values of %d %s", redacted, pluralKeys(redacted)))
+	}
+	if *mergeDeletions != "" {
+		c.Ui.Warn(fmt.Sprintf("Marked %d %s as deleted", deleted, pluralKeys(deleted)))
+	}
 
-	c.Ui.Info(string(marshaled))
+	switch {
+	case len(locked) == 0 || *includeLocked:
+	case *skipLocked:
+		c.Ui.Warn(fmt.Sprintf("Skipped %d locked %s", len(locked), pluralKeys(len(locked))))
+	default:
+		for _, k := range locked {
+			c.Ui.Warn(fmt.Sprintf("Locked: %s", k))
+		}
+		c.Ui.Warn(fmt.Sprintf("Warning! Exported %d %s held by a lock, which won't be locked on import",
+			len(locked), pluralKeys(len(locked))))
+	}
 
 	return 0
 }
 
+// waitForChange lists the keys under the prefix with a blocking query, until
+// the index of the tree moves away from the given index or the wait time runs
+// out. It returns the keys and the index they were listed at, which is still
+// the given index if there was no change.
+func (c *KVExportCommand) waitForChange(client *api.Client, prefix string, index uint64,
+	wait time.Duration, q *api.QueryOptions) ([]string, uint64, error) {
+	blocking := *q
+	deadline := time.Now().Add(wait)
+	for {
+		remaining := deadline.Sub(time.Now())
+		if remaining <= 0 {
+			return nil, index, nil
+		}
+
+		blocking.WaitIndex = index
+		blocking.WaitTime = remaining
+		keys, meta, err := client.KV().Keys(prefix, "", &blocking)
+		if err != nil {
+			return nil, 0, err
+		}
+		if meta.LastIndex != index {
+			return keys, meta.LastIndex, nil
+		}
+	}
+}
+
+// createOutput returns the destination for an export, which is stdout if the
+// path is empty. If a checksum path is given, the sum of everything written
+// is saved there on Commit.
+func (c *KVExportCommand) createOutput(path string, compress bool, checksum string) (*kvExportOutput, error) {
+	return newKVExportOutput(path, c.stdout(), compress, checksum)
+}
+
+// newKVExportOutput returns the destination for an export written to the
+// given path, or to stdout if the path is empty.
+func newKVExportOutput(path string, stdout io.Writer, compress bool, checksum string) (*kvExportOutput, error) {
+	o := &kvExportOutput{}
+	var w io.Writer
+	if path == "" {
+		w = stdout
+	} else {
+		f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create output file: %s", err)
+		}
+		o.file, o.path = f, path
+		w = f
+	}
+
+	// The sum is of the bytes as they leave, so it matches the file or
+	// stream without having to read it back.
+	if checksum != "" {
+		o.sum, o.checksum = sha256.New(), checksum
+		w = io.MultiWriter(w, o.sum)
+	}
+
+	if compress {
+		o.gzip = gzip.NewWriter(w)
+		w = o.gzip
+	}
+	o.buf = bufio.NewWriter(w)
+	return o, nil
+}
+
+// stdout returns the writer for exports which aren't written to a file.
+func (c *KVExportCommand) stdout() io.Writer {
+	if c.testStdout != nil {
+		return c.testStdout
+	}
+	return os.Stdout
+}
+
+// kvExportOutput writes an export to stdout or a file, optionally compressed.
+// A file is written under a temporary name and only renamed into place by
+// Commit, so a failed export leaves nothing behind.
+type kvExportOutput struct {
+	buf  *bufio.Writer
+	gzip *gzip.Writer
+	file *os.File
+	path string
+	done bool
+
+	// sum hashes everything written, for the checksum file at checksum.
+	sum      hash.Hash
+	checksum string
+
+	// n is the number of bytes of the export written so far, before any
+	// compression.
+	n int64
+}
+
+// WriteLine writes a line of the export. Errors are held by the buffer and
+// reported by Commit.
+func (o *kvExportOutput) WriteLine(line string) {
+	o.buf.WriteString(line)
+	o.buf.WriteByte('\n')
+	o.n += int64(len(line)) + 1
+}
+
+// Commit flushes the export and moves the file into place.
+func (o *kvExportOutput) Commit() error {
+	if err := o.buf.Flush(); err != nil {
+		return err
+	}
+	if o.gzip != nil {
+		if err := o.gzip.Close(); err != nil {
+			return err
+		}
+	}
+	if o.file == nil {
+		return o.writeChecksum("-")
+	}
+
+	if err := o.file.Sync(); err != nil {
+		return err
+	}
+	if err := o.file.Close(); err != nil {
+		return err
+	}
+
+	// The checksum goes first, so an export never appears without it.
+	if err := o.writeChecksum(o.path); err != nil {
+		return err
+	}
+	if err := os.Rename(o.file.Name(), o.path); err != nil {
+		if o.sum != nil {
+			os.Remove(o.checksum)
+		}
+		return err
+	}
+	o.done = true
+	return nil
+}
+
+// writeChecksum writes the checksum file, if there is one, naming the export
+// as given.
+func (o *kvExportOutput) writeChecksum(name string) error {
+	if o.sum == nil {
+		return nil
+	}
+	if err := writeChecksumFile(o.checksum, name, o.sum.Sum(nil)); err != nil {
+		return fmt.Errorf("failed to write checksum file: %s", err)
+	}
+	return nil
+}
+
+// Abort removes the temporary file if the export wasn't committed.
+func (o *kvExportOutput) Abort() {
+	if o.file != nil && !o.done {
+		o.file.Close()
+		os.Remove(o.file.Name())
+	}
+}
+
+// newKVKeyFilter returns a function reporting whether a key matches any of
+// the patterns given with the named flag, such as -exclude. A pattern matches
+// any key which starts with it. The "*" character in a pattern matches any
+// run of characters within a single path segment, so "app/*/secrets/"
+// matches "app/web/secrets/key".
+func newKVKeyFilter(flag string, patterns []string) (func(string) bool, error) {
+	var prefixes []string
+	var globs []*regexp.Regexp
+	for _, p := range patterns {
+		p = strings.TrimLeft(p, "/")
+		if p == "" {
+			return nil, fmt.Errorf("Empty -%s pattern", flag)
+		}
+
+		if !strings.Contains(p, "*") {
+			prefixes = append(prefixes, p)
+			continue
+		}
+
+		parts := strings.Split(p, "*")
+		for i := range parts {
+			parts[i] = regexp.QuoteMeta(parts[i])
+		}
+		re, err := regexp.Compile("^" + strings.Join(parts, "[^/]*"))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid -%s pattern %q: %s", flag, p, err)
+		}
+		globs = append(globs, re)
+	}
+
+	return func(key string) bool {
+		for _, p := range prefixes {
+			if strings.HasPrefix(key, p) {
+				return true
+			}
+		}
+		for _, re := range globs {
+			if re.MatchString(key) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// kvExportChunkSize is the number of values fetched per request during an
+// export.
+const kvExportChunkSize = kvMaxTxnOps
+
+// kvFetchChunk reads the values for the given keys using a read-only
+// transaction. Keys that were deleted after they were listed are skipped.
+func kvFetchChunk(client *api.Client, keys []string, q *api.QueryOptions) (api.KVPairs, error) {
+	for len(keys) > 0 {
+		ops := make(api.KVTxnOps, 0, len(keys))
+		for _, k := range keys {
+			ops = append(ops, &api.KVTxnOp{
+				Verb: api.KVGet,
+				Key:  k,
+			})
+		}
+
+		ok, resp, err := kvTxn(client, ops, q)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return resp.Results, nil
+		}
+
+		// The transaction was rolled back, which for a read-only transaction
+		// means some of the keys no longer exist. Drop those and try again.
+		missing := make(map[int]struct{})
+		for _, e := range resp.Errors {
+			if !strings.Contains(e.What, "doesn't exist") {
+				return nil, fmt.Errorf("failed reading %q: %s", keys[e.OpIndex], e.What)
+			}
+			missing[e.OpIndex] = struct{}{}
+		}
+		if len(missing) == 0 {
+			return nil, fmt.Errorf("transaction rolled back without errors")
+		}
+
+		remaining := make([]string, 0, len(keys))
+		for i, k := range keys {
+			if _, ok := missing[i]; !ok {
+				remaining = append(remaining, k)
+			}
+		}
+		keys = remaining
+	}
+	return nil, nil
+}
+
+// kvDefaultJobs is the number of requests made at once to fetch values, for
+// commands which don't have a -jobs flag to change it.
+const kvDefaultJobs = 4
+
+// kvFetchParallel fetches the values for the given keys in chunks, using up
+// to jobs concurrent requests, and passes each chunk to emit in key order.
+// Only a window of jobs chunks is held in memory at once. The first error
+// stops any further chunks from being fetched and is returned.
+func kvFetchParallel(client *api.Client, keys []string, q *api.QueryOptions,
+	jobs int, emit func(api.KVPairs) error) error {
+	type result struct {
+		pairs api.KVPairs
+		err   error
+	}
+
+	// Each chunk gets its own result channel, queued in key order so the
+	// results can be consumed in order no matter when they complete. The
+	// chunk being consumed counts towards the limit, so the queue holds one
+	// less than jobs.
+	queue := make(chan chan result, jobs-1)
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		defer close(queue)
+		for len(keys) > 0 {
+			n := kvExportChunkSize
+			if n > len(keys) {
+				n = len(keys)
+			}
+			chunk := keys[:n]
+			keys = keys[n:]
+
+			ch := make(chan result, 1)
+			select {
+			case queue <- ch:
+			case <-done:
+				return
+			}
+
+			go func() {
+				pairs, err := kvFetchChunk(client, chunk, q)
+				ch <- result{pairs, err}
+			}()
+		}
+	}()
+
+	for ch := range queue {
+		res := <-ch
+		if res.err != nil {
+			return fmt.Errorf("Error querying Consul agent: %s", res.err)
+		}
+		sort.Sort(kvPairsByKey(res.pairs))
+		if err := emit(res.pairs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// kvReadExport reads the entries of an export from a file, in either the json
+// or yaml format, and compressed or not.
+func kvReadExport(file string) ([]*kvExportEntry, error) {
+	raw, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("File not found: %s", file)
+		}
+		return nil, fmt.Errorf("Failed to read file: %s", err)
+	}
+	data, err := gunzipIfCompressed(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	format := "yaml"
+	if strings.HasPrefix(strings.TrimSpace(data), "[") {
+		format = "json"
+	}
+	entries, err := decodeKVEntries(format, data)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot unmarshal %s: %s", file, err)
+	}
+	return entries, nil
+}
+
+// kvTombstones returns the keys under the prefix of the previous entries,
+// including tombstones, which aren't in the sorted list of current keys and
+// aren't excluded. They're returned sorted, with no duplicates.
+func kvTombstones(previous []*kvExportEntry, keys []string, prefix string, excluded func(string) bool) []string {
+	var tombstones []string
+	seen := make(map[string]bool)
+	for _, entry := range previous {
+		k := entry.Key
+		if !strings.HasPrefix(k, prefix) || seen[k] || excluded(k) {
+			continue
+		}
+		seen[k] = true
+		if i := sort.SearchStrings(keys, k); i < len(keys) && keys[i] == k {
+			continue
+		}
+		tombstones = append(tombstones, k)
+	}
+	sort.Strings(tombstones)
+	return tombstones
+}
+
+// kvPairsByKey sorts KV pairs by key.
+type kvPairsByKey api.KVPairs
+
+func (p kvPairsByKey) Len() int           { return len(p) }
+func (p kvPairsByKey) Less(i, j int) bool { return p[i].Key < p[j].Key }
+func (p kvPairsByKey) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// kvExportEntry is a single entry of an export. The order of the fields is
+// the order they're written in, which is kept stable so that exports of the
+// same data are identical.
 type kvExportEntry struct {
 	Key   string `json:"key"`
 	Flags uint64 `json:"flags"`
 	Value string `json:"value"`
+
+	// ModifyIndex is the index the key was last modified at when it was
+	// exported. It's informational only and is not restored by an import,
+	// since indexes are assigned by the destination cluster.
+	ModifyIndex uint64 `json:"modify_index,omitempty"`
+
+	// Session is the ID of the session holding a lock on the key when it
+	// was exported. It's ignored by an import, since sessions belong to the
+	// cluster they were created in.
+	Session string `json:"session,omitempty"`
+
+	// Redacted is set when the value was replaced or masked by -redact or
+	// -redact-pattern, so an import doesn't overwrite the real value with
+	// the placeholder by mistake.
+	Redacted bool `json:"redacted,omitempty"`
+
+	// Deleted marks a tombstone, written by -merge-deletions for a key which
+	// was in an earlier export but no longer exists, so an import deletes
+	// the key rather than writing it. Its flags and value are ignored.
+	Deleted bool `json:"deleted,omitempty"`
+
+	// IsFolder is set for a folder, a key ending in "/" with an empty
+	// value, which only marks a path in the tree. It's for the convenience
+	// of other tools, and is ignored by an import, which goes by the key
+	// and value.
+	IsFolder bool `json:"is_folder,omitempty"`
+}
+
+// kvExportEntryStringFlags is a kvExportEntry with the flags written as a
+// string, for -flags-as-string. Tools which read JSON numbers as doubles,
+// such as JavaScript and jq, lose precision on flags above 2^53, but can
+// pass a string through untouched.
+type kvExportEntryStringFlags struct {
+	Key         string `json:"key"`
+	Flags       uint64 `json:"flags,string"`
+	Value       string `json:"value"`
+	ModifyIndex uint64 `json:"modify_index,omitempty"`
+	Session     string `json:"session,omitempty"`
+	Redacted    bool   `json:"redacted,omitempty"`
+	Deleted     bool   `json:"deleted,omitempty"`
+	IsFolder    bool   `json:"is_folder,omitempty"`
+}
+
+// UnmarshalJSON accepts the flags as either a number or a string, so exports
+// written with and without -flags-as-string can both be read. Numbers are
+// parsed exactly, and a number which isn't a whole uint64, as left by a tool
+// which rounded it, is rejected rather than written with the wrong flags.
+func (e *kvExportEntry) UnmarshalJSON(data []byte) error {
+	type entry kvExportEntry
+	raw := struct {
+		*entry
+		Flags json.RawMessage `json:"flags"`
+	}{entry: (*entry)(e)}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	flags := string(raw.Flags)
+	switch {
+	case flags == "" || flags == "null":
+		e.Flags = 0
+		return nil
+	case flags[0] == '"':
+		if err := json.Unmarshal(raw.Flags, &flags); err != nil {
+			return err
+		}
+	}
+	n, err := strconv.ParseUint(flags, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid flags %s for key %q (expected an unsigned 64 bit integer)",
+			raw.Flags, e.Key)
+	}
+	e.Flags = n
+	return nil
 }
 
 func toExportEntry(pair *api.KVPair) *kvExportEntry {
@@ -121,5 +986,15 @@ func toExportEntry(pair *api.KVPair) *kvExportEntry {
 		Key:   pair.Key,
 		Flags: pair.Flags,
 		Value: base64.StdEncoding.EncodeToString(pair.Value),
+
+		ModifyIndex: pair.ModifyIndex,
+		Session:     pair.Session,
+		IsFolder:    kvIsFolder(pair.Key, pair.Value),
 	}
 }
+
+// kvIsFolder returns true if the key is a folder, which ends in "/" and has
+// an empty value. A key ending in "/" which has a value is an ordinary key.
+func kvIsFolder(key string, value []byte) bool {
+	return strings.HasSuffix(key, "/") && len(value) == 0
+}
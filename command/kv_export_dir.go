@@ -0,0 +1,192 @@
+package command
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+)
+
+// KVExportDirCommand is a Command implementation that is used to write a
+// tree from the key-value store out as a local directory tree.
+type KVExportDirCommand struct {
+	Ui cli.Ui
+}
+
+func (c *KVExportDirCommand) Synopsis() string {
+	return "Exports a tree from the KV store as files in a directory"
+}
+
+func (c *KVExportDirCommand) Help() string {
+	helpText := `
+Usage: consul kv export-dir [options] PREFIX DIR
+
+  Writes each key under the given prefix to a file under the given directory.
+  The path of the file is the key relative to the prefix, and its contents are
+  the value of the key:
+
+      $ consul kv export-dir app/ ./config
+
+  With the above, the key "app/web/nginx.conf" is written to the file
+  "./config/web/nginx.conf". Directories are created as needed, and existing
+  files are overwritten. This is the reverse of "consul kv import-dir".
+
+  Every key is checked before anything is written. The export is refused if
+  any key can't be written safely under DIR, such as one with a ".." path
+  segment, or one which is also the parent of other keys. Flags and sessions
+  can't be stored in files, so they are not exported.
+
+  For a full list of options and examples, please see the Consul documentation.
+
+` + apiOptsText + `
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *KVExportDirCommand) Run(args []string) (code int) {
+	cmdFlags, apiFlags := NewAPIFlagSet("export-dir", c.Ui)
+	defer apiFlags.CheckTimeout(c.Ui, &code)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	if err := parseFlags(c.Ui, cmdFlags, args); err != nil {
+		return 1
+	}
+
+	args = cmdFlags.Args()
+	switch len(args) {
+	case 0:
+		c.Ui.Error("Error! Missing PREFIX and DIR arguments")
+		return 1
+	case 1:
+		c.Ui.Error("Error! Missing DIR argument")
+		return 1
+	case 2:
+	default:
+		c.Ui.Error(fmt.Sprintf("Too many arguments (expected 2, got %d)", len(args)))
+		return 1
+	}
+	prefix, dir := normalizeKVPrefix(args[0]), args[1]
+	if dir == "" {
+		c.Ui.Error("Error! Missing DIR argument")
+		return 1
+	}
+
+	// Create and test the HTTP client
+	client, err := apiFlags.Client()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+		return 1
+	}
+
+	q := apiFlags.QueryOptions()
+	keys, _, err := client.KV().Keys(prefix, "", q)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error querying Consul agent: %s", err))
+		return 1
+	}
+	sort.Strings(keys)
+
+	// Check every key before writing anything, so a bad key doesn't leave a
+	// half-written tree behind.
+	files, err := kvDirFiles(dir, prefix, keys)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error! %s", err))
+		return 1
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		c.Ui.Error(fmt.Sprintf("Error! Failed to create directory: %s", err))
+		return 1
+	}
+
+	written, unsaved := 0, 0
+	err = kvFetchParallel(client, keys, q, kvDefaultJobs, func(pairs api.KVPairs) error {
+		for _, pair := range pairs {
+			file := files[pair.Key]
+			if strings.HasSuffix(pair.Key, "/") {
+				// Keys ending in a "/" only mark a directory.
+				if err := os.MkdirAll(file, 0755); err != nil {
+					return fmt.Errorf("Error! Failed to create directory: %s", err)
+				}
+				continue
+			}
+
+			if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+				return fmt.Errorf("Error! Failed to create directory: %s", err)
+			}
+			if err := ioutil.WriteFile(file, pair.Value, 0644); err != nil {
+				return fmt.Errorf("Error! Failed writing file for key %s: %s", pair.Key, err)
+			}
+			apiFlags.report(c.Ui, fmt.Sprintf("Exported: %s", file))
+
+			written++
+			if pair.Flags != 0 || pair.Session != "" {
+				unsaved++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+
+	if written == 0 {
+		c.Ui.Warn(fmt.Sprintf("No keys exist with prefix: %s", prefix))
+	}
+	if unsaved > 0 {
+		c.Ui.Warn(fmt.Sprintf("Warning! Flags or locks on %d %s were not exported",
+			unsaved, pluralKeys(unsaved)))
+	}
+	return 0
+}
+
+// kvDirFiles maps each key to the path of the file it's written to under the
+// directory. It fails if any key would be written outside the directory, or
+// if a key is also the parent of other keys, since it can't be both a file
+// and a directory.
+func kvDirFiles(dir, prefix string, keys []string) (map[string]string, error) {
+	files := make(map[string]string, len(keys))
+	parents := make(map[string]struct{})
+	for _, key := range keys {
+		rel := strings.TrimSuffix(strings.TrimPrefix(key, prefix), "/")
+		if rel == "" {
+			// The prefix itself, which maps to the directory.
+			files[key] = dir
+			continue
+		}
+
+		segments := strings.Split(rel, "/")
+		for _, seg := range segments {
+			if seg == "" || seg == "." || seg == ".." ||
+				strings.ContainsRune(seg, filepath.Separator) || filepath.VolumeName(seg) != "" {
+				return nil, fmt.Errorf("Key %q can't be written under %s, "+
+					"since it has an empty, \".\", or \"..\" path segment, or one "+
+					"which isn't a valid file name", key, dir)
+			}
+		}
+
+		files[key] = filepath.Join(dir, filepath.FromSlash(rel))
+		if strings.HasSuffix(key, "/") {
+			parents[rel] = struct{}{}
+		}
+		for i := 1; i < len(segments); i++ {
+			parents[strings.Join(segments[:i], "/")] = struct{}{}
+		}
+	}
+
+	for _, key := range keys {
+		if strings.HasSuffix(key, "/") {
+			continue
+		}
+		if _, ok := parents[strings.TrimPrefix(key, prefix)]; ok {
+			return nil, fmt.Errorf("Key %q can't be written as a file, since "+
+				"other keys are stored under it", key)
+		}
+	}
+	return files, nil
+}
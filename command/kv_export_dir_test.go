@@ -0,0 +1,187 @@
+package command
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+)
+
+func TestKVExportDirCommand_implements(t *testing.T) {
+	var _ cli.Command = &KVExportDirCommand{}
+}
+
+func TestKVExportDirCommand_noTabs(t *testing.T) {
+	assertNoTabs(t, new(KVExportDirCommand))
+}
+
+func TestKVExportDirCommand_Run(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	dir, err := ioutil.TempDir("", "consul")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	binary := []byte{0x00, 0xff, 0x10, '\n', 0x00}
+	values := map[string][]byte{
+		"app/a.txt":             []byte("a"),
+		"app/empty":             []byte{},
+		"app/nested/deep/b.bin": binary,
+		"app/nested/dir/":       nil,
+		"app/flagged":           []byte("f"),
+		"other/x":               []byte("x"),
+	}
+	for k, v := range values {
+		pair := &api.KVPair{Key: k, Value: v}
+		if k == "app/flagged" {
+			pair.Flags = 42
+		}
+		if _, err := client.KV().Put(pair, nil); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	// The output directory is created if it's missing.
+	out := filepath.Join(dir, "out", "config")
+	ui := new(cli.MockUi)
+	c := &KVExportDirCommand{Ui: ui}
+	if code := c.Run([]string{"-http-addr=" + srv.httpAddr, "app", out}); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if output := ui.ErrorWriter.String(); !strings.Contains(output, "Flags or locks on 1 key were not exported") {
+		t.Fatalf("bad: %#v", output)
+	}
+
+	var files []string
+	err = filepath.Walk(out, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(out, file)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			rel += "/"
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	expected := []string{
+		"./",
+		"a.txt",
+		"empty",
+		"flagged",
+		"nested/",
+		"nested/deep/",
+		"nested/deep/b.bin",
+		"nested/dir/",
+	}
+	if !reflect.DeepEqual(files, expected) {
+		t.Fatalf("bad: %#v", files)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(out, "nested", "deep", "b.bin"))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !bytes.Equal(data, binary) {
+		t.Fatalf("bad: %q", data)
+	}
+
+	// Importing the files again gives the same values.
+	ui = new(cli.MockUi)
+	imp := &KVImportDirCommand{Ui: ui}
+	if code := imp.Run([]string{"-http-addr=" + srv.httpAddr, out, "copy"}); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	for k, v := range values {
+		if !strings.HasPrefix(k, "app/") || strings.HasSuffix(k, "/") {
+			continue
+		}
+		pair, _, err := client.KV().Get("copy/"+strings.TrimPrefix(k, "app/"), nil)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if pair == nil || !bytes.Equal(pair.Value, v) {
+			t.Fatalf("%s: bad: %#v", k, pair)
+		}
+	}
+}
+
+func TestKVExportDirCommand_Run_unsafe(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	dir, err := ioutil.TempDir("", "consul")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Keys with ".." can't be written with a plain PUT, since the path of
+	// the request would be cleaned, so write them in a transaction.
+	put := func(keys ...string) {
+		var ops api.KVTxnOps
+		for _, k := range keys {
+			ops = append(ops, &api.KVTxnOp{Verb: api.KVSet, Key: k, Value: []byte("x")})
+		}
+		if ok, _, _, err := client.KV().Txn(ops, nil); err != nil || !ok {
+			t.Fatalf("bad: %v %v", ok, err)
+		}
+	}
+	put("dots/ok", "dots/../../escape")
+	put("empty/ok", "empty/a//b")
+	put("dot/./x")
+	put("parent/a", "parent/a/b")
+	put("marker/a", "marker/a/")
+
+	cases := map[string]string{
+		"dots":   `Key "dots/../../escape" can't be written under`,
+		"empty":  `Key "empty/a//b" can't be written under`,
+		"dot":    `Key "dot/./x" can't be written under`,
+		"parent": `Key "parent/a" can't be written as a file`,
+		"marker": `Key "marker/a" can't be written as a file`,
+	}
+
+	for prefix, expected := range cases {
+		out := filepath.Join(dir, prefix)
+		ui := new(cli.MockUi)
+		c := &KVExportDirCommand{Ui: ui}
+		if code := c.Run([]string{"-http-addr=" + srv.httpAddr, prefix, out}); code != 1 {
+			t.Fatalf("%s: bad: %d", prefix, code)
+		}
+		if output := ui.ErrorWriter.String(); !strings.Contains(output, expected) {
+			t.Fatalf("%s: expected %q to contain %q", prefix, output, expected)
+		}
+
+		// Nothing was written, not even the keys which were fine.
+		if _, err := os.Stat(out); !os.IsNotExist(err) {
+			t.Fatalf("%s: bad: %v", prefix, err)
+		}
+	}
+
+	files, err := ioutil.ReadDir(filepath.Dir(dir))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	for _, f := range files {
+		if f.Name() == "escape" {
+			t.Fatalf("bad: key escaped the output directory")
+		}
+	}
+}
@@ -1,9 +1,23 @@
 package command
 
 import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/hashicorp/consul/api"
 	"github.com/mitchellh/cli"
@@ -15,7 +29,8 @@ func TestKVExportCommand_Run(t *testing.T) {
 	waitForLeader(t, srv.httpAddr)
 
 	ui := new(cli.MockUi)
-	c := &KVExportCommand{Ui: ui}
+	stdout := new(bytes.Buffer)
+	c := &KVExportCommand{Ui: ui, testStdout: stdout}
 
 	keys := map[string]string{
 		"foo/a": "a",
@@ -40,7 +55,12 @@ func TestKVExportCommand_Run(t *testing.T) {
 		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
 	}
 
-	output := ui.OutputWriter.String()
+	// The data is written directly, rather than through the Ui.
+	if ui.OutputWriter != nil && ui.OutputWriter.Len() != 0 {
+		t.Fatalf("bad: %#v", ui.OutputWriter.String())
+	}
+
+	output := stdout.String()
 
 	var exported []*kvExportEntry
 	err := json.Unmarshal([]byte(output), &exported)
@@ -58,3 +78,1352 @@ func TestKVExportCommand_Run(t *testing.T) {
 		}
 	}
 }
+
+func TestKVExportCommand_Run_chunked(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	ui := new(cli.MockUi)
+	stdout := new(bytes.Buffer)
+	c := &KVExportCommand{Ui: ui, testStdout: stdout}
+
+	// Write enough keys to span several chunks, with a partial final chunk.
+	count := 2*kvExportChunkSize + 7
+	keys := make(map[string]string, count)
+	for i := 0; i < count; i++ {
+		k := fmt.Sprintf("foo/%04d", i)
+		keys[k] = fmt.Sprintf("value-%d", i)
+		pair := &api.KVPair{Key: k, Flags: uint64(i), Value: []byte(keys[k])}
+		if _, err := client.KV().Put(pair, nil); err != nil {
+			t.Fatalf("err: %#v", err)
+		}
+	}
+
+	args := []string{
+		"-http-addr=" + srv.httpAddr,
+		"foo",
+	}
+
+	code := c.Run(args)
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+
+	var exported []*kvExportEntry
+	if err := json.Unmarshal(stdout.Bytes(), &exported); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if len(exported) != count {
+		t.Fatalf("bad: expected %d, got %d", count, len(exported))
+	}
+
+	for i, entry := range exported {
+		expected := fmt.Sprintf("foo/%04d", i)
+		if entry.Key != expected {
+			t.Fatalf("bad: expected key %s, got %s", expected, entry.Key)
+		}
+		if entry.Flags != uint64(i) {
+			t.Fatalf("bad: expected flags %d, got %d", i, entry.Flags)
+		}
+		if base64.StdEncoding.EncodeToString([]byte(keys[entry.Key])) != entry.Value {
+			t.Fatalf("bad: expected %s, got %s", keys[entry.Key], entry.Value)
+		}
+	}
+}
+
+func TestKVExportCommand_Run_jobs(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	// Write the keys in reverse so the modify indexes don't follow key order.
+	count := 5*kvExportChunkSize + 3
+	for i := count - 1; i >= 0; i-- {
+		pair := &api.KVPair{
+			Key:   fmt.Sprintf("foo/%04d", i),
+			Flags: uint64(i),
+			Value: []byte(fmt.Sprintf("value-%d", i)),
+		}
+		if _, err := client.KV().Put(pair, nil); err != nil {
+			t.Fatalf("err: %#v", err)
+		}
+	}
+
+	export := func(jobs string) string {
+		ui := new(cli.MockUi)
+		stdout := new(bytes.Buffer)
+		c := &KVExportCommand{Ui: ui, testStdout: stdout}
+
+		args := []string{
+			"-http-addr=" + srv.httpAddr,
+			"-jobs=" + jobs,
+			"foo",
+		}
+		code := c.Run(args)
+		if code != 0 {
+			t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+		}
+		return stdout.String()
+	}
+
+	serial := export("1")
+	for _, jobs := range []string{"2", "4", "16"} {
+		if parallel := export(jobs); parallel != serial {
+			t.Fatalf("bad: output with -jobs=%s differs from serial output", jobs)
+		}
+	}
+
+	var exported []*kvExportEntry
+	if err := json.Unmarshal([]byte(serial), &exported); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(exported) != count {
+		t.Fatalf("bad: expected %d, got %d", count, len(exported))
+	}
+	for i, entry := range exported {
+		if expected := fmt.Sprintf("foo/%04d", i); entry.Key != expected {
+			t.Fatalf("bad: expected key %s, got %s", expected, entry.Key)
+		}
+	}
+
+	ui := new(cli.MockUi)
+	stdout := new(bytes.Buffer)
+	c := &KVExportCommand{Ui: ui, testStdout: stdout}
+	if code := c.Run([]string{"-jobs=0"}); code != 1 {
+		t.Fatalf("bad: %d", code)
+	}
+	if !strings.Contains(ui.ErrorWriter.String(), "-jobs must be at least 1") {
+		t.Fatalf("bad: %#v", ui.ErrorWriter.String())
+	}
+}
+
+func TestKVExportCommand_Run_empty(t *testing.T) {
+	srv, _ := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	ui := new(cli.MockUi)
+	stdout := new(bytes.Buffer)
+	c := &KVExportCommand{Ui: ui, testStdout: stdout}
+
+	args := []string{
+		"-http-addr=" + srv.httpAddr,
+		"nope",
+	}
+
+	code := c.Run(args)
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+
+	output := strings.TrimSpace(stdout.String())
+	if output != "[]" {
+		t.Fatalf("bad: %q", output)
+	}
+}
+
+func TestKVExportCommand_Run_formats(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	values := map[string][]byte{
+		"foo/binary":  []byte{0x00, 0xff, 0x10},
+		"foo/empty":   []byte{},
+		"foo/newline": []byte("line1\nline2\n"),
+		"foo/plain":   []byte("hello world"),
+	}
+	for k, v := range values {
+		pair := &api.KVPair{Key: k, Value: v}
+		if _, err := client.KV().Put(pair, nil); err != nil {
+			t.Fatalf("err: %#v", err)
+		}
+	}
+
+	for _, format := range []string{"json", "yaml"} {
+		ui := new(cli.MockUi)
+		stdout := new(bytes.Buffer)
+		c := &KVExportCommand{Ui: ui, testStdout: stdout}
+
+		args := []string{
+			"-http-addr=" + srv.httpAddr,
+			"-format=" + format,
+			"foo",
+		}
+
+		code := c.Run(args)
+		if code != 0 {
+			t.Fatalf("%s: bad: %d. %#v", format, code, ui.ErrorWriter.String())
+		}
+
+		entries, err := decodeKVEntries(format, stdout.String())
+		if err != nil {
+			t.Fatalf("%s: err: %v", format, err)
+		}
+		if len(entries) != len(values) {
+			t.Fatalf("%s: bad: expected %d, got %d", format, len(values), len(entries))
+		}
+		for _, entry := range entries {
+			value, err := base64.StdEncoding.DecodeString(entry.Value)
+			if err != nil {
+				t.Fatalf("%s: err: %v", format, err)
+			}
+			if !bytes.Equal(value, values[entry.Key]) {
+				t.Fatalf("%s: bad: %s: expected %q, got %q", format, entry.Key, values[entry.Key], value)
+			}
+		}
+	}
+
+	ui := new(cli.MockUi)
+	stdout := new(bytes.Buffer)
+	c := &KVExportCommand{Ui: ui, testStdout: stdout}
+
+	args := []string{
+		"-http-addr=" + srv.httpAddr,
+		"-format=flat",
+		"foo",
+	}
+
+	code := c.Run(args)
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+
+	expected := strings.Join([]string{
+		"foo/binary=base64:AP8Q",
+		"foo/empty=",
+		"foo/newline=base64:bGluZTEKbGluZTIK",
+		"foo/plain=hello world",
+		"",
+	}, "\n")
+	if output := stdout.String(); output != expected {
+		t.Fatalf("bad: %q", output)
+	}
+}
+
+func TestKVExportCommand_Run_badFormat(t *testing.T) {
+	ui := new(cli.MockUi)
+	stdout := new(bytes.Buffer)
+	c := &KVExportCommand{Ui: ui, testStdout: stdout}
+
+	code := c.Run([]string{"-format=xml", "foo"})
+	if code != 1 {
+		t.Fatalf("bad: %d", code)
+	}
+	if !strings.Contains(ui.ErrorWriter.String(), "Unsupported format") {
+		t.Fatalf("bad: %#v", ui.ErrorWriter.String())
+	}
+
+	ui = new(cli.MockUi)
+	c = &KVExportCommand{Ui: ui, testStdout: stdout}
+	code = c.Run([]string{"-format=yaml", "-pretty=false", "foo"})
+	if code != 1 {
+		t.Fatalf("bad: %d", code)
+	}
+	if !strings.Contains(ui.ErrorWriter.String(), "only supported with the json format") {
+		t.Fatalf("bad: %#v", ui.ErrorWriter.String())
+	}
+
+	ui = new(cli.MockUi)
+	c = &KVExportCommand{Ui: ui, testStdout: stdout}
+	code = c.Run([]string{"-format=flat", "-flags-as-string", "foo"})
+	if code != 1 {
+		t.Fatalf("bad: %d", code)
+	}
+	if !strings.Contains(ui.ErrorWriter.String(), "only supported with the json and yaml formats") {
+		t.Fatalf("bad: %#v", ui.ErrorWriter.String())
+	}
+
+	ui = new(cli.MockUi)
+	c = &KVExportCommand{Ui: ui, testStdout: stdout}
+	code = c.Run([]string{"-format=flat", "-merge-deletions=old.json", "foo"})
+	if code != 1 {
+		t.Fatalf("bad: %d", code)
+	}
+	if !strings.Contains(ui.ErrorWriter.String(), "-merge-deletions is only supported with the json and yaml formats") {
+		t.Fatalf("bad: %#v", ui.ErrorWriter.String())
+	}
+	if stdout.Len() != 0 {
+		t.Fatalf("bad: %q", stdout.String())
+	}
+}
+
+func TestKVExportCommand_Run_stable(t *testing.T) {
+	values := map[string]string{
+		"foo/a":   "a",
+		"foo/b/c": "c",
+		"foo/b":   "b",
+		"foo/d":   "d",
+		"foo/e":   "e",
+	}
+
+	// Serve the keys, and the values in each transaction, in a different
+	// random order each time.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/kv/foo":
+			var keys []string
+			for k := range values {
+				keys = append(keys, k)
+			}
+			for i, j := range rand.Perm(len(keys)) {
+				keys[i], keys[j] = keys[j], keys[i]
+			}
+			json.NewEncoder(w).Encode(keys)
+		case "/v1/txn":
+			var ops api.TxnOps
+			if err := json.NewDecoder(r.Body).Decode(&ops); err != nil {
+				t.Errorf("err: %v", err)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			var resp api.TxnResponse
+			for _, i := range rand.Perm(len(ops)) {
+				key := ops[i].KV.Key
+				resp.Results = append(resp.Results, &api.TxnResult{
+					KV: &api.KVPair{Key: key, Value: []byte(values[key]), ModifyIndex: 7},
+				})
+			}
+			json.NewEncoder(w).Encode(resp)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	export := func(args ...string) string {
+		ui := new(cli.MockUi)
+		stdout := new(bytes.Buffer)
+		c := &KVExportCommand{Ui: ui, testStdout: stdout}
+
+		args = append([]string{"-http-addr=" + strings.TrimPrefix(srv.URL, "http://")}, args...)
+		if code := c.Run(append(args, "foo")); code != 0 {
+			t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+		}
+		return stdout.String()
+	}
+
+	for _, pretty := range []string{"-pretty=true", "-pretty=false"} {
+		first := export(pretty)
+		for i := 0; i < 5; i++ {
+			if output := export(pretty); output != first {
+				t.Fatalf("%s: bad: output differs:\n%s\n%s", pretty, first, output)
+			}
+		}
+	}
+
+	expected := strings.Join([]string{
+		`[`,
+		`{"key":"foo/a","flags":0,"value":"YQ==","modify_index":7},`,
+		`{"key":"foo/b","flags":0,"value":"Yg==","modify_index":7},`,
+		`{"key":"foo/b/c","flags":0,"value":"Yw==","modify_index":7},`,
+		`{"key":"foo/d","flags":0,"value":"ZA==","modify_index":7},`,
+		`{"key":"foo/e","flags":0,"value":"ZQ==","modify_index":7}`,
+		`]`,
+		``,
+	}, "\n")
+	if output := export("-pretty=false"); output != expected {
+		t.Fatalf("bad: %s", output)
+	}
+
+	// The indented output is the same data, and still has no trailing
+	// whitespace.
+	output := export()
+	var exported []*kvExportEntry
+	if err := json.Unmarshal([]byte(output), &exported); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	compact, err := json.Marshal(exported)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if string(compact) != strings.Replace(strings.TrimSpace(expected), "\n", "", -1) {
+		t.Fatalf("bad: %s", compact)
+	}
+	for _, line := range strings.Split(output, "\n") {
+		if line != strings.TrimRight(line, " \t") {
+			t.Fatalf("bad: %q", line)
+		}
+	}
+}
+
+func TestKVExportCommand_Run_modifyIndex(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	ui := new(cli.MockUi)
+	stdout := new(bytes.Buffer)
+	c := &KVExportCommand{Ui: ui, testStdout: stdout}
+
+	if _, err := client.KV().Put(&api.KVPair{Key: "foo", Value: []byte("bar")}, nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	pair, _, err := client.KV().Get("foo", nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	code := c.Run([]string{"-http-addr=" + srv.httpAddr, "foo"})
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+
+	var exported []*kvExportEntry
+	if err := json.Unmarshal(stdout.Bytes(), &exported); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(exported) != 1 || exported[0].ModifyIndex != pair.ModifyIndex {
+		t.Fatalf("bad: %#v", exported)
+	}
+}
+
+func TestKVExportCommand_Run_exclude(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	for _, k := range []string{
+		"app/api/config",
+		"app/api/secrets/password",
+		"app/web/config",
+		"app/web/secrets/cert",
+		"app/web/secrets/key",
+		"app/worker/config",
+	} {
+		if _, err := client.KV().Put(&api.KVPair{Key: k, Value: []byte("x")}, nil); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	cases := map[string]struct {
+		excludes []string
+		expected []string
+		summary  string
+	}{
+		"glob": {
+			[]string{"app/*/secrets/"},
+			[]string{"app/api/config", "app/web/config", "app/worker/config"},
+			"Exported 3 keys, excluded 3 keys",
+		},
+		"overlapping": {
+			[]string{"app/web/", "app/web/secrets/", "app/w*/config"},
+			[]string{"app/api/config", "app/api/secrets/password"},
+			"Exported 2 keys, excluded 4 keys",
+		},
+		"root": {
+			[]string{"/app"},
+			[]string{},
+			"Exported 0 keys, excluded 6 keys",
+		},
+	}
+
+	for name, tc := range cases {
+		ui := new(cli.MockUi)
+		stdout := new(bytes.Buffer)
+		c := &KVExportCommand{Ui: ui, testStdout: stdout}
+
+		args := []string{"-http-addr=" + srv.httpAddr}
+		for _, e := range tc.excludes {
+			args = append(args, "-exclude="+e)
+		}
+		args = append(args, "app")
+
+		code := c.Run(args)
+		if code != 0 {
+			t.Fatalf("%s: bad: %d. %#v", name, code, ui.ErrorWriter.String())
+		}
+
+		var exported []*kvExportEntry
+		if err := json.Unmarshal(stdout.Bytes(), &exported); err != nil {
+			t.Fatalf("%s: err: %v", name, err)
+		}
+
+		keys := []string{}
+		for _, entry := range exported {
+			keys = append(keys, entry.Key)
+		}
+		if !reflect.DeepEqual(keys, tc.expected) {
+			t.Fatalf("%s: bad: %#v", name, keys)
+		}
+
+		if !strings.Contains(ui.ErrorWriter.String(), tc.summary) {
+			t.Fatalf("%s: bad: %#v", name, ui.ErrorWriter.String())
+		}
+	}
+}
+
+func TestKVExportCommand_Run_mergeDeletions(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	dir, err := ioutil.TempDir("", "kv-export")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The earlier export has a key which was deleted since, one which was
+	// already a tombstone, an excluded key, and a key outside the prefix.
+	old := filepath.Join(dir, "old.yaml")
+	data := `- key: "app/a"
+  flags: 0
+  value: "eA=="
+- key: "app/b"
+  flags: 0
+  value: "eA=="
+- key: "app/c"
+  flags: 0
+  value: ""
+  deleted: true
+- key: "app/secrets/x"
+  flags: 0
+  value: "eA=="
+- key: "other/z"
+  flags: 0
+  value: "eA=="
+`
+	if err := ioutil.WriteFile(old, []byte(data), 0600); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	for _, k := range []string{"app/a", "app/new"} {
+		if _, err := client.KV().Put(&api.KVPair{Key: k, Value: []byte("x")}, nil); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	ui := new(cli.MockUi)
+	stdout := new(bytes.Buffer)
+	c := &KVExportCommand{Ui: ui, testStdout: stdout}
+	args := []string{
+		"-http-addr=" + srv.httpAddr,
+		"-exclude=app/secrets/",
+		"-merge-deletions=" + old,
+		"-pretty=false",
+		"app/",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+
+	var exported []*kvExportEntry
+	if err := json.Unmarshal(stdout.Bytes(), &exported); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	var actual []string
+	for _, entry := range exported {
+		actual = append(actual, fmt.Sprintf("%s %v", entry.Key, entry.Deleted))
+	}
+	expected := []string{"app/a false", "app/b true", "app/c true", "app/new false"}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("bad: %#v", actual)
+	}
+	if !strings.Contains(stdout.String(), `{"key":"app/b","flags":0,"value":"","deleted":true}`) {
+		t.Fatalf("bad: %s", stdout.String())
+	}
+	if !strings.Contains(ui.ErrorWriter.String(), "Marked 2 keys as deleted") {
+		t.Fatalf("bad: %#v", ui.ErrorWriter.String())
+	}
+
+	// A missing file is reported before anything is exported.
+	ui = new(cli.MockUi)
+	c = &KVExportCommand{Ui: ui, testStdout: new(bytes.Buffer)}
+	args = []string{"-http-addr=" + srv.httpAddr, "-merge-deletions=" + filepath.Join(dir, "nope.json"), "app/"}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("bad: %d", code)
+	}
+	if !strings.Contains(ui.ErrorWriter.String(), "File not found") {
+		t.Fatalf("bad: %#v", ui.ErrorWriter.String())
+	}
+}
+
+func TestKVExportCommand_Run_folders(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	// A key ending in "/" with a value isn't a folder, and nor is an empty
+	// key which doesn't end in "/".
+	for k, v := range map[string]string{
+		"app/":        "",
+		"app/db/":     "",
+		"app/db/host": "db1",
+		"app/weird/":  "x",
+		"app/empty":   "",
+	} {
+		if _, err := client.KV().Put(&api.KVPair{Key: k, Value: []byte(v)}, nil); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	export := func(args ...string) ([]string, string) {
+		ui := new(cli.MockUi)
+		stdout := new(bytes.Buffer)
+		c := &KVExportCommand{Ui: ui, testStdout: stdout}
+		if code := c.Run(append([]string{"-http-addr=" + srv.httpAddr}, args...)); code != 0 {
+			t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+		}
+		var exported []*kvExportEntry
+		if err := json.Unmarshal(stdout.Bytes(), &exported); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		var keys []string
+		for _, entry := range exported {
+			keys = append(keys, fmt.Sprintf("%s %v", entry.Key, entry.IsFolder))
+		}
+		return keys, ui.ErrorWriter.String()
+	}
+
+	keys, _ := export("app/")
+	expected := []string{"app/ true", "app/db/ true", "app/db/host false", "app/empty false", "app/weird/ false"}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Fatalf("bad: %#v", keys)
+	}
+
+	keys, stderr := export("-skip-folders", "app/")
+	expected = []string{"app/db/host false", "app/empty false", "app/weird/ false"}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Fatalf("bad: %#v", keys)
+	}
+	if stderr != "Exported 3 keys, skipped 2 folder keys\n" {
+		t.Fatalf("bad: %#v", stderr)
+	}
+
+	keys, stderr = export("-folders-only", "app/")
+	expected = []string{"app/ true", "app/db/ true"}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Fatalf("bad: %#v", keys)
+	}
+	if stderr != "Exported 2 folder keys, skipped 3 other keys\n" {
+		t.Fatalf("bad: %#v", stderr)
+	}
+
+	ui := new(cli.MockUi)
+	c := &KVExportCommand{Ui: ui}
+	if code := c.Run([]string{"-skip-folders", "-folders-only", "app/"}); code != 1 {
+		t.Fatalf("bad: %d", code)
+	}
+	if output := ui.ErrorWriter.String(); !strings.Contains(output, "Cannot specify both -skip-folders and -folders-only") {
+		t.Fatalf("bad: %#v", output)
+	}
+}
+
+func TestKVExportCommand_Run_redact(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	for k, v := range map[string]string{
+		"app/api/config":           "url=db:5432 password=hunter2 user=app",
+		"app/api/secrets/password": "hunter2",
+		"app/web/config":           "port=80",
+		"app/web/secrets/key":      "abc123",
+	} {
+		if _, err := client.KV().Put(&api.KVPair{Key: k, Value: []byte(v)}, nil); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	ui := new(cli.MockUi)
+	stdout := new(bytes.Buffer)
+	c := &KVExportCommand{Ui: ui, testStdout: stdout}
+	code := c.Run([]string{
+		"-http-addr=" + srv.httpAddr,
+		"-redact=app/*/secrets/",
+		`-redact-pattern=password=(\S+)`,
+		"app",
+	})
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+
+	var exported []*kvExportEntry
+	if err := json.Unmarshal(stdout.Bytes(), &exported); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	expected := map[string]struct {
+		value    string
+		redacted bool
+	}{
+		"app/api/config":           {"url=db:5432 password=<redacted> user=app", true},
+		"app/api/secrets/password": {"<redacted>", true},
+		"app/web/config":           {"port=80", false},
+		"app/web/secrets/key":      {"<redacted>", true},
+	}
+	if len(exported) != len(expected) {
+		t.Fatalf("bad: %#v", exported)
+	}
+	for _, entry := range exported {
+		value, err := base64.StdEncoding.DecodeString(entry.Value)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		want := expected[entry.Key]
+		if string(value) != want.value || entry.Redacted != want.redacted {
+			t.Fatalf("%s: bad: %q %v", entry.Key, value, entry.Redacted)
+		}
+	}
+	if !strings.Contains(ui.ErrorWriter.String(), "Redacted the values of 3 keys") {
+		t.Fatalf("bad: %#v", ui.ErrorWriter.String())
+	}
+	if strings.Contains(stdout.String(), "hunter2") {
+		t.Fatalf("bad: %s", stdout.String())
+	}
+
+	// Bad patterns are rejected before anything is exported.
+	for _, arg := range []string{"-redact=", "-redact-pattern=("} {
+		ui := new(cli.MockUi)
+		stdout := new(bytes.Buffer)
+		c := &KVExportCommand{Ui: ui, testStdout: stdout}
+		if code := c.Run([]string{"-http-addr=" + srv.httpAddr, arg, "app"}); code != 1 {
+			t.Fatalf("%s: bad: %d", arg, code)
+		}
+		if !strings.Contains(ui.ErrorWriter.String(), "-redact") || stdout.Len() != 0 {
+			t.Fatalf("%s: bad: %#v", arg, ui.ErrorWriter.String())
+		}
+	}
+}
+
+func TestKVExportCommand_Run_flagsFilter(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	for _, pair := range []*api.KVPair{
+		{Key: "app/a", Value: []byte("x"), Flags: 42},
+		{Key: "app/b", Value: []byte("x"), Flags: 42 | 1<<8},
+		{Key: "app/c", Value: []byte("x"), Flags: math.MaxUint64},
+		{Key: "app/d", Value: []byte("x")},
+	} {
+		if _, err := client.KV().Put(pair, nil); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	cases := map[string]struct {
+		args     []string
+		expected []string
+		summary  string
+	}{
+		"exact": {
+			[]string{"-flags=42"},
+			[]string{"app/a"},
+			"Exported 1 key with flags == 42, skipped 3 with other flags",
+		},
+		"masked": {
+			[]string{"-flags=42", "-flags-mask=0xff"},
+			[]string{"app/a", "app/b"},
+			"Exported 2 keys with flags & 0xff == 42, skipped 2 with other flags",
+		},
+		"max": {
+			[]string{"-flags=18446744073709551615"},
+			[]string{"app/c"},
+			"Exported 1 key with flags == 18446744073709551615, skipped 3",
+		},
+		"zero mask": {
+			[]string{"-flags-mask=0"},
+			[]string{"app/a", "app/b", "app/c", "app/d"},
+			"Exported 4 keys with flags & 0x0 == 0, skipped 0",
+		},
+	}
+
+	for name, tc := range cases {
+		ui := new(cli.MockUi)
+		stdout := new(bytes.Buffer)
+		c := &KVExportCommand{Ui: ui, testStdout: stdout}
+
+		args := append([]string{"-http-addr=" + srv.httpAddr}, tc.args...)
+		code := c.Run(append(args, "app/"))
+		if code != 0 {
+			t.Fatalf("%s: bad: %d. %#v", name, code, ui.ErrorWriter.String())
+		}
+
+		var exported []*kvExportEntry
+		if err := json.Unmarshal(stdout.Bytes(), &exported); err != nil {
+			t.Fatalf("%s: err: %v", name, err)
+		}
+
+		keys := []string{}
+		for _, entry := range exported {
+			keys = append(keys, entry.Key)
+		}
+		if !reflect.DeepEqual(keys, tc.expected) {
+			t.Fatalf("%s: bad: %#v", name, keys)
+		}
+
+		if !strings.Contains(ui.ErrorWriter.String(), tc.summary) {
+			t.Fatalf("%s: bad: %#v", name, ui.ErrorWriter.String())
+		}
+	}
+
+	// No entry could match flags with bits outside of the mask.
+	ui := new(cli.MockUi)
+	c := &KVExportCommand{Ui: ui, testStdout: new(bytes.Buffer)}
+	code := c.Run([]string{"-http-addr=" + srv.httpAddr, "-flags=0x100", "-flags-mask=0xff", "app/"})
+	if code != 1 || !strings.Contains(ui.ErrorWriter.String(), "has bits outside of -flags-mask") {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+}
+
+func TestKVExportCommand_Run_locked(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	session, _, err := client.Session().Create(&api.SessionEntry{}, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := client.KV().Put(&api.KVPair{Key: "foo/a", Value: []byte("a")}, nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	ok, _, err := client.KV().Acquire(&api.KVPair{Key: "foo/b", Value: []byte("b"), Session: session}, nil)
+	if err != nil || !ok {
+		t.Fatalf("err: %v", err)
+	}
+
+	cases := map[string]struct {
+		args     []string
+		sessions []string
+		warnings string
+	}{
+		"default": {
+			nil,
+			[]string{"", session},
+			"Locked: foo/b\nWarning! Exported 1 key held by a lock, which won't be locked on import\n",
+		},
+		"-include-locked": {
+			[]string{"-include-locked"},
+			[]string{"", session},
+			"",
+		},
+		"-skip-locked": {
+			[]string{"-skip-locked"},
+			[]string{""},
+			"Skipped 1 locked key\n",
+		},
+	}
+
+	for name, tc := range cases {
+		ui := new(cli.MockUi)
+		stdout := new(bytes.Buffer)
+		c := &KVExportCommand{Ui: ui, testStdout: stdout}
+
+		args := append([]string{"-http-addr=" + srv.httpAddr}, tc.args...)
+		code := c.Run(append(args, "foo"))
+		if code != 0 {
+			t.Fatalf("%s: bad: %d. %#v", name, code, ui.ErrorWriter.String())
+		}
+
+		var exported []*kvExportEntry
+		if err := json.Unmarshal(stdout.Bytes(), &exported); err != nil {
+			t.Fatalf("%s: err: %v", name, err)
+		}
+		var sessions []string
+		for _, entry := range exported {
+			sessions = append(sessions, entry.Session)
+		}
+		if !reflect.DeepEqual(sessions, tc.sessions) {
+			t.Fatalf("%s: bad: %#v", name, sessions)
+		}
+		var warnings string
+		if ui.ErrorWriter != nil {
+			warnings = ui.ErrorWriter.String()
+		}
+		if warnings != tc.warnings {
+			t.Fatalf("%s: bad: %q", name, warnings)
+		}
+	}
+
+	ui := new(cli.MockUi)
+	stdout := new(bytes.Buffer)
+	c := &KVExportCommand{Ui: ui, testStdout: stdout}
+	code := c.Run([]string{"-http-addr=" + srv.httpAddr, "-include-locked", "-skip-locked", "foo"})
+	if code != 1 || !strings.Contains(ui.ErrorWriter.String(), "Cannot specify both") {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+}
+
+func TestKVExportCommand_Run_output(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	for _, pair := range []*api.KVPair{
+		{Key: "foo/a", Value: []byte("a")},
+		{Key: "foo/bin", Flags: 42, Value: []byte{0x1f, 0x8b, 0, 0xff, '\r', '\n'}},
+	} {
+		if _, err := client.KV().Put(pair, nil); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "kv-export")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	export := func(args ...string) *KVExportCommand {
+		ui := new(cli.MockUi)
+		c := &KVExportCommand{Ui: ui, testStdout: new(bytes.Buffer)}
+		code := c.Run(append([]string{"-http-addr=" + srv.httpAddr}, args...))
+		if code != 0 {
+			t.Fatalf("%v: bad: %d. %#v", args, code, ui.ErrorWriter.String())
+		}
+		return c
+	}
+	gunzip := func(data []byte) []byte {
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		out, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		return out
+	}
+	readFile := func(name string) []byte {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		return data
+	}
+
+	expected := export("foo").testStdout.(*bytes.Buffer).Bytes()
+
+	export("-output="+filepath.Join(dir, "plain.json"), "foo")
+	if actual := readFile("plain.json"); !bytes.Equal(actual, expected) {
+		t.Fatalf("bad: %q", actual)
+	}
+
+	// The extension is added for compressed files, unless it's there.
+	export("-gzip", "-output="+filepath.Join(dir, "export.json"), "foo")
+	if actual := gunzip(readFile("export.json.gz")); !bytes.Equal(actual, expected) {
+		t.Fatalf("bad: %q", actual)
+	}
+	export("-gzip", "-output="+filepath.Join(dir, "other.gz"), "foo")
+	if actual := gunzip(readFile("other.gz")); !bytes.Equal(actual, expected) {
+		t.Fatalf("bad: %q", actual)
+	}
+
+	c := export("-gzip", "foo")
+	if actual := gunzip(c.testStdout.(*bytes.Buffer).Bytes()); !bytes.Equal(actual, expected) {
+		t.Fatalf("bad: %q", actual)
+	}
+
+	// No temporary files are left behind.
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(files) != 3 {
+		t.Fatalf("bad: %v", files)
+	}
+
+	ui := new(cli.MockUi)
+	c = &KVExportCommand{Ui: ui}
+	code := c.Run([]string{"-http-addr=" + srv.httpAddr, "-output=" + filepath.Join(dir, "nope", "out.json"), "foo"})
+	if code != 1 {
+		t.Fatalf("bad: %d", code)
+	}
+
+	// Compressed exports can be imported from a file or stdin.
+	ui = new(cli.MockUi)
+	i := &KVImportCommand{Ui: ui}
+	code = i.Run([]string{"-http-addr=" + srv.httpAddr, "-strip-prefix=foo", "-prefix=file", "-verify",
+		"-file=" + filepath.Join(dir, "export.json.gz")})
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+
+	ui = new(cli.MockUi)
+	i = &KVImportCommand{Ui: ui, testStdin: bytes.NewReader(readFile("export.json.gz"))}
+	code = i.Run([]string{"-http-addr=" + srv.httpAddr, "-strip-prefix=foo", "-prefix=stdin", "-verify", "-"})
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+
+	for _, prefix := range []string{"file", "stdin"} {
+		pair, _, err := client.KV().Get(prefix+"/bin", nil)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if pair == nil || pair.Flags != 42 || !bytes.Equal(pair.Value, []byte{0x1f, 0x8b, 0, 0xff, '\r', '\n'}) {
+			t.Fatalf("bad: %#v", pair)
+		}
+	}
+}
+
+func TestKVExportCommand_Run_checksum(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	for _, pair := range []*api.KVPair{
+		{Key: "foo/a", Value: []byte("a")},
+		{Key: "foo/b", Flags: 42, Value: []byte("b")},
+	} {
+		if _, err := client.KV().Put(pair, nil); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "kv-export")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	export := func(args ...string) []byte {
+		ui := new(cli.MockUi)
+		stdout := new(bytes.Buffer)
+		c := &KVExportCommand{Ui: ui, testStdout: stdout}
+		code := c.Run(append([]string{"-http-addr=" + srv.httpAddr}, args...))
+		if code != 0 {
+			t.Fatalf("%v: bad: %d. %#v", args, code, ui.ErrorWriter.String())
+		}
+		return stdout.Bytes()
+	}
+	readFile := func(name string) []byte {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		return data
+	}
+
+	// The sum is of the compressed file, named as it was written.
+	output := filepath.Join(dir, "export.json.gz")
+	export("-gzip", "-output="+output, "-checksum="+filepath.Join(dir, "file.sha256"), "foo")
+	sum := sha256.Sum256(readFile("export.json.gz"))
+	expected := fmt.Sprintf("%x  %s\n", sum, output)
+	if actual := string(readFile("file.sha256")); actual != expected {
+		t.Fatalf("bad: %q", actual)
+	}
+
+	// The sum of what's written to stdout is named "-".
+	data := export("-checksum="+filepath.Join(dir, "stdout.sha256"), "foo")
+	sum = sha256.Sum256(data)
+	expected = fmt.Sprintf("%x  -\n", sum)
+	if actual := string(readFile("stdout.sha256")); actual != expected {
+		t.Fatalf("bad: %q", actual)
+	}
+
+	// The export can be imported after checking it against the sum.
+	for _, args := range [][]string{
+		{"-prefix=file", "-checksum=" + filepath.Join(dir, "file.sha256"), "@" + output},
+		{"-prefix=stdin", "-checksum=" + filepath.Join(dir, "stdout.sha256"), "-"},
+	} {
+		ui := new(cli.MockUi)
+		i := &KVImportCommand{Ui: ui, testStdin: bytes.NewReader(data)}
+		code := i.Run(append([]string{"-http-addr=" + srv.httpAddr, "-strip-prefix=foo", "-verify"}, args...))
+		if code != 0 {
+			t.Fatalf("%v: bad: %d. %#v", args, code, ui.ErrorWriter.String())
+		}
+	}
+
+	// Nothing is imported if the data doesn't match.
+	ui := new(cli.MockUi)
+	i := &KVImportCommand{Ui: ui, testStdin: bytes.NewReader(append(data, ' '))}
+	code := i.Run([]string{"-http-addr=" + srv.httpAddr, "-strip-prefix=foo", "-prefix=bad",
+		"-checksum=" + filepath.Join(dir, "stdout.sha256"), "-"})
+	if code != 1 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if errs := ui.ErrorWriter.String(); !strings.Contains(errs, "Checksum mismatch") {
+		t.Fatalf("bad: %#v", errs)
+	}
+	pairs, _, err := client.KV().List("bad", nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(pairs) != 0 {
+		t.Fatalf("bad: %#v", pairs)
+	}
+
+	// The checksum can't overwrite the export.
+	ui = new(cli.MockUi)
+	c := &KVExportCommand{Ui: ui}
+	code = c.Run([]string{"-http-addr=" + srv.httpAddr, "-output=" + output, "-checksum=" + output, "foo"})
+	if code != 1 {
+		t.Fatalf("bad: %d", code)
+	}
+	if errs := ui.ErrorWriter.String(); !strings.Contains(errs, "-checksum must be a different file from -output") {
+		t.Fatalf("bad: %#v", errs)
+	}
+}
+
+func TestKVExportCommand_Run_waitForChange(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	put := func(key string) {
+		if _, err := client.KV().Put(&api.KVPair{Key: key, Value: []byte("v")}, nil); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	put("app/a")
+
+	run := func(args ...string) (int, string, string, uint64) {
+		ui := new(cli.MockUi)
+		stdout := new(bytes.Buffer)
+		c := &KVExportCommand{Ui: ui, testStdout: stdout}
+		args = append([]string{"-http-addr=" + srv.httpAddr, "-wait-for-change"}, args...)
+		code := c.Run(append(args, "app"))
+
+		var index uint64
+		errors := ui.ErrorWriter.String()
+		if i := strings.Index(errors, "Index: "); i >= 0 {
+			fmt.Sscanf(errors[i:], "Index: %d", &index)
+		}
+		return code, stdout.String(), errors, index
+	}
+
+	// The first export prints the index to pass in next time.
+	code, output, errors, index := run()
+	if code != 0 || index == 0 || !strings.Contains(output, `"app/a"`) {
+		t.Fatalf("bad: %d %q %q", code, output, errors)
+	}
+
+	// A change outside the tree doesn't count.
+	put("other/a")
+	since := fmt.Sprintf("-since-index=%d", index)
+	code, output, errors, newIndex := run(since, "-wait=200ms")
+	if code != 2 || output != "" || newIndex != index || !strings.Contains(errors, "No change after 200ms") {
+		t.Fatalf("bad: %d %q %q", code, output, errors)
+	}
+
+	// A change while waiting is exported as soon as it's made.
+	time.AfterFunc(200*time.Millisecond, func() { put("app/b") })
+	start := time.Now()
+	code, output, errors, newIndex = run(since, "-wait=10s")
+	if code != 0 || newIndex <= index || !strings.Contains(output, `"app/b"`) {
+		t.Fatalf("bad: %d %q %q", code, output, errors)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("took %s", elapsed)
+	}
+
+	// A change before the command runs is exported right away.
+	put("app/c")
+	code, output, errors, index = run(fmt.Sprintf("-since-index=%d", newIndex), "-wait=10s")
+	if code != 0 || index <= newIndex || !strings.Contains(output, `"app/c"`) {
+		t.Fatalf("bad: %d %q %q", code, output, errors)
+	}
+
+	// An index which has gone backwards, such as after a restore, gives a
+	// full export.
+	code, output, errors, newIndex = run(fmt.Sprintf("-since-index=%d", index+1000), "-wait=10s")
+	if code != 0 || newIndex != index || !strings.Contains(output, `"app/c"`) ||
+		!strings.Contains(errors, "The index went backwards") {
+		t.Fatalf("bad: %d %q %q", code, output, errors)
+	}
+}
+
+func TestKVExportCommand_Run_waitForChangeValidation(t *testing.T) {
+	cases := map[string]struct {
+		args   []string
+		output string
+	}{
+		"since-index without wait-for-change": {
+			[]string{"-since-index=10", "foo"},
+			"Can only specify -since-index with -wait-for-change",
+		},
+		"bad wait": {
+			[]string{"-wait-for-change", "-wait=0s", "foo"},
+			"-wait must be positive",
+		},
+	}
+
+	for name, tc := range cases {
+		ui := new(cli.MockUi)
+		c := &KVExportCommand{Ui: ui, testStdout: new(bytes.Buffer)}
+		if code := c.Run(tc.args); code != 1 {
+			t.Fatalf("%s: bad: %d", name, code)
+		}
+		if output := ui.ErrorWriter.String(); !strings.Contains(output, tc.output) {
+			t.Fatalf("%s: expected %q to contain %q", name, output, tc.output)
+		}
+	}
+}
+
+func TestKVExportCommand_Run_flagsRoundTrip(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	pair := &api.KVPair{Key: "src/max", Flags: math.MaxUint64, Value: []byte("x")}
+	if _, err := client.KV().Put(pair, nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	cases := map[string]struct {
+		args  []string
+		flags string
+	}{
+		"json":        {[]string{"-pretty=false"}, `"flags":18446744073709551615,`},
+		"json string": {[]string{"-pretty=false", "-flags-as-string"}, `"flags":"18446744073709551615",`},
+		"yaml":        {[]string{"-format=yaml"}, "flags: 18446744073709551615\n"},
+		"yaml string": {[]string{"-format=yaml", "-flags-as-string"}, "flags: \"18446744073709551615\"\n"},
+	}
+
+	for name, tc := range cases {
+		ui := new(cli.MockUi)
+		stdout := new(bytes.Buffer)
+		export := &KVExportCommand{Ui: ui, testStdout: stdout}
+		args := append([]string{"-http-addr=" + srv.httpAddr}, tc.args...)
+		if code := export.Run(append(args, "src/")); code != 0 {
+			t.Fatalf("%s: bad: %d. %#v", name, code, ui.ErrorWriter.String())
+		}
+		if !strings.Contains(stdout.String(), tc.flags) {
+			t.Fatalf("%s: bad: %s", name, stdout.String())
+		}
+
+		format := "json"
+		if strings.HasPrefix(name, "yaml") {
+			format = "yaml"
+		}
+		ui = new(cli.MockUi)
+		imp := &KVImportCommand{Ui: ui, testStdin: stdout}
+		code := imp.Run([]string{
+			"-http-addr=" + srv.httpAddr,
+			"-format=" + format,
+			"-strip-prefix=src/",
+			"-prefix=dst/",
+			"-",
+		})
+		if code != 0 {
+			t.Fatalf("%s: bad: %d. %#v", name, code, ui.ErrorWriter.String())
+		}
+
+		imported, _, err := client.KV().Get("dst/max", nil)
+		if err != nil {
+			t.Fatalf("%s: err: %v", name, err)
+		}
+		if imported == nil || imported.Flags != math.MaxUint64 {
+			t.Fatalf("%s: bad: %#v", name, imported)
+		}
+		if _, err := client.KV().Delete("dst/max", nil); err != nil {
+			t.Fatalf("%s: err: %v", name, err)
+		}
+	}
+}
+
+func TestKVExportEntry_UnmarshalJSON(t *testing.T) {
+	cases := map[string]uint64{
+		`{"key":"a","flags":18446744073709551615,"value":""}`:   math.MaxUint64,
+		`{"key":"a","flags":"18446744073709551615","value":""}`: math.MaxUint64,
+		`{"key":"a","flags":42}`:                                42,
+		`{"key":"a","flags":null}`:                              0,
+		`{"key":"a"}`:                                           0,
+	}
+	for data, expected := range cases {
+		var entry kvExportEntry
+		if err := json.Unmarshal([]byte(data), &entry); err != nil {
+			t.Fatalf("%s: err: %v", data, err)
+		}
+		if entry.Key != "a" || entry.Flags != expected {
+			t.Fatalf("%s: bad: %#v", data, entry)
+		}
+	}
+
+	// Flags which were rounded by a tool that reads numbers as doubles are
+	// rejected instead of being written wrong.
+	for _, flags := range []string{
+		`18446744073709552000`,
+		`1.8446744073709552e+19`,
+		`"-1"`,
+		`"abc"`,
+		`true`,
+	} {
+		var entry kvExportEntry
+		err := json.Unmarshal([]byte(`{"key":"a","flags":`+flags+`}`), &entry)
+		if err == nil || !strings.Contains(err.Error(), `invalid flags`) {
+			t.Fatalf("%s: bad: %v", flags, err)
+		}
+	}
+}
+
+func TestKVExportCommand_Run_cancel(t *testing.T) {
+	var keys []string
+	for i := 0; i < 3*kvExportChunkSize; i++ {
+		keys = append(keys, fmt.Sprintf("foo/%04d", i))
+	}
+
+	// The fake agent serves the first chunk, then gets interrupted while
+	// serving the second, which never finishes unless it's cancelled.
+	shutdownCh := make(chan struct{})
+	var txns int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/kv/foo":
+			json.NewEncoder(w).Encode(keys)
+		case "/v1/txn":
+			if txns++; txns > 1 {
+				// The request is only seen to be cancelled once its body
+				// has been read to the end.
+				ioutil.ReadAll(r.Body)
+				close(shutdownCh)
+				<-r.Context().Done()
+				return
+			}
+			var ops api.TxnOps
+			if err := json.NewDecoder(r.Body).Decode(&ops); err != nil {
+				t.Errorf("err: %v", err)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			var resp api.TxnResponse
+			for _, op := range ops {
+				resp.Results = append(resp.Results, &api.TxnResult{
+					KV: &api.KVPair{Key: op.KV.Key, Value: []byte("x")},
+				})
+			}
+			json.NewEncoder(w).Encode(resp)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "kv-export")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	output := filepath.Join(dir, "export.json")
+
+	ui := new(cli.MockUi)
+	c := &KVExportCommand{Ui: ui, ShutdownCh: shutdownCh}
+	args := []string{
+		"-http-addr=" + strings.TrimPrefix(srv.URL, "http://"),
+		"-jobs=1",
+		"-output=" + output,
+		"foo",
+	}
+	if code := c.Run(args); code != exitCancelled {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+
+	expected := fmt.Sprintf("Cancelled after %d of %d keys (", kvExportChunkSize, len(keys))
+	if errs := ui.ErrorWriter.String(); !strings.Contains(errs, expected) ||
+		!strings.Contains(errs, output+" was not written") {
+		t.Fatalf("bad: %#v", errs)
+	}
+
+	// Neither the output nor the temporary file are left behind.
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(files) != 0 {
+		t.Fatalf("bad: %#v", files)
+	}
+}
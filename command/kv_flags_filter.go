@@ -0,0 +1,104 @@
+package command
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// kvFlagsFilterOptsText describes the flags registered by newKVFlagsFilter.
+var kvFlagsFilterOptsText = strings.TrimSpace(`
+KV Flags Filter Options:
+
+  -flags=<uint>           Only include the entries whose flags, after masking
+                          with -flags-mask, equal this value, so an entry is
+                          included when (flags & mask) == value. This can be
+                          given in decimal, or in hex with a "0x" prefix.
+                          Without -flags-mask, the flags must match exactly.
+
+  -flags-mask=<uint>      Mask to apply to the flags of each entry before
+                          comparing them with -flags. Only the bits set in the
+                          mask are compared, and -flags can't have any other
+                          bits set. With a mask but no -flags, the entries
+                          with none of the masked bits set are included. The
+                          default value is 0xffffffffffffffff, every bit.
+`)
+
+// kvFlagsValue is a flag holding a uint64, which records whether it was set.
+type kvFlagsValue struct {
+	value uint64
+	set   bool
+}
+
+func (v *kvFlagsValue) String() string {
+	return strconv.FormatUint(v.value, 10)
+}
+
+func (v *kvFlagsValue) Set(s string) error {
+	n, err := strconv.ParseUint(s, 0, 64)
+	if err != nil {
+		return err
+	}
+	v.value, v.set = n, true
+	return nil
+}
+
+// kvFlagsFilter holds the flags which pick out KV entries by their flags,
+// such as the entries tagged for one team.
+type kvFlagsFilter struct {
+	flags kvFlagsValue
+	mask  kvFlagsValue
+}
+
+// newKVFlagsFilter registers the filter flags on the given flagset,
+// returning the filter they will be parsed into.
+func newKVFlagsFilter(f *flag.FlagSet) *kvFlagsFilter {
+	k := &kvFlagsFilter{mask: kvFlagsValue{value: ^uint64(0)}}
+	f.Var(&k.flags, "flags", "")
+	f.Var(&k.mask, "flags-mask", "")
+	return k
+}
+
+// enabled returns true if either of the flags was given.
+func (k *kvFlagsFilter) enabled() bool {
+	return k.flags.set || k.mask.set
+}
+
+// validate returns an error if no entry could ever match, since -flags has
+// bits set outside of -flags-mask.
+func (k *kvFlagsFilter) validate() error {
+	if k.flags.value&^k.mask.value != 0 {
+		return fmt.Errorf("-flags=%#x has bits outside of -flags-mask=%#x, so no entry can match",
+			k.flags.value, k.mask.value)
+	}
+	return nil
+}
+
+// match returns true if the entry with the given flags passes the filter,
+// which every entry does if neither flag was given.
+func (k *kvFlagsFilter) match(flags uint64) bool {
+	return !k.enabled() || flags&k.mask.value == k.flags.value
+}
+
+// filter returns the pairs which pass the filter, in the same order, along
+// with the number left out. The given slice is reused.
+func (k *kvFlagsFilter) filter(pairs api.KVPairs) (api.KVPairs, int) {
+	kept := pairs[:0]
+	for _, pair := range pairs {
+		if k.match(pair.Flags) {
+			kept = append(kept, pair)
+		}
+	}
+	return kept, len(pairs) - len(kept)
+}
+
+// String describes the filter for messages, such as "flags & 0xff == 42".
+func (k *kvFlagsFilter) String() string {
+	if k.mask.value == ^uint64(0) {
+		return fmt.Sprintf("flags == %d", k.flags.value)
+	}
+	return fmt.Sprintf("flags & %#x == %d", k.mask.value, k.flags.value)
+}
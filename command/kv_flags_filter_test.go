@@ -0,0 +1,152 @@
+package command
+
+import (
+	"flag"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestKVFlagsFilter(t *testing.T) {
+	const max = ^uint64(0)
+	cases := []struct {
+		args    []string
+		err     string
+		matches []uint64
+		misses  []uint64
+		desc    string
+	}{
+		{
+			args:    nil,
+			matches: []uint64{0, 1, 42, max},
+			desc:    "flags == 0",
+		},
+		{
+			args:    []string{"-flags=0"},
+			matches: []uint64{0},
+			misses:  []uint64{1, max},
+			desc:    "flags == 0",
+		},
+		{
+			args:    []string{"-flags=42"},
+			matches: []uint64{42},
+			misses:  []uint64{0, 43, 42 | 1<<8, max},
+			desc:    "flags == 42",
+		},
+		{
+			args:    []string{"-flags=42", "-flags-mask=0xff"},
+			matches: []uint64{42, 42 | 1<<8, 42 | 1<<63},
+			misses:  []uint64{0, 43, max},
+			desc:    "flags & 0xff == 42",
+		},
+		{
+			args:    []string{"-flags=18446744073709551615"},
+			matches: []uint64{max},
+			misses:  []uint64{0, max - 1},
+			desc:    "flags == 18446744073709551615",
+		},
+		{
+			args:    []string{"-flags=0x8000000000000000", "-flags-mask=0x8000000000000000"},
+			matches: []uint64{1 << 63, max},
+			misses:  []uint64{0, 1<<63 - 1},
+			desc:    "flags & 0x8000000000000000 == 9223372036854775808",
+		},
+		{
+			// Above 2^53, where a float64 can't tell these apart.
+			args:    []string{"-flags=9007199254740993"},
+			matches: []uint64{1<<53 + 1},
+			misses:  []uint64{1 << 53, 1<<53 + 2},
+			desc:    "flags == 9007199254740993",
+		},
+		{
+			// With only a mask, the entries with none of its bits set match.
+			args:    []string{"-flags-mask=0x3"},
+			matches: []uint64{0, 4, max &^ 3},
+			misses:  []uint64{1, 2, 3, max},
+			desc:    "flags & 0x3 == 0",
+		},
+		{
+			// A zero mask matches everything.
+			args:    []string{"-flags-mask=0"},
+			matches: []uint64{0, 1, max},
+			desc:    "flags & 0x0 == 0",
+		},
+		{
+			args: []string{"-flags=0x100", "-flags-mask=0xff"},
+			err:  "has bits outside of -flags-mask",
+		},
+		{
+			args: []string{"-flags=1", "-flags-mask=0"},
+			err:  "has bits outside of -flags-mask",
+		},
+		{
+			args: []string{"-flags=18446744073709551616"},
+			err:  "value out of range",
+		},
+		{
+			args: []string{"-flags=-1"},
+			err:  "invalid syntax",
+		},
+		{
+			args: []string{"-flags-mask=team-a"},
+			err:  "invalid syntax",
+		},
+	}
+
+	for _, tc := range cases {
+		f := flag.NewFlagSet("test", flag.ContinueOnError)
+		f.SetOutput(ioutil.Discard)
+		k := newKVFlagsFilter(f)
+		err := f.Parse(tc.args)
+		if err == nil {
+			err = k.validate()
+		}
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("%v: bad: %v", tc.args, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%v: err: %v", tc.args, err)
+		}
+
+		if k.enabled() != (len(tc.args) > 0) {
+			t.Fatalf("%v: bad: %v", tc.args, k.enabled())
+		}
+		for _, flags := range tc.matches {
+			if !k.match(flags) {
+				t.Fatalf("%v: should match %d", tc.args, flags)
+			}
+		}
+		for _, flags := range tc.misses {
+			if k.match(flags) {
+				t.Fatalf("%v: should not match %d", tc.args, flags)
+			}
+		}
+		if desc := k.String(); desc != tc.desc {
+			t.Fatalf("%v: bad: %q", tc.args, desc)
+		}
+	}
+}
+
+func TestKVFlagsFilter_filter(t *testing.T) {
+	f := flag.NewFlagSet("test", flag.ContinueOnError)
+	k := newKVFlagsFilter(f)
+	if err := f.Parse([]string{"-flags=1", "-flags-mask=1"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	pairs := api.KVPairs{
+		{Key: "a", Flags: 1},
+		{Key: "b", Flags: 2},
+		{Key: "c", Flags: 3},
+		{Key: "d", Flags: 0},
+	}
+	kept, skipped := k.filter(pairs)
+	if skipped != 2 || len(kept) != 2 || kept[0].Key != "a" || kept[1].Key != "c" {
+		t.Fatalf("bad: %d %#v", skipped, kept)
+	}
+}
@@ -0,0 +1,628 @@
+package command
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// kvEntryWriter writes exported entries in one of the supported output
+// formats. Entries are written as they arrive so that large trees never
+// need to be held in memory.
+type kvEntryWriter interface {
+	// WriteEntry writes a single entry.
+	WriteEntry(entry *kvExportEntry) error
+
+	// Flush completes the output once all the entries have been written.
+	Flush() error
+}
+
+// newKVEntryWriter returns a writer for the given format which emits its
+// output one line at a time through the out function. Formats other than json
+// are always written the same way, so pretty only applies to json. With
+// flagsAsString, the flags are written as a string instead of a number, which
+// the flat format doesn't include at all.
+func newKVEntryWriter(format string, pretty, flagsAsString bool, out func(string)) (kvEntryWriter, error) {
+	if !pretty && format != "json" {
+		return nil, fmt.Errorf("-pretty=false is only supported with the json format")
+	}
+	if flagsAsString && format == "flat" {
+		return nil, fmt.Errorf("-flags-as-string is only supported with the json and yaml formats")
+	}
+
+	switch format {
+	case "json":
+		return &jsonEntryWriter{out: out, compact: !pretty, flagsAsString: flagsAsString}, nil
+	case "yaml":
+		return &yamlEntryWriter{out: out, flagsAsString: flagsAsString}, nil
+	case "flat":
+		return &flatEntryWriter{out: out}, nil
+	default:
+		return nil, fmt.Errorf("Unsupported format %q (expected json, yaml, or flat)", format)
+	}
+}
+
+// jsonEntryWriter writes entries as an indented JSON array, matching the
+// layout json.MarshalIndent would produce for the whole array. In compact
+// mode, each entry is written on a line of its own with no indentation.
+type jsonEntryWriter struct {
+	out           func(string)
+	compact       bool
+	flagsAsString bool
+	pending       *kvExportEntry
+}
+
+func (w *jsonEntryWriter) WriteEntry(entry *kvExportEntry) error {
+	// Entries are written one behind so we know whether a trailing comma is
+	// needed without buffering the whole tree.
+	if w.pending == nil {
+		w.out("[")
+	} else if err := w.write(w.pending, true); err != nil {
+		return err
+	}
+	w.pending = entry
+	return nil
+}
+
+func (w *jsonEntryWriter) Flush() error {
+	if w.pending == nil {
+		w.out("[]")
+		return nil
+	}
+	if err := w.write(w.pending, false); err != nil {
+		return err
+	}
+	w.out("]")
+	return nil
+}
+
+func (w *jsonEntryWriter) write(entry *kvExportEntry, more bool) error {
+	var v interface{} = entry
+	if w.flagsAsString {
+		v = (*kvExportEntryStringFlags)(entry)
+	}
+
+	var line string
+	if w.compact {
+		marshaled, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		line = string(marshaled)
+	} else {
+		marshaled, err := json.MarshalIndent(v, "\t", "\t")
+		if err != nil {
+			return err
+		}
+		line = "\t" + string(marshaled)
+	}
+
+	if more {
+		line += ","
+	}
+	w.out(line)
+	return nil
+}
+
+// yamlEntryWriter writes entries as a YAML sequence of mappings with the
+// same fields as the JSON format. Strings are written as double-quoted
+// scalars, which are valid YAML and safe for any key.
+type yamlEntryWriter struct {
+	out           func(string)
+	flagsAsString bool
+	written       bool
+}
+
+func (w *yamlEntryWriter) WriteEntry(entry *kvExportEntry) error {
+	key, err := json.Marshal(entry.Key)
+	if err != nil {
+		return err
+	}
+
+	flags := strconv.FormatUint(entry.Flags, 10)
+	if w.flagsAsString {
+		flags = strconv.Quote(flags)
+	}
+	out := fmt.Sprintf("- key: %s\n  flags: %s\n  value: %q", key, flags, entry.Value)
+	if entry.ModifyIndex != 0 {
+		out += fmt.Sprintf("\n  modify_index: %d", entry.ModifyIndex)
+	}
+	if entry.Session != "" {
+		out += fmt.Sprintf("\n  session: %q", entry.Session)
+	}
+	if entry.Redacted {
+		out += "\n  redacted: true"
+	}
+	if entry.Deleted {
+		out += "\n  deleted: true"
+	}
+	if entry.IsFolder {
+		out += "\n  is_folder: true"
+	}
+	w.out(out)
+	w.written = true
+	return nil
+}
+
+func (w *yamlEntryWriter) Flush() error {
+	if !w.written {
+		w.out("[]")
+	}
+	return nil
+}
+
+// flatBase64Prefix marks values in the flat format which could not be
+// printed as-is and were base64 encoded instead.
+const flatBase64Prefix = "base64:"
+
+// flatEntryWriter writes entries as key=value lines, with the value printed
+// raw when possible. This format is meant for reading and shell scripts and
+// can't be imported.
+type flatEntryWriter struct {
+	out func(string)
+}
+
+func (w *flatEntryWriter) WriteEntry(entry *kvExportEntry) error {
+	value, err := base64.StdEncoding.DecodeString(entry.Value)
+	if err != nil {
+		return err
+	}
+
+	if isPrintable(value) && !strings.HasPrefix(string(value), flatBase64Prefix) {
+		w.out(fmt.Sprintf("%s=%s", entry.Key, value))
+	} else {
+		w.out(fmt.Sprintf("%s=%s%s", entry.Key, flatBase64Prefix, entry.Value))
+	}
+	return nil
+}
+
+func (w *flatEntryWriter) Flush() error {
+	return nil
+}
+
+// isPrintable returns true if the value is valid UTF-8 made up only of
+// printable characters, so it can be safely written on a single line.
+func isPrintable(value []byte) bool {
+	if !utf8.Valid(value) {
+		return false
+	}
+	for _, r := range string(value) {
+		if !unicode.IsPrint(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// isBinary returns true if the value isn't valid UTF-8 or contains a NUL
+// byte, so it can't be treated as text.
+func isBinary(value []byte) bool {
+	return !utf8.Valid(value) || bytes.IndexByte(value, 0) != -1
+}
+
+// decodeKVEntries parses the entries of an export in the given format.
+func decodeKVEntries(format string, data string) ([]*kvExportEntry, error) {
+	switch format {
+	case "json":
+		var entries []*kvExportEntry
+		if err := json.Unmarshal([]byte(data), &entries); err != nil {
+			return nil, jsonErrorPosition(data, err)
+		}
+		return entries, nil
+	case "yaml":
+		return decodeYAMLEntries(data)
+	default:
+		return nil, fmt.Errorf("Unsupported format %q (expected json or yaml)", format)
+	}
+}
+
+// kvDecodeValue decodes a base64 value from data being imported. Values in
+// hand edited files may use the URL-safe alphabet or have missing or extra
+// padding, so either alphabet is accepted, with any padding.
+func kvDecodeValue(s string) ([]byte, error) {
+	enc := base64.RawStdEncoding
+	if strings.ContainsAny(s, "-_") {
+		if strings.ContainsAny(s, "+/") {
+			return nil, fmt.Errorf("mixes the standard and URL-safe base64 alphabets")
+		}
+		enc = base64.RawURLEncoding
+	}
+	value, err := enc.DecodeString(strings.TrimRight(s, "="))
+	if e, ok := err.(base64.CorruptInputError); ok {
+		return nil, fmt.Errorf("not valid base64 at byte %d", int64(e))
+	}
+	return value, err
+}
+
+// jsonErrorPosition annotates a JSON decoding error with the line and column
+// in the data where it occurred, when known.
+func jsonErrorPosition(data string, err error) error {
+	var offset int64
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		offset = e.Offset
+	case *json.UnmarshalTypeError:
+		offset = e.Offset
+	default:
+		return err
+	}
+
+	// The offset is just past the byte where decoding failed, so report the
+	// position of that last byte.
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	end := offset
+	if end > 0 {
+		end--
+	}
+	line, col := 1, 1
+	for _, r := range data[:end] {
+		if r == '\n' {
+			line++
+			col = 1
+		} else {
+			col++
+		}
+	}
+	return fmt.Errorf("line %d, column %d (offset %d): %s", line, col, offset, err)
+}
+
+// decodeYAMLEntries parses the YAML produced by yamlEntryWriter. Only the
+// subset of YAML needed to describe a list of entries is supported: a block
+// sequence of mappings with plain, single-quoted, or double-quoted scalars.
+func decodeYAMLEntries(data string) ([]*kvExportEntry, error) {
+	entries := []*kvExportEntry{}
+
+	var entry *kvExportEntry
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	scanner.Buffer(nil, 64*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimRight(scanner.Text(), " \t\r")
+		trimmed := strings.TrimSpace(text)
+		if trimmed == "" || trimmed == "---" || trimmed[0] == '#' {
+			continue
+		}
+		if trimmed == "[]" && entry == nil && len(entries) == 0 {
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(text, "- "):
+			entry = &kvExportEntry{}
+			entries = append(entries, entry)
+			text = strings.TrimSpace(text[2:])
+		case entry != nil && (text[0] == ' ' || text[0] == '\t'):
+			text = trimmed
+		default:
+			return nil, fmt.Errorf("line %d: expected a list item", line)
+		}
+
+		idx := strings.Index(text, ":")
+		if idx == -1 {
+			return nil, fmt.Errorf("line %d: expected a \"field: value\" pair", line)
+		}
+		field := strings.TrimSpace(text[:idx])
+		value, err := yamlScalar(strings.TrimSpace(text[idx+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err)
+		}
+
+		switch field {
+		case "key":
+			entry.Key = value
+		case "value":
+			entry.Value = value
+		case "flags":
+			flags, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid flags: %s", line, err)
+			}
+			entry.Flags = flags
+		case "modify_index":
+			index, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid modify_index: %s", line, err)
+			}
+			entry.ModifyIndex = index
+		case "session":
+			entry.Session = value
+		case "redacted":
+			redacted, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid redacted: %s", line, err)
+			}
+			entry.Redacted = redacted
+		case "deleted":
+			deleted, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid deleted: %s", line, err)
+			}
+			entry.Deleted = deleted
+		case "is_folder":
+			folder, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid is_folder: %s", line, err)
+			}
+			entry.IsFolder = folder
+		default:
+			return nil, fmt.Errorf("line %d: unknown field %q", line, field)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// yamlScalar decodes a single YAML scalar value.
+func yamlScalar(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+
+	switch value[0] {
+	case '"':
+		var s string
+		if err := json.Unmarshal([]byte(value), &s); err != nil {
+			return "", fmt.Errorf("invalid double-quoted string %s", value)
+		}
+		return s, nil
+	case '\'':
+		if len(value) < 2 || value[len(value)-1] != '\'' {
+			return "", fmt.Errorf("invalid single-quoted string %s", value)
+		}
+		return strings.Replace(value[1:len(value)-1], "''", "'", -1), nil
+	default:
+		return value, nil
+	}
+}
+
+// decodeKVInputFormat parses data written by a tool other than Consul, in one
+// of the formats supported by the import command's -input-format option. The
+// values are base64 encoded to match an export, and the keys are returned as
+// they are in the data, to be normalized by the caller. The entries are
+// returned sorted by key, with no flags.
+func decodeKVInputFormat(inputFormat string, data string) ([]*kvExportEntry, error) {
+	dec := json.NewDecoder(strings.NewReader(data))
+	dec.UseNumber()
+	var root interface{}
+	if err := dec.Decode(&root); err != nil {
+		return nil, jsonErrorPosition(data, err)
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("unexpected data after the top-level object")
+	}
+	obj, ok := root.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected an object at the top level, got %s", jsonTypeName(root))
+	}
+
+	values := make(map[string]string)
+	switch inputFormat {
+	case "flat-json":
+		for _, key := range jsonObjectNames(obj) {
+			if key == "" {
+				return nil, fmt.Errorf("empty key")
+			}
+			value := obj[key]
+			s, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s: expected a string value, got %s", key, jsonTypeName(value))
+			}
+			values[key] = s
+		}
+	case "kvjson":
+		if err := flattenKVJSON("", obj, values); err != nil {
+			return nil, err
+		}
+	case "etcd-json":
+		if err := decodeEtcdJSON(obj, values); err != nil {
+			return nil, err
+		}
+	case "zk-dump":
+		if err := decodeZKDump(obj, values); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("Unsupported input format %q (expected consul, flat-json, kvjson, "+
+			"etcd-json, or zk-dump)", inputFormat)
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	entries := make([]*kvExportEntry, 0, len(keys))
+	for _, key := range keys {
+		entries = append(entries, &kvExportEntry{
+			Key:   key,
+			Value: base64.StdEncoding.EncodeToString([]byte(values[key])),
+		})
+	}
+	return entries, nil
+}
+
+// flattenKVJSON walks a tree of nested objects, joining the names on the way
+// to each string value with "/" to give its key. An empty object gives a
+// folder key ending in "/", so empty folders survive a round trip.
+func flattenKVJSON(path string, obj map[string]interface{}, values map[string]string) error {
+	if len(obj) == 0 && path != "" {
+		return addKVJSONValue(path+"/", "", values)
+	}
+
+	for _, name := range jsonObjectNames(obj) {
+		if name == "" {
+			if path == "" {
+				return fmt.Errorf("empty name at the top level")
+			}
+			return fmt.Errorf("%s/: empty name", path)
+		}
+		key := name
+		if path != "" {
+			key = path + "/" + name
+		}
+
+		switch v := obj[name].(type) {
+		case string:
+			if err := addKVJSONValue(key, v, values); err != nil {
+				return err
+			}
+		case map[string]interface{}:
+			if err := flattenKVJSON(key, v, values); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("%s: expected a string or object, got %s", key, jsonTypeName(v))
+		}
+	}
+	return nil
+}
+
+// addKVJSONValue records the value of a key flattened from nested objects.
+// Names containing "/" can give the same key two ways, such as "a/b" and "b"
+// inside "a", which is an error rather than a silent overwrite.
+func addKVJSONValue(key, value string, values map[string]string) error {
+	if _, ok := values[key]; ok {
+		return fmt.Errorf("%s: key appears more than once", key)
+	}
+	values[key] = value
+	return nil
+}
+
+// decodeEtcdJSON reads the output of "etcdctl get --prefix -w json", which
+// has the base64 encoded keys and values in its "kvs" array. etcd leaves out
+// the array when no keys matched, and the value of a key when it's empty.
+func decodeEtcdJSON(obj map[string]interface{}, values map[string]string) error {
+	if _, ok := obj["header"]; !ok {
+		return fmt.Errorf(`expected the output of "etcdctl get -w json", which has a "header" object`)
+	}
+	raw, ok := obj["kvs"]
+	if !ok {
+		return nil
+	}
+	kvs, ok := raw.([]interface{})
+	if !ok {
+		return fmt.Errorf("kvs: expected an array, got %s", jsonTypeName(raw))
+	}
+
+	for i, item := range kvs {
+		kv, ok := item.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("kvs[%d]: expected an object, got %s", i, jsonTypeName(item))
+		}
+		key, err := etcdBase64Field(kv, "key")
+		if err != nil {
+			return fmt.Errorf("kvs[%d]: %s", i, err)
+		}
+		if key == "" {
+			return fmt.Errorf("kvs[%d]: empty key", i)
+		}
+		value, err := etcdBase64Field(kv, "value")
+		if err != nil {
+			return fmt.Errorf("kvs[%d]: %s", i, err)
+		}
+		if _, ok := values[key]; ok {
+			return fmt.Errorf("kvs[%d]: key %q appears more than once", i, key)
+		}
+		values[key] = value
+	}
+	return nil
+}
+
+// etcdBase64Field decodes a base64 encoded field of an etcd key, which is
+// empty if it's left out.
+func etcdBase64Field(kv map[string]interface{}, name string) (string, error) {
+	raw, ok := kv[name]
+	if !ok {
+		return "", nil
+	}
+	s, ok := raw.(string)
+	if !ok {
+		return "", fmt.Errorf("%s: expected a base64 string, got %s", name, jsonTypeName(raw))
+	}
+	decoded, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return "", fmt.Errorf("%s: invalid base64: %s", name, err)
+	}
+	return string(decoded), nil
+}
+
+// decodeZKDump reads a ZooKeeper dump, which is an object mapping the path of
+// each node to its data as a string, or null if it has none. Since every
+// node in ZooKeeper can have children, nodes with no data which have
+// children are left out, as the keys of their children imply them, while
+// childless ones are imported with an empty value. The root and ZooKeeper's
+// own nodes under /zookeeper are skipped.
+func decodeZKDump(obj map[string]interface{}, values map[string]string) error {
+	parents := make(map[string]bool)
+	for _, p := range jsonObjectNames(obj) {
+		if !strings.HasPrefix(p, "/") {
+			return fmt.Errorf("%s: expected an absolute path", p)
+		}
+		for dir := path.Dir(p); dir != "/"; dir = path.Dir(dir) {
+			parents[dir] = true
+		}
+	}
+
+	for _, p := range jsonObjectNames(obj) {
+		if p == "/" || p == "/zookeeper" || strings.HasPrefix(p, "/zookeeper/") {
+			continue
+		}
+		switch v := obj[p].(type) {
+		case string:
+			values[p] = v
+		case nil:
+			if !parents[p] {
+				values[p] = ""
+			}
+		default:
+			return fmt.Errorf("%s: expected a string or null, got %s", p, jsonTypeName(v))
+		}
+	}
+	return nil
+}
+
+// jsonObjectNames returns the names in a decoded object, sorted so that
+// errors are reported in a stable order.
+func jsonObjectNames(obj map[string]interface{}) []string {
+	names := make([]string, 0, len(obj))
+	for name := range obj {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// jsonTypeName returns the JSON type of a decoded value, for errors.
+func jsonTypeName(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number, float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
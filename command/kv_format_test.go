@@ -0,0 +1,79 @@
+package command
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestKVFormat_yamlRoundTrip(t *testing.T) {
+	entries := []*kvExportEntry{
+		{Key: "foo", Flags: 0, Value: ""},
+		{Key: "foo/\"quoted\" key: with colon", Flags: 12, Value: "YmFyCg==", ModifyIndex: 37},
+		{Key: "foo/ünïcode", Flags: 18446744073709551615, Value: "AP8Q"},
+		{Key: "foo/secret", Value: "PHJlZGFjdGVkPg==", Redacted: true},
+		{Key: "foo/gone", Deleted: true},
+		{Key: "foo/dir/", IsFolder: true},
+	}
+
+	var lines []string
+	w, err := newKVEntryWriter("yaml", true, false, func(s string) { lines = append(lines, s) })
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	for _, entry := range entries {
+		if err := w.WriteEntry(entry); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	decoded, err := decodeYAMLEntries(strings.Join(lines, "\n"))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, entries) {
+		t.Fatalf("bad: %#v", decoded)
+	}
+}
+
+func TestKVFormat_emptyOutput(t *testing.T) {
+	for _, format := range []string{"json", "yaml"} {
+		var lines []string
+		w, err := newKVEntryWriter(format, true, false, func(s string) { lines = append(lines, s) })
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+
+		decoded, err := decodeKVEntries(format, strings.Join(lines, "\n"))
+		if err != nil {
+			t.Fatalf("%s: err: %v", format, err)
+		}
+		if len(decoded) != 0 {
+			t.Fatalf("%s: bad: %#v", format, decoded)
+		}
+	}
+}
+
+func TestKVFormat_yamlErrors(t *testing.T) {
+	cases := map[string]string{
+		"not a list":    "key: foo",
+		"bad flags":     "- key: foo\n  flags: nope",
+		"unknown field": "- key: foo\n  bogus: 1",
+		"bad quoting":   "- key: \"foo",
+		"missing colon": "- key foo",
+	}
+
+	for name, data := range cases {
+		if _, err := decodeYAMLEntries(data); err == nil {
+			t.Errorf("%s: expected error", name)
+		} else if !strings.Contains(err.Error(), "line ") {
+			t.Errorf("%s: expected line number in %q", name, err)
+		}
+	}
+}
@@ -3,11 +3,18 @@ package command
 import (
 	"bytes"
 	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"os"
+	"sort"
 	"strings"
+	"sync"
 	"text/tabwriter"
+	"text/template"
+	"time"
 
 	"github.com/hashicorp/consul/api"
 	"github.com/mitchellh/cli"
@@ -17,11 +24,14 @@ import (
 // a key from the key-value store.
 type KVGetCommand struct {
 	Ui cli.Ui
+
+	// testStdout is the output for testing.
+	testStdout io.Writer
 }
 
 func (c *KVGetCommand) Help() string {
 	helpText := `
-Usage: consul kv get [options] [KEY_OR_PREFIX]
+Usage: consul kv get [options] [KEY_OR_PREFIX | KEY...]
 
   Retrieves the value from Consul's key-value store at the given key name. If no
   key exists with that name, an error is returned. If a key exists with that
@@ -39,6 +49,12 @@ Usage: consul kv get [options] [KEY_OR_PREFIX]
 
       $ consul kv get -detailed foo
 
+  To fall back to other keys when a key doesn't exist, give all of them in
+  order. The value of the first one which exists is printed, or the -default
+  value if none of them do:
+
+      $ consul kv get -default=10 app/prod/pool app/default/pool
+
   To treat the path as a prefix and list all keys which start with the given
   prefix, specify the "-recurse" flag:
 
@@ -49,72 +65,243 @@ Usage: consul kv get [options] [KEY_OR_PREFIX]
 
       $ consul kv get -keys foo
 
+  To list only the immediate children of a prefix as a JSON array, combine this
+  with the -separator and -format options:
+
+      $ consul kv get -keys -separator=/ -format=json foo/
+
+  To print a key or, with -recurse, all keys under a prefix as JSON, including
+  all of their metadata, use the -format option:
+
+      $ consul kv get -recurse -format=json foo
+
+  To render each key with a custom format, specify a Go template with the
+  "-template" option:
+
+      $ consul kv get -recurse -template='{{.Key}} {{.Flags}}' foo
+
+  To list only the keys under a prefix with the given flags, such as the
+  entries a team tags with the value 42 in the low byte of their flags:
+
+      $ consul kv get -recurse -flags=42 -flags-mask=0xff foo
+
+  To page through a large tree rather than print all of it at once, set a
+  page size with -limit, and then pass the last key printed as -after to get
+  the next page:
+
+      $ consul kv get -recurse -limit=100 foo
+      $ consul kv get -recurse -limit=100 -after=foo/0099 foo
+
+  To compare a key across every known datacenter, such as to check that it
+  has been replicated, use the -all-datacenters option:
+
+      $ consul kv get -all-datacenters foo
+
   For a full list of options and examples, please see the Consul documentation.
 
 ` + apiOptsText + `
 
+` + kvKeyOptsText + `
+
+` + kvFlagsFilterOptsText + `
+
+  The flags filter only works with -recurse.
+
 KV Get Options:
 
+  -all-datacenters        Read the key from every datacenter known to the
+                          agent at once, and print a table of its
+                          ModifyIndex and value in each. A datacenter which
+                          can't be read from is reported in the table. With
+                          -format=json, an object keyed by datacenter is
+                          printed instead. This is the same as giving
+                          -datacenter="*", and only works for a single key.
+                          The default value is false.
+
+  -after=<key>            With -keys or -recurse, only list the keys which
+                          sort after this one, such as the last key of the
+                          previous page. With -recurse, only the key names are
+                          listed up front, and the values are fetched for the
+                          page alone.
+
   -base64                 Base64 encode the value. The default value is false.
 
+  -block                  Wait for the key, or with -keys or -recurse any key
+                          under the prefix, to change before printing the
+                          result, using a blocking query. If a key which
+                          existed is deleted, an error is reported. If there
+                          is no change within the -wait time, the command
+                          exits with status 2. The default value is false.
+
+  -default=<string>       Value to print, exiting with status 0, if none of
+                          the given keys exist. It cannot be combined with
+                          -block, -detailed, -format, -keys, -recurse, or
+                          -template. With -verbose, the key which was found,
+                          if any, is printed to stderr.
+
   -detailed               Provide additional metadata about the key in addition
                           to the value such as the ModifyIndex and any flags
                           that may have been set on the key. The default value
                           is false.
 
+  -format=<string>        Output format, either "text" or "json". With "json",
+                          a key is printed as an object with its key, flags,
+                          and base64 encoded value, using the same fields as
+                          "consul kv export", along with its create_index,
+                          modify_index, lock_index, and session. With -recurse
+                          this is an array of objects, and with -keys it's an
+                          array of key names. Nothing is printed for a missing
+                          key. The default value is "text".
+
   -keys                   List keys which start with the given prefix, but not
                           their values. This is especially useful if you only
                           need the key names themselves. This option is commonly
                           combined with the -separator option. The default value
                           is false.
 
+  -limit=<n>              With -keys or -recurse, print at most this many
+                          keys. If there are more, a notice with the -after
+                          value for the next page is printed to stderr, and
+                          the command still exits with status 0. With
+                          -recurse, the values are fetched for the page alone.
+                          The default value is 0, which means no limit.
+
+  -output=<path>          Write the value to the given file exactly as it is
+                          stored, instead of printing it. The file is created
+                          with 0600 permissions if it doesn't exist, and is
+                          not created if the key doesn't exist. It cannot be
+                          combined with the other output options.
+
+  -raw                    Write the value exactly as it is stored, without a
+                          trailing newline. This is useful for binary values.
+                          It cannot be combined with the other output options.
+                          The default value is false.
+
   -recurse                Recursively look at all keys prefixed with the given
                           path. The default value is false.
 
+  -template=<string>      Go template used to render each key, instead of the
+                          default output. The fields Key, Value, Flags,
+                          CreateIndex, ModifyIndex, LockIndex, and Session are
+                          available, along with the "base64" and "json"
+                          functions for encoding values. This works for single
+                          keys and with -recurse.
+
   -separator=<string>     String to use as a separator between keys. The default
                           value is "/", but this option is only taken into
                           account when paired with the -keys flag.
 
+  -strict                 With -all-datacenters, exit with status 3 if any
+                          datacenter can't be read from, or 2 if the key is
+                          missing from any of them. Without it, this only
+                          happens when no datacenter could be read from, or
+                          none of them have the key. The default value is
+                          false.
+
 `
 	return strings.TrimSpace(helpText)
 }
 
-func (c *KVGetCommand) Run(args []string) int {
-	cmdFlags := flag.NewFlagSet("get", flag.ContinueOnError)
+func (c *KVGetCommand) Run(args []string) (code int) {
+	cmdFlags, apiFlags := NewAPIFlagSet("get", c.Ui)
+	defer apiFlags.CheckTimeout(c.Ui, &code)
 	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
-	datacenter := cmdFlags.String("datacenter", "", "")
-	token := cmdFlags.String("token", "", "")
-	stale := cmdFlags.Bool("stale", false, "")
 	detailed := cmdFlags.Bool("detailed", false, "")
 	keys := cmdFlags.Bool("keys", false, "")
 	base64encode := cmdFlags.Bool("base64", false, "")
 	recurse := cmdFlags.Bool("recurse", false, "")
 	separator := cmdFlags.String("separator", "/", "")
-	httpAddr := HTTPAddrFlag(cmdFlags)
-	if err := cmdFlags.Parse(args); err != nil {
+	format := cmdFlags.String("format", "text", "")
+	raw := cmdFlags.Bool("raw", false, "")
+	tmplText := cmdFlags.String("template", "", "")
+	output := cmdFlags.String("output", "", "")
+	block := cmdFlags.Bool("block", false, "")
+	wait := cmdFlags.Duration("wait", 10*time.Minute, "")
+	allDCs := cmdFlags.Bool("all-datacenters", false, "")
+	strict := cmdFlags.Bool("strict", false, "")
+	limit := cmdFlags.Int("limit", 0, "")
+	after := cmdFlags.String("after", "", "")
+	defaultValue := cmdFlags.String("default", "", "")
+	keyFlags := newKVKeyFlags(cmdFlags)
+	flagsFilter := newKVFlagsFilter(cmdFlags)
+	if err := parseFlags(c.Ui, cmdFlags, args); err != nil {
+		return 1
+	}
+
+	if apiFlags.Datacenter == "*" {
+		apiFlags.Datacenter = ""
+		*allDCs = true
+	}
+	if *allDCs && (*block || *detailed || *keys || *output != "" || *raw || *recurse ||
+		*tmplText != "" || apiFlags.Datacenter != "") {
+		c.Ui.Error("Error! Cannot combine -all-datacenters with -block, -datacenter, -detailed, " +
+			"-keys, -output, -raw, -recurse, or -template")
+		return 1
+	}
+	if *strict && !*allDCs {
+		c.Ui.Error("Error! Can only specify -strict with -all-datacenters")
+		return 1
+	}
+	paged := *limit > 0 || *after != ""
+	if *limit < 0 {
+		c.Ui.Error("Error! -limit must not be negative")
+		return 1
+	}
+	if paged && !(*keys || *recurse) {
+		c.Ui.Error("Error! Can only specify -limit or -after with -keys or -recurse")
+		return 1
+	}
+	if paged && *block {
+		c.Ui.Error("Error! Cannot combine -block with -limit or -after")
+		return 1
+	}
+	if flagsFilter.enabled() && !*recurse {
+		c.Ui.Error("Error! Can only specify -flags or -flags-mask with -recurse")
+		return 1
+	}
+	if err := flagsFilter.validate(); err != nil {
+		c.Ui.Error(fmt.Sprintf("Error! %s", err))
 		return 1
 	}
 
-	key := ""
+	// An empty default is still a default, so it's told apart from none by
+	// whether the flag was set.
+	hasDefault := false
+	cmdFlags.Visit(func(f *flag.Flag) { hasDefault = hasDefault || f.Name == "default" })
 
-	// Check for arg validation
+	// Several keys, or a default, are a chain of fallbacks for a single
+	// value, where the first key which exists wins.
 	args = cmdFlags.Args()
-	switch len(args) {
-	case 0:
-		key = ""
-	case 1:
-		key = args[0]
-	default:
-		c.Ui.Error(fmt.Sprintf("Too many arguments (expected 1, got %d)", len(args)))
+	fallback := len(args) > 1 || hasDefault
+	if len(args) > 1 && (*allDCs || *block || *keys || *recurse) {
+		c.Ui.Error(fmt.Sprintf("Error! Cannot give several keys with -all-datacenters, -block, -keys, "+
+			"or -recurse (got %d)", len(args)))
+		return 1
+	}
+	if hasDefault && (*allDCs || *block || *detailed || *keys || *recurse || *tmplText != "" || *format != "text") {
+		c.Ui.Error("Error! Cannot combine -default with -all-datacenters, -block, -detailed, -format, " +
+			"-keys, -recurse, or -template")
 		return 1
 	}
 
-	// This is just a "nice" thing to do. Since pairs cannot start with a /, but
-	// users will likely put "/" or "/foo", lets go ahead and strip that for them
-	// here.
-	if len(key) > 0 && key[0] == '/' {
-		key = key[1:]
+	// Keys can't start with a slash, but users will likely put "/" or "/foo",
+	// so that's stripped along with anything else that needs normalizing.
+	if len(args) == 0 {
+		args = []string{""}
 	}
+	chain := make([]string, len(args))
+	for i, arg := range args {
+		var err error
+		if chain[i], err = keyFlags.check(c.Ui, arg); err != nil {
+			c.Ui.Error(fmt.Sprintf("Error! %s", err))
+			return 1
+		}
+		if chain[i] == "" && fallback {
+			c.Ui.Error("Error! Missing KEY argument")
+			return 1
+		}
+	}
+	key := chain[0]
 
 	// If the key is empty and we are not doing a recursive or key-based lookup,
 	// this is an error.
@@ -123,45 +310,152 @@ func (c *KVGetCommand) Run(args []string) int {
 		return 1
 	}
 
-	// Create and test the HTTP client
-	conf := api.DefaultConfig()
-	conf.Address = *httpAddr
-	if *token != "" {
-		conf.Token = *token
+	if *raw && (*detailed || *keys || *recurse || *base64encode || *format != "text") {
+		c.Ui.Error("Error! Cannot combine -raw with -base64, -detailed, -format, -keys, or -recurse")
+		return 1
+	}
+
+	if *output != "" && (*detailed || *keys || *recurse || *base64encode || *tmplText != "" || *format != "text") {
+		c.Ui.Error("Error! Cannot combine -output with -base64, -detailed, -format, -keys, -recurse, or -template")
+		return 1
+	}
+
+	// Parse the template up front so a bad one fails before any requests
+	var tmpl *template.Template
+	if *tmplText != "" {
+		if *raw || *detailed || *keys || *base64encode || *format != "text" {
+			c.Ui.Error("Error! Cannot combine -template with -base64, -detailed, -format, -keys, or -raw")
+			return 1
+		}
+
+		var err error
+		tmpl, err = template.New("kv").Funcs(kvTemplateFuncs).Parse(*tmplText)
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Error! Invalid template: %s", err))
+			return 1
+		}
+	}
+
+	switch *format {
+	case "text":
+	case "json":
+		// Values are always base64 encoded, and the metadata is always
+		// included, so -detailed makes no difference.
+		if *base64encode {
+			c.Ui.Error("Error! Cannot combine -format=json with -base64, since values are always base64 encoded")
+			return 1
+		}
+	default:
+		c.Ui.Error(fmt.Sprintf("Error! Unsupported format %q (expected text or json)", *format))
+		return 1
 	}
-	client, err := api.NewClient(conf)
+
+	// Create and test the HTTP client
+	client, err := apiFlags.Client()
 	if err != nil {
 		c.Ui.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
 		return 1
 	}
 
+	qo := apiFlags.QueryOptions()
+
 	switch {
+	case *allDCs:
+		return c.getAllDatacenters(client, key, qo, *format, *base64encode, *strict)
 	case *keys:
-		keys, _, err := client.KV().Keys(key, *separator, &api.QueryOptions{
-			Datacenter: *datacenter,
-			AllowStale: *stale,
-		})
-		if err != nil {
-			c.Ui.Error(fmt.Sprintf("Error querying Consul agent: %s", err))
-			return 1
+		var keys []string
+		query := func(q *api.QueryOptions) (uint64, error) {
+			var meta *api.QueryMeta
+			var err error
+			keys, meta, err = client.KV().Keys(key, *separator, q)
+			if err != nil {
+				return 0, err
+			}
+			return meta.LastIndex, nil
+		}
+		if code := apiFlags.blockingQuery(c.Ui, kvDenial("read keys under "+key, key, "read"),
+			qo, *block, *wait, query, nil); code != 0 {
+			return code
+		}
+		var more bool
+		if paged {
+			keys, more = kvPageKeys(keys, *after, *limit)
 		}
 
-		for _, k := range keys {
-			c.Ui.Info(string(k))
+		if *format == "json" {
+			if keys == nil {
+				keys = []string{}
+			}
+			if code := printJSON(c.Ui, keys); code != 0 {
+				return code
+			}
+		} else {
+			for _, k := range keys {
+				c.Ui.Info(string(k))
+			}
 		}
 
+		if more {
+			c.notePage(apiFlags, *limit, keys[len(keys)-1])
+		}
 		return 0
 	case *recurse:
-		pairs, _, err := client.KV().List(key, &api.QueryOptions{
-			Datacenter: *datacenter,
-			AllowStale: *stale,
-		})
-		if err != nil {
-			c.Ui.Error(fmt.Sprintf("Error querying Consul agent: %s", err))
-			return 1
+		var pairs api.KVPairs
+		var initial string
+		first := true
+		query := func(q *api.QueryOptions) (uint64, error) {
+			var meta *api.QueryMeta
+			var err error
+			pairs, meta, err = client.KV().List(key, q)
+			if err != nil {
+				return 0, err
+			}
+			pairs, _ = flagsFilter.filter(pairs)
+			if first {
+				initial, first = kvWatchState(pairs), false
+			}
+			return meta.LastIndex, nil
+		}
+
+		// With a flags filter, only stop blocking once an entry which
+		// passes it has changed.
+		var changed func() bool
+		if flagsFilter.enabled() {
+			changed = func() bool { return kvWatchState(pairs) != initial }
+		}
+
+		var more bool
+		if paged {
+			pairs, more, err = kvListPage(client, key, *after, *limit, flagsFilter, qo)
+			if err != nil {
+				c.Ui.Error(apiFlags.errorMessage("Error querying Consul agent", err,
+					kvDenial("read keys under "+key, key, "read")))
+				return exitCommError
+			}
+		} else if code := apiFlags.blockingQuery(c.Ui, kvDenial("read keys under "+key, key, "read"),
+			qo, *block, *wait, query, changed); code != 0 {
+			return code
+		}
+		if more {
+			defer c.notePage(apiFlags, *limit, pairs[len(pairs)-1].Key)
+		}
+
+		if *format == "json" {
+			entries := make([]*kvGetEntry, 0, len(pairs))
+			for _, pair := range pairs {
+				entries = append(entries, toGetEntry(pair))
+			}
+			return printJSON(c.Ui, entries)
 		}
 
 		for i, pair := range pairs {
+			if tmpl != nil {
+				if err := c.renderTemplate(tmpl, pair); err != nil {
+					return 1
+				}
+				continue
+			}
+
 			if *detailed {
 				var b bytes.Buffer
 				if err := prettyKVPair(&b, pair, *base64encode); err != nil {
@@ -184,19 +478,71 @@ func (c *KVGetCommand) Run(args []string) int {
 		}
 
 		return 0
+	case fallback:
+		pair, code := c.getFirst(client, apiFlags, chain, qo)
+		if code != 0 {
+			return code
+		}
+		switch {
+		case pair != nil:
+		case hasDefault:
+			if apiFlags.Verbose {
+				apiFlags.note(c.Ui, "No key exists, using the -default value")
+			}
+			pair = &api.KVPair{Value: []byte(*defaultValue)}
+		case len(chain) == 1:
+			c.Ui.Error(fmt.Sprintf("Error! No key exists at: %s", key))
+			return exitNotFound
+		default:
+			c.Ui.Error(fmt.Sprintf("Error! No key exists at any of: %s", strings.Join(chain, ", ")))
+			return exitNotFound
+		}
+		return c.printPair(pair, *output, *raw)
 	default:
-		pair, _, err := client.KV().Get(key, &api.QueryOptions{
-			Datacenter: *datacenter,
-			AllowStale: *stale,
-		})
-		if err != nil {
-			c.Ui.Error(fmt.Sprintf("Error querying Consul agent: %s", err))
-			return 1
+		// The index for a single key can move when other keys change, so
+		// when blocking, only stop once the key itself has changed.
+		var pair, initial *api.KVPair
+		first := true
+		query := func(q *api.QueryOptions) (uint64, error) {
+			p, meta, err := client.KV().Get(key, q)
+			if err != nil {
+				return 0, err
+			}
+			if first {
+				initial, first = p, false
+			}
+			pair = p
+			return meta.LastIndex, nil
+		}
+		changed := func() bool {
+			if pair == nil || initial == nil {
+				return pair != initial
+			}
+			return pair.ModifyIndex != initial.ModifyIndex
+		}
+		if code := apiFlags.blockingQuery(c.Ui, kvDenial("read "+key, key, "read"),
+			qo, *block, *wait, query, changed); code != 0 {
+			return code
 		}
 
 		if pair == nil {
+			if *block && initial != nil {
+				c.Ui.Error(fmt.Sprintf("Error! Key was deleted: %s", key))
+				return exitNotFound
+			}
 			c.Ui.Error(fmt.Sprintf("Error! No key exists at: %s", key))
-			return 1
+			return exitNotFound
+		}
+
+		if tmpl != nil {
+			if err := c.renderTemplate(tmpl, pair); err != nil {
+				return 1
+			}
+			return 0
+		}
+
+		if *format == "json" {
+			return printJSON(c.Ui, toGetEntry(pair))
 		}
 
 		if *detailed {
@@ -208,17 +554,317 @@ func (c *KVGetCommand) Run(args []string) int {
 
 			c.Ui.Info(b.String())
 			return 0
-		} else {
-			c.Ui.Info(string(pair.Value))
-			return 0
+		}
+		return c.printPair(pair, *output, *raw)
+	}
+}
+
+// getFirst reads the given keys in order, returning the first one which
+// exists, or nil if none of them do. With -verbose, the key which was found
+// is printed to stderr. A failed request is reported, and its exit code is
+// returned.
+func (c *KVGetCommand) getFirst(client *api.Client, apiFlags *APIFlags, chain []string,
+	q *api.QueryOptions) (*api.KVPair, int) {
+	for _, key := range chain {
+		pair, _, err := client.KV().Get(key, q)
+		if err != nil {
+			c.Ui.Error(apiFlags.errorMessage("Error querying Consul agent", err,
+				kvDenial("read "+key, key, "read")))
+			return nil, exitCommError
+		}
+		if pair != nil {
+			if apiFlags.Verbose {
+				apiFlags.note(c.Ui, fmt.Sprintf("Found key: %s", key))
+			}
+			return pair, 0
 		}
 	}
+	return nil, 0
+}
+
+// printPair prints the value of a single key, or writes it to a file with
+// -output or exactly as it's stored with -raw.
+func (c *KVGetCommand) printPair(pair *api.KVPair, output string, raw bool) int {
+	if output != "" {
+		if err := writeValueFile(output, pair.Value); err != nil {
+			c.Ui.Error(fmt.Sprintf("Error writing value: %s", err))
+			return 1
+		}
+		return 0
+	}
+
+	if raw {
+		if _, err := c.stdout().Write(pair.Value); err != nil {
+			c.Ui.Error(fmt.Sprintf("Error writing value: %s", err))
+			return 1
+		}
+		return 0
+	}
+
+	c.Ui.Info(string(pair.Value))
+	return 0
 }
 
 func (c *KVGetCommand) Synopsis() string {
 	return "Retrieves or lists data from the KV store"
 }
 
+// notePage reports that the listing stopped at the limit, and how to get
+// the next page.
+func (c *KVGetCommand) notePage(apiFlags *APIFlags, limit int, last string) {
+	apiFlags.note(c.Ui, fmt.Sprintf("Stopped at the -limit of %d %s. Use -after=%q to list the next page",
+		limit, pluralKeys(limit), last))
+}
+
+// kvPageKeys returns the sorted keys which come after the given one, or all
+// of them if it's empty, up to the limit if it's positive. It also returns
+// whether there were more keys past the limit.
+func kvPageKeys(keys []string, after string, limit int) ([]string, bool) {
+	sort.Strings(keys)
+	if after != "" {
+		keys = keys[sort.Search(len(keys), func(i int) bool { return keys[i] > after }):]
+	}
+	if limit > 0 && len(keys) > limit {
+		return keys[:limit], true
+	}
+	return keys, false
+}
+
+// errKVPageFull stops fetching the values for a page once it's full.
+var errKVPageFull = errors.New("page is full")
+
+// kvListPage returns a page of the pairs under the prefix which pass the
+// filter, as with kvPageKeys, and whether there are more past the limit.
+// Only the key names are listed up front, and the values are fetched in
+// chunks until the page is full, so a page of a large tree doesn't have to
+// fit in a single response.
+func kvListPage(client *api.Client, prefix, after string, limit int, filter *kvFlagsFilter,
+	q *api.QueryOptions) (api.KVPairs, bool, error) {
+	keys, _, err := client.KV().Keys(prefix, "", q)
+	if err != nil {
+		return nil, false, err
+	}
+	keys, _ = kvPageKeys(keys, after, 0)
+
+	var page api.KVPairs
+	more := false
+	err = kvFetchParallel(client, keys, q, kvDefaultJobs, func(pairs api.KVPairs) error {
+		pairs, _ = filter.filter(pairs)
+		for _, pair := range pairs {
+			if limit > 0 && len(page) == limit {
+				more = true
+				return errKVPageFull
+			}
+			page = append(page, pair)
+		}
+		return nil
+	})
+	if err != nil && err != errKVPageFull {
+		return nil, false, err
+	}
+	return page, more, nil
+}
+
+// kvGetDCResult is the result of reading a key from one datacenter with
+// -all-datacenters. Pair is nil if the key doesn't exist there, or if it
+// couldn't be read, in which case Error is set.
+type kvGetDCResult struct {
+	Pair  *kvGetEntry `json:"pair"`
+	Error string      `json:"error,omitempty"`
+
+	// value is the raw value of the key, for the text output.
+	value []byte
+}
+
+// getAllDatacenters reads the key from every known datacenter in parallel and
+// prints the results. It returns the exit code for the command.
+func (c *KVGetCommand) getAllDatacenters(client *api.Client, key string, q *api.QueryOptions,
+	format string, base64encode, strict bool) int {
+	dcs, err := client.Catalog().Datacenters()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error querying Consul agent: %s", err))
+		return exitCommError
+	}
+	sort.Strings(dcs)
+
+	results := make([]*kvGetDCResult, len(dcs))
+	var wg sync.WaitGroup
+	for i, dc := range dcs {
+		wg.Add(1)
+		go func(i int, dc string) {
+			defer wg.Done()
+			dq := *q
+			dq.Datacenter = dc
+			res := &kvGetDCResult{}
+			pair, _, err := client.KV().Get(key, &dq)
+			switch {
+			case err != nil:
+				res.Error = err.Error()
+			case pair != nil:
+				res.Pair, res.value = toGetEntry(pair), pair.Value
+			}
+			results[i] = res
+		}(i, dc)
+	}
+	wg.Wait()
+
+	var failed, missing int
+	for _, res := range results {
+		switch {
+		case res.Error != "":
+			failed++
+		case res.Pair == nil:
+			missing++
+		}
+	}
+
+	if format == "json" {
+		byDC := make(map[string]*kvGetDCResult, len(dcs))
+		for i, dc := range dcs {
+			byDC[dc] = results[i]
+		}
+		if code := printJSON(c.Ui, byDC); code != 0 {
+			return code
+		}
+	} else {
+		var b bytes.Buffer
+		tw := tabwriter.NewWriter(&b, 0, 2, 2, ' ', 0)
+		fmt.Fprintf(tw, "Datacenter\tModifyIndex\tStatus\tValue\n")
+		for i, res := range results {
+			switch {
+			case res.Error != "":
+				fmt.Fprintf(tw, "%s\t-\terror\t%s\n", dcs[i], res.Error)
+			case res.Pair == nil:
+				fmt.Fprintf(tw, "%s\t-\tmissing\t\n", dcs[i])
+			default:
+				value := string(res.value)
+				if base64encode {
+					value = res.Pair.Value
+				}
+				fmt.Fprintf(tw, "%s\t%d\tok\t%s\n", dcs[i], res.Pair.ModifyIndex, value)
+			}
+		}
+		tw.Flush()
+		c.Ui.Info(strings.TrimSuffix(b.String(), "\n"))
+	}
+
+	switch {
+	case failed == len(dcs) || (strict && failed > 0):
+		c.Ui.Error(fmt.Sprintf("Error! Failed reading from %d of %d datacenters", failed, len(dcs)))
+		return exitCommError
+	case missing == len(dcs)-failed || (strict && missing > 0):
+		c.Ui.Error(fmt.Sprintf("Error! Key is missing from %d of %d datacenters: %s", missing, len(dcs), key))
+		return exitNotFound
+	}
+	return 0
+}
+
+// kvGetEntry is the JSON form of a pair printed with -format=json. The
+// fields are the same as kvExportEntry where they overlap, but all of the
+// metadata is always included.
+type kvGetEntry struct {
+	Key         string `json:"key"`
+	Flags       uint64 `json:"flags"`
+	Value       string `json:"value"`
+	CreateIndex uint64 `json:"create_index"`
+	ModifyIndex uint64 `json:"modify_index"`
+	LockIndex   uint64 `json:"lock_index"`
+	Session     string `json:"session"`
+}
+
+func toGetEntry(pair *api.KVPair) *kvGetEntry {
+	return &kvGetEntry{
+		Key:         pair.Key,
+		Flags:       pair.Flags,
+		Value:       base64.StdEncoding.EncodeToString(pair.Value),
+		CreateIndex: pair.CreateIndex,
+		ModifyIndex: pair.ModifyIndex,
+		LockIndex:   pair.LockIndex,
+		Session:     pair.Session,
+	}
+}
+
+// printJSON prints the value as indented JSON, returning the exit code.
+func printJSON(ui cli.Ui, v interface{}) int {
+	marshaled, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		ui.Error(fmt.Sprintf("Error rendering JSON: %s", err))
+		return 1
+	}
+	ui.Info(string(marshaled))
+	return 0
+}
+
+// writeValueFile writes a value to the given file without any changes,
+// creating it readable only by the current user if it doesn't exist.
+func writeValueFile(path string, value []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(value); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// renderTemplate renders a pair using the given template, reporting any error
+// to the UI.
+func (c *KVGetCommand) renderTemplate(tmpl *template.Template, pair *api.KVPair) error {
+	data := &kvTemplateData{
+		Key:         pair.Key,
+		Value:       string(pair.Value),
+		Flags:       pair.Flags,
+		CreateIndex: pair.CreateIndex,
+		ModifyIndex: pair.ModifyIndex,
+		LockIndex:   pair.LockIndex,
+		Session:     pair.Session,
+	}
+
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, data); err != nil {
+		c.Ui.Error(fmt.Sprintf("Error! Failed rendering template for key %s: %s", pair.Key, err))
+		return err
+	}
+	c.Ui.Info(b.String())
+	return nil
+}
+
+// kvTemplateData is the data available to templates given with -template.
+type kvTemplateData struct {
+	Key         string
+	Value       string
+	Flags       uint64
+	CreateIndex uint64
+	ModifyIndex uint64
+	LockIndex   uint64
+	Session     string
+}
+
+// kvTemplateFuncs are the helper functions available to templates given with
+// -template.
+var kvTemplateFuncs = template.FuncMap{
+	"base64": func(s string) string {
+		return base64.StdEncoding.EncodeToString([]byte(s))
+	},
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	},
+}
+
+// stdout returns the writer for raw output.
+func (c *KVGetCommand) stdout() io.Writer {
+	if c.testStdout != nil {
+		return c.testStdout
+	}
+	return os.Stdout
+}
+
 func prettyKVPair(w io.Writer, pair *api.KVPair, base64EncodeValue bool) error {
 	tw := tabwriter.NewWriter(w, 0, 2, 6, ' ', 0)
 	fmt.Fprintf(tw, "CreateIndex\t%d\n", pair.CreateIndex)
@@ -1,9 +1,19 @@
 package command
 
 import (
+	"bytes"
 	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/hashicorp/consul/api"
 	"github.com/mitchellh/cli"
@@ -29,9 +39,73 @@ func TestKVGetCommand_Validation(t *testing.T) {
 			[]string{},
 			"Missing KEY argument",
 		},
-		"extra args": {
-			[]string{"foo", "bar", "baz"},
-			"Too many arguments",
+		"several keys with -recurse": {
+			[]string{"-recurse", "foo", "bar", "baz"},
+			"Cannot give several keys with -all-datacenters, -block, -keys, or -recurse (got 3)",
+		},
+		"several keys with -keys": {
+			[]string{"-keys", "foo", "bar"},
+			"Cannot give several keys",
+		},
+		"-default with -recurse": {
+			[]string{"-default=x", "-recurse", "foo"},
+			"Cannot combine -default",
+		},
+		"-default with -format": {
+			[]string{"-default=x", "-format=json", "foo", "bar"},
+			"Cannot combine -default",
+		},
+		"-default without a key": {
+			[]string{"-default=x"},
+			"Missing KEY argument",
+		},
+		"-output with -recurse": {
+			[]string{"-output=out", "-recurse", "foo"},
+			"Cannot combine -output",
+		},
+		"-output with -keys": {
+			[]string{"-output=out", "-keys", "foo"},
+			"Cannot combine -output",
+		},
+		"-all-datacenters with -recurse": {
+			[]string{"-all-datacenters", "-recurse", "foo"},
+			"Cannot combine -all-datacenters",
+		},
+		"-all-datacenters with -datacenter": {
+			[]string{"-all-datacenters", "-datacenter=dc1", "foo"},
+			"Cannot combine -all-datacenters",
+		},
+		"-datacenter=* with -block": {
+			[]string{"-datacenter=*", "-block", "foo"},
+			"Cannot combine -all-datacenters",
+		},
+		"-strict without -all-datacenters": {
+			[]string{"-strict", "foo"},
+			"Can only specify -strict with -all-datacenters",
+		},
+		"-flags without -recurse": {
+			[]string{"-flags=1", "foo"},
+			"Can only specify -flags or -flags-mask with -recurse",
+		},
+		"-flags outside of -flags-mask": {
+			[]string{"-recurse", "-flags=0x100", "-flags-mask=0xff", "foo"},
+			"has bits outside of -flags-mask",
+		},
+		"-limit without -recurse": {
+			[]string{"-limit=10", "foo"},
+			"Can only specify -limit or -after with -keys or -recurse",
+		},
+		"-after without -recurse": {
+			[]string{"-after=foo/a", "foo"},
+			"Can only specify -limit or -after with -keys or -recurse",
+		},
+		"negative -limit": {
+			[]string{"-recurse", "-limit=-1", "foo"},
+			"-limit must not be negative",
+		},
+		"-limit with -block": {
+			[]string{"-recurse", "-block", "-limit=10", "foo"},
+			"Cannot combine -block with -limit or -after",
 		},
 	}
 
@@ -89,6 +163,87 @@ func TestKVGetCommand_Run(t *testing.T) {
 	}
 }
 
+func TestKVGetCommand_Fallback(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	for key, value := range map[string]string{
+		"app/default/pool": "10",
+		"app/prod/pool":    "",
+		"app/default/host": "db",
+	} {
+		if _, err := client.KV().Put(&api.KVPair{Key: key, Value: []byte(value)}, nil); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	cases := map[string]struct {
+		args   []string
+		code   int
+		output string
+		errors string
+	}{
+		"first wins": {
+			[]string{"app/prod/pool", "app/default/pool"},
+			0,
+			"\n",
+			"",
+		},
+		"falls back": {
+			[]string{"app/prod/host", "app/default/host"},
+			0,
+			"db\n",
+			"",
+		},
+		"verbose": {
+			[]string{"-verbose", "app/prod/host", "app/default/host"},
+			0,
+			"db\n",
+			"Found key: app/default/host",
+		},
+		"missing": {
+			[]string{"app/prod/port", "app/default/port"},
+			exitNotFound,
+			"",
+			"No key exists at any of: app/prod/port, app/default/port",
+		},
+		"default": {
+			[]string{"-default=8080", "-verbose", "app/prod/port", "app/default/port"},
+			0,
+			"8080\n",
+			"No key exists, using the -default value",
+		},
+		"empty default": {
+			[]string{"-default=", "app/prod/port"},
+			0,
+			"\n",
+			"",
+		},
+		"default unused": {
+			[]string{"-default=8080", "app/default/pool"},
+			0,
+			"10\n",
+			"",
+		},
+	}
+
+	for name, tc := range cases {
+		ui := new(cli.MockUi)
+		c := &KVGetCommand{Ui: ui}
+		code := c.Run(append([]string{"-http-addr=" + srv.httpAddr}, tc.args...))
+		if code != tc.code {
+			t.Fatalf("%s: bad: %d. %#v", name, code, ui.ErrorWriter.String())
+		}
+		if output := ui.OutputWriter.String(); output != tc.output {
+			t.Fatalf("%s: bad: %#v", name, output)
+		}
+		if stderr := ui.ErrorWriter.String(); !strings.Contains(stderr, tc.errors) {
+			t.Fatalf("%s: expected %q to contain %q", name, stderr, tc.errors)
+		}
+	}
+}
+
 func TestKVGetCommand_Missing(t *testing.T) {
 	srv, _ := testAgentWithAPIClient(t)
 	defer srv.Shutdown()
@@ -103,8 +258,8 @@ func TestKVGetCommand_Missing(t *testing.T) {
 	}
 
 	code := c.Run(args)
-	if code == 0 {
-		t.Fatalf("expected bad code")
+	if code != exitNotFound {
+		t.Fatalf("bad: %d", code)
 	}
 }
 
@@ -252,6 +407,184 @@ func TestKVGetCommand_Recurse(t *testing.T) {
 	}
 }
 
+func TestKVGetCommand_RecurseFlags(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	pairs := []*api.KVPair{
+		{Key: "foo/a", Value: []byte("a"), Flags: 42},
+		{Key: "foo/b", Value: []byte("b"), Flags: 42 | 1<<8},
+		{Key: "foo/c", Value: []byte("c"), Flags: 7},
+		{Key: "foo/d", Value: []byte("d")},
+	}
+	for _, pair := range pairs {
+		if _, err := client.KV().Put(pair, nil); err != nil {
+			t.Fatalf("err: %#v", err)
+		}
+	}
+
+	cases := map[string]struct {
+		args   []string
+		output string
+	}{
+		"exact": {
+			[]string{"-flags=42"},
+			"foo/a:a\n",
+		},
+		"masked": {
+			[]string{"-flags=42", "-flags-mask=0xff"},
+			"foo/a:a\nfoo/b:b\n",
+		},
+		"mask only": {
+			[]string{"-flags-mask=0x7"},
+			"foo/d:d\n",
+		},
+		"no match": {
+			[]string{"-flags=1"},
+			"",
+		},
+	}
+
+	for name, tc := range cases {
+		ui := new(cli.MockUi)
+		c := &KVGetCommand{Ui: ui}
+
+		args := append([]string{"-http-addr=" + srv.httpAddr, "-recurse"}, tc.args...)
+		code := c.Run(append(args, "foo"))
+		if code != 0 {
+			t.Fatalf("%s: bad: %d. %#v", name, code, ui.ErrorWriter.String())
+		}
+
+		var output string
+		if ui.OutputWriter != nil {
+			output = ui.OutputWriter.String()
+		}
+		if output != tc.output {
+			t.Fatalf("%s: bad: %q", name, output)
+		}
+	}
+}
+
+func TestKVGetCommand_Limit(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	pairs := []*api.KVPair{
+		{Key: "foo/a", Value: []byte("a")},
+		{Key: "foo/b", Value: []byte("b"), Flags: 42},
+		{Key: "foo/c", Value: []byte("c")},
+		{Key: "foo/d/e", Value: []byte("e"), Flags: 42},
+		{Key: "foo/f", Value: []byte("f"), Flags: 42},
+	}
+	for _, pair := range pairs {
+		if _, err := client.KV().Put(pair, nil); err != nil {
+			t.Fatalf("err: %#v", err)
+		}
+	}
+
+	cases := map[string]struct {
+		args   []string
+		output string
+		next   string
+	}{
+		"first page": {
+			[]string{"-recurse", "-limit=2"},
+			"foo/a:a\nfoo/b:b\n",
+			"foo/b",
+		},
+		"next page": {
+			[]string{"-recurse", "-limit=2", "-after=foo/b"},
+			"foo/c:c\nfoo/d/e:e\n",
+			"foo/d/e",
+		},
+		"last page": {
+			[]string{"-recurse", "-limit=2", "-after=foo/d/e"},
+			"foo/f:f\n",
+			"",
+		},
+		"exact page": {
+			[]string{"-recurse", "-limit=5"},
+			"foo/a:a\nfoo/b:b\nfoo/c:c\nfoo/d/e:e\nfoo/f:f\n",
+			"",
+		},
+		"after only": {
+			[]string{"-recurse", "-after=foo/c"},
+			"foo/d/e:e\nfoo/f:f\n",
+			"",
+		},
+		"flags": {
+			[]string{"-recurse", "-limit=2", "-flags=42"},
+			"foo/b:b\nfoo/d/e:e\n",
+			"foo/d/e",
+		},
+		"flags last page": {
+			[]string{"-recurse", "-limit=2", "-flags=42", "-after=foo/d/e"},
+			"foo/f:f\n",
+			"",
+		},
+		"json": {
+			[]string{"-recurse", "-limit=1", "-after=foo/a", "-format=json"},
+			"",
+			"foo/b",
+		},
+		"keys": {
+			[]string{"-keys", "-limit=2", "-after=foo/a"},
+			"foo/b\nfoo/c\n",
+			"foo/c",
+		},
+		"keys with separator": {
+			[]string{"-keys", "-separator=/", "-limit=2", "-after=foo/c"},
+			"foo/d/\nfoo/f\n",
+			"",
+		},
+		"keys json": {
+			[]string{"-keys", "-limit=3", "-format=json"},
+			"[\n  \"foo/a\",\n  \"foo/b\",\n  \"foo/c\"\n]\n",
+			"foo/c",
+		},
+	}
+
+	for name, tc := range cases {
+		ui := new(cli.MockUi)
+		c := &KVGetCommand{Ui: ui}
+
+		args := append([]string{"-http-addr=" + srv.httpAddr}, tc.args...)
+		code := c.Run(append(args, "foo/"))
+		if code != 0 {
+			t.Fatalf("%s: bad: %d. %#v", name, code, ui.ErrorWriter.String())
+		}
+
+		var output string
+		if ui.OutputWriter != nil {
+			output = ui.OutputWriter.String()
+		}
+		if name == "json" {
+			var entries []*kvGetEntry
+			if err := json.Unmarshal([]byte(output), &entries); err != nil {
+				t.Fatalf("%s: err: %v", name, err)
+			}
+			if len(entries) != 1 || entries[0].Key != "foo/b" {
+				t.Fatalf("%s: bad: %q", name, output)
+			}
+		} else if output != tc.output {
+			t.Fatalf("%s: bad: %q", name, output)
+		}
+
+		errOutput := ui.ErrorWriter.String()
+		if tc.next == "" {
+			if errOutput != "" {
+				t.Fatalf("%s: bad: %q", name, errOutput)
+			}
+			continue
+		}
+		if !strings.Contains(errOutput, fmt.Sprintf("Use -after=%q to list the next page", tc.next)) {
+			t.Fatalf("%s: bad: %q", name, errOutput)
+		}
+	}
+}
+
 func TestKVGetCommand_RecurseBase64(t *testing.T) {
 	srv, client := testAgentWithAPIClient(t)
 	defer srv.Shutdown()
@@ -339,3 +672,566 @@ func TestKVGetCommand_DetailedBase64(t *testing.T) {
 		t.Fatalf("bad %#v, value is not base64 encoded", output)
 	}
 }
+
+func TestKVGetCommand_KeysJSON(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	for _, key := range []string{"foo/bar", "foo/baz/a", "foo/baz/b", "foo/zip", "food"} {
+		if _, err := client.KV().Put(&api.KVPair{Key: key}, nil); err != nil {
+			t.Fatalf("err: %#v", err)
+		}
+	}
+
+	cases := map[string]struct {
+		args     []string
+		expected []string
+	}{
+		"trailing slash": {
+			[]string{"foo/"},
+			[]string{"foo/bar", "foo/baz/", "foo/zip"},
+		},
+		"no trailing slash": {
+			[]string{"foo"},
+			[]string{"foo/", "food"},
+		},
+		"no separator": {
+			[]string{"-separator=", "foo/"},
+			[]string{"foo/bar", "foo/baz/a", "foo/baz/b", "foo/zip"},
+		},
+		"empty": {
+			[]string{"nope/"},
+			[]string{},
+		},
+	}
+
+	for name, tc := range cases {
+		ui := new(cli.MockUi)
+		c := &KVGetCommand{Ui: ui}
+
+		args := append([]string{"-http-addr=" + srv.httpAddr, "-keys", "-format=json"}, tc.args...)
+		code := c.Run(args)
+		if code != 0 {
+			t.Fatalf("%s: bad: %d. %#v", name, code, ui.ErrorWriter.String())
+		}
+
+		var keys []string
+		if err := json.Unmarshal(ui.OutputWriter.Bytes(), &keys); err != nil {
+			t.Fatalf("%s: err: %v", name, err)
+		}
+		if keys == nil || !reflect.DeepEqual(keys, tc.expected) {
+			t.Fatalf("%s: bad: %#v", name, keys)
+		}
+	}
+}
+
+func TestKVGetCommand_JSON(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	session, _, err := client.Session().Create(&api.SessionEntry{}, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := client.KV().Put(&api.KVPair{Key: "foo/a", Value: []byte("a"), Flags: 7}, nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	pair := &api.KVPair{Key: "foo/b", Value: []byte{0x00, 0xff}, Session: session}
+	if ok, _, err := client.KV().Acquire(pair, nil); err != nil || !ok {
+		t.Fatalf("bad: %v %v", ok, err)
+	}
+
+	pairs, _, err := client.KV().List("foo/", nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(pairs) != 2 {
+		t.Fatalf("bad: %#v", pairs)
+	}
+	a, b := pairs[0], pairs[1]
+	expectedA := &kvGetEntry{
+		Key:         "foo/a",
+		Flags:       7,
+		Value:       "YQ==",
+		CreateIndex: a.CreateIndex,
+		ModifyIndex: a.ModifyIndex,
+	}
+	expectedB := &kvGetEntry{
+		Key:         "foo/b",
+		Value:       "AP8=",
+		CreateIndex: b.CreateIndex,
+		ModifyIndex: b.ModifyIndex,
+		LockIndex:   1,
+		Session:     session,
+	}
+
+	run := func(args ...string) (int, []byte) {
+		ui := new(cli.MockUi)
+		c := &KVGetCommand{Ui: ui}
+		code := c.Run(append([]string{"-http-addr=" + srv.httpAddr, "-format=json"}, args...))
+		if ui.OutputWriter == nil {
+			return code, nil
+		}
+		return code, ui.OutputWriter.Bytes()
+	}
+
+	// A single key is an object.
+	code, out := run("foo/b")
+	if code != 0 {
+		t.Fatalf("bad: %d", code)
+	}
+	var entry *kvGetEntry
+	if err := json.Unmarshal(out, &entry); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !reflect.DeepEqual(entry, expectedB) {
+		t.Fatalf("bad: %#v", entry)
+	}
+
+	// The names of the fields match the export format.
+	var fields map[string]interface{}
+	if err := json.Unmarshal(out, &fields); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	for _, field := range []string{"key", "flags", "value", "create_index", "modify_index", "lock_index", "session"} {
+		if _, ok := fields[field]; !ok {
+			t.Fatalf("missing %s: %s", field, out)
+		}
+	}
+
+	// With -recurse it's an array, which is empty if there are no keys.
+	code, out = run("-recurse", "foo/")
+	if code != 0 {
+		t.Fatalf("bad: %d", code)
+	}
+	var entries []*kvGetEntry
+	if err := json.Unmarshal(out, &entries); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !reflect.DeepEqual(entries, []*kvGetEntry{expectedA, expectedB}) {
+		t.Fatalf("bad: %#v", entries)
+	}
+	code, out = run("-recurse", "nope/")
+	if code != 0 || strings.TrimSpace(string(out)) != "[]" {
+		t.Fatalf("bad: %d %q", code, out)
+	}
+
+	// A missing key prints nothing.
+	code, out = run("nope")
+	if code != exitNotFound || len(out) != 0 {
+		t.Fatalf("bad: %d %q", code, out)
+	}
+}
+
+func TestKVGetCommand_FormatValidation(t *testing.T) {
+	cases := map[string]struct {
+		args   []string
+		output string
+	}{
+		"json with -base64": {
+			[]string{"-format=json", "-base64", "foo"},
+			"Cannot combine -format=json with -base64",
+		},
+		"json with -raw": {
+			[]string{"-format=json", "-raw", "foo"},
+			"Cannot combine -raw",
+		},
+		"json with -output": {
+			[]string{"-format=json", "-output=out", "foo"},
+			"Cannot combine -output",
+		},
+		"unknown format": {
+			[]string{"-format=xml", "-keys", "foo"},
+			"Unsupported format",
+		},
+	}
+
+	for name, tc := range cases {
+		ui := new(cli.MockUi)
+		c := &KVGetCommand{Ui: ui}
+
+		code := c.Run(tc.args)
+		if code != 1 {
+			t.Fatalf("%s: bad: %d", name, code)
+		}
+		if !strings.Contains(ui.ErrorWriter.String(), tc.output) {
+			t.Fatalf("%s: expected %q to contain %q", name, ui.ErrorWriter.String(), tc.output)
+		}
+	}
+}
+
+func TestKVGetCommand_Template(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	keys := map[string]string{
+		"foo/a": "a",
+		"foo/b": "b\"c",
+	}
+	for k, v := range keys {
+		pair := &api.KVPair{Key: k, Value: []byte(v), Flags: 7}
+		if _, err := client.KV().Put(pair, nil); err != nil {
+			t.Fatalf("err: %#v", err)
+		}
+	}
+
+	cases := map[string]struct {
+		args   []string
+		output string
+	}{
+		"single": {
+			[]string{"-template={{.Key}} {{.Flags}} {{.Value}}", "foo/a"},
+			"foo/a 7 a\n",
+		},
+		"recurse": {
+			[]string{"-recurse", "-template={{.Key}}={{.Value}}", "foo"},
+			"foo/a=a\nfoo/b=b\"c\n",
+		},
+		"helpers": {
+			[]string{"-recurse", "-template={{.Key}} {{base64 .Value}} {{json .Value}}", "foo"},
+			"foo/a YQ== \"a\"\nfoo/b YiJj \"b\\\"c\"\n",
+		},
+	}
+
+	for name, tc := range cases {
+		ui := new(cli.MockUi)
+		c := &KVGetCommand{Ui: ui}
+
+		args := append([]string{"-http-addr=" + srv.httpAddr}, tc.args...)
+		code := c.Run(args)
+		if code != 0 {
+			t.Fatalf("%s: bad: %d. %#v", name, code, ui.ErrorWriter.String())
+		}
+		if output := ui.OutputWriter.String(); output != tc.output {
+			t.Fatalf("%s: bad: %q", name, output)
+		}
+	}
+}
+
+func TestKVGetCommand_TemplateValidation(t *testing.T) {
+	cases := map[string]struct {
+		args   []string
+		output string
+	}{
+		"invalid template": {
+			[]string{"-template={{.Key", "foo"},
+			"Invalid template",
+		},
+		"with -detailed": {
+			[]string{"-template={{.Key}}", "-detailed", "foo"},
+			"Cannot combine -template",
+		},
+		"with -keys": {
+			[]string{"-template={{.Key}}", "-keys", "foo"},
+			"Cannot combine -template",
+		},
+	}
+
+	for name, tc := range cases {
+		ui := new(cli.MockUi)
+		c := &KVGetCommand{Ui: ui}
+
+		// No agent is running, so these must fail before any request is made.
+		code := c.Run(append([]string{"-http-addr=127.0.0.1:0"}, tc.args...))
+		if code != 1 {
+			t.Fatalf("%s: bad: %d", name, code)
+		}
+		if !strings.Contains(ui.ErrorWriter.String(), tc.output) {
+			t.Fatalf("%s: expected %q to contain %q", name, ui.ErrorWriter.String(), tc.output)
+		}
+	}
+}
+
+func TestKVGetCommand_Block(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	put := func(key, value string) {
+		pair := &api.KVPair{Key: key, Value: []byte(value)}
+		if _, err := client.KV().Put(pair, nil); err != nil {
+			t.Fatalf("err: %#v", err)
+		}
+	}
+	put("foo", "bar")
+	put("tree/a", "a")
+
+	cases := map[string]struct {
+		args   []string
+		change func()
+		code   int
+		output string
+		err    string
+	}{
+		"changed": {
+			[]string{"foo"},
+			func() {
+				// A change to another key doesn't end the wait.
+				put("other", "x")
+				put("foo", "baz")
+			},
+			0, "baz\n", "",
+		},
+		"appeared": {
+			[]string{"-detailed", "new"},
+			func() { put("new", "value") },
+			0, "Value            value", "",
+		},
+		"deleted": {
+			[]string{"foo"},
+			func() {
+				if _, err := client.KV().Delete("foo", nil); err != nil {
+					t.Fatalf("err: %#v", err)
+				}
+			},
+			exitNotFound, "", "Key was deleted: foo",
+		},
+		"recurse": {
+			[]string{"-recurse", "tree"},
+			func() { put("tree/b", "b") },
+			0, "tree/a:a\ntree/b:b\n", "",
+		},
+		"keys": {
+			[]string{"-keys", "tree/"},
+			func() { put("tree/c", "c") },
+			0, "tree/a\ntree/b\ntree/c\n", "",
+		},
+		"timeout": {
+			[]string{"-wait=100ms", "-recurse", "tree"},
+			nil,
+			2, "", "Timed out",
+		},
+	}
+
+	for _, name := range []string{"changed", "appeared", "deleted", "recurse", "keys", "timeout"} {
+		tc := cases[name]
+		ui := new(cli.MockUi)
+		c := &KVGetCommand{Ui: ui}
+
+		if tc.change != nil {
+			go func(change func()) {
+				time.Sleep(200 * time.Millisecond)
+				change()
+			}(tc.change)
+		}
+
+		args := append([]string{"-http-addr=" + srv.httpAddr, "-block", "-wait=10s"}, tc.args...)
+		code := c.Run(args)
+		if code != tc.code {
+			t.Fatalf("%s: bad: %d. %#v", name, code, ui.ErrorWriter.String())
+		}
+		if output := ui.OutputWriter.String(); !strings.Contains(output, tc.output) {
+			t.Fatalf("%s: bad: %q", name, output)
+		}
+		if errOut := ui.ErrorWriter.String(); !strings.Contains(errOut, tc.err) {
+			t.Fatalf("%s: bad: %q", name, errOut)
+		}
+	}
+}
+
+func TestKVGetCommand_Output(t *testing.T) {
+	srv, _ := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	dir, err := ioutil.TempDir("", "kv-get-output")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Round trip a value which a terminal or shell would be likely to mangle.
+	value := []byte("line\r\n\x00binary\x00\r\nend\r\n")
+	in := filepath.Join(dir, "in")
+	if err := ioutil.WriteFile(in, value, 0644); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	ui := new(cli.MockUi)
+	put := &KVPutCommand{Ui: ui}
+	if code := put.Run([]string{"-http-addr=" + srv.httpAddr, "foo", "@" + in}); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+
+	out := filepath.Join(dir, "out")
+	ui = new(cli.MockUi)
+	c := &KVGetCommand{Ui: ui}
+	if code := c.Run([]string{"-http-addr=" + srv.httpAddr, "-output=" + out, "foo"}); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if ui.OutputWriter != nil && ui.OutputWriter.Len() != 0 {
+		t.Fatalf("bad: %#v", ui.OutputWriter.String())
+	}
+
+	actual, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !bytes.Equal(actual, value) {
+		t.Fatalf("bad: %q", actual)
+	}
+	fi, err := os.Stat(out)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if mode := fi.Mode().Perm(); mode != 0600 {
+		t.Fatalf("bad: %v", mode)
+	}
+
+	// A missing key doesn't leave an empty file behind.
+	missing := filepath.Join(dir, "missing")
+	ui = new(cli.MockUi)
+	c = &KVGetCommand{Ui: ui}
+	if code := c.Run([]string{"-http-addr=" + srv.httpAddr, "-output=" + missing, "nope"}); code == 0 {
+		t.Fatalf("bad: %d", code)
+	}
+	if _, err := os.Stat(missing); !os.IsNotExist(err) {
+		t.Fatalf("bad: %v", err)
+	}
+}
+
+func TestKVGetCommand_Raw(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	value := []byte("\x1b[31mbinary\x00\r\n")
+	if _, err := client.KV().Put(&api.KVPair{Key: "foo", Value: value}, nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	ui := new(cli.MockUi)
+	stdout := new(bytes.Buffer)
+	c := &KVGetCommand{Ui: ui, testStdout: stdout}
+	if code := c.Run([]string{"-http-addr=" + srv.httpAddr, "-raw", "foo"}); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+
+	// The value is written as it is, rather than through the Ui.
+	if !bytes.Equal(stdout.Bytes(), value) {
+		t.Fatalf("bad: %q", stdout.Bytes())
+	}
+	if ui.OutputWriter != nil && ui.OutputWriter.Len() != 0 {
+		t.Fatalf("bad: %#v", ui.OutputWriter.String())
+	}
+}
+
+func TestKVGetCommand_AllDatacenters(t *testing.T) {
+	// Each datacenter has its own state for the key: the value, missing, or
+	// unreachable.
+	states := map[string]string{
+		"dc1": "present",
+		"dc2": "present",
+		"dc3": "missing",
+		"dc4": "unreachable",
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v1/catalog/datacenters":
+			fmt.Fprint(w, `["dc4", "dc2", "dc3", "dc1"]`)
+		case r.URL.Path == "/v1/kv/foo":
+			dc := r.URL.Query().Get("dc")
+			switch states[dc] {
+			case "present":
+				w.Header().Set("X-Consul-Index", "10")
+				fmt.Fprintf(w, `[{"Key": "foo", "Flags": 0, "Value": %q, "ModifyIndex": %d}]`,
+					base64.StdEncoding.EncodeToString([]byte("value in "+dc)), len(dc)+int(dc[2]-'0'))
+			case "missing":
+				w.Header().Set("X-Consul-Index", "10")
+				w.WriteHeader(http.StatusNotFound)
+			default:
+				w.WriteHeader(http.StatusInternalServerError)
+				fmt.Fprint(w, "No path to datacenter")
+			}
+		default:
+			t.Errorf("unexpected request: %s", r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	addr := strings.TrimPrefix(srv.URL, "http://")
+
+	run := func(args ...string) (int, string, string) {
+		ui := new(cli.MockUi)
+		c := &KVGetCommand{Ui: ui}
+		code := c.Run(append([]string{"-http-addr=" + addr}, append(args, "foo")...))
+		return code, ui.OutputWriter.String(), ui.ErrorWriter.String()
+	}
+
+	table := `Datacenter  ModifyIndex  Status   Value
+dc1         4            ok       value in dc1
+dc2         5            ok       value in dc2
+dc3         -            missing  
+dc4         -            error    Unexpected response code: 500 (No path to datacenter)
+`
+	cases := map[string]struct {
+		args   []string
+		code   int
+		output string
+	}{
+		"table": {
+			[]string{"-all-datacenters"},
+			0,
+			table,
+		},
+		"datacenter *": {
+			[]string{"-datacenter=*"},
+			0,
+			table,
+		},
+		"strict": {
+			[]string{"-all-datacenters", "-strict"},
+			exitCommError,
+			table,
+		},
+	}
+	for name, tc := range cases {
+		code, output, errors := run(tc.args...)
+		if code != tc.code {
+			t.Fatalf("%s: bad: %d. %#v", name, code, errors)
+		}
+		if output != tc.output {
+			t.Fatalf("%s: bad: %q", name, output)
+		}
+	}
+
+	code, output, errors := run("-all-datacenters", "-format=json")
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, errors)
+	}
+	var results map[string]*kvGetDCResult
+	if err := json.Unmarshal([]byte(output), &results); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(results) != 4 ||
+		results["dc1"].Pair == nil || results["dc1"].Pair.ModifyIndex != 4 ||
+		results["dc1"].Pair.Value != base64.StdEncoding.EncodeToString([]byte("value in dc1")) ||
+		results["dc3"].Pair != nil || results["dc3"].Error != "" ||
+		results["dc4"].Pair != nil || !strings.Contains(results["dc4"].Error, "No path to datacenter") {
+		t.Fatalf("bad: %s", output)
+	}
+
+	// The key missing from every reachable datacenter, or from any with
+	// -strict, means it isn't found.
+	states["dc4"] = "missing"
+	if code, _, errors := run("-all-datacenters", "-strict"); code != exitNotFound ||
+		!strings.Contains(errors, "Key is missing from 2 of 4 datacenters") {
+		t.Fatalf("bad: %d. %#v", code, errors)
+	}
+	states["dc1"], states["dc2"], states["dc4"] = "missing", "missing", "unreachable"
+	if code, _, errors := run("-all-datacenters"); code != exitNotFound {
+		t.Fatalf("bad: %d. %#v", code, errors)
+	}
+
+	// Nothing could be read at all.
+	for dc := range states {
+		states[dc] = "unreachable"
+	}
+	if code, _, errors := run("-all-datacenters"); code != exitCommError ||
+		!strings.Contains(errors, "Failed reading from 4 of 4 datacenters") {
+		t.Fatalf("bad: %d. %#v", code, errors)
+	}
+}
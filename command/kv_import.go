@@ -2,15 +2,17 @@ package command
 
 import (
 	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
 	"encoding/base64"
-	"encoding/json"
 	"errors"
-	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/hashicorp/consul/api"
 	"github.com/mitchellh/cli"
@@ -19,10 +21,15 @@ import (
 // KVImportCommand is a Command implementation that is used to import
 // a KV tree stored as JSON
 type KVImportCommand struct {
-	Ui cli.Ui
+	Ui         cli.Ui
+	ShutdownCh <-chan struct{}
 
 	// testStdin is the input for testing.
 	testStdin io.Reader
+
+	// testPutErr, if set, is called before each key is written without
+	// -atomic, and the write fails with the error it returns, for testing.
+	testPutErr func(key string) error
 }
 
 func (c *KVImportCommand) Synopsis() string {
@@ -34,7 +41,11 @@ func (c *KVImportCommand) Help() string {
 Usage: consul kv import [DATA]
 
   Imports key-value pairs to the key-value store from the JSON representation
-  generated by the "consul kv export" command.
+  generated by the "consul kv export" command. Dumps from other tools, such
+  as etcd and ZooKeeper, can be imported with -input-format:
+
+      $ etcdctl get --prefix -w json /app > app.json
+      $ consul kv import -input-format=etcd-json @app.json
 
   The data can be read from a file by prefixing the filename with the "@"
   symbol. For example:
@@ -43,91 +54,1414 @@ Usage: consul kv import [DATA]
 
   Or it can be read from stdin using the "-" symbol:
 
-      $ cat filename.json | consul kv import config/program/license -
+      $ cat filename.json | consul kv import -
+
+  The -file option can also be used to name the file to read, where "-" again
+  means stdin:
+
+      $ consul kv import -file=filename.json
+
+  Data compressed with gzip, such as by "consul kv export -gzip", is detected
+  and decompressed when it's read from a file or stdin.
+
+  To check the data against a checksum file, such as one written by
+  "consul kv export -checksum", before anything is imported:
+
+      $ consul kv import -checksum=filename.json.sha256 @filename.json
+
+  The flags of each entry may be a number or a string, as written by
+  "consul kv export -flags-as-string". Flags which aren't a whole number that
+  fits in 64 bits, such as ones rounded by a tool which reads numbers as
+  floating point, are rejected before anything is written.
+
+  Keys can be re-rooted under a new path as they are imported:
+
+      $ consul kv export app/prod | consul kv import -prefix=staging -
+
+  The -strip-prefix option removes a leading path from the keys first, so
+  "-strip-prefix=app/prod -prefix=staging" would import "app/prod/db" as
+  "staging/db".
 
   Alternatively the data may be provided as the final parameter to the command,
   though care must be taken with regards to shell escaping.
 
+  Progress is reported to stderr every second, and every 1000 keys, with the
+  number of keys written and failed and the rate they're being written at,
+  unless -quiet is given, which also leaves out the list of keys written. If
+  the import is interrupted or fails part of the way through, the request in
+  flight is finished, and the last key written is reported so the import can
+  be picked up from there with -resume-after:
+
+      $ consul kv import -resume-after=app/db/port @filename.json
+
+  An interrupted import exits with status 130.
+
+  For very large imports, -state-file saves how far the import has got as it
+  goes, so running the same import again picks up where it stopped:
+
+      $ consul kv import -state-file=import.state @filename.json
+
+  The keys are written in sorted order with -state-file, and the state file is
+  deleted once the import finishes.
+
+  If a key appears more than once in the data with different flags or values,
+  nothing is imported and the duplicated keys are listed, unless -first-wins
+  or -last-wins is given to pick which one is imported. A key which appears
+  more than once with the same flags and value is imported once, with a
+  warning.
+
+  The "session" field of exported keys which were held by a lock is ignored,
+  since sessions can't be moved between clusters. A warning is printed if the
+  data has any locked keys.
+
+  Entries whose values were redacted by "consul kv export" are refused, so
+  the placeholders don't overwrite the real values, unless -allow-redacted
+  is given.
+
+  Entries with "deleted": true are tombstones, as written by "consul kv export"
+  with -merge-deletions, and their keys are deleted rather than written, in
+  the same way as the other entries, including with -atomic. They're counted
+  as deletes by a dry run, and are checked not to exist by -verify.
+
   For a full list of options and examples, please see the Consul documentation.
 
 ` + apiOptsText + `
 
+` + kvKeyOptsText + `
+
+` + kvAuditOptsText + `
+
+  Only the keys deleted by -prune are recorded in the audit log.
+
 KV Import Options:
 
-  None.
+  -allow-redacted         Import entries marked as redacted by "consul kv
+                          export -redact" or -redact-pattern, writing the
+                          placeholder values in place of the real ones.
+                          Without this, nothing is imported if the data has
+                          any redacted entries. The default value is false.
+
+  -atomic                 Write the data using transactions, so each batch of
+                          up to 64 keys is written completely or not at all.
+                          If all the data fits in a single transaction, a
+                          failed import leaves the KV store untouched. If a
+                          later batch fails, the keys which were and were not
+                          written are listed. The default value is false.
+
+  -checksum=<path>        Check the SHA-256 sum of the data against the one in
+                          the given file, in the format used by sha256sum,
+                          such as one written by "consul kv export -checksum".
+                          The sum is of the data exactly as it's read, before
+                          it's decompressed. If it doesn't match, nothing is
+                          imported. This can only be used when the data is
+                          read from a file or stdin.
+
+  -dry-run                Compare the data against the KV store and report
+                          how many keys would be created, updated, or left
+                          unchanged, and with -prune how many would be
+                          deleted, without writing anything. With -verbose,
+                          each key is also listed along with whether it would
+                          be created, updated, left unchanged, or deleted.
+                          Exits with status 2 if there are changes pending,
+                          and 0 if not. The default value is false.
+
+  -file=<path>            Path of a file to read the data from, instead of the
+                          DATA argument. Use "-" to read from stdin.
+
+  -first-wins             Import the first of the entries for a key which
+                          appears more than once in the data, printing a
+                          warning for each such key. The default value is
+                          false.
+
+  -format=<string>        Format of the data being imported. One of "json" or
+                          "yaml", matching the formats written by the
+                          "consul kv export" command. This can only be set
+                          with -input-format=consul. The default value is
+                          "json".
+
+  -ignore-missing-prefix  Import keys which don't start with the -strip-prefix
+                          value unchanged, instead of failing. The default
+                          value is false.
+
+  -input-format=<string>  Layout of the data being imported. One of "consul",
+                          for the data written by "consul kv export", or one
+                          of these for JSON written by other tools:
+
+                            flat-json  A single object mapping each key to
+                                       its value as a string.
+                            kvjson     Nested objects, one per path segment,
+                                       with each key's value as a string at
+                                       the end of its path. An empty object
+                                       is imported as a folder key.
+                            etcd-json  The output of "etcdctl get --prefix
+                                       -w json", with base64 encoded keys
+                                       and values.
+                            zk-dump    A single object mapping the path of
+                                       each ZooKeeper node to its data as a
+                                       string, or null if it has none. Nodes
+                                       with no data but with children are
+                                       left out, as are those under
+                                       /zookeeper.
+
+                          The values in these are imported as they are, with
+                          no flags, and the keys are imported in sorted
+                          order. A leading slash is removed from each key,
+                          as for any key, and -prefix can be used to import
+                          them under a new path. Any value of the wrong type
+                          is rejected with its path. The default value is
+                          "consul".
+
+  -invalid-keys=<mode>    What to do with keys which Consul can't store, such
+                          as ones with newlines or other control characters,
+                          or bytes which aren't valid UTF-8, which other
+                          tools may allow. One of "error" to fail the import,
+                          "skip" to leave them out, or "encode" to
+                          percent-encode the characters which aren't
+                          allowed, along with any "%", such as "a%0Ab" for a
+                          key with a newline. Each key skipped or encoded is
+                          reported. The default value is "error".
+
+  -last-wins              Import the last of the entries for a key which
+                          appears more than once in the data, printing a
+                          warning for each such key. The key is imported in
+                          the position where it first appears. The default
+                          value is false.
+
+  -prune                  After importing, delete the keys under the
+                          destination prefix which aren't in the data, and
+                          list each one. The destination prefix is the
+                          -prefix value if given, or else the longest common
+                          path of the imported keys, such as "app/" for
+                          "app/db/host" and "app/web/port". Nothing is
+                          pruned if that would be empty, since it would
+                          cover the whole KV store. The default value is
+                          false.
+
+  -prefix=<string>        Prefix to prepend to every imported key, such as
+                          "staging/". A trailing slash is added if missing.
+                          This is applied after -strip-prefix.
+
+  -rate-limit=<n>         Maximum number of keys written per second. With
+                          -atomic, each transaction counts as a write for
+                          every key in it. The default value is 0, which
+                          means no limit.
+
+  -resume-after=<key>     Skip the keys in the data up to and including the
+                          given key, as reported when an import is stopped
+                          part of the way through. The key is matched after
+                          -prefix and -strip-prefix are applied, and must be
+                          in the data. Keys which are skipped are still
+                          verified with -verify, and kept with -prune.
+
+  -skip-folders           Leave out folders, keys ending in "/" with an empty
+                          value, which only mark a path in the tree. Keys
+                          ending in "/" which have a value are still
+                          imported, and folders already in the KV store are
+                          left alone by -prune. A summary of the number of
+                          folders skipped is written to stderr. The default
+                          value is false.
+
+  -state-file=<path>      File to save the progress of the import to, every
+                          second and every 1000 keys, and when it's stopped.
+                          If the file already exists, the keys up to and
+                          including the last one it records are skipped. The
+                          file holds a hash of the data being imported, after
+                          -prefix and -strip-prefix are applied, and the
+                          import fails if the data doesn't match it. The keys
+                          are written in sorted order, and the file is
+                          deleted once the import finishes.
+
+  -strip-prefix=<string>  Prefix to remove from every imported key before it
+                          is written. A trailing slash is added if missing. It
+                          is an error for a key not to have this prefix unless
+                          -ignore-missing-prefix is set.
+
+  -validate-only          Parse and check the data, reporting every entry
+                          with an invalid key or value, without connecting to
+                          an agent or writing anything. The default value is
+                          false.
+
+  -values-are-raw         Take the values in the data as plain strings rather
+                          than base64. This can only be set with
+                          -input-format=consul. The default value is false.
+
+  -verify                 After importing, read the keys back and compare
+                          their flags and values against the imported data.
+                          Any mismatches are reported and the command exits
+                          with status 2 if any keys are missing, or 1 if
+                          they differ. The default value is false.
+
+  -verify-only            Compare the data against the KV store as with
+                          -verify, but without writing anything. This can be
+                          used to check an export against a live cluster. The
+                          default value is false.
 `
 	return strings.TrimSpace(helpText)
 }
 
-func (c *KVImportCommand) Run(args []string) int {
-	cmdFlags := flag.NewFlagSet("import", flag.ContinueOnError)
+func (c *KVImportCommand) Run(args []string) (code int) {
+	cmdFlags, apiFlags := NewAPIFlagSet("import", c.Ui)
+	defer apiFlags.CheckTimeout(c.Ui, &code)
 
-	datacenter := cmdFlags.String("datacenter", "", "")
-	token := cmdFlags.String("token", "", "")
-	httpAddr := HTTPAddrFlag(cmdFlags)
-	if err := cmdFlags.Parse(args); err != nil {
+	format := cmdFlags.String("format", "json", "")
+	inputFormat := cmdFlags.String("input-format", "consul", "")
+	invalidKeys := cmdFlags.String("invalid-keys", "error", "")
+	file := cmdFlags.String("file", "", "")
+	checksum := cmdFlags.String("checksum", "", "")
+	verify := cmdFlags.Bool("verify", false, "")
+	verifyOnly := cmdFlags.Bool("verify-only", false, "")
+	prefix := cmdFlags.String("prefix", "", "")
+	stripPrefix := cmdFlags.String("strip-prefix", "", "")
+	ignoreMissingPrefix := cmdFlags.Bool("ignore-missing-prefix", false, "")
+	atomic := cmdFlags.Bool("atomic", false, "")
+	dryRun := cmdFlags.Bool("dry-run", false, "")
+	prune := cmdFlags.Bool("prune", false, "")
+	rateLimit := cmdFlags.Float64("rate-limit", 0, "")
+	resumeAfter := cmdFlags.String("resume-after", "", "")
+	stateFile := cmdFlags.String("state-file", "", "")
+	firstWins := cmdFlags.Bool("first-wins", false, "")
+	allowRedacted := cmdFlags.Bool("allow-redacted", false, "")
+	lastWins := cmdFlags.Bool("last-wins", false, "")
+	valuesAreRaw := cmdFlags.Bool("values-are-raw", false, "")
+	validateOnly := cmdFlags.Bool("validate-only", false, "")
+	skipFolders := cmdFlags.Bool("skip-folders", false, "")
+	keyFlags := newKVKeyFlags(cmdFlags)
+	audit := newKVAuditFlags(cmdFlags)
+	if err := parseFlags(c.Ui, cmdFlags, args); err != nil {
+		return 1
+	}
+
+	if *dryRun && (*verify || *verifyOnly) {
+		c.Ui.Error("Error! Cannot specify -dry-run with -verify or -verify-only")
+		return 1
+	}
+	if *prune && *verifyOnly {
+		c.Ui.Error("Error! Cannot specify -prune with -verify-only")
+		return 1
+	}
+	if audit.path != "" && !*prune {
+		c.Ui.Error("Error! -audit-log only records the keys deleted by -prune, so it needs -prune")
+		return 1
+	}
+	if audit.bestEffort && !audit.enabled() {
+		c.Ui.Error("Error! Can only specify -audit-best-effort with -audit-log or " + kvAuditLogEnvName)
+		return 1
+	}
+	if *resumeAfter != "" && (*dryRun || *verifyOnly) {
+		c.Ui.Error("Error! Cannot specify -resume-after with -dry-run or -verify-only")
+		return 1
+	}
+	if *stateFile != "" && (*dryRun || *verifyOnly) {
+		c.Ui.Error("Error! Cannot specify -state-file with -dry-run or -verify-only")
+		return 1
+	}
+	if *stateFile != "" && *resumeAfter != "" {
+		c.Ui.Error("Error! Cannot specify both -resume-after and -state-file")
+		return 1
+	}
+	if *validateOnly && (*dryRun || *verify || *verifyOnly || *resumeAfter != "" || *stateFile != "") {
+		c.Ui.Error("Error! Cannot specify -validate-only with -dry-run, -resume-after, -state-file, " +
+			"-verify, or -verify-only")
+		return 1
+	}
+	if *rateLimit < 0 {
+		c.Ui.Error("Error! -rate-limit must not be negative")
+		return 1
+	}
+	if *firstWins && *lastWins {
+		c.Ui.Error("Error! Cannot specify both -first-wins and -last-wins")
+		return 1
+	}
+	switch *inputFormat {
+	case "consul":
+	case "flat-json", "kvjson", "etcd-json", "zk-dump":
+		if *format != "json" {
+			c.Ui.Error("Error! Can only specify -format with -input-format=consul")
+			return 1
+		}
+	default:
+		c.Ui.Error(fmt.Sprintf("Error! Unsupported -input-format %q (expected consul, flat-json, kvjson, "+
+			"etcd-json, or zk-dump)", *inputFormat))
+		return 1
+	}
+	if *valuesAreRaw && *inputFormat != "consul" {
+		c.Ui.Error("Error! Can only specify -values-are-raw with -input-format=consul")
+		return 1
+	}
+	switch *invalidKeys {
+	case "error", "skip", "encode":
+	default:
+		c.Ui.Error(fmt.Sprintf("Error! Unsupported -invalid-keys %q (expected error, skip, or encode)",
+			*invalidKeys))
 		return 1
 	}
 
 	// Check for arg validation
 	args = cmdFlags.Args()
-	data, err := c.dataFromArgs(args)
+	data, err := c.dataFromArgs(args, *file, *checksum)
 	if err != nil {
 		c.Ui.Error(fmt.Sprintf("Error! %s", err))
 		return 1
 	}
 
-	// Create and test the HTTP client
-	conf := api.DefaultConfig()
-	conf.Address = *httpAddr
-	if *token != "" {
-		conf.Token = *token
+	var entries []*kvExportEntry
+	if *inputFormat == "consul" {
+		entries, err = decodeKVEntries(*format, data)
+	} else {
+		entries, err = decodeKVInputFormat(*inputFormat, data)
 	}
-	client, err := api.NewClient(conf)
 	if err != nil {
-		c.Ui.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+		c.Ui.Error(fmt.Sprintf("Cannot unmarshal data: %s", err))
 		return 1
 	}
 
-	var entries []*kvExportEntry
-	if err := json.Unmarshal([]byte(data), &entries); err != nil {
-		c.Ui.Error(fmt.Sprintf("Cannot unmarshal data: %s", err))
+	// Values in the data written by "consul kv export" are base64 encoded,
+	// which is easy to break when editing a file by hand, so they're all
+	// checked up front and put in the standard form.
+	badValues := 0
+	if *inputFormat == "consul" {
+		badValues = c.normalizeValues(entries, *valuesAreRaw)
+	}
+
+	// Keys are normalized the same way as those given to the other
+	// commands, before they are re-rooted. Dumps from other tools can have
+	// keys which Consul can't store, which -invalid-keys can skip or encode
+	// rather than failing the import.
+	var badKeys []error
+	kept := entries[:0]
+	for _, entry := range entries {
+		key, err := keyFlags.check(c.Ui, entry.Key)
+		switch {
+		case err == nil:
+		case *invalidKeys == "skip":
+			c.Ui.Warn(fmt.Sprintf("Warning! %s, skipping it", err))
+			continue
+		case *invalidKeys == "encode":
+			encoded, encodeErr := keyFlags.check(c.Ui, keyFlags.encode(entry.Key))
+			if encodeErr != nil {
+				c.Ui.Error(fmt.Sprintf("Error! %s", encodeErr))
+				return 1
+			}
+			c.Ui.Warn(fmt.Sprintf("Warning! %s, importing it as %q", err, encoded))
+			key = encoded
+		default:
+			badKeys = append(badKeys, err)
+			continue
+		}
+		entry.Key = key
+		kept = append(kept, entry)
+	}
+	entries = kept
+
+	switch len(badKeys) {
+	case 0:
+	case 1:
+		c.Ui.Error(fmt.Sprintf("Error! %s. Use -invalid-keys to skip or encode such keys", badKeys[0]))
+	default:
+		for _, err := range badKeys {
+			c.Ui.Error(fmt.Sprintf("Invalid key: %s", err))
+		}
+		c.Ui.Error(fmt.Sprintf("Error! The data has %d keys which Consul can't store. "+
+			"Use -invalid-keys to skip or encode such keys", len(badKeys)))
+	}
+	if badValues > 0 || len(badKeys) > 0 {
+		return 1
+	}
+
+	// Folders are skipped by what they hold rather than by "is_folder",
+	// which may not be there.
+	if *skipFolders {
+		kept, folders := entries[:0], 0
+		for _, entry := range entries {
+			if strings.HasSuffix(entry.Key, "/") && entry.Value == "" {
+				folders++
+				continue
+			}
+			kept = append(kept, entry)
+		}
+		entries = kept
+		c.Ui.Warn(fmt.Sprintf("Skipped %d folder %s", folders, pluralKeys(folders)))
+	}
+
+	if *prefix != "" || *stripPrefix != "" {
+		if err := kvRewriteKeys(entries, *stripPrefix, *prefix, *ignoreMissingPrefix); err != nil {
+			c.Ui.Error(fmt.Sprintf("Error! %s", err))
+			return 1
+		}
+	}
+
+	// Duplicates are found once the keys are in their final form, since
+	// two keys in the data may only collide after they're rewritten.
+	entries, dups := kvDedupeEntries(entries, *lastWins)
+	var conflicts []*kvDuplicate
+	for _, d := range dups {
+		if d.conflict {
+			conflicts = append(conflicts, d)
+		}
+	}
+	if len(conflicts) > 0 && !*firstWins && !*lastWins {
+		for _, d := range conflicts {
+			c.Ui.Error(fmt.Sprintf("Duplicate: %s (%d times)", d.key, d.count))
+		}
+		c.Ui.Error(fmt.Sprintf("Error! The data has %d %s more than once with different flags or values. "+
+			"Use -first-wins or -last-wins to choose which is imported", len(conflicts), pluralKeys(len(conflicts))))
 		return 1
 	}
+	for _, d := range dups {
+		if !d.conflict {
+			c.Ui.Warn(fmt.Sprintf("Warning! Key %s appears %d times with the same value, importing it once",
+				d.key, d.count))
+			continue
+		}
+		which := "first"
+		if *lastWins {
+			which = "last"
+		}
+		c.Ui.Warn(fmt.Sprintf("Warning! Key %s appears %d times with different values, importing the %s one",
+			d.key, d.count, which))
+	}
+
+	// Work out what to prune before anything is written, so a bad prefix
+	// doesn't leave a half-finished sync behind.
+	var prunePrefix string
+	if *prune {
+		if *prefix != "" {
+			prunePrefix = normalizeKVPrefix(*prefix)
+		} else {
+			keys := make([]string, len(entries))
+			for i, entry := range entries {
+				keys[i] = entry.Key
+			}
+			prunePrefix = kvCommonPrefix(keys)
+		}
+		if prunePrefix == "" {
+			c.Ui.Error("Error! Cannot prune, since the imported keys have no common prefix " +
+				"and every other key in the KV store would be deleted. Use -prefix to " +
+				"give the destination prefix")
+			return 1
+		}
+	}
+
+	// Sessions don't carry over between clusters, so locks can't be
+	// restored.
+	locked := 0
+	for _, entry := range entries {
+		if entry.Session != "" {
+			locked++
+		}
+	}
+	if locked > 0 {
+		c.Ui.Warn(fmt.Sprintf("Warning! The data has %d locked %s, but locks are not imported",
+			locked, pluralKeys(locked)))
+	}
+
+	// Redacted values are only placeholders, and writing them would
+	// overwrite the real secrets.
+	var redacted []string
+	for _, entry := range entries {
+		if entry.Redacted {
+			redacted = append(redacted, entry.Key)
+		}
+	}
+	if len(redacted) > 0 && !*allowRedacted {
+		for _, k := range redacted {
+			c.Ui.Error(fmt.Sprintf("Redacted: %s", k))
+		}
+		c.Ui.Error(fmt.Sprintf("Error! The data has %d redacted %s, whose values were replaced "+
+			"when exported. Use -allow-redacted to import them anyway", len(redacted), pluralKeys(len(redacted))))
+		return 1
+	}
+	if len(redacted) > 0 {
+		c.Ui.Warn(fmt.Sprintf("Warning! Importing %d redacted %s with placeholder values",
+			len(redacted), pluralKeys(len(redacted))))
+	}
 
+	// Tombstones are carried as pairs with no value, so they're written in
+	// their place in the data, with deletes marking which they are.
+	pairs := make([]*api.KVPair, 0, len(entries))
+	deletes := make(map[string]bool)
 	for _, entry := range entries {
+		if entry.Deleted {
+			deletes[entry.Key] = true
+			pairs = append(pairs, &api.KVPair{Key: entry.Key})
+			continue
+		}
+
 		value, err := base64.StdEncoding.DecodeString(entry.Value)
 		if err != nil {
 			c.Ui.Error(fmt.Sprintf("Error base 64 decoding value for key %s: %s", entry.Key, err))
 			return 1
 		}
 
-		pair := &api.KVPair{
+		pairs = append(pairs, &api.KVPair{
 			Key:   entry.Key,
 			Flags: entry.Flags,
 			Value: value,
+		})
+	}
+
+	if *validateOnly {
+		apiFlags.report(c.Ui, fmt.Sprintf("Validated %d %s", len(pairs), pluralKeys(len(pairs))))
+		return 0
+	}
+
+	// Create and test the HTTP client
+	client, err := apiFlags.Client()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+		return 1
+	}
+
+	if *dryRun {
+		return c.dryRun(client, pairs, deletes, *prune, prunePrefix, *skipFolders, apiFlags.Verbose,
+			apiFlags.QueryOptions())
+	}
+
+	if !*verifyOnly {
+		wo := apiFlags.WriteOptions()
+
+		// Skip what was written before, but keep all the pairs for the
+		// prune.
+		write := pairs
+		if *resumeAfter != "" {
+			write = nil
+			for i, pair := range pairs {
+				if pair.Key == *resumeAfter {
+					write = pairs[i+1:]
+					break
+				}
+			}
+			if write == nil {
+				c.Ui.Error(fmt.Sprintf("Error! Key %q given to -resume-after is not in the data", *resumeAfter))
+				return 1
+			}
+		}
+
+		// With a state file the keys are written in sorted order, so the
+		// last one written marks where to pick up from.
+		var state *kvImportState
+		if *stateFile != "" {
+			sort.Sort(kvPairsByKey(pairs))
+			state, err = loadKVImportState(*stateFile, kvImportHash(pairs, deletes))
+			if err != nil {
+				c.Ui.Error(fmt.Sprintf("Error! %s", err))
+				return 1
+			}
+			if state.LastKey != "" {
+				i := sort.Search(len(pairs), func(i int) bool { return pairs[i].Key > state.LastKey })
+				write = pairs[i:]
+				apiFlags.note(c.Ui, fmt.Sprintf("Resuming the import after %s, skipping %d %s",
+					state.LastKey, i, pluralKeys(i)))
+			}
+			if err := state.save(); err != nil {
+				c.Ui.Error(fmt.Sprintf("Error! Failed to write state file: %s", err))
+				return 1
+			}
+		}
+
+		progress := newKVImportProgress(c.Ui, len(write), apiFlags.Quiet, *rateLimit, c.ShutdownCh)
+		progress.state = state
+		if *atomic {
+			if code := c.importAtomic(client, write, deletes, apiFlags, progress); code != 0 {
+				return code
+			}
+		} else {
+			for _, pair := range write {
+				if !progress.wait(1) {
+					progress.stopped("Cancelled")
+					return exitCancelled
+				}
+				var err error
+				if c.testPutErr != nil {
+					err = c.testPutErr(pair.Key)
+				}
+				if err == nil && deletes[pair.Key] {
+					_, err = client.KV().Delete(pair.Key, wo)
+				} else if err == nil {
+					_, err = client.KV().Put(pair, wo)
+				}
+				if err != nil {
+					msg := fmt.Sprintf("Error! Failed writing data for key %s", pair.Key)
+					denial := kvDenial("write to "+pair.Key, pair.Key, "write")
+					if deletes[pair.Key] {
+						msg = fmt.Sprintf("Error! Failed deleting key %s", pair.Key)
+						denial = kvDenial("delete "+pair.Key, pair.Key, "write")
+					}
+					c.Ui.Error(apiFlags.errorMessage(msg, err, denial))
+					progress.fail(1)
+					progress.stopped("Stopped")
+					return 1
+				}
+
+				apiFlags.report(c.Ui, kvImportedMessage(pair.Key, deletes))
+				progress.wrote(pair)
+			}
+		}
+		progress.done()
+
+		if *prune {
+			if code := c.prune(client, pairs, prunePrefix, *skipFolders, apiFlags, audit); code != 0 {
+				return code
+			}
+		}
+
+		if state != nil {
+			if err := state.remove(); err != nil {
+				c.Ui.Warn(fmt.Sprintf("Warning! Failed to remove state file: %s", err))
+			}
+		}
+	}
+
+	if *verify || *verifyOnly {
+		mismatches, missing, err := kvVerifyEntries(client, entries, apiFlags.QueryOptions())
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Error verifying data: %s", err))
+			return exitCommError
+		}
+
+		for _, m := range mismatches {
+			c.Ui.Error(fmt.Sprintf("Mismatch: %s", m))
+		}
+		if len(mismatches) > 0 {
+			c.Ui.Error(fmt.Sprintf("Error! Verification failed for %d of %d keys", len(mismatches), len(entries)))
+			if missing > 0 {
+				return exitNotFound
+			}
+			return 1
+		}
+
+		apiFlags.report(c.Ui, fmt.Sprintf("Verified %d keys", len(entries)))
+	}
+
+	return 0
+}
+
+// normalizeValues checks the base64 value of each entry, and re-encodes it in
+// the standard form with padding, so that entries with the same value compare
+// equal. With raw set, the values are taken as plain strings instead, and
+// encoded. Each bad value is reported along with its index in the data, and
+// the number of them is returned. Tombstones have their flags and value
+// cleared, since they're ignored.
+func (c *KVImportCommand) normalizeValues(entries []*kvExportEntry, raw bool) int {
+	var bad []string
+	for i, entry := range entries {
+		if entry.Deleted {
+			entry.Flags, entry.Value = 0, ""
+			continue
+		}
+		if raw {
+			entry.Value = base64.StdEncoding.EncodeToString([]byte(entry.Value))
+			continue
+		}
+		value, err := kvDecodeValue(entry.Value)
+		if err != nil {
+			bad = append(bad, fmt.Sprintf("entry %d (key %q): %s", i, entry.Key, err))
+			continue
+		}
+		entry.Value = base64.StdEncoding.EncodeToString(value)
+	}
+
+	switch len(bad) {
+	case 0:
+	case 1:
+		c.Ui.Error(fmt.Sprintf("Error! Invalid value for %s. Use -values-are-raw if the values "+
+			"are plain strings rather than base64", bad[0]))
+	default:
+		for _, b := range bad {
+			c.Ui.Error(fmt.Sprintf("Invalid value: %s", b))
+		}
+		c.Ui.Error(fmt.Sprintf("Error! The data has %d values which aren't valid base64. "+
+			"Use -values-are-raw if the values are plain strings rather than base64", len(bad)))
+	}
+	return len(bad)
+}
+
+// dryRun compares the pairs against the live contents of the KV store and
+// reports what an import would change, including the keys which would be
+// deleted by tombstones or pruned, without writing anything. It returns 0 if
+// nothing would change, or 2 if there are changes pending, so it can be used
+// to check for drift.
+func (c *KVImportCommand) dryRun(client *api.Client, pairs []*api.KVPair, deletes map[string]bool,
+	prune bool, prefix string, skipFolders, verbose bool, q *api.QueryOptions) int {
+	keys := make([]string, len(pairs))
+	for i, pair := range pairs {
+		keys[i] = pair.Key
+	}
+	live, err := kvListLive(client, keys, q)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error querying Consul agent: %s", err))
+		return 1
+	}
+
+	var create, update, remove, unchanged int
+	for _, pair := range pairs {
+		existing, ok := live[pair.Key]
+		switch {
+		case deletes[pair.Key] && ok:
+			remove++
+			if verbose {
+				c.Ui.Info(fmt.Sprintf("Delete: %s", pair.Key))
+			}
+		case deletes[pair.Key]:
+			unchanged++
+			if verbose {
+				c.Ui.Info(fmt.Sprintf("Unchanged: %s", pair.Key))
+			}
+		case !ok:
+			create++
+			if verbose {
+				c.Ui.Info(fmt.Sprintf("Create: %s", pair.Key))
+			}
+		case existing.Flags != pair.Flags || !bytes.Equal(existing.Value, pair.Value):
+			update++
+			if verbose {
+				c.Ui.Info(fmt.Sprintf("Update: %s", pair.Key))
+			}
+		default:
+			unchanged++
+			if verbose {
+				c.Ui.Info(fmt.Sprintf("Unchanged: %s", pair.Key))
+			}
+		}
+	}
+
+	summary := fmt.Sprintf("%d create, %d update, %d unchanged", create, update, unchanged)
+	var stale api.KVPairs
+	if prune {
+		stale, err = kvPruneList(client, prefix, pairs, skipFolders, q)
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Error querying Consul agent: %s", err))
+			return 1
+		}
+		if verbose {
+			for _, pair := range stale {
+				c.Ui.Info(fmt.Sprintf("Delete: %s", pair.Key))
+			}
 		}
+	}
+	if prune || len(deletes) > 0 {
+		summary += fmt.Sprintf(", %d delete", remove+len(stale))
+	}
+
+	c.Ui.Info(summary)
+	if create+update+remove+len(stale) > 0 {
+		return 2
+	}
+	return 0
+}
 
-		wo := &api.WriteOptions{
-			Datacenter: *datacenter,
-			Token:      *token,
+// prune deletes the keys under the prefix which aren't in the imported
+// pairs. Each key is deleted with a check-and-set against the index it was
+// listed at, so a key written in the meantime is left alone. The keys are
+// recorded in the audit log first, if there is one. It returns the exit code
+// for the command.
+func (c *KVImportCommand) prune(client *api.Client, pairs []*api.KVPair, prefix string, skipFolders bool,
+	apiFlags *APIFlags, audit *kvAuditFlags) int {
+	stale, err := kvPruneList(client, prefix, pairs, skipFolders, apiFlags.QueryOptions())
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error querying Consul agent: %s", err))
+		return 1
+	}
+
+	if len(stale) > 0 {
+		keys := make([]string, len(stale))
+		for i, pair := range stale {
+			keys[i] = pair.Key
 		}
+		if !audit.record(c.Ui, client, apiFlags, &kvAuditEntry{
+			Operation:    "prune",
+			Prefix:       prefix,
+			Keys:         keys,
+			Recurse:      true,
+			CAS:          true,
+			KeysAffected: len(stale),
+		}) {
+			return 1
+		}
+	}
 
-		if _, err := client.KV().Put(pair, wo); err != nil {
-			c.Ui.Error(fmt.Sprintf("Error! Failed writing data for key %s: %s", pair.Key, err))
+	skipped := 0
+	for _, pair := range stale {
+		ok, _, err := client.KV().DeleteCAS(pair, apiFlags.WriteOptions())
+		if err != nil {
+			c.Ui.Error(apiFlags.errorMessage(fmt.Sprintf("Error! Failed pruning key %s", pair.Key),
+				err, kvDenial("delete "+pair.Key, pair.Key, "write")))
 			return 1
 		}
+		if !ok {
+			c.Ui.Error(fmt.Sprintf("Not pruned: %s", pair.Key))
+			skipped++
+			continue
+		}
+		apiFlags.report(c.Ui, fmt.Sprintf("Pruned: %s", pair.Key))
+	}
 
-		c.Ui.Info(fmt.Sprintf("Imported: %s", pair.Key))
+	if skipped > 0 {
+		c.Ui.Error(fmt.Sprintf("Error! Left %d %s in place which changed during the prune",
+			skipped, pluralKeys(skipped)))
+		return 1
 	}
+	return 0
+}
+
+// kvPruneList returns the live pairs under the prefix which aren't in the
+// given pairs, sorted by key. With skipFolders, folders are left alone, since
+// they were left out of the pairs.
+func kvPruneList(client *api.Client, prefix string, pairs []*api.KVPair, skipFolders bool,
+	q *api.QueryOptions) (api.KVPairs, error) {
+	keep := make(map[string]struct{}, len(pairs))
+	for _, pair := range pairs {
+		keep[pair.Key] = struct{}{}
+	}
+
+	live, _, err := client.KV().List(prefix, q)
+	if err != nil {
+		return nil, err
+	}
+
+	var stale api.KVPairs
+	for _, pair := range live {
+		if _, ok := keep[pair.Key]; ok || (skipFolders && kvIsFolder(pair.Key, pair.Value)) {
+			continue
+		}
+		stale = append(stale, pair)
+	}
+	return stale, nil
+}
+
+// kvCommonPrefix returns the longest path which all of the keys are under,
+// ending with a slash, or an empty string if they have none in common. A
+// partial path segment isn't a prefix, so "app/a" and "app/ab" give "app/".
+func kvCommonPrefix(keys []string) string {
+	if len(keys) == 0 {
+		return ""
+	}
+
+	prefix := keys[0]
+	for _, key := range keys[1:] {
+		i := 0
+		for i < len(prefix) && i < len(key) && prefix[i] == key[i] {
+			i++
+		}
+		prefix = prefix[:i]
+	}
+	return prefix[:strings.LastIndex(prefix, "/")+1]
+}
+
+// importAtomic writes the pairs using transactions, so that each batch is
+// either written completely or not at all. It returns the exit code for the
+// command.
+func (c *KVImportCommand) importAtomic(client *api.Client, pairs []*api.KVPair, deletes map[string]bool,
+	apiFlags *APIFlags, progress *kvImportProgress) int {
+	return kvWriteBatches(c.Ui, client, pairs, deletes, apiFlags, progress)
+}
+
+// kvWriteBatches writes the pairs in batches of transactions, reporting each
+// key as it's imported. All the batches are planned before anything is
+// written so that data which can't fit in a transaction is caught up front.
+// If a batch fails, the keys which were and weren't committed are listed. The
+// keys in deletes are deleted rather than written, and it may be nil, as may
+// the progress. It returns the exit code for the command.
+func kvWriteBatches(ui cli.Ui, client *api.Client, pairs []*api.KVPair, deletes map[string]bool,
+	apiFlags *APIFlags, progress *kvImportProgress) int {
+	batches, err := kvTxnBatches(pairs)
+	if err != nil {
+		ui.Error(fmt.Sprintf("Error! %s", err))
+		return 1
+	}
+
+	for i, batch := range batches {
+		if !progress.wait(len(batch)) {
+			progress.stopped("Cancelled")
+			return exitCancelled
+		}
+
+		ops := make(api.KVTxnOps, 0, len(batch))
+		keys := make([]string, 0, len(batch))
+		for _, pair := range batch {
+			op := &api.KVTxnOp{
+				Verb:  api.KVSet,
+				Key:   pair.Key,
+				Flags: pair.Flags,
+				Value: pair.Value,
+			}
+			if deletes[pair.Key] {
+				op = &api.KVTxnOp{Verb: api.KVDelete, Key: pair.Key}
+			}
+			ops = append(ops, op)
+			keys = append(keys, pair.Key)
+		}
+
+		ok, resp, err := kvTxn(client, ops, apiFlags.QueryOptions())
+		if err == nil && !ok {
+			err = newKVTxnError(keys, resp)
+		}
+		if err != nil {
+			key := kvDeniedKey(err, batch[0].Key)
+			ui.Error(apiFlags.errorMessage(fmt.Sprintf("Error! Failed writing batch %d of %d", i+1, len(batches)),
+				err, kvDenial("write to "+key, key, "write")))
+			for _, b := range batches[:i] {
+				for _, pair := range b {
+					ui.Error(fmt.Sprintf("Committed: %s", pair.Key))
+				}
+			}
+			for _, b := range batches[i:] {
+				for _, pair := range b {
+					ui.Error(fmt.Sprintf("Not committed: %s", pair.Key))
+				}
+			}
+			progress.fail(len(batch))
+			progress.stopped("Stopped")
+			return 1
+		}
 
+		for _, pair := range batch {
+			apiFlags.report(ui, kvImportedMessage(pair.Key, deletes))
+		}
+		progress.wrote(batch...)
+	}
 	return 0
 }
 
-func (c *KVImportCommand) dataFromArgs(args []string) (string, error) {
-	var stdin io.Reader = os.Stdin
-	if c.testStdin != nil {
-		stdin = c.testStdin
+// kvImportedMessage returns the line reporting that the key was imported, or
+// deleted if it's in deletes.
+func kvImportedMessage(key string, deletes map[string]bool) string {
+	if deletes[key] {
+		return fmt.Sprintf("Deleted: %s", key)
+	}
+	return fmt.Sprintf("Imported: %s", key)
+}
+
+const (
+	// kvProgressKeys and kvProgressInterval are how often the progress of
+	// an import is reported.
+	kvProgressKeys     = 1000
+	kvProgressInterval = time.Second
+)
+
+// kvImportProgress keeps track of how far an import has got, reporting it
+// to stderr as it goes, and paces the writes if there's a rate limit. Its
+// methods do nothing on a nil progress, for callers which don't report it.
+type kvImportProgress struct {
+	ui         cli.Ui
+	total      int
+	quiet      bool
+	limiter    *kvRateLimiter
+	shutdownCh <-chan struct{}
+
+	// state is where the last key written is saved with -state-file, or
+	// nil.
+	state    *kvImportState
+	stateErr bool
+
+	written  int
+	failed   int
+	lastKey  string
+	start    time.Time
+	reported time.Time
+}
+
+// newKVImportProgress returns the progress for an import of the given number
+// of keys, with at most rate writes per second if rate is positive.
+func newKVImportProgress(ui cli.Ui, total int, quiet bool, rate float64,
+	shutdownCh <-chan struct{}) *kvImportProgress {
+	p := &kvImportProgress{
+		ui:         ui,
+		total:      total,
+		quiet:      quiet,
+		shutdownCh: shutdownCh,
+		start:      time.Now(),
+	}
+	p.reported = p.start
+	if rate > 0 {
+		p.limiter = newKVRateLimiter(rate)
+	}
+	return p
+}
+
+// wait blocks until the rate limit allows the next n keys to be written. It
+// returns false if the import was interrupted, and nothing more should be
+// written.
+func (p *kvImportProgress) wait(n int) bool {
+	if p == nil {
+		return true
+	}
+
+	select {
+	case <-p.shutdownCh:
+		return false
+	default:
+	}
+	if p.limiter == nil {
+		return true
+	}
+	return p.limiter.wait(n, p.shutdownCh)
+}
+
+// wrote records that the given pairs were written, reporting the progress
+// if it's due.
+func (p *kvImportProgress) wrote(pairs ...*api.KVPair) {
+	if p == nil || len(pairs) == 0 {
+		return
+	}
+
+	before := p.written
+	p.written += len(pairs)
+	p.lastKey = pairs[len(pairs)-1].Key
+	if p.written/kvProgressKeys != before/kvProgressKeys ||
+		time.Since(p.reported) >= kvProgressInterval {
+		p.report()
+		p.save()
+	}
+}
+
+// fail records that the given number of keys failed to be written.
+func (p *kvImportProgress) fail(n int) {
+	if p == nil {
+		return
+	}
+	p.failed += n
+}
+
+// done reports the final progress of a finished import.
+func (p *kvImportProgress) done() {
+	if p == nil {
+		return
+	}
+	p.report()
+}
+
+// stopped reports how far an import got before it was stopped, and how to
+// pick it up from there.
+func (p *kvImportProgress) stopped(reason string) {
+	if p == nil {
+		return
+	}
+
+	p.report()
+	p.save()
+	p.ui.Error(fmt.Sprintf("%s after writing %d of %d keys", reason, p.written, p.total))
+	if p.lastKey != "" {
+		p.ui.Error(fmt.Sprintf("Last key written: %s", p.lastKey))
+	}
+	if p.state != nil {
+		p.ui.Error(fmt.Sprintf("Run the import again with -state-file=%q to resume it", p.state.path))
+	} else if p.lastKey != "" {
+		p.ui.Error(fmt.Sprintf("Use -resume-after=%q to resume the import", p.lastKey))
+	}
+}
+
+// save records the last key written in the state file, if there is one. A
+// failure is only a warning, since the keys are still being written, and
+// it's only reported once.
+func (p *kvImportProgress) save() {
+	if p.state == nil || p.lastKey == "" {
+		return
+	}
+
+	p.state.LastKey = p.lastKey
+	if err := p.state.save(); err != nil && !p.stateErr {
+		p.ui.Warn(fmt.Sprintf("Warning! Failed to write state file: %s", err))
+		p.stateErr = true
+	}
+}
+
+func (p *kvImportProgress) report() {
+	p.reported = time.Now()
+	if p.quiet {
+		return
+	}
+
+	var rate float64
+	if elapsed := p.reported.Sub(p.start).Seconds(); elapsed > 0 {
+		rate = float64(p.written) / elapsed
+	}
+	p.ui.Error(fmt.Sprintf("Progress: %d of %d keys written, %d failed, %.1f keys/s",
+		p.written, p.total, p.failed, rate))
+}
+
+// kvRateLimiter is a token bucket which allows a number of events per
+// second. It holds at most one token, so the events are spread evenly rather
+// than let through in bursts.
+type kvRateLimiter struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newKVRateLimiter(rate float64) *kvRateLimiter {
+	return &kvRateLimiter{rate: rate, tokens: 1, last: time.Now()}
+}
+
+// wait takes n tokens, blocking until they're available. It returns false if
+// a shutdown is triggered first, without taking any tokens.
+func (l *kvRateLimiter) wait(n int, shutdownCh <-chan struct{}) bool {
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > 1 {
+		l.tokens = 1
+	}
+	l.last = now
+	if l.tokens >= float64(n) {
+		l.tokens -= float64(n)
+		return true
+	}
+
+	delay := time.Duration((float64(n) - l.tokens) / l.rate * float64(time.Second))
+	select {
+	case <-time.After(delay):
+		l.tokens, l.last = 0, now.Add(delay)
+		return true
+	case <-shutdownCh:
+		return false
+	}
+}
+
+// kvTxnBatches splits the pairs into batches which are each small enough to
+// be written in a single transaction, keeping the original order.
+func kvTxnBatches(pairs []*api.KVPair) ([][]*api.KVPair, error) {
+	var batches [][]*api.KVPair
+	var batch []*api.KVPair
+	size := 0
+	for _, pair := range pairs {
+		if len(pair.Value) > kvMaxValueSize {
+			return nil, fmt.Errorf("Value for key %q is too large for a transaction (%d > %d bytes)",
+				pair.Key, len(pair.Value), kvMaxValueSize)
+		}
+
+		if len(batch) == kvMaxTxnOps || size+len(pair.Value) > kvMaxValueSize {
+			batches = append(batches, batch)
+			batch, size = nil, 0
+		}
+		batch = append(batch, pair)
+		size += len(pair.Value)
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+	return batches, nil
+}
+
+// kvTxnError is a transaction which was rolled back, naming the key of each
+// operation which failed.
+type kvTxnError struct {
+	errs []string
+
+	// denied is the first key which was denied by ACLs, if any.
+	denied string
+}
+
+// newKVTxnError returns the error for a transaction which was rolled back,
+// where keys are the keys of its operations, in order.
+func newKVTxnError(keys []string, resp *api.KVTxnResponse) *kvTxnError {
+	e := &kvTxnError{}
+	for _, te := range resp.Errors {
+		key := keys[te.OpIndex]
+		e.errs = append(e.errs, fmt.Sprintf("%s: %s", key, te.What))
+		if e.denied == "" && isPermissionDenied(errors.New(te.What)) {
+			e.denied = key
+		}
+	}
+	return e
+}
+
+func (e *kvTxnError) Error() string {
+	return fmt.Sprintf("transaction rolled back: %s", strings.Join(e.errs, ", "))
+}
+
+// kvDeniedKey returns the key to name when explaining that the error was
+// caused by ACLs: the key which was denied if the error came from a
+// transaction, or else the given key.
+func kvDeniedKey(err error, key string) string {
+	if e, ok := err.(*kvTxnError); ok && e.denied != "" {
+		return e.denied
+	}
+	return key
+}
+
+// kvRewriteKeys re-roots the keys of the given entries by removing the strip
+// prefix and then prepending the new prefix. Both prefixes are treated as
+// paths, so a trailing slash is added if it's missing. All keys are checked
+// before any are changed.
+func kvRewriteKeys(entries []*kvExportEntry, strip, prefix string, ignoreMissing bool) error {
+	strip = normalizeKVPrefix(strip)
+	prefix = normalizeKVPrefix(prefix)
+
+	keys := make([]string, len(entries))
+	for i, entry := range entries {
+		key := entry.Key
+		if strip != "" {
+			if strings.HasPrefix(key, strip) {
+				key = key[len(strip):]
+			} else if !ignoreMissing {
+				return fmt.Errorf("Key %q does not have prefix %q", entry.Key, strip)
+			}
+		}
+
+		key = prefix + key
+		if key == "" {
+			return fmt.Errorf("Key %q would be empty after stripping prefix %q", entry.Key, strip)
+		}
+		keys[i] = key
+	}
+
+	for i, entry := range entries {
+		entry.Key = keys[i]
+	}
+	return nil
+}
+
+// kvDuplicate describes a key which appears more than once in the data being
+// imported.
+type kvDuplicate struct {
+	key      string
+	count    int
+	conflict bool
+}
+
+// kvDedupeEntries removes the entries for keys which appear more than once,
+// keeping the first entry for each key, or the last one with lastWins. The
+// kept entry takes the position of the first one, so the order doesn't
+// depend on which wins. The duplicated keys are returned in the order they
+// first appear, noting whether their flags or values differ. Keys are
+// tracked by their hash, and entries are compared with the one already
+// kept, so nothing is copied.
+func kvDedupeEntries(entries []*kvExportEntry, lastWins bool) ([]*kvExportEntry, []*kvDuplicate) {
+	kept := make([]*kvExportEntry, 0, len(entries))
+	seen := make(map[[sha256.Size]byte]int, len(entries))
+	found := make(map[[sha256.Size]byte]*kvDuplicate)
+	var dups []*kvDuplicate
+	for _, entry := range entries {
+		h := sha256.Sum256([]byte(entry.Key))
+		i, ok := seen[h]
+		if !ok {
+			seen[h] = len(kept)
+			kept = append(kept, entry)
+			continue
+		}
+
+		d, ok := found[h]
+		if !ok {
+			d = &kvDuplicate{key: entry.Key, count: 1}
+			found[h] = d
+			dups = append(dups, d)
+		}
+		d.count++
+		if entry.Flags != kept[i].Flags || entry.Value != kept[i].Value || entry.Deleted != kept[i].Deleted {
+			d.conflict = true
+		}
+		if lastWins {
+			kept[i] = entry
+		}
+	}
+	return kept, dups
+}
+
+// normalizeKVPrefix turns a user-supplied prefix into a path that can be
+// joined with a key, without a leading slash and with a trailing one.
+func normalizeKVPrefix(prefix string) string {
+	prefix = strings.TrimLeft(prefix, "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return prefix
+}
+
+// kvVerifyEntries compares the given entries against the live contents of
+// the KV store and returns a description of each entry which doesn't match,
+// along with how many of them are missing altogether. The keys of tombstones
+// must not exist.
+func kvVerifyEntries(client *api.Client, entries []*kvExportEntry, q *api.QueryOptions) ([]string, int, error) {
+	keys := make([]string, len(entries))
+	for i, entry := range entries {
+		keys[i] = entry.Key
+	}
+	live, err := kvListLive(client, keys, q)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var mismatches []string
+	missing := 0
+	for _, entry := range entries {
+		value, err := base64.StdEncoding.DecodeString(entry.Value)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed base 64 decoding value for key %s: %s", entry.Key, err)
+		}
+
+		pair, ok := live[entry.Key]
+		switch {
+		case entry.Deleted && ok:
+			mismatches = append(mismatches, fmt.Sprintf("%s (not deleted)", entry.Key))
+		case entry.Deleted:
+		case !ok:
+			mismatches = append(mismatches, fmt.Sprintf("%s (missing)", entry.Key))
+			missing++
+		case pair.Flags != entry.Flags:
+			mismatches = append(mismatches, fmt.Sprintf("%s (flags differ: expected %d, got %d)",
+				entry.Key, entry.Flags, pair.Flags))
+		case sha256.Sum256(value) != sha256.Sum256(pair.Value):
+			mismatches = append(mismatches, fmt.Sprintf("%s (value differs)", entry.Key))
+		}
+	}
+	return mismatches, missing, nil
+}
+
+// kvListLive returns the live pairs in the KV store for the given keys,
+// indexed by key. Reads are batched by listing the top-level prefix of each
+// key rather than fetching every key on its own.
+func kvListLive(client *api.Client, keys []string, q *api.QueryOptions) (map[string]*api.KVPair, error) {
+	var prefixes []string
+	seen := make(map[string]struct{})
+	for _, key := range keys {
+		prefix := key
+		if idx := strings.Index(prefix, "/"); idx != -1 {
+			prefix = prefix[:idx+1]
+		}
+		if _, ok := seen[prefix]; !ok {
+			seen[prefix] = struct{}{}
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	sort.Strings(prefixes)
+
+	live := make(map[string]*api.KVPair)
+	var listed []string
+	for _, prefix := range prefixes {
+		// Skip prefixes already covered by an earlier, shorter one, such as
+		// "foo/" when "foo" was listed.
+		covered := false
+		for _, l := range listed {
+			if strings.HasPrefix(prefix, l) {
+				covered = true
+				break
+			}
+		}
+		if covered {
+			continue
+		}
+
+		pairs, _, err := client.KV().List(prefix, q)
+		if err != nil {
+			return nil, err
+		}
+		for _, pair := range pairs {
+			live[pair.Key] = pair
+		}
+		listed = append(listed, prefix)
+	}
+	return live, nil
+}
+
+// dataFromArgs returns the data to import, from the DATA argument or the file
+// it names, or from -file. If a checksum file is given, the data must come
+// from a file or stdin, and must match it.
+func (c *KVImportCommand) dataFromArgs(args []string, file, checksum string) (string, error) {
+	if file != "" {
+		if len(args) > 0 {
+			return "", errors.New("Cannot specify both -file and DATA")
+		}
+		return c.readFile(file, checksum)
 	}
 
 	switch len(args) {
@@ -144,24 +1478,77 @@ func (c *KVImportCommand) dataFromArgs(args []string) (string, error) {
 		return "", errors.New("Empty DATA argument")
 	}
 
-	switch data[0] {
-	case '@':
-		data, err := ioutil.ReadFile(data[1:])
+	switch {
+	case data[0] == '@':
+		return c.readFile(data[1:], checksum)
+	case data == "-":
+		return c.readFile(data, checksum)
+	case checksum != "":
+		return "", errors.New("Can only specify -checksum when reading the data from a file or stdin")
+	default:
+		return data, nil
+	}
+}
+
+// readFile returns the contents of the given file, or of stdin if the file
+// is "-". If a checksum file is given, the contents are checked against it
+// as they were read. Data compressed with gzip, such as from "consul kv
+// export -gzip", is then decompressed.
+func (c *KVImportCommand) readFile(file, checksum string) (string, error) {
+	var data []byte
+	if file == "-" {
+		var stdin io.Reader = os.Stdin
+		if c.testStdin != nil {
+			stdin = c.testStdin
+		}
+
+		var b bytes.Buffer
+		if _, err := io.Copy(&b, stdin); err != nil {
+			return "", fmt.Errorf("Failed to read stdin: %s", err)
+		}
+		data = b.Bytes()
+	} else {
+		var err error
+		data, err = ioutil.ReadFile(file)
 		if err != nil {
+			if os.IsNotExist(err) {
+				return "", fmt.Errorf("File not found: %s", file)
+			}
 			return "", fmt.Errorf("Failed to read file: %s", err)
 		}
-		return string(data), nil
-	case '-':
-		if len(data) > 1 {
-			return data, nil
-		} else {
-			var b bytes.Buffer
-			if _, err := io.Copy(&b, stdin); err != nil {
-				return "", fmt.Errorf("Failed to read stdin: %s", err)
-			}
-			return b.String(), nil
+	}
+
+	if checksum != "" {
+		expected, err := readChecksumFile(checksum)
+		if err != nil {
+			return "", err
 		}
-	default:
-		return data, nil
+		if actual := sha256.Sum256(data); !bytes.Equal(actual[:], expected) {
+			return "", fmt.Errorf("Checksum mismatch: the data has SHA-256 sum %x, but %s has %x",
+				actual, checksum, expected)
+		}
+	}
+	return gunzipIfCompressed(data)
+}
+
+// gzipMagic is the header every gzip stream starts with.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// gunzipIfCompressed returns the data decompressed if it starts with the
+// gzip header, or unchanged if not. Exports are JSON or YAML text, which
+// can't start with these bytes.
+func gunzipIfCompressed(data []byte) (string, error) {
+	if !bytes.HasPrefix(data, gzipMagic) {
+		return string(data), nil
+	}
+
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("Failed to decompress data: %s", err)
+	}
+	decompressed, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", fmt.Errorf("Failed to decompress data: %s", err)
 	}
+	return string(decompressed), nil
 }
@@ -0,0 +1,192 @@
+package command
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/command/agent"
+	"github.com/mitchellh/cli"
+)
+
+// KVImportDirCommand is a Command implementation that is used to mirror a
+// local directory tree into the key-value store.
+type KVImportDirCommand struct {
+	Ui cli.Ui
+}
+
+func (c *KVImportDirCommand) Synopsis() string {
+	return "Imports a directory tree into the KV store"
+}
+
+func (c *KVImportDirCommand) Help() string {
+	helpText := `
+Usage: consul kv import-dir [options] DIR [PREFIX]
+
+  Walks the given directory and writes each file under it to the key-value
+  store. The key is the path of the file relative to DIR, under the given
+  prefix, and the value is the contents of the file:
+
+      $ consul kv import-dir ./config app/
+
+  With the above, the file "./config/web/nginx.conf" is written to the key
+  "app/web/nginx.conf".
+
+  Files and directories whose names start with a "." are skipped, unless the
+  "-hidden" option is given. Symbolic links to files are read, but symbolic
+  links to directories are not followed.
+
+  The keys are written in batches of transactions, so each batch is written
+  completely or not at all. Keys which already exist are overwritten, and keys
+  under the prefix with no matching file are left alone. Use "consul kv
+  export-dir" to write keys back out as files.
+
+  For a full list of options and examples, please see the Consul documentation.
+
+` + apiOptsText + `
+
+KV Import Dir Options:
+
+  -exclude=<glob>         Skip files and directories matching the given glob.
+                          A glob without a "/" is matched against the name of
+                          each file and directory, and one with a "/" against
+                          the path relative to DIR. This can be specified
+                          multiple times.
+
+  -hidden                 Include files and directories whose names start with
+                          a ".". The default value is false.
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *KVImportDirCommand) Run(args []string) (code int) {
+	cmdFlags, apiFlags := NewAPIFlagSet("import-dir", c.Ui)
+	defer apiFlags.CheckTimeout(c.Ui, &code)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	hidden := cmdFlags.Bool("hidden", false, "")
+	var excludes []string
+	cmdFlags.Var((*agent.AppendSliceValue)(&excludes), "exclude", "")
+	if err := parseFlags(c.Ui, cmdFlags, args); err != nil {
+		return 1
+	}
+
+	for _, pattern := range excludes {
+		if _, err := path.Match(pattern, ""); err != nil {
+			c.Ui.Error(fmt.Sprintf("Error! Invalid -exclude pattern %q: %s", pattern, err))
+			return 1
+		}
+	}
+
+	var dir, prefix string
+	args = cmdFlags.Args()
+	switch len(args) {
+	case 0:
+		c.Ui.Error("Error! Missing DIR argument")
+		return 1
+	case 1:
+		dir = args[0]
+	case 2:
+		dir, prefix = args[0], args[1]
+	default:
+		c.Ui.Error(fmt.Sprintf("Too many arguments (expected 1 or 2, got %d)", len(args)))
+		return 1
+	}
+
+	pairs, err := kvReadDir(dir, normalizeKVPrefix(prefix), *hidden, excludes)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error! %s", err))
+		return 1
+	}
+	if len(pairs) == 0 {
+		c.Ui.Warn(fmt.Sprintf("No files to import in %s", dir))
+		return 0
+	}
+
+	// Create and test the HTTP client
+	client, err := apiFlags.Client()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+		return 1
+	}
+
+	return kvWriteBatches(c.Ui, client, pairs, nil, apiFlags, nil)
+}
+
+// kvReadDir reads the files under the directory into KV pairs, keyed by their
+// paths relative to the directory under the given prefix. Hidden files and
+// directories are skipped unless hidden is set, as is anything matching one
+// of the exclude globs.
+func kvReadDir(dir, prefix string, hidden bool, excludes []string) ([]*api.KVPair, error) {
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+
+	var pairs []*api.KVPair
+	err = filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if file == dir {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		skip := !hidden && strings.HasPrefix(info.Name(), ".")
+		for _, pattern := range excludes {
+			name := info.Name()
+			if strings.Contains(pattern, "/") {
+				name = rel
+			}
+			if ok, _ := path.Match(pattern, name); ok {
+				skip = true
+			}
+		}
+		if skip {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		// Only follow links to files, since links to directories could
+		// loop.
+		if info.Mode()&os.ModeSymlink != 0 {
+			if info, err = os.Stat(file); err != nil {
+				return err
+			}
+			if info.IsDir() {
+				return nil
+			}
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		value, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		pairs = append(pairs, &api.KVPair{
+			Key:   prefix + rel,
+			Value: value,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pairs, nil
+}
@@ -0,0 +1,193 @@
+package command
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/cli"
+)
+
+func TestKVImportDirCommand_implements(t *testing.T) {
+	var _ cli.Command = &KVImportDirCommand{}
+}
+
+func TestKVImportDirCommand_noTabs(t *testing.T) {
+	assertNoTabs(t, new(KVImportDirCommand))
+}
+
+// testKVWriteTree creates the given files under dir, making directories as
+// needed.
+func testKVWriteTree(t *testing.T, dir string, files map[string][]byte) {
+	for name, data := range files {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if err := ioutil.WriteFile(file, data, 0644); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+}
+
+func TestKVImportDirCommand_Validation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "consul")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	testKVWriteTree(t, dir, map[string][]byte{"file": []byte("x")})
+
+	cases := map[string]struct {
+		args   []string
+		output string
+	}{
+		"no dir": {
+			[]string{},
+			"Missing DIR argument",
+		},
+		"extra args": {
+			[]string{dir, "app", "extra"},
+			"Too many arguments",
+		},
+		"missing dir": {
+			[]string{filepath.Join(dir, "nope"), "app"},
+			"no such file or directory",
+		},
+		"not a dir": {
+			[]string{filepath.Join(dir, "file"), "app"},
+			"is not a directory",
+		},
+		"bad exclude": {
+			[]string{"-exclude=[", dir, "app"},
+			"Invalid -exclude pattern",
+		},
+	}
+
+	for name, tc := range cases {
+		ui := new(cli.MockUi)
+		c := &KVImportDirCommand{Ui: ui}
+
+		if code := c.Run(tc.args); code != 1 {
+			t.Fatalf("%s: bad: %d", name, code)
+		}
+		if output := ui.ErrorWriter.String(); !strings.Contains(output, tc.output) {
+			t.Fatalf("%s: expected %q to contain %q", name, output, tc.output)
+		}
+	}
+}
+
+func TestKVImportDirCommand_Run(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	dir, err := ioutil.TempDir("", "consul")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	binary := []byte{0x00, 0xff, 0x10, '\n', 0x00}
+	testKVWriteTree(t, dir, map[string][]byte{
+		"a.txt":                  []byte("a"),
+		"empty":                  []byte{},
+		"nested/deep/b.bin":      binary,
+		"nested/deep/skip.tmp":   []byte("tmp"),
+		"nested/other/c.txt":     []byte("c"),
+		"nested/other/d.txt":     []byte("d"),
+		".hidden":                []byte("hidden"),
+		".git/HEAD":              []byte("ref"),
+		"nested/.secrets/token":  []byte("token"),
+		"nested/other/.keep":     []byte{},
+		"nested/excluded/file":   []byte("x"),
+		"nested/deep/excluded/e": []byte("e"),
+	})
+
+	run := func(prefix string, args ...string) {
+		ui := new(cli.MockUi)
+		c := &KVImportDirCommand{Ui: ui}
+		args = append([]string{"-http-addr=" + srv.httpAddr}, args...)
+		if code := c.Run(append(args, dir, prefix)); code != 0 {
+			t.Fatalf("%s: bad: %d. %#v", prefix, code, ui.ErrorWriter.String())
+		}
+	}
+	keys := func(prefix string) []string {
+		keys, _, err := client.KV().Keys(prefix, "", nil)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		sort.Strings(keys)
+		return keys
+	}
+
+	// Hidden and excluded files are skipped. A glob without a "/" matches
+	// names at any depth.
+	run("app", "-exclude=*.tmp", "-exclude=excluded", "-exclude=nested/other/d.*")
+	expected := []string{
+		"app/a.txt",
+		"app/empty",
+		"app/nested/deep/b.bin",
+		"app/nested/other/c.txt",
+	}
+	if actual := keys("app/"); !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("bad: %#v", actual)
+	}
+
+	pair, _, err := client.KV().Get("app/nested/deep/b.bin", nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if pair == nil || !bytes.Equal(pair.Value, binary) {
+		t.Fatalf("bad: %#v", pair)
+	}
+	pair, _, err = client.KV().Get("app/empty", nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if pair == nil || len(pair.Value) != 0 {
+		t.Fatalf("bad: %#v", pair)
+	}
+
+	// With -hidden, dotfiles are included too, and more keys than fit in
+	// one transaction are written in batches.
+	var many = make(map[string][]byte)
+	for i := 0; i < kvMaxTxnOps+5; i++ {
+		many[filepath.Join("many", strings.Repeat("x", i+1))] = []byte("v")
+	}
+	testKVWriteTree(t, dir, many)
+	run("all/", "-hidden")
+	actual := keys("all/")
+	if len(actual) != 12+len(many) {
+		t.Fatalf("bad: %d keys: %#v", len(actual), actual)
+	}
+	for _, key := range []string{"all/.hidden", "all/.git/HEAD", "all/nested/.secrets/token", "all/nested/other/.keep"} {
+		if i := sort.SearchStrings(actual, key); i == len(actual) || actual[i] != key {
+			t.Fatalf("missing %s: %#v", key, actual)
+		}
+	}
+}
+
+func TestKVImportDirCommand_Run_empty(t *testing.T) {
+	dir, err := ioutil.TempDir("", "consul")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	testKVWriteTree(t, dir, map[string][]byte{".hidden": []byte("x")})
+
+	// Nothing is sent to the agent, so this works without one.
+	ui := new(cli.MockUi)
+	c := &KVImportDirCommand{Ui: ui}
+	if code := c.Run([]string{"-http-addr=127.0.0.1:0", dir, "app"}); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if !strings.Contains(ui.ErrorWriter.String(), "No files to import") {
+		t.Fatalf("bad: %#v", ui.ErrorWriter.String())
+	}
+}
@@ -0,0 +1,85 @@
+package command
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// kvImportState is the progress of an import run with -state-file, which is
+// saved as it goes so the import can be resumed if it's stopped. The keys are
+// written in sorted order, so the last key written is all that's needed to
+// know which keys are left.
+type kvImportState struct {
+	path string
+
+	// Hash is the hash of the data being imported, from kvImportHash, so
+	// the state isn't used to resume an import of different data.
+	Hash string
+
+	// LastKey is the last key which was known to be written, or an empty
+	// string if none were.
+	LastKey string
+}
+
+// loadKVImportState returns the state saved at the given path, or a new state
+// if there's no file there yet. It's an error for the saved state to be for
+// data with a different hash.
+func loadKVImportState(path, hash string) (*kvImportState, error) {
+	state := &kvImportState{path: path}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			state.Hash = hash
+			return state, nil
+		}
+		return nil, fmt.Errorf("Failed to read state file: %s", err)
+	}
+
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("State file %s is not valid: %s", path, err)
+	}
+	if state.Hash != hash {
+		return nil, fmt.Errorf("State file %s is for different data than is being imported. "+
+			"Remove it to start the import over", path)
+	}
+	return state, nil
+}
+
+// save writes the state to its file, replacing it in one step so a crash
+// can't leave it half written.
+func (s *kvImportState) save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return kvRenderWriteFile(s.path, append(data, '\n'))
+}
+
+// remove deletes the state file once the import has finished.
+func (s *kvImportState) remove() error {
+	if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// kvImportHash returns a hash of the key, flags, and value of each of the
+// pairs, in order, or just the key for those in deletes.
+func kvImportHash(pairs []*api.KVPair, deletes map[string]bool) string {
+	h := sha256.New()
+	for _, pair := range pairs {
+		if deletes[pair.Key] {
+			fmt.Fprintf(h, "%q deleted\n", pair.Key)
+			continue
+		}
+		fmt.Fprintf(h, "%q %d %d\n", pair.Key, pair.Flags, len(pair.Value))
+		h.Write(pair.Value)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
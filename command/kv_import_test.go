@@ -1,9 +1,19 @@
 package command
 
 import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/hashicorp/consul/api"
 	"github.com/mitchellh/cli"
 )
 
@@ -59,3 +69,1925 @@ func TestKVImportCommand_Run(t *testing.T) {
 		t.Fatalf("bad: expected: baz, got %s", pair.Value)
 	}
 }
+
+func TestKVImportCommand_Run_locked(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	const json = `[
+		{
+			"key": "foo",
+			"flags": 0,
+			"value": "YmFy",
+			"session": "adf4238a-882b-9ddc-4a9d-5b6758e4159e"
+		}
+	]`
+
+	ui := new(cli.MockUi)
+	c := &KVImportCommand{
+		Ui:        ui,
+		testStdin: strings.NewReader(json),
+	}
+
+	code := c.Run([]string{"-http-addr=" + srv.httpAddr, "-"})
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if !strings.Contains(ui.ErrorWriter.String(), "The data has 1 locked key, but locks are not imported") {
+		t.Fatalf("bad: %#v", ui.ErrorWriter.String())
+	}
+
+	pair, _, err := client.KV().Get("foo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pair == nil || string(pair.Value) != "bar" || pair.Session != "" {
+		t.Fatalf("bad: %#v", pair)
+	}
+}
+
+func TestKVImportCommand_Run_redacted(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	if _, err := client.KV().Put(&api.KVPair{Key: "secret", Value: []byte("hunter2")}, nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	const json = `[
+		{
+			"key": "foo",
+			"flags": 0,
+			"value": "YmFy"
+		},
+		{
+			"key": "secret",
+			"flags": 0,
+			"value": "PHJlZGFjdGVkPg==",
+			"redacted": true
+		}
+	]`
+
+	// Nothing is written without -allow-redacted.
+	ui := new(cli.MockUi)
+	c := &KVImportCommand{
+		Ui:        ui,
+		testStdin: strings.NewReader(json),
+	}
+	code := c.Run([]string{"-http-addr=" + srv.httpAddr, "-"})
+	if code != 1 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	output := ui.ErrorWriter.String()
+	if !strings.Contains(output, "Redacted: secret") ||
+		!strings.Contains(output, "The data has 1 redacted key") {
+		t.Fatalf("bad: %#v", output)
+	}
+	if pair, _, err := client.KV().Get("foo", nil); err != nil || pair != nil {
+		t.Fatalf("bad: %#v %v", pair, err)
+	}
+
+	ui = new(cli.MockUi)
+	c = &KVImportCommand{
+		Ui:        ui,
+		testStdin: strings.NewReader(json),
+	}
+	code = c.Run([]string{"-http-addr=" + srv.httpAddr, "-allow-redacted", "-"})
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if !strings.Contains(ui.ErrorWriter.String(), "Importing 1 redacted key with placeholder values") {
+		t.Fatalf("bad: %#v", ui.ErrorWriter.String())
+	}
+	pair, _, err := client.KV().Get("secret", nil)
+	if err != nil || pair == nil || string(pair.Value) != "<redacted>" {
+		t.Fatalf("bad: %#v %v", pair, err)
+	}
+}
+
+func TestKVImportCommand_Run_yaml(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	const yaml = `
+- key: "foo"
+  flags: 42
+  value: "YmFyCg=="
+- key: 'foo/a'
+  flags: 0
+  value: YmF6Cg==
+- key: "foo/empty"
+  flags: 0
+  value: ""
+`
+
+	ui := new(cli.MockUi)
+	c := &KVImportCommand{
+		Ui:        ui,
+		testStdin: strings.NewReader(yaml),
+	}
+
+	args := []string{
+		"-http-addr=" + srv.httpAddr,
+		"-format=yaml",
+		"-",
+	}
+
+	code := c.Run(args)
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+
+	pair, _, err := client.KV().Get("foo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(pair.Value)) != "bar" || pair.Flags != 42 {
+		t.Fatalf("bad: %#v", pair)
+	}
+
+	pair, _, err = client.KV().Get("foo/a", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(pair.Value)) != "baz" {
+		t.Fatalf("bad: expected: baz, got %s", pair.Value)
+	}
+
+	pair, _, err = client.KV().Get("foo/empty", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pair == nil || len(pair.Value) != 0 {
+		t.Fatalf("bad: %#v", pair)
+	}
+}
+
+func TestKVImportCommand_Run_file(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	const json = `[
+		{
+			"key": "file/foo",
+			"flags": 3,
+			"value": "YmFyCg=="
+		},
+		{
+			"key": "file/foo/a",
+			"flags": 0,
+			"value": "YmF6Cg=="
+		}
+	]`
+
+	f, err := ioutil.TempFile("", "kv-import")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(json); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Import the same data from the file, then from stdin, comparing the
+	// resulting state after each.
+	var results []api.KVPairs
+	for _, source := range []string{f.Name(), "-"} {
+		if _, err := client.KV().DeleteTree("file", nil); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+
+		ui := new(cli.MockUi)
+		c := &KVImportCommand{
+			Ui:        ui,
+			testStdin: strings.NewReader(json),
+		}
+
+		args := []string{
+			"-http-addr=" + srv.httpAddr,
+			"-file=" + source,
+		}
+
+		code := c.Run(args)
+		if code != 0 {
+			t.Fatalf("%s: bad: %d. %#v", source, code, ui.ErrorWriter.String())
+		}
+
+		pairs, _, err := client.KV().List("file", nil)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if len(pairs) != 2 {
+			t.Fatalf("%s: bad: %#v", source, pairs)
+		}
+		results = append(results, pairs)
+	}
+
+	for i := range results[0] {
+		a, b := results[0][i], results[1][i]
+		if a.Key != b.Key || a.Flags != b.Flags || !bytes.Equal(a.Value, b.Value) {
+			t.Fatalf("bad: %#v != %#v", a, b)
+		}
+	}
+}
+
+func TestKVImportCommand_Run_errors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kv-import")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	invalid := filepath.Join(dir, "invalid.json")
+	if err := ioutil.WriteFile(invalid, []byte("[\n\t{\n\t\t\"key\": foo\n\t}\n]"), 0600); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	sums := filepath.Join(dir, "sums.sha256")
+	if err := ioutil.WriteFile(sums, []byte(strings.Repeat(strings.Repeat("0", 64)+"  a.json\n", 2)), 0600); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	cases := map[string]struct {
+		args   []string
+		output string
+	}{
+		"missing file": {
+			[]string{"-file=" + filepath.Join(dir, "nope.json")},
+			"File not found",
+		},
+		"missing @file": {
+			[]string{"@" + filepath.Join(dir, "nope.json")},
+			"File not found",
+		},
+		"invalid json": {
+			[]string{"-file=" + invalid},
+			"line 3, column 11",
+		},
+		"file and data": {
+			[]string{"-file=" + invalid, "[]"},
+			"Cannot specify both -file and DATA",
+		},
+		"checksum with data": {
+			[]string{"-checksum=" + sums, "[]"},
+			"Can only specify -checksum when reading the data from a file or stdin",
+		},
+		"missing checksum file": {
+			[]string{"-checksum=" + filepath.Join(dir, "nope.sha256"), "@" + invalid},
+			"Checksum file not found",
+		},
+		"bad checksum file": {
+			[]string{"-checksum=" + invalid, "@" + invalid},
+			"doesn't start with a SHA-256 sum",
+		},
+		"several checksums": {
+			[]string{"-checksum=" + sums, "@" + invalid},
+			"has more than one checksum",
+		},
+		"prune with verify-only": {
+			[]string{"-prune", "-verify-only", "[]"},
+			"Cannot specify -prune with -verify-only",
+		},
+		"resume-after with dry-run": {
+			[]string{"-resume-after=foo", "-dry-run", "[]"},
+			"Cannot specify -resume-after with -dry-run or -verify-only",
+		},
+		"resume-after missing key": {
+			[]string{"-resume-after=foo", `[{"key": "bar", "value": ""}]`},
+			"Key \"foo\" given to -resume-after is not in the data",
+		},
+		"state-file with verify-only": {
+			[]string{"-state-file=foo", "-verify-only", "[]"},
+			"Cannot specify -state-file with -dry-run or -verify-only",
+		},
+		"state-file with resume-after": {
+			[]string{"-state-file=foo", "-resume-after=bar", "[]"},
+			"Cannot specify both -resume-after and -state-file",
+		},
+		"negative rate-limit": {
+			[]string{"-rate-limit=-1", "[]"},
+			"-rate-limit must not be negative",
+		},
+		"bad input-format": {
+			[]string{"-input-format=csv", "{}"},
+			`Unsupported -input-format "csv"`,
+		},
+		"format with input-format": {
+			[]string{"-input-format=kvjson", "-format=yaml", "{}"},
+			"Can only specify -format with -input-format=consul",
+		},
+		"flat-json array": {
+			[]string{"-input-format=flat-json", "[]"},
+			"expected an object at the top level, got array",
+		},
+		"flat-json number": {
+			[]string{"-input-format=flat-json", `{"app/a": "x", "app/port": 8080}`},
+			"app/port: expected a string value, got number",
+		},
+		"flat-json nested": {
+			[]string{"-input-format=flat-json", `{"app": {"a": "x"}}`},
+			"app: expected a string value, got object",
+		},
+		"kvjson null": {
+			[]string{"-input-format=kvjson", `{"app": {"db": {"host": null}}}`},
+			"app/db/host: expected a string or object, got null",
+		},
+		"kvjson array": {
+			[]string{"-input-format=kvjson", `{"app": {"hosts": ["a", "b"]}}`},
+			"app/hosts: expected a string or object, got array",
+		},
+		"kvjson empty name": {
+			[]string{"-input-format=kvjson", `{"app": {"": "x"}}`},
+			"app/: empty name",
+		},
+		"kvjson same key twice": {
+			[]string{"-input-format=kvjson", `{"app": {"db/host": "a", "db": {"host": "b"}}}`},
+			"app/db/host: key appears more than once",
+		},
+		"bad invalid-keys": {
+			[]string{"-invalid-keys=drop", "[]"},
+			`Unsupported -invalid-keys "drop"`,
+		},
+		"etcd-json no header": {
+			[]string{"-input-format=etcd-json", `{"kvs": []}`},
+			`expected the output of "etcdctl get -w json"`,
+		},
+		"etcd-json bad base64": {
+			[]string{"-input-format=etcd-json", `{"header": {}, "kvs": [{"key": "YQ==", "value": "!"}]}`},
+			"kvs[0]: value: invalid base64",
+		},
+		"etcd-json empty key": {
+			[]string{"-input-format=etcd-json", `{"header": {}, "kvs": [{"value": "YQ=="}]}`},
+			"kvs[0]: empty key",
+		},
+		"zk-dump relative path": {
+			[]string{"-input-format=zk-dump", `{"app/db": "x"}`},
+			"app/db: expected an absolute path",
+		},
+		"zk-dump number": {
+			[]string{"-input-format=zk-dump", `{"/app/port": 5432}`},
+			"/app/port: expected a string or null, got number",
+		},
+		"kvjson invalid json": {
+			[]string{"-input-format=kvjson", "{\n\t\"app\": x\n}"},
+			"line 2, column 9",
+		},
+		"validate-only with dry-run": {
+			[]string{"-validate-only", "-dry-run", "[]"},
+			"Cannot specify -validate-only with -dry-run",
+		},
+		"values-are-raw with input-format": {
+			[]string{"-input-format=flat-json", "-values-are-raw", "{}"},
+			"Can only specify -values-are-raw with -input-format=consul",
+		},
+		"bad base64 value": {
+			[]string{`[{"key": "a", "value": "YQ=="}, {"key": "b", "value": "not base64!"}]`},
+			`Error! Invalid value for entry 1 (key "b"): not valid base64 at byte 3. ` +
+				"Use -values-are-raw if the values are plain strings rather than base64",
+		},
+		"mixed base64 alphabets": {
+			[]string{`[{"key": "a", "value": "a+b_"}]`},
+			`entry 0 (key "a"): mixes the standard and URL-safe base64 alphabets`,
+		},
+		"several bad values": {
+			[]string{`[{"key": "a", "value": "!"}, {"key": "b", "value": "YQ=="}, {"key": "c", "value": "Y"}]`},
+			"Error! The data has 2 values which aren't valid base64",
+		},
+		"several invalid keys": {
+			[]string{`[{"key": "a\nb", "value": ""}, {"key": "c\td", "value": ""}]`},
+			"Error! The data has 2 keys which Consul can't store",
+		},
+	}
+
+	for name, tc := range cases {
+		ui := new(cli.MockUi)
+		c := &KVImportCommand{Ui: ui}
+
+		code := c.Run(tc.args)
+		if code == 0 {
+			t.Errorf("%s: expected non-zero exit", name)
+		}
+
+		output := ui.ErrorWriter.String()
+		if !strings.Contains(output, tc.output) {
+			t.Errorf("%s: expected %q to contain %q", name, output, tc.output)
+		}
+	}
+}
+
+func TestKVImportCommand_Run_verify(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	const json = `[
+		{
+			"key": "foo",
+			"flags": 0,
+			"value": "YmFyCg=="
+		},
+		{
+			"key": "foo/a",
+			"flags": 5,
+			"value": "YmF6Cg==",
+			"modify_index": 1234
+		},
+		{
+			"key": "other/b",
+			"flags": 0,
+			"value": ""
+		}
+	]`
+
+	ui := new(cli.MockUi)
+	c := &KVImportCommand{
+		Ui:        ui,
+		testStdin: strings.NewReader(json),
+	}
+
+	args := []string{
+		"-http-addr=" + srv.httpAddr,
+		"-verify",
+		"-",
+	}
+
+	code := c.Run(args)
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if !strings.Contains(ui.OutputWriter.String(), "Verified 3 keys") {
+		t.Fatalf("bad: %#v", ui.OutputWriter.String())
+	}
+
+	// Change the cluster out from under the export and make sure a
+	// verify-only pass catches every difference without writing anything.
+	if _, err := client.KV().Put(&api.KVPair{Key: "foo", Value: []byte("changed")}, nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := client.KV().Put(&api.KVPair{Key: "foo/a", Flags: 6, Value: []byte("baz\n")}, nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := client.KV().Delete("other/b", nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	ui = new(cli.MockUi)
+	c = &KVImportCommand{
+		Ui:        ui,
+		testStdin: strings.NewReader(json),
+	}
+
+	args = []string{
+		"-http-addr=" + srv.httpAddr,
+		"-verify-only",
+		"-",
+	}
+
+	// One of the keys is missing altogether.
+	code = c.Run(args)
+	if code != exitNotFound {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+
+	output := ui.ErrorWriter.String()
+	for _, expected := range []string{
+		"Mismatch: foo (value differs)",
+		"Mismatch: foo/a (flags differ: expected 5, got 6)",
+		"Mismatch: other/b (missing)",
+		"Verification failed for 3 of 3 keys",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Fatalf("bad: expected %q in %#v", expected, output)
+		}
+	}
+
+	pair, _, err := client.KV().Get("foo", nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if string(pair.Value) != "changed" {
+		t.Fatalf("bad: %#v", pair)
+	}
+}
+
+func TestKVImportCommand_Run_dryRun(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	const json = `[
+		{
+			"key": "foo",
+			"flags": 0,
+			"value": "YmFyCg=="
+		},
+		{
+			"key": "foo/a",
+			"flags": 5,
+			"value": "YmF6Cg=="
+		},
+		{
+			"key": "foo/b",
+			"flags": 0,
+			"value": "YmF6Cg=="
+		},
+		{
+			"key": "other/c",
+			"flags": 0,
+			"value": ""
+		}
+	]`
+
+	// One key matches, one has different flags, one has a different value,
+	// and one is missing.
+	for _, pair := range []*api.KVPair{
+		{Key: "foo", Value: []byte("bar\n")},
+		{Key: "foo/a", Flags: 6, Value: []byte("baz\n")},
+		{Key: "foo/b", Value: []byte("changed")},
+	} {
+		if _, err := client.KV().Put(pair, nil); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	ui := new(cli.MockUi)
+	c := &KVImportCommand{
+		Ui:        ui,
+		testStdin: strings.NewReader(json),
+	}
+
+	args := []string{
+		"-http-addr=" + srv.httpAddr,
+		"-dry-run",
+		"-verbose",
+		"-",
+	}
+
+	code := c.Run(args)
+	if code != 2 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+
+	expected := "Unchanged: foo\nUpdate: foo/a\nUpdate: foo/b\nCreate: other/c\n" +
+		"1 create, 2 update, 1 unchanged\n"
+	if output := ui.OutputWriter.String(); output != expected {
+		t.Fatalf("bad: %#v", output)
+	}
+
+	// Nothing should have been written.
+	pair, _, err := client.KV().Get("other/c", nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if pair != nil {
+		t.Fatalf("bad: %#v", pair)
+	}
+
+	// Once imported, a dry run finds nothing to do.
+	c = &KVImportCommand{
+		Ui:        new(cli.MockUi),
+		testStdin: strings.NewReader(json),
+	}
+	if code := c.Run([]string{"-http-addr=" + srv.httpAddr, "-"}); code != 0 {
+		t.Fatalf("bad: %d", code)
+	}
+
+	ui = new(cli.MockUi)
+	c = &KVImportCommand{
+		Ui:        ui,
+		testStdin: strings.NewReader(json),
+	}
+	code = c.Run([]string{"-http-addr=" + srv.httpAddr, "-dry-run", "-"})
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if output := ui.OutputWriter.String(); output != "0 create, 0 update, 4 unchanged\n" {
+		t.Fatalf("bad: %#v", output)
+	}
+}
+
+func TestKVImportCommand_rewriteKeys(t *testing.T) {
+	keys := []string{"app/prod", "app/prod/", "app/prod/db/host", "other"}
+
+	cases := map[string]struct {
+		strip         string
+		prefix        string
+		ignoreMissing bool
+		expected      []string
+		err           string
+	}{
+		"prefix only": {
+			prefix:   "staging/",
+			expected: []string{"staging/app/prod", "staging/app/prod/", "staging/app/prod/db/host", "staging/other"},
+		},
+		"prefix without trailing slash": {
+			prefix:   "/staging",
+			expected: []string{"staging/app/prod", "staging/app/prod/", "staging/app/prod/db/host", "staging/other"},
+		},
+		"strip missing": {
+			strip: "app",
+			err:   `Key "other" does not have prefix "app/"`,
+		},
+		"strip ignore missing": {
+			strip:         "app",
+			ignoreMissing: true,
+			expected:      []string{"prod", "prod/", "prod/db/host", "other"},
+		},
+		"strip to empty": {
+			strip:         "app/prod/",
+			ignoreMissing: true,
+			err:           `Key "app/prod/" would be empty`,
+		},
+		"strip and prefix": {
+			strip:         "app/prod",
+			prefix:        "staging",
+			ignoreMissing: true,
+			expected:      []string{"staging/app/prod", "staging/", "staging/db/host", "staging/other"},
+		},
+	}
+
+	for name, tc := range cases {
+		entries := make([]*kvExportEntry, len(keys))
+		for i, k := range keys {
+			entries[i] = &kvExportEntry{Key: k}
+		}
+
+		err := kvRewriteKeys(entries, tc.strip, tc.prefix, tc.ignoreMissing)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s: expected error %q, got %v", name, tc.err, err)
+			}
+			// Nothing should be rewritten on error.
+			for i, entry := range entries {
+				if entry.Key != keys[i] {
+					t.Errorf("%s: key changed: %s", name, entry.Key)
+				}
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: err: %v", name, err)
+			continue
+		}
+
+		for i, entry := range entries {
+			if entry.Key != tc.expected[i] {
+				t.Errorf("%s: expected %q, got %q", name, tc.expected[i], entry.Key)
+			}
+		}
+	}
+}
+
+func TestKVImportCommand_dedupeEntries(t *testing.T) {
+	entries := []*kvExportEntry{
+		{Key: "a", Value: "MQ=="},
+		{Key: "b", Value: "MQ=="},
+		{Key: "a", Value: "Mg=="},
+		{Key: "c", Value: "MQ=="},
+		{Key: "b", Value: "MQ=="},
+		{Key: "d", Value: "MQ==", Flags: 1},
+		{Key: "a", Value: "Mw=="},
+		{Key: "d", Value: "MQ==", Flags: 2},
+	}
+
+	cases := map[string]struct {
+		lastWins bool
+		expected []string
+	}{
+		"first wins": {false, []string{"a=MQ==", "b=MQ==", "c=MQ==", "d=MQ==/1"}},
+		"last wins":  {true, []string{"a=Mw==", "b=MQ==", "c=MQ==", "d=MQ==/2"}},
+	}
+
+	for name, tc := range cases {
+		kept, dups := kvDedupeEntries(entries, tc.lastWins)
+
+		var actual []string
+		for _, entry := range kept {
+			s := entry.Key + "=" + entry.Value
+			if entry.Flags != 0 {
+				s += fmt.Sprintf("/%d", entry.Flags)
+			}
+			actual = append(actual, s)
+		}
+		if !reflect.DeepEqual(actual, tc.expected) {
+			t.Fatalf("%s: bad: %v", name, actual)
+		}
+
+		expected := []*kvDuplicate{
+			{key: "a", count: 3, conflict: true},
+			{key: "b", count: 2, conflict: false},
+			{key: "d", count: 2, conflict: true},
+		}
+		if !reflect.DeepEqual(dups, expected) {
+			t.Fatalf("%s: bad: %#v", name, dups)
+		}
+	}
+
+	// Without any duplicates, nothing changes.
+	kept, dups := kvDedupeEntries(entries[:2], false)
+	if len(kept) != 2 || len(dups) != 0 {
+		t.Fatalf("bad: %#v %#v", kept, dups)
+	}
+}
+
+func TestKVImportCommand_Run_duplicates(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	// "/dup" and "dup" are the same key once they're normalized.
+	const same = `[
+		{"key": "dup", "flags": 0, "value": "b25l"},
+		{"key": "other", "flags": 0, "value": "eA=="},
+		{"key": "/dup", "flags": 0, "value": "b25l"}
+	]`
+	const conflicting = `[
+		{"key": "dup", "flags": 0, "value": "b25l"},
+		{"key": "other", "flags": 0, "value": "eA=="},
+		{"key": "dup", "flags": 0, "value": "dHdv"},
+		{"key": "flags", "flags": 1, "value": "eA=="},
+		{"key": "flags", "flags": 2, "value": "eA=="}
+	]`
+
+	cases := map[string]struct {
+		data   string
+		args   []string
+		code   int
+		errOut []string
+		value  string
+	}{
+		"same value": {
+			same, nil, 0,
+			[]string{"Warning! Key dup appears 2 times with the same value, importing it once"},
+			"one",
+		},
+		"conflicting": {
+			conflicting, nil, 1,
+			[]string{
+				"Duplicate: dup (2 times)",
+				"Duplicate: flags (2 times)",
+				"Error! The data has 2 keys more than once with different flags or values",
+			},
+			"",
+		},
+		"first wins": {
+			conflicting, []string{"-first-wins"}, 0,
+			[]string{"Warning! Key dup appears 2 times with different values, importing the first one"},
+			"one",
+		},
+		"last wins": {
+			conflicting, []string{"-last-wins"}, 0,
+			[]string{"Warning! Key dup appears 2 times with different values, importing the last one"},
+			"two",
+		},
+		"both": {
+			conflicting, []string{"-first-wins", "-last-wins"}, 1,
+			[]string{"Error! Cannot specify both -first-wins and -last-wins"},
+			"",
+		},
+	}
+
+	for _, name := range []string{"same value", "conflicting", "first wins", "last wins", "both"} {
+		tc := cases[name]
+		if _, err := client.KV().DeleteTree("", nil); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+
+		ui := new(cli.MockUi)
+		c := &KVImportCommand{Ui: ui, testStdin: strings.NewReader(tc.data)}
+		args := append([]string{"-http-addr=" + srv.httpAddr, "-quiet", "-verify"}, tc.args...)
+		code := c.Run(append(args, "-"))
+		if code != tc.code {
+			t.Fatalf("%s: bad: %d. %#v", name, code, ui.ErrorWriter.String())
+		}
+		errOut := ui.ErrorWriter.String()
+		for _, line := range tc.errOut {
+			if !strings.Contains(errOut, line) {
+				t.Fatalf("%s: bad: %#v missing %q", name, errOut, line)
+			}
+		}
+
+		pair, _, err := client.KV().Get("dup", nil)
+		if err != nil {
+			t.Fatalf("%s: err: %v", name, err)
+		}
+		switch {
+		case tc.value == "" && pair != nil:
+			t.Fatalf("%s: bad: %#v", name, pair)
+		case tc.value != "" && (pair == nil || string(pair.Value) != tc.value):
+			t.Fatalf("%s: bad: %#v", name, pair)
+		}
+	}
+}
+
+func TestKVImportCommand_Run_prefix(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	const json = `[
+		{
+			"key": "app/prod/a",
+			"flags": 0,
+			"value": "YmFyCg=="
+		}
+	]`
+
+	ui := new(cli.MockUi)
+	c := &KVImportCommand{
+		Ui:        ui,
+		testStdin: strings.NewReader(json),
+	}
+
+	args := []string{
+		"-http-addr=" + srv.httpAddr,
+		"-strip-prefix=app/prod",
+		"-prefix=staging",
+		"-verify",
+		"-",
+	}
+
+	code := c.Run(args)
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+
+	pair, _, err := client.KV().Get("staging/a", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pair == nil || strings.TrimSpace(string(pair.Value)) != "bar" {
+		t.Fatalf("bad: %#v", pair)
+	}
+}
+
+func TestKVImportCommand_commonPrefix(t *testing.T) {
+	cases := []struct {
+		keys     []string
+		expected string
+	}{
+		{nil, ""},
+		{[]string{"app/db/host"}, "app/db/"},
+		{[]string{"app/db/host", "app/db/port"}, "app/db/"},
+		{[]string{"app/db/host", "app/web/port"}, "app/"},
+		{[]string{"app/a", "app/ab"}, "app/"},
+		{[]string{"app/", "app/a"}, "app/"},
+		{[]string{"app/a", "other/a"}, ""},
+		{[]string{"foo"}, ""},
+	}
+
+	for _, tc := range cases {
+		if actual := kvCommonPrefix(tc.keys); actual != tc.expected {
+			t.Fatalf("%v: expected %q, got %q", tc.keys, tc.expected, actual)
+		}
+	}
+}
+
+func TestKVImportCommand_Run_prune(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	for _, key := range []string{"app/a", "app/old/b", "apple", "other/c", "staging/old"} {
+		if _, err := client.KV().Put(&api.KVPair{Key: key, Value: []byte("old")}, nil); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	const json = `[
+		{
+			"key": "app/a",
+			"flags": 0,
+			"value": "b2xk"
+		},
+		{
+			"key": "app/new",
+			"flags": 0,
+			"value": "bmV3"
+		}
+	]`
+
+	// A dry run lists the whole plan without changing anything.
+	ui := new(cli.MockUi)
+	c := &KVImportCommand{Ui: ui, testStdin: strings.NewReader(json)}
+	code := c.Run([]string{"-http-addr=" + srv.httpAddr, "-prune", "-dry-run", "-verbose", "-"})
+	if code != 2 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	expected := "Unchanged: app/a\nCreate: app/new\nDelete: app/old/b\n" +
+		"1 create, 0 update, 1 unchanged, 1 delete\n"
+	if output := ui.OutputWriter.String(); output != expected {
+		t.Fatalf("bad: %#v", output)
+	}
+	if pair, _, err := client.KV().Get("app/old/b", nil); err != nil || pair == nil {
+		t.Fatalf("bad: %#v, %v", pair, err)
+	}
+
+	ui = new(cli.MockUi)
+	c = &KVImportCommand{Ui: ui, testStdin: strings.NewReader(json)}
+	code = c.Run([]string{"-http-addr=" + srv.httpAddr, "-prune", "-"})
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	expected = "Imported: app/a\nImported: app/new\nPruned: app/old/b\n"
+	if output := ui.OutputWriter.String(); output != expected {
+		t.Fatalf("bad: %#v", output)
+	}
+
+	// Only keys under the common path of the import are pruned.
+	keys, _, err := client.KV().Keys("", "", nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !reflect.DeepEqual(keys, []string{"app/a", "app/new", "apple", "other/c", "staging/old"}) {
+		t.Fatalf("bad: %#v", keys)
+	}
+
+	// With -prefix, the keys under it are pruned.
+	ui = new(cli.MockUi)
+	c = &KVImportCommand{Ui: ui, testStdin: strings.NewReader(json)}
+	code = c.Run([]string{"-http-addr=" + srv.httpAddr, "-prune", "-strip-prefix=app", "-prefix=staging", "-"})
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if output := ui.OutputWriter.String(); !strings.HasSuffix(output, "Pruned: staging/old\n") {
+		t.Fatalf("bad: %#v", output)
+	}
+
+	// Keys with nothing in common would prune the whole KV store.
+	ui = new(cli.MockUi)
+	c = &KVImportCommand{Ui: ui}
+	code = c.Run([]string{
+		"-http-addr=" + srv.httpAddr,
+		"-prune",
+		`[{"key":"app/x","flags":0,"value":""},{"key":"other/x","flags":0,"value":""}]`,
+	})
+	if code != 1 {
+		t.Fatalf("bad: %d", code)
+	}
+	if output := ui.ErrorWriter.String(); !strings.Contains(output, "Cannot prune") {
+		t.Fatalf("bad: %#v", output)
+	}
+	if pair, _, err := client.KV().Get("app/x", nil); err != nil || pair != nil {
+		t.Fatalf("bad: %#v, %v", pair, err)
+	}
+}
+
+func TestKVImportCommand_Run_skipFolders(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	// A folder already at the destination is left alone by the prune.
+	for _, key := range []string{"app/", "app/old/", "app/old/b"} {
+		if _, err := client.KV().Put(&api.KVPair{Key: key}, nil); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	const data = `[
+		{"key": "app/new/", "flags": 0, "value": "", "is_folder": true},
+		{"key": "app/new/a", "flags": 0, "value": "YQ=="},
+		{"key": "app/weird/", "flags": 0, "value": "eA=="},
+		{"key": "app/empty", "flags": 0, "value": ""}
+	]`
+
+	// A dry run doesn't count the folder as a delete.
+	ui := new(cli.MockUi)
+	c := &KVImportCommand{Ui: ui}
+	code := c.Run([]string{"-http-addr=" + srv.httpAddr, "-skip-folders", "-prune", "-dry-run", data})
+	if code != 2 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if output := ui.OutputWriter.String(); output != "3 create, 0 update, 0 unchanged, 1 delete\n" {
+		t.Fatalf("bad: %#v", output)
+	}
+
+	ui = new(cli.MockUi)
+	c = &KVImportCommand{Ui: ui}
+	if code := c.Run([]string{"-http-addr=" + srv.httpAddr, "-skip-folders", "-prune", data}); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if output := ui.ErrorWriter.String(); !strings.HasPrefix(output, "Skipped 1 folder key\n") {
+		t.Fatalf("bad: %#v", output)
+	}
+
+	keys, _, err := client.KV().Keys("", "", nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	expected := []string{"app/", "app/empty", "app/new/a", "app/old/", "app/weird/"}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Fatalf("bad: %#v", keys)
+	}
+}
+
+func TestKVImportCommand_Run_pruneAuditLog(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	for _, key := range []string{"app/a", "app/old/b", "app/old/c"} {
+		if _, err := client.KV().Put(&api.KVPair{Key: key, Value: []byte("old")}, nil); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "consul")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	log := filepath.Join(dir, "audit.log")
+
+	const data = `[{"key":"app/a","flags":0,"value":"b2xk"}]`
+
+	// The audit log only records prunes.
+	ui := new(cli.MockUi)
+	c := &KVImportCommand{Ui: ui}
+	if code := c.Run([]string{"-http-addr=" + srv.httpAddr, "-audit-log=" + log, data}); code != 1 {
+		t.Fatalf("bad: %d", code)
+	}
+	if output := ui.ErrorWriter.String(); !strings.Contains(output, "-audit-log only records the keys deleted by -prune") {
+		t.Fatalf("bad: %#v", output)
+	}
+
+	// Nothing is pruned if the line can't be written.
+	ui = new(cli.MockUi)
+	c = &KVImportCommand{Ui: ui}
+	bad := filepath.Join(dir, "missing", "audit.log")
+	if code := c.Run([]string{"-http-addr=" + srv.httpAddr, "-prune", "-audit-log=" + bad, data}); code != 1 {
+		t.Fatalf("bad: %d", code)
+	}
+	keys, _, err := client.KV().Keys("app/", "", nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(keys) != 3 {
+		t.Fatalf("bad: %#v", keys)
+	}
+
+	ui = new(cli.MockUi)
+	c = &KVImportCommand{Ui: ui}
+	if code := c.Run([]string{"-http-addr=" + srv.httpAddr, "-prune", "-audit-log=" + log, data}); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+
+	raw, err := ioutil.ReadFile(log)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	var entry kvAuditEntry
+	if err := json.Unmarshal(raw, &entry); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if entry.Operation != "prune" || entry.Prefix != "app/" || entry.KeysAffected != 2 ||
+		!reflect.DeepEqual(entry.Keys, []string{"app/old/b", "app/old/c"}) || !entry.CAS {
+		t.Fatalf("bad: %s", raw)
+	}
+}
+
+func TestKVImportCommand_txnBatches(t *testing.T) {
+	var pairs []*api.KVPair
+	for i := 0; i < kvMaxTxnOps+1; i++ {
+		pairs = append(pairs, &api.KVPair{Key: fmt.Sprintf("ops/%d", i)})
+	}
+	big := make([]byte, kvMaxValueSize/2)
+	for i := 0; i < 3; i++ {
+		pairs = append(pairs, &api.KVPair{Key: fmt.Sprintf("big/%d", i), Value: big})
+	}
+
+	batches, err := kvTxnBatches(pairs)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The first batch is capped by the number of operations, and the big
+	// values can only fit two to a transaction.
+	var sizes []int
+	for _, b := range batches {
+		sizes = append(sizes, len(b))
+	}
+	if !reflect.DeepEqual(sizes, []int{kvMaxTxnOps, 3, 1}) {
+		t.Fatalf("bad: %#v", sizes)
+	}
+
+	tooBig := []*api.KVPair{{Key: "huge", Value: make([]byte, kvMaxValueSize+1)}}
+	if _, err := kvTxnBatches(tooBig); err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Fatalf("bad: %v", err)
+	}
+}
+
+func TestKVImportCommand_Run_atomic(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	importEntries := func(entries []*kvExportEntry) (*cli.MockUi, int) {
+		data, err := json.Marshal(entries)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+
+		ui := new(cli.MockUi)
+		c := &KVImportCommand{
+			Ui:        ui,
+			testStdin: bytes.NewReader(data),
+		}
+
+		args := []string{
+			"-http-addr=" + srv.httpAddr,
+			"-atomic",
+			"-",
+		}
+		return ui, c.Run(args)
+	}
+
+	// A successful import spanning several transactions.
+	var entries []*kvExportEntry
+	for i := 0; i < 2*kvMaxTxnOps+1; i++ {
+		entries = append(entries, &kvExportEntry{Key: fmt.Sprintf("ok/%03d", i)})
+	}
+	ui, code := importEntries(entries)
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	keys, _, err := client.KV().Keys("ok/", "", nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(keys) != len(entries) {
+		t.Fatalf("bad: %d", len(keys))
+	}
+
+	// A failure in a single transaction should leave nothing behind. An
+	// empty key is rejected by the servers, rolling back the transaction.
+	entries = []*kvExportEntry{
+		{Key: "single/a"},
+		{Key: ""},
+	}
+	ui, code = importEntries(entries)
+	if code != 1 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	keys, _, err = client.KV().Keys("single/", "", nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(keys) != 0 {
+		t.Fatalf("bad: %#v", keys)
+	}
+
+	// A failure in a later transaction reports what was committed.
+	entries = nil
+	for i := 0; i < kvMaxTxnOps; i++ {
+		entries = append(entries, &kvExportEntry{Key: fmt.Sprintf("multi/%03d", i)})
+	}
+	entries = append(entries, &kvExportEntry{Key: "multi/last"}, &kvExportEntry{Key: ""})
+	ui, code = importEntries(entries)
+	if code != 1 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+
+	output := ui.ErrorWriter.String()
+	for _, expected := range []string{
+		"Failed writing batch 2 of 2",
+		"Committed: multi/000",
+		"Committed: multi/063",
+		"Not committed: multi/last",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Fatalf("bad: expected %q in %#v", expected, output)
+		}
+	}
+	keys, _, err = client.KV().Keys("multi/", "", nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(keys) != kvMaxTxnOps {
+		t.Fatalf("bad: %d", len(keys))
+	}
+}
+
+func TestKVImportCommand_Run_progress(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	var entries []*kvExportEntry
+	for i := 0; i < 5; i++ {
+		entries = append(entries, &kvExportEntry{Key: fmt.Sprintf("app/%d", i)})
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	cases := map[string]struct {
+		args     []string
+		progress bool
+		min      time.Duration
+	}{
+		"progress": {
+			[]string{},
+			true,
+			0,
+		},
+		"quiet": {
+			[]string{"-quiet"},
+			false,
+			0,
+		},
+		// The first write goes straight through, and the rest are spaced
+		// out.
+		"rate limit": {
+			[]string{"-rate-limit=20"},
+			true,
+			200 * time.Millisecond,
+		},
+		// The transaction waits for a write for each of its keys after
+		// the first.
+		"rate limit atomic": {
+			[]string{"-rate-limit=20", "-atomic"},
+			true,
+			200 * time.Millisecond,
+		},
+	}
+
+	for name, tc := range cases {
+		ui := new(cli.MockUi)
+		c := &KVImportCommand{Ui: ui}
+		args := append([]string{"-http-addr=" + srv.httpAddr}, tc.args...)
+
+		start := time.Now()
+		if code := c.Run(append(args, string(data))); code != 0 {
+			t.Fatalf("%s: bad: %d. %#v", name, code, ui.ErrorWriter.String())
+		}
+		if elapsed := time.Since(start); elapsed < tc.min {
+			t.Fatalf("%s: took %s", name, elapsed)
+		}
+
+		output := ui.ErrorWriter.String()
+		if tc.progress != strings.Contains(output, "Progress: 5 of 5 keys written, 0 failed") {
+			t.Fatalf("%s: bad: %#v", name, output)
+		}
+	}
+
+	keys, _, err := client.KV().Keys("app/", "", nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(keys) != len(entries) {
+		t.Fatalf("bad: %#v", keys)
+	}
+}
+
+func TestKVImportCommand_Run_interrupt(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	var entries []*kvExportEntry
+	for i := 0; i < 10; i++ {
+		entries = append(entries, &kvExportEntry{Key: fmt.Sprintf("app/%d", i)})
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Interrupt the import part of the way through, while it's held back by
+	// the rate limit.
+	shutdownCh := make(chan struct{})
+	time.AfterFunc(300*time.Millisecond, func() { close(shutdownCh) })
+
+	ui := new(cli.MockUi)
+	c := &KVImportCommand{Ui: ui, ShutdownCh: shutdownCh}
+	args := []string{"-http-addr=" + srv.httpAddr, "-rate-limit=10", string(data)}
+	if code := c.Run(args); code != exitCancelled {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+
+	output := ui.ErrorWriter.String()
+	if !strings.Contains(output, "Cancelled after writing") {
+		t.Fatalf("bad: %#v", output)
+	}
+	i := strings.Index(output, "Last key written: ")
+	if i < 0 {
+		t.Fatalf("bad: %#v", output)
+	}
+	last := strings.SplitN(output[i+len("Last key written: "):], "\n", 2)[0]
+	if !strings.Contains(output, fmt.Sprintf("-resume-after=%q", last)) {
+		t.Fatalf("bad: %#v", output)
+	}
+
+	keys, _, err := client.KV().Keys("app/", "", nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(keys) == 0 || len(keys) == len(entries) || keys[len(keys)-1] != last {
+		t.Fatalf("bad: %#v", keys)
+	}
+	written := len(keys)
+
+	// Picking up from the last key writes the rest.
+	ui = new(cli.MockUi)
+	c = &KVImportCommand{Ui: ui}
+	args = []string{"-http-addr=" + srv.httpAddr, "-resume-after=" + last, string(data)}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if output := ui.OutputWriter.String(); strings.Contains(output, "Imported: "+last+"\n") ||
+		strings.Count(output, "Imported: ") != len(entries)-written {
+		t.Fatalf("bad: %#v", output)
+	}
+
+	keys, _, err = client.KV().Keys("app/", "", nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(keys) != len(entries) {
+		t.Fatalf("bad: %#v", keys)
+	}
+}
+
+func TestKVImportCommand_Run_stateFile(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	dir, err := ioutil.TempDir("", "kv-import")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	stateFile := filepath.Join(dir, "import.state")
+
+	// The data isn't sorted, but the keys are written in sorted order.
+	var entries []*kvExportEntry
+	for i := 9; i >= 0; i-- {
+		entries = append(entries, &kvExportEntry{Key: fmt.Sprintf("app/%d", i)})
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Fail the import part of the way through.
+	ui := new(cli.MockUi)
+	c := &KVImportCommand{
+		Ui: ui,
+		testPutErr: func(key string) error {
+			if key == "app/5" {
+				return fmt.Errorf("injected")
+			}
+			return nil
+		},
+	}
+	args := []string{"-http-addr=" + srv.httpAddr, "-state-file=" + stateFile, string(data)}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	output := ui.ErrorWriter.String()
+	if !strings.Contains(output, "Stopped after writing 5 of 10 keys") ||
+		!strings.Contains(output, fmt.Sprintf("-state-file=%q", stateFile)) ||
+		strings.Contains(output, "-resume-after") {
+		t.Fatalf("bad: %#v", output)
+	}
+
+	b, err := ioutil.ReadFile(stateFile)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	state := &kvImportState{}
+	if err := json.Unmarshal(b, state); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if state.LastKey != "app/4" {
+		t.Fatalf("bad: %#v", state)
+	}
+
+	// Resuming the import of different data is refused.
+	other, err := json.Marshal(entries[1:])
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	ui = new(cli.MockUi)
+	c = &KVImportCommand{Ui: ui}
+	args = []string{"-http-addr=" + srv.httpAddr, "-state-file=" + stateFile, string(other)}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if output := ui.ErrorWriter.String(); !strings.Contains(output, "is for different data") {
+		t.Fatalf("bad: %#v", output)
+	}
+	if _, err := os.Stat(stateFile); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Running the same import again writes the rest, and removes the state
+	// file.
+	ui = new(cli.MockUi)
+	c = &KVImportCommand{Ui: ui}
+	args = []string{"-http-addr=" + srv.httpAddr, "-state-file=" + stateFile, string(data)}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if output := ui.ErrorWriter.String(); !strings.Contains(output, "Resuming the import after app/4, skipping 5 keys") {
+		t.Fatalf("bad: %#v", output)
+	}
+	if output := ui.OutputWriter.String(); strings.Count(output, "Imported: ") != 5 ||
+		!strings.HasPrefix(output, "Imported: app/5\n") {
+		t.Fatalf("bad: %#v", output)
+	}
+	if _, err := os.Stat(stateFile); !os.IsNotExist(err) {
+		t.Fatalf("err: %v", err)
+	}
+
+	keys, _, err := client.KV().Keys("app/", "", nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(keys) != len(entries) {
+		t.Fatalf("bad: %#v", keys)
+	}
+}
+
+func TestKVImportCommand_Run_inputFormat(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	values := map[string]string{
+		"app/db/host":   "db.example.com",
+		"app/db/port":   "5432",
+		"app/empty/":    "",
+		"app/name":      "ünïcode \"quoted\"\n",
+		"app/web/a/b/c": "deep",
+	}
+	for k, v := range values {
+		if _, err := client.KV().Put(&api.KVPair{Key: k, Value: []byte(v)}, nil); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	original, _, err := client.KV().List("app", nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Export the tree, then convert the export the way the other tools lay
+	// out the same data.
+	stdout := new(bytes.Buffer)
+	ui := new(cli.MockUi)
+	export := &KVExportCommand{Ui: ui, testStdout: stdout}
+	if code := export.Run([]string{"-http-addr=" + srv.httpAddr, "app"}); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	var entries []*kvExportEntry
+	if err := json.Unmarshal(stdout.Bytes(), &entries); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	flat := make(map[string]string)
+	nested := make(map[string]interface{})
+	for _, entry := range entries {
+		value, err := base64.StdEncoding.DecodeString(entry.Value)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		flat[entry.Key] = string(value)
+
+		parts := strings.Split(entry.Key, "/")
+		obj := nested
+		for _, part := range parts[:len(parts)-1] {
+			child, ok := obj[part].(map[string]interface{})
+			if !ok {
+				child = make(map[string]interface{})
+				obj[part] = child
+			}
+			obj = child
+		}
+		if last := parts[len(parts)-1]; last != "" {
+			obj[last] = string(value)
+		}
+	}
+
+	inputs := map[string]interface{}{
+		"consul":    entries,
+		"flat-json": flat,
+		"kvjson":    nested,
+	}
+	for format, input := range inputs {
+		data, err := json.Marshal(input)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if _, err := client.KV().DeleteTree("app", nil); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+
+		ui := new(cli.MockUi)
+		c := &KVImportCommand{Ui: ui}
+		args := []string{
+			"-http-addr=" + srv.httpAddr,
+			"-input-format=" + format,
+			string(data),
+		}
+		if code := c.Run(args); code != 0 {
+			t.Fatalf("%s: bad: %d. %#v", format, code, ui.ErrorWriter.String())
+		}
+
+		imported, _, err := client.KV().List("app", nil)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if len(imported) != len(original) {
+			t.Fatalf("%s: bad: %#v", format, imported)
+		}
+		for i, pair := range imported {
+			o := original[i]
+			if pair.Key != o.Key || pair.Flags != o.Flags || !bytes.Equal(pair.Value, o.Value) {
+				t.Fatalf("%s: bad: %#v != %#v", format, pair, o)
+			}
+		}
+	}
+}
+
+func TestKVImportCommand_Run_foreignFormats(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	// Both fixtures hold the same tree, including a key with a newline,
+	// which Consul can't store.
+	cases := []struct {
+		name     string
+		args     []string
+		code     int
+		expected map[string]string
+		stderr   string
+	}{
+		{
+			"etcd-json",
+			[]string{"-input-format=etcd-json", "@test-fixtures/kv/etcd.json"},
+			1, nil,
+			`Error! Key "/app/bad\nkey" contains a newline. Use -invalid-keys to skip or encode such keys`,
+		},
+		{
+			"etcd-json skip",
+			[]string{"-input-format=etcd-json", "-invalid-keys=skip", "@test-fixtures/kv/etcd.json"},
+			0,
+			map[string]string{
+				"app/db/host": "db.example.com",
+				"app/db/port": "5432",
+				"app/empty":   "",
+				"app/name":    "ünïcode",
+			},
+			`Warning! Key "/app/bad\nkey" contains a newline, skipping it`,
+		},
+		{
+			"etcd-json encode prefix",
+			[]string{"-input-format=etcd-json", "-invalid-keys=encode", "-prefix=etcd",
+				"@test-fixtures/kv/etcd.json"},
+			0,
+			map[string]string{
+				"etcd/app/bad%0Akey": "x",
+				"etcd/app/db/host":   "db.example.com",
+				"etcd/app/db/port":   "5432",
+				"etcd/app/empty":     "",
+				"etcd/app/name":      "ünïcode",
+			},
+			`Warning! Key "/app/bad\nkey" contains a newline, importing it as "app/bad%0Akey"`,
+		},
+		{
+			"zk-dump skip",
+			[]string{"-input-format=zk-dump", "-invalid-keys=skip", "@test-fixtures/kv/zk.json"},
+			0,
+			map[string]string{
+				"app/db/host": "db.example.com",
+				"app/db/port": "5432",
+				"app/empty":   "",
+				"app/name":    "ünïcode",
+			},
+			`Warning! Key "/app/bad\nkey" contains a newline, skipping it`,
+		},
+	}
+
+	for _, tc := range cases {
+		for _, prefix := range []string{"app", "etcd"} {
+			if _, err := client.KV().DeleteTree(prefix, nil); err != nil {
+				t.Fatalf("err: %v", err)
+			}
+		}
+
+		ui := new(cli.MockUi)
+		c := &KVImportCommand{Ui: ui}
+		args := append([]string{"-http-addr=" + srv.httpAddr, "-quiet"}, tc.args...)
+		if code := c.Run(args); code != tc.code {
+			t.Fatalf("%s: bad: %d. %#v", tc.name, code, ui.ErrorWriter.String())
+		}
+		if stderr := ui.ErrorWriter.String(); !strings.Contains(stderr, tc.stderr) {
+			t.Fatalf("%s: bad stderr: %q", tc.name, stderr)
+		}
+
+		pairs, _, err := client.KV().List("", nil)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		actual := make(map[string]string)
+		for _, pair := range pairs {
+			actual[pair.Key] = string(pair.Value)
+		}
+		if len(actual) != len(tc.expected) {
+			t.Fatalf("%s: bad: %#v", tc.name, actual)
+		}
+		for k, v := range tc.expected {
+			if value, ok := actual[k]; !ok || value != v {
+				t.Fatalf("%s: bad: %#v", tc.name, actual)
+			}
+		}
+	}
+}
+
+func TestKVImportCommand_Run_values(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	cases := []struct {
+		name     string
+		args     []string
+		expected map[string]string
+	}{
+		{
+			"padded and unpadded",
+			[]string{`[{"key": "a", "value": "YmFy"}, {"key": "b", "value": "YmE="}, {"key": "c", "value": "YmE"}]`},
+			map[string]string{"a": "bar", "b": "ba", "c": "ba"},
+		},
+		{
+			"url-safe",
+			[]string{`[{"key": "a", "value": "-_8"}, {"key": "b", "value": "-_8="}]`},
+			map[string]string{"a": "\xfb\xff", "b": "\xfb\xff"},
+		},
+		{
+			"raw",
+			[]string{"-values-are-raw", `[{"key": "a", "value": "not base64!"}, {"key": "b", "value": ""}]`},
+			map[string]string{"a": "not base64!", "b": ""},
+		},
+	}
+
+	for _, tc := range cases {
+		if _, err := client.KV().DeleteTree("", nil); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+
+		ui := new(cli.MockUi)
+		c := &KVImportCommand{Ui: ui}
+		args := append([]string{"-http-addr=" + srv.httpAddr, "-quiet"}, tc.args...)
+		if code := c.Run(args); code != 0 {
+			t.Fatalf("%s: bad: %d. %#v", tc.name, code, ui.ErrorWriter.String())
+		}
+
+		pairs, _, err := client.KV().List("", nil)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		actual := make(map[string]string)
+		for _, pair := range pairs {
+			actual[pair.Key] = string(pair.Value)
+		}
+		if !reflect.DeepEqual(actual, tc.expected) {
+			t.Fatalf("%s: bad: %#v", tc.name, actual)
+		}
+	}
+}
+
+func TestKVImportCommand_Run_validateOnly(t *testing.T) {
+	// Nothing listens on this address, so any attempt to use the agent
+	// fails the command.
+	const addr = "-http-addr=127.0.0.1:1"
+
+	ui := new(cli.MockUi)
+	c := &KVImportCommand{Ui: ui}
+	args := []string{addr, "-validate-only", `[{"key": "a", "value": "YQ=="}, {"key": "b", "value": "Yg"}]`}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if output := ui.OutputWriter.String(); !strings.Contains(output, "Validated 2 keys") {
+		t.Fatalf("bad: %q", output)
+	}
+
+	// Every bad entry is reported, both keys and values.
+	ui = new(cli.MockUi)
+	c = &KVImportCommand{Ui: ui}
+	args = []string{addr, "-validate-only",
+		`[{"key": "a", "value": "!"}, {"key": "b\nc", "value": ""}, {"key": "d", "value": "?"}]`}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	stderr := ui.ErrorWriter.String()
+	for _, expected := range []string{
+		`Invalid value: entry 0 (key "a")`,
+		`Invalid value: entry 2 (key "d")`,
+		`Error! Key "b\nc" contains a newline. Use -invalid-keys`,
+	} {
+		if !strings.Contains(stderr, expected) {
+			t.Fatalf("expected %q to contain %q", stderr, expected)
+		}
+	}
+	if ui.OutputWriter != nil && ui.OutputWriter.Len() != 0 {
+		t.Fatalf("bad: %q", ui.OutputWriter.String())
+	}
+}
+
+func TestKVImportCommand_Run_tombstones(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	// One key is created, one updated, one deleted, and one tombstone is
+	// for a key which doesn't exist.
+	const data = `[
+		{"key": "app/absent", "flags": 0, "value": "", "deleted": true},
+		{"key": "app/keep", "flags": 0, "value": "bmV3"},
+		{"key": "app/new", "flags": 0, "value": "bmV3"},
+		{"key": "app/old", "flags": 0, "value": "", "deleted": true}
+	]`
+
+	for _, atomic := range []bool{false, true} {
+		if _, err := client.KV().DeleteTree("app/", nil); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		for _, k := range []string{"app/keep", "app/old"} {
+			if _, err := client.KV().Put(&api.KVPair{Key: k, Value: []byte("old")}, nil); err != nil {
+				t.Fatalf("err: %v", err)
+			}
+		}
+
+		ui := new(cli.MockUi)
+		c := &KVImportCommand{Ui: ui}
+		args := []string{"-http-addr=" + srv.httpAddr, "-dry-run", "-verbose", data}
+		if code := c.Run(args); code != 2 {
+			t.Fatalf("%v: bad: %d. %#v", atomic, code, ui.ErrorWriter.String())
+		}
+		expected := "Unchanged: app/absent\nUpdate: app/keep\nCreate: app/new\nDelete: app/old\n" +
+			"1 create, 1 update, 1 unchanged, 1 delete\n"
+		if output := ui.OutputWriter.String(); output != expected {
+			t.Fatalf("%v: bad: %#v", atomic, output)
+		}
+
+		ui = new(cli.MockUi)
+		c = &KVImportCommand{Ui: ui}
+		args = []string{"-http-addr=" + srv.httpAddr, fmt.Sprintf("-atomic=%v", atomic), "-verify", data}
+		if code := c.Run(args); code != 0 {
+			t.Fatalf("%v: bad: %d. %#v", atomic, code, ui.ErrorWriter.String())
+		}
+		output := ui.OutputWriter.String()
+		for _, line := range []string{"Deleted: app/absent", "Imported: app/keep", "Imported: app/new",
+			"Deleted: app/old", "Verified 4 keys"} {
+			if !strings.Contains(output, line) {
+				t.Fatalf("%v: expected %q in %#v", atomic, line, output)
+			}
+		}
+
+		pairs, _, err := client.KV().List("app/", nil)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		actual := make(map[string]string)
+		for _, pair := range pairs {
+			actual[pair.Key] = string(pair.Value)
+		}
+		if !reflect.DeepEqual(actual, map[string]string{"app/keep": "new", "app/new": "new"}) {
+			t.Fatalf("%v: bad: %#v", atomic, actual)
+		}
+	}
+
+	// A tombstone which conflicts with an entry for the same key is a
+	// duplicate.
+	ui := new(cli.MockUi)
+	c := &KVImportCommand{Ui: ui}
+	args := []string{"-http-addr=" + srv.httpAddr,
+		`[{"key": "app/x", "value": ""}, {"key": "app/x", "value": "", "deleted": true}]`}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if !strings.Contains(ui.ErrorWriter.String(), "Duplicate: app/x (2 times)") {
+		t.Fatalf("bad: %#v", ui.ErrorWriter.String())
+	}
+
+	// Verifying reports a key which a tombstone should have deleted.
+	if _, err := client.KV().Put(&api.KVPair{Key: "app/old", Value: []byte("back")}, nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	ui = new(cli.MockUi)
+	c = &KVImportCommand{Ui: ui}
+	args = []string{"-http-addr=" + srv.httpAddr, "-verify-only", data}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if !strings.Contains(ui.ErrorWriter.String(), "Mismatch: app/old (not deleted)") {
+		t.Fatalf("bad: %#v", ui.ErrorWriter.String())
+	}
+}
+
+func TestKVImportCommand_Run_tombstoneRoundTrip(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	dir, err := ioutil.TempDir("", "kv-import")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	put := func(key, value string) {
+		if _, err := client.KV().Put(&api.KVPair{Key: key, Value: []byte(value)}, nil); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	export := func(path string, args ...string) {
+		ui := new(cli.MockUi)
+		stdout := new(bytes.Buffer)
+		c := &KVExportCommand{Ui: ui, testStdout: stdout}
+		args = append([]string{"-http-addr=" + srv.httpAddr, "-output=" + path}, args...)
+		if code := c.Run(append(args, "src/")); code != 0 {
+			t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+		}
+	}
+	importFile := func(path, format string) {
+		ui := new(cli.MockUi)
+		c := &KVImportCommand{Ui: ui}
+		args := []string{"-http-addr=" + srv.httpAddr, "-format=" + format, "-strip-prefix=src/", "-prefix=dst/",
+			"@" + path}
+		if code := c.Run(args); code != 0 {
+			t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+		}
+	}
+	tree := func(prefix string) map[string]string {
+		pairs, _, err := client.KV().List(prefix, nil)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		tree := make(map[string]string)
+		for _, pair := range pairs {
+			tree[strings.TrimPrefix(pair.Key, prefix)] = string(pair.Value)
+		}
+		return tree
+	}
+
+	for _, format := range []string{"json", "yaml"} {
+		for _, prefix := range []string{"src/", "dst/"} {
+			if _, err := client.KV().DeleteTree(prefix, nil); err != nil {
+				t.Fatalf("err: %v", err)
+			}
+		}
+		put("src/a", "1")
+		put("src/b", "2")
+		put("src/c", "3")
+		put("dst/other", "kept")
+
+		first := filepath.Join(dir, "first."+format)
+		export(first, "-format="+format)
+		importFile(first, format)
+
+		// Create, update, and delete keys, then export again, merging in
+		// the deletions since the first export.
+		put("src/b", "changed")
+		put("src/d", "4")
+		if _, err := client.KV().Delete("src/a", nil); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if _, err := client.KV().Delete("src/c", nil); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		second := filepath.Join(dir, "second."+format)
+		export(second, "-format="+format, "-merge-deletions="+first)
+		importFile(second, format)
+
+		expected := tree("src/")
+		expected["other"] = "kept"
+		if actual := tree("dst/"); !reflect.DeepEqual(actual, expected) {
+			t.Fatalf("%s: bad: %#v", format, actual)
+		}
+
+		// The tombstones carry over to the next export while the keys are
+		// still gone.
+		third := filepath.Join(dir, "third."+format)
+		export(third, "-format="+format, "-merge-deletions="+second)
+		contents, err := ioutil.ReadFile(third)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if n := strings.Count(string(contents), "deleted"); n != 2 {
+			t.Fatalf("%s: bad: %s", format, contents)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package command
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/mitchellh/cli"
+)
+
+// kvDefaultMaxKeyLength is the default for -max-key-length.
+const kvDefaultMaxKeyLength = 1024
+
+// kvKeyOptsText describes the flags registered by newKVKeyFlags.
+var kvKeyOptsText = strings.TrimSpace(`
+KV Key Options:
+
+  -allow-whitespace       Allow keys which start or end with whitespace. These
+                          are usually a mistake, such as a stray space from
+                          copying a key, so they are rejected by default.
+                          The default value is false.
+
+  -max-key-length=<int>   Maximum length of a key in bytes. Longer keys are
+                          rejected before any request is made. The default
+                          value is 1024, and 0 means no limit.
+`)
+
+// kvKeyFlags holds the flags which control how the keys given to the KV
+// commands are checked.
+type kvKeyFlags struct {
+	allowWhitespace bool
+	maxLength       int
+}
+
+// newKVKeyFlags registers the key flags on the given flagset, returning the
+// values they will be parsed into.
+func newKVKeyFlags(f *flag.FlagSet) *kvKeyFlags {
+	k := &kvKeyFlags{}
+	f.BoolVar(&k.allowWhitespace, "allow-whitespace", false, "")
+	f.IntVar(&k.maxLength, "max-key-length", kvDefaultMaxKeyLength, "")
+	return k
+}
+
+// normalize checks a key given by the user and returns it in the form it's
+// stored in: without leading slashes, since keys can't start with one, and
+// with repeated slashes collapsed into one. Along with the key, it returns a
+// description of each change made, apart from removing a single leading
+// slash, which is so common that it's done quietly. Keys with newlines or
+// other control characters, invalid UTF-8, or leading or trailing whitespace
+// are rejected, as are keys longer than the maximum length once normalized.
+func (k *kvKeyFlags) normalize(key string) (string, []string, error) {
+	if !utf8.ValidString(key) {
+		return "", nil, fmt.Errorf("Key %q is not valid UTF-8", key)
+	}
+	for _, r := range key {
+		switch {
+		case r == '\n' || r == '\r':
+			return "", nil, fmt.Errorf("Key %q contains a newline", key)
+		case unicode.IsControl(r):
+			return "", nil, fmt.Errorf("Key %q contains the control character %U", key, r)
+		}
+	}
+	var notes []string
+	trimmed := strings.TrimLeft(key, "/")
+	if n := len(key) - len(trimmed); n > 1 {
+		notes = append(notes, fmt.Sprintf("removed %d leading slashes", n))
+	}
+
+	var b bytes.Buffer
+	collapsed := 0
+	for i := 0; i < len(trimmed); i++ {
+		if trimmed[i] == '/' && i > 0 && trimmed[i-1] == '/' {
+			collapsed++
+			continue
+		}
+		b.WriteByte(trimmed[i])
+	}
+	if collapsed > 0 {
+		slashes := "slashes"
+		if collapsed == 1 {
+			slashes = "slash"
+		}
+		notes = append(notes, fmt.Sprintf("collapsed %d repeated %s", collapsed, slashes))
+	}
+	normalized := b.String()
+
+	if !k.allowWhitespace && strings.TrimSpace(normalized) != normalized {
+		return "", nil, fmt.Errorf("Key %q starts or ends with whitespace "+
+			"(use -allow-whitespace if this is intended)", key)
+	}
+	if k.maxLength > 0 && len(normalized) > k.maxLength {
+		return "", nil, fmt.Errorf("Key %q is too long (%d > %d bytes, see -max-key-length)",
+			normalized, len(normalized), k.maxLength)
+	}
+	return normalized, notes, nil
+}
+
+// encode percent-encodes the characters of a key which normalize rejects:
+// control characters, bytes which aren't valid UTF-8, and whitespace at
+// either end unless it's allowed. Any "%" is encoded too, so the original key
+// can be recovered from the result. A key which is too long is still
+// rejected once encoded.
+func (k *kvKeyFlags) encode(key string) string {
+	start := len(key) - len(strings.TrimLeftFunc(key, unicode.IsSpace))
+	end := len(strings.TrimRightFunc(key, unicode.IsSpace))
+
+	var b bytes.Buffer
+	for i := 0; i < len(key); {
+		r, size := utf8.DecodeRuneInString(key[i:])
+		edge := !k.allowWhitespace && (i < start || i >= end)
+		if (r == utf8.RuneError && size == 1) || r == '%' || unicode.IsControl(r) || edge {
+			for _, c := range []byte(key[i : i+size]) {
+				fmt.Fprintf(&b, "%%%02X", c)
+			}
+		} else {
+			b.WriteString(key[i : i+size])
+		}
+		i += size
+	}
+	return b.String()
+}
+
+// check normalizes the key as normalize does, reporting any changes made to
+// it as a warning.
+func (k *kvKeyFlags) check(ui cli.Ui, key string) (string, error) {
+	normalized, notes, err := k.normalize(key)
+	if err != nil {
+		return "", err
+	}
+	if len(notes) > 0 {
+		ui.Warn(fmt.Sprintf("Normalized key %q to %q: %s", key, normalized, strings.Join(notes, ", ")))
+	}
+	return normalized, nil
+}
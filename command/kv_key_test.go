@@ -0,0 +1,221 @@
+package command
+
+import (
+	"flag"
+	"math/rand"
+	"strings"
+	"testing"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/mitchellh/cli"
+)
+
+func TestKVKeyFlags_normalize(t *testing.T) {
+	cases := []struct {
+		key      string
+		expected string
+		notes    string
+		err      string
+	}{
+		{"", "", "", ""},
+		{"foo", "foo", "", ""},
+		{"foo/bar/", "foo/bar/", "", ""},
+		{"/foo", "foo", "", ""},
+		{"/", "", "", ""},
+		{"//foo", "foo", "removed 2 leading slashes", ""},
+		{"///", "", "removed 3 leading slashes", ""},
+		{"foo//bar", "foo/bar", "collapsed 1 repeated slash", ""},
+		{"foo///bar//", "foo/bar/", "collapsed 3 repeated slashes", ""},
+		{"//a//b", "a/b", "removed 2 leading slashes, collapsed 1 repeated slash", ""},
+		{"foo bar", "foo bar", "", ""},
+		{"ünïcødé/ключ", "ünïcødé/ключ", "", ""},
+		{"foo\nbar", "", "", "contains a newline"},
+		{"foo\r", "", "", "contains a newline"},
+		{"foo\x00", "", "", "control character U+0000"},
+		{"foo\tbar", "", "", "control character U+0009"},
+		{"\xff", "", "", "not valid UTF-8"},
+		{" foo", "", "", "starts or ends with whitespace"},
+		{"foo ", "", "", "starts or ends with whitespace"},
+		{"/foo ", "", "", "starts or ends with whitespace"},
+		{strings.Repeat("a", kvDefaultMaxKeyLength), strings.Repeat("a", kvDefaultMaxKeyLength), "", ""},
+		{strings.Repeat("a", kvDefaultMaxKeyLength+1), "", "", "is too long"},
+		{"/" + strings.Repeat("a", kvDefaultMaxKeyLength), strings.Repeat("a", kvDefaultMaxKeyLength), "", ""},
+	}
+
+	k := newKVKeyFlags(flag.NewFlagSet("test", flag.ContinueOnError))
+	for _, tc := range cases {
+		key, notes, err := k.normalize(tc.key)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("%q: bad: %v", tc.key, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%q: err: %v", tc.key, err)
+		}
+		if key != tc.expected || strings.Join(notes, ", ") != tc.notes {
+			t.Fatalf("%q: bad: %q %#v", tc.key, key, notes)
+		}
+	}
+
+	// The flags relax the checks.
+	f := flag.NewFlagSet("test", flag.ContinueOnError)
+	k = newKVKeyFlags(f)
+	if err := f.Parse([]string{"-allow-whitespace", "-max-key-length=0"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	for _, key := range []string{" foo ", strings.Repeat("a", 10*kvDefaultMaxKeyLength)} {
+		if normalized, _, err := k.normalize(key); err != nil || normalized != key {
+			t.Fatalf("%q: bad: %q %v", key, normalized, err)
+		}
+	}
+	if _, _, err := k.normalize(" foo\n"); err == nil {
+		t.Fatalf("should fail")
+	}
+}
+
+func TestKVKeyFlags_encode(t *testing.T) {
+	cases := []struct {
+		key      string
+		expected string
+	}{
+		{"foo/bar", "foo/bar"},
+		{"foo\nbar", "foo%0Abar"},
+		{"100%\t", "100%25%09"},
+		{"\xffkey", "%FFkey"},
+		{" foo bar ", "%20foo bar%20"},
+		{"ünïcødé\x00", "ünïcødé%00"},
+	}
+
+	k := newKVKeyFlags(flag.NewFlagSet("test", flag.ContinueOnError))
+	for _, tc := range cases {
+		encoded := k.encode(tc.key)
+		if encoded != tc.expected {
+			t.Fatalf("%q: bad: %q", tc.key, encoded)
+		}
+		if _, _, err := k.normalize(encoded); err != nil {
+			t.Fatalf("%q: err: %v", tc.key, err)
+		}
+	}
+}
+
+// TestKVKeyFlags_normalizeRandom checks normalize against random keys built
+// from characters which are likely to cause trouble. Any key which is
+// accepted must come out in the stored form, and normalizing it again must
+// leave it alone.
+func TestKVKeyFlags_normalizeRandom(t *testing.T) {
+	alphabet := []string{"/", "/", "/", "a", "b", ".", " ", "\t", "\n", "\r", "\x00", "\x7f",
+		" ", " ", "é", "\xff", "\xc3", "%", "?", "#", "\\"}
+	k := newKVKeyFlags(flag.NewFlagSet("test", flag.ContinueOnError))
+	k.maxLength = 16
+
+	r := rand.New(rand.NewSource(42))
+	for i := 0; i < 10000; i++ {
+		var b []string
+		for n := r.Intn(20); n > 0; n-- {
+			b = append(b, alphabet[r.Intn(len(alphabet))])
+		}
+		input := strings.Join(b, "")
+
+		key, notes, err := k.normalize(input)
+		if err != nil {
+			if key != "" || notes != nil {
+				t.Fatalf("%q: bad: %q %#v", input, key, notes)
+			}
+			continue
+		}
+
+		switch {
+		case !utf8.ValidString(key),
+			strings.HasPrefix(key, "/"),
+			strings.Contains(key, "//"),
+			strings.TrimSpace(key) != key,
+			len(key) > k.maxLength,
+			strings.IndexFunc(key, unicode.IsControl) >= 0:
+			t.Fatalf("%q: bad: %q", input, key)
+		}
+		if strings.Replace(input, "/", "", -1) != strings.Replace(key, "/", "", -1) {
+			t.Fatalf("%q: more than slashes changed: %q", input, key)
+		}
+		if again, notes, err := k.normalize(key); err != nil || again != key || len(notes) != 0 {
+			t.Fatalf("%q: not idempotent: %q %#v %v", input, again, notes, err)
+		}
+	}
+}
+
+func TestKVKeyFlags_commands(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	// A repeated slash is collapsed, and the change is reported.
+	ui := new(cli.MockUi)
+	put := &KVPutCommand{Ui: ui}
+	if code := put.Run([]string{"-http-addr=" + srv.httpAddr, "//app//config", "1"}); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if output := ui.ErrorWriter.String(); !strings.Contains(output,
+		`Normalized key "//app//config" to "app/config": removed 2 leading slashes, collapsed 1 repeated slash`) {
+		t.Fatalf("bad: %#v", output)
+	}
+	if pair, _, err := client.KV().Get("app/config", nil); err != nil || pair == nil || string(pair.Value) != "1" {
+		t.Fatalf("bad: %#v %v", pair, err)
+	}
+
+	// Every command rejects the same keys before making any requests.
+	cases := map[string]cli.Command{
+		"get":    &KVGetCommand{Ui: new(cli.MockUi)},
+		"put":    &KVPutCommand{Ui: new(cli.MockUi)},
+		"delete": &KVDeleteCommand{Ui: new(cli.MockUi)},
+		"export": &KVExportCommand{Ui: new(cli.MockUi)},
+	}
+	for name, c := range cases {
+		for _, key := range []string{"app/config ", "app\nconfig"} {
+			if code := c.Run([]string{"-http-addr=" + srv.httpAddr, key}); code != 1 {
+				t.Fatalf("%s %q: bad: %d", name, key, code)
+			}
+		}
+	}
+
+	// Whitespace can be allowed.
+	ui = new(cli.MockUi)
+	put = &KVPutCommand{Ui: ui}
+	if code := put.Run([]string{"-http-addr=" + srv.httpAddr, "-allow-whitespace", "app/ spaced ", "2"}); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if pair, _, err := client.KV().Get("app/ spaced ", nil); err != nil || pair == nil {
+		t.Fatalf("bad: %#v %v", pair, err)
+	}
+
+	// Imported keys are normalized too, including a leading slash.
+	ui = new(cli.MockUi)
+	imp := &KVImportCommand{Ui: ui}
+	data := `[{"key": "/imported//key", "flags": 0, "value": "Mw=="}]`
+	if code := imp.Run([]string{"-http-addr=" + srv.httpAddr, data}); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	keys, _, err := client.KV().Keys("imported", "", nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(keys) != 1 || keys[0] != "imported/key" {
+		t.Fatalf("bad: %#v", keys)
+	}
+
+	// Keys from KEY=VALUE arguments are normalized before duplicates are
+	// merged.
+	ui = new(cli.MockUi)
+	put = &KVPutCommand{Ui: ui}
+	if code := put.Run([]string{"-http-addr=" + srv.httpAddr, "-pairs", "multi/a=1", "/multi//a=2"}); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	pairs, _, err := client.KV().List("multi", nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(pairs) != 1 || string(pairs[0].Value) != "2" {
+		t.Fatalf("bad: %#v", pairs)
+	}
+}
@@ -0,0 +1,415 @@
+package command
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+)
+
+const (
+	// kvLockSessionTTL is the default TTL of the session holding the lock.
+	// The session is renewed in the background, so this only bounds how long
+	// the lock outlives the command if it dies without releasing it.
+	kvLockSessionTTL = 15 * time.Second
+
+	// kvLockRetryWait is the time to wait before trying to acquire a lock
+	// which has no holder, but can't be acquired because of the lock-delay
+	// of a session which was invalidated while holding it.
+	kvLockRetryWait = time.Second
+
+	// kvLockMonitorWait is the wait time used for each blocking query while
+	// watching for the lock to be lost.
+	kvLockMonitorWait = 5 * time.Minute
+)
+
+// KVLockCommand is a Command implementation that is used to run a command
+// while holding a lock on a key in the key-value store.
+type KVLockCommand struct {
+	Ui         cli.Ui
+	ShutdownCh <-chan struct{}
+}
+
+func (c *KVLockCommand) Synopsis() string {
+	return "Runs a command while holding a lock on a key in the KV store"
+}
+
+func (c *KVLockCommand) Help() string {
+	helpText := `
+Usage: consul kv lock [options] KEY [--] COMMAND [ARGS...]
+
+  Acquires a lock on the given key using a new session, then runs the command
+  while holding it. The lock is released when the command exits:
+
+      $ consul kv lock service/web/deploy -- ./deploy.sh production
+
+  If another session holds the lock, this waits for it to be released, unless
+  the "-try" option is given.
+
+  The session is renewed in the background while the command runs. If the
+  lock is lost, such as when the session is invalidated, the command is sent
+  a SIGTERM and given the -grace-period to exit before it's killed. An
+  interrupt is passed on to the command in the same way. The command sees
+  CONSUL_LOCK_HELD=true and the ID of the session in CONSUL_LOCK_SESSION in
+  its environment.
+
+  The value and flags of the key are left as they are. The exit status is
+  that of the command, or 1 if the lock was lost.
+
+  For a full list of options and examples, please see the Consul documentation.
+
+` + apiOptsText + `
+
+KV Lock Options:
+
+  -grace-period=<dur>     Time to allow the command to exit after a SIGTERM
+                          before it's killed. The default value is 5s.
+
+  -name=<string>          Name of the session used to hold the lock. The
+                          default includes the command and the key.
+
+  -session-ttl=<dur>      TTL of the session used to hold the lock. If the
+                          session isn't renewed within this time, such as when
+                          this process is killed, the lock is released. The
+                          default value is 15s.
+
+  -try                    Fail right away if the lock is held by another
+                          session, instead of waiting for it. The default
+                          value is false.
+
+  -wait=<duration>        Maximum time to wait to acquire the lock. This
+                          can't be combined with -try. The default value is 0,
+                          which means to wait until the lock is acquired.
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *KVLockCommand) Run(args []string) (code int) {
+	cmdFlags, apiFlags := NewAPIFlagSet("lock", c.Ui)
+	defer apiFlags.CheckTimeout(c.Ui, &code)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	ttl := cmdFlags.Duration("session-ttl", kvLockSessionTTL, "")
+	grace := cmdFlags.Duration("grace-period", lockKillGracePeriod, "")
+	name := cmdFlags.String("name", "", "")
+	try := cmdFlags.Bool("try", false, "")
+	wait := cmdFlags.Duration("wait", 0, "")
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+
+	args = cmdFlags.Args()
+	if len(args) == 0 {
+		c.Ui.Error("Error! Missing KEY argument")
+		return 1
+	}
+	key := strings.TrimPrefix(args[0], "/")
+	command := args[1:]
+	if len(command) > 0 && command[0] == "--" {
+		command = command[1:]
+	}
+
+	var errs []string
+	if key == "" {
+		errs = append(errs, "Error! Missing KEY argument")
+	}
+	if len(command) == 0 {
+		errs = append(errs, "Error! Missing command to run")
+	}
+	if *ttl <= 0 {
+		errs = append(errs, "Error! -session-ttl must be positive")
+	}
+	if *grace <= 0 {
+		errs = append(errs, "Error! -grace-period must be positive")
+	}
+	if *wait < 0 {
+		errs = append(errs, "Error! -wait must not be negative")
+	}
+	if *try && *wait != 0 {
+		errs = append(errs, "Error! Cannot specify both -try and -wait")
+	}
+	if len(errs) > 0 {
+		for _, err := range errs {
+			c.Ui.Error(err)
+		}
+		return 1
+	}
+
+	if *name == "" {
+		*name = fmt.Sprintf("Consul kv lock for '%s' at '%s'", strings.Join(command, " "), key)
+	}
+
+	client, err := apiFlags.Client()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+		return 1
+	}
+	wo := apiFlags.WriteOptions()
+
+	session, _, err := client.Session().Create(&api.SessionEntry{
+		Name:     *name,
+		TTL:      ttl.String(),
+		Behavior: api.SessionBehaviorRelease,
+	}, wo)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error creating session: %s", err))
+		return exitCommError
+	}
+
+	// Keep the session alive until we're done, then destroy it rather than
+	// leaving it to expire.
+	renewDoneCh := make(chan struct{})
+	renewErrCh := make(chan error, 1)
+	go func() {
+		renewErrCh <- client.Session().RenewPeriodic(ttl.String(), session, wo, renewDoneCh)
+	}()
+	defer func() {
+		close(renewDoneCh)
+		client.Session().Destroy(session, wo)
+	}()
+
+	if code := c.acquire(client, key, session, *try, *wait); code != 0 {
+		return code
+	}
+
+	lostCh := make(chan struct{})
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go c.monitor(client, key, session, lostCh, stopCh)
+
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Env = append(os.Environ(),
+		"CONSUL_LOCK_HELD=true",
+		"CONSUL_LOCK_SESSION="+session,
+	)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		c.Ui.Error(fmt.Sprintf("Error starting command: %s", err))
+		c.release(client, key, session)
+		return 1
+	}
+
+	childDoneCh := make(chan struct{})
+	var childErr error
+	go func() {
+		childErr = cmd.Wait()
+		close(childDoneCh)
+	}()
+
+	lost := false
+	select {
+	case <-childDoneCh:
+	case <-c.ShutdownCh:
+		c.killChild(cmd.Process, *grace, childDoneCh)
+	case <-lostCh:
+		lost = true
+	case err := <-renewErrCh:
+		c.Ui.Error(fmt.Sprintf("Error renewing session: %s", err))
+		lost = true
+	}
+
+	if lost {
+		c.Ui.Error(fmt.Sprintf("Error! Lock was lost: %s", key))
+		c.killChild(cmd.Process, *grace, childDoneCh)
+		return 1
+	}
+
+	if code := c.release(client, key, session); code != 0 {
+		return code
+	}
+	return exitStatus(childErr)
+}
+
+// acquire waits for the lock on the key to be acquired by the session. It
+// returns 0 once it's held, or the exit code for the command after reporting
+// any error.
+func (c *KVLockCommand) acquire(client *api.Client, key, session string, try bool, wait time.Duration) int {
+	var deadline time.Time
+	if wait > 0 {
+		deadline = time.Now().Add(wait)
+	}
+	timedOut := func() bool {
+		return !deadline.IsZero() && !time.Now().Before(deadline)
+	}
+
+	q := &api.QueryOptions{}
+	for {
+		pair, meta, err, ok := c.get(client, key, q)
+		if !ok {
+			c.Ui.Error("Shutdown triggered during lock acquisition")
+			return 1
+		}
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Error querying Consul agent: %s", err))
+			return exitCommError
+		}
+
+		if pair != nil && pair.Session != "" && pair.Session != session {
+			if try {
+				c.Ui.Error(fmt.Sprintf("Error! Lock on %s is held by session %s", key, pair.Session))
+				return 1
+			}
+			if timedOut() {
+				c.Ui.Error(fmt.Sprintf("Error! Timed out after %s waiting for the lock on %s", wait, key))
+				return 1
+			}
+
+			// Wait for the holder to let go.
+			q.WaitIndex = meta.LastIndex
+			q.WaitTime = 0
+			if !deadline.IsZero() {
+				q.WaitTime = deadline.Sub(time.Now())
+			}
+			continue
+		}
+
+		// Acquiring also writes the value, so write back what's there.
+		entry := &api.KVPair{Key: key, Session: session}
+		if pair != nil {
+			entry.Flags = pair.Flags
+			entry.Value = pair.Value
+		}
+		acquired, _, err := client.KV().Acquire(entry, nil)
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Error acquiring lock: %s", err))
+			return exitCommError
+		}
+		if acquired {
+			return 0
+		}
+
+		// The lock is free but the lock-delay of its last holder hasn't
+		// passed, or someone else got in first.
+		if try {
+			c.Ui.Error(fmt.Sprintf("Error! Lock on %s is not available", key))
+			return 1
+		}
+		if timedOut() {
+			c.Ui.Error(fmt.Sprintf("Error! Timed out after %s waiting for the lock on %s", wait, key))
+			return 1
+		}
+		select {
+		case <-time.After(kvLockRetryWait):
+		case <-c.ShutdownCh:
+			c.Ui.Error("Shutdown triggered during lock acquisition")
+			return 1
+		}
+		q.WaitIndex = 0
+	}
+}
+
+// get reads the key, giving up if a shutdown is triggered while waiting on a
+// blocking query. The last result is false if it gave up.
+func (c *KVLockCommand) get(client *api.Client, key string, q *api.QueryOptions) (*api.KVPair, *api.QueryMeta, error, bool) {
+	type result struct {
+		pair *api.KVPair
+		meta *api.QueryMeta
+		err  error
+	}
+	ch := make(chan result, 1)
+	go func(q api.QueryOptions) {
+		pair, meta, err := client.KV().Get(key, &q)
+		ch <- result{pair, meta, err}
+	}(*q)
+
+	select {
+	case res := <-ch:
+		return res.pair, res.meta, res.err, true
+	case <-c.ShutdownCh:
+		return nil, nil, nil, false
+	}
+}
+
+// monitor watches the key and closes lostCh if the session stops holding
+// the lock. Errors are retried a few times before the lock is assumed lost.
+func (c *KVLockCommand) monitor(client *api.Client, key, session string, lostCh, stopCh chan struct{}) {
+	q := &api.QueryOptions{WaitTime: kvLockMonitorWait}
+	retries := defaultMonitorRetry
+	for {
+		pair, meta, err := client.KV().Get(key, q)
+		select {
+		case <-stopCh:
+			return
+		default:
+		}
+
+		if err != nil {
+			if retries > 0 {
+				retries--
+				time.Sleep(defaultMonitorRetryTime)
+				continue
+			}
+			close(lostCh)
+			return
+		}
+		retries = defaultMonitorRetry
+
+		if pair == nil || pair.Session != session {
+			close(lostCh)
+			return
+		}
+		q.WaitIndex = meta.LastIndex
+	}
+}
+
+// release gives up the lock, leaving the value of the key as it is now. It
+// returns the exit code for the command if this fails.
+func (c *KVLockCommand) release(client *api.Client, key, session string) int {
+	pair, _, err := client.KV().Get(key, nil)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error releasing lock: %s", err))
+		return exitCommError
+	}
+	if pair == nil || pair.Session != session {
+		// Someone else deleted or took the key, so there's nothing to do.
+		return 0
+	}
+
+	pair.Session = session
+	if _, _, err := client.KV().Release(pair, nil); err != nil {
+		c.Ui.Error(fmt.Sprintf("Error releasing lock: %s", err))
+		return exitCommError
+	}
+	return 0
+}
+
+// killChild sends the child a SIGTERM, and kills it if it hasn't exited
+// after the grace period, or if another shutdown is triggered first. It
+// returns once the child has exited.
+func (c *KVLockCommand) killChild(child *os.Process, grace time.Duration, doneCh chan struct{}) {
+	if err := signalPid(child.Pid, syscall.SIGTERM); err != nil {
+		c.Ui.Error(fmt.Sprintf("Failed to terminate %d: %v", child.Pid, err))
+	}
+
+	select {
+	case <-doneCh:
+		return
+	case <-time.After(grace):
+	case <-c.ShutdownCh:
+	}
+
+	if err := signalPid(child.Pid, syscall.SIGKILL); err != nil {
+		c.Ui.Error(fmt.Sprintf("Failed to kill %d: %v", child.Pid, err))
+	}
+	<-doneCh
+}
+
+// exitStatus returns the exit code for an error from running a command,
+// which is 1 if the command didn't exit normally.
+func exitStatus(err error) int {
+	if err == nil {
+		return 0
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.ExitStatus() > 0 {
+			return status.ExitStatus()
+		}
+	}
+	return 1
+}
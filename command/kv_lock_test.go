@@ -0,0 +1,344 @@
+package command
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+)
+
+func TestKVLockCommand_implements(t *testing.T) {
+	var _ cli.Command = &KVLockCommand{}
+}
+
+func TestKVLockCommand_noTabs(t *testing.T) {
+	assertNoTabs(t, new(KVLockCommand))
+}
+
+func TestKVLockCommand_Validation(t *testing.T) {
+	cases := map[string]struct {
+		args   []string
+		output string
+	}{
+		"no key": {
+			[]string{},
+			"Missing KEY argument",
+		},
+		"no command": {
+			[]string{"foo", "--"},
+			"Missing command to run",
+		},
+		"bad ttl": {
+			[]string{"-session-ttl=0s", "foo", "true"},
+			"-session-ttl must be positive",
+		},
+		"bad grace period": {
+			[]string{"-grace-period=-1s", "foo", "true"},
+			"-grace-period must be positive",
+		},
+		"-try with -wait": {
+			[]string{"-try", "-wait=1s", "foo", "true"},
+			"Cannot specify both -try and -wait",
+		},
+	}
+
+	for name, tc := range cases {
+		ui := new(cli.MockUi)
+		c := &KVLockCommand{Ui: ui}
+
+		if code := c.Run(tc.args); code != 1 {
+			t.Fatalf("%s: bad: %d", name, code)
+		}
+		if output := ui.ErrorWriter.String(); !strings.Contains(output, tc.output) {
+			t.Fatalf("%s: expected %q to contain %q", name, output, tc.output)
+		}
+	}
+}
+
+// testPath is the PATH the tests were started with, since other tests clear
+// the environment.
+var testPath = os.Getenv("PATH")
+
+// testKVLockDir returns a temporary directory for the files written by the
+// commands run under the lock, and makes sure the commands can be found.
+func testKVLockDir(t *testing.T) (string, func()) {
+	os.Setenv("PATH", testPath)
+
+	dir, err := ioutil.TempDir("", "consul")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+// waitForFile waits for the file to be written, returning its contents. This
+// is safe to call from other goroutines.
+func waitForFile(t *testing.T, path string) string {
+	for i := 0; i < 100; i++ {
+		if data, err := ioutil.ReadFile(path); err == nil && len(data) > 0 {
+			return strings.TrimSpace(string(data))
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Errorf("timed out waiting for %s", path)
+	return ""
+}
+
+func TestKVLockCommand_Run(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	dir, cleanup := testKVLockDir(t)
+	defer cleanup()
+	out := filepath.Join(dir, "out")
+
+	pair := &api.KVPair{Key: "locks/foo", Flags: 42, Value: []byte("keep")}
+	if _, err := client.KV().Put(pair, nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The command is told about the session, and its exit code is passed on.
+	ui := new(cli.MockUi)
+	c := &KVLockCommand{Ui: ui}
+	args := []string{
+		"-http-addr=" + srv.httpAddr,
+		"locks/foo",
+		"--",
+		"sh", "-c",
+		"echo $CONSUL_LOCK_HELD $CONSUL_LOCK_SESSION > " + out + "; exit 7",
+	}
+	if code := c.Run(args); code != 7 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) != 2 || fields[0] != "true" || fields[1] == "" {
+		t.Fatalf("bad: %q", data)
+	}
+	session := fields[1]
+
+	// The lock is released and the session destroyed, but the value and
+	// flags are left alone.
+	pair, _, err = client.KV().Get("locks/foo", nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if pair == nil || pair.Session != "" || pair.LockIndex != 1 ||
+		pair.Flags != 42 || string(pair.Value) != "keep" {
+		t.Fatalf("bad: %#v", pair)
+	}
+	info, _, err := client.Session().Info(session, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if info != nil {
+		t.Fatalf("bad: %#v", info)
+	}
+
+	// A missing key is created.
+	ui = new(cli.MockUi)
+	c = &KVLockCommand{Ui: ui}
+	args = []string{"-http-addr=" + srv.httpAddr, "locks/new", "true"}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	pair, _, err = client.KV().Get("locks/new", nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if pair == nil || pair.Session != "" || pair.LockIndex != 1 {
+		t.Fatalf("bad: %#v", pair)
+	}
+}
+
+func TestKVLockCommand_Held(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	dir, cleanup := testKVLockDir(t)
+	defer cleanup()
+	out := filepath.Join(dir, "out")
+
+	// Hold the lock with another session.
+	other, _, err := client.Session().Create(&api.SessionEntry{}, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	pair := &api.KVPair{Key: "locks/foo", Session: other}
+	if ok, _, err := client.KV().Acquire(pair, nil); err != nil || !ok {
+		t.Fatalf("bad: %v %v", ok, err)
+	}
+
+	cases := map[string]struct {
+		args   []string
+		output string
+	}{
+		"try": {
+			[]string{"-try"},
+			"Lock on locks/foo is held by session " + other,
+		},
+		"wait": {
+			[]string{"-wait=200ms"},
+			"Timed out after 200ms waiting for the lock on locks/foo",
+		},
+	}
+
+	for name, tc := range cases {
+		ui := new(cli.MockUi)
+		c := &KVLockCommand{Ui: ui}
+		args := append([]string{"-http-addr=" + srv.httpAddr}, tc.args...)
+		args = append(args, "locks/foo", "touch", out)
+		if code := c.Run(args); code != 1 {
+			t.Fatalf("%s: bad: %d", name, code)
+		}
+		if output := ui.ErrorWriter.String(); !strings.Contains(output, tc.output) {
+			t.Fatalf("%s: expected %q to contain %q", name, output, tc.output)
+		}
+		if _, err := os.Stat(out); !os.IsNotExist(err) {
+			t.Fatalf("%s: command was run: %v", name, err)
+		}
+	}
+
+	// Once the other session lets go, the command runs.
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		if _, _, err := client.KV().Release(pair, nil); err != nil {
+			t.Errorf("err: %v", err)
+		}
+	}()
+	ui := new(cli.MockUi)
+	c := &KVLockCommand{Ui: ui}
+	args := []string{"-http-addr=" + srv.httpAddr, "-wait=10s", "locks/foo", "touch", out}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if _, err := os.Stat(out); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestKVLockCommand_Lost(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	dir, cleanup := testKVLockDir(t)
+	defer cleanup()
+	sessionFile := filepath.Join(dir, "session")
+	out := filepath.Join(dir, "out")
+
+	cases := map[string]struct {
+		script string
+		output string
+	}{
+		"terminated": {
+			`trap "echo term > ` + out + `; exit 0" TERM; ` +
+				`echo $CONSUL_LOCK_SESSION > ` + sessionFile + `; ` +
+				`while true; do sleep 0.1; done`,
+			"term",
+		},
+		"killed": {
+			`trap "echo ignored > ` + out + `" TERM; ` +
+				`echo $CONSUL_LOCK_SESSION > ` + sessionFile + `; ` +
+				`while true; do sleep 0.1; done`,
+			"ignored",
+		},
+	}
+
+	for name, tc := range cases {
+		os.Remove(sessionFile)
+		os.Remove(out)
+
+		// Take the lock away by destroying the session once the command
+		// is running.
+		go func() {
+			session := waitForFile(t, sessionFile)
+			if _, err := client.Session().Destroy(session, nil); err != nil {
+				t.Errorf("err: %v", err)
+			}
+		}()
+
+		ui := new(cli.MockUi)
+		c := &KVLockCommand{Ui: ui}
+		args := []string{
+			"-http-addr=" + srv.httpAddr,
+			"-grace-period=500ms",
+			// The lock-delay keeps a lost lock from being taken again
+			// for a while, so use a new key each time.
+			"locks/" + name,
+			"sh", "-c", tc.script,
+		}
+
+		doneCh := make(chan int, 1)
+		go func() { doneCh <- c.Run(args) }()
+		select {
+		case code := <-doneCh:
+			if code != 1 {
+				t.Fatalf("%s: bad: %d. %#v", name, code, ui.ErrorWriter.String())
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("%s: command was not stopped", name)
+		}
+
+		if output := ui.ErrorWriter.String(); !strings.Contains(output, "Lock was lost: locks/"+name) {
+			t.Fatalf("%s: bad: %#v", name, output)
+		}
+		if output := waitForFile(t, out); output != tc.output {
+			t.Fatalf("%s: bad: %q", name, output)
+		}
+	}
+}
+
+func TestKVLockCommand_Shutdown(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	dir, cleanup := testKVLockDir(t)
+	defer cleanup()
+	started := filepath.Join(dir, "started")
+	out := filepath.Join(dir, "out")
+
+	shutdownCh := make(chan struct{}, 1)
+	go func() {
+		waitForFile(t, started)
+		shutdownCh <- struct{}{}
+	}()
+
+	// The signal is passed on to the command, which exits on its own.
+	ui := new(cli.MockUi)
+	c := &KVLockCommand{Ui: ui, ShutdownCh: shutdownCh}
+	args := []string{
+		"-http-addr=" + srv.httpAddr,
+		"locks/foo",
+		"sh", "-c",
+		`trap "echo term > ` + out + `; exit 3" TERM; ` +
+			`echo yes > ` + started + `; ` +
+			`while true; do sleep 0.1; done`,
+	}
+	if code := c.Run(args); code != 3 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if output := waitForFile(t, out); output != "term" {
+		t.Fatalf("bad: %q", output)
+	}
+
+	pair, _, err := client.KV().Get("locks/foo", nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if pair == nil || pair.Session != "" {
+		t.Fatalf("bad: %#v", pair)
+	}
+}
@@ -0,0 +1,509 @@
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/command/agent"
+	"github.com/mitchellh/cli"
+)
+
+// KVPatchCommand is a Command implementation that is used to change fields of
+// a JSON value in the key-value store.
+type KVPatchCommand struct {
+	Ui cli.Ui
+
+	// testBeforeCAS is called between reading the key and writing it, for
+	// testing.
+	testBeforeCAS func()
+}
+
+func (c *KVPatchCommand) Synopsis() string {
+	return "Changes fields of a JSON value in the KV store"
+}
+
+func (c *KVPatchCommand) Help() string {
+	helpText := `
+Usage: consul kv patch [options] KEY
+
+  Changes fields of the JSON document stored at KEY, without a script to read,
+  modify, and write it back:
+
+      $ consul kv patch -set=db.pool.size=20 -delete=db.legacy config/app
+
+  Fields are addressed by a path of names separated by dots, with array
+  elements given by their index, either as "servers.0.host" or as
+  "servers[0].host". Setting a field creates any objects missing along its
+  path, and setting the index just past the end of an array appends to it.
+
+  The key is read, the changes are applied, and the result is written back
+  with a check-and-set against the ModifyIndex that was read. If the key
+  changes in between, it's read again and the changes applied again, as many
+  times as -retries allows. The new ModifyIndex is printed, so further
+  check-and-set operations can be chained.
+
+  A value which isn't valid JSON is an error, and nothing is written. The
+  document is written back with its fields in sorted order, indented if it
+  was indented before. If the changes leave it the same, nothing is written.
+
+  For a full list of options and examples, please see the Consul documentation.
+
+` + apiOptsText + `
+
+` + kvKeyOptsText + `
+
+KV Patch Options:
+
+  -delete=<path>          Remove the field at the given path, or the element
+                          of an array, moving the ones after it down. Nothing
+                          happens if it doesn't exist. This can be specified
+                          multiple times, and is applied after -set.
+
+  -dry-run                Print the changed document instead of writing it.
+                          The default value is false.
+
+  -retries=<int>          Number of times to read the key again and apply the
+                          changes again when it changes before it's written.
+                          This is separate from -retry, which covers transient
+                          errors. The default value is 3.
+
+  -set=<path=value>       Set the field at the given path to the value, which
+                          is split from the path on the first "=". A value of
+                          true or false, or which is a JSON number, is set as
+                          that type, and anything else as a string. This can
+                          be specified multiple times.
+
+  -string                 Set every value given with -set as a string, even
+                          if it looks like a number or a boolean. The default
+                          value is false.
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *KVPatchCommand) Run(args []string) (code int) {
+	cmdFlags, apiFlags := NewAPIFlagSet("patch", c.Ui)
+	defer apiFlags.CheckTimeout(c.Ui, &code)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	var sets, deletes []string
+	cmdFlags.Var((*agent.AppendSliceValue)(&sets), "set", "")
+	cmdFlags.Var((*agent.AppendSliceValue)(&deletes), "delete", "")
+	asString := cmdFlags.Bool("string", false, "")
+	retries := cmdFlags.Int("retries", 3, "")
+	dryRun := cmdFlags.Bool("dry-run", false, "")
+	keyFlags := newKVKeyFlags(cmdFlags)
+	if err := parseFlags(c.Ui, cmdFlags, args); err != nil {
+		return 1
+	}
+
+	var key string
+	args = cmdFlags.Args()
+	switch len(args) {
+	case 0:
+		c.Ui.Error("Error! Missing KEY argument")
+		return 1
+	case 1:
+		key = args[0]
+	default:
+		c.Ui.Error(fmt.Sprintf("Too many arguments (expected 1, got %d)", len(args)))
+		return 1
+	}
+	key, err := keyFlags.check(c.Ui, key)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error! %s", err))
+		return 1
+	}
+	if key == "" {
+		c.Ui.Error("Error! KEY can't be empty")
+		return 1
+	}
+	if *retries < 0 {
+		c.Ui.Error("Error! -retries must not be negative")
+		return 1
+	}
+
+	patch, err := newKVPatch(sets, deletes, *asString)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error! %s", err))
+		return 1
+	}
+
+	// Create and test the HTTP client
+	client, err := apiFlags.Client()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+		return 1
+	}
+
+	q := apiFlags.QueryOptions()
+	attempt, failures := 0, 0
+	for {
+		current, _, err := client.KV().Get(key, q)
+		if err != nil {
+			c.Ui.Error(apiFlags.errorMessage("Error querying Consul agent", err,
+				kvDenial("read "+key, key, "read")))
+			return 1
+		}
+		if current == nil {
+			c.Ui.Error(fmt.Sprintf("Error! No key exists at: %s", key))
+			return exitNotFound
+		}
+
+		value, changed, err := patch.apply(current.Value)
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Error! Cannot patch %s: %s", key, err))
+			return 1
+		}
+		if *dryRun {
+			c.Ui.Output(string(value))
+			return 0
+		}
+		if !changed {
+			c.Ui.Warn(fmt.Sprintf("No change to %s, nothing was written", key))
+			c.Ui.Output(fmt.Sprintf("%d", current.ModifyIndex))
+			return 0
+		}
+
+		if c.testBeforeCAS != nil {
+			c.testBeforeCAS()
+		}
+
+		ops := api.KVTxnOps{
+			&api.KVTxnOp{
+				Verb:  api.KVCAS,
+				Key:   key,
+				Value: value,
+				Flags: current.Flags,
+				Index: current.ModifyIndex,
+			},
+		}
+		ok, resp, err := kvTxn(client, ops, q)
+		if err != nil {
+			msg := fmt.Sprintf("Write to %s failed: %s", key, err)
+			if isTransientError(err) && apiFlags.retryWait(apiFlags.ctx, failures, msg) {
+				failures++
+				continue
+			}
+			c.Ui.Error(apiFlags.errorMessage(fmt.Sprintf("Error! Did not write to %s", key),
+				err, kvDenial("write to "+key, key, "write")))
+			return 1
+		}
+		if ok {
+			c.Ui.Warn(fmt.Sprintf("Success! Patched %s", key))
+			c.Ui.Output(fmt.Sprintf("%d", resp.Results[0].ModifyIndex))
+			return 0
+		}
+
+		// Only retry when the key changed, and report anything else.
+		for _, e := range resp.Errors {
+			if !strings.Contains(e.What, "index is stale") {
+				c.Ui.Error(apiFlags.errorMessage(fmt.Sprintf("Error! Did not write to %s", key),
+					errors.New(e.What), kvDenial("write to "+key, key, "write")))
+				return 1
+			}
+		}
+		if attempt >= *retries {
+			c.Ui.Error(fmt.Sprintf("Error! Did not write to %s: CAS failed on every attempt (%d)",
+				key, attempt+1))
+			return 1
+		}
+		c.Ui.Warn(fmt.Sprintf("Key %s changed before it was written (retry %d of %d)",
+			key, attempt+1, *retries))
+		attempt++
+	}
+}
+
+// kvPatch is a set of changes to a JSON document.
+type kvPatch struct {
+	sets    []kvPatchSet
+	deletes [][]string
+}
+
+// kvPatchSet sets the field at a path to a value.
+type kvPatchSet struct {
+	path  []string
+	value interface{}
+}
+
+// newKVPatch parses the -set and -delete flags. Values which look like
+// numbers or booleans are set as those types, unless asString is set.
+func newKVPatch(sets, deletes []string, asString bool) (*kvPatch, error) {
+	if len(sets) == 0 && len(deletes) == 0 {
+		return nil, errors.New("Nothing to change. Give -set or -delete")
+	}
+
+	p := &kvPatch{}
+	for _, s := range sets {
+		i := strings.Index(s, "=")
+		if i == -1 {
+			return nil, fmt.Errorf("Invalid -set %q (expected path=value)", s)
+		}
+		path, err := parseKVPatchPath(s[:i])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid -set %q: %s", s, err)
+		}
+		var value interface{} = s[i+1:]
+		if !asString {
+			value = parseKVPatchValue(s[i+1:])
+		}
+		p.sets = append(p.sets, kvPatchSet{path, value})
+	}
+	for _, d := range deletes {
+		path, err := parseKVPatchPath(d)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid -delete %q: %s", d, err)
+		}
+		p.deletes = append(p.deletes, path)
+	}
+	return p, nil
+}
+
+// parseKVPatchPath splits a path such as "servers[0].host" or
+// "servers.0.host" into its segments.
+func parseKVPatchPath(path string) ([]string, error) {
+	if path == "" {
+		return nil, errors.New("empty path")
+	}
+
+	var segments []string
+	for _, part := range strings.Split(path, ".") {
+		name := part
+		var indexes []string
+		if i := strings.Index(part, "["); i != -1 {
+			name = part[:i]
+			rest := part[i:]
+			for rest != "" {
+				end := strings.Index(rest, "]")
+				if rest[0] != '[' || end == -1 {
+					return nil, fmt.Errorf("unmatched brackets in %q", part)
+				}
+				index := rest[1:end]
+				if _, err := strconv.Atoi(index); err != nil {
+					return nil, fmt.Errorf("%q is not an array index", index)
+				}
+				indexes = append(indexes, index)
+				rest = rest[end+1:]
+			}
+			if name == "" && len(segments) == 0 {
+				return nil, fmt.Errorf("path must start with a field name")
+			}
+		}
+		if name != "" {
+			segments = append(segments, name)
+		} else if len(indexes) == 0 {
+			return nil, errors.New("empty field name")
+		}
+		segments = append(segments, indexes...)
+	}
+	return segments, nil
+}
+
+// parseKVPatchValue returns the value given to -set as a boolean or a JSON
+// number if it is one, or else as a string.
+func parseKVPatchValue(s string) interface{} {
+	switch s {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err == nil {
+		if n, ok := v.(json.Number); ok && string(n) == s {
+			return n
+		}
+	}
+	return s
+}
+
+// apply applies the changes to a JSON document, returning the new document
+// and whether it's different. Numbers are kept exactly as they were.
+func (p *kvPatch) apply(data []byte) ([]byte, bool, error) {
+	original, err := decodeKVPatchJSON(data)
+	if err != nil {
+		return nil, false, fmt.Errorf("the value is not valid JSON: %s", err)
+	}
+	doc, _ := decodeKVPatchJSON(data)
+
+	for _, s := range p.sets {
+		if doc, err = kvPatchSetPath(doc, s.path, s.value, ""); err != nil {
+			return nil, false, err
+		}
+	}
+	for _, path := range p.deletes {
+		if doc, err = kvPatchDeletePath(doc, path, ""); err != nil {
+			return nil, false, err
+		}
+	}
+	if reflect.DeepEqual(doc, original) {
+		return data, false, nil
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if bytes.Contains(bytes.TrimSpace(data), []byte("\n")) {
+		enc.SetIndent("", kvPatchIndent(data))
+	}
+	if err := enc.Encode(doc); err != nil {
+		return nil, false, err
+	}
+	out := bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+	if bytes.HasSuffix(data, []byte("\n")) {
+		out = append(out, '\n')
+	}
+	return out, true, nil
+}
+
+// decodeKVPatchJSON decodes a single JSON document, keeping numbers as they
+// were written.
+func decodeKVPatchJSON(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		if err == io.EOF {
+			return nil, errors.New("the value is empty")
+		}
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("unexpected data after the document")
+	}
+	return doc, nil
+}
+
+// kvPatchIndent returns the indent used by an indented JSON document, taken
+// from the first indented line, or two spaces if there isn't one.
+func kvPatchIndent(data []byte) string {
+	for _, line := range strings.Split(string(data), "\n")[1:] {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed != "" && len(trimmed) < len(line) {
+			return line[:len(line)-len(trimmed)]
+		}
+	}
+	return "  "
+}
+
+// kvPatchSetPath sets the field at the path under node, creating any objects
+// which are missing on the way, and returns the new node. at is the path to
+// node, for errors.
+func kvPatchSetPath(node interface{}, path []string, value interface{}, at string) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	next := kvPatchJoin(at, path[0])
+	switch n := node.(type) {
+	case nil:
+		return kvPatchSetPath(map[string]interface{}{}, path, value, at)
+	case map[string]interface{}:
+		child, err := kvPatchSetPath(n[path[0]], path[1:], value, next)
+		if err != nil {
+			return nil, err
+		}
+		n[path[0]] = child
+		return n, nil
+	case []interface{}:
+		i, err := strconv.Atoi(path[0])
+		if err != nil || i < 0 {
+			return nil, fmt.Errorf("%s is an array, so %q must be an index", kvPatchName(at), path[0])
+		}
+		if i > len(n) {
+			return nil, fmt.Errorf("index %d is past the end of %s, which has length %d",
+				i, kvPatchName(at), len(n))
+		}
+		if i == len(n) {
+			n = append(n, nil)
+		}
+		child, err := kvPatchSetPath(n[i], path[1:], value, next)
+		if err != nil {
+			return nil, err
+		}
+		n[i] = child
+		return n, nil
+	default:
+		return nil, fmt.Errorf("%s is %s, not an object or array", kvPatchName(at), kvPatchType(node))
+	}
+}
+
+// kvPatchDeletePath removes the field at the path under node, if it exists,
+// and returns the new node.
+func kvPatchDeletePath(node interface{}, path []string, at string) (interface{}, error) {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		child, ok := n[path[0]]
+		switch {
+		case !ok:
+		case len(path) == 1:
+			delete(n, path[0])
+		default:
+			child, err := kvPatchDeletePath(child, path[1:], kvPatchJoin(at, path[0]))
+			if err != nil {
+				return nil, err
+			}
+			n[path[0]] = child
+		}
+		return n, nil
+	case []interface{}:
+		i, err := strconv.Atoi(path[0])
+		if err != nil || i < 0 {
+			return nil, fmt.Errorf("%s is an array, so %q must be an index", kvPatchName(at), path[0])
+		}
+		switch {
+		case i >= len(n):
+		case len(path) == 1:
+			n = append(n[:i], n[i+1:]...)
+		default:
+			child, err := kvPatchDeletePath(n[i], path[1:], kvPatchJoin(at, path[0]))
+			if err != nil {
+				return nil, err
+			}
+			n[i] = child
+		}
+		return n, nil
+	case nil:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("%s is %s, not an object or array", kvPatchName(at), kvPatchType(node))
+	}
+}
+
+// kvPatchJoin adds a segment to a path.
+func kvPatchJoin(at, segment string) string {
+	if at == "" {
+		return segment
+	}
+	return at + "." + segment
+}
+
+// kvPatchName names the field at a path in an error.
+func kvPatchName(at string) string {
+	if at == "" {
+		return "the value"
+	}
+	return fmt.Sprintf("field %q", at)
+}
+
+// kvPatchType describes the type of a JSON value in an error.
+func kvPatchType(v interface{}) string {
+	switch v.(type) {
+	case string:
+		return "a string"
+	case json.Number:
+		return "a number"
+	case bool:
+		return "a boolean"
+	default:
+		return "null"
+	}
+}
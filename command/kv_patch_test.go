@@ -0,0 +1,333 @@
+package command
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+)
+
+func TestKVPatchCommand_implements(t *testing.T) {
+	var _ cli.Command = &KVPatchCommand{}
+}
+
+func TestKVPatchCommand_noTabs(t *testing.T) {
+	assertNoTabs(t, new(KVPatchCommand))
+}
+
+func TestKVPatchCommand_Validation(t *testing.T) {
+	cases := map[string]struct {
+		args   []string
+		output string
+	}{
+		"no key": {
+			[]string{"-set=a=1"},
+			"Missing KEY argument",
+		},
+		"extra args": {
+			[]string{"-set=a=1", "foo", "bar"},
+			"Too many arguments (expected 1, got 2)",
+		},
+		"nothing to change": {
+			[]string{"foo"},
+			"Nothing to change. Give -set or -delete",
+		},
+		"set without value": {
+			[]string{"-set=a.b", "foo"},
+			`Invalid -set "a.b" (expected path=value)`,
+		},
+		"empty field": {
+			[]string{"-set=a..b=1", "foo"},
+			`Invalid -set "a..b=1": empty field name`,
+		},
+		"bad index": {
+			[]string{"-delete=a[x]", "foo"},
+			`Invalid -delete "a[x]": "x" is not an array index`,
+		},
+		"unmatched bracket": {
+			[]string{"-delete=a[0", "foo"},
+			`Invalid -delete "a[0": unmatched brackets in "a[0"`,
+		},
+		"negative retries": {
+			[]string{"-set=a=1", "-retries=-1", "foo"},
+			"-retries must not be negative",
+		},
+	}
+
+	for name, tc := range cases {
+		ui := new(cli.MockUi)
+		c := &KVPatchCommand{Ui: ui}
+		if code := c.Run(tc.args); code != 1 {
+			t.Errorf("%s: bad: %d", name, code)
+		}
+		if output := ui.ErrorWriter.String(); !strings.Contains(output, tc.output) {
+			t.Errorf("%s: expected %q to contain %q", name, output, tc.output)
+		}
+	}
+}
+
+func TestKVPatch_apply(t *testing.T) {
+	cases := []struct {
+		name     string
+		sets     []string
+		deletes  []string
+		asString bool
+		value    string
+		expected string
+		err      string
+	}{
+		{
+			name:     "types",
+			sets:     []string{"n=5", "f=-1.5e3", "b=true", "s=hello", "v=1.2.3", "big=18446744073709551615"},
+			value:    `{}`,
+			expected: `{"b":true,"big":18446744073709551615,"f":-1.5e3,"n":5,"s":"hello","v":"1.2.3"}`,
+		},
+		{
+			name:     "strings",
+			sets:     []string{"n=5", "b=true"},
+			asString: true,
+			value:    `{}`,
+			expected: `{"b":"true","n":"5"}`,
+		},
+		{
+			name:     "nested creation",
+			sets:     []string{"a.b.c=x", "a.d=1"},
+			value:    `{"a":null,"keep":12345678901234567890}`,
+			expected: `{"a":{"b":{"c":"x"},"d":1},"keep":12345678901234567890}`,
+		},
+		{
+			name:     "arrays",
+			sets:     []string{"servers[0].port=8080", "servers.2.host=c", "tags[1][0]=x"},
+			value:    `{"servers":[{"host":"a"},{"host":"b"}],"tags":[[],[]]}`,
+			expected: `{"servers":[{"host":"a","port":8080},{"host":"b"},{"host":"c"}],"tags":[[],["x"]]}`,
+		},
+		{
+			name:     "deletes",
+			deletes:  []string{"a.b", "list[0]", "missing.field", "list[5]"},
+			value:    `{"a":{"b":1,"c":2},"list":[1,2,3]}`,
+			expected: `{"a":{"c":2},"list":[2,3]}`,
+		},
+		{
+			name:     "set then delete",
+			sets:     []string{"a.b=1"},
+			deletes:  []string{"a.b"},
+			value:    `{"x":1}`,
+			expected: `{"a":{},"x":1}`,
+		},
+		{
+			name:     "indented",
+			sets:     []string{"a=<b>"},
+			value:    "{\n    \"b\": [\n        1\n    ]\n}\n",
+			expected: "{\n    \"a\": \"<b>\",\n    \"b\": [\n        1\n    ]\n}\n",
+		},
+		{
+			name:  "not json",
+			sets:  []string{"a=1"},
+			value: `a=1`,
+			err:   "the value is not valid JSON",
+		},
+		{
+			name:  "empty",
+			sets:  []string{"a=1"},
+			value: ``,
+			err:   "the value is not valid JSON: the value is empty",
+		},
+		{
+			name:  "trailing data",
+			sets:  []string{"a=1"},
+			value: `{} {}`,
+			err:   "unexpected data after the document",
+		},
+		{
+			name:  "under a scalar",
+			sets:  []string{"a.b.c=1"},
+			value: `{"a":{"b":"x"}}`,
+			err:   `field "a.b" is a string, not an object or array`,
+		},
+		{
+			name:  "root scalar",
+			sets:  []string{"a=1"},
+			value: `5`,
+			err:   "the value is a number, not an object or array",
+		},
+		{
+			name:  "array field",
+			sets:  []string{"list.x=1"},
+			value: `{"list":[]}`,
+			err:   `field "list" is an array, so "x" must be an index`,
+		},
+		{
+			name:  "past the end",
+			sets:  []string{"list[2]=1"},
+			value: `{"list":[0]}`,
+			err:   `index 2 is past the end of field "list", which has length 1`,
+		},
+	}
+
+	for _, tc := range cases {
+		p, err := newKVPatch(tc.sets, tc.deletes, tc.asString)
+		if err != nil {
+			t.Fatalf("%s: err: %v", tc.name, err)
+		}
+		out, changed, err := p.apply([]byte(tc.value))
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("%s: bad: %v", tc.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: err: %v", tc.name, err)
+		}
+		if !changed || string(out) != tc.expected {
+			t.Fatalf("%s: bad: %v %q", tc.name, changed, out)
+		}
+	}
+
+	// Changes which leave the document the same are reported as such.
+	p, err := newKVPatch([]string{"a=1"}, []string{"b"}, false)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if out, changed, err := p.apply([]byte(`{ "a": 1 }`)); err != nil || changed || string(out) != `{ "a": 1 }` {
+		t.Fatalf("bad: %q %v %v", out, changed, err)
+	}
+}
+
+func TestKVPatchCommand_Run(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	run := func(c *KVPatchCommand, args ...string) (int, *cli.MockUi) {
+		ui := new(cli.MockUi)
+		c.Ui = ui
+		return c.Run(append([]string{"-http-addr=" + srv.httpAddr}, args...)), ui
+	}
+	check := func(key, value string) *api.KVPair {
+		pair, _, err := client.KV().Get(key, nil)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if pair == nil || string(pair.Value) != value {
+			t.Fatalf("bad: %#v", pair)
+		}
+		return pair
+	}
+
+	pair := &api.KVPair{Key: "app/config", Flags: 42, Value: []byte(`{"db":{"host":"a"}}`)}
+	if _, err := client.KV().Put(pair, nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Intermediate objects are created, and the flags are kept.
+	code, ui := run(&KVPatchCommand{}, "-set=db.pool.size=20", "-set=cache.ttl=30s", "app/config")
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	pair = check("app/config", `{"cache":{"ttl":"30s"},"db":{"host":"a","pool":{"size":20}}}`)
+	if pair.Flags != 42 {
+		t.Fatalf("bad: %#v", pair)
+	}
+	if output := ui.OutputWriter.String(); output != strconv.FormatUint(pair.ModifyIndex, 10)+"\n" {
+		t.Fatalf("bad: %q", output)
+	}
+
+	// A dry run prints the result without writing it.
+	code, ui = run(&KVPatchCommand{}, "-dry-run", "-delete=cache", "app/config")
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if output := ui.OutputWriter.String(); output != `{"db":{"host":"a","pool":{"size":20}}}`+"\n" {
+		t.Fatalf("bad: %q", output)
+	}
+	check("app/config", `{"cache":{"ttl":"30s"},"db":{"host":"a","pool":{"size":20}}}`)
+
+	// Nothing is written when nothing changes.
+	code, ui = run(&KVPatchCommand{}, "-set=db.host=a", "app/config")
+	if code != 0 || !strings.Contains(ui.ErrorWriter.String(), "No change to app/config") {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if again := check("app/config", string(pair.Value)); again.ModifyIndex != pair.ModifyIndex {
+		t.Fatalf("bad: %#v", again)
+	}
+
+	// A value which isn't JSON is an error, and isn't touched.
+	if _, err := client.KV().Put(&api.KVPair{Key: "app/text", Value: []byte("plain")}, nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	code, ui = run(&KVPatchCommand{}, "-set=a=1", "app/text")
+	if code != 1 || !strings.Contains(ui.ErrorWriter.String(), "Cannot patch app/text: the value is not valid JSON") {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	check("app/text", "plain")
+
+	// A missing key is reported.
+	code, ui = run(&KVPatchCommand{}, "-set=a=1", "app/nope")
+	if code != exitNotFound || !strings.Contains(ui.ErrorWriter.String(), "No key exists at: app/nope") {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+}
+
+func TestKVPatchCommand_CASRetry(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	if _, err := client.KV().Put(&api.KVPair{Key: "foo", Value: []byte(`{"a":1}`)}, nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Another writer changes a different field between the read and the
+	// write, so the patch is applied again on top of its change.
+	writes := 0
+	race := func() {
+		writes++
+		if writes == 1 {
+			if _, err := client.KV().Put(&api.KVPair{Key: "foo", Value: []byte(`{"a":1,"b":2}`)}, nil); err != nil {
+				t.Fatalf("err: %v", err)
+			}
+		}
+	}
+	ui := new(cli.MockUi)
+	c := &KVPatchCommand{Ui: ui, testBeforeCAS: race}
+	if code := c.Run([]string{"-http-addr=" + srv.httpAddr, "-set=c=3", "foo"}); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if writes != 2 || !strings.Contains(ui.ErrorWriter.String(), "retry 1 of 3") {
+		t.Fatalf("bad: %d. %#v", writes, ui.ErrorWriter.String())
+	}
+	pair, _, err := client.KV().Get("foo", nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if string(pair.Value) != `{"a":1,"b":2,"c":3}` {
+		t.Fatalf("bad: %#v", pair)
+	}
+
+	// Without retries, the race fails the write.
+	writes = 0
+	race = func() {
+		writes++
+		if _, err := client.KV().Put(&api.KVPair{Key: "foo", Value: []byte(`{"x":1}`)}, nil); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	ui = new(cli.MockUi)
+	c = &KVPatchCommand{Ui: ui, testBeforeCAS: race}
+	if code := c.Run([]string{"-http-addr=" + srv.httpAddr, "-retries=1", "-set=c=4", "foo"}); code != 1 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if writes != 2 || !strings.Contains(ui.ErrorWriter.String(), "CAS failed on every attempt (2)") {
+		t.Fatalf("bad: %d. %#v", writes, ui.ErrorWriter.String())
+	}
+	pair, _, err = client.KV().Get("foo", nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if string(pair.Value) != `{"x":1}` {
+		t.Fatalf("bad: %#v", pair)
+	}
+}
@@ -0,0 +1,164 @@
+package command
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+)
+
+// KVPruneCommand is a Command implementation that is used to delete folder
+// keys which have nothing left under them.
+type KVPruneCommand struct {
+	Ui cli.Ui
+}
+
+func (c *KVPruneCommand) Synopsis() string {
+	return "Deletes empty folder keys from the KV store"
+}
+
+func (c *KVPruneCommand) Help() string {
+	helpText := `
+Usage: consul kv prune [options] [PREFIX]
+
+  Deletes the empty folder keys under the given prefix. A folder key is a key
+  ending in "/", such as those created by the web UI, and it's empty if it has
+  no value and no other keys under it. These are often left behind once all
+  the keys in a folder have been deleted:
+
+      $ consul kv prune app/
+
+  Deleting a folder can leave the folder above it empty, so folders are pruned
+  from the bottom up until none are left, and a chain of nested empty folders
+  is removed completely. A folder key with a value, flags, or a lock is never
+  deleted, and neither are the folders above it. With no prefix, the whole
+  key-value store is pruned.
+
+  Each key is deleted with a check-and-set against the index it was listed at,
+  so nothing is deleted if a folder key changes in the meantime. Keys are
+  deleted in transactions of up to 64 keys, and every key is checked before
+  any are deleted.
+
+  For a full list of options and examples, please see the Consul documentation.
+
+` + apiOptsText + `
+
+KV Prune Options:
+
+  -dry-run                List the folder keys which would be deleted, one per
+                          line, without deleting anything. The default value
+                          is false.
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *KVPruneCommand) Run(args []string) (code int) {
+	cmdFlags, apiFlags := NewAPIFlagSet("prune", c.Ui)
+	defer apiFlags.CheckTimeout(c.Ui, &code)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	dryRun := cmdFlags.Bool("dry-run", false, "")
+	if err := parseFlags(c.Ui, cmdFlags, args); err != nil {
+		return 1
+	}
+
+	var prefix string
+	args = cmdFlags.Args()
+	switch len(args) {
+	case 0:
+	case 1:
+		prefix = args[0]
+	default:
+		c.Ui.Error(fmt.Sprintf("Too many arguments (expected 0 or 1, got %d)", len(args)))
+		return 1
+	}
+	prefix = normalizeKVPrefix(prefix)
+
+	// Create and test the HTTP client
+	client, err := apiFlags.Client()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+		return 1
+	}
+
+	pairs, _, err := client.KV().List(prefix, apiFlags.QueryOptions())
+	if err != nil {
+		c.Ui.Error(apiFlags.errorMessage("Error querying Consul agent", err,
+			kvDenial("read keys under "+prefix, prefix, "read")))
+		return exitCommError
+	}
+
+	empty := kvEmptyFolders(pairs)
+	if *dryRun {
+		for i := len(empty) - 1; i >= 0; i-- {
+			c.Ui.Info(empty[i].Key)
+		}
+		left := len(pairs) - len(empty)
+		c.Ui.Warn(fmt.Sprintf("Would delete %d empty folder %s with prefix: %s (%d %s left)",
+			len(empty), pluralKeys(len(empty)), prefix, left, pluralKeys(left)))
+		return 0
+	}
+
+	if len(empty) == 0 {
+		apiFlags.report(c.Ui, fmt.Sprintf("No empty folder keys to delete with prefix: %s", prefix))
+		return 0
+	}
+
+	deleted, err := kvDeleteTreeCAS(client, empty, apiFlags.WriteOptions())
+	if err != nil {
+		k := kvDeniedKey(err, prefix)
+		c.Ui.Error(apiFlags.errorMessage("Error! Did not prune empty folder keys", err,
+			kvDenial("delete "+k, k, "write")))
+		if deleted > 0 {
+			c.Ui.Error(fmt.Sprintf("Deleted %d of %d %s before the failure",
+				deleted, len(empty), pluralKeys(len(empty))))
+		}
+		if _, ok := err.(*kvCASError); ok {
+			return 1
+		}
+		return exitCommError
+	}
+
+	for i := len(empty) - 1; i >= 0; i-- {
+		apiFlags.report(c.Ui, fmt.Sprintf("Pruned: %s", empty[i].Key))
+	}
+	left := len(pairs) - deleted
+	apiFlags.report(c.Ui, fmt.Sprintf("Success! Deleted %d empty folder %s with prefix: %s (%d %s left)",
+		deleted, pluralKeys(deleted), prefix, left, pluralKeys(left)))
+	return 0
+}
+
+// kvEmptyFolders returns the empty folder keys among the pairs: keys ending in
+// "/" with no value, flags, or lock, and no other keys under them apart from
+// more empty folders. This is the same set that would be found by deleting
+// the empty folders over and over until none are left, since a folder only
+// becomes empty once everything under it is an empty folder. The keys are
+// sorted in reverse, so each folder comes after the folders under it.
+func kvEmptyFolders(pairs api.KVPairs) api.KVPairs {
+	// Any key which isn't an empty folder keeps every folder above it.
+	isEmpty := func(pair *api.KVPair) bool {
+		return strings.HasSuffix(pair.Key, "/") && len(pair.Value) == 0 &&
+			pair.Flags == 0 && pair.Session == ""
+	}
+	kept := make(map[string]struct{})
+	for _, pair := range pairs {
+		if isEmpty(pair) {
+			continue
+		}
+		for i := 0; i < len(pair.Key)-1; i++ {
+			if pair.Key[i] == '/' {
+				kept[pair.Key[:i+1]] = struct{}{}
+			}
+		}
+	}
+
+	var empty api.KVPairs
+	for _, pair := range pairs {
+		if _, ok := kept[pair.Key]; !ok && isEmpty(pair) {
+			empty = append(empty, pair)
+		}
+	}
+	sort.Sort(sort.Reverse(kvPairsByKey(empty)))
+	return empty
+}
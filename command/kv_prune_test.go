@@ -0,0 +1,159 @@
+package command
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+)
+
+func TestKVPruneCommand_implements(t *testing.T) {
+	var _ cli.Command = &KVPruneCommand{}
+}
+
+func TestKVPruneCommand_noTabs(t *testing.T) {
+	assertNoTabs(t, new(KVPruneCommand))
+}
+
+func TestKVPruneCommand_Validation(t *testing.T) {
+	ui := new(cli.MockUi)
+	c := &KVPruneCommand{Ui: ui}
+
+	if code := c.Run([]string{"foo", "bar"}); code != 1 {
+		t.Fatalf("bad: %d", code)
+	}
+	if output := ui.ErrorWriter.String(); !strings.Contains(output, "Too many arguments") {
+		t.Fatalf("bad: %#v", output)
+	}
+}
+
+func TestKVEmptyFolders(t *testing.T) {
+	pairs := api.KVPairs{
+		{Key: "a/"},
+		{Key: "a/b/"},
+		{Key: "a/b/c/"},
+		{Key: "a/b/c/d/"},
+		{Key: "keep/"},
+		{Key: "keep/empty/"},
+		{Key: "keep/x"},
+		{Key: "value/", Value: []byte("1")},
+		{Key: "value/empty/"},
+		{Key: "flags/", Flags: 42},
+		{Key: "locked/", Session: "abc"},
+		{Key: "locked/under/"},
+		{Key: "notafolder"},
+	}
+
+	var keys []string
+	for _, pair := range kvEmptyFolders(pairs) {
+		keys = append(keys, pair.Key)
+	}
+	expected := []string{
+		"value/empty/",
+		"locked/under/",
+		"keep/empty/",
+		"a/b/c/d/",
+		"a/b/c/",
+		"a/b/",
+		"a/",
+	}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Fatalf("bad: %#v", keys)
+	}
+
+	if empty := kvEmptyFolders(nil); len(empty) != 0 {
+		t.Fatalf("bad: %#v", empty)
+	}
+}
+
+func TestKVPruneCommand_Run(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	for _, pair := range []*api.KVPair{
+		{Key: "app/"},
+		{Key: "app/old/"},
+		{Key: "app/old/config/"},
+		{Key: "app/old/config/db/"},
+		{Key: "app/live/"},
+		{Key: "app/live/key", Value: []byte("1")},
+		{Key: "app/note/", Value: []byte("keep me")},
+		{Key: "other/"},
+	} {
+		if _, err := client.KV().Put(pair, nil); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	run := func(args ...string) (int, string, string) {
+		ui := new(cli.MockUi)
+		c := &KVPruneCommand{Ui: ui}
+		code := c.Run(append([]string{"-http-addr=" + srv.httpAddr}, args...))
+		return code, ui.OutputWriter.String(), ui.ErrorWriter.String()
+	}
+
+	// A dry run lists the chain of empty folders without deleting it.
+	code, output, errors := run("-dry-run", "app")
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, errors)
+	}
+	if output != "app/old/\napp/old/config/\napp/old/config/db/\n" {
+		t.Fatalf("bad: %#v", output)
+	}
+	if !strings.Contains(errors, "Would delete 3 empty folder keys with prefix: app/ (4 keys left)") {
+		t.Fatalf("bad: %#v", errors)
+	}
+	if pair, _, err := client.KV().Get("app/old/config/db/", nil); err != nil || pair == nil {
+		t.Fatalf("bad: %#v %v", pair, err)
+	}
+
+	code, output, errors = run("app")
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, errors)
+	}
+	if !strings.Contains(output, "Pruned: app/old/config/db/") ||
+		!strings.Contains(output, "Success! Deleted 3 empty folder keys with prefix: app/ (4 keys left)") {
+		t.Fatalf("bad: %#v", output)
+	}
+
+	keys, _, err := client.KV().Keys("", "", nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	expected := []string{"app/", "app/live/", "app/live/key", "app/note/", "other/"}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Fatalf("bad: %#v", keys)
+	}
+
+	// Once the last key in a folder goes, the folders above it go too, but
+	// only under the prefix.
+	if _, err := client.KV().Delete("app/live/key", nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := client.KV().Delete("app/note/", nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	code, output, errors = run("app/")
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, errors)
+	}
+	if !strings.Contains(output, "Success! Deleted 2 empty folder keys with prefix: app/ (0 keys left)") {
+		t.Fatalf("bad: %#v", output)
+	}
+	keys, _, err = client.KV().Keys("", "", nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !reflect.DeepEqual(keys, []string{"other/"}) {
+		t.Fatalf("bad: %#v", keys)
+	}
+
+	// Running it again finds nothing to do.
+	code, output, _ = run("app/")
+	if code != 0 || !strings.Contains(output, "No empty folder keys to delete with prefix: app/") {
+		t.Fatalf("bad: %d %#v", code, output)
+	}
+}
@@ -3,7 +3,7 @@ package command
 import (
 	"bytes"
 	"encoding/base64"
-	"flag"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -21,11 +21,17 @@ type KVPutCommand struct {
 
 	// testStdin is the input for testing.
 	testStdin io.Reader
+
+	// testBeforeCAS is called between reading the key and writing it with
+	// -update-existing, for testing.
+	testBeforeCAS func()
 }
 
 func (c *KVPutCommand) Help() string {
 	helpText := `
 Usage: consul kv put [options] KEY [DATA]
+       consul kv put [options] -pairs KEY=VALUE...
+       consul kv put [options] -from-env-file=FILE [KEY=VALUE...]
 
   Writes the data to the given path in the key-value store. The data can be of
   any type.
@@ -46,60 +52,150 @@ Usage: consul kv put [options] KEY [DATA]
 
       $ consul kv put webapp/beta/active
 
+  A DATA argument which is given but empty, such as from an unset variable in
+  "$VAR", is more likely a mistake, so it's written with this warning:
+
+      Warning! Writing an empty value to KEY. Use -allow-empty if this is
+      intended, or -require-data to refuse empty values.
+
+  With -require-data, an empty value is refused before anything is sent to the
+  agent instead, and -min-size refuses values smaller than the given size. An
+  empty value can be written on purpose with -allow-empty. These checks also
+  apply to each KEY=VALUE, but never to a key written without DATA.
+
   If the -base64 flag is specified, the data will be treated as base 64
   encoded.
 
+  Values are limited to 512KB. Larger values are rejected before anything is
+  sent to the agent.
+
   To perform a Check-And-Set operation, specify the -cas flag with the
   appropriate -modify-index flag corresponding to the key you want to perform
   the CAS operation on:
 
       $ consul kv put -cas -modify-index=844 config/redis/maxconns 5
 
+  Or use -update-existing to have the ModifyIndex read from the key first,
+  retrying if the key changes before it's written. The new ModifyIndex is
+  printed, on its own with -quiet, so further CAS operations can be chained:
+
+      $ consul kv put -quiet -cas -update-existing config/redis/maxconns 5
+
+  Several keys can be written at once with -pairs, giving each as KEY=VALUE,
+  split on the first "=", so the value may contain more of them:
+
+      $ consul kv put -pairs app/host=db.local app/port=5432 app/dsn=user=app
+
+  Or they can be read from a file of KEY=VALUE lines in the style of a .env
+  file, or from stdin with "-". Any KEY=VALUE arguments are written along with
+  the file, and override it:
+
+      $ consul kv put -from-env-file=app.env app/port=5433
+
+  If the same key is given more than once, the last value wins. The keys are
+  written in a single transaction, so either all of them are written or none
+  are. Use -no-atomic to write them one at a time instead, such as when there
+  are too many to fit in a transaction. The -base64 and -flags options apply to
+  every key, but several keys can't be written with CAS or lock operations.
+  Without -pairs or -from-env-file, the arguments are always KEY [DATA], so
+  "consul kv put a=b" writes the key "a=b".
+
   Additional flags and more advanced use cases are detailed below.
 
 ` + apiOptsText + `
 
+` + kvKeyOptsText + `
+
 KV Put Options:
 
+  -allow-empty            Write an empty DATA argument without a warning,
+                          even with -require-data or -min-size. The default
+                          value is false.
+
   -acquire                Obtain a lock on the key. If the key does not exist,
                           this operation will create the key and obtain the
                           lock. The session must already exist and be specified
-                          via the -session flag. The default value is false.
+                          via the -session flag. This fails if the lock is held
+                          by another session, and can't be combined with -cas.
+                          The default value is false.
 
   -base64                 Treat the data as base 64 encoded. The default value
                           is false.
 
   -cas                    Perform a Check-And-Set operation. Specifying this
-                          value also requires the -modify-index flag to be set.
-                          The default value is false.
+                          value also requires the -modify-index flag or the
+                          -update-existing flag to be set. The default value
+                          is false.
+
+  -from-env-file=<path>   Read keys to write from a file of KEY=VALUE lines, or
+                          from stdin if the path is "-". Blank lines, comments
+                          starting with "#", and an "export" before the key are
+                          ignored. Values may be quoted with single quotes,
+                          which are taken as-is, or with double quotes, which
+                          allow escapes such as "\n" and may span lines.
 
   -flags=<int>            Unsigned integer value to assign to this key-value
                           pair. This value is not read by Consul, so clients can
                           use this value however makes sense for their use case.
                           The default value is 0 (no flags).
 
+  -min-size=<bytes>       Refuse to write a value smaller than this many bytes,
+                          after any base 64 decoding. A key written without
+                          DATA is not checked. The default value is 0 (no
+                          minimum).
+
   -modify-index=<int>     Unsigned integer representing the ModifyIndex of the
                           key. This is used in combination with the -cas flag.
 
-  -release                Forfeit the lock on the key at the givne path. This
+  -must-exist             Only update the key with -update-existing if it
+                          already exists, rather than creating it. The default
+                          value is false.
+
+  -no-atomic              Write several keys one at a time instead of in a
+                          single transaction. If a write fails, the keys before
+                          it have already been written. The default value is
+                          false.
+
+  -require-data           Refuse to write an empty value given as DATA, or in
+                          a KEY=VALUE, instead of warning about it. A key
+                          written without DATA is still allowed. The default
+                          value is false.
+
+  -retries=<int>          Number of times to read the key again and retry
+                          the write when it changes during -update-existing.
+                          This is separate from -retry, which covers transient
+                          errors. The default value is 3.
+
+  -pairs                  Write several keys, each given as a KEY=VALUE
+                          argument, instead of a single KEY [DATA]. This is
+                          implied by -from-env-file. The default value is
+                          false.
+
+  -release                Forfeit the lock on the key at the given path. This
                           requires the -session flag to be set. The key must be
-                          held by the session in order to be unlocked. The
-                          default value is false.
+                          held by the session in order to be unlocked. This
+                          can't be combined with -cas. The default value is
+                          false.
 
   -session=<string>       User-defined identifer for this session as a string.
                           This is commonly used with the -acquire and -release
                           operations to build robust locking, but it can be set
                           on any key. The default value is empty (no session).
+
+  -update-existing        Read the ModifyIndex for the -cas operation from the
+                          key, instead of -modify-index. If the key doesn't
+                          exist, it is only created if nothing else creates it
+                          first. The ModifyIndex of the written key is printed
+                          on success, after the success message unless -quiet
+                          is given. The default value is false.
 `
 	return strings.TrimSpace(helpText)
 }
 
-func (c *KVPutCommand) Run(args []string) int {
-	cmdFlags := flag.NewFlagSet("get", flag.ContinueOnError)
+func (c *KVPutCommand) Run(args []string) (code int) {
+	cmdFlags, apiFlags := NewAPIFlagSet("get", c.Ui)
+	defer apiFlags.CheckTimeout(c.Ui, &code)
 	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
-	httpAddr := HTTPAddrFlag(cmdFlags)
-	datacenter := cmdFlags.String("datacenter", "", "")
-	token := cmdFlags.String("token", "", "")
 	cas := cmdFlags.Bool("cas", false, "")
 	flags := cmdFlags.Uint64("flags", 0, "")
 	base64encoded := cmdFlags.Bool("base64", false, "")
@@ -107,45 +203,145 @@ func (c *KVPutCommand) Run(args []string) int {
 	session := cmdFlags.String("session", "", "")
 	acquire := cmdFlags.Bool("acquire", false, "")
 	release := cmdFlags.Bool("release", false, "")
-	if err := cmdFlags.Parse(args); err != nil {
+	updateExisting := cmdFlags.Bool("update-existing", false, "")
+	mustExist := cmdFlags.Bool("must-exist", false, "")
+	retries := cmdFlags.Int("retries", 3, "")
+	fromEnvFile := cmdFlags.String("from-env-file", "", "")
+	noAtomic := cmdFlags.Bool("no-atomic", false, "")
+	pairsMode := cmdFlags.Bool("pairs", false, "")
+	requireData := cmdFlags.Bool("require-data", false, "")
+	allowEmpty := cmdFlags.Bool("allow-empty", false, "")
+	minSize := cmdFlags.Int("min-size", 0, "")
+	keyFlags := newKVKeyFlags(cmdFlags)
+	if err := parseFlags(c.Ui, cmdFlags, args); err != nil {
+		return 1
+	}
+
+	if *minSize < 0 {
+		c.Ui.Error("Error! -min-size must not be negative")
 		return 1
 	}
+	checkValue := func(key string, value []byte) bool {
+		return c.checkValue(key, value, *requireData, *allowEmpty, *minSize)
+	}
 
 	// Check for arg validation
 	args = cmdFlags.Args()
+	if *pairsMode || *fromEnvFile != "" {
+		if *cas || *modifyIndex != 0 || *updateExisting || *mustExist ||
+			*acquire || *release || *session != "" {
+			c.Ui.Error("Error! Cannot write several keys with -acquire, -cas, " +
+				"-modify-index, -must-exist, -release, -session, or -update-existing")
+			return 1
+		}
+
+		pairs, err := c.pairsFromArgs(args, *fromEnvFile, *flags, *base64encoded, keyFlags)
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Error! %s", err))
+			return 1
+		}
+		for _, pair := range pairs {
+			if !checkValue(pair.Key, pair.Value) {
+				return 1
+			}
+		}
+
+		client, err := apiFlags.Client()
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+			return 1
+		}
+		if *noAtomic {
+			return c.putEach(client, pairs, apiFlags)
+		}
+		return c.putAtomic(client, pairs, apiFlags)
+	}
+	if *noAtomic {
+		c.Ui.Error("Error! Can only specify -no-atomic with -pairs or -from-env-file")
+		return 1
+	}
+
+	// KEY=VALUE arguments without -pairs are most likely meant as several
+	// keys, which would otherwise only be reported as too many arguments.
+	if len(args) > 2 && strings.Contains(args[0], "=") {
+		c.Ui.Error(fmt.Sprintf("Error! Too many arguments (expected 1 or 2, got %d). "+
+			"Use -pairs to write several keys given as KEY=VALUE", len(args)))
+		return 1
+	}
+
 	key, data, err := c.dataFromArgs(args)
 	if err != nil {
 		c.Ui.Error(fmt.Sprintf("Error! %s", err))
 		return 1
 	}
+	if key, err = keyFlags.check(c.Ui, key); err != nil {
+		c.Ui.Error(fmt.Sprintf("Error! %s", err))
+		return 1
+	}
 
 	dataBytes := []byte(data)
 	if *base64encoded {
 		dataBytes, err = base64.StdEncoding.DecodeString(data)
 		if err != nil {
 			c.Ui.Error(fmt.Sprintf("Error! Cannot base 64 decode data: %s", err))
+			return 1
 		}
 	}
 
+	// Catch values the agent would reject before sending them
+	if len(dataBytes) > kvMaxValueSize {
+		c.Ui.Error(fmt.Sprintf("Error! Value is too large (%d > %d bytes)", len(dataBytes), kvMaxValueSize))
+		return 1
+	}
+
+	// A key written without DATA is meant to be empty, while empty DATA is
+	// more likely an unset variable.
+	if len(args) == 2 && !checkValue(key, dataBytes) {
+		return 1
+	}
+
 	// Session is reauired for release or acquire
 	if (*release || *acquire) && *session == "" {
 		c.Ui.Error("Error! Missing -session (required with -acquire and -release)")
 		return 1
 	}
+	if *release && *acquire {
+		c.Ui.Error("Error! Cannot specify both -acquire and -release")
+		return 1
+	}
+
+	// Locks are checked against the session, not the ModifyIndex
+	if (*release || *acquire) && *cas {
+		c.Ui.Error("Error! Cannot combine -acquire or -release with -cas")
+		return 1
+	}
+
+	// The ModifyIndex is read from the key with -update-existing
+	if *updateExisting {
+		switch {
+		case !*cas:
+			c.Ui.Error("Error! -update-existing requires -cas")
+			return 1
+		case *modifyIndex != 0:
+			c.Ui.Error("Error! Cannot specify -modify-index with -update-existing")
+			return 1
+		case *retries < 0:
+			c.Ui.Error("Error! -retries must not be negative")
+			return 1
+		}
+	} else if *mustExist {
+		c.Ui.Error("Error! -must-exist requires -update-existing")
+		return 1
+	}
 
 	// ModifyIndex is required for CAS
-	if *cas && *modifyIndex == 0 {
+	if *cas && *modifyIndex == 0 && !*updateExisting {
 		c.Ui.Error("Must specify -modify-index with -cas!")
 		return 1
 	}
 
 	// Create and test the HTTP client
-	conf := api.DefaultConfig()
-	conf.Address = *httpAddr
-	if *token != "" {
-		conf.Token = *token
-	}
-	client, err := api.NewClient(conf)
+	client, err := apiFlags.Client()
 	if err != nil {
 		c.Ui.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
 		return 1
@@ -159,16 +355,16 @@ func (c *KVPutCommand) Run(args []string) int {
 		Session:     *session,
 	}
 
-	wo := &api.WriteOptions{
-		Datacenter: *datacenter,
-		Token:      *token,
-	}
+	wo := apiFlags.WriteOptions()
 
 	switch {
+	case *cas && *updateExisting:
+		return c.updateExisting(client, pair, apiFlags, *retries, *mustExist)
 	case *cas:
-		ok, _, err := client.KV().CAS(pair, wo)
+		ok, err := c.cas(client, pair, apiFlags)
 		if err != nil {
-			c.Ui.Error(fmt.Sprintf("Error! Did not write to %s: %s", key, err))
+			c.Ui.Error(apiFlags.errorMessage(fmt.Sprintf("Error! Did not write to %s", key),
+				err, kvDenial("write to "+key, key, "write")))
 			return 1
 		}
 		if !ok {
@@ -176,49 +372,274 @@ func (c *KVPutCommand) Run(args []string) int {
 			return 1
 		}
 
-		c.Ui.Info(fmt.Sprintf("Success! Data written to: %s", key))
+		apiFlags.report(c.Ui, fmt.Sprintf("Success! Data written to: %s%s", key, describeBinary(dataBytes)))
 		return 0
 	case *acquire:
 		ok, _, err := client.KV().Acquire(pair, wo)
 		if err != nil {
-			c.Ui.Error(fmt.Sprintf("Error! Failed writing data: %s", err))
+			c.Ui.Error(apiFlags.errorMessage("Error! Failed writing data", err,
+				kvDenial("write to "+key, key, "write")))
 			return 1
 		}
 		if !ok {
-			c.Ui.Error("Error! Did not acquire lock")
+			c.Ui.Error(fmt.Sprintf("Error! Did not acquire lock on %s: %s",
+				key, c.lockHolder(client, key, apiFlags.QueryOptions())))
 			return 1
 		}
 
-		c.Ui.Info(fmt.Sprintf("Success! Lock acquired on: %s", key))
+		apiFlags.report(c.Ui, fmt.Sprintf("Success! Lock acquired on: %s", key))
 		return 0
 	case *release:
 		ok, _, err := client.KV().Release(pair, wo)
 		if err != nil {
-			c.Ui.Error(fmt.Sprintf("Error! Failed writing data: %s", key))
+			c.Ui.Error(apiFlags.errorMessage("Error! Failed writing data", err,
+				kvDenial("write to "+key, key, "write")))
 			return 1
 		}
 		if !ok {
-			c.Ui.Error("Error! Did not release lock")
+			c.Ui.Error(fmt.Sprintf("Error! Did not release lock on %s: %s",
+				key, c.lockHolder(client, key, apiFlags.QueryOptions())))
 			return 1
 		}
 
-		c.Ui.Info(fmt.Sprintf("Success! Lock released on: %s", key))
+		apiFlags.report(c.Ui, fmt.Sprintf("Success! Lock released on: %s", key))
 		return 0
 	default:
 		if _, err := client.KV().Put(pair, wo); err != nil {
-			c.Ui.Error(fmt.Sprintf("Error! Failed writing data: %s", err))
+			c.Ui.Error(apiFlags.errorMessage("Error! Failed writing data", err,
+				kvDenial("write to "+key, key, "write")))
 			return 1
 		}
 
-		c.Ui.Info(fmt.Sprintf("Success! Data written to: %s", key))
+		apiFlags.report(c.Ui, fmt.Sprintf("Success! Data written to: %s%s", key, describeBinary(dataBytes)))
 		return 0
 	}
 }
 
+// checkValue checks a value given as DATA or in a KEY=VALUE against
+// -require-data, -allow-empty, and -min-size, printing a warning for an empty
+// value which is still allowed. It returns false if the value is refused.
+func (c *KVPutCommand) checkValue(key string, value []byte, requireData, allowEmpty bool, minSize int) bool {
+	switch {
+	case len(value) == 0 && allowEmpty:
+		return true
+	case len(value) == 0 && requireData:
+		c.Ui.Error(fmt.Sprintf("Error! Refusing to write an empty value to %s with -require-data. "+
+			"Use -allow-empty if this is intended", key))
+		return false
+	case len(value) < minSize:
+		c.Ui.Error(fmt.Sprintf("Error! Refusing to write a %d byte value to %s, which is smaller than "+
+			"the -min-size of %d bytes", len(value), key, minSize))
+		return false
+	case len(value) == 0:
+		c.Ui.Warn(fmt.Sprintf("Warning! Writing an empty value to %s. Use -allow-empty if this is "+
+			"intended, or -require-data to refuse empty values.", key))
+	}
+	return true
+}
+
+// cas writes the pair with a CAS operation against its ModifyIndex. The
+// write can't just be sent again after a transient error, since it fails if
+// the first attempt went through. Instead the key is read again: the write
+// is retried if the ModifyIndex hasn't moved, and counted as done if the key
+// now holds the new value.
+func (c *KVPutCommand) cas(client *api.Client, pair *api.KVPair, apiFlags *APIFlags) (bool, error) {
+	for attempt := 0; ; attempt++ {
+		ok, _, err := client.KV().CAS(pair, apiFlags.WriteOptions())
+		if err == nil {
+			return ok, nil
+		}
+		if !isTransientError(err) ||
+			!apiFlags.retryWait(apiFlags.ctx, attempt, fmt.Sprintf("CAS on %s failed: %s", pair.Key, err)) {
+			return false, err
+		}
+
+		current, _, err := client.KV().Get(pair.Key, apiFlags.QueryOptions())
+		switch {
+		case err != nil:
+			return false, err
+		case current == nil:
+			return false, nil
+		case current.ModifyIndex == pair.ModifyIndex:
+		default:
+			return bytes.Equal(current.Value, pair.Value) && current.Flags == pair.Flags &&
+				current.Session == pair.Session, nil
+		}
+	}
+}
+
+// updateExisting writes the pair with a CAS operation against the key's
+// current ModifyIndex, reading it again and retrying up to retries times if
+// the key changes in between. The write is done in a transaction so the
+// ModifyIndex of the written key can be reported. A transient error is
+// retried the same way, up to -retry times, which is safe since the write
+// only depends on the ModifyIndex that was read.
+func (c *KVPutCommand) updateExisting(client *api.Client, pair *api.KVPair, apiFlags *APIFlags, retries int, mustExist bool) int {
+	q := apiFlags.QueryOptions()
+	attempt, failures := 0, 0
+	for {
+		current, _, err := client.KV().Get(pair.Key, q)
+		if err != nil {
+			c.Ui.Error(apiFlags.errorMessage("Error querying Consul agent", err,
+				kvDenial("read "+pair.Key, pair.Key, "read")))
+			return 1
+		}
+
+		// A ModifyIndex of 0 only lets the write create the key.
+		var index uint64
+		if current != nil {
+			index = current.ModifyIndex
+		} else if mustExist {
+			c.Ui.Error(fmt.Sprintf("Error! No key exists at: %s", pair.Key))
+			return 1
+		}
+
+		if c.testBeforeCAS != nil {
+			c.testBeforeCAS()
+		}
+
+		ops := api.KVTxnOps{
+			&api.KVTxnOp{
+				Verb:    api.KVCAS,
+				Key:     pair.Key,
+				Value:   pair.Value,
+				Flags:   pair.Flags,
+				Index:   index,
+				Session: pair.Session,
+			},
+		}
+		ok, resp, err := kvTxn(client, ops, q)
+		if err != nil {
+			msg := fmt.Sprintf("Write to %s failed: %s", pair.Key, err)
+			if isTransientError(err) && apiFlags.retryWait(apiFlags.ctx, failures, msg) {
+				failures++
+				continue
+			}
+			c.Ui.Error(apiFlags.errorMessage(fmt.Sprintf("Error! Did not write to %s", pair.Key),
+				err, kvDenial("write to "+pair.Key, pair.Key, "write")))
+			return 1
+		}
+		if ok {
+			apiFlags.report(c.Ui, fmt.Sprintf("Success! Data written to: %s%s", pair.Key, describeBinary(pair.Value)))
+			c.Ui.Output(fmt.Sprintf("%d", resp.Results[0].ModifyIndex))
+			return 0
+		}
+
+		// Only retry when the key changed, and report anything else.
+		for _, e := range resp.Errors {
+			if !strings.Contains(e.What, "index is stale") {
+				c.Ui.Error(apiFlags.errorMessage(fmt.Sprintf("Error! Did not write to %s", pair.Key),
+					errors.New(e.What), kvDenial("write to "+pair.Key, pair.Key, "write")))
+				return 1
+			}
+		}
+		if attempt >= retries {
+			c.Ui.Error(fmt.Sprintf("Error! Did not write to %s: CAS failed on every attempt (%d)",
+				pair.Key, attempt+1))
+			return 1
+		}
+		c.Ui.Warn(fmt.Sprintf("Key %s changed before it was written (retry %d of %d)",
+			pair.Key, attempt+1, retries))
+		attempt++
+	}
+}
+
+// putAtomic writes the pairs in a single transaction. A transient error is
+// retried up to -retry times, which is safe since the same values are set
+// each time.
+func (c *KVPutCommand) putAtomic(client *api.Client, pairs []*api.KVPair, apiFlags *APIFlags) int {
+	batches, err := kvTxnBatches(pairs)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error! %s", err))
+		return 1
+	}
+	if len(batches) > 1 {
+		c.Ui.Error(fmt.Sprintf("Error! Too many keys to write in a single transaction "+
+			"(at most %d keys and %d bytes of values); use -no-atomic to write them "+
+			"one at a time", kvMaxTxnOps, kvMaxValueSize))
+		return 1
+	}
+
+	ops := make(api.KVTxnOps, 0, len(pairs))
+	keys := make([]string, 0, len(pairs))
+	for _, pair := range pairs {
+		ops = append(ops, &api.KVTxnOp{
+			Verb:  api.KVSet,
+			Key:   pair.Key,
+			Flags: pair.Flags,
+			Value: pair.Value,
+		})
+		keys = append(keys, pair.Key)
+	}
+
+	for attempt := 0; ; attempt++ {
+		ok, resp, err := kvTxn(client, ops, apiFlags.QueryOptions())
+		if err == nil && !ok {
+			err = newKVTxnError(keys, resp)
+		} else if err != nil && isTransientError(err) &&
+			apiFlags.retryWait(apiFlags.ctx, attempt, fmt.Sprintf("Write failed: %s", err)) {
+			continue
+		}
+		if err != nil {
+			key := kvDeniedKey(err, keys[0])
+			c.Ui.Error(apiFlags.errorMessage("Error! Failed writing data, no keys were written",
+				err, kvDenial("write to "+key, key, "write")))
+			return 1
+		}
+		break
+	}
+
+	for _, pair := range pairs {
+		apiFlags.report(c.Ui, fmt.Sprintf("Success! Data written to: %s%s", pair.Key, describeBinary(pair.Value)))
+	}
+	return 0
+}
+
+// putEach writes the pairs one at a time, stopping at the first failure.
+func (c *KVPutCommand) putEach(client *api.Client, pairs []*api.KVPair, apiFlags *APIFlags) int {
+	for i, pair := range pairs {
+		if _, err := client.KV().Put(pair, apiFlags.WriteOptions()); err != nil {
+			msg := fmt.Sprintf("Error! Failed writing data to %s (%d of %d %s written)",
+				pair.Key, i, len(pairs), pluralKeys(len(pairs)))
+			c.Ui.Error(apiFlags.errorMessage(msg, err, kvDenial("write to "+pair.Key, pair.Key, "write")))
+			return 1
+		}
+		apiFlags.report(c.Ui, fmt.Sprintf("Success! Data written to: %s%s", pair.Key, describeBinary(pair.Value)))
+	}
+	return 0
+}
+
+// lockHolder describes who holds the lock on the key, to explain why a lock
+// couldn't be acquired or released.
+func (c *KVPutCommand) lockHolder(client *api.Client, key string, q *api.QueryOptions) string {
+	pair, _, err := client.KV().Get(key, q)
+	switch {
+	case err != nil:
+		return fmt.Sprintf("error reading key: %s", err)
+	case pair == nil:
+		return "key does not exist"
+	case pair.Session != "":
+		return fmt.Sprintf("held by session %s", pair.Session)
+	default:
+		// A lock released by invalidating its session can't be taken
+		// again until the session's lock-delay has passed.
+		return "not held, but may be in its lock-delay"
+	}
+}
+
 func (c *KVPutCommand) Synopsis() string {
 	return "Sets or updates data in the KV store"
 }
 
+// describeBinary returns a note on the size of the value if it contains binary
+// data, since it can't be shown as-is.
+func describeBinary(value []byte) string {
+	if !isBinary(value) {
+		return ""
+	}
+	return fmt.Sprintf(" (%d bytes of binary data)", len(value))
+}
+
 func (c *KVPutCommand) dataFromArgs(args []string) (string, string, error) {
 	var stdin io.Reader = os.Stdin
 	if c.testStdin != nil {
@@ -264,3 +685,178 @@ func (c *KVPutCommand) dataFromArgs(args []string) (string, string, error) {
 		return key, data, nil
 	}
 }
+
+// pairsFromArgs builds the pairs to write from the given env file, if any,
+// followed by the KEY=VALUE arguments. Each value is split from its key at
+// the first "=". The keys are normalized, and when a key is given more than
+// once, the last value is written, in the place the key first appeared.
+func (c *KVPutCommand) pairsFromArgs(args []string, envFile string, flags uint64, base64encoded bool,
+	keyFlags *kvKeyFlags) ([]*api.KVPair, error) {
+	// The arguments are checked first, so a mistake doesn't consume stdin.
+	var assignments []*api.KVPair
+	for _, arg := range args {
+		i := strings.Index(arg, "=")
+		switch {
+		case i < 0:
+			return nil, fmt.Errorf("Argument %q is not of the form KEY=VALUE. "+
+				"The KEY [DATA] form can only write a single key, and can't be "+
+				"combined with -pairs or -from-env-file", arg)
+		case i == 0:
+			return nil, fmt.Errorf("Missing key in argument %q", arg)
+		}
+		assignments = append(assignments, &api.KVPair{Key: arg[:i], Value: []byte(arg[i+1:])})
+	}
+
+	if envFile != "" {
+		var data []byte
+		var err error
+		if envFile == "-" {
+			var stdin io.Reader = os.Stdin
+			if c.testStdin != nil {
+				stdin = c.testStdin
+			}
+			data, err = ioutil.ReadAll(stdin)
+		} else {
+			data, err = ioutil.ReadFile(envFile)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read env file: %s", err)
+		}
+
+		fromFile, err := parseKVEnvFile(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse env file %s: %s", envFile, err)
+		}
+		assignments = append(fromFile, assignments...)
+	}
+	if len(assignments) == 0 {
+		return nil, fmt.Errorf("No keys to write")
+	}
+
+	var pairs []*api.KVPair
+	seen := make(map[string]*api.KVPair, len(assignments))
+	for _, a := range assignments {
+		key, err := keyFlags.check(c.Ui, a.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		value := a.Value
+		if base64encoded {
+			if value, err = base64.StdEncoding.DecodeString(string(value)); err != nil {
+				return nil, fmt.Errorf("Cannot base 64 decode data for %s: %s", a.Key, err)
+			}
+		}
+		if len(value) > kvMaxValueSize {
+			return nil, fmt.Errorf("Value for %s is too large (%d > %d bytes)",
+				a.Key, len(value), kvMaxValueSize)
+		}
+
+		if pair, ok := seen[key]; ok {
+			pair.Value = value
+			continue
+		}
+		pair := &api.KVPair{Key: key, Flags: flags, Value: value}
+		seen[key] = pair
+		pairs = append(pairs, pair)
+	}
+	return pairs, nil
+}
+
+// parseKVEnvFile parses KEY=VALUE lines in the style of a .env file. Blank
+// lines and comments are skipped, and a leading "export" is ignored. An
+// unquoted value is trimmed and ends at a " #" comment. A value in single
+// quotes is taken as-is, while one in double quotes may contain the escapes
+// \n, \r, \t, \" and \\. Either kind may span several lines, and in double
+// quotes a backslash at the end of a line joins it to the next.
+func parseKVEnvFile(data string) ([]*api.KVPair, error) {
+	var pairs []*api.KVPair
+	lines := strings.Split(strings.Replace(data, "\r\n", "\n", -1), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimLeft(lines[i], " \t")
+		if strings.TrimSpace(line) == "" || line[0] == '#' {
+			continue
+		}
+		if strings.HasPrefix(line, "export ") || strings.HasPrefix(line, "export\t") {
+			line = strings.TrimLeft(line[len("export"):], " \t")
+		}
+
+		eq := strings.Index(line, "=")
+		if eq < 0 {
+			return nil, fmt.Errorf("line %d: expected KEY=VALUE", i+1)
+		}
+		key := strings.TrimSpace(line[:eq])
+		if key == "" || strings.ContainsAny(key, " \t\"'") {
+			return nil, fmt.Errorf("line %d: invalid key %q", i+1, key)
+		}
+
+		value := strings.TrimLeft(line[eq+1:], " \t")
+		if value != "" && (value[0] == '"' || value[0] == '\'') {
+			var err error
+			if value, i, err = unquoteKVEnvValue(lines, i, value); err != nil {
+				return nil, err
+			}
+		} else {
+			for _, comment := range []string{" #", "\t#"} {
+				if j := strings.Index(value, comment); j >= 0 {
+					value = value[:j]
+				}
+			}
+			value = strings.TrimSpace(value)
+		}
+		pairs = append(pairs, &api.KVPair{Key: key, Value: []byte(value)})
+	}
+	return pairs, nil
+}
+
+// unquoteKVEnvValue reads a quoted value which starts with the given text on
+// line i, continuing onto the following lines until the closing quote. It
+// returns the value along with the index of the line it ends on.
+func unquoteKVEnvValue(lines []string, i int, text string) (string, int, error) {
+	quote, start := text[0], i
+	text = text[1:]
+
+	var b bytes.Buffer
+	for {
+		joined := false
+		for j := 0; j < len(text); j++ {
+			ch := text[j]
+			switch {
+			case ch == quote:
+				rest := strings.TrimSpace(text[j+1:])
+				if rest != "" && rest[0] != '#' {
+					return "", 0, fmt.Errorf("line %d: unexpected %q after closing quote", i+1, rest)
+				}
+				return b.String(), i, nil
+			case ch != '\\' || quote == '\'':
+				b.WriteByte(ch)
+			case j == len(text)-1:
+				joined = true
+			default:
+				j++
+				switch text[j] {
+				case 'n':
+					b.WriteByte('\n')
+				case 'r':
+					b.WriteByte('\r')
+				case 't':
+					b.WriteByte('\t')
+				case '"', '\\':
+					b.WriteByte(text[j])
+				default:
+					b.WriteByte('\\')
+					b.WriteByte(text[j])
+				}
+			}
+		}
+
+		i++
+		if i == len(lines) {
+			return "", 0, fmt.Errorf("line %d: missing closing quote", start+1)
+		}
+		if !joined {
+			b.WriteByte('\n')
+		}
+		text = lines[i]
+	}
+}
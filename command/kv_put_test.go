@@ -3,9 +3,14 @@ package command
 import (
 	"bytes"
 	"encoding/base64"
+	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"reflect"
 	"strconv"
 	"strings"
 	"testing"
@@ -38,10 +43,38 @@ func TestKVPutCommand_Validation(t *testing.T) {
 			[]string{"-release", "foo"},
 			"Missing -session",
 		},
+		"-acquire with -release": {
+			[]string{"-acquire", "-release", "-session=abc", "foo"},
+			"Cannot specify both -acquire and -release",
+		},
+		"-acquire with -cas": {
+			[]string{"-acquire", "-session=abc", "-cas", "-modify-index=5", "foo"},
+			"Cannot combine -acquire or -release with -cas",
+		},
+		"-release with -cas": {
+			[]string{"-release", "-session=abc", "-cas", "-update-existing", "foo"},
+			"Cannot combine -acquire or -release with -cas",
+		},
 		"-cas no -modify-index": {
 			[]string{"-cas", "foo"},
 			"Must specify -modify-index",
 		},
+		"-update-existing no -cas": {
+			[]string{"-update-existing", "foo"},
+			"-update-existing requires -cas",
+		},
+		"-update-existing with -modify-index": {
+			[]string{"-cas", "-update-existing", "-modify-index=5", "foo"},
+			"Cannot specify -modify-index",
+		},
+		"-must-exist no -update-existing": {
+			[]string{"-must-exist", "foo"},
+			"-must-exist requires -update-existing",
+		},
+		"negative -retries": {
+			[]string{"-cas", "-update-existing", "-retries=-1", "foo"},
+			"-retries must not be negative",
+		},
 		"no key": {
 			[]string{},
 			"Missing KEY argument",
@@ -50,6 +83,46 @@ func TestKVPutCommand_Validation(t *testing.T) {
 			[]string{"foo", "bar", "baz"},
 			"Too many arguments",
 		},
+		"KEY=VALUE with KEY DATA": {
+			[]string{"-pairs", "a=1", "foo", "bar"},
+			"is not of the form KEY=VALUE",
+		},
+		"-from-env-file with KEY DATA": {
+			[]string{"-from-env-file=-", "foo", "bar"},
+			"is not of the form KEY=VALUE",
+		},
+		"KEY=VALUE missing key": {
+			[]string{"-pairs", "a=1", "=2"},
+			"Missing key",
+		},
+		"KEY=VALUE with -cas": {
+			[]string{"-pairs", "-cas", "-modify-index=5", "a=1"},
+			"Cannot write several keys",
+		},
+		"KEY=VALUE with -session": {
+			[]string{"-pairs", "-session=abc", "a=1", "b=2"},
+			"Cannot write several keys",
+		},
+		"-no-atomic with one key": {
+			[]string{"-no-atomic", "foo", "bar"},
+			"Can only specify -no-atomic",
+		},
+		"KEY=VALUE without -pairs": {
+			[]string{"a=1", "b=2", "c=3"},
+			"Use -pairs to write several keys",
+		},
+		"KEY=VALUE bad base64": {
+			[]string{"-pairs", "-base64", "a=YQ==", "b=not base64!"},
+			"Cannot base 64 decode data for b",
+		},
+		"negative -min-size": {
+			[]string{"-min-size=-1", "foo", "bar"},
+			"-min-size must not be negative",
+		},
+		"-from-env-file missing": {
+			[]string{"-from-env-file=/nope/definitely/not-a-real-file.env"},
+			"Failed to read env file",
+		},
 	}
 
 	for name, tc := range cases {
@@ -101,6 +174,44 @@ func TestKVPutCommand_Run(t *testing.T) {
 	}
 }
 
+func TestKVPutCommand_RunKeyWithEquals(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	// Without -pairs, a key containing "=" is still written as it is.
+	cases := map[string][]string{
+		"a=b": {"a=b"},
+		"c=d": {"c=d", "data"},
+	}
+	for key, args := range cases {
+		ui := new(cli.MockUi)
+		c := &KVPutCommand{Ui: ui}
+		if code := c.Run(append([]string{"-http-addr=" + srv.httpAddr}, args...)); code != 0 {
+			t.Fatalf("%s: bad: %d. %#v", key, code, ui.ErrorWriter.String())
+		}
+
+		pair, _, err := client.KV().Get(key, nil)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if pair == nil {
+			t.Fatalf("%s: missing", key)
+		}
+		if len(args) == 2 && string(pair.Value) != args[1] {
+			t.Fatalf("%s: bad: %#v", key, pair.Value)
+		}
+	}
+
+	keys, _, err := client.KV().Keys("", "", nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if expected := []string{"a=b", "c=d"}; !reflect.DeepEqual(keys, expected) {
+		t.Fatalf("bad: %#v", keys)
+	}
+}
+
 func TestKVPutCommand_RunEmptyDataQuoted(t *testing.T) {
 	srv, client := testAgentWithAPIClient(t)
 	defer srv.Shutdown()
@@ -129,6 +240,79 @@ func TestKVPutCommand_RunEmptyDataQuoted(t *testing.T) {
 	}
 }
 
+func TestKVPutCommand_RequireData(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	const warning = "Warning! Writing an empty value to"
+	cases := []struct {
+		name    string
+		args    []string
+		code    int
+		output  string
+		written []string
+	}{
+		{"empty data", []string{"a", ""}, 0, warning, []string{"a"}},
+		{"no data", []string{"b"}, 0, "", []string{"b"}},
+		{"empty data allowed", []string{"-allow-empty", "c", ""}, 0, "", []string{"c"}},
+		{
+			"require empty data", []string{"-require-data", "d", ""}, 1,
+			"Error! Refusing to write an empty value to d with -require-data", nil,
+		},
+		{"require no data", []string{"-require-data", "e"}, 0, "", []string{"e"}},
+		{"require empty data allowed", []string{"-require-data", "-allow-empty", "f", ""}, 0, "", []string{"f"}},
+		{"require data", []string{"-require-data", "g", "x"}, 0, "", []string{"g"}},
+		{
+			"min size", []string{"-min-size=4", "h", "abc"}, 1,
+			"Error! Refusing to write a 3 byte value to h, which is smaller than the -min-size of 4 bytes", nil,
+		},
+		{"min size met", []string{"-min-size=4", "i", "abcd"}, 0, "", []string{"i"}},
+		{"min size no data", []string{"-min-size=4", "j"}, 0, "", []string{"j"}},
+		{"min size empty data allowed", []string{"-min-size=4", "-allow-empty", "k", ""}, 0, "", []string{"k"}},
+		{"min size base64", []string{"-min-size=4", "-base64", "l", "YWJj"}, 1, "3 byte value to l", nil},
+		{"several empty", []string{"-pairs", "m1=x", "m2="}, 0, warning + " m2", []string{"m1", "m2"}},
+		{
+			"require several empty", []string{"-pairs", "-require-data", "n1=x", "n2="}, 1,
+			"Refusing to write an empty value to n2", nil,
+		},
+	}
+
+	for _, tc := range cases {
+		ui := new(cli.MockUi)
+		c := &KVPutCommand{Ui: ui}
+		code := c.Run(append([]string{"-http-addr=" + srv.httpAddr}, tc.args...))
+		if code != tc.code {
+			t.Fatalf("%s: expected exit %d, got %d. %#v", tc.name, tc.code, code, ui.ErrorWriter.String())
+		}
+
+		errs := ui.ErrorWriter.String()
+		if tc.output == "" && errs != "" || !strings.Contains(errs, tc.output) {
+			t.Fatalf("%s: expected %q to contain %q", tc.name, errs, tc.output)
+		}
+		if tc.output == warning && !strings.Contains(errs, "Use -allow-empty if this is intended") {
+			t.Fatalf("%s: bad: %#v", tc.name, errs)
+		}
+
+		// Nothing is written when a value is refused. Each case uses keys
+		// starting with a letter of its own.
+		var prefix string
+		for _, arg := range tc.args {
+			if !strings.HasPrefix(arg, "-") {
+				prefix = arg[:1]
+				break
+			}
+		}
+		keys, _, err := client.KV().Keys(prefix, "", nil)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if !reflect.DeepEqual(keys, tc.written) {
+			t.Fatalf("%s: bad: %#v", tc.name, keys)
+		}
+	}
+}
+
 func TestKVPutCommand_RunBase64(t *testing.T) {
 	srv, client := testAgentWithAPIClient(t)
 	defer srv.Shutdown()
@@ -375,3 +559,495 @@ func TestKVPutCommand_CAS(t *testing.T) {
 		t.Errorf("bad: %#v", data.Value)
 	}
 }
+
+func TestKVPutCommand_AcquireRelease(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	var sessions []string
+	for i := 0; i < 2; i++ {
+		id, _, err := client.Session().Create(&api.SessionEntry{}, nil)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		sessions = append(sessions, id)
+	}
+	first, second := sessions[0], sessions[1]
+
+	run := func(args ...string) (int, *cli.MockUi) {
+		ui := new(cli.MockUi)
+		c := &KVPutCommand{Ui: ui}
+		return c.Run(append([]string{"-http-addr=" + srv.httpAddr}, args...)), ui
+	}
+	holder := func() string {
+		pair, _, err := client.KV().Get("lock", nil)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if pair == nil {
+			t.Fatalf("missing key")
+		}
+		return pair.Session
+	}
+
+	// The first session gets the lock, creating the key.
+	code, ui := run("-acquire", "-session="+first, "lock", "a")
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if output := ui.OutputWriter.String(); output != "Success! Lock acquired on: lock\n" {
+		t.Fatalf("bad: %q", output)
+	}
+	if holder() != first {
+		t.Fatalf("bad: %q", holder())
+	}
+
+	// The second one can neither acquire nor release it, and says why.
+	expected := "held by session " + first
+	for _, op := range []string{"-acquire", "-release"} {
+		code, ui = run(op, "-session="+second, "lock", "b")
+		if code != 1 {
+			t.Fatalf("%s: bad: %d", op, code)
+		}
+		if output := ui.ErrorWriter.String(); !strings.Contains(output, expected) {
+			t.Fatalf("%s: expected %q to contain %q", op, output, expected)
+		}
+		if holder() != first {
+			t.Fatalf("%s: bad: %q", op, holder())
+		}
+	}
+
+	// Acquiring again with the holder is fine, and once it lets go the
+	// second session can take the lock.
+	if code, ui = run("-acquire", "-session="+first, "lock", "a"); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if code, ui = run("-release", "-session="+first, "lock", "a"); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if holder() != "" {
+		t.Fatalf("bad: %q", holder())
+	}
+	if code, ui = run("-acquire", "-session="+second, "lock", "b"); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if holder() != second {
+		t.Fatalf("bad: %q", holder())
+	}
+
+	pair, _, err := client.KV().Get("lock", nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if string(pair.Value) != "b" || pair.LockIndex != 2 {
+		t.Fatalf("bad: %#v", pair)
+	}
+}
+
+func TestKVPutCommand_UpdateExisting(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	run := func(c *KVPutCommand, args ...string) (int, *cli.MockUi) {
+		ui := new(cli.MockUi)
+		c.Ui = ui
+		args = append([]string{"-http-addr=" + srv.httpAddr, "-cas", "-update-existing"}, args...)
+		return c.Run(args), ui
+	}
+	check := func(ui *cli.MockUi, key, value string) {
+		pair, _, err := client.KV().Get(key, nil)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if pair == nil || string(pair.Value) != value {
+			t.Fatalf("bad: %#v", pair)
+		}
+		expected := fmt.Sprintf("Success! Data written to: %s\n%d\n", key, pair.ModifyIndex)
+		if output := ui.OutputWriter.String(); output != expected {
+			t.Fatalf("bad: %q", output)
+		}
+	}
+
+	// Missing keys are created, unless they must exist.
+	code, ui := run(&KVPutCommand{}, "-must-exist", "foo", "a")
+	if code != 1 || !strings.Contains(ui.ErrorWriter.String(), "No key exists at: foo") {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	code, ui = run(&KVPutCommand{}, "foo", "a")
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	check(ui, "foo", "a")
+
+	// Existing keys are updated.
+	code, ui = run(&KVPutCommand{}, "-must-exist", "foo", "b")
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	check(ui, "foo", "b")
+
+	// With -quiet, only the index is printed.
+	code, ui = run(&KVPutCommand{}, "-quiet", "foo", "b")
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	pair, _, err := client.KV().Get("foo", nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if output := ui.OutputWriter.String(); output != strconv.FormatUint(pair.ModifyIndex, 10)+"\n" {
+		t.Fatalf("bad: %q", output)
+	}
+	if output := ui.ErrorWriter.String(); output != "" {
+		t.Fatalf("bad: %q", output)
+	}
+
+	// A write which races with another is retried.
+	writes := 0
+	race := func() {
+		writes++
+		if writes == 1 {
+			if _, err := client.KV().Put(&api.KVPair{Key: "foo", Value: []byte("x")}, nil); err != nil {
+				t.Fatalf("err: %v", err)
+			}
+		}
+	}
+	code, ui = run(&KVPutCommand{testBeforeCAS: race}, "foo", "c")
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if writes != 2 || !strings.Contains(ui.ErrorWriter.String(), "retry 1 of 3") {
+		t.Fatalf("bad: %d. %#v", writes, ui.ErrorWriter.String())
+	}
+	check(ui, "foo", "c")
+
+	// Without retries, the race fails the write.
+	writes = 0
+	code, ui = run(&KVPutCommand{testBeforeCAS: race}, "-retries=0", "foo", "d")
+	if code != 1 || !strings.Contains(ui.ErrorWriter.String(), "CAS failed") {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	pair, _, err = client.KV().Get("foo", nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if string(pair.Value) != "x" {
+		t.Fatalf("bad: %#v", pair)
+	}
+}
+
+func TestKVPutCommand_CASRetry(t *testing.T) {
+	// The fake agent fails the first write with a server error, which may
+	// or may not have gone through, as given by stored.
+	var writes int
+	var stored *api.KVPair
+	fake := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			w.Header().Set("X-Consul-Index", "1")
+			if stored == nil {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(api.KVPairs{stored})
+			return
+		}
+
+		writes++
+		switch {
+		case writes == 1:
+			w.WriteHeader(http.StatusInternalServerError)
+		case r.URL.Path == "/v1/txn":
+			w.Write([]byte(`{"Results":[{"KV":{"Key":"foo","ModifyIndex":7}}],"Errors":null}`))
+		default:
+			w.Write([]byte("true"))
+		}
+	}))
+	defer fake.Close()
+
+	cases := map[string]struct {
+		args   []string
+		stored *api.KVPair
+		code   int
+		writes int
+	}{
+		"not written": {
+			[]string{"-modify-index=5"},
+			&api.KVPair{Key: "foo", Value: []byte("old"), ModifyIndex: 5},
+			0, 2,
+		},
+		"written": {
+			[]string{"-modify-index=5"},
+			&api.KVPair{Key: "foo", Value: []byte("bar"), ModifyIndex: 6},
+			0, 1,
+		},
+		"changed by another write": {
+			[]string{"-modify-index=5"},
+			&api.KVPair{Key: "foo", Value: []byte("other"), ModifyIndex: 6},
+			1, 1,
+		},
+		"deleted": {
+			[]string{"-modify-index=5"},
+			nil,
+			1, 1,
+		},
+		"update existing": {
+			[]string{"-update-existing"},
+			&api.KVPair{Key: "foo", Value: []byte("old"), ModifyIndex: 5},
+			0, 2,
+		},
+	}
+
+	for name, tc := range cases {
+		writes, stored = 0, tc.stored
+
+		ui := new(cli.MockUi)
+		c := &KVPutCommand{Ui: ui}
+		args := []string{
+			"-http-addr=" + strings.TrimPrefix(fake.URL, "http://"),
+			"-retry=1", "-retry-interval=1ms", "-cas",
+		}
+		args = append(args, tc.args...)
+		if code := c.Run(append(args, "foo", "bar")); code != tc.code {
+			t.Fatalf("%s: bad: %d. %#v", name, code, ui.ErrorWriter.String())
+		}
+		if writes != tc.writes {
+			t.Fatalf("%s: bad: made %d writes", name, writes)
+		}
+		if !strings.Contains(ui.ErrorWriter.String(), "retrying in 1ms") {
+			t.Fatalf("%s: bad: %#v", name, ui.ErrorWriter.String())
+		}
+	}
+}
+
+func TestKVPutCommand_FileBinary(t *testing.T) {
+	srv, _ := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	// Build a value with every byte value, including NULs and newlines.
+	value := make([]byte, 4096)
+	for i := range value {
+		value[i] = byte(i % 256)
+	}
+
+	f, err := ioutil.TempFile("", "kv-put-command-file")
+	if err != nil {
+		t.Fatalf("err: %#v", err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(value); err != nil {
+		t.Fatalf("err: %#v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("err: %#v", err)
+	}
+
+	ui := new(cli.MockUi)
+	c := &KVPutCommand{Ui: ui}
+
+	args := []string{
+		"-http-addr=" + srv.httpAddr,
+		"foo", "@" + f.Name(),
+	}
+
+	code := c.Run(args)
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if !strings.Contains(ui.OutputWriter.String(), "(4096 bytes of binary data)") {
+		t.Fatalf("bad: %#v", ui.OutputWriter.String())
+	}
+
+	ui = new(cli.MockUi)
+	var out bytes.Buffer
+	get := &KVGetCommand{Ui: ui, testStdout: &out}
+
+	args = []string{
+		"-http-addr=" + srv.httpAddr,
+		"-raw",
+		"foo",
+	}
+
+	code = get.Run(args)
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if !bytes.Equal(out.Bytes(), value) {
+		t.Fatalf("bad: %q", out.Bytes())
+	}
+}
+
+func TestKVPutCommand_TooLarge(t *testing.T) {
+	ui := new(cli.MockUi)
+	c := &KVPutCommand{
+		Ui:        ui,
+		testStdin: bytes.NewReader(make([]byte, kvMaxValueSize+1)),
+	}
+
+	// No agent is running, so this would fail differently if the command
+	// tried to send the value.
+	code := c.Run([]string{"foo", "-"})
+	if code != 1 {
+		t.Fatalf("bad: %d", code)
+	}
+	if !strings.Contains(ui.ErrorWriter.String(), "Value is too large") {
+		t.Fatalf("bad: %#v", ui.ErrorWriter.String())
+	}
+}
+
+func TestKVPutCommand_BadBase64(t *testing.T) {
+	ui := new(cli.MockUi)
+	c := &KVPutCommand{Ui: ui}
+
+	code := c.Run([]string{"-base64", "foo", "not base64!"})
+	if code != 1 {
+		t.Fatalf("bad: %d", code)
+	}
+	if !strings.Contains(ui.ErrorWriter.String(), "Cannot base 64 decode data") {
+		t.Fatalf("bad: %#v", ui.ErrorWriter.String())
+	}
+}
+
+func TestKVPutCommand_Multi(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	envFile, err := ioutil.TempFile("", "kv-put-command-env")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.Remove(envFile.Name())
+	if _, err := envFile.WriteString("app/port=5432\napp/dsn=\"host=db\\nuser=app\"\n"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	envFile.Close()
+
+	cases := map[string]struct {
+		args     []string
+		stdin    string
+		expected map[string]string
+	}{
+		"args": {
+			[]string{"-pairs", "a=1", "b=x=y", "c=", "a=2"},
+			"",
+			map[string]string{"a": "2", "b": "x=y", "c": ""},
+		},
+		"env file": {
+			[]string{"-from-env-file=" + envFile.Name(), "app/port=5433"},
+			"",
+			map[string]string{"app/port": "5433", "app/dsn": "host=db\nuser=app"},
+		},
+		"env stdin": {
+			[]string{"-from-env-file=-", "-no-atomic", "-base64"},
+			"one=MQ==\ntwo=Mg==\n",
+			map[string]string{"one": "1", "two": "2"},
+		},
+	}
+
+	for name, tc := range cases {
+		ui := new(cli.MockUi)
+		c := &KVPutCommand{Ui: ui, testStdin: strings.NewReader(tc.stdin)}
+		code := c.Run(append([]string{"-http-addr=" + srv.httpAddr, "-flags=7"}, tc.args...))
+		if code != 0 {
+			t.Fatalf("%s: bad: %d. %#v", name, code, ui.ErrorWriter.String())
+		}
+		if n := strings.Count(ui.OutputWriter.String(), "Success! Data written to: "); n != len(tc.expected) {
+			t.Fatalf("%s: bad: %#v", name, ui.OutputWriter.String())
+		}
+
+		for key, value := range tc.expected {
+			pair, _, err := client.KV().Get(key, nil)
+			if err != nil {
+				t.Fatalf("%s: err: %v", name, err)
+			}
+			if pair == nil || string(pair.Value) != value || pair.Flags != 7 {
+				t.Fatalf("%s: bad: %s: %#v", name, key, pair)
+			}
+		}
+	}
+
+	// Nothing is written if the transaction is too big.
+	args := []string{"-http-addr=" + srv.httpAddr, "-pairs"}
+	for i := 0; i <= kvMaxTxnOps; i++ {
+		args = append(args, fmt.Sprintf("many/%d=x", i))
+	}
+	ui := new(cli.MockUi)
+	c := &KVPutCommand{Ui: ui}
+	if code := c.Run(args); code != 1 || !strings.Contains(ui.ErrorWriter.String(), "use -no-atomic") {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if keys, _, err := client.KV().Keys("many/", "", nil); err != nil || len(keys) != 0 {
+		t.Fatalf("bad: %v %v", keys, err)
+	}
+
+	ui = new(cli.MockUi)
+	c = &KVPutCommand{Ui: ui}
+	if code := c.Run(append([]string{"-no-atomic"}, args...)); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if keys, _, err := client.KV().Keys("many/", "", nil); err != nil || len(keys) != kvMaxTxnOps+1 {
+		t.Fatalf("bad: %d %v", len(keys), err)
+	}
+}
+
+func TestParseKVEnvFile(t *testing.T) {
+	data := strings.Join([]string{
+		"# A comment",
+		"",
+		"PLAIN=value",
+		"  SPACED = some value   # comment",
+		"export EXPORTED=1",
+		"EMPTY=",
+		"EQUALS=a=b=c",
+		"HASH=a#b",
+		`DOUBLE="line one\nline \"two\"\t\\ \$x" # comment`,
+		`SINGLE='no \n escapes # here'`,
+		`MULTI="first`,
+		`second"`,
+		`JOINED="first \`,
+		`second"`,
+		"CRLF=yes\r",
+	}, "\n")
+
+	pairs, err := parseKVEnvFile(data)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	expected := []string{
+		"PLAIN", "value",
+		"SPACED", "some value",
+		"EXPORTED", "1",
+		"EMPTY", "",
+		"EQUALS", "a=b=c",
+		"HASH", "a#b",
+		"DOUBLE", "line one\nline \"two\"\t\\ \\$x",
+		"SINGLE", `no \n escapes # here`,
+		"MULTI", "first\nsecond",
+		"JOINED", "first second",
+		"CRLF", "yes",
+	}
+	if len(pairs) != len(expected)/2 {
+		t.Fatalf("bad: %d pairs", len(pairs))
+	}
+	for i, pair := range pairs {
+		if pair.Key != expected[2*i] || string(pair.Value) != expected[2*i+1] {
+			t.Fatalf("bad: %d: %q=%q", i, pair.Key, pair.Value)
+		}
+	}
+
+	errors := map[string]string{
+		"NOVALUE":               "line 1: expected KEY=VALUE",
+		"A=1\n=2":               "line 2: invalid key",
+		"BAD KEY=1":             "line 1: invalid key",
+		"A=\"unterminated\nB=1": "line 1: missing closing quote",
+		"A='x' y":               "line 1: unexpected",
+	}
+	for data, expected := range errors {
+		if _, err := parseKVEnvFile(data); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Fatalf("%q: bad: %v", data, err)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package command
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// kvRedactedValue replaces the values, or the parts of them, which are
+// redacted from an export.
+const kvRedactedValue = "<redacted>"
+
+// kvRedactor scrubs secrets from the values of an export, so it can be
+// shared. Keys matching the -redact patterns have their whole value
+// replaced, and the parts of other values matching a -redact-pattern
+// regular expression are masked.
+type kvRedactor struct {
+	key      func(string) bool
+	patterns []*regexp.Regexp
+}
+
+// newKVRedactor returns a redactor for the given key patterns, which match
+// the same way as -exclude, and regular expressions.
+func newKVRedactor(keys, patterns []string) (*kvRedactor, error) {
+	key, err := newKVKeyFilter("redact", keys)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &kvRedactor{key: key}
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid -redact-pattern %q: %s", p, err)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	return r, nil
+}
+
+// redact returns the value to export for the key, and whether any of it was
+// redacted.
+func (r *kvRedactor) redact(key string, value []byte) ([]byte, bool) {
+	if r.key(key) {
+		return []byte(kvRedactedValue), true
+	}
+
+	redacted := false
+	for _, re := range r.patterns {
+		var masked bool
+		value, masked = kvMask(re, value)
+		redacted = redacted || masked
+	}
+	return value, redacted
+}
+
+// kvMask replaces each match of the regular expression in the value with the
+// redacted marker. If the expression has groups, only the text they match is
+// replaced, so "password=(\S+)" keeps the "password=" and masks the rest.
+// Empty matches are left alone.
+func kvMask(re *regexp.Regexp, value []byte) ([]byte, bool) {
+	matches := re.FindAllSubmatchIndex(value, -1)
+	if matches == nil {
+		return value, false
+	}
+
+	var out []byte
+	last := 0
+	for _, m := range matches {
+		spans := m[:2]
+		if re.NumSubexp() > 0 {
+			spans = m[2:]
+		}
+		for i := 0; i < len(spans); i += 2 {
+			// Groups which didn't take part in the match have a start of
+			// -1, and nested groups start inside one already masked.
+			start, end := spans[i], spans[i+1]
+			if start < last || start == end {
+				continue
+			}
+			out = append(out, value[last:start]...)
+			out = append(out, kvRedactedValue...)
+			last = end
+		}
+	}
+	if out == nil {
+		return value, false
+	}
+	return append(out, value[last:]...), true
+}
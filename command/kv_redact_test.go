@@ -0,0 +1,30 @@
+package command
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestKVMask(t *testing.T) {
+	cases := []struct {
+		pattern string
+		value   string
+		masked  string
+	}{
+		{`hunter2`, "password=hunter2", "password=<redacted>"},
+		{`password=\S+`, "a password=x b password=y", "a <redacted> b <redacted>"},
+		{`password=(\S+)`, "a password=x b password=y", "a password=<redacted> b password=<redacted>"},
+		{`(user|pass)=(\S+)`, "user=app pass=x", "<redacted>=<redacted> <redacted>=<redacted>"},
+		{`token=(\S+)|secret=(\S+)`, "secret=x token=y", "secret=<redacted> token=<redacted>"},
+		{`((a)b)`, "xaby", "x<redacted>y"},
+		{`x*`, "abc", "abc"},
+		{`nope`, "abc", "abc"},
+	}
+
+	for _, tc := range cases {
+		masked, ok := kvMask(regexp.MustCompile(tc.pattern), []byte(tc.value))
+		if string(masked) != tc.masked || ok != (tc.masked != tc.value) {
+			t.Fatalf("%s %q: bad: %q %v", tc.pattern, tc.value, masked, ok)
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package command
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+)
+
+// KVRenderCommand is a Command implementation that is used to render a
+// template file once using values from the key-value store.
+type KVRenderCommand struct {
+	Ui cli.Ui
+
+	// testStdout is the output for the rendered template, for testing. The
+	// result never goes through the Ui, which may decorate what it prints.
+	testStdout io.Writer
+}
+
+func (c *KVRenderCommand) Synopsis() string {
+	return "Renders a template file with values from the KV store"
+}
+
+func (c *KVRenderCommand) Help() string {
+	helpText := `
+Usage: consul kv render [options] TEMPLATE_FILE
+
+  Renders a Go template file once using values from the key-value store, and
+  writes the result to stdout or the file given with -output. This covers the
+  simple cases of consul-template without running it:
+
+      $ consul kv render -output=app.conf app.conf.tmpl
+
+  The template can use these functions to look up values:
+
+      key "path"                  The value of the key, as a string.
+      keyOrDefault "path" "def"   The value of the key, or "def" if it
+                                  doesn't exist.
+      tree "prefix"               A map of the keys under the prefix, with
+                                  the prefix removed, to their values. Folder
+                                  keys ending in "/" are left out, and a
+                                  prefix with no keys gives an empty map.
+
+  For example, to write a line for each key under "app/config":
+
+      {{ range $name, $value := tree "app/config" }}
+      {{ $name }} = {{ $value }}
+      {{ end }}
+
+  Each key is looked up once, when the template first uses it. Values are
+  written exactly as they're stored, without any escaping. If a key used with
+  "key" doesn't exist, nothing is written and the command exits with status 2.
+
+` + apiOptsText + `
+
+KV Render Options:
+
+  -output=<path>          Write the result to the given file instead of
+                          stdout. The result is written to a temporary file
+                          which is renamed into place once it's complete, so
+                          a failed render never leaves a partial file. A new
+                          file is only readable by the current user.
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *KVRenderCommand) Run(args []string) (code int) {
+	cmdFlags, apiFlags := NewAPIFlagSet("render", c.Ui)
+	defer apiFlags.CheckTimeout(c.Ui, &code)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	output := cmdFlags.String("output", "", "")
+	if err := parseFlags(c.Ui, cmdFlags, args); err != nil {
+		return 1
+	}
+
+	var file string
+	args = cmdFlags.Args()
+	switch len(args) {
+	case 0:
+		c.Ui.Error("Error! Missing TEMPLATE_FILE argument")
+		return 1
+	case 1:
+		file = args[0]
+	default:
+		c.Ui.Error(fmt.Sprintf("Too many arguments (expected 1, got %d)", len(args)))
+		return 1
+	}
+
+	// Parse the template before connecting, so a bad template fails without
+	// any requests
+	text, err := ioutil.ReadFile(file)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error! Failed to read template: %s", err))
+		return 1
+	}
+	r := &kvRenderer{
+		values: make(map[string]*api.KVPair),
+		trees:  make(map[string]map[string]string),
+	}
+	tmpl, err := template.New(filepath.Base(file)).Funcs(template.FuncMap{
+		"key":          r.key,
+		"keyOrDefault": r.keyOrDefault,
+		"tree":         r.tree,
+	}).Parse(string(text))
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error! Failed to parse template: %s", err))
+		return 1
+	}
+
+	// Create and test the HTTP client
+	client, err := apiFlags.Client()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+		return 1
+	}
+	r.client, r.q = client, apiFlags.QueryOptions()
+
+	// Render into memory first, so nothing is written if a lookup fails
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, nil); err != nil {
+		if r.queryErr != nil {
+			c.Ui.Error(apiFlags.errorMessage("Error querying Consul agent", r.queryErr,
+				kvDenial("read "+r.failed, r.failed, "read")))
+			return exitCommError
+		}
+		c.Ui.Error(fmt.Sprintf("Error! Failed to render template: %s", err))
+		if r.missing != "" {
+			return exitNotFound
+		}
+		return 1
+	}
+
+	if *output == "" {
+		if _, err := c.stdout().Write(buf.Bytes()); err != nil {
+			c.Ui.Error(fmt.Sprintf("Error! Failed to write output: %s", err))
+			return 1
+		}
+		return 0
+	}
+	if err := kvRenderWriteFile(*output, buf.Bytes()); err != nil {
+		c.Ui.Error(fmt.Sprintf("Error! Failed to write output file: %s", err))
+		return 1
+	}
+	apiFlags.report(c.Ui, fmt.Sprintf("Success! Rendered %s to %s", file, *output))
+	return 0
+}
+
+// stdout returns the writer for results which aren't written to a file.
+func (c *KVRenderCommand) stdout() io.Writer {
+	if c.testStdout != nil {
+		return c.testStdout
+	}
+	return os.Stdout
+}
+
+// kvRenderer provides the template functions for a render, looking up each
+// key or prefix the first time it's used. A lookup which fails is recorded
+// so the command can tell why the template failed.
+type kvRenderer struct {
+	client *api.Client
+	q      *api.QueryOptions
+
+	values map[string]*api.KVPair
+	trees  map[string]map[string]string
+
+	// missing is the first key which didn't exist, and queryErr the first
+	// request which failed, for the key or prefix named by failed.
+	missing  string
+	queryErr error
+	failed   string
+}
+
+// get returns the pair for the key, or nil if it doesn't exist.
+func (r *kvRenderer) get(path string) (*api.KVPair, error) {
+	path = strings.TrimPrefix(path, "/")
+	if pair, ok := r.values[path]; ok {
+		return pair, nil
+	}
+
+	pair, _, err := r.client.KV().Get(path, r.q)
+	if err != nil {
+		if r.queryErr == nil {
+			r.queryErr, r.failed = err, path
+		}
+		return nil, err
+	}
+	r.values[path] = pair
+	return pair, nil
+}
+
+func (r *kvRenderer) key(path string) (string, error) {
+	pair, err := r.get(path)
+	if err != nil {
+		return "", err
+	}
+	if pair == nil {
+		if r.missing == "" {
+			r.missing = path
+		}
+		return "", fmt.Errorf("key %s does not exist", path)
+	}
+	return string(pair.Value), nil
+}
+
+func (r *kvRenderer) keyOrDefault(path, def string) (string, error) {
+	pair, err := r.get(path)
+	if err != nil {
+		return "", err
+	}
+	if pair == nil {
+		return def, nil
+	}
+	return string(pair.Value), nil
+}
+
+func (r *kvRenderer) tree(prefix string) (map[string]string, error) {
+	prefix = normalizeKVPrefix(prefix)
+	if tree, ok := r.trees[prefix]; ok {
+		return tree, nil
+	}
+
+	pairs, _, err := r.client.KV().List(prefix, r.q)
+	if err != nil {
+		if r.queryErr == nil {
+			r.queryErr, r.failed = err, prefix
+		}
+		return nil, err
+	}
+
+	tree := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		if strings.HasSuffix(pair.Key, "/") {
+			continue
+		}
+		tree[strings.TrimPrefix(pair.Key, prefix)] = string(pair.Value)
+	}
+	r.trees[prefix] = tree
+	return tree, nil
+}
+
+// kvRenderWriteFile writes the data to a temporary file next to the path,
+// then renames it into place.
+func kvRenderWriteFile(path string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
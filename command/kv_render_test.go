@@ -0,0 +1,186 @@
+package command
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+)
+
+func TestKVRenderCommand_implements(t *testing.T) {
+	var _ cli.Command = &KVRenderCommand{}
+}
+
+func TestKVRenderCommand_noTabs(t *testing.T) {
+	assertNoTabs(t, new(KVRenderCommand))
+}
+
+func TestKVRenderCommand_Validation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kv-render")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	bad := filepath.Join(dir, "bad.tmpl")
+	if err := ioutil.WriteFile(bad, []byte(`{{ key "a" `), 0644); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	unknown := filepath.Join(dir, "unknown.tmpl")
+	if err := ioutil.WriteFile(unknown, []byte(`{{ secret "a" }}`), 0644); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	cases := map[string]struct {
+		args   []string
+		output string
+	}{
+		"no args": {
+			[]string{},
+			"Missing TEMPLATE_FILE argument",
+		},
+		"extra args": {
+			[]string{"a", "b"},
+			"Too many arguments (expected 1, got 2)",
+		},
+		"missing file": {
+			[]string{filepath.Join(dir, "nope.tmpl")},
+			"Error! Failed to read template",
+		},
+		"bad template": {
+			[]string{bad},
+			"Error! Failed to parse template: template: bad.tmpl:1: unclosed action",
+		},
+		"unknown function": {
+			[]string{unknown},
+			`Error! Failed to parse template: template: unknown.tmpl:1: function "secret" not defined`,
+		},
+	}
+
+	for name, tc := range cases {
+		ui := new(cli.MockUi)
+		c := &KVRenderCommand{Ui: ui}
+
+		if code := c.Run(tc.args); code != 1 {
+			t.Errorf("%s: bad: %d", name, code)
+		}
+		if output := ui.ErrorWriter.String(); !strings.Contains(output, tc.output) {
+			t.Errorf("%s: expected %q to contain %q", name, output, tc.output)
+		}
+	}
+}
+
+func TestKVRenderCommand_Run(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	binary := []byte{0, 1, 0xfe, 0xff, '{', '{', '<', '&', '\n'}
+	for _, pair := range []*api.KVPair{
+		{Key: "app/name", Value: []byte("web")},
+		{Key: "app/binary", Value: binary},
+		{Key: "services/"},
+		{Key: "services/api", Value: []byte("8080")},
+		{Key: "services/db", Value: []byte("5432")},
+		{Key: "config/api/timeout", Value: []byte("5s")},
+		{Key: "config/db/pool", Value: []byte("10")},
+		{Key: "config/db/timeout", Value: []byte("1s")},
+	} {
+		if _, err := client.KV().Put(pair, nil); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "kv-render")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	render := func(text string, args ...string) (int, []byte, string) {
+		file := filepath.Join(dir, "test.tmpl")
+		if err := ioutil.WriteFile(file, []byte(text), 0644); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+
+		ui := new(cli.MockUi)
+		var stdout bytes.Buffer
+		c := &KVRenderCommand{Ui: ui, testStdout: &stdout}
+		args = append([]string{"-http-addr=" + srv.httpAddr}, args...)
+		code := c.Run(append(args, file))
+		return code, stdout.Bytes(), ui.ErrorWriter.String()
+	}
+
+	// A range over one tree nested in a range over another, skipping the
+	// folder key.
+	tmpl := `name={{ key "app/name" }}
+{{ range $svc, $port := tree "services" -}}
+[{{ $svc }}:{{ $port }}]
+{{ range $k, $v := tree (printf "config/%s" $svc) -}}
+{{ $k }}={{ $v }}
+{{ end -}}
+{{ end -}}
+region={{ keyOrDefault "app/region" "us-east-1" }}
+`
+	code, output, errors := render(tmpl)
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, errors)
+	}
+	expected := `name=web
+[api:8080]
+timeout=5s
+[db:5432]
+pool=10
+timeout=1s
+region=us-east-1
+`
+	if string(output) != expected {
+		t.Fatalf("bad: %q", output)
+	}
+
+	// Values are written byte for byte, without any escaping.
+	code, output, errors = render(`{{ key "app/binary" }}`)
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, errors)
+	}
+	if !bytes.Equal(output, binary) {
+		t.Fatalf("bad: %q", output)
+	}
+
+	// The result can be written to a file instead.
+	out := filepath.Join(dir, "app.conf")
+	code, output, errors = render(`{{ key "/app/binary" }}`, "-output="+out)
+	if code != 0 || len(output) != 0 {
+		t.Fatalf("bad: %d %q. %#v", code, output, errors)
+	}
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !bytes.Equal(data, binary) {
+		t.Fatalf("bad: %q", data)
+	}
+
+	// A missing key names the key and writes nothing, even to stdout.
+	code, output, errors = render(`before {{ key "app/missing" }} after`, "-output="+out)
+	if code != exitNotFound || len(output) != 0 {
+		t.Fatalf("bad: %d %q", code, output)
+	}
+	if !strings.Contains(errors, "key app/missing does not exist") {
+		t.Fatalf("bad: %#v", errors)
+	}
+	if data, err := ioutil.ReadFile(out); err != nil || !bytes.Equal(data, binary) {
+		t.Fatalf("bad: %q %v", data, err)
+	}
+
+	// A tree with no keys is empty rather than an error.
+	code, output, errors = render(`{{ range $k, $v := tree "nope" }}{{ $k }}{{ end }}done`)
+	if code != 0 || string(output) != "done" {
+		t.Fatalf("bad: %d %q. %#v", code, output, errors)
+	}
+}
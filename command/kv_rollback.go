@@ -0,0 +1,194 @@
+package command
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+)
+
+// KVRollbackCommand is a Command implementation that is used to restore a
+// tree in the key-value store from a backup written by the backup command.
+type KVRollbackCommand struct {
+	Ui cli.Ui
+}
+
+func (c *KVRollbackCommand) Synopsis() string {
+	return "Restores a tree in the KV store from a backup"
+}
+
+func (c *KVRollbackCommand) Help() string {
+	helpText := `
+Usage: consul kv rollback [options] PREFIX
+
+  Restores the keys under PREFIX from a backup tree written by "consul kv
+  backup", given with -from:
+
+      $ consul kv rollback \
+          -from=backups/app/config/2017-03-01T09:30:00Z/ app/config/
+
+  Each key in the backup is written back under PREFIX with its flags, in
+  transactions of up to 64 keys. Only the keys which differ from the backup
+  are written. Keys added under PREFIX since the backup are left alone, unless
+  the -prune option is given to delete them. Each one is deleted with a
+  check-and-set against the index it was listed at, so a key written in the
+  meantime is kept.
+
+  For a full list of options and examples, please see the Consul documentation.
+
+` + apiOptsText + `
+
+KV Rollback Options:
+
+  -dry-run                List the keys which would be restored or deleted,
+                          without changing anything. The default value is
+                          false.
+
+  -from=<prefix>          Prefix of the backup tree to restore from, such as
+                          "backups/app/config/2017-03-01T09:30:00Z/". This is
+                          required.
+
+  -prune                  Delete the keys under PREFIX which aren't in the
+                          backup. The default value is false.
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *KVRollbackCommand) Run(args []string) (code int) {
+	cmdFlags, apiFlags := NewAPIFlagSet("rollback", c.Ui)
+	defer apiFlags.CheckTimeout(c.Ui, &code)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	from := cmdFlags.String("from", "", "")
+	prune := cmdFlags.Bool("prune", false, "")
+	dryRun := cmdFlags.Bool("dry-run", false, "")
+	if err := parseFlags(c.Ui, cmdFlags, args); err != nil {
+		return 1
+	}
+
+	var prefix string
+	args = cmdFlags.Args()
+	switch len(args) {
+	case 0:
+		c.Ui.Error("Error! Missing PREFIX argument")
+		return 1
+	case 1:
+		prefix = normalizeKVPrefix(args[0])
+	default:
+		c.Ui.Error(fmt.Sprintf("Too many arguments (expected 1, got %d)", len(args)))
+		return 1
+	}
+
+	// The backup can't overlap the tree being restored, or the restore would
+	// write into the backup, and -prune could delete it.
+	fromPrefix := normalizeKVPrefix(*from)
+	switch {
+	case fromPrefix == "":
+		c.Ui.Error("Error! Missing -from prefix")
+		return 1
+	case strings.HasPrefix(fromPrefix, prefix) || strings.HasPrefix(prefix, fromPrefix):
+		c.Ui.Error(fmt.Sprintf("Error! The backup %s and the prefix %s overlap", fromPrefix, prefix))
+		return 1
+	}
+
+	// Create and test the HTTP client
+	client, err := apiFlags.Client()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+		return 1
+	}
+
+	qo := apiFlags.QueryOptions()
+	backup, _, err := client.KV().List(fromPrefix, qo)
+	if err != nil {
+		c.Ui.Error(apiFlags.errorMessage("Error querying Consul agent", err,
+			kvDenial("read keys under "+fromPrefix, fromPrefix, "read")))
+		return exitCommError
+	}
+	if len(backup) == 0 {
+		c.Ui.Error(fmt.Sprintf("Error! No backup exists at: %s", fromPrefix))
+		return exitNotFound
+	}
+
+	live, _, err := client.KV().List(prefix, qo)
+	if err != nil {
+		c.Ui.Error(apiFlags.errorMessage("Error querying Consul agent", err,
+			kvDenial("read keys under "+prefix, prefix, "read")))
+		return exitCommError
+	}
+	current := make(map[string]*api.KVPair, len(live))
+	for _, pair := range live {
+		current[pair.Key] = pair
+	}
+
+	// Work out what has changed since the backup, so only those keys are
+	// written.
+	var restore []*api.KVPair
+	wanted := make(map[string]struct{}, len(backup))
+	for _, pair := range backup {
+		key := prefix + strings.TrimPrefix(pair.Key, fromPrefix)
+		wanted[key] = struct{}{}
+		if existing, ok := current[key]; ok && existing.Flags == pair.Flags &&
+			bytes.Equal(existing.Value, pair.Value) {
+			continue
+		}
+		restore = append(restore, &api.KVPair{
+			Key:   key,
+			Flags: pair.Flags,
+			Value: pair.Value,
+		})
+	}
+	var stale api.KVPairs
+	if *prune {
+		for _, pair := range live {
+			if _, ok := wanted[pair.Key]; !ok {
+				stale = append(stale, pair)
+			}
+		}
+	}
+	unchanged := len(backup) - len(restore)
+
+	if *dryRun {
+		for _, pair := range restore {
+			c.Ui.Info(fmt.Sprintf("Restore: %s", pair.Key))
+		}
+		for _, pair := range stale {
+			c.Ui.Info(fmt.Sprintf("Delete: %s", pair.Key))
+		}
+		c.Ui.Warn(fmt.Sprintf("Would restore %d %s and delete %d with prefix %s from %s (%d unchanged)",
+			len(restore), pluralKeys(len(restore)), len(stale), prefix, fromPrefix, unchanged))
+		return 0
+	}
+
+	if err := kvWriteTxn(client, restore, qo); err != nil {
+		key := kvDeniedKey(err, prefix)
+		c.Ui.Error(apiFlags.errorMessage(fmt.Sprintf("Error! Failed restoring prefix %s", prefix),
+			err, kvDenial("write to "+key, key, "write")))
+		return 1
+	}
+	for _, pair := range restore {
+		apiFlags.report(c.Ui, fmt.Sprintf("Restored: %s", pair.Key))
+	}
+
+	if len(stale) > 0 {
+		deleted, err := kvDeleteTreeCAS(client, stale, apiFlags.WriteOptions())
+		if err != nil {
+			k := kvDeniedKey(err, prefix)
+			c.Ui.Error(apiFlags.errorMessage(fmt.Sprintf("Error! Restored prefix %s, but did not prune it", prefix),
+				err, kvDenial("delete "+k, k, "write")))
+			if deleted > 0 {
+				c.Ui.Error(fmt.Sprintf("Deleted %d of %d %s before the failure",
+					deleted, len(stale), pluralKeys(len(stale))))
+			}
+			return 1
+		}
+		for _, pair := range stale {
+			apiFlags.report(c.Ui, fmt.Sprintf("Pruned: %s", pair.Key))
+		}
+	}
+
+	apiFlags.report(c.Ui, fmt.Sprintf("Success! Restored %d %s and deleted %d with prefix %s from %s (%d unchanged)",
+		len(restore), pluralKeys(len(restore)), len(stale), prefix, fromPrefix, unchanged))
+	return 0
+}
@@ -0,0 +1,155 @@
+package command
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+)
+
+func TestKVRollbackCommand_implements(t *testing.T) {
+	var _ cli.Command = &KVRollbackCommand{}
+}
+
+func TestKVRollbackCommand_noTabs(t *testing.T) {
+	assertNoTabs(t, new(KVRollbackCommand))
+}
+
+func TestKVRollbackCommand_Validation(t *testing.T) {
+	cases := map[string]struct {
+		args []string
+		err  string
+	}{
+		"no prefix": {
+			[]string{"-from=backups/app/2017-03-01T00:00:00Z"},
+			"Error! Missing PREFIX argument",
+		},
+		"extra args": {
+			[]string{"-from=backups/app/2017-03-01T00:00:00Z", "foo", "bar"},
+			"Too many arguments (expected 1, got 2)",
+		},
+		"no -from": {
+			[]string{"app"},
+			"Error! Missing -from prefix",
+		},
+		"backup under the prefix": {
+			[]string{"-from=app/backups/2017-03-01T00:00:00Z", "app"},
+			"Error! The backup app/backups/2017-03-01T00:00:00Z/ and the prefix app/ overlap",
+		},
+		"prefix under the backup": {
+			[]string{"-from=backups/", "backups/app"},
+			"Error! The backup backups/ and the prefix backups/app/ overlap",
+		},
+		"whole store": {
+			[]string{"-from=backups/app/2017-03-01T00:00:00Z", "/"},
+			"Error! The backup backups/app/2017-03-01T00:00:00Z/ and the prefix  overlap",
+		},
+	}
+
+	for name, tc := range cases {
+		ui := new(cli.MockUi)
+		c := &KVRollbackCommand{Ui: ui}
+
+		if code := c.Run(tc.args); code != 1 {
+			t.Errorf("%s: bad: %d", name, code)
+		}
+		if output := ui.ErrorWriter.String(); output != tc.err+"\n" {
+			t.Errorf("%s: expected %q, got %q", name, tc.err, output)
+		}
+	}
+}
+
+func TestKVRollbackCommand_Run(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	const from = "backups/app/config/2017-03-01T00:00:00Z/"
+	for _, pair := range []*api.KVPair{
+		{Key: from + "db", Value: []byte("postgres"), Flags: 42},
+		{Key: from + "feature/on", Value: []byte("true")},
+		{Key: from + "replicas", Value: []byte("3")},
+		// Since the backup, db was changed, feature/on deleted, and
+		// cache added.
+		{Key: "app/config/db", Value: []byte("mysql"), Flags: 42},
+		{Key: "app/config/replicas", Value: []byte("3")},
+		{Key: "app/config/cache", Value: []byte("redis")},
+		{Key: "app/configuration", Value: []byte("not under the prefix")},
+	} {
+		if _, err := client.KV().Put(pair, nil); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	run := func(args ...string) (int, string, string) {
+		ui := new(cli.MockUi)
+		c := &KVRollbackCommand{Ui: ui}
+		code := c.Run(append([]string{"-http-addr=" + srv.httpAddr}, args...))
+		var output string
+		if ui.OutputWriter != nil {
+			output = ui.OutputWriter.String()
+		}
+		return code, output, ui.ErrorWriter.String()
+	}
+	keys := func() []string {
+		keys, _, err := client.KV().Keys("app/", "", nil)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		return keys
+	}
+
+	// A dry run lists the changes without making them.
+	code, output, errors := run("-from="+from, "-prune", "-dry-run", "app/config")
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, errors)
+	}
+	if output != "Restore: app/config/db\nRestore: app/config/feature/on\nDelete: app/config/cache\n" {
+		t.Fatalf("bad: %#v", output)
+	}
+	if !strings.Contains(errors, "Would restore 2 keys and delete 1 with prefix app/config/ from "+from+" (1 unchanged)") {
+		t.Fatalf("bad: %#v", errors)
+	}
+	before := []string{"app/config/cache", "app/config/db", "app/config/replicas", "app/configuration"}
+	if after := keys(); !reflect.DeepEqual(after, before) {
+		t.Fatalf("bad: %#v", after)
+	}
+
+	// Without -prune, keys added since the backup are kept.
+	code, output, errors = run("-from="+from, "app/config/")
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, errors)
+	}
+	if !strings.Contains(output, "Restored: app/config/db") ||
+		!strings.Contains(output, "Success! Restored 2 keys and deleted 0 with prefix app/config/ from "+from+" (1 unchanged)") {
+		t.Fatalf("bad: %#v", output)
+	}
+	pair, _, err := client.KV().Get("app/config/db", nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if string(pair.Value) != "postgres" || pair.Flags != 42 {
+		t.Fatalf("bad: %#v", pair)
+	}
+
+	code, output, errors = run("-from="+from, "-prune", "app/config/")
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, errors)
+	}
+	if !strings.Contains(output, "Pruned: app/config/cache") ||
+		!strings.Contains(output, "Success! Restored 0 keys and deleted 1 with prefix app/config/ from "+from+" (3 unchanged)") {
+		t.Fatalf("bad: %#v", output)
+	}
+	expected := []string{"app/config/db", "app/config/feature/on", "app/config/replicas", "app/configuration"}
+	if after := keys(); !reflect.DeepEqual(after, expected) {
+		t.Fatalf("bad: %#v", after)
+	}
+
+	// A missing backup is reported.
+	code, _, errors = run("-from=backups/app/config/2017-01-01T00:00:00Z", "app/config/")
+	if code != exitNotFound || !strings.Contains(errors, "Error! No backup exists at: backups/app/config/2017-01-01T00:00:00Z/") {
+		t.Fatalf("bad: %d %#v", code, errors)
+	}
+}
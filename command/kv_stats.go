@@ -0,0 +1,277 @@
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+)
+
+// KVStatsCommand is a Command implementation that is used to report the
+// number and size of the keys under each prefix in the key-value store.
+type KVStatsCommand struct {
+	Ui cli.Ui
+}
+
+func (c *KVStatsCommand) Synopsis() string {
+	return "Reports the number and size of keys under each prefix"
+}
+
+func (c *KVStatsCommand) Help() string {
+	helpText := `
+Usage: consul kv stats [options] [PREFIX]
+
+  Reports how many keys and bytes live under each prefix below the given one,
+  for capacity planning. With no prefix, the whole key-value store is
+  covered:
+
+      $ consul kv stats
+
+  The keys are grouped by the first -depth segments of their path after the
+  prefix, so this reports on each tree two levels below "app/", such as
+  "app/web/db/":
+
+      $ consul kv stats -depth=2 app/
+
+  A key with fewer segments than that is reported on its own line. For each
+  line, the number of keys, the total size of their values, the size of the
+  largest value, and the key with the most segments are shown, sorted by
+  prefix, followed by the totals.
+
+  Only the key names are listed up front, and the values are fetched in
+  chunks, so large trees don't have to fit in a single response. Values over
+  the -warn-size are listed on stderr, since they're getting close to the
+  limit of 512KB the servers allow.
+
+  For a full list of options and examples, please see the Consul documentation.
+
+` + apiOptsText + `
+
+KV Stats Options:
+
+  -depth=<int>            Number of path segments after the prefix to group
+                          the keys by. The default value is 1.
+
+  -format=<string>        Output format. One of "text" or "json". The default
+                          value is "text".
+
+  -warn-size=<bytes>      Print a warning for each value larger than this many
+                          bytes. Use 0 to turn the warnings off. The default
+                          value is 262144 (256KB).
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *KVStatsCommand) Run(args []string) (code int) {
+	cmdFlags, apiFlags := NewAPIFlagSet("stats", c.Ui)
+	defer apiFlags.CheckTimeout(c.Ui, &code)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	depth := cmdFlags.Int("depth", 1, "")
+	format := cmdFlags.String("format", "text", "")
+	warnSize := cmdFlags.Int("warn-size", 256*1024, "")
+	if err := parseFlags(c.Ui, cmdFlags, args); err != nil {
+		return 1
+	}
+
+	if *depth < 1 {
+		c.Ui.Error("Error! -depth must be at least 1")
+		return 1
+	}
+	if *format != "text" && *format != "json" {
+		c.Ui.Error(fmt.Sprintf("Error! Unsupported -format %q (expected text or json)", *format))
+		return 1
+	}
+	if *warnSize < 0 {
+		c.Ui.Error("Error! -warn-size must not be negative")
+		return 1
+	}
+
+	var prefix string
+	args = cmdFlags.Args()
+	switch len(args) {
+	case 0:
+	case 1:
+		prefix = args[0]
+	default:
+		c.Ui.Error(fmt.Sprintf("Too many arguments (expected 0 or 1, got %d)", len(args)))
+		return 1
+	}
+	prefix = normalizeKVPrefix(prefix)
+
+	// Create and test the HTTP client
+	client, err := apiFlags.Client()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+		return 1
+	}
+
+	q := apiFlags.QueryOptions()
+	keys, _, err := client.KV().Keys(prefix, "", q)
+	if err != nil {
+		c.Ui.Error(apiFlags.errorMessage("Error querying Consul agent", err,
+			kvDenial("read keys under "+prefix, prefix, "read")))
+		return exitCommError
+	}
+	sort.Strings(keys)
+
+	// Only the names and sizes of large values are kept for the warnings.
+	type largeValue struct {
+		key  string
+		size int
+	}
+	var large []largeValue
+
+	stats := newKVStats(prefix, *depth)
+	err = kvFetchParallel(client, keys, q, kvDefaultJobs, func(pairs api.KVPairs) error {
+		for _, pair := range pairs {
+			stats.add(pair)
+			if *warnSize > 0 && len(pair.Value) > *warnSize {
+				large = append(large, largeValue{pair.Key, len(pair.Value)})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return exitCommError
+	}
+
+	if *format == "json" {
+		b, err := json.MarshalIndent(stats.output(), "", "  ")
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Error! Failed to encode stats: %s", err))
+			return 1
+		}
+		c.Ui.Output(string(b))
+	} else {
+		var b bytes.Buffer
+		tw := tabwriter.NewWriter(&b, 0, 2, 6, ' ', 0)
+		fmt.Fprintf(tw, "Prefix\tKeys\tBytes\tLargest\tDeepest\n")
+		for _, g := range stats.sorted() {
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%s\n", g.Prefix, g.Keys, g.Bytes, g.Largest, g.Deepest)
+		}
+		fmt.Fprintf(tw, "Total\t%d\t%d\t%d\t%s\n", stats.total.Keys, stats.total.Bytes,
+			stats.total.Largest, stats.total.Deepest)
+		if err := tw.Flush(); err != nil {
+			c.Ui.Error(fmt.Sprintf("Error! Failed to render stats: %s", err))
+			return 1
+		}
+		c.Ui.Output(strings.TrimSuffix(b.String(), "\n"))
+	}
+
+	for _, v := range large {
+		c.Ui.Warn(fmt.Sprintf("Warning! %s has a %d byte value, which is close to the %d byte limit",
+			v.key, v.size, kvMaxValueSize))
+	}
+	return 0
+}
+
+// kvStatsGroup is the summary of the keys under one prefix.
+type kvStatsGroup struct {
+	Prefix string
+
+	// Keys is the number of keys, and Bytes the total size of their values.
+	Keys  int
+	Bytes int64
+
+	// Largest is the size of the largest value, which belongs to
+	// LargestKey.
+	Largest    int
+	LargestKey string
+
+	// Deepest is the first key, in key order, with the most path segments.
+	Deepest string
+	depth   int
+}
+
+// add counts the pair in the group.
+func (g *kvStatsGroup) add(pair *api.KVPair) {
+	g.Keys++
+	g.Bytes += int64(len(pair.Value))
+	if g.LargestKey == "" || len(pair.Value) > g.Largest {
+		g.Largest, g.LargestKey = len(pair.Value), pair.Key
+	}
+	if depth := strings.Count(strings.TrimSuffix(pair.Key, "/"), "/") + 1; depth > g.depth {
+		g.Deepest, g.depth = pair.Key, depth
+	}
+}
+
+// kvStats groups the keys under a prefix by the first depth segments of their
+// path after it.
+type kvStats struct {
+	prefix string
+	depth  int
+	groups map[string]*kvStatsGroup
+	total  kvStatsGroup
+}
+
+func newKVStats(prefix string, depth int) *kvStats {
+	return &kvStats{
+		prefix: prefix,
+		depth:  depth,
+		groups: make(map[string]*kvStatsGroup),
+		total:  kvStatsGroup{Prefix: prefix},
+	}
+}
+
+// add counts the pair in its group and the totals.
+func (s *kvStats) add(pair *api.KVPair) {
+	name := kvStatsGroupName(s.prefix, pair.Key, s.depth)
+	g, ok := s.groups[name]
+	if !ok {
+		g = &kvStatsGroup{Prefix: name}
+		s.groups[name] = g
+	}
+	g.add(pair)
+	s.total.add(pair)
+}
+
+// sorted returns the groups sorted by prefix.
+func (s *kvStats) sorted() []*kvStatsGroup {
+	names := make([]string, 0, len(s.groups))
+	for name := range s.groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	groups := make([]*kvStatsGroup, 0, len(names))
+	for _, name := range names {
+		groups = append(groups, s.groups[name])
+	}
+	return groups
+}
+
+// kvStatsOutput is the output of the stats command when using the JSON
+// format.
+type kvStatsOutput struct {
+	Prefix   string
+	Depth    int
+	Prefixes []*kvStatsGroup
+	Total    *kvStatsGroup
+}
+
+func (s *kvStats) output() *kvStatsOutput {
+	return &kvStatsOutput{
+		Prefix:   s.prefix,
+		Depth:    s.depth,
+		Prefixes: s.sorted(),
+		Total:    &s.total,
+	}
+}
+
+// kvStatsGroupName returns the prefix a key is grouped under: the prefix
+// followed by the first depth segments of the rest of the key, ending in a
+// "/". A key with no more segments than that is its own group.
+func kvStatsGroupName(prefix, key string, depth int) string {
+	rest := strings.TrimPrefix(key, prefix)
+	parts := strings.SplitN(rest, "/", depth+1)
+	if len(parts) <= depth {
+		return key
+	}
+	return prefix + strings.Join(parts[:depth], "/") + "/"
+}
@@ -0,0 +1,159 @@
+package command
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+)
+
+func TestKVStatsCommand_implements(t *testing.T) {
+	var _ cli.Command = &KVStatsCommand{}
+}
+
+func TestKVStatsCommand_noTabs(t *testing.T) {
+	assertNoTabs(t, new(KVStatsCommand))
+}
+
+func TestKVStatsCommand_Validation(t *testing.T) {
+	cases := map[string]struct {
+		args   []string
+		output string
+	}{
+		"extra args": {
+			[]string{"a", "b"},
+			"Too many arguments (expected 0 or 1, got 2)",
+		},
+		"zero depth": {
+			[]string{"-depth=0"},
+			"-depth must be at least 1",
+		},
+		"bad format": {
+			[]string{"-format=yaml"},
+			`Unsupported -format "yaml" (expected text or json)`,
+		},
+		"negative warn-size": {
+			[]string{"-warn-size=-1"},
+			"-warn-size must not be negative",
+		},
+	}
+
+	for name, tc := range cases {
+		ui := new(cli.MockUi)
+		c := &KVStatsCommand{Ui: ui}
+
+		if code := c.Run(tc.args); code != 1 {
+			t.Errorf("%s: bad: %d", name, code)
+		}
+		if output := ui.ErrorWriter.String(); !strings.Contains(output, tc.output) {
+			t.Errorf("%s: expected %q to contain %q", name, output, tc.output)
+		}
+	}
+}
+
+func TestKVStatsCommand_Run(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	for _, pair := range []*api.KVPair{
+		{Key: "app/db/host", Value: []byte("10.0.0.1")},
+		{Key: "app/db/port", Value: []byte("5432")},
+		{Key: "app/db/replica/host", Value: []byte("10.0.0.2")},
+		{Key: "app/name", Value: []byte("demo")},
+		{Key: "app/web/"},
+		{Key: "app/web/motd", Value: []byte(strings.Repeat("x", 20))},
+		{Key: "other/key", Value: []byte("x")},
+	} {
+		if _, err := client.KV().Put(pair, nil); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	ui := new(cli.MockUi)
+	c := &KVStatsCommand{Ui: ui}
+	code := c.Run([]string{"-http-addr=" + srv.httpAddr, "-warn-size=10", "app"})
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+
+	var lines [][]string
+	for _, line := range strings.Split(ui.OutputWriter.String(), "\n") {
+		if line != "" {
+			lines = append(lines, strings.Fields(line))
+		}
+	}
+	expected := [][]string{
+		{"Prefix", "Keys", "Bytes", "Largest", "Deepest"},
+		{"app/db/", "3", "20", "8", "app/db/replica/host"},
+		{"app/name", "1", "4", "4", "app/name"},
+		{"app/web/", "2", "20", "20", "app/web/motd"},
+		{"Total", "6", "44", "20", "app/db/replica/host"},
+	}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Fatalf("bad: %#v", ui.OutputWriter.String())
+	}
+
+	errs := ui.ErrorWriter.String()
+	if errs != "Warning! app/web/motd has a 20 byte value, which is close to the 524288 byte limit\n" {
+		t.Fatalf("bad: %#v", errs)
+	}
+
+	// Deeper groups, as JSON, with the default -warn-size.
+	ui = new(cli.MockUi)
+	c = &KVStatsCommand{Ui: ui}
+	code = c.Run([]string{"-http-addr=" + srv.httpAddr, "-depth=2", "-format=json", "app/"})
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if ui.ErrorWriter.String() != "" {
+		t.Fatalf("bad: %#v", ui.ErrorWriter.String())
+	}
+
+	var out struct {
+		Prefix   string
+		Depth    int
+		Prefixes []*kvStatsGroup
+		Total    *kvStatsGroup
+	}
+	if err := json.Unmarshal(ui.OutputWriter.Bytes(), &out); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	var prefixes []string
+	for _, g := range out.Prefixes {
+		prefixes = append(prefixes, g.Prefix)
+	}
+	if !reflect.DeepEqual(prefixes, []string{"app/db/host", "app/db/port", "app/db/replica/",
+		"app/name", "app/web/", "app/web/motd"}) {
+		t.Fatalf("bad: %#v", prefixes)
+	}
+	if out.Prefix != "app/" || out.Depth != 2 || out.Total.Keys != 6 || out.Total.LargestKey != "app/web/motd" {
+		t.Fatalf("bad: %#v %#v", out, out.Total)
+	}
+}
+
+func TestKVStatsGroupName(t *testing.T) {
+	cases := []struct {
+		prefix   string
+		key      string
+		depth    int
+		expected string
+	}{
+		{"", "app/db/host", 1, "app/"},
+		{"", "app/db/host", 2, "app/db/"},
+		{"", "app/db/host", 3, "app/db/host"},
+		{"app/", "app/db/host", 1, "app/db/"},
+		{"app/", "app/db/", 1, "app/db/"},
+		{"app/", "app/name", 1, "app/name"},
+		{"app/", "app/", 1, "app/"},
+	}
+	for _, tc := range cases {
+		actual := kvStatsGroupName(tc.prefix, tc.key, tc.depth)
+		if actual != tc.expected {
+			t.Errorf("%q %q %d: expected %q, got %q", tc.prefix, tc.key, tc.depth, tc.expected, actual)
+		}
+	}
+}
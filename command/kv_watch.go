@@ -0,0 +1,360 @@
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/command/agent"
+	"github.com/mitchellh/cli"
+)
+
+const (
+	// kvWatchWait is the wait time used for each blocking query.
+	kvWatchWait = 5 * time.Minute
+
+	// kvWatchRetryWait is the initial wait after an error from the agent,
+	// which doubles for each error in a row up to kvWatchMaxRetryWait.
+	kvWatchRetryWait    = time.Second
+	kvWatchMaxRetryWait = time.Minute
+)
+
+// KVWatchCommand is a Command implementation that is used to watch a key or
+// prefix in the key-value store for changes.
+type KVWatchCommand struct {
+	Ui         cli.Ui
+	ShutdownCh <-chan struct{}
+
+	// testStdout is the output for raw values and handlers, for testing.
+	testStdout io.Writer
+
+	// testRetryWait overrides the initial wait after an error for testing.
+	testRetryWait time.Duration
+}
+
+func (c *KVWatchCommand) Synopsis() string {
+	return "Watches a key or prefix in the KV store for changes"
+}
+
+func (c *KVWatchCommand) Help() string {
+	helpText := `
+Usage: consul kv watch [options] KEY_OR_PREFIX
+
+  Watches the given key for changes using blocking queries, and prints the new
+  value each time it changes:
+
+      $ consul kv watch redis/config/connections
+
+  With the -recurse option, every key under the prefix is watched, and all of
+  them are printed when any of them changes:
+
+      $ consul kv watch -recurse redis/config/
+
+  Rather than printing the changes, the "-exec" option runs a handler for each
+  one. The handler is given the key, or the list of keys when watching a
+  prefix, as JSON on stdin, and the index of the change in the CONSUL_INDEX
+  environment variable:
+
+      $ consul kv watch -exec=/usr/local/bin/reload.sh redis/config/
+
+  The watch runs until it is interrupted, or with "-once", until the first
+  change.
+
+  For a full list of options and examples, please see the Consul documentation.
+
+` + apiOptsText + `
+
+KV Watch Options:
+
+  -detailed               Print additional metadata about each key along with
+                          the value, as with "consul kv get -detailed". The
+                          default value is false.
+
+  -exec=<command>         Command to run for each change, instead of printing
+                          it. The command gets the JSON for the change on
+                          stdin. If it fails, the watch stops with an error.
+
+  -format=<string>        Output format, either "text" or "json". The "json"
+                          format prints the key, or the list of keys with
+                          -recurse, in the same form that is given to -exec.
+                          The default value is "text".
+
+  -once                   Exit after the first change. The default value is
+                          false.
+
+  -raw                    Write the value of the key exactly as it is stored,
+                          without a trailing newline. This can't be combined
+                          with the other output options or -recurse. The
+                          default value is false.
+
+  -recurse                Watch every key which starts with the given prefix.
+                          The default value is false.
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *KVWatchCommand) Run(args []string) (code int) {
+	cmdFlags, apiFlags := NewAPIFlagSet("watch", c.Ui)
+	defer apiFlags.CheckTimeout(c.Ui, &code)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	recurse := cmdFlags.Bool("recurse", false, "")
+	raw := cmdFlags.Bool("raw", false, "")
+	detailed := cmdFlags.Bool("detailed", false, "")
+	format := cmdFlags.String("format", "text", "")
+	script := cmdFlags.String("exec", "", "")
+	once := cmdFlags.Bool("once", false, "")
+	if err := parseFlags(c.Ui, cmdFlags, args); err != nil {
+		return 1
+	}
+
+	switch *format {
+	case "text", "json":
+	default:
+		c.Ui.Error(fmt.Sprintf("Error! Unsupported format %q (expected text or json)", *format))
+		return 1
+	}
+
+	if *raw && (*recurse || *detailed || *format != "text" || *script != "") {
+		c.Ui.Error("Error! Cannot combine -raw with -detailed, -exec, -format, or -recurse")
+		return 1
+	}
+	if *script != "" && (*detailed || *format != "text") {
+		c.Ui.Error("Error! Cannot combine -exec with -detailed or -format")
+		return 1
+	}
+
+	key := ""
+	args = cmdFlags.Args()
+	switch len(args) {
+	case 0:
+	case 1:
+		key = args[0]
+	default:
+		c.Ui.Error(fmt.Sprintf("Too many arguments (expected 1, got %d)", len(args)))
+		return 1
+	}
+
+	// This is just a "nice" thing to do. Since pairs cannot start with a /,
+	// but users will likely put "/" or "/foo", lets go ahead and strip that
+	// for them here.
+	key = strings.TrimPrefix(key, "/")
+	if key == "" && !*recurse {
+		c.Ui.Error("Error! Missing KEY argument")
+		return 1
+	}
+
+	client, err := apiFlags.Client()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+		return 1
+	}
+
+	query := func(q *api.QueryOptions) (api.KVPairs, *api.QueryMeta, error) {
+		if *recurse {
+			return client.KV().List(key, q)
+		}
+		pair, meta, err := client.KV().Get(key, q)
+		if pair == nil {
+			return nil, meta, err
+		}
+		return api.KVPairs{pair}, meta, err
+	}
+
+	handle := func(pairs api.KVPairs, index uint64) error {
+		var data interface{} = pairs
+		if !*recurse {
+			data = (*api.KVPair)(nil)
+			if len(pairs) > 0 {
+				data = pairs[0]
+			}
+		} else if pairs == nil {
+			data = api.KVPairs{}
+		}
+
+		switch {
+		case *script != "":
+			return c.exec(*script, data, index)
+		case *format == "json":
+			b, err := json.MarshalIndent(data, "", "  ")
+			if err != nil {
+				return fmt.Errorf("Error encoding output: %s", err)
+			}
+			c.Ui.Output(string(b))
+			return nil
+		default:
+			return c.print(key, pairs, *recurse, *raw, *detailed)
+		}
+	}
+
+	return c.watch(apiFlags.QueryOptions(), query, handle, *once)
+}
+
+// watch runs the query as a blocking query in a loop, calling handle with
+// the result each time it changes, until it's shut down or handle fails.
+// Errors from the agent are retried with a backoff.
+func (c *KVWatchCommand) watch(q *api.QueryOptions,
+	query func(*api.QueryOptions) (api.KVPairs, *api.QueryMeta, error),
+	handle func(api.KVPairs, uint64) error, once bool) int {
+	type result struct {
+		pairs api.KVPairs
+		meta  *api.QueryMeta
+		err   error
+	}
+
+	retryWait := kvWatchRetryWait
+	if c.testRetryWait != 0 {
+		retryWait = c.testRetryWait
+	}
+	wait := retryWait
+
+	var index uint64
+	var last string
+	first := true
+	for {
+		q.WaitIndex = index
+		q.WaitTime = kvWatchWait
+
+		// Run the query in the background so a shutdown doesn't have to
+		// wait for a blocking query to return.
+		ch := make(chan result, 1)
+		go func(q api.QueryOptions) {
+			pairs, meta, err := query(&q)
+			ch <- result{pairs, meta, err}
+		}(*q)
+
+		var res result
+		select {
+		case res = <-ch:
+		case <-c.ShutdownCh:
+			return 0
+		}
+
+		if res.err != nil {
+			c.Ui.Error(fmt.Sprintf("Error querying Consul agent: %s (retrying in %s)", res.err, wait))
+			select {
+			case <-time.After(wait):
+			case <-c.ShutdownCh:
+				return 0
+			}
+			if wait *= 2; wait > kvWatchMaxRetryWait {
+				wait = kvWatchMaxRetryWait
+			}
+			continue
+		}
+		wait = retryWait
+
+		// An index going backwards means the servers' state was reset, such
+		// as by a restore, so start over with a query which doesn't block.
+		// Otherwise never wait on index 0, which would return right away and
+		// spin.
+		switch {
+		case res.meta.LastIndex < index:
+			index = 0
+		case res.meta.LastIndex == 0:
+			index = 1
+		default:
+			index = res.meta.LastIndex
+		}
+
+		// The index also moves for changes outside the watched keys, so
+		// only handle results that are actually different.
+		current := kvWatchState(res.pairs)
+		if first || current == last {
+			first = false
+			last = current
+			continue
+		}
+		last = current
+
+		if err := handle(res.pairs, res.meta.LastIndex); err != nil {
+			c.Ui.Error(err.Error())
+			return 1
+		}
+		if once {
+			return 0
+		}
+	}
+}
+
+// kvWatchState returns a string which changes whenever any of the pairs
+// are modified, created, or deleted.
+func kvWatchState(pairs api.KVPairs) string {
+	var b bytes.Buffer
+	for _, pair := range pairs {
+		fmt.Fprintf(&b, "%s\x00%d\x00", pair.Key, pair.ModifyIndex)
+	}
+	return b.String()
+}
+
+// print writes the changed pairs in text form.
+func (c *KVWatchCommand) print(key string, pairs api.KVPairs, recurse, raw, detailed bool) error {
+	if len(pairs) == 0 {
+		if recurse {
+			c.Ui.Warn(fmt.Sprintf("No keys exist with prefix: %s", key))
+		} else {
+			c.Ui.Warn(fmt.Sprintf("Key was deleted: %s", key))
+		}
+		return nil
+	}
+
+	for i, pair := range pairs {
+		switch {
+		case raw:
+			if _, err := c.stdout().Write(pair.Value); err != nil {
+				return fmt.Errorf("Error writing value: %s", err)
+			}
+		case detailed:
+			var b bytes.Buffer
+			if err := prettyKVPair(&b, pair, false); err != nil {
+				return fmt.Errorf("Error rendering KV pair: %s", err)
+			}
+			c.Ui.Info(b.String())
+			if i < len(pairs)-1 {
+				c.Ui.Info("")
+			}
+		case recurse:
+			c.Ui.Info(fmt.Sprintf("%s:%s", pair.Key, pair.Value))
+		default:
+			c.Ui.Info(string(pair.Value))
+		}
+	}
+	return nil
+}
+
+// exec runs the handler script with the JSON for the change on its stdin.
+func (c *KVWatchCommand) exec(script string, data interface{}, index uint64) error {
+	cmd, err := agent.ExecScript(script)
+	if err != nil {
+		return fmt.Errorf("Error executing handler: %s", err)
+	}
+	cmd.Env = append(os.Environ(),
+		"CONSUL_INDEX="+strconv.FormatUint(index, 10),
+	)
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(data); err != nil {
+		return fmt.Errorf("Error encoding output: %s", err)
+	}
+	cmd.Stdin = &buf
+	cmd.Stdout = c.stdout()
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("Error executing handler: %s", err)
+	}
+	return nil
+}
+
+// stdout returns the writer for raw output and handlers.
+func (c *KVWatchCommand) stdout() io.Writer {
+	if c.testStdout != nil {
+		return c.testStdout
+	}
+	return os.Stdout
+}
@@ -0,0 +1,333 @@
+package command
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+)
+
+func TestKVWatchCommand_implements(t *testing.T) {
+	var _ cli.Command = &KVWatchCommand{}
+}
+
+func TestKVWatchCommand_noTabs(t *testing.T) {
+	assertNoTabs(t, new(KVWatchCommand))
+}
+
+func TestKVWatchCommand_Validation(t *testing.T) {
+	cases := map[string]struct {
+		args   []string
+		output string
+	}{
+		"no key": {
+			[]string{},
+			"Missing KEY argument",
+		},
+		"extra args": {
+			[]string{"foo", "bar"},
+			"Too many arguments",
+		},
+		"bad format": {
+			[]string{"-format=xml", "foo"},
+			"Unsupported format",
+		},
+		"-raw with -recurse": {
+			[]string{"-raw", "-recurse", "foo"},
+			"Cannot combine -raw",
+		},
+		"-raw with -exec": {
+			[]string{"-raw", "-exec=cat", "foo"},
+			"Cannot combine -raw",
+		},
+		"-exec with -format": {
+			[]string{"-exec=cat", "-format=json", "foo"},
+			"Cannot combine -exec",
+		},
+	}
+
+	for name, tc := range cases {
+		ui := new(cli.MockUi)
+		c := &KVWatchCommand{Ui: ui}
+
+		if code := c.Run(tc.args); code != 1 {
+			t.Fatalf("%s: bad: %d", name, code)
+		}
+		if output := ui.ErrorWriter.String(); !strings.Contains(output, tc.output) {
+			t.Fatalf("%s: expected %q to contain %q", name, output, tc.output)
+		}
+	}
+}
+
+func TestKVWatchCommand_Run(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	put := func(key, value string) {
+		pair := &api.KVPair{Key: key, Value: []byte(value)}
+		if _, err := client.KV().Put(pair, nil); err != nil {
+			t.Errorf("err: %v", err)
+		}
+	}
+	put("foo/a", "a")
+
+	cases := map[string]struct {
+		args   []string
+		change func()
+		output string
+		stderr string
+	}{
+		"key": {
+			[]string{"foo/a"},
+			func() {
+				// Changes to other keys are ignored.
+				put("foo/other", "x")
+				put("foo/a", "b")
+			},
+			"b\n",
+			"",
+		},
+		"created": {
+			[]string{"-detailed", "foo/new"},
+			func() { put("foo/new", "new") },
+			"Value            new\n",
+			"",
+		},
+		"deleted": {
+			[]string{"foo/new"},
+			func() {
+				if _, err := client.KV().Delete("foo/new", nil); err != nil {
+					t.Errorf("err: %v", err)
+				}
+			},
+			"",
+			"Key was deleted: foo/new\n",
+		},
+		"recurse": {
+			[]string{"-recurse", "foo/"},
+			func() { put("foo/b", "b") },
+			"foo/a:b\nfoo/b:b\nfoo/other:x\n",
+			"",
+		},
+	}
+
+	for _, name := range []string{"key", "created", "deleted", "recurse"} {
+		tc := cases[name]
+		ui := new(cli.MockUi)
+		c := &KVWatchCommand{Ui: ui}
+
+		go func() {
+			time.Sleep(200 * time.Millisecond)
+			tc.change()
+		}()
+
+		args := append([]string{"-http-addr=" + srv.httpAddr, "-once"}, tc.args...)
+		code := c.Run(args)
+		if code != 0 {
+			t.Fatalf("%s: bad: %d. %#v", name, code, ui.ErrorWriter.String())
+		}
+		if output := ui.OutputWriter.String(); !strings.HasSuffix(output, tc.output) {
+			t.Fatalf("%s: bad: %q", name, output)
+		}
+		if stderr := ui.ErrorWriter.String(); stderr != tc.stderr {
+			t.Fatalf("%s: bad: %q", name, stderr)
+		}
+	}
+
+	// The JSON output is the same as what's given to a handler.
+	ui := new(cli.MockUi)
+	c := &KVWatchCommand{Ui: ui}
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		put("foo/a", "json")
+	}()
+	code := c.Run([]string{"-http-addr=" + srv.httpAddr, "-once", "-format=json", "foo/a"})
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	var pair api.KVPair
+	if err := json.Unmarshal(ui.OutputWriter.Bytes(), &pair); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if pair.Key != "foo/a" || string(pair.Value) != "json" {
+		t.Fatalf("bad: %#v", pair)
+	}
+}
+
+func TestKVWatchCommand_Exec(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	dir, err := ioutil.TempDir("", "consul")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "out")
+
+	ui := new(cli.MockUi)
+	c := &KVWatchCommand{Ui: ui}
+
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		pair := &api.KVPair{Key: "foo/a", Value: []byte("foo/a")}
+		if _, err := client.KV().Put(pair, nil); err != nil {
+			t.Errorf("err: %v", err)
+		}
+	}()
+
+	args := []string{
+		"-http-addr=" + srv.httpAddr,
+		"-once",
+		"-recurse",
+		"-exec=cat > " + out + " && echo $CONSUL_INDEX >> " + out,
+		"foo/",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("bad: %q", data)
+	}
+	var pairs api.KVPairs
+	if err := json.Unmarshal([]byte(lines[0]), &pairs); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(pairs) != 1 || pairs[0].Key != "foo/a" || string(pairs[0].Value) != "foo/a" {
+		t.Fatalf("bad: %#v", pairs)
+	}
+	if lines[1] != strconv.FormatUint(pairs[0].ModifyIndex, 10) {
+		t.Fatalf("bad: %q", lines[1])
+	}
+
+	// A failing handler stops the watch.
+	ui = new(cli.MockUi)
+	c = &KVWatchCommand{Ui: ui}
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		if _, err := client.KV().Put(&api.KVPair{Key: "foo/b", Value: []byte("b")}, nil); err != nil {
+			t.Errorf("err: %v", err)
+		}
+	}()
+	args = []string{"-http-addr=" + srv.httpAddr, "-recurse", "-exec=exit 1", "foo/"}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("bad: %d", code)
+	}
+	if !strings.Contains(ui.ErrorWriter.String(), "Error executing handler") {
+		t.Fatalf("bad: %#v", ui.ErrorWriter.String())
+	}
+}
+
+func TestKVWatchCommand_Shutdown(t *testing.T) {
+	stop := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Hold blocking queries open.
+		if r.URL.Query().Get("index") != "" {
+			<-stop
+			return
+		}
+		w.Header().Set("X-Consul-Index", "1")
+		w.Write([]byte(`[{"Key":"foo","Value":"YmFy","ModifyIndex":1}]`))
+	}))
+	defer srv.Close()
+	defer close(stop)
+
+	shutdownCh := make(chan struct{})
+	ui := new(cli.MockUi)
+	c := &KVWatchCommand{Ui: ui, ShutdownCh: shutdownCh}
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		close(shutdownCh)
+	}()
+
+	code := c.Run([]string{"-http-addr=" + strings.TrimPrefix(srv.URL, "http://"), "foo"})
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if ui.OutputWriter != nil && ui.OutputWriter.Len() != 0 {
+		t.Fatalf("bad: %#v", ui.OutputWriter.String())
+	}
+}
+
+func TestKVWatchCommand_Retry(t *testing.T) {
+	// Fail twice, then reset the index as a restored server would, and
+	// finally make a change.
+	var lock sync.Mutex
+	var indexes []string
+	responses := []struct {
+		code   int
+		index  string
+		value  string
+		modify string
+	}{
+		{200, "10", "a", "1"},
+		{500, "", "", ""},
+		{500, "", "", ""},
+		{200, "5", "a", "1"},
+		{200, "5", "a", "1"},
+		{200, "6", "b", "6"},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		if len(indexes) == len(responses) {
+			t.Errorf("too many requests")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		resp := responses[len(indexes)]
+		indexes = append(indexes, r.URL.Query().Get("index"))
+
+		if resp.code != 200 {
+			w.WriteHeader(resp.code)
+			return
+		}
+		w.Header().Set("X-Consul-Index", resp.index)
+		w.Write([]byte(`[{"Key":"foo","Value":"` +
+			base64.StdEncoding.EncodeToString([]byte(resp.value)) +
+			`","ModifyIndex":` + resp.modify + `}]`))
+	}))
+	defer srv.Close()
+
+	ui := new(cli.MockUi)
+	c := &KVWatchCommand{Ui: ui, testRetryWait: 10 * time.Millisecond}
+
+	code := c.Run([]string{"-http-addr=" + strings.TrimPrefix(srv.URL, "http://"), "-once", "foo"})
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+
+	// After the reset, a query which doesn't block picks up the new index.
+	expected := []string{"", "10", "10", "10", "", "5"}
+	if !reflect.DeepEqual(indexes, expected) {
+		t.Fatalf("bad: %#v", indexes)
+	}
+	if output := ui.OutputWriter.String(); output != "b\n" {
+		t.Fatalf("bad: %q", output)
+	}
+	errors := ui.ErrorWriter.String()
+	if !strings.Contains(errors, "retrying in 10ms") || !strings.Contains(errors, "retrying in 20ms") {
+		t.Fatalf("bad: %#v", errors)
+	}
+}
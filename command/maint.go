@@ -1,8 +1,8 @@
 package command
 
 import (
-	"flag"
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/hashicorp/consul/api"
@@ -38,60 +38,74 @@ Usage: consul maint [options]
   only a specific service.
 
   If no arguments are given, the agent's maintenance status will be shown.
-  This will return blank if nothing is currently under maintenance.
+  This will return blank if nothing is currently under maintenance, or with
+  -format=json, an object with the "Node" under maintenance, if any, and the
+  "Services":
 
-Options:
+      $ consul maint -format=json
 
-  -enable                    Enable maintenance mode.
-  -disable                   Disable maintenance mode.
-  -reason=<string>           Text string describing the maintenance reason
-  -service=<serviceID>       Control maintenance mode for a specific service ID
-  -token=""                  ACL token to use. Defaults to that of agent.
-  -http-addr=127.0.0.1:8500  HTTP address of the Consul agent.
+  For a full list of options and examples, please see the Consul documentation.
+
+` + apiOptsText + `
+
+Maint Options:
+
+  -disable                Disable maintenance mode.
+
+  -enable                 Enable maintenance mode. If it's already enabled,
+                          nothing is changed, including the reason.
+
+  -format=<string>        Output format of the maintenance status, either
+                          "text" or "json". The default value is "text".
+
+  -reason=<string>        Text string describing the maintenance reason.
+
+  -service=<serviceID>    Control maintenance mode for a specific service ID.
 `
 	return strings.TrimSpace(helpText)
 }
 
-func (c *MaintCommand) Run(args []string) int {
-	var enable bool
-	var disable bool
-	var reason string
-	var serviceID string
-	var token string
-
-	cmdFlags := flag.NewFlagSet("maint", flag.ContinueOnError)
+func (c *MaintCommand) Run(args []string) (code int) {
+	cmdFlags, apiFlags := NewAPIFlagSet("maint", c.Ui)
+	defer apiFlags.CheckTimeout(c.Ui, &code)
 	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
-
-	cmdFlags.BoolVar(&enable, "enable", false, "enable maintenance mode")
-	cmdFlags.BoolVar(&disable, "disable", false, "disable maintenance mode")
-	cmdFlags.StringVar(&reason, "reason", "", "maintenance reason")
-	cmdFlags.StringVar(&serviceID, "service", "", "service maintenance")
-	cmdFlags.StringVar(&token, "token", "", "")
-	httpAddr := HTTPAddrFlag(cmdFlags)
-
-	if err := cmdFlags.Parse(args); err != nil {
+	enable := cmdFlags.Bool("enable", false, "")
+	disable := cmdFlags.Bool("disable", false, "")
+	format := cmdFlags.String("format", "text", "")
+	reason := cmdFlags.String("reason", "", "")
+	serviceID := cmdFlags.String("service", "", "")
+	if err := parseFlags(c.Ui, cmdFlags, args); err != nil {
 		return 1
 	}
 
 	// Ensure we don't have conflicting args
-	if enable && disable {
+	if *enable && *disable {
 		c.Ui.Error("Only one of -enable or -disable may be provided")
 		return 1
 	}
-	if !enable && reason != "" {
+	if !*enable && *reason != "" {
 		c.Ui.Error("Reason may only be provided with -enable")
 		return 1
 	}
-	if !enable && !disable && serviceID != "" {
+	if !*enable && !*disable && *serviceID != "" {
 		c.Ui.Error("Service requires either -enable or -disable")
 		return 1
 	}
+	if *format != "text" && *format != "json" {
+		c.Ui.Error(fmt.Sprintf("Error! Unsupported format %q (expected text or json)", *format))
+		return 1
+	}
+	if *format != "text" && (*enable || *disable) {
+		c.Ui.Error("Error! Can only specify -format when listing the maintenance status")
+		return 1
+	}
+	if args = cmdFlags.Args(); len(args) > 0 {
+		c.Ui.Error(fmt.Sprintf("Too many arguments (expected 0, got %d)", len(args)))
+		return 1
+	}
 
 	// Create and test the HTTP client
-	conf := api.DefaultConfig()
-	conf.Address = *httpAddr
-	conf.Token = token
-	client, err := api.NewClient(conf)
+	client, err := apiFlags.Client()
 	if err != nil {
 		c.Ui.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
 		return 1
@@ -103,35 +117,58 @@ func (c *MaintCommand) Run(args []string) int {
 		return 1
 	}
 
-	if !enable && !disable {
-		// List mode - list nodes/services in maintenance mode
+	// The status is needed to list it, and to tell whether enabling
+	// maintenance would change anything.
+	var status *maintStatus
+	if !*disable {
 		checks, err := a.Checks()
 		if err != nil {
 			c.Ui.Error(fmt.Sprintf("Error getting checks: %s", err))
 			return 1
 		}
+		status = newMaintStatus(nodeName, checks)
+	}
 
-		for _, check := range checks {
-			if check.CheckID == "_node_maintenance" {
-				c.Ui.Output("Node:")
-				c.Ui.Output("  Name:   " + nodeName)
-				c.Ui.Output("  Reason: " + check.Notes)
-				c.Ui.Output("")
-			} else if strings.HasPrefix(string(check.CheckID), "_service_maintenance:") {
-				c.Ui.Output("Service:")
-				c.Ui.Output("  ID:     " + check.ServiceID)
-				c.Ui.Output("  Reason: " + check.Notes)
-				c.Ui.Output("")
-			}
+	if !*enable && !*disable {
+		// List mode - list nodes/services in maintenance mode
+		if *format == "json" {
+			return printJSON(c.Ui, status)
+		}
+
+		if status.Node != nil {
+			c.Ui.Output("Node:")
+			c.Ui.Output("  Name:   " + status.Node.Name)
+			c.Ui.Output("  Reason: " + status.Node.Reason)
+			c.Ui.Output("")
+		}
+		for _, service := range status.Services {
+			c.Ui.Output("Service:")
+			c.Ui.Output("  ID:     " + service.ID)
+			c.Ui.Output("  Reason: " + service.Reason)
+			c.Ui.Output("")
 		}
 
 		return 0
 	}
 
-	if enable {
+	if *enable {
+		// Enabling maintenance again would keep the original reason, so
+		// it's reported rather than looking like it was updated.
+		if current := status.find(*serviceID); current != nil {
+			what := "Node maintenance"
+			if *serviceID != "" {
+				what = fmt.Sprintf("Service maintenance for %q", *serviceID)
+			}
+			c.Ui.Output(fmt.Sprintf("%s is already enabled, with reason: %s", what, current.Reason))
+			if *reason != "" && *reason != current.Reason {
+				c.Ui.Warn("Warning! The reason was not changed. Disable maintenance first to give a new reason.")
+			}
+			return 0
+		}
+
 		// Enable node maintenance
-		if serviceID == "" {
-			if err := a.EnableNodeMaintenance(reason); err != nil {
+		if *serviceID == "" {
+			if err := a.EnableNodeMaintenance(*reason); err != nil {
 				c.Ui.Error(fmt.Sprintf("Error enabling node maintenance: %s", err))
 				return 1
 			}
@@ -140,37 +177,88 @@ func (c *MaintCommand) Run(args []string) int {
 		}
 
 		// Enable service maintenance
-		if err := a.EnableServiceMaintenance(serviceID, reason); err != nil {
+		if err := a.EnableServiceMaintenance(*serviceID, *reason); err != nil {
 			c.Ui.Error(fmt.Sprintf("Error enabling service maintenance: %s", err))
 			return 1
 		}
-		c.Ui.Output(fmt.Sprintf("Service maintenance is now enabled for %q", serviceID))
+		c.Ui.Output(fmt.Sprintf("Service maintenance is now enabled for %q", *serviceID))
 		return 0
 	}
 
-	if disable {
-		// Disable node maintenance
-		if serviceID == "" {
-			if err := a.DisableNodeMaintenance(); err != nil {
-				c.Ui.Error(fmt.Sprintf("Error disabling node maintenance: %s", err))
-				return 1
-			}
-			c.Ui.Output("Node maintenance is now disabled")
-			return 0
-		}
-
-		// Disable service maintenance
-		if err := a.DisableServiceMaintenance(serviceID); err != nil {
-			c.Ui.Error(fmt.Sprintf("Error disabling service maintenance: %s", err))
+	// Disable node maintenance
+	if *serviceID == "" {
+		if err := a.DisableNodeMaintenance(); err != nil {
+			c.Ui.Error(fmt.Sprintf("Error disabling node maintenance: %s", err))
 			return 1
 		}
-		c.Ui.Output(fmt.Sprintf("Service maintenance is now disabled for %q", serviceID))
+		c.Ui.Output("Node maintenance is now disabled")
 		return 0
 	}
 
+	// Disable service maintenance
+	if err := a.DisableServiceMaintenance(*serviceID); err != nil {
+		c.Ui.Error(fmt.Sprintf("Error disabling service maintenance: %s", err))
+		return 1
+	}
+	c.Ui.Output(fmt.Sprintf("Service maintenance is now disabled for %q", *serviceID))
 	return 0
 }
 
+// maintStatus is what's under maintenance on the agent, which is also the
+// output of the command with -format=json.
+type maintStatus struct {
+	// Node is the node's maintenance, or nil if it's not under
+	// maintenance.
+	Node *maintEntry
+
+	// Services are the services under maintenance, sorted by ID.
+	Services []*maintEntry
+}
+
+// maintEntry is the maintenance of the node or of one service. Only one of
+// Name and ID is set, for the node and the service respectively.
+type maintEntry struct {
+	Name   string `json:",omitempty"`
+	ID     string `json:",omitempty"`
+	Reason string
+}
+
+// newMaintStatus finds the maintenance of the node and its services from the
+// critical checks which the agent registers for it.
+func newMaintStatus(nodeName string, checks map[string]*api.AgentCheck) *maintStatus {
+	status := &maintStatus{Services: []*maintEntry{}}
+	for _, check := range checks {
+		if check.CheckID == "_node_maintenance" {
+			status.Node = &maintEntry{Name: nodeName, Reason: check.Notes}
+		} else if strings.HasPrefix(string(check.CheckID), "_service_maintenance:") {
+			status.Services = append(status.Services, &maintEntry{ID: check.ServiceID, Reason: check.Notes})
+		}
+	}
+	sort.Sort(maintEntriesByID(status.Services))
+	return status
+}
+
+// find returns the maintenance of the given service, or of the node if the
+// service ID is empty, or nil if it's not under maintenance.
+func (s *maintStatus) find(serviceID string) *maintEntry {
+	if serviceID == "" {
+		return s.Node
+	}
+	for _, service := range s.Services {
+		if service.ID == serviceID {
+			return service
+		}
+	}
+	return nil
+}
+
+// maintEntriesByID sorts maintenance entries by ID.
+type maintEntriesByID []*maintEntry
+
+func (m maintEntriesByID) Len() int           { return len(m) }
+func (m maintEntriesByID) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
+func (m maintEntriesByID) Less(i, j int) bool { return m[i].ID < m[j].ID }
+
 func (c *MaintCommand) Synopsis() string {
 	return "Controls node or service maintenance mode"
 }
@@ -1,6 +1,8 @@
 package command
 
 import (
+	"encoding/json"
+	"reflect"
 	"strings"
 	"testing"
 
@@ -31,6 +33,14 @@ func TestMaintCommandRun_ConflictingArgs(t *testing.T) {
 	if code := c.Run([]string{"-service=redis"}); code != 1 {
 		t.Fatalf("expected return code 1, got %d", code)
 	}
+
+	if code := c.Run([]string{"-enable", "-format=json"}); code != 1 {
+		t.Fatalf("expected return code 1, got %d", code)
+	}
+
+	if code := c.Run([]string{"-format=yaml"}); code != 1 {
+		t.Fatalf("expected return code 1, got %d", code)
+	}
 }
 
 func TestMaintCommandRun_NoArgs(t *testing.T) {
@@ -208,3 +218,120 @@ func TestMaintCommandRun_ServiceMaintenance_NoService(t *testing.T) {
 		t.Fatalf("bad: %#v", ui.ErrorWriter.String())
 	}
 }
+
+func TestMaintCommandRun_json(t *testing.T) {
+	a1 := testAgent(t)
+	defer a1.Shutdown()
+
+	for _, id := range []string{"web", "db"} {
+		service := &structs.NodeService{
+			ID:      id,
+			Service: id,
+		}
+		if err := a1.agent.AddService(service, nil, false, ""); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	status := func() *maintStatus {
+		ui := new(cli.MockUi)
+		c := &MaintCommand{Ui: ui}
+		args := []string{"-http-addr=" + a1.httpAddr, "-format=json"}
+		if code := c.Run(args); code != 0 {
+			t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+		}
+
+		var out maintStatus
+		if err := json.Unmarshal(ui.OutputWriter.Bytes(), &out); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		return &out
+	}
+
+	// Nothing is under maintenance yet.
+	if out := status(); !reflect.DeepEqual(out, &maintStatus{Services: []*maintEntry{}}) {
+		t.Fatalf("bad: %#v", out)
+	}
+
+	if err := a1.agent.EnableServiceMaintenance("web", "broken 1", ""); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := a1.agent.EnableServiceMaintenance("db", "broken 2", ""); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	a1.agent.EnableNodeMaintenance("broken 3", "")
+
+	expected := &maintStatus{
+		Node: &maintEntry{Name: a1.config.NodeName, Reason: "broken 3"},
+		Services: []*maintEntry{
+			{ID: "db", Reason: "broken 2"},
+			{ID: "web", Reason: "broken 1"},
+		},
+	}
+	if out := status(); !reflect.DeepEqual(out, expected) {
+		t.Fatalf("bad: %#v", out)
+	}
+}
+
+func TestMaintCommandRun_alreadyEnabled(t *testing.T) {
+	a1, client := testAgentWithAPIClient(t)
+	defer a1.Shutdown()
+
+	service := &structs.NodeService{
+		ID:      "test",
+		Service: "test",
+	}
+	if err := a1.agent.AddService(service, nil, false, ""); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := a1.agent.EnableServiceMaintenance("test", "broken 1", ""); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	a1.agent.EnableNodeMaintenance("broken 2", "")
+
+	cases := map[string]struct {
+		args   []string
+		output string
+		warn   bool
+	}{
+		"node": {
+			[]string{"-enable"},
+			"Node maintenance is already enabled, with reason: broken 2",
+			false,
+		},
+		"node new reason": {
+			[]string{"-enable", "-reason=patching"},
+			"Node maintenance is already enabled, with reason: broken 2",
+			true,
+		},
+		"service": {
+			[]string{"-enable", "-service=test", "-reason=broken 1"},
+			"Service maintenance for \"test\" is already enabled, with reason: broken 1",
+			false,
+		},
+	}
+
+	for name, tc := range cases {
+		ui := new(cli.MockUi)
+		c := &MaintCommand{Ui: ui}
+		args := append([]string{"-http-addr=" + a1.httpAddr}, tc.args...)
+		if code := c.Run(args); code != 0 {
+			t.Fatalf("%s: bad: %d. %#v", name, code, ui.ErrorWriter.String())
+		}
+		if output := ui.OutputWriter.String(); !strings.Contains(output, tc.output) {
+			t.Fatalf("%s: bad: %#v", name, output)
+		}
+		if warned := strings.Contains(ui.ErrorWriter.String(), "The reason was not changed"); warned != tc.warn {
+			t.Fatalf("%s: bad: %#v", name, ui.ErrorWriter.String())
+		}
+	}
+
+	// The original reason is kept.
+	checks, err := client.Agent().Checks()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if check := checks["_node_maintenance"]; check == nil || check.Notes != "broken 2" {
+		t.Fatalf("bad: %#v", check)
+	}
+}
@@ -1,15 +1,18 @@
 package command
 
 import (
-	"flag"
 	"fmt"
-	"github.com/hashicorp/consul/command/agent"
-	"github.com/mitchellh/cli"
-	"github.com/ryanuber/columnize"
 	"net"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/command/agent"
+	"github.com/hashicorp/serf/serf"
+	"github.com/mitchellh/cli"
+	"github.com/ryanuber/columnize"
 )
 
 // MembersCommand is a Command implementation that queries a running
@@ -22,48 +25,133 @@ func (c *MembersCommand) Help() string {
 	helpText := `
 Usage: consul members [options]
 
-  Outputs the members of a running Consul agent.
+  Outputs the members of the gossip pool the agent is in, sorted by name:
 
-Options:
+      $ consul members
 
-  -detailed                 Provides detailed information about nodes
+  Members which have failed are still listed, since the agent keeps trying
+  to reach them for a while in case of a network partition. If no members
+  match the -status filter, the command exits with status 2.
 
-  -rpc-addr=127.0.0.1:8400  RPC address of the Consul agent.
+  For a full list of options and examples, please see the Consul documentation.
 
-  -status=<regexp>          If provided, output is filtered to only nodes matching
-                            the regular expression for status
+` + apiOptsText + `
 
-  -wan                      If the agent is in server mode, this can be used to return
-                            the other peers in the WAN pool
+Members Options:
+
+  -detailed               Show the protocol versions and all the tags of each
+                          member. The default value is false.
+
+  -format=<string>        Output format, either "text" or "json". With "json",
+                          the members are printed as an array of objects,
+                          with all their details. The default value is
+                          "text".
+
+  -rpc-addr=<address>     Deprecated. Use the agent's RPC address, such as
+                          127.0.0.1:8400, instead of its HTTP API, as before.
+                          This is also used if only CONSUL_RPC_ADDR is set.
+                          The other HTTP API options don't apply with it.
+
+  -status=<regexp>        Only list the members whose status, which is one of
+                          "alive", "leaving", "left", or "failed", matches
+                          the regular expression, such as "failed|left".
+
+  -wan                    List the members of the WAN pool instead, which
+                          holds the servers of every datacenter. This can
+                          only be used with a server agent. The default value
+                          is false.
 `
 	return strings.TrimSpace(helpText)
 }
 
-func (c *MembersCommand) Run(args []string) int {
-	var detailed bool
-	var wan bool
-	var statusFilter string
-	cmdFlags := flag.NewFlagSet("members", flag.ContinueOnError)
+func (c *MembersCommand) Run(args []string) (code int) {
+	cmdFlags, apiFlags := NewAPIFlagSet("members", c.Ui)
+	defer apiFlags.CheckTimeout(c.Ui, &code)
 	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
-	cmdFlags.BoolVar(&detailed, "detailed", false, "detailed output")
-	cmdFlags.BoolVar(&wan, "wan", false, "wan members")
-	cmdFlags.StringVar(&statusFilter, "status", ".*", "status filter")
-	rpcAddr := RPCAddrFlag(cmdFlags)
-	if err := cmdFlags.Parse(args); err != nil {
+	detailed := cmdFlags.Bool("detailed", false, "")
+	format := cmdFlags.String("format", "text", "")
+	statusFilter := cmdFlags.String("status", ".*", "")
+	wan := cmdFlags.Bool("wan", false, "")
+	rpcAddr := cmdFlags.String("rpc-addr", "", "")
+	if err := parseFlags(c.Ui, cmdFlags, args); err != nil {
 		return 1
 	}
 
-	// Compile the regexp
-	statusRe, err := regexp.Compile(statusFilter)
+	rpcAddress, ok := rpcAddrDeprecated(c.Ui, cmdFlags, *rpcAddr)
+	if !ok {
+		return 1
+	}
+	if args = cmdFlags.Args(); len(args) > 0 {
+		c.Ui.Error(fmt.Sprintf("Too many arguments (expected 0, got %d)", len(args)))
+		return 1
+	}
+	if *format != "text" && *format != "json" {
+		c.Ui.Error(fmt.Sprintf("Error! Unsupported format %q (expected text or json)", *format))
+		return 1
+	}
+	statusRe, err := regexp.Compile(*statusFilter)
 	if err != nil {
 		c.Ui.Error(fmt.Sprintf("Failed to compile status regexp: %v", err))
 		return 1
 	}
 
-	client, err := RPCClient(*rpcAddr)
+	var members []*api.AgentMember
+	if rpcAddress != "" {
+		if members, ok = c.rpcMembers(rpcAddress, *wan); !ok {
+			return 1
+		}
+	} else {
+		// Create and test the HTTP client
+		client, err := apiFlags.Client()
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+			return 1
+		}
+
+		members, err = client.Agent().Members(*wan)
+		if err != nil {
+			c.Ui.Error(apiFlags.errorMessage("Error retrieving members", err,
+				catalogDenial("list the members", "node")))
+			return exitCommError
+		}
+	}
+
+	var matched []*api.AgentMember
+	for _, member := range members {
+		if statusRe.MatchString(memberStatus(member)) {
+			matched = append(matched, member)
+		}
+	}
+	if len(matched) == 0 {
+		return exitNotFound
+	}
+	sort.Sort(ByMemberName(matched))
+
+	if *format == "json" {
+		out := make([]*memberJSON, 0, len(matched))
+		for _, member := range matched {
+			out = append(out, &memberJSON{member, memberStatus(member)})
+		}
+		return printJSON(c.Ui, out)
+	}
+
+	var result []string
+	if *detailed {
+		result = c.detailedOutput(matched)
+	} else {
+		result = c.standardOutput(matched)
+	}
+	c.Ui.Output(columnize.SimpleFormat(result))
+	return 0
+}
+
+// rpcMembers lists the members through the agent's deprecated RPC address,
+// in the same form as the HTTP API gives them, reporting any error.
+func (c *MembersCommand) rpcMembers(addr string, wan bool) ([]*api.AgentMember, bool) {
+	client, err := RPCClient(addr)
 	if err != nil {
 		c.Ui.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
-		return 1
+		return nil, false
 	}
 	defer client.Close()
 
@@ -75,46 +163,64 @@ func (c *MembersCommand) Run(args []string) int {
 	}
 	if err != nil {
 		c.Ui.Error(fmt.Sprintf("Error retrieving members: %s", err))
-		return 1
-	}
-
-	// Filter the results
-	n := len(members)
-	for i := 0; i < n; i++ {
-		member := members[i]
-		if !statusRe.MatchString(member.Status) {
-			members[i], members[n-1] = members[n-1], members[i]
-			i--
-			n--
-			continue
-		}
+		return nil, false
 	}
-	members = members[:n]
 
-	// No matching members
-	if len(members) == 0 {
-		return 2
+	out := make([]*api.AgentMember, 0, len(members))
+	for _, m := range members {
+		out = append(out, &api.AgentMember{
+			Name:        m.Name,
+			Addr:        m.Addr.String(),
+			Port:        m.Port,
+			Tags:        m.Tags,
+			Status:      memberStatusCode(m.Status),
+			ProtocolMin: m.ProtocolMin,
+			ProtocolMax: m.ProtocolMax,
+			ProtocolCur: m.ProtocolCur,
+			DelegateMin: m.DelegateMin,
+			DelegateMax: m.DelegateMax,
+			DelegateCur: m.DelegateCur,
+		})
 	}
+	return out, true
+}
 
-	sort.Sort(ByMemberName(members))
+// memberJSON is a member as printed with -format=json, with its status
+// spelled out rather than as serf's number for it.
+type memberJSON struct {
+	*api.AgentMember
+	Status string
+}
 
-	// Generate the output
-	var result []string
-	if detailed {
-		result = c.detailedOutput(members)
-	} else {
-		result = c.standardOutput(members)
+// memberStatus returns the name of the member's status, such as "alive".
+func memberStatus(member *api.AgentMember) string {
+	switch s := serf.MemberStatus(member.Status); s {
+	case serf.StatusNone, serf.StatusAlive, serf.StatusLeaving, serf.StatusLeft, serf.StatusFailed:
+		return s.String()
+	default:
+		return "unknown"
 	}
+}
 
-	// Generate the columnized version
-	output := columnize.SimpleFormat(result)
-	c.Ui.Output(output)
+// memberStatusCode returns serf's number for the named status, the reverse
+// of memberStatus, or -1 if it isn't known.
+func memberStatusCode(name string) int {
+	for _, s := range []serf.MemberStatus{serf.StatusNone, serf.StatusAlive, serf.StatusLeaving,
+		serf.StatusLeft, serf.StatusFailed} {
+		if s.String() == name {
+			return int(s)
+		}
+	}
+	return -1
+}
 
-	return 0
+// memberAddr returns the gossip address of the member as "IP:port".
+func memberAddr(member *api.AgentMember) string {
+	return net.JoinHostPort(member.Addr, strconv.Itoa(int(member.Port)))
 }
 
 // so we can sort members by name
-type ByMemberName []agent.Member
+type ByMemberName []*api.AgentMember
 
 func (m ByMemberName) Len() int           { return len(m) }
 func (m ByMemberName) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
@@ -122,12 +228,13 @@ func (m ByMemberName) Less(i, j int) bool { return m[i].Name < m[j].Name }
 
 // standardOutput is used to dump the most useful information about nodes
 // in a more human-friendly format
-func (c *MembersCommand) standardOutput(members []agent.Member) []string {
+func (c *MembersCommand) standardOutput(members []*api.AgentMember) []string {
 	result := make([]string, 0, len(members))
 	header := "Node|Address|Status|Type|Build|Protocol|DC"
 	result = append(result, header)
 	for _, member := range members {
-		addr := net.TCPAddr{IP: member.Addr, Port: int(member.Port)}
+		addr := memberAddr(member)
+		status := memberStatus(member)
 		protocol := member.Tags["vsn"]
 		build := member.Tags["build"]
 		if build == "" {
@@ -140,15 +247,15 @@ func (c *MembersCommand) standardOutput(members []agent.Member) []string {
 		switch member.Tags["role"] {
 		case "node":
 			line := fmt.Sprintf("%s|%s|%s|client|%s|%s|%s",
-				member.Name, addr.String(), member.Status, build, protocol, dc)
+				member.Name, addr, status, build, protocol, dc)
 			result = append(result, line)
 		case "consul":
 			line := fmt.Sprintf("%s|%s|%s|server|%s|%s|%s",
-				member.Name, addr.String(), member.Status, build, protocol, dc)
+				member.Name, addr, status, build, protocol, dc)
 			result = append(result, line)
 		default:
 			line := fmt.Sprintf("%s|%s|%s|unknown|||",
-				member.Name, addr.String(), member.Status)
+				member.Name, addr, status)
 			result = append(result, line)
 		}
 	}
@@ -157,9 +264,9 @@ func (c *MembersCommand) standardOutput(members []agent.Member) []string {
 
 // detailedOutput is used to dump all known information about nodes in
 // their raw format
-func (c *MembersCommand) detailedOutput(members []agent.Member) []string {
+func (c *MembersCommand) detailedOutput(members []*api.AgentMember) []string {
 	result := make([]string, 0, len(members))
-	header := "Node|Address|Status|Tags"
+	header := "Node|Address|Status|Protocol|Delegate|Tags"
 	result = append(result, header)
 	for _, member := range members {
 		// Get the tags sorted by key
@@ -177,9 +284,12 @@ func (c *MembersCommand) detailedOutput(members []agent.Member) []string {
 
 		tags := strings.Join(tagPairs, ",")
 
-		addr := net.TCPAddr{IP: member.Addr, Port: int(member.Port)}
-		line := fmt.Sprintf("%s|%s|%s|%s",
-			member.Name, addr.String(), member.Status, tags)
+		// The protocol versions are shown as the current one, followed by
+		// the range the member understands.
+		protocol := fmt.Sprintf("%d (%d-%d)", member.ProtocolCur, member.ProtocolMin, member.ProtocolMax)
+		delegate := fmt.Sprintf("%d (%d-%d)", member.DelegateCur, member.DelegateMin, member.DelegateMax)
+		line := fmt.Sprintf("%s|%s|%s|%s|%s|%s",
+			member.Name, memberAddr(member), memberStatus(member), protocol, delegate, tags)
 		result = append(result, line)
 	}
 	return result
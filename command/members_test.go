@@ -1,8 +1,12 @@
 package command
 
 import (
+	"encoding/json"
 	"fmt"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/command/agent"
 	"github.com/mitchellh/cli"
+	"os"
 	"strings"
 	"testing"
 )
@@ -103,3 +107,124 @@ func TestMembersCommandRun_statusFilter_failed(t *testing.T) {
 		t.Fatalf("bad: %d", code)
 	}
 }
+
+func TestMembersCommandRun_detailed(t *testing.T) {
+	a1 := testAgent(t)
+	defer a1.Shutdown()
+
+	ui := new(cli.MockUi)
+	c := &MembersCommand{Ui: ui}
+	args := []string{"-http-addr=" + a1.httpAddr, "-detailed"}
+
+	code := c.Run(args)
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+
+	output := ui.OutputWriter.String()
+	if !strings.Contains(output, "Protocol") || !strings.Contains(output, "Delegate") ||
+		!strings.Contains(output, "role=consul") || !strings.Contains(output, "dc=dc1") {
+		t.Fatalf("bad: %#v", output)
+	}
+}
+
+func TestMembersCommandRun_json(t *testing.T) {
+	a1 := testAgent(t)
+	defer a1.Shutdown()
+
+	ui := new(cli.MockUi)
+	c := &MembersCommand{Ui: ui}
+	args := []string{"-http-addr=" + a1.httpAddr, "-format=json", "-status=alive"}
+
+	code := c.Run(args)
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+
+	var members []struct {
+		Name   string
+		Status string
+		Tags   map[string]string
+	}
+	if err := json.Unmarshal(ui.OutputWriter.Bytes(), &members); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(members) != 1 || members[0].Name != a1.config.NodeName || members[0].Status != "alive" ||
+		members[0].Tags["role"] != "consul" {
+		t.Fatalf("bad: %#v", members)
+	}
+}
+
+func TestMembersCommandRun_errors(t *testing.T) {
+	cases := map[string]struct {
+		args []string
+		err  string
+	}{
+		"rpc-addr and http-addr": {
+			[]string{"-rpc-addr=127.0.0.1:8400", "-http-addr=127.0.0.1:8500"},
+			"Cannot specify both -rpc-addr and -http-addr",
+		},
+		"too many args": {
+			[]string{"foo"},
+			"Too many arguments (expected 0, got 1)",
+		},
+		"bad format": {
+			[]string{"-format=yaml"},
+			"Unsupported format \"yaml\"",
+		},
+		"bad status": {
+			[]string{"-status=("},
+			"Failed to compile status regexp",
+		},
+	}
+
+	for name, tc := range cases {
+		ui := new(cli.MockUi)
+		c := &MembersCommand{Ui: ui}
+		if code := c.Run(tc.args); code != 1 {
+			t.Fatalf("%s: bad: %d", name, code)
+		}
+		if output := ui.ErrorWriter.String(); !strings.Contains(output, tc.err) {
+			t.Fatalf("%s: bad: %#v", name, output)
+		}
+	}
+}
+
+func TestMembersCommandRun_rpcAddr(t *testing.T) {
+	a1 := testAgent(t)
+	defer a1.Shutdown()
+
+	run := func(args ...string) (string, string) {
+		ui := new(cli.MockUi)
+		c := &MembersCommand{Ui: ui}
+		if code := c.Run(args); code != 0 {
+			t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+		}
+		return ui.OutputWriter.String(), ui.ErrorWriter.String()
+	}
+
+	// The deprecated RPC address gives the same members as the HTTP API.
+	for _, args := range [][]string{
+		{"-format=json"},
+		{"-detailed"},
+	} {
+		expected, _ := run(append(args, "-http-addr="+a1.httpAddr)...)
+		actual, warning := run(append(args, "-rpc-addr="+a1.addr)...)
+		if actual != expected {
+			t.Fatalf("%v: expected %q, got %q", args, expected, actual)
+		}
+		if !strings.Contains(warning, "deprecated for this command") {
+			t.Fatalf("%v: bad: %#v", args, warning)
+		}
+	}
+
+	// CONSUL_RPC_ADDR is still used on its own.
+	defer os.Setenv(api.HTTPAddrEnvName, os.Getenv(api.HTTPAddrEnvName))
+	defer os.Setenv(agent.RPCAddrEnvName, os.Getenv(agent.RPCAddrEnvName))
+	os.Unsetenv(api.HTTPAddrEnvName)
+	os.Setenv(agent.RPCAddrEnvName, a1.addr)
+	output, warning := run()
+	if !strings.Contains(output, a1.config.NodeName) || !strings.Contains(warning, "RPC address "+a1.addr) {
+		t.Fatalf("bad: %#v %#v", output, warning)
+	}
+}
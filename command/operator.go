@@ -75,6 +75,9 @@ func (c *OperatorCommand) Synopsis() string {
 const raftHelp = `
 Raft Subcommand Actions:
 
+  These actions are deprecated. Use "consul operator raft list-peers" and
+  "consul operator raft remove-peer" instead.
+
   raft -list-peers -stale=[true|false]
 
      Displays the current Raft peer configuration.
@@ -0,0 +1,57 @@
+package command
+
+import (
+	"strings"
+
+	"github.com/mitchellh/cli"
+)
+
+// OperatorRaftCommand is a Command implementation that shows help for the
+// subcommands nested below it. It still runs the -list-peers and -remove-peer
+// actions which came before the subcommands, so existing scripts keep
+// working.
+type OperatorRaftCommand struct {
+	Ui cli.Ui
+}
+
+func (c *OperatorRaftCommand) Run(args []string) int {
+	if len(args) == 0 {
+		return cli.RunResultHelp
+	}
+
+	for _, arg := range args {
+		if name := flagName(arg); name == "list-peers" || name == "remove-peer" {
+			c.Ui.Warn(`Warning! The -list-peers and -remove-peer actions are deprecated. Use ` +
+				`"consul operator raft list-peers" or "consul operator raft remove-peer" instead`)
+			break
+		}
+	}
+	op := &OperatorCommand{Ui: c.Ui}
+	return op.Run(append([]string{"raft"}, args...))
+}
+
+func (c *OperatorRaftCommand) Help() string {
+	helpText := `
+Usage: consul operator raft <subcommand> [options]
+
+  This command has subcommands for viewing and changing the Raft
+  configuration of the Consul servers, such as to recover from the loss of a
+  server.
+
+  List the servers in the Raft configuration:
+
+      $ consul operator raft list-peers
+
+  Remove a failed server from the Raft configuration:
+
+      $ consul operator raft remove-peer -address=10.0.1.12:8300
+
+  For more examples, ask for subcommand help or view the documentation.
+
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *OperatorRaftCommand) Synopsis() string {
+	return "Views and modifies the Raft configuration"
+}
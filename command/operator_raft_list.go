@@ -0,0 +1,101 @@
+package command
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+	"github.com/ryanuber/columnize"
+)
+
+// OperatorRaftListCommand is a Command implementation that is used to list
+// the servers in the Raft configuration.
+type OperatorRaftListCommand struct {
+	Ui cli.Ui
+}
+
+func (c *OperatorRaftListCommand) Synopsis() string {
+	return "Lists the servers in the Raft configuration"
+}
+
+func (c *OperatorRaftListCommand) Help() string {
+	helpText := `
+Usage: consul operator raft list-peers [options]
+
+  Lists the servers in the Raft configuration, with the node name, ID, and
+  address of each, whether it's the leader, and whether it has a vote:
+
+      $ consul operator raft list-peers
+
+  The configuration is read from the leader. If the cluster has lost its
+  leader, use -stale to read it from the server the agent talks to instead.
+
+  For a full list of options and examples, please see the Consul documentation.
+
+` + apiOptsText + `
+
+Operator Raft List Peers Options:
+
+  -format=<string>        Output format, either "text" or "json". With "json",
+                          the servers are printed as an array of objects. The
+                          default value is "text".
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *OperatorRaftListCommand) Run(args []string) (code int) {
+	cmdFlags, apiFlags := NewAPIFlagSet("list-peers", c.Ui)
+	defer apiFlags.CheckTimeout(c.Ui, &code)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	format := cmdFlags.String("format", "text", "")
+	if err := parseFlags(c.Ui, cmdFlags, args); err != nil {
+		return 1
+	}
+
+	if args = cmdFlags.Args(); len(args) > 0 {
+		c.Ui.Error(fmt.Sprintf("Too many arguments (expected 0, got %d)", len(args)))
+		return 1
+	}
+	if *format != "text" && *format != "json" {
+		c.Ui.Error(fmt.Sprintf("Error! Unsupported format %q (expected text or json)", *format))
+		return 1
+	}
+
+	// Create and test the HTTP client
+	client, err := apiFlags.Client()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+		return 1
+	}
+
+	reply, err := client.Operator().RaftGetConfiguration(apiFlags.QueryOptions())
+	if err != nil {
+		c.Ui.Error(apiFlags.errorMessage("Error getting the Raft configuration", err,
+			operatorDenial("read the Raft configuration", "read")))
+		return exitCommError
+	}
+
+	if *format == "json" {
+		if reply.Servers == nil {
+			reply.Servers = []*api.RaftServer{}
+		}
+		return printJSON(c.Ui, reply.Servers)
+	}
+
+	result := []string{"Node|ID|Address|State|Voter"}
+	for _, s := range reply.Servers {
+		state := "follower"
+		if s.Leader {
+			state = "leader"
+		}
+		voter := "voter"
+		if !s.Voter {
+			voter = "non-voter"
+		}
+		result = append(result, fmt.Sprintf("%s|%s|%s|%s|%s",
+			s.Node, s.ID, s.Address, state, voter))
+	}
+	c.Ui.Output(columnize.SimpleFormat(result))
+	return 0
+}
@@ -0,0 +1,86 @@
+package command
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+)
+
+func TestOperatorRaftListCommand_implements(t *testing.T) {
+	var _ cli.Command = &OperatorRaftListCommand{}
+}
+
+func TestOperatorRaftListCommand_noTabs(t *testing.T) {
+	assertNoTabs(t, new(OperatorRaftListCommand))
+}
+
+func TestOperatorRaftListCommand_Run(t *testing.T) {
+	srv, _ := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	ui := new(cli.MockUi)
+	c := &OperatorRaftListCommand{Ui: ui}
+	if code := c.Run([]string{"-http-addr=" + srv.httpAddr}); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+
+	lines := strings.Split(strings.TrimSpace(ui.OutputWriter.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("bad: %#v", lines)
+	}
+	if fields := strings.Fields(lines[0]); strings.Join(fields, " ") != "Node ID Address State Voter" {
+		t.Fatalf("bad: %#v", lines[0])
+	}
+	if !strings.HasPrefix(lines[1], srv.config.NodeName) {
+		t.Fatalf("bad: %#v", lines[1])
+	}
+	fields := strings.Fields(strings.TrimPrefix(lines[1], srv.config.NodeName))
+	if len(fields) != 4 || fields[2] != "leader" || fields[3] != "voter" {
+		t.Fatalf("bad: %#v", lines[1])
+	}
+
+	ui = new(cli.MockUi)
+	c = &OperatorRaftListCommand{Ui: ui}
+	if code := c.Run([]string{"-http-addr=" + srv.httpAddr, "-format=json"}); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	var servers []*api.RaftServer
+	if err := json.Unmarshal(ui.OutputWriter.Bytes(), &servers); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(servers) != 1 || servers[0].Node != srv.config.NodeName || !servers[0].Leader ||
+		!servers[0].Voter || servers[0].Address != fields[1] {
+		t.Fatalf("bad: %#v", servers)
+	}
+}
+
+func TestOperatorRaftListCommand_Run_errors(t *testing.T) {
+	cases := map[string]struct {
+		args []string
+		err  string
+	}{
+		"too many args": {
+			[]string{"foo"},
+			"Too many arguments (expected 0, got 1)",
+		},
+		"bad format": {
+			[]string{"-format=yaml"},
+			"Unsupported format \"yaml\"",
+		},
+	}
+
+	for name, tc := range cases {
+		ui := new(cli.MockUi)
+		c := &OperatorRaftListCommand{Ui: ui}
+		if code := c.Run(tc.args); code != 1 {
+			t.Fatalf("%s: bad: %d", name, code)
+		}
+		if output := ui.ErrorWriter.String(); !strings.Contains(output, tc.err) {
+			t.Fatalf("%s: bad: %#v", name, output)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package command
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mattn/go-isatty"
+	"github.com/mitchellh/cli"
+)
+
+// OperatorRaftRemoveCommand is a Command implementation that is used to
+// remove a server from the Raft configuration.
+type OperatorRaftRemoveCommand struct {
+	Ui cli.Ui
+
+	// testStdinTerminal overrides the check for whether stdin is a
+	// terminal, for testing.
+	testStdinTerminal *bool
+}
+
+func (c *OperatorRaftRemoveCommand) Synopsis() string {
+	return "Removes a server from the Raft configuration"
+}
+
+func (c *OperatorRaftRemoveCommand) Help() string {
+	helpText := `
+Usage: consul operator raft remove-peer [options]
+
+  Removes the server with the given -address or -id from the Raft
+  configuration. The server is looked up first and shown before asking for
+  confirmation, which can be skipped with the -force option. When not running
+  interactively, -force is required. If there's no such server, the command
+  exits with status 2.
+
+      $ consul operator raft remove-peer -address=10.0.1.12:8300
+
+  There are rare cases where a server is left behind in the Raft
+  configuration even though it's no longer part of the cluster. This removes
+  it, so it no longer counts towards the quorum. If the server still shows in
+  the output of "consul members", it's better to run "consul force-leave"
+  instead.
+
+  Removing the leader forces the servers to elect a new one, so it's refused
+  unless -allow-leader-removal is given.
+
+  For a full list of options and examples, please see the Consul documentation.
+
+` + apiOptsText + `
+
+Operator Raft Remove Peer Options:
+
+  -address=<addr>         Address of the server to remove, in the form
+                          "IP:port". The port is usually 8300.
+
+  -allow-leader-removal   Allow the current leader to be removed. The default
+                          value is false.
+
+  -force                  Remove the server without asking for confirmation.
+                          This is required when not running interactively.
+                          The default value is false.
+
+  -id=<id>                ID of the server to remove, as shown by "consul
+                          operator raft list-peers". Only one of -address or
+                          -id may be given.
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *OperatorRaftRemoveCommand) Run(args []string) (code int) {
+	cmdFlags, apiFlags := NewAPIFlagSet("remove-peer", c.Ui)
+	defer apiFlags.CheckTimeout(c.Ui, &code)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	address := cmdFlags.String("address", "", "")
+	id := cmdFlags.String("id", "", "")
+	force := cmdFlags.Bool("force", false, "")
+	allowLeader := cmdFlags.Bool("allow-leader-removal", false, "")
+	if err := parseFlags(c.Ui, cmdFlags, args); err != nil {
+		return 1
+	}
+
+	if args = cmdFlags.Args(); len(args) > 0 {
+		c.Ui.Error(fmt.Sprintf("Too many arguments (expected 0, got %d)", len(args)))
+		return 1
+	}
+	if *address == "" && *id == "" {
+		c.Ui.Error("Error! Missing -address or -id of the server to remove")
+		return 1
+	}
+	if *address != "" && *id != "" {
+		c.Ui.Error("Error! Cannot specify both -address and -id")
+		return 1
+	}
+
+	// Create and test the HTTP client
+	client, err := apiFlags.Client()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+		return 1
+	}
+
+	// The server is looked up first, to check whether it's the leader and
+	// to find its address from an ID, since peers can only be removed by
+	// address.
+	reply, err := client.Operator().RaftGetConfiguration(apiFlags.QueryOptions())
+	if err != nil {
+		c.Ui.Error(apiFlags.errorMessage("Error getting the Raft configuration", err,
+			operatorDenial("read the Raft configuration", "read")))
+		return exitCommError
+	}
+	var server *api.RaftServer
+	for _, s := range reply.Servers {
+		if (*address != "" && s.Address == *address) || (*id != "" && s.ID == *id) {
+			server = s
+			break
+		}
+	}
+	if server == nil {
+		if *address != "" {
+			c.Ui.Error(fmt.Sprintf("Error! No server with address %q in the Raft configuration", *address))
+		} else {
+			c.Ui.Error(fmt.Sprintf("Error! No server with ID %q in the Raft configuration", *id))
+		}
+		return exitNotFound
+	}
+
+	if server.Leader && !*allowLeader {
+		c.Ui.Error(fmt.Sprintf("Error! Refusing to remove %s, which is the current leader. "+
+			"Use -allow-leader-removal to remove it and force a new election", server.Address))
+		return 1
+	}
+	if !*force && !c.confirm(server) {
+		return 1
+	}
+
+	if err := client.Operator().RaftRemovePeerByAddress(server.Address, apiFlags.WriteOptions()); err != nil {
+		c.Ui.Error(apiFlags.errorMessage("Error removing server", err,
+			operatorDenial("change the Raft configuration", "write")))
+		return exitCommError
+	}
+
+	apiFlags.report(c.Ui, fmt.Sprintf("Success! Removed server %s (node %s) from the Raft configuration",
+		server.Address, server.Node))
+	return 0
+}
+
+// confirm asks the user to approve removing the server, reporting why not if
+// they don't.
+func (c *OperatorRaftRemoveCommand) confirm(server *api.RaftServer) bool {
+	if !c.stdinIsTerminal() {
+		c.Ui.Error("Error! Refusing to remove a server without confirmation. " +
+			"Use -force to skip the prompt when not running interactively.")
+		return false
+	}
+
+	role := "a follower"
+	if server.Leader {
+		role = "the leader"
+	}
+	query := fmt.Sprintf("Remove server %s (node %s), which is %s, from the Raft configuration? "+
+		"Only 'yes' will be accepted to approve.", server.Address, server.Node, role)
+	answer, err := c.Ui.Ask(query)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error reading confirmation: %s", err))
+		return false
+	}
+	if strings.TrimSpace(answer) != "yes" {
+		c.Ui.Error("Remove cancelled, the server was not removed")
+		return false
+	}
+	return true
+}
+
+// stdinIsTerminal returns true if the command can prompt the user for input.
+func (c *OperatorRaftRemoveCommand) stdinIsTerminal() bool {
+	if c.testStdinTerminal != nil {
+		return *c.testStdinTerminal
+	}
+	return isatty.IsTerminal(os.Stdin.Fd())
+}
@@ -0,0 +1,131 @@
+package command
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/cli"
+)
+
+func TestOperatorRaftRemoveCommand_implements(t *testing.T) {
+	var _ cli.Command = &OperatorRaftRemoveCommand{}
+}
+
+func TestOperatorRaftRemoveCommand_noTabs(t *testing.T) {
+	assertNoTabs(t, new(OperatorRaftRemoveCommand))
+}
+
+func TestOperatorRaftRemoveCommand_Run_errors(t *testing.T) {
+	cases := map[string]struct {
+		args []string
+		err  string
+	}{
+		"too many args": {
+			[]string{"-address=10.0.0.1:8300", "foo"},
+			"Too many arguments (expected 0, got 1)",
+		},
+		"no address or id": {
+			[]string{},
+			"Missing -address or -id",
+		},
+		"address and id": {
+			[]string{"-address=10.0.0.1:8300", "-id=10.0.0.1:8300"},
+			"Cannot specify both -address and -id",
+		},
+	}
+
+	for name, tc := range cases {
+		ui := new(cli.MockUi)
+		c := &OperatorRaftRemoveCommand{Ui: ui}
+		if code := c.Run(tc.args); code != 1 {
+			t.Fatalf("%s: bad: %d", name, code)
+		}
+		if output := ui.ErrorWriter.String(); !strings.Contains(output, tc.err) {
+			t.Fatalf("%s: bad: %#v", name, output)
+		}
+	}
+}
+
+func TestOperatorRaftRemoveCommand_Run(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	reply, err := client.Operator().RaftGetConfiguration(nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(reply.Servers) != 1 {
+		t.Fatalf("bad: %#v", reply.Servers)
+	}
+	leader := reply.Servers[0]
+
+	notTerminal := false
+	cases := map[string]struct {
+		args []string
+		code int
+		err  string
+	}{
+		"missing address": {
+			[]string{"-force", "-address=10.0.0.1:8300"},
+			exitNotFound,
+			`No server with address "10.0.0.1:8300" in the Raft configuration`,
+		},
+		"missing id": {
+			[]string{"-force", "-id=nope"},
+			exitNotFound,
+			`No server with ID "nope" in the Raft configuration`,
+		},
+		"leader": {
+			[]string{"-force", "-address=" + leader.Address},
+			1,
+			"Use -allow-leader-removal",
+		},
+		"leader by id": {
+			[]string{"-force", "-id=" + leader.ID},
+			1,
+			"Use -allow-leader-removal",
+		},
+		"no confirmation": {
+			[]string{"-allow-leader-removal", "-address=" + leader.Address},
+			1,
+			"Refusing to remove a server without confirmation",
+		},
+	}
+
+	for name, tc := range cases {
+		ui := new(cli.MockUi)
+		c := &OperatorRaftRemoveCommand{Ui: ui, testStdinTerminal: &notTerminal}
+		args := append([]string{"-http-addr=" + srv.httpAddr}, tc.args...)
+		if code := c.Run(args); code != tc.code {
+			t.Fatalf("%s: bad: %d. %#v", name, code, ui.ErrorWriter.String())
+		}
+		if output := ui.ErrorWriter.String(); !strings.Contains(output, tc.err) {
+			t.Fatalf("%s: bad: %#v", name, output)
+		}
+	}
+
+	// Declining the prompt leaves the server in place.
+	terminal := true
+	ui := new(cli.MockUi)
+	ui.InputReader = strings.NewReader("no\n")
+	c := &OperatorRaftRemoveCommand{Ui: ui, testStdinTerminal: &terminal}
+	args := []string{"-http-addr=" + srv.httpAddr, "-allow-leader-removal", "-address=" + leader.Address}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if output := ui.OutputWriter.String(); !strings.Contains(output, "which is the leader") {
+		t.Fatalf("bad: %#v", output)
+	}
+	if output := ui.ErrorWriter.String(); !strings.Contains(output, "Remove cancelled") {
+		t.Fatalf("bad: %#v", output)
+	}
+
+	reply, err = client.Operator().RaftGetConfiguration(nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(reply.Servers) != 1 {
+		t.Fatalf("bad: %#v", reply.Servers)
+	}
+}
@@ -50,3 +50,31 @@ func TestOperator_Raft_RemovePeer(t *testing.T) {
 		t.Fatalf("bad: %s", output)
 	}
 }
+
+func TestOperatorRaftCommand_implements(t *testing.T) {
+	var _ cli.Command = &OperatorRaftCommand{}
+}
+
+func TestOperatorRaftCommand_deprecated(t *testing.T) {
+	a1 := testAgent(t)
+	defer a1.Shutdown()
+	waitForLeader(t, a1.httpAddr)
+
+	ui := new(cli.MockUi)
+	c := &OperatorRaftCommand{Ui: ui}
+	if code := c.Run(nil); code != cli.RunResultHelp {
+		t.Fatalf("bad: %d", code)
+	}
+
+	// The old actions still work, with a warning.
+	args := []string{"-http-addr=" + a1.httpAddr, "-list-peers"}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if output := ui.OutputWriter.String(); !strings.Contains(output, "leader") {
+		t.Fatalf("bad: %s", output)
+	}
+	if output := ui.ErrorWriter.String(); !strings.Contains(output, "-list-peers and -remove-peer actions are deprecated") {
+		t.Fatalf("bad: %s", output)
+	}
+}
@@ -0,0 +1,52 @@
+package command
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/mitchellh/cli"
+)
+
+// parseFlags parses the flags for a command like f.Parse, but fails if one of
+// the command's own flags appears after the first argument. The flag package
+// stops at the first argument, so "consul kv delete foo -recurse" would
+// otherwise delete the single key "foo" and quietly ignore -recurse. An
+// argument which only looks like a flag can still be given after "--".
+func parseFlags(ui cli.Ui, f *flag.FlagSet, args []string) error {
+	if err := f.Parse(args); err != nil {
+		return err
+	}
+
+	rest := f.Args()
+	if start := len(args) - len(rest); start > 0 && args[start-1] == "--" {
+		return nil
+	}
+	for _, arg := range rest {
+		if name := flagName(arg); name != "" && f.Lookup(name) != nil {
+			err := fmt.Errorf("Error! The -%s flag was given after the arguments, where it "+
+				"would be ignored. Move it before them, or put \"--\" before the arguments "+
+				"to pass %q as an argument", name, arg)
+			ui.Error(err.Error())
+			return err
+		}
+	}
+	return nil
+}
+
+// flagName returns the name of the flag an argument would set, such as
+// "recurse" for "-recurse" or "--recurse=true", or an empty string if it
+// isn't written like a flag.
+func flagName(arg string) string {
+	if len(arg) < 2 || arg[0] != '-' {
+		return ""
+	}
+	name := strings.TrimPrefix(arg[1:], "-")
+	if i := strings.Index(name, "="); i >= 0 {
+		name = name[:i]
+	}
+	if name == "" || name[0] == '-' {
+		return ""
+	}
+	return name
+}
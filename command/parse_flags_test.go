@@ -0,0 +1,156 @@
+package command
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/cli"
+)
+
+func TestParseFlags_order(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	addr := "-http-addr=" + srv.httpAddr
+	const misplaced = "flag was given after the arguments"
+
+	cases := []struct {
+		name   string
+		cmd    func(ui cli.Ui) cli.Command
+		args   []string
+		code   int
+		output string
+	}{
+		{
+			"put flag before",
+			func(ui cli.Ui) cli.Command { return &KVPutCommand{Ui: ui} },
+			[]string{addr, "-flags=42", "order/a", "a"},
+			0, "Success! Data written to: order/a",
+		},
+		{
+			"put flag after",
+			func(ui cli.Ui) cli.Command { return &KVPutCommand{Ui: ui} },
+			[]string{addr, "order/after", "b", "-flags=42"},
+			1, "The -flags " + misplaced,
+		},
+		{
+			"put mixed",
+			func(ui cli.Ui) cli.Command { return &KVPutCommand{Ui: ui} },
+			[]string{addr, "order/mixed", "-base64", "Yw=="},
+			1, "The -base64 " + misplaced,
+		},
+		{
+			"put after --",
+			func(ui cli.Ui) cli.Command { return &KVPutCommand{Ui: ui} },
+			[]string{addr, "--", "order/b", "-flags=42"},
+			0, "Success! Data written to: order/b",
+		},
+		{
+			"get flag before",
+			func(ui cli.Ui) cli.Command { return &KVGetCommand{Ui: ui} },
+			[]string{addr, "-recurse", "order/"},
+			0, "order/a:a",
+		},
+		{
+			"get flag after",
+			func(ui cli.Ui) cli.Command { return &KVGetCommand{Ui: ui} },
+			[]string{addr, "order/", "-recurse"},
+			1, "The -recurse " + misplaced,
+		},
+		{
+			"get mixed",
+			func(ui cli.Ui) cli.Command { return &KVGetCommand{Ui: ui} },
+			[]string{addr, "-recurse", "order/", "--keys"},
+			1, "The -keys " + misplaced,
+		},
+		{
+			"delete flag after",
+			func(ui cli.Ui) cli.Command { return &KVDeleteCommand{Ui: ui} },
+			[]string{addr, "order/", "-recurse"},
+			1, "The -recurse " + misplaced,
+		},
+		{
+			"delete mixed",
+			func(ui cli.Ui) cli.Command { return &KVDeleteCommand{Ui: ui} },
+			[]string{addr, "-recurse", "order/", "-recurse=false"},
+			1, "The -recurse " + misplaced,
+		},
+		{
+			"restore flag before",
+			func(ui cli.Ui) cli.Command { return &SnapshotRestoreCommand{Ui: ui} },
+			[]string{addr, "-force", "nope.snap"},
+			1, "Error opening snapshot file",
+		},
+		{
+			"restore flag after",
+			func(ui cli.Ui) cli.Command { return &SnapshotRestoreCommand{Ui: ui} },
+			[]string{addr, "nope.snap", "-force"},
+			1, "The -force " + misplaced,
+		},
+		{
+			"restore mixed",
+			func(ui cli.Ui) cli.Command { return &SnapshotRestoreCommand{Ui: ui} },
+			[]string{addr, "-skip-verify", "nope.snap", "-force=true"},
+			1, "The -force " + misplaced,
+		},
+	}
+
+	for _, tc := range cases {
+		ui := new(cli.MockUi)
+		code := tc.cmd(ui).Run(tc.args)
+		if code != tc.code {
+			t.Fatalf("%s: expected exit %d, got %d. %#v", tc.name, tc.code, code, ui.ErrorWriter.String())
+		}
+		output := ui.OutputWriter.String() + ui.ErrorWriter.String()
+		if !strings.Contains(output, tc.output) {
+			t.Fatalf("%s: expected %q to contain %q", tc.name, output, tc.output)
+		}
+	}
+
+	// Nothing was written or deleted by the commands which failed.
+	pairs, _, err := client.KV().List("order/", nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(pairs) != 2 || pairs[0].Key != "order/a" || pairs[0].Flags != 42 ||
+		pairs[1].Key != "order/b" || string(pairs[1].Value) != "-flags=42" {
+		t.Fatalf("bad: %#v", pairs)
+	}
+
+	// With the flag first, the tree is deleted.
+	ui := new(cli.MockUi)
+	c := &KVDeleteCommand{Ui: ui}
+	if code := c.Run([]string{addr, "-recurse", "-force", "order/"}); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	pairs, _, err = client.KV().List("order/", nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(pairs) != 0 {
+		t.Fatalf("bad: %#v", pairs)
+	}
+}
+
+func TestFlagName(t *testing.T) {
+	cases := map[string]string{
+		"-recurse":      "recurse",
+		"--recurse":     "recurse",
+		"-flags=42":     "flags",
+		"--flags=42":    "flags",
+		"-":             "",
+		"--":            "",
+		"---recurse":    "",
+		"-=42":          "",
+		"foo":           "",
+		"foo-recurse":   "",
+		"-5":            "5",
+		"-base64=a=b=c": "base64",
+	}
+	for arg, expected := range cases {
+		if actual := flagName(arg); actual != expected {
+			t.Errorf("%q: expected %q, got %q", arg, expected, actual)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+)
+
+// QueryCommand is a Command implementation that just shows help for the
+// subcommands nested below it.
+type QueryCommand struct {
+	Ui cli.Ui
+}
+
+func (c *QueryCommand) Run(args []string) int {
+	return cli.RunResultHelp
+}
+
+func (c *QueryCommand) Help() string {
+	helpText := `
+Usage: consul query <subcommand> [options] [args]
+
+  This command has subcommands for managing prepared queries, which look up
+  the healthy instances of a service by a name or ID, with filtering and
+  failover to other datacenters built in. Queries are defined in JSON, in the
+  same form as the HTTP API takes.
+
+  Create a query from a definition in a file, printing its ID:
+
+      $ consul query create redis.json
+
+  Execute a query by name, listing the nodes it returns:
+
+      $ consul query execute redis
+
+  Show the definition of a query, which can be edited and given to update:
+
+      $ consul query read 8f246b77-f3e1-ff88-5b48-8ec93abf3e05 > redis.json
+
+  For more examples, ask for subcommand help or view the documentation.
+
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *QueryCommand) Synopsis() string {
+	return "Manages prepared queries"
+}
+
+// queryArg returns the single argument, such as a query ID, reporting an
+// error naming it as what if there isn't exactly one.
+func queryArg(ui cli.Ui, args []string, what string) (string, bool) {
+	switch len(args) {
+	case 0:
+		ui.Error(fmt.Sprintf("Error! Missing %s argument", what))
+		return "", false
+	case 1:
+	default:
+		ui.Error(fmt.Sprintf("Too many arguments (expected 1, got %d)", len(args)))
+		return "", false
+	}
+	if args[0] == "" {
+		ui.Error(fmt.Sprintf("Error! The %s argument can't be empty", what))
+		return "", false
+	}
+	return args[0], true
+}
+
+// isQueryNotFound returns true if the agent reported that there is no query
+// with the given name or ID.
+func isQueryNotFound(err error) bool {
+	return strings.Contains(err.Error(), "Unexpected response code: 404")
+}
+
+// queryGet returns the query with the given ID, or nil if there is none.
+func queryGet(client *api.Client, id string, q *api.QueryOptions) (*api.PreparedQueryDefinition, error) {
+	queries, _, err := client.PreparedQuery().Get(id, q)
+	if err != nil {
+		if isQueryNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if len(queries) == 0 {
+		return nil, nil
+	}
+	return queries[0], nil
+}
+
+// queryReadDefinition reads a query definition in JSON from a file, or from
+// stdin for "-", and checks it for mistakes the agent would reject or
+// silently ignore.
+func queryReadDefinition(path string) (*api.PreparedQueryDefinition, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read the definition: %s", err)
+	}
+
+	var def api.PreparedQueryDefinition
+	if err := json.Unmarshal(data, &def); err != nil {
+		return nil, fmt.Errorf("Failed to parse the definition: %s", err)
+	}
+	if err := queryCheckFields(data, reflect.TypeOf(def), ""); err != nil {
+		return nil, fmt.Errorf("Invalid definition: %s", err)
+	}
+	if err := validateQueryDefinition(&def); err != nil {
+		return nil, fmt.Errorf("Invalid definition: %s", err)
+	}
+	return &def, nil
+}
+
+// queryCheckFields reports any field of a JSON object which isn't in the
+// given struct, such as a misspelled one, which would otherwise be ignored.
+// Field names match without regard to case, the same as when decoding.
+func queryCheckFields(data []byte, t reflect.Type, path string) error {
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(bytes.TrimSpace(data), &fields); err != nil {
+		// Anything but an object has already failed to decode, or is null.
+		return nil
+	}
+
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f, ok := t.FieldByNameFunc(func(field string) bool { return strings.EqualFold(field, name) })
+		if !ok {
+			return fmt.Errorf("Unknown field %q", path+name)
+		}
+		if err := queryCheckFields(fields[name], f.Type, path+f.Name+"."); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateQueryDefinition checks a query definition for the mistakes the
+// agent would reject, so they can be reported with the fields involved.
+func validateQueryDefinition(def *api.PreparedQueryDefinition) error {
+	if def.Service.Service == "" {
+		return fmt.Errorf("Service.Service must give the name of the service to query")
+	}
+	if def.Service.Failover.NearestN < 0 {
+		return fmt.Errorf("Service.Failover.NearestN must not be negative (got %d)", def.Service.Failover.NearestN)
+	}
+	for _, tag := range def.Service.Tags {
+		if tag == "" || tag == "!" {
+			return fmt.Errorf("Service.Tags can't have an empty tag")
+		}
+	}
+
+	if def.DNS.TTL != "" {
+		ttl, err := time.ParseDuration(def.DNS.TTL)
+		if err != nil {
+			return fmt.Errorf("DNS.TTL %q is not a duration, such as \"10s\"", def.DNS.TTL)
+		}
+		if ttl < 0 {
+			return fmt.Errorf("DNS.TTL must not be negative (got %q)", def.DNS.TTL)
+		}
+	}
+
+	switch def.Template.Type {
+	case "":
+		if def.Template.Regexp != "" {
+			return fmt.Errorf("Template.Regexp can only be given with Template.Type")
+		}
+	case "name_prefix_match":
+		if _, err := regexp.Compile(def.Template.Regexp); err != nil {
+			return fmt.Errorf("Template.Regexp is not valid: %s", err)
+		}
+	default:
+		return fmt.Errorf("Unsupported Template.Type %q (expected name_prefix_match)", def.Template.Type)
+	}
+
+	// Reading a query without the privileges for its token gives this in
+	// place of the token, which would then be used to execute it.
+	if def.Token == "<hidden>" {
+		return fmt.Errorf("Token is \"<hidden>\", which looks like it was copied from a query read " +
+			"without the privileges to see its token. Remove it, or give the real token")
+	}
+	return nil
+}
+
+// queriesByName sorts queries by name, and then by ID.
+type queriesByName []*api.PreparedQueryDefinition
+
+func (s queriesByName) Len() int      { return len(s) }
+func (s queriesByName) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s queriesByName) Less(i, j int) bool {
+	if s[i].Name != s[j].Name {
+		return s[i].Name < s[j].Name
+	}
+	return s[i].ID < s[j].ID
+}
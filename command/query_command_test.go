@@ -0,0 +1,125 @@
+package command
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+)
+
+func TestQueryCommand_implements(t *testing.T) {
+	var _ cli.Command = &QueryCommand{}
+}
+
+func TestQueryCommand_noTabs(t *testing.T) {
+	assertNoTabs(t, new(QueryCommand))
+}
+
+// testQueryCreate creates a prepared query for the query tests, returning
+// its ID.
+func testQueryCreate(t *testing.T, client *api.Client, def *api.PreparedQueryDefinition) string {
+	id, _, err := client.PreparedQuery().Create(def, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return id
+}
+
+// testQueryDefinitionFile writes a query definition to a file in the given
+// directory, returning its path.
+func testQueryDefinitionFile(t *testing.T, dir, name, def string) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(def), 0600); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return path
+}
+
+func TestQueryReadDefinition(t *testing.T) {
+	dir, err := ioutil.TempDir("", "query")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cases := map[string]struct {
+		def string
+		err string
+	}{
+		"valid": {
+			`{"Name": "web", "service": {"Service": "web", "Tags": ["v1", "!v2"], "Failover": {"NearestN": 2}},
+			  "DNS": {"TTL": "10s"}}`,
+			"",
+		},
+		"template": {
+			`{"Name": "geo-db", "Template": {"Type": "name_prefix_match", "Regexp": "^geo-db-(.*)$"},
+			  "Service": {"Service": "mysql-${match(1)}"}}`,
+			"",
+		},
+		"not JSON": {
+			`Service = "web"`,
+			"Failed to parse the definition",
+		},
+		"no service": {
+			`{"Name": "web"}`,
+			"Service.Service must give the name of the service to query",
+		},
+		"unknown field": {
+			`{"Service": {"Service": "web", "Tag": ["v1"]}}`,
+			`Unknown field "Service.Tag"`,
+		},
+		"unknown top level field": {
+			`{"Services": {"Service": "web"}}`,
+			`Unknown field "Services"`,
+		},
+		"negative NearestN": {
+			`{"Service": {"Service": "web", "Failover": {"NearestN": -1}}}`,
+			"Service.Failover.NearestN must not be negative",
+		},
+		"empty tag": {
+			`{"Service": {"Service": "web", "Tags": ["!"]}}`,
+			"Service.Tags can't have an empty tag",
+		},
+		"bad TTL": {
+			`{"Service": {"Service": "web"}, "DNS": {"TTL": "10"}}`,
+			`DNS.TTL "10" is not a duration`,
+		},
+		"template type": {
+			`{"Service": {"Service": "web"}, "Template": {"Type": "regexp"}}`,
+			`Unsupported Template.Type "regexp"`,
+		},
+		"template regexp": {
+			`{"Service": {"Service": "web"}, "Template": {"Type": "name_prefix_match", "Regexp": "("}}`,
+			"Template.Regexp is not valid",
+		},
+		"regexp without type": {
+			`{"Service": {"Service": "web"}, "Template": {"Regexp": "^web"}}`,
+			"Template.Regexp can only be given with Template.Type",
+		},
+		"redacted token": {
+			`{"Service": {"Service": "web"}, "Token": "<hidden>"}`,
+			`Token is "<hidden>"`,
+		},
+	}
+
+	for name, tc := range cases {
+		path := testQueryDefinitionFile(t, dir, "query.json", tc.def)
+		def, err := queryReadDefinition(path)
+		if tc.err == "" {
+			if err != nil {
+				t.Fatalf("%s: err: %v", name, err)
+			}
+			if def.Service.Service == "" {
+				t.Fatalf("%s: bad: %#v", name, def)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Fatalf("%s: expected an error containing %q, got %v", name, tc.err, err)
+		}
+	}
+}
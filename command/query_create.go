@@ -0,0 +1,80 @@
+package command
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mitchellh/cli"
+)
+
+// QueryCreateCommand is a Command implementation that is used to create a
+// prepared query.
+type QueryCreateCommand struct {
+	Ui cli.Ui
+}
+
+func (c *QueryCreateCommand) Synopsis() string {
+	return "Creates a new prepared query"
+}
+
+func (c *QueryCreateCommand) Help() string {
+	helpText := `
+Usage: consul query create [options] FILE
+
+  Creates a prepared query from the JSON definition in the given file, or in
+  stdin for "-", and prints the ID of the new query, so that it can be
+  captured by a script:
+
+      $ consul query create redis.json
+
+  The definition takes the same form as the HTTP API. It's checked before
+  it's sent, so that mistakes such as a missing service name or a misspelled
+  field are reported with the fields involved. The ID is generated by Consul,
+  so it can't be given.
+
+  For a full list of options and examples, please see the Consul documentation.
+
+` + apiOptsText
+	return strings.TrimSpace(helpText)
+}
+
+func (c *QueryCreateCommand) Run(args []string) (code int) {
+	cmdFlags, apiFlags := NewAPIFlagSet("create", c.Ui)
+	defer apiFlags.CheckTimeout(c.Ui, &code)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	if err := parseFlags(c.Ui, cmdFlags, args); err != nil {
+		return 1
+	}
+
+	path, ok := queryArg(c.Ui, cmdFlags.Args(), "FILE")
+	if !ok {
+		return 1
+	}
+	def, err := queryReadDefinition(path)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error! %s", err))
+		return 1
+	}
+	if def.ID != "" {
+		c.Ui.Error("Error! Invalid definition: The ID is generated by Consul, so it can't be given. " +
+			"Use \"consul query update\" to change an existing query")
+		return 1
+	}
+
+	// Create and test the HTTP client
+	client, err := apiFlags.Client()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+		return 1
+	}
+
+	id, _, err := client.PreparedQuery().Create(def, apiFlags.WriteOptions())
+	if err != nil {
+		c.Ui.Error(apiFlags.errorMessage("Error creating prepared query", err,
+			queryDenial("create a prepared query", def.Name, "write")))
+		return exitCommError
+	}
+
+	c.Ui.Output(id)
+	return 0
+}
@@ -0,0 +1,98 @@
+package command
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/cli"
+)
+
+func TestQueryCreateCommand_implements(t *testing.T) {
+	var _ cli.Command = &QueryCreateCommand{}
+}
+
+func TestQueryCreateCommand_noTabs(t *testing.T) {
+	assertNoTabs(t, new(QueryCreateCommand))
+}
+
+func TestQueryCreateCommand_Run(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+	testHealthRegister(t, client)
+
+	dir, err := ioutil.TempDir("", "query")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := testQueryDefinitionFile(t, dir, "web.json", `{"Name": "web", "Service": {"Service": "web"}}`)
+
+	ui := new(cli.MockUi)
+	c := &QueryCreateCommand{Ui: ui}
+	if code := c.Run([]string{"-http-addr=" + srv.httpAddr, path}); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	id := strings.TrimSpace(ui.OutputWriter.String())
+
+	// The new query can be executed by its ID, and by its name, returning
+	// the passing instance of the service.
+	for _, nameOrID := range []string{id, "web"} {
+		ui = new(cli.MockUi)
+		e := &QueryExecuteCommand{Ui: ui}
+		if code := e.Run([]string{"-http-addr=" + srv.httpAddr, nameOrID}); code != 0 {
+			t.Fatalf("%s: bad: %d. %#v", nameOrID, code, ui.ErrorWriter.String())
+		}
+		output := ui.OutputWriter.String()
+		if !strings.Contains(output, "web-1") || strings.Contains(output, "web-2") {
+			t.Fatalf("%s: bad: %#v", nameOrID, output)
+		}
+	}
+}
+
+func TestQueryCreateCommand_Validation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "query")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cases := map[string]struct {
+		args   []string
+		output string
+	}{
+		"no file": {
+			[]string{},
+			"Missing FILE argument",
+		},
+		"too many": {
+			[]string{"a.json", "b.json"},
+			"Too many arguments (expected 1, got 2)",
+		},
+		"missing file": {
+			[]string{dir + "/missing.json"},
+			"Failed to read the definition",
+		},
+		"no service": {
+			[]string{testQueryDefinitionFile(t, dir, "empty.json", `{"Name": "web"}`)},
+			"Invalid definition: Service.Service must give the name of the service to query",
+		},
+		"ID": {
+			[]string{testQueryDefinitionFile(t, dir, "id.json", `{"ID": "abc", "Service": {"Service": "web"}}`)},
+			"The ID is generated by Consul",
+		},
+	}
+
+	for name, tc := range cases {
+		ui := new(cli.MockUi)
+		c := &QueryCreateCommand{Ui: ui}
+		if code := c.Run(tc.args); code != 1 {
+			t.Fatalf("%s: bad: %d", name, code)
+		}
+		if output := ui.ErrorWriter.String(); !strings.Contains(output, tc.output) {
+			t.Fatalf("%s: expected %q to contain %q", name, output, tc.output)
+		}
+	}
+}
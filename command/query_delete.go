@@ -0,0 +1,131 @@
+package command
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mattn/go-isatty"
+	"github.com/mitchellh/cli"
+)
+
+// QueryDeleteCommand is a Command implementation that is used to delete a
+// prepared query.
+type QueryDeleteCommand struct {
+	Ui cli.Ui
+
+	// testStdinTerminal overrides the check for whether stdin is a
+	// terminal, for testing.
+	testStdinTerminal *bool
+}
+
+func (c *QueryDeleteCommand) Synopsis() string {
+	return "Deletes a prepared query"
+}
+
+func (c *QueryDeleteCommand) Help() string {
+	helpText := `
+Usage: consul query delete [options] ID
+
+  Deletes the prepared query with the given ID, after asking for
+  confirmation, which can be skipped with the -force option. When not running
+  interactively, -force is required. If there's no such query, the command
+  exits with status 2.
+
+      $ consul query delete 8f246b77-f3e1-ff88-5b48-8ec93abf3e05
+
+  For a full list of options and examples, please see the Consul documentation.
+
+` + apiOptsText + `
+
+Query Delete Options:
+
+  -force                  Delete the query without asking for confirmation.
+                          This is required when not running interactively.
+                          The default value is false.
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *QueryDeleteCommand) Run(args []string) (code int) {
+	cmdFlags, apiFlags := NewAPIFlagSet("delete", c.Ui)
+	defer apiFlags.CheckTimeout(c.Ui, &code)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	force := cmdFlags.Bool("force", false, "")
+	if err := parseFlags(c.Ui, cmdFlags, args); err != nil {
+		return 1
+	}
+
+	id, ok := queryArg(c.Ui, cmdFlags.Args(), "ID")
+	if !ok {
+		return 1
+	}
+
+	// Create and test the HTTP client
+	client, err := apiFlags.Client()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+		return 1
+	}
+
+	// The query is looked up first to report a mistyped ID, and to show
+	// what will be deleted.
+	query, err := queryGet(client, id, apiFlags.QueryOptions())
+	if err != nil {
+		c.Ui.Error(apiFlags.errorMessage("Error reading prepared query", err,
+			queryDenial("read the prepared query", "", "read")))
+		return exitCommError
+	}
+	if query == nil {
+		c.Ui.Error(fmt.Sprintf("Error! No prepared query with ID %q", id))
+		return exitNotFound
+	}
+
+	if !*force && !c.confirm(query) {
+		return 1
+	}
+
+	if _, err := client.PreparedQuery().Delete(id, apiFlags.WriteOptions()); err != nil {
+		c.Ui.Error(apiFlags.errorMessage("Error deleting prepared query", err,
+			queryDenial("delete the prepared query", query.Name, "write")))
+		return exitCommError
+	}
+
+	apiFlags.report(c.Ui, fmt.Sprintf("Success! Deleted prepared query: %s", id))
+	return 0
+}
+
+// confirm asks the user to approve deleting the query, reporting why not if
+// they don't.
+func (c *QueryDeleteCommand) confirm(query *api.PreparedQueryDefinition) bool {
+	if !c.stdinIsTerminal() {
+		c.Ui.Error("Error! Refusing to delete a prepared query without confirmation. " +
+			"Use -force to skip the prompt when not running interactively.")
+		return false
+	}
+
+	name := ""
+	if query.Name != "" {
+		name = fmt.Sprintf(" %q", query.Name)
+	}
+	answer, err := c.Ui.Ask(fmt.Sprintf("Delete prepared query%s (%s) for service %s? "+
+		"Only 'yes' will be accepted to approve.", name, query.ID, query.Service.Service))
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error reading confirmation: %s", err))
+		return false
+	}
+	if strings.TrimSpace(answer) != "yes" {
+		c.Ui.Error("Delete cancelled, the prepared query was not deleted")
+		return false
+	}
+	return true
+}
+
+// stdinIsTerminal returns true if the command can prompt the user for input.
+func (c *QueryDeleteCommand) stdinIsTerminal() bool {
+	if c.testStdinTerminal != nil {
+		return *c.testStdinTerminal
+	}
+	return isatty.IsTerminal(os.Stdin.Fd())
+}
@@ -0,0 +1,87 @@
+package command
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+)
+
+func TestQueryDeleteCommand_implements(t *testing.T) {
+	var _ cli.Command = &QueryDeleteCommand{}
+}
+
+func TestQueryDeleteCommand_noTabs(t *testing.T) {
+	assertNoTabs(t, new(QueryDeleteCommand))
+}
+
+func TestQueryDeleteCommand_confirm(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	id := testQueryCreate(t, client, &api.PreparedQueryDefinition{
+		Name:    "web",
+		Service: api.ServiceQuery{Service: "web"},
+	})
+
+	terminal, notTerminal := true, false
+	cases := map[string]struct {
+		terminal *bool
+		input    string
+		code     int
+		output   string
+	}{
+		"not a terminal": {
+			&notTerminal,
+			"",
+			1,
+			"Refusing to delete a prepared query without confirmation",
+		},
+		"declined": {
+			&terminal,
+			"no\n",
+			1,
+			`Delete prepared query "web" (` + id + `) for service web?`,
+		},
+		"confirmed": {
+			&terminal,
+			"yes\n",
+			0,
+			"Success! Deleted prepared query: " + id,
+		},
+	}
+
+	for _, name := range []string{"not a terminal", "declined", "confirmed"} {
+		tc := cases[name]
+		ui := new(cli.MockUi)
+		ui.InputReader = strings.NewReader(tc.input)
+		c := &QueryDeleteCommand{Ui: ui, testStdinTerminal: tc.terminal}
+
+		code := c.Run([]string{"-http-addr=" + srv.httpAddr, id})
+		if code != tc.code {
+			t.Fatalf("%s: bad: %d. %#v", name, code, ui.ErrorWriter.String())
+		}
+
+		output := ui.OutputWriter.String() + ui.ErrorWriter.String()
+		if !strings.Contains(output, tc.output) {
+			t.Fatalf("%s: expected %q to contain %q", name, output, tc.output)
+		}
+
+		query, err := queryGet(client, id, nil)
+		if err != nil {
+			t.Fatalf("%s: err: %v", name, err)
+		}
+		if deleted := query == nil; deleted != (tc.code == 0) {
+			t.Fatalf("%s: bad: %#v", name, query)
+		}
+	}
+
+	// Once it's gone, the query isn't found.
+	ui := new(cli.MockUi)
+	c := &QueryDeleteCommand{Ui: ui}
+	if code := c.Run([]string{"-http-addr=" + srv.httpAddr, "-force", id}); code != exitNotFound {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+}
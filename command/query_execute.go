@@ -0,0 +1,137 @@
+package command
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+	"github.com/ryanuber/columnize"
+)
+
+// QueryExecuteCommand is a Command implementation that is used to execute a
+// prepared query.
+type QueryExecuteCommand struct {
+	Ui cli.Ui
+}
+
+func (c *QueryExecuteCommand) Synopsis() string {
+	return "Executes a prepared query"
+}
+
+func (c *QueryExecuteCommand) Help() string {
+	helpText := `
+Usage: consul query execute [options] NAME_OR_ID
+
+  Executes the prepared query with the given name or ID, and prints the
+  instances of the service it returns as a table, in the order given by the
+  query. If the query returns no instances, the command exits with status 2,
+  so it can be used to check that a service is available:
+
+      $ consul query execute redis
+
+  If the query failed over to another datacenter, that's noted on stderr.
+
+  For a full list of options and examples, please see the Consul documentation.
+
+` + apiOptsText + `
+
+Query Execute Options:
+
+  -format=<string>        Output format, either "text" or "json". With "json",
+                          the whole response is printed as an object, with
+                          the instances under "Nodes". The default value is
+                          "text".
+
+  -limit=<n>              Print at most this many instances. The default value
+                          is 0, which means no limit.
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *QueryExecuteCommand) Run(args []string) (code int) {
+	cmdFlags, apiFlags := NewAPIFlagSet("execute", c.Ui)
+	defer apiFlags.CheckTimeout(c.Ui, &code)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	format := cmdFlags.String("format", "text", "")
+	limit := cmdFlags.Int("limit", 0, "")
+	if err := parseFlags(c.Ui, cmdFlags, args); err != nil {
+		return 1
+	}
+
+	id, ok := queryArg(c.Ui, cmdFlags.Args(), "NAME_OR_ID")
+	if !ok {
+		return 1
+	}
+	if *format != "text" && *format != "json" {
+		c.Ui.Error(fmt.Sprintf("Error! Unsupported format %q (expected text or json)", *format))
+		return 1
+	}
+	if *limit < 0 {
+		c.Ui.Error("Error! -limit must not be negative")
+		return 1
+	}
+
+	// Create and test the HTTP client
+	client, err := apiFlags.Client()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+		return 1
+	}
+
+	resp, _, err := client.PreparedQuery().Execute(id, apiFlags.QueryOptions())
+	if err != nil {
+		if isQueryNotFound(err) {
+			c.Ui.Error(fmt.Sprintf("Error! No prepared query with name or ID %q", id))
+			return exitNotFound
+		}
+		c.Ui.Error(apiFlags.errorMessage("Error executing prepared query", err,
+			queryDenial("execute the prepared query", id, "read")))
+		return exitCommError
+	}
+
+	// The API doesn't take the limit, so the instances are cut down here,
+	// after the query has sorted them.
+	if *limit > 0 && len(resp.Nodes) > *limit {
+		resp.Nodes = resp.Nodes[:*limit]
+	}
+	if resp.Nodes == nil {
+		resp.Nodes = []api.ServiceEntry{}
+	}
+	if resp.Failovers > 0 {
+		apiFlags.note(c.Ui, fmt.Sprintf("Failed over to datacenter %s, after querying %d remote %s",
+			resp.Datacenter, resp.Failovers, pluralDatacenters(resp.Failovers)))
+	}
+
+	if *format == "json" {
+		if code := printJSON(c.Ui, resp); code != 0 {
+			return code
+		}
+	} else if len(resp.Nodes) > 0 {
+		result := []string{"Node|Address|Service ID|Port|Tags|Status"}
+		for _, entry := range resp.Nodes {
+			address := entry.Service.Address
+			if address == "" {
+				address = entry.Node.Address
+			}
+			result = append(result, fmt.Sprintf("%s|%s|%s|%d|%s|%s",
+				entry.Node.Node, address, entry.Service.ID, entry.Service.Port,
+				strings.Join(entry.Service.Tags, ","), entry.Checks.AggregatedStatus()))
+		}
+		c.Ui.Output(columnize.SimpleFormat(result))
+	}
+
+	if len(resp.Nodes) == 0 {
+		c.Ui.Error(fmt.Sprintf("Error! Prepared query %s returned no instances of %s", id, resp.Service))
+		return exitNotFound
+	}
+	return 0
+}
+
+// pluralDatacenters returns the right form of "datacenter" for n of them.
+func pluralDatacenters(n int) string {
+	if n == 1 {
+		return "datacenter"
+	}
+	return "datacenters"
+}
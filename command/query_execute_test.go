@@ -0,0 +1,109 @@
+package command
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+)
+
+func TestQueryExecuteCommand_implements(t *testing.T) {
+	var _ cli.Command = &QueryExecuteCommand{}
+}
+
+func TestQueryExecuteCommand_noTabs(t *testing.T) {
+	assertNoTabs(t, new(QueryExecuteCommand))
+}
+
+func TestQueryExecuteCommand_Run(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+	testHealthRegister(t, client)
+
+	testQueryCreate(t, client, &api.PreparedQueryDefinition{
+		Name:    "web",
+		Service: api.ServiceQuery{Service: "web"},
+	})
+	testQueryCreate(t, client, &api.PreparedQueryDefinition{
+		Name:    "web-v2",
+		Service: api.ServiceQuery{Service: "web", Tags: []string{"v2"}},
+	})
+
+	ui := new(cli.MockUi)
+	c := &QueryExecuteCommand{Ui: ui}
+	if code := c.Run([]string{"-http-addr=" + srv.httpAddr, "web"}); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	output := ui.OutputWriter.String()
+	for _, expected := range []string{"Node", "Service ID", "web-1", "10.0.0.1", "8080", "v1", "passing"} {
+		if !strings.Contains(output, expected) {
+			t.Fatalf("expected %q to contain %q", output, expected)
+		}
+	}
+
+	ui = new(cli.MockUi)
+	c = &QueryExecuteCommand{Ui: ui}
+	if code := c.Run([]string{"-http-addr=" + srv.httpAddr, "-format=json", "-limit=1", "web"}); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	var resp api.PreparedQueryExecuteResponse
+	if err := json.Unmarshal(ui.OutputWriter.Bytes(), &resp); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp.Service != "web" || len(resp.Nodes) != 1 || resp.Nodes[0].Node.Node != "web-1" {
+		t.Fatalf("bad: %#v", resp)
+	}
+
+	// The only v2 instance is critical, so there's nothing to return.
+	ui = new(cli.MockUi)
+	c = &QueryExecuteCommand{Ui: ui}
+	if code := c.Run([]string{"-http-addr=" + srv.httpAddr, "web-v2"}); code != exitNotFound {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if output := ui.ErrorWriter.String(); !strings.Contains(output, "Prepared query web-v2 returned no instances of web") {
+		t.Fatalf("bad: %#v", output)
+	}
+
+	ui = new(cli.MockUi)
+	c = &QueryExecuteCommand{Ui: ui}
+	if code := c.Run([]string{"-http-addr=" + srv.httpAddr, "nope"}); code != exitNotFound {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if output := ui.ErrorWriter.String(); !strings.Contains(output, `No prepared query with name or ID "nope"`) {
+		t.Fatalf("bad: %#v", output)
+	}
+}
+
+func TestQueryExecuteCommand_Validation(t *testing.T) {
+	cases := map[string]struct {
+		args   []string
+		output string
+	}{
+		"no query": {
+			[]string{},
+			"Missing NAME_OR_ID argument",
+		},
+		"format": {
+			[]string{"-format=yaml", "web"},
+			`Unsupported format "yaml" (expected text or json)`,
+		},
+		"limit": {
+			[]string{"-limit=-1", "web"},
+			"-limit must not be negative",
+		},
+	}
+
+	for name, tc := range cases {
+		ui := new(cli.MockUi)
+		c := &QueryExecuteCommand{Ui: ui}
+		if code := c.Run(tc.args); code != 1 {
+			t.Fatalf("%s: bad: %d", name, code)
+		}
+		if output := ui.ErrorWriter.String(); !strings.Contains(output, tc.output) {
+			t.Fatalf("%s: expected %q to contain %q", name, output, tc.output)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package command
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+	"github.com/ryanuber/columnize"
+)
+
+// QueryListCommand is a Command implementation that is used to list the
+// prepared queries.
+type QueryListCommand struct {
+	Ui cli.Ui
+}
+
+func (c *QueryListCommand) Synopsis() string {
+	return "Lists the prepared queries"
+}
+
+func (c *QueryListCommand) Help() string {
+	helpText := `
+Usage: consul query list [options]
+
+  Lists the prepared queries as a table sorted by name, with the service each
+  one looks up. Only the queries the token can read are listed.
+
+      $ consul query list
+
+  For a full list of options and examples, please see the Consul documentation.
+
+` + apiOptsText + `
+
+Query List Options:
+
+  -format=<string>        Output format, either "text" or "json". With "json",
+                          the whole definition of each query is printed, as
+                          an array of objects. The default value is "text".
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *QueryListCommand) Run(args []string) (code int) {
+	cmdFlags, apiFlags := NewAPIFlagSet("list", c.Ui)
+	defer apiFlags.CheckTimeout(c.Ui, &code)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	format := cmdFlags.String("format", "text", "")
+	if err := parseFlags(c.Ui, cmdFlags, args); err != nil {
+		return 1
+	}
+
+	if args = cmdFlags.Args(); len(args) > 0 {
+		c.Ui.Error(fmt.Sprintf("Too many arguments (expected 0, got %d)", len(args)))
+		return 1
+	}
+	if *format != "text" && *format != "json" {
+		c.Ui.Error(fmt.Sprintf("Error! Unsupported format %q (expected text or json)", *format))
+		return 1
+	}
+
+	// Create and test the HTTP client
+	client, err := apiFlags.Client()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+		return 1
+	}
+
+	queries, _, err := client.PreparedQuery().List(apiFlags.QueryOptions())
+	if err != nil {
+		c.Ui.Error(apiFlags.errorMessage("Error listing prepared queries", err,
+			queryDenial("list prepared queries", "", "read")))
+		return exitCommError
+	}
+	sort.Sort(queriesByName(queries))
+
+	if *format == "json" {
+		if queries == nil {
+			queries = []*api.PreparedQueryDefinition{}
+		}
+		return printJSON(c.Ui, queries)
+	}
+	if len(queries) == 0 {
+		return 0
+	}
+
+	result := []string{"ID|Name|Service|Tags|Template"}
+	for _, query := range queries {
+		result = append(result, fmt.Sprintf("%s|%s|%s|%s|%s", query.ID,
+			formatQueryField(query.Name), query.Service.Service,
+			formatQueryField(strings.Join(query.Service.Tags, ",")), formatQueryField(query.Template.Type)))
+	}
+	c.Ui.Output(columnize.SimpleFormat(result))
+	return 0
+}
+
+// formatQueryField formats a field of a query for a table, giving "-" for
+// one which is empty.
+func formatQueryField(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,76 @@
+package command
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+)
+
+func TestQueryListCommand_implements(t *testing.T) {
+	var _ cli.Command = &QueryListCommand{}
+}
+
+func TestQueryListCommand_noTabs(t *testing.T) {
+	assertNoTabs(t, new(QueryListCommand))
+}
+
+func TestQueryListCommand_Run(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	// Nothing is printed when there are no queries.
+	ui := new(cli.MockUi)
+	c := &QueryListCommand{Ui: ui}
+	if code := c.Run([]string{"-http-addr=" + srv.httpAddr}); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if ui.OutputWriter != nil && ui.OutputWriter.Len() != 0 {
+		t.Fatalf("bad: %#v", ui.OutputWriter.String())
+	}
+
+	web := testQueryCreate(t, client, &api.PreparedQueryDefinition{
+		Name:    "web",
+		Service: api.ServiceQuery{Service: "web", Tags: []string{"v1", "!canary"}},
+	})
+	db := testQueryCreate(t, client, &api.PreparedQueryDefinition{
+		Name:     "db-",
+		Service:  api.ServiceQuery{Service: "db-${name.suffix}"},
+		Template: api.QueryTemplate{Type: "name_prefix_match"},
+	})
+
+	ui = new(cli.MockUi)
+	c = &QueryListCommand{Ui: ui}
+	if code := c.Run([]string{"-http-addr=" + srv.httpAddr}); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	lines := strings.Split(strings.TrimSpace(ui.OutputWriter.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("bad: %#v", lines)
+	}
+	for i, expected := range [][]string{
+		{"ID", "Name", "Service", "Tags", "Template"},
+		{db, "db-", "db-${name.suffix}", "-", "name_prefix_match"},
+		{web, "web", "web", "v1,!canary", "-"},
+	} {
+		if fields := strings.Fields(lines[i]); strings.Join(fields, " ") != strings.Join(expected, " ") {
+			t.Fatalf("bad: line %d: %#v", i, lines[i])
+		}
+	}
+
+	ui = new(cli.MockUi)
+	c = &QueryListCommand{Ui: ui}
+	if code := c.Run([]string{"-http-addr=" + srv.httpAddr, "-format=json"}); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	var queries []*api.PreparedQueryDefinition
+	if err := json.Unmarshal(ui.OutputWriter.Bytes(), &queries); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(queries) != 2 || queries[0].ID != db || queries[1].ID != web || queries[1].Service.Tags[1] != "!canary" {
+		t.Fatalf("bad: %#v", queries)
+	}
+}
@@ -0,0 +1,71 @@
+package command
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mitchellh/cli"
+)
+
+// QueryReadCommand is a Command implementation that is used to show the
+// definition of a prepared query.
+type QueryReadCommand struct {
+	Ui cli.Ui
+}
+
+func (c *QueryReadCommand) Synopsis() string {
+	return "Shows the definition of a prepared query"
+}
+
+func (c *QueryReadCommand) Help() string {
+	helpText := `
+Usage: consul query read [options] ID
+
+  Prints the definition of the prepared query with the given ID as JSON, in
+  the form taken by "consul query create" and "consul query update", so it can
+  be edited and given back. If there's no such query, the command exits with
+  status 2.
+
+      $ consul query read 8f246b77-f3e1-ff88-5b48-8ec93abf3e05
+
+  The token of the query is shown as "<hidden>" unless the token used has the
+  privileges to see it.
+
+  For a full list of options and examples, please see the Consul documentation.
+
+` + apiOptsText
+	return strings.TrimSpace(helpText)
+}
+
+func (c *QueryReadCommand) Run(args []string) (code int) {
+	cmdFlags, apiFlags := NewAPIFlagSet("read", c.Ui)
+	defer apiFlags.CheckTimeout(c.Ui, &code)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	if err := parseFlags(c.Ui, cmdFlags, args); err != nil {
+		return 1
+	}
+
+	id, ok := queryArg(c.Ui, cmdFlags.Args(), "ID")
+	if !ok {
+		return 1
+	}
+
+	// Create and test the HTTP client
+	client, err := apiFlags.Client()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+		return 1
+	}
+
+	query, err := queryGet(client, id, apiFlags.QueryOptions())
+	if err != nil {
+		c.Ui.Error(apiFlags.errorMessage("Error reading prepared query", err,
+			queryDenial("read the prepared query", "", "read")))
+		return exitCommError
+	}
+	if query == nil {
+		c.Ui.Error(fmt.Sprintf("Error! No prepared query with ID %q", id))
+		return exitNotFound
+	}
+	return printJSON(c.Ui, query)
+}
@@ -0,0 +1,53 @@
+package command
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+)
+
+func TestQueryReadCommand_implements(t *testing.T) {
+	var _ cli.Command = &QueryReadCommand{}
+}
+
+func TestQueryReadCommand_noTabs(t *testing.T) {
+	assertNoTabs(t, new(QueryReadCommand))
+}
+
+func TestQueryReadCommand_Run(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	id := testQueryCreate(t, client, &api.PreparedQueryDefinition{
+		Name:    "web",
+		Service: api.ServiceQuery{Service: "web", OnlyPassing: true},
+		DNS:     api.QueryDNSOptions{TTL: "10s"},
+	})
+
+	ui := new(cli.MockUi)
+	c := &QueryReadCommand{Ui: ui}
+	if code := c.Run([]string{"-http-addr=" + srv.httpAddr, id}); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	var def api.PreparedQueryDefinition
+	if err := json.Unmarshal(ui.OutputWriter.Bytes(), &def); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if def.ID != id || def.Name != "web" || !def.Service.OnlyPassing || def.DNS.TTL != "10s" {
+		t.Fatalf("bad: %#v", def)
+	}
+
+	ui = new(cli.MockUi)
+	c = &QueryReadCommand{Ui: ui}
+	missing := "8f246b77-f3e1-ff88-5b48-8ec93abf3e05"
+	if code := c.Run([]string{"-http-addr=" + srv.httpAddr, missing}); code != exitNotFound {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if output := ui.ErrorWriter.String(); !strings.Contains(output, "No prepared query with ID") {
+		t.Fatalf("bad: %#v", output)
+	}
+}
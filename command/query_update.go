@@ -0,0 +1,100 @@
+package command
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mitchellh/cli"
+)
+
+// QueryUpdateCommand is a Command implementation that is used to change a
+// prepared query.
+type QueryUpdateCommand struct {
+	Ui cli.Ui
+}
+
+func (c *QueryUpdateCommand) Synopsis() string {
+	return "Changes a prepared query"
+}
+
+func (c *QueryUpdateCommand) Help() string {
+	helpText := `
+Usage: consul query update [options] ID FILE
+
+  Replaces the definition of the prepared query with the given ID with the
+  JSON definition in the given file, or in stdin for "-". If there's no such
+  query, the command exits with status 2.
+
+      $ consul query read 8f246b77-f3e1-ff88-5b48-8ec93abf3e05 > redis.json
+      $ consul query update 8f246b77-f3e1-ff88-5b48-8ec93abf3e05 redis.json
+
+  The whole query is replaced, so anything left out of the definition is
+  cleared. The definition is checked in the same way as for "consul query
+  create". It may leave out the ID, but if it gives one, it must match.
+
+  For a full list of options and examples, please see the Consul documentation.
+
+` + apiOptsText
+	return strings.TrimSpace(helpText)
+}
+
+func (c *QueryUpdateCommand) Run(args []string) (code int) {
+	cmdFlags, apiFlags := NewAPIFlagSet("update", c.Ui)
+	defer apiFlags.CheckTimeout(c.Ui, &code)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	if err := parseFlags(c.Ui, cmdFlags, args); err != nil {
+		return 1
+	}
+
+	args = cmdFlags.Args()
+	switch len(args) {
+	case 0:
+		c.Ui.Error("Error! Missing ID and FILE arguments")
+		return 1
+	case 1:
+		c.Ui.Error("Error! Missing FILE argument")
+		return 1
+	case 2:
+	default:
+		c.Ui.Error(fmt.Sprintf("Too many arguments (expected 2, got %d)", len(args)))
+		return 1
+	}
+	id, path := args[0], args[1]
+	if id == "" {
+		c.Ui.Error("Error! The ID argument can't be empty")
+		return 1
+	}
+
+	def, err := queryReadDefinition(path)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error! %s", err))
+		return 1
+	}
+	if def.ID != "" && def.ID != id {
+		c.Ui.Error(fmt.Sprintf("Error! Invalid definition: The ID %q doesn't match the query being updated", def.ID))
+		return 1
+	}
+	def.ID = id
+
+	// Create and test the HTTP client
+	client, err := apiFlags.Client()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+		return 1
+	}
+
+	if _, err := client.PreparedQuery().Update(def, apiFlags.WriteOptions()); err != nil {
+		// The agent doesn't give a status for a missing query here, so it's
+		// looked up to tell that apart from other errors.
+		if query, getErr := queryGet(client, id, apiFlags.QueryOptions()); getErr == nil && query == nil {
+			c.Ui.Error(fmt.Sprintf("Error! No prepared query with ID %q", id))
+			return exitNotFound
+		}
+		c.Ui.Error(apiFlags.errorMessage("Error updating prepared query", err,
+			queryDenial("update the prepared query", def.Name, "write")))
+		return exitCommError
+	}
+
+	apiFlags.report(c.Ui, fmt.Sprintf("Success! Updated prepared query: %s", id))
+	return 0
+}
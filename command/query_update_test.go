@@ -0,0 +1,107 @@
+package command
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+)
+
+func TestQueryUpdateCommand_implements(t *testing.T) {
+	var _ cli.Command = &QueryUpdateCommand{}
+}
+
+func TestQueryUpdateCommand_noTabs(t *testing.T) {
+	assertNoTabs(t, new(QueryUpdateCommand))
+}
+
+func TestQueryUpdateCommand_Run(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	dir, err := ioutil.TempDir("", "query")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	id := testQueryCreate(t, client, &api.PreparedQueryDefinition{
+		Name:    "web",
+		Service: api.ServiceQuery{Service: "web"},
+	})
+	path := testQueryDefinitionFile(t, dir, "web.json",
+		`{"Name": "web", "Service": {"Service": "web", "Tags": ["v2"]}}`)
+
+	ui := new(cli.MockUi)
+	c := &QueryUpdateCommand{Ui: ui}
+	if code := c.Run([]string{"-http-addr=" + srv.httpAddr, id, path}); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if output := ui.OutputWriter.String(); output != "Success! Updated prepared query: "+id+"\n" {
+		t.Fatalf("bad: %#v", output)
+	}
+
+	queries, _, err := client.PreparedQuery().Get(id, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(queries) != 1 || len(queries[0].Service.Tags) != 1 || queries[0].Service.Tags[0] != "v2" {
+		t.Fatalf("bad: %#v", queries)
+	}
+
+	ui = new(cli.MockUi)
+	c = &QueryUpdateCommand{Ui: ui}
+	missing := "8f246b77-f3e1-ff88-5b48-8ec93abf3e05"
+	if code := c.Run([]string{"-http-addr=" + srv.httpAddr, missing, path}); code != exitNotFound {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+}
+
+func TestQueryUpdateCommand_Validation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "query")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cases := map[string]struct {
+		args   []string
+		output string
+	}{
+		"no args": {
+			[]string{},
+			"Missing ID and FILE arguments",
+		},
+		"no file": {
+			[]string{"abc"},
+			"Missing FILE argument",
+		},
+		"too many": {
+			[]string{"abc", "a.json", "b.json"},
+			"Too many arguments (expected 2, got 3)",
+		},
+		"ID mismatch": {
+			[]string{"abc", testQueryDefinitionFile(t, dir, "id.json", `{"ID": "def", "Service": {"Service": "web"}}`)},
+			`The ID "def" doesn't match the query being updated`,
+		},
+		"no service": {
+			[]string{"abc", testQueryDefinitionFile(t, dir, "empty.json", `{}`)},
+			"Service.Service must give the name of the service to query",
+		},
+	}
+
+	for name, tc := range cases {
+		ui := new(cli.MockUi)
+		c := &QueryUpdateCommand{Ui: ui}
+		if code := c.Run(tc.args); code != 1 {
+			t.Fatalf("%s: bad: %d", name, code)
+		}
+		if output := ui.ErrorWriter.String(); !strings.Contains(output, tc.output) {
+			t.Fatalf("%s: expected %q to contain %q", name, output, tc.output)
+		}
+	}
+}
@@ -2,10 +2,15 @@ package command
 
 import (
 	"flag"
+	"fmt"
 	"os"
+	"strconv"
+	"strings"
 
 	consulapi "github.com/hashicorp/consul/api"
 	"github.com/hashicorp/consul/command/agent"
+	"github.com/hashicorp/go-cleanhttp"
+	"github.com/mitchellh/cli"
 )
 
 // RPCAddrFlag returns a pointer to a string that will be populated
@@ -19,6 +24,33 @@ func RPCAddrFlag(f *flag.FlagSet) *string {
 		"RPC address of the Consul agent")
 }
 
+// rpcAddrDeprecated returns the RPC address for a command which has moved to
+// the HTTP API but still accepts -rpc-addr, or "" if the HTTP API should be
+// used. The RPC address is used when -rpc-addr is given, or when only
+// CONSUL_RPC_ADDR is set, as before, and a warning says it's deprecated. It
+// returns false if both -rpc-addr and -http-addr were given.
+func rpcAddrDeprecated(ui cli.Ui, f *flag.FlagSet, rpcAddr string) (string, bool) {
+	set := make(map[string]bool)
+	f.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	switch {
+	case set["rpc-addr"] && set["http-addr"]:
+		ui.Error("Error! Cannot specify both -rpc-addr and -http-addr")
+		return "", false
+	case set["rpc-addr"]:
+	case set["http-addr"] || os.Getenv(consulapi.HTTPAddrEnvName) != "":
+		return "", true
+	default:
+		if rpcAddr = os.Getenv(agent.RPCAddrEnvName); rpcAddr == "" {
+			return "", true
+		}
+	}
+
+	ui.Warn(fmt.Sprintf("Warning! Using the agent's RPC address %s from -rpc-addr or %s, which is "+
+		"deprecated for this command. Use -http-addr or %s with the agent's HTTP address instead, "+
+		"such as 127.0.0.1:8500", rpcAddr, agent.RPCAddrEnvName, consulapi.HTTPAddrEnvName))
+	return rpcAddr, true
+}
+
 // RPCClient returns a new Consul RPC client with the given address.
 func RPCClient(addr string) (*agent.RPCClient, error) {
 	return agent.NewRPCClient(addr)
@@ -35,6 +67,75 @@ func HTTPAddrFlag(f *flag.FlagSet) *string {
 		"HTTP address of the Consul agent")
 }
 
+// HTTPTLSFlags holds the values of the flags used to talk to the HTTP API
+// of the Consul agent over TLS.
+type HTTPTLSFlags struct {
+	CAFile     string
+	CAPath     string
+	ClientCert string
+	ClientKey  string
+	ServerName string
+	Insecure   bool
+}
+
+// HTTPTLSFlag returns a pointer to the TLS options that will be populated
+// when the given flagset is parsed. Each flag defaults to the value of its
+// environment variable, so flags given on the command line take precedence.
+func HTTPTLSFlag(f *flag.FlagSet) *HTTPTLSFlags {
+	t := &HTTPTLSFlags{}
+	f.StringVar(&t.CAFile, "ca-file", os.Getenv(consulapi.HTTPCAFileEnvName),
+		"CA file to use for TLS when talking to the Consul agent")
+	f.StringVar(&t.CAPath, "ca-path", os.Getenv(consulapi.HTTPCAPathEnvName),
+		"Directory of CA certs to use for TLS when talking to the Consul agent")
+	f.StringVar(&t.ClientCert, "client-cert", os.Getenv(consulapi.HTTPClientCertEnvName),
+		"Client cert file to use for TLS when talking to the Consul agent")
+	f.StringVar(&t.ClientKey, "client-key", os.Getenv(consulapi.HTTPClientKeyEnvName),
+		"Client key file to use for TLS when talking to the Consul agent")
+	f.StringVar(&t.ServerName, "tls-server-name", os.Getenv(consulapi.HTTPTLSServerNameEnvName),
+		"Server name to verify the Consul agent's certificate against")
+
+	insecure := false
+	if verify, err := strconv.ParseBool(os.Getenv(consulapi.HTTPSSLVerifyEnvName)); err == nil {
+		insecure = !verify
+	}
+	f.BoolVar(&t.Insecure, "insecure", insecure,
+		"Disable verification of the Consul agent's certificate")
+	return t
+}
+
+// Configure sets up the transport of the given client configuration with
+// the TLS options. This replaces any TLS settings made from the environment
+// by consulapi.DefaultConfig, which the flags already default to. TLS is
+// used if CONSUL_HTTP_SSL is set, or the address starts with https://.
+func (t *HTTPTLSFlags) Configure(conf *consulapi.Config) error {
+	// An https:// address turns on TLS the same as CONSUL_HTTP_SSL.
+	if strings.HasPrefix(conf.Address, "https://") {
+		conf.Scheme = "https"
+		conf.Address = strings.TrimPrefix(conf.Address, "https://")
+	}
+
+	if (t.ClientCert == "") != (t.ClientKey == "") {
+		return fmt.Errorf("-client-cert and -client-key must be given together")
+	}
+
+	tlsConf, err := consulapi.SetupTLSConfig(&consulapi.TLSConfig{
+		Address:            t.ServerName,
+		CAFile:             t.CAFile,
+		CAPath:             t.CAPath,
+		CertFile:           t.ClientCert,
+		KeyFile:            t.ClientKey,
+		InsecureSkipVerify: t.Insecure,
+	})
+	if err != nil {
+		return err
+	}
+
+	transport := cleanhttp.DefaultPooledTransport()
+	transport.TLSClientConfig = tlsConf
+	conf.HttpClient.Transport = transport
+	return nil
+}
+
 // HTTPClient returns a new Consul HTTP client with the given address.
 func HTTPClient(addr string) (*consulapi.Client, error) {
 	return HTTPClientConfig(func(c *consulapi.Config) {
@@ -1,12 +1,26 @@
 package command
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
 	"flag"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	consulapi "github.com/hashicorp/consul/api"
 	"github.com/hashicorp/consul/command/agent"
+	"github.com/mitchellh/cli"
 )
 
 const (
@@ -88,3 +102,217 @@ func TestAddrFlag_precedence(t *testing.T) {
 		}
 	}
 }
+
+// testCert is a key pair generated for testing TLS.
+type testCert struct {
+	cert *x509.Certificate
+	key  *rsa.PrivateKey
+	der  []byte
+}
+
+// writeTestCert generates a certificate signed by the given parent, or self
+// signed if it's nil, and writes its PEM encoded cert and key into dir.
+func writeTestCert(t *testing.T, dir, name string, parent *testCert, dnsNames ...string) *testCert {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     dnsNames,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err := ioutil.WriteFile(filepath.Join(dir, name+".crt"), certPEM, 0600); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, name+".key"), keyPEM, 0600); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return &testCert{cert, key, der}
+}
+
+func TestHTTPTLSFlag(t *testing.T) {
+	dir, err := ioutil.TempDir("", "consul")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The CA path only holds the CA, and the other CA isn't trusted by the
+	// server or used to sign its cert.
+	capath := filepath.Join(dir, "capath")
+	if err := os.Mkdir(capath, 0700); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	ca := writeTestCert(t, capath, "ca", nil)
+	writeTestCert(t, dir, "other-ca", nil)
+	server := writeTestCert(t, dir, "server", ca, "consul.test")
+	writeTestCert(t, dir, "client", ca)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"Key":"foo","Value":"YmFy"}]`))
+	}))
+	srv.TLS = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{server.der}, PrivateKey: server.key}},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	}
+	srv.StartTLS()
+	defer srv.Close()
+	addr := strings.TrimPrefix(srv.URL, "https://")
+
+	file := func(name string) string {
+		return filepath.Join(dir, name)
+	}
+	valid := []string{
+		"-ca-file=" + file("capath/ca.crt"),
+		"-client-cert=" + file("client.crt"),
+		"-client-key=" + file("client.key"),
+		"-tls-server-name=consul.test",
+	}
+
+	cases := map[string]struct {
+		env  map[string]string
+		args []string
+		err  string
+		code int
+	}{
+		"flags": {
+			nil,
+			append([]string{"-http-addr=https://" + addr}, valid...),
+			"",
+			0,
+		},
+		"CONSUL_HTTP_SSL": {
+			map[string]string{"CONSUL_HTTP_SSL": "true"},
+			append([]string{"-http-addr=" + addr}, valid...),
+			"",
+			0,
+		},
+		"env": {
+			map[string]string{
+				"CONSUL_HTTP_ADDR":       "https://" + addr,
+				"CONSUL_CAPATH":          capath,
+				"CONSUL_CLIENT_CERT":     file("client.crt"),
+				"CONSUL_CLIENT_KEY":      file("client.key"),
+				"CONSUL_TLS_SERVER_NAME": "consul.test",
+			},
+			nil,
+			"",
+			0,
+		},
+		"flags override env": {
+			map[string]string{
+				"CONSUL_CACERT":          file("other-ca.crt"),
+				"CONSUL_TLS_SERVER_NAME": "wrong.test",
+				"CONSUL_HTTP_SSL_VERIFY": "false",
+			},
+			append([]string{"-http-addr=https://" + addr, "-insecure=false"}, valid...),
+			"",
+			0,
+		},
+		"untrusted CA": {
+			nil,
+			[]string{
+				"-http-addr=https://" + addr,
+				"-ca-file=" + file("other-ca.crt"),
+				"-client-cert=" + file("client.crt"),
+				"-client-key=" + file("client.key"),
+				"-tls-server-name=consul.test",
+			},
+			"certificate signed by unknown authority",
+			exitCommError,
+		},
+		"wrong server name": {
+			nil,
+			append(append([]string{"-http-addr=https://" + addr}, valid...), "-tls-server-name=wrong.test"),
+			"certificate is valid for consul.test",
+			exitCommError,
+		},
+		"insecure": {
+			nil,
+			[]string{
+				"-http-addr=https://" + addr,
+				"-client-cert=" + file("client.crt"),
+				"-client-key=" + file("client.key"),
+				"-insecure",
+			},
+			"",
+			0,
+		},
+		"no client cert": {
+			nil,
+			[]string{
+				"-http-addr=https://" + addr,
+				"-ca-file=" + file("capath/ca.crt"),
+				"-tls-server-name=consul.test",
+			},
+			"Error querying Consul agent",
+			exitCommError,
+		},
+		"client cert without key": {
+			nil,
+			[]string{
+				"-http-addr=https://" + addr,
+				"-client-cert=" + file("client.crt"),
+			},
+			"-client-cert and -client-key must be given together",
+			1,
+		},
+	}
+
+	for name, tc := range cases {
+		os.Clearenv()
+		for k, v := range tc.env {
+			os.Setenv(k, v)
+		}
+
+		ui := new(cli.MockUi)
+		c := &KVGetCommand{Ui: ui}
+		code := c.Run(append(tc.args, "foo"))
+
+		if tc.err == "" {
+			if code != 0 {
+				t.Fatalf("%s: bad: %d. %#v", name, code, ui.ErrorWriter.String())
+			}
+			if output := ui.OutputWriter.String(); output != "bar\n" {
+				t.Fatalf("%s: bad: %q", name, output)
+			}
+			continue
+		}
+		if code != tc.code {
+			t.Fatalf("%s: bad: %d", name, code)
+		}
+		if output := ui.ErrorWriter.String(); !strings.Contains(output, tc.err) {
+			t.Fatalf("%s: bad: %q", name, output)
+		}
+	}
+	os.Clearenv()
+}
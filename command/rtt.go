@@ -3,11 +3,13 @@ package command
 import (
 	"flag"
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/hashicorp/consul/api"
 	"github.com/hashicorp/serf/coordinate"
 	"github.com/mitchellh/cli"
+	"github.com/ryanuber/columnize"
 )
 
 // RTTCommand is a Command implementation that allows users to query the
@@ -19,6 +21,7 @@ type RTTCommand struct {
 func (c *RTTCommand) Help() string {
 	helpText := `
 Usage: consul rtt [options] node1 [node2]
+       consul rtt -list [options] [node]
 
   Estimates the round trip time between two nodes using Consul's network
   coordinate model of the cluster.
@@ -36,8 +39,15 @@ Usage: consul rtt [options] node1 [node2]
   because they are maintained by independent Serf gossip pools, so they are
   not compatible.
 
+  With -list, every node with a coordinate is listed by its estimated round
+  trip time from the given node, or the agent's node, nearest first:
+
+      $ consul rtt -list -wan myserver.dc1
+
 Options:
 
+  -list                      List every node by its estimated round trip time
+                             from the given node, nearest first.
   -wan                       Use WAN coordinates instead of LAN coordinates.
   -http-addr=127.0.0.1:8500  HTTP address of the Consul agent.
 `
@@ -45,25 +55,40 @@ Options:
 }
 
 func (c *RTTCommand) Run(args []string) int {
-	var wan bool
+	var list, wan bool
 
 	cmdFlags := flag.NewFlagSet("rtt", flag.ContinueOnError)
 	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
 
+	cmdFlags.BoolVar(&list, "list", false, "list")
 	cmdFlags.BoolVar(&wan, "wan", false, "wan")
 	httpAddr := HTTPAddrFlag(cmdFlags)
 	if err := cmdFlags.Parse(args); err != nil {
 		return 1
 	}
 
-	// They must provide at least one node.
+	// They must provide at least one node, unless listing.
 	nodes := cmdFlags.Args()
-	if len(nodes) < 1 || len(nodes) > 2 {
+	if list && len(nodes) > 1 {
+		c.Ui.Error("At most one node name can be specified with -list")
+		c.Ui.Error("")
+		c.Ui.Error(c.Help())
+		return 1
+	}
+	if !list && (len(nodes) < 1 || len(nodes) > 2) {
 		c.Ui.Error("One or two node names must be specified")
 		c.Ui.Error("")
 		c.Ui.Error(c.Help())
 		return 1
 	}
+	if wan {
+		for _, node := range nodes {
+			if _, _, ok := splitWANNode(node); !ok {
+				c.Ui.Error("Node names must be specified as <node name>.<datacenter> with -wan")
+				return 1
+			}
+		}
+	}
 
 	// Create and test the HTTP client.
 	conf := api.DefaultConfig()
@@ -73,112 +98,173 @@ func (c *RTTCommand) Run(args []string) int {
 		c.Ui.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
 		return 1
 	}
-	coordClient := client.Coordinate()
 
-	var source string
-	var coord1, coord2 *coordinate.Coordinate
+	source := "LAN"
 	if wan {
 		source = "WAN"
+	}
 
-		// Default the second node to the agent if none was given.
-		if len(nodes) < 2 {
-			agent := client.Agent()
-			self, err := agent.Self()
-			if err != nil {
-				c.Ui.Error(fmt.Sprintf("Unable to look up agent info: %s", err))
-				return 1
-			}
-
-			node, dc := self["Config"]["NodeName"], self["Config"]["Datacenter"]
-			nodes = append(nodes, fmt.Sprintf("%s.%s", node, dc))
+	// Default the last node to the agent if none was given.
+	if len(nodes) < 2 && !(list && len(nodes) == 1) {
+		self, err := client.Agent().Self()
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Unable to look up agent info: %s", err))
+			return 1
 		}
+		node := fmt.Sprintf("%v", self["Config"]["NodeName"])
+		if wan {
+			node = fmt.Sprintf("%s.%v", node, self["Config"]["Datacenter"])
+		}
+		nodes = append(nodes, node)
+	}
 
-		// Parse the input nodes.
-		parts1 := strings.Split(nodes[0], ".")
-		parts2 := strings.Split(nodes[1], ".")
-		if len(parts1) != 2 || len(parts2) != 2 {
-			c.Ui.Error("Node names must be specified as <node name>.<datacenter> with -wan")
+	coords, err := c.coordinates(client, wan)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error getting coordinates: %s", err))
+		return 1
+	}
+
+	// Make sure we found the coordinates of the nodes given.
+	for _, node := range nodes {
+		if _, ok := coords[node]; !ok {
+			c.Ui.Error(c.missingCoordinate(client, node, wan))
 			return 1
 		}
-		node1, dc1 := parts1[0], parts1[1]
-		node2, dc2 := parts2[0], parts2[1]
+	}
 
-		// Pull all the WAN coordinates.
-		dcs, err := coordClient.Datacenters()
+	if list {
+		return c.list(coords, nodes[0], source)
+	}
+
+	// Report the round trip time.
+	dist := formatRTT(coords[nodes[0]].DistanceTo(coords[nodes[1]]).Seconds())
+	c.Ui.Output(fmt.Sprintf("Estimated %s <-> %s rtt: %s (using %s coordinates)", nodes[0], nodes[1], dist, source))
+	return 0
+}
+
+// coordinates returns the coordinate of each node which has one. WAN
+// coordinates are keyed by "<node name>.<datacenter>".
+func (c *RTTCommand) coordinates(client *api.Client, wan bool) (map[string]*coordinate.Coordinate, error) {
+	coords := make(map[string]*coordinate.Coordinate)
+	if wan {
+		dcs, err := client.Coordinate().Datacenters()
 		if err != nil {
-			c.Ui.Error(fmt.Sprintf("Error getting coordinates: %s", err))
-			return 1
+			return nil, err
 		}
-
-		// See if the requested nodes are in there.
 		for _, dc := range dcs {
 			for _, entry := range dc.Coordinates {
-				if dc.Datacenter == dc1 && entry.Node == node1 {
-					coord1 = entry.Coord
-				}
-				if dc.Datacenter == dc2 && entry.Node == node2 {
-					coord2 = entry.Coord
-				}
-
-				if coord1 != nil && coord2 != nil {
-					goto SHOW_RTT
-				}
+				coords[fmt.Sprintf("%s.%s", entry.Node, dc.Datacenter)] = entry.Coord
 			}
 		}
-	} else {
-		source = "LAN"
-
-		// Default the second node to the agent if none was given.
-		if len(nodes) < 2 {
-			agent := client.Agent()
-			node, err := agent.NodeName()
-			if err != nil {
-				c.Ui.Error(fmt.Sprintf("Unable to look up agent info: %s", err))
-				return 1
-			}
-			nodes = append(nodes, node)
-		}
+		return coords, nil
+	}
 
-		// Pull all the LAN coordinates.
-		entries, _, err := coordClient.Nodes(nil)
+	entries, _, err := client.Coordinate().Nodes(nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		coords[entry.Node] = entry.Coord
+	}
+	return coords, nil
+}
+
+// missingCoordinate explains why a node has no coordinate, telling a node
+// which doesn't exist apart from one which hasn't got a coordinate yet.
+func (c *RTTCommand) missingCoordinate(client *api.Client, node string, wan bool) string {
+	name, dc := node, ""
+	if wan {
+		name, dc, _ = splitWANNode(node)
+		dcs, err := client.Catalog().Datacenters()
 		if err != nil {
-			c.Ui.Error(fmt.Sprintf("Error getting coordinates: %s", err))
-			return 1
+			return fmt.Sprintf("Could not find a coordinate for node %q", node)
 		}
+		found := false
+		for _, d := range dcs {
+			found = found || d == dc
+		}
+		if !found {
+			return fmt.Sprintf("Datacenter %q not found", dc)
+		}
+	}
 
-		// See if the requested nodes are in there.
-		for _, entry := range entries {
-			if entry.Node == nodes[0] {
-				coord1 = entry.Coord
-			}
-			if entry.Node == nodes[1] {
-				coord2 = entry.Coord
-			}
-
-			if coord1 != nil && coord2 != nil {
-				goto SHOW_RTT
-			}
+	nodes, _, err := client.Catalog().Nodes(&api.QueryOptions{Datacenter: dc})
+	if err != nil {
+		return fmt.Sprintf("Could not find a coordinate for node %q", node)
+	}
+	for _, n := range nodes {
+		if n.Node != name {
+			continue
+		}
+		if wan {
+			return fmt.Sprintf("Node %q has no WAN coordinate. Only servers have WAN coordinates, "+
+				"which are set a few seconds after they join", node)
 		}
+		return fmt.Sprintf("Node %q has no coordinate yet. Coordinates are set a few seconds "+
+			"after a node joins, so try again shortly", node)
+	}
+	if wan {
+		return fmt.Sprintf("Node %q not found in datacenter %q", name, dc)
 	}
+	return fmt.Sprintf("Node %q not found", node)
+}
 
-	// Make sure we found both coordinates.
-	if coord1 == nil {
-		c.Ui.Error(fmt.Sprintf("Could not find a coordinate for node %q", nodes[0]))
-		return 1
+// list prints every other node by its estimated round trip time from the
+// given one, nearest first.
+func (c *RTTCommand) list(coords map[string]*coordinate.Coordinate, from, source string) int {
+	var entries rttEntries
+	for node, coord := range coords {
+		if node != from {
+			entries = append(entries, rttEntry{node, coords[from].DistanceTo(coord).Seconds()})
+		}
 	}
-	if coord2 == nil {
-		c.Ui.Error(fmt.Sprintf("Could not find a coordinate for node %q", nodes[1]))
+	if len(entries) == 0 {
+		c.Ui.Error(fmt.Sprintf("No other nodes have %s coordinates", source))
 		return 1
 	}
+	sort.Sort(entries)
 
-SHOW_RTT:
-
-	// Report the round trip time.
-	dist := fmt.Sprintf("%.3f ms", coord1.DistanceTo(coord2).Seconds()*1000.0)
-	c.Ui.Output(fmt.Sprintf("Estimated %s <-> %s rtt: %s (using %s coordinates)", nodes[0], nodes[1], dist, source))
+	result := []string{"Node|RTT"}
+	for _, entry := range entries {
+		result = append(result, fmt.Sprintf("%s|%s", entry.node, formatRTT(entry.rtt)))
+	}
+	c.Ui.Output(fmt.Sprintf("Estimated rtt from %s (using %s coordinates):", from, source))
+	c.Ui.Output(columnize.SimpleFormat(result))
 	return 0
 }
 
 func (c *RTTCommand) Synopsis() string {
 	return "Estimates network round trip time between nodes"
 }
+
+// splitWANNode splits a WAN node name into the node and datacenter.
+func splitWANNode(node string) (string, string, bool) {
+	parts := strings.Split(node, ".")
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// formatRTT formats a round trip time given in seconds.
+func formatRTT(seconds float64) string {
+	return fmt.Sprintf("%.3f ms", seconds*1000.0)
+}
+
+// rttEntry is a node and its estimated round trip time, in seconds.
+type rttEntry struct {
+	node string
+	rtt  float64
+}
+
+// rttEntries sorts nodes by round trip time, and then by name.
+type rttEntries []rttEntry
+
+func (s rttEntries) Len() int      { return len(s) }
+func (s rttEntries) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s rttEntries) Less(i, j int) bool {
+	if s[i].rtt != s[j].rtt {
+		return s[i].rtt < s[j].rtt
+	}
+	return s[i].node < s[j].node
+}
@@ -40,6 +40,10 @@ func TestRTTCommand_Run_BadArgs(t *testing.T) {
 	if code := c.Run([]string{"-wan", "node1", "node2.dc1"}); code != 1 {
 		t.Fatalf("expected return code 1, got %d", code)
 	}
+
+	if code := c.Run([]string{"-list", "node1", "node2"}); code != 1 {
+		t.Fatalf("expected return code 1, got %d", code)
+	}
 }
 
 func TestRTTCommand_Run_LAN(t *testing.T) {
@@ -149,6 +153,57 @@ func TestRTTCommand_Run_LAN(t *testing.T) {
 		if code != 1 {
 			t.Fatalf("bad: %d: %#v", code, ui.ErrorWriter.String())
 		}
+		if !strings.Contains(ui.ErrorWriter.String(), `Node "nope" not found`) {
+			t.Fatalf("bad: %#v", ui.ErrorWriter.String())
+		}
+	}
+
+	// Try a node which hasn't got a coordinate.
+	{
+		req := structs.RegisterRequest{
+			Datacenter: a.config.Datacenter,
+			Node:       "cats",
+			Address:    "127.0.0.3",
+		}
+		var reply struct{}
+		if err := a.agent.RPC("Catalog.Register", &req, &reply); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		ui := new(cli.MockUi)
+		c := &RTTCommand{Ui: ui}
+		args := []string{
+			"-http-addr=" + a.httpAddr,
+			"cats",
+		}
+		code := c.Run(args)
+		if code != 1 {
+			t.Fatalf("bad: %d: %#v", code, ui.ErrorWriter.String())
+		}
+		if !strings.Contains(ui.ErrorWriter.String(), `Node "cats" has no coordinate yet`) {
+			t.Fatalf("bad: %#v", ui.ErrorWriter.String())
+		}
+	}
+
+	// List the nodes by their distance from the agent's node.
+	{
+		ui := new(cli.MockUi)
+		c := &RTTCommand{Ui: ui}
+		args := []string{
+			"-http-addr=" + a.httpAddr,
+			"-list",
+		}
+		code := c.Run(args)
+		if code != 0 {
+			t.Fatalf("bad: %d: %#v", code, ui.ErrorWriter.String())
+		}
+
+		output := ui.OutputWriter.String()
+		expected := fmt.Sprintf("Estimated rtt from %s (using LAN coordinates):\nNode  RTT\ndogs  %s\n",
+			a.config.NodeName, dist_str)
+		if output != expected {
+			t.Fatalf("bad: %#v", output)
+		}
 	}
 }
 
@@ -215,5 +270,27 @@ func TestRTTCommand_Run_WAN(t *testing.T) {
 		if code != 1 {
 			t.Fatalf("bad: %d: %#v", code, ui.ErrorWriter.String())
 		}
+		if !strings.Contains(ui.ErrorWriter.String(), `Datacenter "nope" not found`) {
+			t.Fatalf("bad: %#v", ui.ErrorWriter.String())
+		}
+	}
+
+	// Try an unknown node in a known datacenter.
+	{
+		ui := new(cli.MockUi)
+		c := &RTTCommand{Ui: ui}
+		args := []string{
+			"-wan",
+			"-http-addr=" + a.httpAddr,
+			"nope." + a.config.Datacenter,
+		}
+		code := c.Run(args)
+		if code != 1 {
+			t.Fatalf("bad: %d: %#v", code, ui.ErrorWriter.String())
+		}
+		expected := fmt.Sprintf(`Node "nope" not found in datacenter %q`, a.config.Datacenter)
+		if !strings.Contains(ui.ErrorWriter.String(), expected) {
+			t.Fatalf("bad: %#v", ui.ErrorWriter.String())
+		}
 	}
 }
@@ -0,0 +1,133 @@
+package command
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+)
+
+// SessionCommand is a Command implementation that just shows help for
+// the subcommands nested below it.
+type SessionCommand struct {
+	Ui cli.Ui
+}
+
+func (c *SessionCommand) Run(args []string) int {
+	return cli.RunResultHelp
+}
+
+func (c *SessionCommand) Help() string {
+	helpText := `
+Usage: consul session <subcommand> [options] [args]
+
+  This command has subcommands for managing sessions, which back the locks
+  held on keys in the KV store. A lock is released once its session is
+  destroyed, or invalidated by a failing health check or an expired TTL.
+
+  List the sessions, with their nodes, TTLs, and checks:
+
+      $ consul session list
+
+  Create a session with a TTL, printing its ID:
+
+      $ consul session create -name=deploy -ttl=30s
+
+  Renew a session before its TTL runs out:
+
+      $ consul session renew adf4238a-882b-9ddc-4a9d-5b6758e4159e
+
+  Destroy a session which is holding a stuck lock:
+
+      $ consul session destroy adf4238a-882b-9ddc-4a9d-5b6758e4159e
+
+  For more examples, ask for subcommand help or view the documentation.
+
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *SessionCommand) Synopsis() string {
+	return "Manages the sessions which back locks"
+}
+
+// sessionIDArg returns the session ID from the arguments, reporting an
+// error if there isn't exactly one.
+func sessionIDArg(ui cli.Ui, args []string) (string, bool) {
+	switch len(args) {
+	case 0:
+		ui.Error("Error! Missing ID argument")
+		return "", false
+	case 1:
+	default:
+		ui.Error(fmt.Sprintf("Too many arguments (expected 1, got %d)", len(args)))
+		return "", false
+	}
+	if args[0] == "" {
+		ui.Error("Error! The session ID can't be empty")
+		return "", false
+	}
+	return args[0], true
+}
+
+// formatSessionTTL formats the TTL of a session for a table, giving "-" for
+// a session which doesn't have one.
+func formatSessionTTL(ttl string) string {
+	if ttl == "" {
+		return "-"
+	}
+	return ttl
+}
+
+// formatSessionChecks formats the health checks of a session for a table,
+// giving "-" for a session which doesn't have any.
+func formatSessionChecks(checks []string) string {
+	if len(checks) == 0 {
+		return "-"
+	}
+	return strings.Join(checks, ",")
+}
+
+// sessionOutput is a session printed with -format=json. The lock delay is
+// given as a duration string rather than in nanoseconds.
+type sessionOutput struct {
+	ID          string
+	Name        string
+	Node        string
+	Checks      []string
+	LockDelay   string
+	Behavior    string
+	TTL         string
+	CreateIndex uint64
+}
+
+// newSessionOutput returns the JSON form of a session.
+func newSessionOutput(session *api.SessionEntry) *sessionOutput {
+	checks := session.Checks
+	if checks == nil {
+		checks = []string{}
+	}
+	return &sessionOutput{
+		ID:          session.ID,
+		Name:        session.Name,
+		Node:        session.Node,
+		Checks:      checks,
+		LockDelay:   session.LockDelay.String(),
+		Behavior:    session.Behavior,
+		TTL:         session.TTL,
+		CreateIndex: session.CreateIndex,
+	}
+}
+
+// sessionsByNode sorts sessions by node, and then by ID.
+type sessionsByNode []*api.SessionEntry
+
+func (s sessionsByNode) Len() int      { return len(s) }
+func (s sessionsByNode) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s sessionsByNode) Less(i, j int) bool {
+	if s[i].Node != s[j].Node {
+		return s[i].Node < s[j].Node
+	}
+	return s[i].ID < s[j].ID
+}
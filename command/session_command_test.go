@@ -0,0 +1,26 @@
+package command
+
+import (
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+)
+
+func TestSessionCommand_implements(t *testing.T) {
+	var _ cli.Command = &SessionCommand{}
+}
+
+func TestSessionCommand_noTabs(t *testing.T) {
+	assertNoTabs(t, new(SessionCommand))
+}
+
+// testSessionCreate creates a session for the session tests, returning its
+// ID.
+func testSessionCreate(t *testing.T, client *api.Client, entry *api.SessionEntry) string {
+	id, _, err := client.Session().Create(entry, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return id
+}
@@ -0,0 +1,151 @@
+package command
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+)
+
+const (
+	// sessionDefaultLockDelay is the lock delay the servers give a session
+	// by default.
+	sessionDefaultLockDelay = 15 * time.Second
+
+	// sessionMaxLockDelay is the longest lock delay the servers allow.
+	sessionMaxLockDelay = 60 * time.Second
+)
+
+// SessionCreateCommand is a Command implementation that is used to create a
+// session.
+type SessionCreateCommand struct {
+	Ui cli.Ui
+}
+
+func (c *SessionCreateCommand) Synopsis() string {
+	return "Creates a new session"
+}
+
+func (c *SessionCreateCommand) Help() string {
+	helpText := `
+Usage: consul session create [options]
+
+  Creates a session on the node of the agent, and prints its ID so that it
+  can be captured by a script. The session is tied to the health of the node,
+  and is invalidated if the node fails.
+
+      $ consul session create -name=deploy -ttl=30s
+
+  A session with a TTL must be renewed before the TTL runs out, or it's
+  invalidated. See "consul session renew".
+
+  For a full list of options and examples, please see the Consul documentation.
+
+` + apiOptsText + `
+
+Session Create Options:
+
+  -behavior=<string>      What happens to the locks held by the session when
+                          it's invalidated, either "release" to release them
+                          or "delete" to delete the keys. The default value
+                          is "release".
+
+  -lock-delay=<duration>  How long the keys locked by the session can't be
+                          locked again after it's invalidated, up to 60s.
+                          The default value is 15s.
+
+  -name=<string>          Name of the session, which is shown when listing
+                          the sessions.
+
+  -ttl=<duration>         TTL of the session, which must be renewed before it
+                          runs out. The servers limit the TTL to between 10s
+                          and 24h by default. The default is no TTL.
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *SessionCreateCommand) Run(args []string) (code int) {
+	cmdFlags, apiFlags := NewAPIFlagSet("create", c.Ui)
+	defer apiFlags.CheckTimeout(c.Ui, &code)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	behavior := cmdFlags.String("behavior", api.SessionBehaviorRelease, "")
+	lockDelay := cmdFlags.Duration("lock-delay", sessionDefaultLockDelay, "")
+	name := cmdFlags.String("name", "", "")
+	ttl := cmdFlags.Duration("ttl", 0, "")
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+
+	if args = cmdFlags.Args(); len(args) > 0 {
+		c.Ui.Error(fmt.Sprintf("Too many arguments (expected 0, got %d)", len(args)))
+		return 1
+	}
+	if *behavior != api.SessionBehaviorRelease && *behavior != api.SessionBehaviorDelete {
+		c.Ui.Error(fmt.Sprintf("Error! Unsupported behavior %q (expected release or delete)", *behavior))
+		return 1
+	}
+	if *lockDelay < 0 || *lockDelay > sessionMaxLockDelay {
+		c.Ui.Error(fmt.Sprintf("Error! -lock-delay must be between 0s and %s", sessionMaxLockDelay))
+		return 1
+	}
+	if *ttl < 0 {
+		c.Ui.Error("Error! -ttl can't be negative")
+		return 1
+	}
+
+	// Create and test the HTTP client
+	client, err := apiFlags.Client()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+		return 1
+	}
+
+	entry := &api.SessionEntry{
+		Name:      *name,
+		LockDelay: *lockDelay,
+		Behavior:  *behavior,
+	}
+	if *ttl > 0 {
+		entry.TTL = ttl.String()
+	}
+
+	var id string
+	if *lockDelay == 0 {
+		// Create leaves out a lock delay of zero, which would get the
+		// default instead, so the request is made directly.
+		id, err = createSessionNoLockDelay(client, entry, apiFlags.WriteOptions())
+	} else {
+		id, _, err = client.Session().Create(entry, apiFlags.WriteOptions())
+	}
+	if err != nil {
+		c.Ui.Error(apiFlags.errorMessage("Error creating session", err,
+			sessionDenial("create a session", "", "write")))
+		return exitCommError
+	}
+
+	c.Ui.Output(id)
+	return 0
+}
+
+// createSessionNoLockDelay creates a session the same way as the API client,
+// but with a lock delay of zero.
+func createSessionNoLockDelay(client *api.Client, entry *api.SessionEntry, q *api.WriteOptions) (string, error) {
+	body := map[string]interface{}{
+		"LockDelay": "0ms",
+		"Behavior":  entry.Behavior,
+	}
+	if entry.Name != "" {
+		body["Name"] = entry.Name
+	}
+	if entry.TTL != "" {
+		body["TTL"] = entry.TTL
+	}
+
+	var out struct{ ID string }
+	if _, err := client.Raw().Write("/v1/session/create", body, &out, q); err != nil {
+		return "", err
+	}
+	return out.ID, nil
+}
@@ -0,0 +1,114 @@
+package command
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+)
+
+func TestSessionCreateCommand_implements(t *testing.T) {
+	var _ cli.Command = &SessionCreateCommand{}
+}
+
+func TestSessionCreateCommand_noTabs(t *testing.T) {
+	assertNoTabs(t, new(SessionCreateCommand))
+}
+
+func TestSessionCreateCommand_Validation(t *testing.T) {
+	cases := map[string]struct {
+		args   []string
+		output string
+	}{
+		"too many args": {
+			[]string{"foo"},
+			"Too many arguments",
+		},
+		"bad behavior": {
+			[]string{"-behavior=keep"},
+			"Unsupported behavior",
+		},
+		"lock delay too long": {
+			[]string{"-lock-delay=61s"},
+			"-lock-delay must be between 0s and 1m0s",
+		},
+		"negative lock delay": {
+			[]string{"-lock-delay=-1s"},
+			"-lock-delay must be between",
+		},
+		"negative ttl": {
+			[]string{"-ttl=-10s"},
+			"-ttl can't be negative",
+		},
+	}
+
+	for name, tc := range cases {
+		ui := new(cli.MockUi)
+		c := &SessionCreateCommand{Ui: ui}
+
+		if code := c.Run(tc.args); code != 1 {
+			t.Fatalf("%s: bad: %d", name, code)
+		}
+		if output := ui.ErrorWriter.String(); !strings.Contains(output, tc.output) {
+			t.Fatalf("%s: expected %q to contain %q", name, output, tc.output)
+		}
+	}
+}
+
+func TestSessionCreateCommand_Run(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	cases := []struct {
+		args     []string
+		expected api.SessionEntry
+	}{
+		{
+			[]string{},
+			api.SessionEntry{LockDelay: 15 * time.Second, Behavior: "release"},
+		},
+		{
+			[]string{"-name=deploy", "-ttl=30s", "-behavior=delete", "-lock-delay=5s"},
+			api.SessionEntry{Name: "deploy", TTL: "30s", LockDelay: 5 * time.Second, Behavior: "delete"},
+		},
+		{
+			[]string{"-lock-delay=0", "-ttl=1m"},
+			api.SessionEntry{TTL: "1m0s", Behavior: "release"},
+		},
+	}
+
+	for _, tc := range cases {
+		ui := new(cli.MockUi)
+		c := &SessionCreateCommand{Ui: ui}
+
+		args := append([]string{"-http-addr=" + srv.httpAddr}, tc.args...)
+		if code := c.Run(args); code != 0 {
+			t.Fatalf("%v: bad: %d. %#v", tc.args, code, ui.ErrorWriter.String())
+		}
+
+		// Only the ID is printed.
+		id := strings.TrimSpace(ui.OutputWriter.String())
+		session, _, err := client.Session().Info(id, nil)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if session == nil || session.Name != tc.expected.Name || session.TTL != tc.expected.TTL ||
+			session.LockDelay != tc.expected.LockDelay || session.Behavior != tc.expected.Behavior ||
+			session.Node != srv.config.NodeName {
+			t.Fatalf("%v: bad: %#v", tc.args, session)
+		}
+	}
+
+	// The servers check the range of the TTL.
+	ui := new(cli.MockUi)
+	c := &SessionCreateCommand{Ui: ui}
+	if code := c.Run([]string{"-http-addr=" + srv.httpAddr, "-ttl=1s"}); code != exitCommError {
+		t.Fatalf("bad: %d", code)
+	}
+	if output := ui.ErrorWriter.String(); !strings.Contains(output, "Invalid Session TTL") {
+		t.Fatalf("bad: %#v", output)
+	}
+}
@@ -0,0 +1,170 @@
+package command
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mattn/go-isatty"
+	"github.com/mitchellh/cli"
+)
+
+// SessionDestroyCommand is a Command implementation that is used to destroy a
+// session, releasing its locks.
+type SessionDestroyCommand struct {
+	Ui cli.Ui
+
+	// testStdinTerminal overrides the check for whether stdin is a
+	// terminal, for testing.
+	testStdinTerminal *bool
+}
+
+func (c *SessionDestroyCommand) Synopsis() string {
+	return "Destroys a session, releasing its locks"
+}
+
+func (c *SessionDestroyCommand) Help() string {
+	helpText := `
+Usage: consul session destroy [options] ID
+
+  Destroys the session with the given ID. The locks held by the session are
+  released, or the keys are deleted if the session has the "delete" behavior.
+  The keys are listed before asking for confirmation, which can be skipped
+  with the -force option. When not running interactively, -force is required.
+  If there's no such session, the command exits with status 2.
+
+      $ consul session destroy adf4238a-882b-9ddc-4a9d-5b6758e4159e
+
+  The keys are found by listing the whole KV store, so only the keys which
+  the token can read are listed.
+
+  For a full list of options and examples, please see the Consul documentation.
+
+` + apiOptsText + `
+
+Session Destroy Options:
+
+  -force                  Destroy the session without asking for
+                          confirmation. This is required when not running
+                          interactively. The default value is false.
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *SessionDestroyCommand) Run(args []string) (code int) {
+	cmdFlags, apiFlags := NewAPIFlagSet("destroy", c.Ui)
+	defer apiFlags.CheckTimeout(c.Ui, &code)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	force := cmdFlags.Bool("force", false, "")
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+
+	id, ok := sessionIDArg(c.Ui, cmdFlags.Args())
+	if !ok {
+		return 1
+	}
+
+	// Create and test the HTTP client
+	client, err := apiFlags.Client()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+		return 1
+	}
+
+	// Destroying a session which doesn't exist succeeds, so it's looked up
+	// first to report a mistyped ID.
+	session, _, err := client.Session().Info(id, apiFlags.QueryOptions())
+	if err != nil {
+		c.Ui.Error(apiFlags.errorMessage("Error querying Consul agent", err,
+			sessionDenial("read session "+id, "", "read")))
+		return exitCommError
+	}
+	if session == nil {
+		c.Ui.Error(fmt.Sprintf("Error! No session with ID %q", id))
+		return exitNotFound
+	}
+
+	keys, err := sessionLockedKeys(client, id, apiFlags.QueryOptions())
+	if err != nil {
+		c.Ui.Error(apiFlags.errorMessage("Error listing the keys locked by the session", err,
+			kvDenial("list the keys locked by the session", "", "read")))
+		return exitCommError
+	}
+
+	verb := "Released"
+	if session.Behavior == api.SessionBehaviorDelete {
+		verb = "Deleted"
+	}
+	if !*force && !c.confirm(session, keys, verb) {
+		return 1
+	}
+
+	if _, err := client.Session().Destroy(id, apiFlags.WriteOptions()); err != nil {
+		c.Ui.Error(apiFlags.errorMessage("Error destroying session", err,
+			sessionDenial("destroy session "+id, session.Node, "write")))
+		return exitCommError
+	}
+
+	for _, key := range keys {
+		apiFlags.report(c.Ui, fmt.Sprintf("%s: %s", verb, key))
+	}
+	apiFlags.report(c.Ui, fmt.Sprintf("Success! Destroyed session: %s", id))
+	return 0
+}
+
+// confirm asks the user to approve destroying the session, after listing
+// the keys which will be released or deleted, reporting why not if they
+// don't.
+func (c *SessionDestroyCommand) confirm(session *api.SessionEntry, keys []string, verb string) bool {
+	if !c.stdinIsTerminal() {
+		c.Ui.Error("Error! Refusing to destroy a session without confirmation. " +
+			"Use -force to skip the prompt when not running interactively.")
+		return false
+	}
+
+	for _, key := range keys {
+		c.Ui.Output(fmt.Sprintf("Will be %s: %s", strings.ToLower(verb), key))
+	}
+	query := fmt.Sprintf("Destroy session %s on node %s, which locks %d %s? "+
+		"Only 'yes' will be accepted to approve.", session.ID, session.Node, len(keys), pluralKeys(len(keys)))
+	answer, err := c.Ui.Ask(query)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error reading confirmation: %s", err))
+		return false
+	}
+	if strings.TrimSpace(answer) != "yes" {
+		c.Ui.Error("Destroy cancelled, the session was not destroyed")
+		return false
+	}
+	return true
+}
+
+// stdinIsTerminal returns true if the command can prompt the user for input.
+func (c *SessionDestroyCommand) stdinIsTerminal() bool {
+	if c.testStdinTerminal != nil {
+		return *c.testStdinTerminal
+	}
+	return isatty.IsTerminal(os.Stdin.Fd())
+}
+
+// sessionLockedKeys returns the keys locked by the given session, sorted.
+// There's no endpoint to look these up, so the whole KV store is listed and
+// the keys are picked out by their session.
+func sessionLockedKeys(client *api.Client, id string, q *api.QueryOptions) ([]string, error) {
+	pairs, _, err := client.KV().List("", q)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for _, pair := range pairs {
+		if pair.Session == id {
+			keys = append(keys, pair.Key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
@@ -0,0 +1,144 @@
+package command
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+)
+
+func TestSessionDestroyCommand_implements(t *testing.T) {
+	var _ cli.Command = &SessionDestroyCommand{}
+}
+
+func TestSessionDestroyCommand_noTabs(t *testing.T) {
+	assertNoTabs(t, new(SessionDestroyCommand))
+}
+
+// testSessionAcquire locks the given keys with the session.
+func testSessionAcquire(t *testing.T, client *api.Client, id string, keys ...string) {
+	for _, key := range keys {
+		ok, _, err := client.KV().Acquire(&api.KVPair{Key: key, Session: id}, nil)
+		if err != nil || !ok {
+			t.Fatalf("%s: bad: %v %v", key, ok, err)
+		}
+	}
+}
+
+func TestSessionDestroyCommand_confirm(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	id := testSessionCreate(t, client, nil)
+	testSessionAcquire(t, client, id, "locks/b", "locks/a")
+	if _, err := client.KV().Put(&api.KVPair{Key: "locks/c"}, nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	terminal, notTerminal := true, false
+	cases := map[string]struct {
+		terminal *bool
+		input    string
+		code     int
+		output   string
+	}{
+		"not a terminal": {
+			&notTerminal,
+			"",
+			1,
+			"Refusing to destroy a session without confirmation",
+		},
+		"declined": {
+			&terminal,
+			"no\n",
+			1,
+			"Will be released: locks/a\nWill be released: locks/b\n",
+		},
+		"confirmed": {
+			&terminal,
+			"yes\n",
+			0,
+			"Released: locks/a\nReleased: locks/b\nSuccess! Destroyed session: " + id,
+		},
+	}
+
+	for _, name := range []string{"not a terminal", "declined", "confirmed"} {
+		tc := cases[name]
+		ui := new(cli.MockUi)
+		ui.InputReader = strings.NewReader(tc.input)
+		c := &SessionDestroyCommand{Ui: ui, testStdinTerminal: tc.terminal}
+
+		code := c.Run([]string{"-http-addr=" + srv.httpAddr, id})
+		if code != tc.code {
+			t.Fatalf("%s: bad: %d. %#v", name, code, ui.ErrorWriter.String())
+		}
+
+		output := ui.OutputWriter.String() + ui.ErrorWriter.String()
+		if !strings.Contains(output, tc.output) {
+			t.Fatalf("%s: expected %q to contain %q", name, output, tc.output)
+		}
+
+		session, _, err := client.Session().Info(id, nil)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if destroyed := session == nil; destroyed != (tc.code == 0) {
+			t.Fatalf("%s: bad: %#v", name, session)
+		}
+	}
+
+	// The locks were released, leaving the keys.
+	pairs, _, err := client.KV().List("locks/", nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(pairs) != 3 {
+		t.Fatalf("bad: %#v", pairs)
+	}
+	for _, pair := range pairs {
+		if pair.Session != "" {
+			t.Fatalf("bad: %#v", pair)
+		}
+	}
+
+	// The session is gone now.
+	ui := new(cli.MockUi)
+	c := &SessionDestroyCommand{Ui: ui}
+	if code := c.Run([]string{"-http-addr=" + srv.httpAddr, "-force", id}); code != exitNotFound {
+		t.Fatalf("bad: %d", code)
+	}
+	if output := ui.ErrorWriter.String(); !strings.Contains(output, "No session with ID") {
+		t.Fatalf("bad: %#v", output)
+	}
+}
+
+func TestSessionDestroyCommand_deleteBehavior(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	id := testSessionCreate(t, client, &api.SessionEntry{Behavior: api.SessionBehaviorDelete})
+	testSessionAcquire(t, client, id, "ephemeral/a")
+	if _, err := client.KV().Put(&api.KVPair{Key: "ephemeral/b"}, nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	ui := new(cli.MockUi)
+	c := &SessionDestroyCommand{Ui: ui}
+	if code := c.Run([]string{"-http-addr=" + srv.httpAddr, "-force", id}); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if output := ui.OutputWriter.String(); !strings.Contains(output, "Deleted: ephemeral/a\n") {
+		t.Fatalf("bad: %#v", output)
+	}
+
+	keys, _, err := client.KV().Keys("ephemeral/", "", nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(keys) != 1 || keys[0] != "ephemeral/b" {
+		t.Fatalf("bad: %#v", keys)
+	}
+}
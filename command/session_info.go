@@ -0,0 +1,104 @@
+package command
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/mitchellh/cli"
+)
+
+// SessionInfoCommand is a Command implementation that is used to show the
+// details of a session.
+type SessionInfoCommand struct {
+	Ui cli.Ui
+}
+
+func (c *SessionInfoCommand) Synopsis() string {
+	return "Shows the details of a session"
+}
+
+func (c *SessionInfoCommand) Help() string {
+	helpText := `
+Usage: consul session info [options] ID
+
+  Shows the details of the session with the given ID. If there's no such
+  session, the command exits with status 2.
+
+      $ consul session info adf4238a-882b-9ddc-4a9d-5b6758e4159e
+
+  For a full list of options and examples, please see the Consul documentation.
+
+` + apiOptsText + `
+
+Session Info Options:
+
+  -format=<string>        Output format, either "text" or "json". With "json",
+                          the session is printed as an object. The default
+                          value is "text".
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *SessionInfoCommand) Run(args []string) (code int) {
+	cmdFlags, apiFlags := NewAPIFlagSet("info", c.Ui)
+	defer apiFlags.CheckTimeout(c.Ui, &code)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	format := cmdFlags.String("format", "text", "")
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+
+	id, ok := sessionIDArg(c.Ui, cmdFlags.Args())
+	if !ok {
+		return 1
+	}
+	if *format != "text" && *format != "json" {
+		c.Ui.Error(fmt.Sprintf("Error! Unsupported format %q (expected text or json)", *format))
+		return 1
+	}
+
+	// Create and test the HTTP client
+	client, err := apiFlags.Client()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+		return 1
+	}
+
+	session, _, err := client.Session().Info(id, apiFlags.QueryOptions())
+	if err != nil {
+		c.Ui.Error(apiFlags.errorMessage("Error querying Consul agent", err,
+			sessionDenial("read session "+id, "", "read")))
+		return exitCommError
+	}
+	if session == nil {
+		c.Ui.Error(fmt.Sprintf("Error! No session with ID %q", id))
+		return exitNotFound
+	}
+
+	if *format == "json" {
+		return printJSON(c.Ui, newSessionOutput(session))
+	}
+
+	var b bytes.Buffer
+	tw := tabwriter.NewWriter(&b, 0, 2, 6, ' ', 0)
+	fmt.Fprintf(tw, "ID\t%s\n", session.ID)
+	if session.Name == "" {
+		fmt.Fprint(tw, "Name\t-\n")
+	} else {
+		fmt.Fprintf(tw, "Name\t%s\n", session.Name)
+	}
+	fmt.Fprintf(tw, "Node\t%s\n", session.Node)
+	fmt.Fprintf(tw, "TTL\t%s\n", formatSessionTTL(session.TTL))
+	fmt.Fprintf(tw, "Behavior\t%s\n", session.Behavior)
+	fmt.Fprintf(tw, "LockDelay\t%s\n", session.LockDelay)
+	fmt.Fprintf(tw, "Checks\t%s\n", formatSessionChecks(session.Checks))
+	fmt.Fprintf(tw, "CreateIndex\t%d", session.CreateIndex)
+	if err := tw.Flush(); err != nil {
+		c.Ui.Error(fmt.Sprintf("Error rendering session: %s", err))
+		return 1
+	}
+	c.Ui.Output(b.String())
+	return 0
+}
@@ -0,0 +1,113 @@
+package command
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+)
+
+func TestSessionInfoCommand_implements(t *testing.T) {
+	var _ cli.Command = &SessionInfoCommand{}
+}
+
+func TestSessionInfoCommand_noTabs(t *testing.T) {
+	assertNoTabs(t, new(SessionInfoCommand))
+}
+
+func TestSessionInfoCommand_Validation(t *testing.T) {
+	cases := map[string]struct {
+		args   []string
+		output string
+	}{
+		"no id": {
+			[]string{},
+			"Missing ID argument",
+		},
+		"empty id": {
+			[]string{""},
+			"session ID can't be empty",
+		},
+		"too many args": {
+			[]string{"foo", "bar"},
+			"Too many arguments",
+		},
+		"bad format": {
+			[]string{"-format=yaml", "foo"},
+			"Unsupported format",
+		},
+	}
+
+	for name, tc := range cases {
+		ui := new(cli.MockUi)
+		c := &SessionInfoCommand{Ui: ui}
+
+		if code := c.Run(tc.args); code != 1 {
+			t.Fatalf("%s: bad: %d", name, code)
+		}
+		if output := ui.ErrorWriter.String(); !strings.Contains(output, tc.output) {
+			t.Fatalf("%s: expected %q to contain %q", name, output, tc.output)
+		}
+	}
+}
+
+func TestSessionInfoCommand_Run(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	id := testSessionCreate(t, client, &api.SessionEntry{Name: "deploy", TTL: "30s", LockDelay: 5 * time.Second})
+
+	ui := new(cli.MockUi)
+	c := &SessionInfoCommand{Ui: ui}
+	if code := c.Run([]string{"-http-addr=" + srv.httpAddr, id}); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	fields := make(map[string]string)
+	for _, line := range strings.Split(ui.OutputWriter.String(), "\n") {
+		if parts := strings.SplitN(line, " ", 2); len(parts) == 2 {
+			fields[parts[0]] = strings.TrimSpace(parts[1])
+		}
+	}
+	expected := map[string]string{
+		"ID":        id,
+		"Name":      "deploy",
+		"Node":      srv.config.NodeName,
+		"TTL":       "30s",
+		"Behavior":  "release",
+		"LockDelay": "5s",
+		"Checks":    "serfHealth",
+	}
+	for k, v := range expected {
+		if fields[k] != v {
+			t.Fatalf("%s: bad: %#v", k, fields)
+		}
+	}
+
+	ui = new(cli.MockUi)
+	c = &SessionInfoCommand{Ui: ui}
+	if code := c.Run([]string{"-http-addr=" + srv.httpAddr, "-format=json", id}); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	var out sessionOutput
+	if err := json.Unmarshal(ui.OutputWriter.Bytes(), &out); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if out.ID != id || out.Name != "deploy" || out.TTL != "30s" || out.LockDelay != "5s" || out.CreateIndex == 0 {
+		t.Fatalf("bad: %#v", out)
+	}
+
+	// A session which doesn't exist exits with 2.
+	ui = new(cli.MockUi)
+	c = &SessionInfoCommand{Ui: ui}
+	missing := "adf4238a-882b-9ddc-4a9d-5b6758e4159e"
+	if code := c.Run([]string{"-http-addr=" + srv.httpAddr, missing}); code != exitNotFound {
+		t.Fatalf("bad: %d", code)
+	}
+	if output := ui.ErrorWriter.String(); !strings.Contains(output, "No session with ID") {
+		t.Fatalf("bad: %#v", output)
+	}
+}
@@ -0,0 +1,109 @@
+package command
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+	"github.com/ryanuber/columnize"
+)
+
+// SessionListCommand is a Command implementation that is used to list the
+// sessions.
+type SessionListCommand struct {
+	Ui cli.Ui
+}
+
+func (c *SessionListCommand) Synopsis() string {
+	return "Lists the sessions"
+}
+
+func (c *SessionListCommand) Help() string {
+	helpText := `
+Usage: consul session list [options]
+
+  Lists the sessions as a table, sorted by node, with the TTL, behavior, and
+  health checks of each session:
+
+      $ consul session list
+
+  To list only the sessions of a node:
+
+      $ consul session list -node=web-1
+
+  For a full list of options and examples, please see the Consul documentation.
+
+` + apiOptsText + `
+
+Session List Options:
+
+  -format=<string>        Output format, either "text" or "json". With "json",
+                          the sessions are printed as an array of objects.
+                          The default value is "text".
+
+  -node=<name>            Only list the sessions of the given node.
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *SessionListCommand) Run(args []string) (code int) {
+	cmdFlags, apiFlags := NewAPIFlagSet("list", c.Ui)
+	defer apiFlags.CheckTimeout(c.Ui, &code)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	format := cmdFlags.String("format", "text", "")
+	node := cmdFlags.String("node", "", "")
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+
+	if args = cmdFlags.Args(); len(args) > 0 {
+		c.Ui.Error(fmt.Sprintf("Too many arguments (expected 0, got %d)", len(args)))
+		return 1
+	}
+	if *format != "text" && *format != "json" {
+		c.Ui.Error(fmt.Sprintf("Error! Unsupported format %q (expected text or json)", *format))
+		return 1
+	}
+
+	// Create and test the HTTP client
+	client, err := apiFlags.Client()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+		return 1
+	}
+
+	var sessions []*api.SessionEntry
+	denial := sessionDenial("list sessions", "", "read")
+	if *node != "" {
+		sessions, _, err = client.Session().Node(*node, apiFlags.QueryOptions())
+		denial = sessionDenial("list the sessions of "+*node, *node, "read")
+	} else {
+		sessions, _, err = client.Session().List(apiFlags.QueryOptions())
+	}
+	if err != nil {
+		c.Ui.Error(apiFlags.errorMessage("Error querying Consul agent", err, denial))
+		return exitCommError
+	}
+	sort.Sort(sessionsByNode(sessions))
+
+	if *format == "json" {
+		out := make([]*sessionOutput, len(sessions))
+		for i, session := range sessions {
+			out[i] = newSessionOutput(session)
+		}
+		return printJSON(c.Ui, out)
+	}
+
+	if len(sessions) == 0 {
+		return 0
+	}
+	result := []string{"ID|Name|Node|TTL|Behavior|Checks"}
+	for _, session := range sessions {
+		result = append(result, fmt.Sprintf("%s|%s|%s|%s|%s|%s", session.ID, session.Name,
+			session.Node, formatSessionTTL(session.TTL), session.Behavior, formatSessionChecks(session.Checks)))
+	}
+	c.Ui.Output(columnize.SimpleFormat(result))
+	return 0
+}
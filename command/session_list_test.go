@@ -0,0 +1,111 @@
+package command
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+)
+
+func TestSessionListCommand_implements(t *testing.T) {
+	var _ cli.Command = &SessionListCommand{}
+}
+
+func TestSessionListCommand_noTabs(t *testing.T) {
+	assertNoTabs(t, new(SessionListCommand))
+}
+
+func TestSessionListCommand_Validation(t *testing.T) {
+	cases := map[string]struct {
+		args   []string
+		output string
+	}{
+		"too many args": {
+			[]string{"foo"},
+			"Too many arguments",
+		},
+		"bad format": {
+			[]string{"-format=yaml"},
+			"Unsupported format",
+		},
+	}
+
+	for name, tc := range cases {
+		ui := new(cli.MockUi)
+		c := &SessionListCommand{Ui: ui}
+
+		if code := c.Run(tc.args); code != 1 {
+			t.Fatalf("%s: bad: %d", name, code)
+		}
+		if output := ui.ErrorWriter.String(); !strings.Contains(output, tc.output) {
+			t.Fatalf("%s: expected %q to contain %q", name, output, tc.output)
+		}
+	}
+}
+
+func TestSessionListCommand_Run(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	// Nothing is printed without any sessions.
+	ui := new(cli.MockUi)
+	c := &SessionListCommand{Ui: ui}
+	if code := c.Run([]string{"-http-addr=" + srv.httpAddr}); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if ui.OutputWriter != nil && ui.OutputWriter.String() != "" {
+		t.Fatalf("bad: %#v", ui.OutputWriter.String())
+	}
+
+	id1 := testSessionCreate(t, client, &api.SessionEntry{Name: "deploy", TTL: "30s"})
+	id2 := testSessionCreate(t, client, &api.SessionEntry{Behavior: api.SessionBehaviorDelete})
+
+	ui = new(cli.MockUi)
+	c = &SessionListCommand{Ui: ui}
+	if code := c.Run([]string{"-http-addr=" + srv.httpAddr}); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	lines := strings.Split(strings.TrimSpace(ui.OutputWriter.String()), "\n")
+	if len(lines) != 3 || strings.Join(strings.Fields(lines[0]), " ") != "ID Name Node TTL Behavior Checks" {
+		t.Fatalf("bad: %#v", lines)
+	}
+	for _, line := range lines[1:] {
+		var expected []string
+		switch {
+		case strings.HasPrefix(line, id1):
+			expected = []string{id1, "deploy", srv.config.NodeName, "30s", "release", "serfHealth"}
+		case strings.HasPrefix(line, id2):
+			expected = []string{id2, srv.config.NodeName, "-", "delete", "serfHealth"}
+		default:
+			t.Fatalf("bad: %#v", line)
+		}
+		if fields := strings.Fields(line); strings.Join(fields, " ") != strings.Join(expected, " ") {
+			t.Fatalf("bad: %#v", fields)
+		}
+	}
+
+	// The sessions can be listed by node, as JSON.
+	for node, count := range map[string]int{srv.config.NodeName: 2, "nope": 0} {
+		ui = new(cli.MockUi)
+		c = &SessionListCommand{Ui: ui}
+		args := []string{"-http-addr=" + srv.httpAddr, "-format=json", "-node=" + node}
+		if code := c.Run(args); code != 0 {
+			t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+		}
+		var out []sessionOutput
+		if err := json.Unmarshal(ui.OutputWriter.Bytes(), &out); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if len(out) != count {
+			t.Fatalf("%s: bad: %#v", node, out)
+		}
+		for _, session := range out {
+			if session.Node != node || session.LockDelay != "15s" || len(session.Checks) != 1 {
+				t.Fatalf("%s: bad: %#v", node, session)
+			}
+		}
+	}
+}
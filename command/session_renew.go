@@ -0,0 +1,72 @@
+package command
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mitchellh/cli"
+)
+
+// SessionRenewCommand is a Command implementation that is used to renew the
+// TTL of a session.
+type SessionRenewCommand struct {
+	Ui cli.Ui
+}
+
+func (c *SessionRenewCommand) Synopsis() string {
+	return "Renews the TTL of a session"
+}
+
+func (c *SessionRenewCommand) Help() string {
+	helpText := `
+Usage: consul session renew [options] ID
+
+  Renews the session with the given ID, restarting its TTL. If there's no
+  such session, which may be because its TTL already ran out, the command
+  exits with status 2.
+
+      $ consul session renew adf4238a-882b-9ddc-4a9d-5b6758e4159e
+
+  The servers may increase the TTL of a session when renewing it if they're
+  under load, so the new TTL is printed.
+
+  For a full list of options and examples, please see the Consul documentation.
+
+` + apiOptsText
+	return strings.TrimSpace(helpText)
+}
+
+func (c *SessionRenewCommand) Run(args []string) (code int) {
+	cmdFlags, apiFlags := NewAPIFlagSet("renew", c.Ui)
+	defer apiFlags.CheckTimeout(c.Ui, &code)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+
+	id, ok := sessionIDArg(c.Ui, cmdFlags.Args())
+	if !ok {
+		return 1
+	}
+
+	// Create and test the HTTP client
+	client, err := apiFlags.Client()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+		return 1
+	}
+
+	session, _, err := client.Session().Renew(id, apiFlags.WriteOptions())
+	if err != nil {
+		c.Ui.Error(apiFlags.errorMessage("Error renewing session", err,
+			sessionDenial("renew session "+id, "", "write")))
+		return exitCommError
+	}
+	if session == nil {
+		c.Ui.Error(fmt.Sprintf("Error! No session with ID %q, it may have expired", id))
+		return exitNotFound
+	}
+
+	apiFlags.report(c.Ui, fmt.Sprintf("Success! Renewed session %s (TTL: %s)", id, formatSessionTTL(session.TTL)))
+	return 0
+}
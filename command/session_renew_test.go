@@ -0,0 +1,54 @@
+package command
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+)
+
+func TestSessionRenewCommand_implements(t *testing.T) {
+	var _ cli.Command = &SessionRenewCommand{}
+}
+
+func TestSessionRenewCommand_noTabs(t *testing.T) {
+	assertNoTabs(t, new(SessionRenewCommand))
+}
+
+func TestSessionRenewCommand_Run(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	id := testSessionCreate(t, client, &api.SessionEntry{TTL: "30s"})
+
+	ui := new(cli.MockUi)
+	c := &SessionRenewCommand{Ui: ui}
+	if code := c.Run([]string{"-http-addr=" + srv.httpAddr, id}); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if output := ui.OutputWriter.String(); !strings.Contains(output, "Success! Renewed session "+id+" (TTL: 30s)") {
+		t.Fatalf("bad: %#v", output)
+	}
+
+	// A session which doesn't exist, or has expired, exits with 2.
+	if _, err := client.Session().Destroy(id, nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	ui = new(cli.MockUi)
+	c = &SessionRenewCommand{Ui: ui}
+	if code := c.Run([]string{"-http-addr=" + srv.httpAddr, id}); code != exitNotFound {
+		t.Fatalf("bad: %d", code)
+	}
+	if output := ui.ErrorWriter.String(); !strings.Contains(output, "may have expired") {
+		t.Fatalf("bad: %#v", output)
+	}
+
+	// The ID is required.
+	ui = new(cli.MockUi)
+	c = &SessionRenewCommand{Ui: ui}
+	if code := c.Run([]string{"-http-addr=" + srv.httpAddr}); code != 1 {
+		t.Fatalf("bad: %d", code)
+	}
+}
@@ -40,6 +40,9 @@ Usage: consul snapshot <subcommand> [options] [args]
 
       $ consul snapshot inspect backup.snap
 
+  Compare two snapshots:
+
+      $ consul snapshot diff old.snap new.snap
 
   For more examples, ask for subcommand help or view the documentation.
 
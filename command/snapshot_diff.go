@@ -0,0 +1,410 @@
+package command
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/hashicorp/consul/consul/structs"
+	"github.com/hashicorp/go-msgpack/codec"
+	"github.com/hashicorp/raft"
+	"github.com/mitchellh/cli"
+)
+
+// SnapshotDiffCommand is a Command implementation that is used to compare
+// the state in two snapshot files.
+type SnapshotDiffCommand struct {
+	Ui cli.Ui
+}
+
+func (c *SnapshotDiffCommand) Help() string {
+	helpText := `
+Usage: consul snapshot diff [options] OLD NEW
+
+  Compares the state in two snapshot files, and reports how many entries of
+  each type were added, removed, or changed between them:
+
+    $ consul snapshot diff last-night.snap now.snap
+
+  Nodes, services, checks, KV entries, sessions, ACL tokens, and prepared
+  queries are compared. Tombstones and network coordinates are left out, as
+  they change all the time, and so are any entries of types this version of
+  Consul doesn't know about, so snapshots from slightly different versions
+  can still be compared. Raft indexes aren't compared, so an entry which was
+  written again with the same contents isn't reported as changed.
+
+  Both snapshots are verified and read in a single pass. Only a hash of each
+  entry is kept, so the memory used depends on the number of entries, not
+  their size.
+
+  The command exits with status 0 if the snapshots have the same state, 2 if
+  there are differences, or 1 if there was an error.
+
+  For a full list of options and examples, please see the Consul documentation.
+
+Snapshot Diff Options:
+
+  -detail=<type>          List the entries of the given type which differ, as
+                          well as the counts. Only "kv" is supported, which
+                          lists each KV key which was added, removed, or
+                          changed. Short text values are shown, and other
+                          values are summarized by their SHA-256 and size.
+`
+
+	return strings.TrimSpace(helpText)
+}
+
+func (c *SnapshotDiffCommand) Run(args []string) int {
+	cmdFlags := flag.NewFlagSet("diff", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	detail := cmdFlags.String("detail", "", "")
+	if err := parseFlags(c.Ui, cmdFlags, args); err != nil {
+		return 1
+	}
+
+	if *detail != "" && *detail != "kv" {
+		c.Ui.Error(fmt.Sprintf("Unsupported -detail %q (expected kv)", *detail))
+		return 1
+	}
+
+	args = cmdFlags.Args()
+	if len(args) != 2 {
+		c.Ui.Error(fmt.Sprintf("Expected OLD and NEW snapshot files (got %d arguments)", len(args)))
+		return 1
+	}
+
+	// The old snapshot is summarized first, then the new one compared
+	// against it as it's read.
+	diff := newSnapshotDiff()
+	for i, file := range args {
+		f, err := os.Open(file)
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Error opening snapshot file: %s", err))
+			return 1
+		}
+		read := diff.readOld
+		if i == 1 {
+			read = diff.readNew
+		}
+		err = read(f)
+		f.Close()
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Error verifying snapshot %s: %s", file, err))
+			return 1
+		}
+	}
+	types, kv := diff.finish()
+
+	var b bytes.Buffer
+	tw := tabwriter.NewWriter(&b, 0, 2, 6, ' ', 0)
+	fmt.Fprintf(tw, "Old\t%s (index %d, term %d)\n", args[0], diff.oldMeta.Index, diff.oldMeta.Term)
+	fmt.Fprintf(tw, "New\t%s (index %d, term %d)\n", args[1], diff.newMeta.Index, diff.newMeta.Term)
+	fmt.Fprintf(tw, "\n")
+	fmt.Fprintf(tw, "Type\tAdded\tRemoved\tChanged\tUnchanged\n")
+	changes := 0
+	for _, t := range types {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\n", t.Name, t.Added, t.Removed, t.Changed, t.Unchanged)
+		changes += t.Added + t.Removed + t.Changed
+	}
+	if err := tw.Flush(); err != nil {
+		c.Ui.Error(fmt.Sprintf("Error rendering snapshot diff: %s", err))
+		return 1
+	}
+	c.Ui.Info(b.String())
+
+	if *detail == "kv" {
+		for _, d := range kv {
+			c.Ui.Info(d.String())
+		}
+	}
+
+	if changes > 0 {
+		return 2
+	}
+	return 0
+}
+
+func (c *SnapshotDiffCommand) Synopsis() string {
+	return "Compares the state in two Consul snapshot files"
+}
+
+// snapshotDiffTypes are the types of entries compared by a diff, in the order
+// they are reported.
+var snapshotDiffTypes = []string{
+	"Node",
+	"Service",
+	"Check",
+	"KV",
+	"Session",
+	"ACL Token",
+	"Prepared Query",
+}
+
+// snapshotTypeDiff counts the differences in the entries of one type between
+// two snapshots.
+type snapshotTypeDiff struct {
+	Name      string
+	Added     int
+	Removed   int
+	Changed   int
+	Unchanged int
+}
+
+// snapshotDiffEntry is what's kept of an entry to compare it with the other
+// snapshot: a hash of its contents, and for a KV entry, its flags and a
+// description of its value.
+type snapshotDiffEntry struct {
+	sum   [sha256.Size]byte
+	flags uint64
+	value string
+}
+
+// snapshotKVDiff is a KV key which differs between two snapshots. Old is nil
+// if the key was added, and New is nil if it was removed.
+type snapshotKVDiff struct {
+	Key string
+	Old *snapshotDiffEntry
+	New *snapshotDiffEntry
+}
+
+func (d *snapshotKVDiff) String() string {
+	switch {
+	case d.Old == nil:
+		return fmt.Sprintf("Added: %s = %s", d.Key, d.New.value)
+	case d.New == nil:
+		return fmt.Sprintf("Removed: %s", d.Key)
+	}
+
+	var parts []string
+	if d.Old.value != d.New.value {
+		parts = append(parts, fmt.Sprintf("value %s => %s", d.Old.value, d.New.value))
+	}
+	if d.Old.flags != d.New.flags {
+		parts = append(parts, fmt.Sprintf("flags %d => %d", d.Old.flags, d.New.flags))
+	}
+	if len(parts) == 0 {
+		parts = append(parts, "lock or session")
+	}
+	return fmt.Sprintf("Changed: %s: %s", d.Key, strings.Join(parts, ", "))
+}
+
+// snapshotDiff compares the state of two snapshots, which are read one after
+// the other.
+type snapshotDiff struct {
+	oldMeta *raft.SnapshotMeta
+	newMeta *raft.SnapshotMeta
+
+	// old holds the entries of the old snapshot by type and ID. Each entry
+	// is removed once it's found in the new snapshot, so only the removed
+	// entries are left.
+	old   map[string]map[string]*snapshotDiffEntry
+	types map[string]*snapshotTypeDiff
+	kv    []*snapshotKVDiff
+}
+
+func newSnapshotDiff() *snapshotDiff {
+	d := &snapshotDiff{
+		old:   make(map[string]map[string]*snapshotDiffEntry),
+		types: make(map[string]*snapshotTypeDiff),
+	}
+	for _, name := range snapshotDiffTypes {
+		d.old[name] = make(map[string]*snapshotDiffEntry)
+		d.types[name] = &snapshotTypeDiff{Name: name}
+	}
+	return d
+}
+
+// readOld reads the old snapshot, keeping a summary of each entry.
+func (d *snapshotDiff) readOld(in io.Reader) error {
+	meta, err := readSnapshotState(in, func(state io.Reader) error {
+		return decodeSnapshotEntries(state, d.addOld)
+	})
+	d.oldMeta = meta
+	return err
+}
+
+// readNew reads the new snapshot, comparing each entry with the old one.
+func (d *snapshotDiff) readNew(in io.Reader) error {
+	meta, err := readSnapshotState(in, func(state io.Reader) error {
+		return decodeSnapshotEntries(state, d.addNew)
+	})
+	d.newMeta = meta
+	return err
+}
+
+func (d *snapshotDiff) addOld(typ, id string, e *snapshotDiffEntry) {
+	d.old[typ][id] = e
+}
+
+func (d *snapshotDiff) addNew(typ, id string, e *snapshotDiffEntry) {
+	t := d.types[typ]
+	old, ok := d.old[typ][id]
+	switch {
+	case !ok:
+		t.Added++
+	case old.sum != e.sum:
+		t.Changed++
+	default:
+		t.Unchanged++
+	}
+	delete(d.old[typ], id)
+
+	if typ == "KV" && (!ok || old.sum != e.sum) {
+		d.kv = append(d.kv, &snapshotKVDiff{Key: id, Old: old, New: e})
+	}
+}
+
+// finish counts the entries which were only in the old snapshot, and returns
+// the counts for each type along with the KV keys which differ, sorted by
+// key.
+func (d *snapshotDiff) finish() ([]*snapshotTypeDiff, []*snapshotKVDiff) {
+	var types []*snapshotTypeDiff
+	for _, name := range snapshotDiffTypes {
+		t := d.types[name]
+		t.Removed = len(d.old[name])
+		types = append(types, t)
+	}
+	for key, e := range d.old["KV"] {
+		d.kv = append(d.kv, &snapshotKVDiff{Key: key, Old: e})
+	}
+	sort.Sort(snapshotKVDiffsByKey(d.kv))
+	return types, d.kv
+}
+
+type snapshotKVDiffsByKey []*snapshotKVDiff
+
+func (s snapshotKVDiffsByKey) Len() int           { return len(s) }
+func (s snapshotKVDiffsByKey) Less(i, j int) bool { return s[i].Key < s[j].Key }
+func (s snapshotKVDiffsByKey) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// decodeSnapshotEntries walks the entries in the state from a snapshot like
+// decodeSnapshotState, calling fn with the type, ID, and summary of each one
+// that's compared. Tombstones, coordinates, and entries of unknown types are
+// skipped.
+func decodeSnapshotEntries(r io.Reader, fn func(typ, id string, e *snapshotDiffEntry)) error {
+	br := bufio.NewReader(r)
+	dec := codec.NewDecoder(br, &codec.MsgpackHandle{})
+
+	var header interface{}
+	if err := dec.Decode(&header); err != nil {
+		return err
+	}
+
+	for {
+		msgType, err := br.ReadByte()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		var typ, id string
+		var value interface{}
+		e := &snapshotDiffEntry{}
+		switch structs.MessageType(msgType) {
+		case structs.RegisterRequestType:
+			var req structs.RegisterRequest
+			if err := dec.Decode(&req); err != nil {
+				return err
+			}
+			switch {
+			case req.Service != nil:
+				req.Service.RaftIndex = structs.RaftIndex{}
+				typ, id, value = "Service", req.Node+"/"+req.Service.ID, req.Service
+			case req.Check != nil:
+				req.Check.RaftIndex = structs.RaftIndex{}
+				typ, id, value = "Check", req.Node+"/"+string(req.Check.CheckID), req.Check
+			default:
+				typ, id, value = "Node", req.Node, &structs.Node{
+					Node:            req.Node,
+					Address:         req.Address,
+					TaggedAddresses: req.TaggedAddresses,
+					Meta:            req.NodeMeta,
+				}
+			}
+		case structs.KVSRequestType:
+			var entry structs.DirEntry
+			if err := dec.Decode(&entry); err != nil {
+				return err
+			}
+			entry.RaftIndex = structs.RaftIndex{}
+			typ, id, value = "KV", entry.Key, &entry
+			e.flags, e.value = entry.Flags, describeSnapshotValue(entry.Value)
+		case structs.SessionRequestType:
+			var session structs.Session
+			if err := dec.Decode(&session); err != nil {
+				return err
+			}
+			session.RaftIndex = structs.RaftIndex{}
+			typ, id, value = "Session", session.ID, &session
+		case structs.ACLRequestType:
+			var acl structs.ACL
+			if err := dec.Decode(&acl); err != nil {
+				return err
+			}
+			acl.RaftIndex = structs.RaftIndex{}
+			typ, id, value = "ACL Token", acl.ID, &acl
+		case structs.PreparedQueryRequestType:
+			var query structs.PreparedQuery
+			if err := dec.Decode(&query); err != nil {
+				return err
+			}
+			query.RaftIndex = structs.RaftIndex{}
+			typ, id, value = "Prepared Query", query.ID, &query
+		default:
+			var skip interface{}
+			if err := dec.Decode(&skip); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if e.sum, err = snapshotDiffSum(value); err != nil {
+			return err
+		}
+		fn(typ, id, e)
+	}
+	return nil
+}
+
+// snapshotDiffSum hashes the contents of an entry. The entry is encoded as
+// JSON, which sorts map keys, so the same contents always give the same hash.
+// The caller clears the Raft indexes first.
+func snapshotDiffSum(v interface{}) ([sha256.Size]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+	return sha256.Sum256(b), nil
+}
+
+// snapshotDiffMaxValue is the longest KV value shown in full by -detail.
+const snapshotDiffMaxValue = 64
+
+// describeSnapshotValue returns a KV value quoted if it's short printable
+// text, or else its size and the start of its SHA-256.
+func describeSnapshotValue(value []byte) string {
+	printable := len(value) <= snapshotDiffMaxValue && utf8.Valid(value)
+	for _, r := range string(value) {
+		if !printable {
+			break
+		}
+		printable = unicode.IsPrint(r)
+	}
+	if printable {
+		return fmt.Sprintf("%q", value)
+	}
+	sum := sha256.Sum256(value)
+	return fmt.Sprintf("sha256:%s (%d bytes)", hex.EncodeToString(sum[:6]), len(value))
+}
@@ -0,0 +1,287 @@
+package command
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/consul/structs"
+	"github.com/hashicorp/go-msgpack/codec"
+	"github.com/mitchellh/cli"
+)
+
+func TestSnapshotDiffCommand_implements(t *testing.T) {
+	var _ cli.Command = &SnapshotDiffCommand{}
+}
+
+func TestSnapshotDiffCommand_noTabs(t *testing.T) {
+	assertNoTabs(t, new(SnapshotDiffCommand))
+}
+
+func TestSnapshotDiffCommand_Validation(t *testing.T) {
+	ui := new(cli.MockUi)
+	c := &SnapshotDiffCommand{Ui: ui}
+
+	cases := map[string]struct {
+		args   []string
+		output string
+	}{
+		"no files": {
+			[]string{},
+			"Expected OLD and NEW snapshot files (got 0 arguments)",
+		},
+		"one file": {
+			[]string{"foo"},
+			"Expected OLD and NEW snapshot files (got 1 arguments)",
+		},
+		"extra args": {
+			[]string{"foo", "bar", "baz"},
+			"Expected OLD and NEW snapshot files (got 3 arguments)",
+		},
+		"bad detail": {
+			[]string{"-detail=acl", "foo", "bar"},
+			`Unsupported -detail "acl" (expected kv)`,
+		},
+		"missing file": {
+			[]string{"test-fixtures/snapshot/backup.snap", "test-fixtures/snapshot/nope.snap"},
+			"Error opening snapshot file",
+		},
+	}
+
+	for name, tc := range cases {
+		// Ensure our buffer is always clear
+		if ui.ErrorWriter != nil {
+			ui.ErrorWriter.Reset()
+		}
+		if ui.OutputWriter != nil {
+			ui.OutputWriter.Reset()
+		}
+
+		code := c.Run(tc.args)
+		if code != 1 {
+			t.Errorf("%s: expected exit 1, got %d", name, code)
+		}
+
+		output := ui.ErrorWriter.String()
+		if !strings.Contains(output, tc.output) {
+			t.Errorf("%s: expected %q to contain %q", name, output, tc.output)
+		}
+	}
+}
+
+func TestSnapshotDiffCommand_same(t *testing.T) {
+	ui := new(cli.MockUi)
+	c := &SnapshotDiffCommand{Ui: ui}
+
+	fixture := "test-fixtures/snapshot/backup.snap"
+	code := c.Run([]string{"-detail=kv", fixture, fixture})
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+
+	output := ui.OutputWriter.String()
+	if !strings.Contains(output, "KV                  0          0            0            3") {
+		t.Fatalf("bad: %#v", output)
+	}
+	if strings.Contains(output, "Added:") || strings.Contains(output, "Changed:") {
+		t.Fatalf("bad: %#v", output)
+	}
+}
+
+func TestSnapshotDiffCommand_Run(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	save := func(name string) string {
+		file := path.Join(dir, name)
+		f, err := os.Create(file)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		defer f.Close()
+
+		snap, _, err := client.Snapshot().Save(nil)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		defer snap.Close()
+		if _, err := io.Copy(f, snap); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		return file
+	}
+	put := func(key, value string, flags uint64) {
+		pair := &api.KVPair{Key: key, Value: []byte(value), Flags: flags}
+		if _, err := client.KV().Put(pair, nil); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	put("app/kept", "same", 0)
+	put("app/removed", "gone", 0)
+	put("app/changed", "before", 0)
+	put("app/flagged", "x", 1)
+	oldFile := save("old.snap")
+
+	// Writing a key again with the same value only bumps its index.
+	put("app/kept", "same", 0)
+	if _, err := client.KV().Delete("app/removed", nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	put("app/changed", "after", 0)
+	put("app/flagged", "x", 2)
+	put("app/added", strings.Repeat("a", 100), 0)
+	newFile := save("new.snap")
+
+	ui := new(cli.MockUi)
+	c := &SnapshotDiffCommand{Ui: ui}
+	code := c.Run([]string{"-detail=kv", oldFile, newFile})
+	if code != 2 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+
+	output := ui.OutputWriter.String()
+	for _, line := range []string{
+		"Type                Added      Removed      Changed      Unchanged",
+		"KV                  1          1            2            1",
+		`Added: app/added = sha256:2816597888e4 (100 bytes)`,
+		`Changed: app/changed: value "before" => "after"`,
+		`Changed: app/flagged: flags 1 => 2`,
+		`Removed: app/removed`,
+	} {
+		if !strings.Contains(output, line) {
+			t.Fatalf("bad %#v, missing %q", output, line)
+		}
+	}
+	if strings.Contains(output, "app/kept") {
+		t.Fatalf("bad: %#v", output)
+	}
+
+	// The keys are listed in order.
+	if strings.Index(output, "app/added") > strings.Index(output, "app/removed") {
+		t.Fatalf("bad: %#v", output)
+	}
+
+	// Without -detail only the counts are shown.
+	ui = new(cli.MockUi)
+	c = &SnapshotDiffCommand{Ui: ui}
+	code = c.Run([]string{oldFile, newFile})
+	if code != 2 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if output := ui.OutputWriter.String(); strings.Contains(output, "app/") {
+		t.Fatalf("bad: %#v", output)
+	}
+}
+
+func TestSnapshotDiff_entries(t *testing.T) {
+	type entry struct {
+		msgType structs.MessageType
+		value   interface{}
+	}
+	encode := func(entries []entry) *bytes.Buffer {
+		var buf bytes.Buffer
+		enc := codec.NewEncoder(&buf, &codec.MsgpackHandle{})
+		if err := enc.Encode(map[string]uint64{"LastIndex": 42}); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		for _, e := range entries {
+			buf.WriteByte(byte(e.msgType))
+			if err := enc.Encode(e.value); err != nil {
+				t.Fatalf("err: %v", err)
+			}
+		}
+		return &buf
+	}
+
+	service := &structs.NodeService{ID: "web", Service: "web", Port: 80}
+	old := encode([]entry{
+		{structs.RegisterRequestType, &structs.RegisterRequest{Node: "foo", Address: "127.0.0.1"}},
+		{structs.RegisterRequestType, &structs.RegisterRequest{Node: "foo", Service: service}},
+		{structs.KVSRequestType, &structs.DirEntry{Key: "big", Value: bytes.Repeat([]byte("a"), 65)}},
+		{structs.KVSRequestType, &structs.DirEntry{Key: "binary", Value: []byte{0, 1, 2}}},
+		{structs.SessionRequestType, &structs.Session{ID: "s1", Node: "foo"}},
+		{structs.TombstoneRequestType, &structs.DirEntry{Key: "deleted"}},
+	})
+
+	// The new snapshot has an entry from a newer version of Consul, and the
+	// same service at a later index.
+	moved := *service
+	moved.RaftIndex = structs.RaftIndex{CreateIndex: 10, ModifyIndex: 10}
+	newState := encode([]entry{
+		{structs.RegisterRequestType, &structs.RegisterRequest{Node: "foo", Address: "127.0.0.2"}},
+		{structs.RegisterRequestType, &structs.RegisterRequest{Node: "foo", Service: &moved}},
+		{structs.KVSRequestType, &structs.DirEntry{Key: "big", Value: bytes.Repeat([]byte("b"), 65)}},
+		{structs.KVSRequestType, &structs.DirEntry{Key: "binary", Value: []byte{0, 1, 2}}},
+		{structs.IgnoreUnknownTypeFlag | 42, map[string]string{"Hello": "world"}},
+		{structs.ACLRequestType, &structs.ACL{ID: "token", Name: "test"}},
+	})
+
+	d := newSnapshotDiff()
+	if err := decodeSnapshotEntries(old, d.addOld); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := decodeSnapshotEntries(newState, d.addNew); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	types, kv := d.finish()
+
+	expected := map[string]snapshotTypeDiff{
+		"Node":           {Name: "Node", Changed: 1},
+		"Service":        {Name: "Service", Unchanged: 1},
+		"Check":          {Name: "Check"},
+		"KV":             {Name: "KV", Changed: 1, Unchanged: 1},
+		"Session":        {Name: "Session", Removed: 1},
+		"ACL Token":      {Name: "ACL Token", Added: 1},
+		"Prepared Query": {Name: "Prepared Query"},
+	}
+	if len(types) != len(expected) {
+		t.Fatalf("bad: %#v", types)
+	}
+	for _, actual := range types {
+		if *actual != expected[actual.Name] {
+			t.Fatalf("bad: %#v", actual)
+		}
+	}
+
+	if len(kv) != 1 {
+		t.Fatalf("bad: %#v", kv)
+	}
+	line := kv[0].String()
+	if line != "Changed: big: value sha256:635361c48bb9 (65 bytes) => sha256:74b128f30cf8 (65 bytes)" {
+		t.Fatalf("bad: %q", line)
+	}
+}
+
+func TestDescribeSnapshotValue(t *testing.T) {
+	cases := []struct {
+		value    []byte
+		expected string
+	}{
+		{nil, `""`},
+		{[]byte("hello world"), `"hello world"`},
+		{[]byte(`say "hi"`), `"say \"hi\""`},
+		{bytes.Repeat([]byte("a"), 64), `"` + strings.Repeat("a", 64) + `"`},
+		{bytes.Repeat([]byte("a"), 65), "sha256:"},
+		{[]byte("line\nbreak"), "sha256:"},
+		{[]byte{0xff, 0xfe}, "sha256:"},
+	}
+	for _, tc := range cases {
+		actual := describeSnapshotValue(tc.value)
+		if !strings.HasPrefix(actual, tc.expected) {
+			t.Errorf("%q: expected %q, got %q", tc.value, tc.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,434 @@
+package command
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"strings"
+
+	"github.com/hashicorp/consul/snapshot"
+	"github.com/hashicorp/raft"
+	"golang.org/x/crypto/scrypt"
+)
+
+// An encrypted snapshot starts with snapshotEncryptedMagic, followed by a
+// random nonce prefix. A snapshot encrypted with a passphrase instead starts
+// with snapshotScryptMagic, followed by the scrypt parameters log2(N), r, and
+// p, a byte each, a random salt, and then the nonce prefix, and its key is
+// derived from the passphrase and salt. The snapshot follows in chunks of up to
+// snapshotChunkSize bytes, each sealed with AES-256-GCM and written as a
+// 4-byte big-endian length and then the sealed chunk. The top bit of the
+// length marks the final chunk. Each chunk's nonce is the prefix, the
+// chunk's number, and whether it's the final one, and the header is
+// authenticated along with every chunk, so chunks can't be dropped,
+// reordered, or moved between snapshots without it being noticed.
+const (
+	snapshotEncryptedMagic  = "consul snapshot aes-256-gcm v1\n"
+	snapshotScryptMagic     = "consul snapshot aes-256-gcm scrypt v1\n"
+	snapshotNoncePrefixSize = 7
+	snapshotChunkSize       = 64 * 1024
+	snapshotFinalChunk      = 1 << 31
+	snapshotKeySize         = 32
+	snapshotSaltSize        = 16
+)
+
+// The scrypt parameters for new snapshots encrypted with a passphrase, which
+// take about 32MB and a fraction of a second to derive a key. Snapshots with
+// parameters needing more than snapshotScryptMaxMemory aren't decrypted, so a
+// crafted header can't exhaust the memory before the key is checked.
+const (
+	snapshotScryptLogN      = 15
+	snapshotScryptR         = 8
+	snapshotScryptP         = 1
+	snapshotScryptMaxMemory = 256 * 1024 * 1024
+
+	// snapshotMinPassphrase is the shortest passphrase accepted.
+	snapshotMinPassphrase = 8
+)
+
+var (
+	// errSnapshotEncrypted is returned when reading an encrypted snapshot
+	// without a key.
+	errSnapshotEncrypted = errors.New("The snapshot is encrypted. Pass the key it was encrypted with " +
+		"as -decrypt-key")
+
+	// errSnapshotDecrypt is returned when a chunk of an encrypted snapshot
+	// fails to authenticate.
+	errSnapshotDecrypt = errors.New("Failed to decrypt the snapshot: the key is wrong, or the data " +
+		"has been changed")
+
+	// errSnapshotTruncated is returned when an encrypted snapshot ends
+	// before its final chunk.
+	errSnapshotTruncated = errors.New("The encrypted snapshot is truncated")
+)
+
+// snapshotKey is what a snapshot is encrypted with: either a 32-byte key,
+// or a passphrase, which a key is derived from for each snapshot.
+type snapshotKey struct {
+	key        []byte
+	passphrase []byte
+}
+
+// readSnapshotKey reads the key for an encrypted snapshot from the given
+// file. The file holds the 32 bytes of the key, either as they are or hex or
+// base64 encoded, or else a passphrase. Text which happens to be 32
+// characters long isn't taken as a raw key, and hex digits which aren't a
+// 32-byte key are refused rather than being taken as a passphrase.
+func readSnapshotKey(path string) (*snapshotKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read key file: %s", err)
+	}
+	if len(data) == snapshotKeySize && !isPrintable(data) {
+		return &snapshotKey{key: data}, nil
+	}
+
+	text := strings.TrimSpace(string(data))
+	if key, err := hex.DecodeString(text); err == nil {
+		if len(key) == snapshotKeySize {
+			return &snapshotKey{key: key}, nil
+		}
+		return nil, fmt.Errorf("Key file %s holds %d hex encoded bytes, but a key must be 32 bytes, "+
+			"such as from \"openssl rand -hex 32\"", path, len(key))
+	}
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && len(key) == snapshotKeySize {
+		return &snapshotKey{key: key}, nil
+	}
+
+	// Only the line break at the end of the file is left off a passphrase.
+	passphrase := strings.TrimRight(string(data), "\r\n")
+	if len(passphrase) < snapshotMinPassphrase || !isPrintable([]byte(passphrase)) {
+		return nil, fmt.Errorf("Key file %s must hold a 32-byte key, either raw or hex or base64 encoded, "+
+			"such as from \"openssl rand -hex 32\", or a passphrase of at least %d characters",
+			path, snapshotMinPassphrase)
+	}
+	return &snapshotKey{passphrase: []byte(passphrase)}, nil
+}
+
+// newHeader returns the header for a new encrypted snapshot, with a random
+// nonce prefix, and the key to encrypt it with. With a passphrase, the key is
+// derived with a random salt, which is recorded in the header along with the
+// scrypt parameters.
+func (k *snapshotKey) newHeader() ([]byte, []byte, error) {
+	if k.passphrase == nil {
+		header := make([]byte, len(snapshotEncryptedMagic)+snapshotNoncePrefixSize)
+		copy(header, snapshotEncryptedMagic)
+		if _, err := io.ReadFull(rand.Reader, header[len(snapshotEncryptedMagic):]); err != nil {
+			return nil, nil, err
+		}
+		return header, k.key, nil
+	}
+
+	header := make([]byte, len(snapshotScryptMagic)+3+snapshotSaltSize+snapshotNoncePrefixSize)
+	n := copy(header, snapshotScryptMagic)
+	header[n], header[n+1], header[n+2] = snapshotScryptLogN, snapshotScryptR, snapshotScryptP
+	if _, err := io.ReadFull(rand.Reader, header[n+3:]); err != nil {
+		return nil, nil, err
+	}
+	salt := header[n+3 : n+3+snapshotSaltSize]
+	key, err := scrypt.Key(k.passphrase, salt, 1<<snapshotScryptLogN, snapshotScryptR, snapshotScryptP,
+		snapshotKeySize)
+	if err != nil {
+		return nil, nil, err
+	}
+	return header, key, nil
+}
+
+// readHeader reads the header of an encrypted snapshot, returning it and the
+// key to decrypt the snapshot with.
+func (k *snapshotKey) readHeader(in io.Reader) ([]byte, []byte, error) {
+	read := func(p []byte) error {
+		if _, err := io.ReadFull(in, p); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return errSnapshotTruncated
+			}
+			return err
+		}
+		return nil
+	}
+
+	// The magic for a key is the shorter of the two.
+	header := make([]byte, len(snapshotEncryptedMagic))
+	if err := read(header); err != nil {
+		return nil, nil, err
+	}
+	if string(header) == snapshotEncryptedMagic {
+		if k.passphrase != nil {
+			return nil, nil, fmt.Errorf("The snapshot was encrypted with a 32-byte key, " +
+				"but -decrypt-key holds a passphrase")
+		}
+		header = append(header, make([]byte, snapshotNoncePrefixSize)...)
+		if err := read(header[len(snapshotEncryptedMagic):]); err != nil {
+			return nil, nil, err
+		}
+		return header, k.key, nil
+	}
+	if !strings.HasPrefix(snapshotScryptMagic, string(header)) {
+		return nil, nil, fmt.Errorf("The snapshot is not encrypted")
+	}
+
+	header = append(header, make([]byte, len(snapshotScryptMagic)-len(header)+3+snapshotSaltSize+
+		snapshotNoncePrefixSize)...)
+	if err := read(header[len(snapshotEncryptedMagic):]); err != nil {
+		return nil, nil, err
+	}
+	n := len(snapshotScryptMagic)
+	if string(header[:n]) != snapshotScryptMagic {
+		return nil, nil, fmt.Errorf("The snapshot is not encrypted")
+	}
+	if k.passphrase == nil {
+		return nil, nil, fmt.Errorf("The snapshot was encrypted with a passphrase, " +
+			"but -decrypt-key holds a 32-byte key")
+	}
+	logN, r, p := uint(header[n]), int(header[n+1]), int(header[n+2])
+	if logN < 1 || logN > 30 || r < 1 || p < 1 || 128*r<<logN > snapshotScryptMaxMemory {
+		return nil, nil, fmt.Errorf("The snapshot's scrypt parameters N=2^%d, r=%d, p=%d are not supported",
+			logN, r, p)
+	}
+	salt := header[n+3 : n+3+snapshotSaltSize]
+	key, err := scrypt.Key(k.passphrase, salt, 1<<logN, r, p, snapshotKeySize)
+	if err != nil {
+		return nil, nil, err
+	}
+	return header, key, nil
+}
+
+// snapshotNonce returns the nonce for the given chunk of an encrypted
+// snapshot with the given header, which ends with the nonce prefix.
+func snapshotNonce(header []byte, chunk uint32, final bool) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, header[len(header)-snapshotNoncePrefixSize:])
+	binary.BigEndian.PutUint32(nonce[snapshotNoncePrefixSize:], chunk)
+	if final {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// newSnapshotAEAD returns the cipher for encrypting snapshots with the key.
+func newSnapshotAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// snapshotEncrypter encrypts a snapshot as it's written. Close must be called
+// to write the final chunk, without which the snapshot can't be decrypted.
+type snapshotEncrypter struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	header []byte
+	buf    []byte
+	chunk  uint32
+}
+
+// newSnapshotEncrypter returns a writer which encrypts a snapshot with the
+// key, writing the header right away.
+func newSnapshotEncrypter(w io.Writer, key *snapshotKey) (*snapshotEncrypter, error) {
+	header, aesKey, err := key.newHeader()
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newSnapshotAEAD(aesKey)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &snapshotEncrypter{
+		w:      w,
+		aead:   aead,
+		header: header,
+		buf:    make([]byte, 0, snapshotChunkSize),
+	}, nil
+}
+
+func (e *snapshotEncrypter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		if len(e.buf) == snapshotChunkSize {
+			if err := e.seal(false); err != nil {
+				return n, err
+			}
+		}
+		m := copy(e.buf[len(e.buf):cap(e.buf)], p)
+		e.buf = e.buf[:len(e.buf)+m]
+		p = p[m:]
+		n += m
+	}
+	return n, nil
+}
+
+// Close writes the final chunk. It doesn't close the underlying writer.
+func (e *snapshotEncrypter) Close() error {
+	return e.seal(true)
+}
+
+// seal encrypts and writes the buffered chunk.
+func (e *snapshotEncrypter) seal(final bool) error {
+	if e.chunk == math.MaxUint32 {
+		return fmt.Errorf("snapshot is too large to encrypt")
+	}
+	sealed := e.aead.Seal(nil, snapshotNonce(e.header, e.chunk, final), e.buf, e.header)
+
+	length := uint32(len(sealed))
+	if final {
+		length |= snapshotFinalChunk
+	}
+	var frame [4]byte
+	binary.BigEndian.PutUint32(frame[:], length)
+	if _, err := e.w.Write(frame[:]); err != nil {
+		return err
+	}
+	if _, err := e.w.Write(sealed); err != nil {
+		return err
+	}
+	e.buf = e.buf[:0]
+	e.chunk++
+	return nil
+}
+
+// snapshotDecrypter decrypts a snapshot as it's read. Each chunk is
+// authenticated before any of it is returned, and reading fails if the
+// snapshot ends before its final chunk or has data after it.
+type snapshotDecrypter struct {
+	r      io.Reader
+	aead   cipher.AEAD
+	header []byte
+	chunk  uint32
+	sealed []byte
+	buf    []byte
+	done   bool
+	err    error
+}
+
+// newSnapshotDecrypter returns a reader which decrypts the snapshot read from
+// r with the key, after reading and checking its header.
+func newSnapshotDecrypter(r io.Reader, key *snapshotKey) (*snapshotDecrypter, error) {
+	header, aesKey, err := key.readHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newSnapshotAEAD(aesKey)
+	if err != nil {
+		return nil, err
+	}
+	return &snapshotDecrypter{
+		r:      r,
+		aead:   aead,
+		header: header,
+		sealed: make([]byte, snapshotChunkSize+aead.Overhead()),
+	}, nil
+}
+
+func (d *snapshotDecrypter) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		if d.done {
+			return 0, io.EOF
+		}
+		d.err = d.open()
+	}
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
+
+// open reads and decrypts the next chunk.
+func (d *snapshotDecrypter) open() error {
+	var frame [4]byte
+	if _, err := io.ReadFull(d.r, frame[:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return errSnapshotTruncated
+		}
+		return err
+	}
+	length := binary.BigEndian.Uint32(frame[:])
+	final := length&snapshotFinalChunk != 0
+	length &^= snapshotFinalChunk
+	if length < uint32(d.aead.Overhead()) || length > uint32(len(d.sealed)) {
+		return errSnapshotDecrypt
+	}
+
+	sealed := d.sealed[:length]
+	if _, err := io.ReadFull(d.r, sealed); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return errSnapshotTruncated
+		}
+		return err
+	}
+	plain, err := d.aead.Open(sealed[:0], snapshotNonce(d.header, d.chunk, final), sealed, d.header)
+	if err != nil {
+		return errSnapshotDecrypt
+	}
+	if final {
+		var extra [1]byte
+		n, err := io.ReadFull(d.r, extra[:])
+		if n > 0 {
+			return fmt.Errorf("Unexpected data after the end of the encrypted snapshot")
+		}
+		if err != io.EOF {
+			return err
+		}
+		d.done = true
+	}
+	d.buf = plain
+	d.chunk++
+	return nil
+}
+
+// verifySnapshot verifies the snapshot read from r, decrypting it with the
+// key if one is given. All of an encrypted snapshot is read, so every chunk
+// is authenticated, even past where the verifier stops.
+func verifySnapshot(r io.Reader, key *snapshotKey) (*raft.SnapshotMeta, error) {
+	in, err := snapshotPlaintext(r, key)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := snapshot.Verify(in)
+	if err != nil {
+		return nil, err
+	}
+	if key != nil {
+		if _, err := io.Copy(ioutil.Discard, in); err != nil {
+			return nil, err
+		}
+	}
+	return meta, nil
+}
+
+// snapshotPlaintext returns a reader for the snapshot read from r, which
+// decrypts it with the key if it's encrypted. It's an error to read an
+// encrypted snapshot without a key, or to give a key for one which isn't
+// encrypted.
+func snapshotPlaintext(r io.Reader, key *snapshotKey) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(len(snapshotScryptMagic))
+	encrypted := bytes.HasPrefix(magic, []byte(snapshotEncryptedMagic)) ||
+		bytes.HasPrefix(magic, []byte(snapshotScryptMagic))
+	switch {
+	case encrypted && key == nil:
+		return nil, errSnapshotEncrypted
+	case encrypted:
+		return newSnapshotDecrypter(br, key)
+	case key != nil:
+		return nil, fmt.Errorf("The snapshot is not encrypted, so -decrypt-key can't be used")
+	default:
+		return br, nil
+	}
+}
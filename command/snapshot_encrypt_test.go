@@ -0,0 +1,231 @@
+package command
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testSnapshotEncrypt encrypts the data with the key.
+func testSnapshotEncrypt(t *testing.T, data []byte, key *snapshotKey) []byte {
+	var buf bytes.Buffer
+	enc, err := newSnapshotEncrypter(&buf, key)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := enc.Write(data); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestSnapshotEncrypt_roundTrip(t *testing.T) {
+	key := &snapshotKey{key: bytes.Repeat([]byte{7}, snapshotKeySize)}
+	for _, size := range []int{0, 1, snapshotChunkSize - 1, snapshotChunkSize, 3*snapshotChunkSize + 5} {
+		data := make([]byte, size)
+		rand.Read(data)
+
+		encrypted := testSnapshotEncrypt(t, data, key)
+		if !bytes.HasPrefix(encrypted, []byte(snapshotEncryptedMagic)) {
+			t.Fatalf("%d: missing header", size)
+		}
+		if size > 16 && bytes.Contains(encrypted, data) {
+			t.Fatalf("%d: data is not encrypted", size)
+		}
+
+		in, err := snapshotPlaintext(bytes.NewReader(encrypted), key)
+		if err != nil {
+			t.Fatalf("%d: err: %v", size, err)
+		}
+		decrypted, err := ioutil.ReadAll(in)
+		if err != nil {
+			t.Fatalf("%d: err: %v", size, err)
+		}
+		if !bytes.Equal(decrypted, data) {
+			t.Fatalf("%d: bad: got %d bytes", size, len(decrypted))
+		}
+	}
+}
+
+func TestSnapshotEncrypt_tampered(t *testing.T) {
+	key := &snapshotKey{key: bytes.Repeat([]byte{7}, snapshotKeySize)}
+	data := make([]byte, 2*snapshotChunkSize+100)
+	rand.Read(data)
+	encrypted := testSnapshotEncrypt(t, data, key)
+
+	header := len(snapshotEncryptedMagic) + snapshotNoncePrefixSize
+	flipped := append([]byte{}, encrypted...)
+	flipped[len(flipped)/2] ^= 0x01
+	prefix := append([]byte{}, encrypted...)
+	prefix[header-1] ^= 0x01
+
+	// The final chunk is the last 4+100+16 bytes.
+	final := len(encrypted) - 4 - 100 - 16
+
+	cases := map[string]struct {
+		data []byte
+		key  *snapshotKey
+		err  error
+	}{
+		"wrong key":    {encrypted, &snapshotKey{key: bytes.Repeat([]byte{8}, snapshotKeySize)}, errSnapshotDecrypt},
+		"bit flip":     {flipped, key, errSnapshotDecrypt},
+		"nonce prefix": {prefix, key, errSnapshotDecrypt},
+		"truncated":    {encrypted[:len(encrypted)-1], key, errSnapshotTruncated},
+		"no final":     {encrypted[:final], key, errSnapshotTruncated},
+		"header only":  {encrypted[:header], key, errSnapshotTruncated},
+		"short header": {encrypted[:header-1], key, errSnapshotTruncated},
+	}
+	for name, tc := range cases {
+		in, err := snapshotPlaintext(bytes.NewReader(tc.data), tc.key)
+		if err == nil {
+			_, err = ioutil.ReadAll(in)
+		}
+		if err != tc.err {
+			t.Fatalf("%s: bad: %v", name, err)
+		}
+	}
+
+	// Data after the final chunk is rejected.
+	in, err := snapshotPlaintext(bytes.NewReader(append(encrypted, 0)), key)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := ioutil.ReadAll(in); err == nil || !strings.Contains(err.Error(), "Unexpected data") {
+		t.Fatalf("bad: %v", err)
+	}
+}
+
+func TestSnapshotPlaintext(t *testing.T) {
+	key := &snapshotKey{key: bytes.Repeat([]byte{7}, snapshotKeySize)}
+	encrypted := testSnapshotEncrypt(t, []byte("data"), key)
+
+	if _, err := snapshotPlaintext(bytes.NewReader(encrypted), nil); err != errSnapshotEncrypted {
+		t.Fatalf("bad: %v", err)
+	}
+	_, err := snapshotPlaintext(strings.NewReader("data"), key)
+	if err == nil || !strings.Contains(err.Error(), "not encrypted") {
+		t.Fatalf("bad: %v", err)
+	}
+
+	in, err := snapshotPlaintext(strings.NewReader("data"), nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if data, err := ioutil.ReadAll(in); err != nil || string(data) != "data" {
+		t.Fatalf("bad: %q %v", data, err)
+	}
+}
+
+func TestReadSnapshotKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	key := make([]byte, snapshotKeySize)
+	rand.Read(key)
+	cases := map[string]struct {
+		contents   string
+		key        []byte
+		passphrase string
+		err        string
+	}{
+		"raw":        {string(key), key, "", ""},
+		"hex":        {hex.EncodeToString(key) + "\n", key, "", ""},
+		"base64":     {base64.StdEncoding.EncodeToString(key) + "\n", key, "", ""},
+		"passphrase": {"correct horse battery staple\n", nil, "correct horse battery staple", ""},
+		"text":       {strings.Repeat("x", snapshotKeySize), nil, strings.Repeat("x", snapshotKeySize), ""},
+		"spaces":     {"  spaced out  \r\n", nil, "  spaced out  ", ""},
+		"short hex":  {hex.EncodeToString(key[:16]), nil, "", "holds 16 hex encoded bytes"},
+		"short":      {"hunter2\n", nil, "", "or a passphrase of at least 8 characters"},
+		"binary":     {string(key[:20]) + "\x00", nil, "", "or a passphrase of at least 8 characters"},
+	}
+	for name, tc := range cases {
+		file := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(file, []byte(tc.contents), 0600); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		got, err := readSnapshotKey(file)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("%s: bad: %v", name, err)
+			}
+			continue
+		}
+		if err != nil || !bytes.Equal(got.key, tc.key) || string(got.passphrase) != tc.passphrase {
+			t.Fatalf("%s: bad: %#v %v", name, got, err)
+		}
+	}
+
+	if _, err := readSnapshotKey(filepath.Join(dir, "missing")); err == nil {
+		t.Fatalf("should fail")
+	}
+}
+
+func TestSnapshotEncrypt_passphrase(t *testing.T) {
+	key := &snapshotKey{passphrase: []byte("correct horse battery staple")}
+	data := make([]byte, snapshotChunkSize+100)
+	rand.Read(data)
+
+	encrypted := testSnapshotEncrypt(t, data, key)
+	if !bytes.HasPrefix(encrypted, []byte(snapshotScryptMagic)) {
+		t.Fatalf("missing header")
+	}
+	in, err := snapshotPlaintext(bytes.NewReader(encrypted), key)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if decrypted, err := ioutil.ReadAll(in); err != nil || !bytes.Equal(decrypted, data) {
+		t.Fatalf("bad: got %d bytes, %v", len(decrypted), err)
+	}
+
+	// Each snapshot gets its own salt, and so its own key.
+	again := testSnapshotEncrypt(t, data, key)
+	params := len(snapshotScryptMagic) + 3
+	if bytes.Equal(encrypted[params:params+snapshotSaltSize], again[params:params+snapshotSaltSize]) {
+		t.Fatalf("salt was reused")
+	}
+
+	salt := append([]byte{}, encrypted...)
+	salt[params] ^= 0x01
+	unsupported := append([]byte{}, encrypted...)
+	unsupported[params-3] = 20
+
+	cases := map[string]struct {
+		data []byte
+		key  *snapshotKey
+		err  string
+	}{
+		"wrong passphrase": {encrypted, &snapshotKey{passphrase: []byte("incorrect horse")}, errSnapshotDecrypt.Error()},
+		"salt":             {salt, key, errSnapshotDecrypt.Error()},
+		"key":              {encrypted, &snapshotKey{key: bytes.Repeat([]byte{7}, snapshotKeySize)}, "holds a 32-byte key"},
+		"unsupported":      {unsupported, key, "N=2^20, r=8, p=1 are not supported"},
+		"truncated":        {encrypted[:params], key, errSnapshotTruncated.Error()},
+	}
+	for name, tc := range cases {
+		in, err := snapshotPlaintext(bytes.NewReader(tc.data), tc.key)
+		if err == nil {
+			_, err = ioutil.ReadAll(in)
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Fatalf("%s: bad: %v", name, err)
+		}
+	}
+
+	// A snapshot encrypted with a key can't be decrypted with a passphrase.
+	withKey := testSnapshotEncrypt(t, data, &snapshotKey{key: bytes.Repeat([]byte{7}, snapshotKeySize)})
+	_, err = snapshotPlaintext(bytes.NewReader(withKey), key)
+	if err == nil || !strings.Contains(err.Error(), "holds a passphrase") {
+		t.Fatalf("bad: %v", err)
+	}
+}
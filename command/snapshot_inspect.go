@@ -1,14 +1,28 @@
 package command
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
 	"os"
+	"sort"
 	"strings"
 	"text/tabwriter"
+	"time"
 
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/consul/structs"
 	"github.com/hashicorp/consul/snapshot"
+	"github.com/hashicorp/go-cleanhttp"
+	"github.com/hashicorp/go-msgpack/codec"
+	"github.com/hashicorp/raft"
 	"github.com/mitchellh/cli"
 )
 
@@ -16,19 +30,80 @@ import (
 // metadata about a snapshot file
 type SnapshotInspectCommand struct {
 	Ui cli.Ui
+
+	// testStdin is the input for testing.
+	testStdin io.Reader
+
+	// testStdout is where extracted keys are written for testing.
+	testStdout io.Writer
 }
 
 func (c *SnapshotInspectCommand) Help() string {
 	helpText := `
 Usage: consul snapshot inspect [options] FILE
 
-  Displays information about a snapshot file on disk.
+  Displays information about a snapshot file on disk, including a breakdown
+  of the number and size of the entries of each type it contains.
 
   To inspect the file "backup.snap":
 
     $ consul snapshot inspect backup.snap
 
+  To output the same information as JSON:
+
+    $ consul snapshot inspect -format=json backup.snap
+
+  To read the snapshot from stdin, use "-" as the file name. The snapshot is
+  read in a single pass, so it can be streamed from elsewhere:
+
+    $ curl -s https://backups.example.com/backup.snap | consul snapshot inspect -
+
+  An http:// or https:// URL can be given in place of the file name, and is
+  downloaded as it's read. With -quick, only the start of it is downloaded:
+
+    $ consul snapshot inspect -quick https://backups.example.com/backup.snap
+
+  If there's a metadata file next to the snapshot, saved by "consul snapshot
+  save -meta", the datacenter, agent version, leader, time, and SHA-256 from
+  it are shown too. A warning is printed if it doesn't match the snapshot.
+
+  To recover keys without restoring the whole snapshot, extract the keys under
+  a prefix in the same JSON format as "consul kv export", and then import just
+  those with "consul kv import":
+
+    $ consul snapshot inspect -extract-kv=app/ -output=app.json backup.snap
+    $ consul kv import @app.json
+
   For a full list of options and examples, please see the Consul documentation.
+
+Snapshot Inspect Options:
+
+  -decrypt-key=<path>     Decrypt a snapshot saved with "consul snapshot save
+                          -encrypt-key", using the key or passphrase in the
+                          given file.
+
+  -extract-kv=<prefix>    Write the keys under the given prefix in the
+                          snapshot, sorted, in the format of "consul kv
+                          export" instead of displaying information about the
+                          snapshot. Use "" for every key.
+
+  -format=<string>        Output format. One of "text" or "json". The default
+                          value is "text".
+
+  -output=<path>          Write the keys extracted by -extract-kv to the given
+                          file instead of stdout. The file is only replaced
+                          once every key has been written.
+
+  -quick                  Only read the metadata at the start of the snapshot,
+                          and stop there. This is much faster for a large
+                          snapshot, but the snapshot isn't verified, since its
+                          checksums are at the end, and the entries aren't
+                          counted. The default value is false.
+
+  -url-timeout=<duration> Maximum time to wait to connect to a URL given as
+                          FILE, and then for each part of it to arrive. The
+                          download as a whole can take longer. The default
+                          value is 30s.
 `
 
 	return strings.TrimSpace(helpText)
@@ -37,7 +112,33 @@ Usage: consul snapshot inspect [options] FILE
 func (c *SnapshotInspectCommand) Run(args []string) int {
 	cmdFlags := flag.NewFlagSet("get", flag.ContinueOnError)
 	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
-	if err := cmdFlags.Parse(args); err != nil {
+	format := cmdFlags.String("format", "text", "")
+	quick := cmdFlags.Bool("quick", false, "")
+	decryptKey := cmdFlags.String("decrypt-key", "", "")
+	extractKV := cmdFlags.String("extract-kv", "", "")
+	output := cmdFlags.String("output", "", "")
+	urlTimeout := cmdFlags.Duration("url-timeout", 30*time.Second, "")
+	if err := parseFlags(c.Ui, cmdFlags, args); err != nil {
+		return 1
+	}
+
+	set := make(map[string]bool)
+	cmdFlags.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	extract := set["extract-kv"]
+	switch {
+	case set["output"] && !extract:
+		c.Ui.Error("Error! -output can only be used with -extract-kv")
+		return 1
+	case extract && *quick:
+		c.Ui.Error("Error! -quick can't be used with -extract-kv, which has to read the whole snapshot")
+		return 1
+	case extract && set["format"]:
+		c.Ui.Error("Error! -format can't be used with -extract-kv, which always writes JSON")
+		return 1
+	}
+
+	if *format != "text" && *format != "json" {
+		c.Ui.Error(fmt.Sprintf("Unsupported format %q (expected text or json)", *format))
 		return 1
 	}
 
@@ -55,17 +156,126 @@ func (c *SnapshotInspectCommand) Run(args []string) int {
 		return 1
 	}
 
-	// Open the file.
-	f, err := os.Open(file)
-	if err != nil {
-		c.Ui.Error(fmt.Sprintf("Error opening snapshot file: %s", err))
+	var key *snapshotKey
+	var err error
+	if *decryptKey != "" {
+		if key, err = readSnapshotKey(*decryptKey); err != nil {
+			c.Ui.Error(fmt.Sprintf("Error! %s", err))
+			return 1
+		}
+	}
+
+	if *urlTimeout <= 0 {
+		c.Ui.Error("Error! -url-timeout must be greater than zero")
 		return 1
 	}
-	defer f.Close()
+	url := isSnapshotURL(file)
 
-	meta, err := snapshot.Verify(f)
-	if err != nil {
-		c.Ui.Error(fmt.Sprintf("Error verifying snapshot: %s", err))
+	// Look for a metadata file saved alongside the snapshot.
+	var sidecar *snapshotMetaFile
+	if file != "-" && !url {
+		if sidecar, err = readSnapshotMetaFile(file); err != nil {
+			c.Ui.Warn(fmt.Sprintf("Warning! Ignoring the snapshot metadata file: %s", err))
+		}
+	}
+
+	// Open the file, or read from stdin or a URL.
+	var in io.Reader
+	switch {
+	case file == "-":
+		in = os.Stdin
+		if c.testStdin != nil {
+			in = c.testStdin
+		}
+	case url:
+		body, err := openSnapshotURL(file, *urlTimeout)
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Error downloading snapshot: %s", err))
+			return 1
+		}
+		defer body.Close()
+		in = body
+	default:
+		f, err := os.Open(file)
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Error opening snapshot file: %s", err))
+			return 1
+		}
+		defer f.Close()
+		in = f
+	}
+
+	// Hash the snapshot as it's read, to check it against the metadata file.
+	// The sum is of the file as saved, so it's taken before decrypting.
+	h := sha256.New()
+	if sidecar != nil && !*quick {
+		in = io.TeeReader(in, h)
+	}
+	if in, err = snapshotPlaintext(in, key); err != nil {
+		c.Ui.Error(fmt.Sprintf("Error reading snapshot: %s", err))
+		return 1
+	}
+
+	var meta *raft.SnapshotMeta
+	var stats []*snapshotTypeStats
+	var pairs api.KVPairs
+	if extract {
+		meta, pairs, err = extractSnapshotKV(in, *extractKV)
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Error verifying snapshot: %s", err))
+			return 1
+		}
+	} else if *quick {
+		meta, err = snapshot.ReadMeta(in)
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Error reading snapshot: %s", err))
+			return 1
+		}
+	} else {
+		meta, stats, err = inspectSnapshot(in)
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Error verifying snapshot: %s", err))
+			return 1
+		}
+	}
+
+	if sidecar != nil {
+		var sum []byte
+		if !*quick {
+			// The snapshot may end with padding the verifier didn't need.
+			if _, err := io.Copy(ioutil.Discard, in); err != nil {
+				c.Ui.Error(fmt.Sprintf("Error reading snapshot: %s", err))
+				return 1
+			}
+			sum = h.Sum(nil)
+		}
+		if problem := sidecar.mismatch(meta, sum); problem != "" {
+			c.Ui.Warn(fmt.Sprintf("Warning! The snapshot metadata file %s doesn't match the snapshot: %s",
+				snapshotMetaPath(file), problem))
+		}
+	}
+
+	if extract {
+		return c.writeKV(pairs, *extractKV, *output)
+	}
+
+	if *format == "json" {
+		out := &snapshotInspectOutput{
+			ID:       meta.ID,
+			Size:     meta.Size,
+			Index:    meta.Index,
+			Term:     meta.Term,
+			Version:  int(meta.Version),
+			Metadata: sidecar,
+			Types:    stats,
+		}
+		b, err := json.MarshalIndent(out, "", "  ")
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Error rendering snapshot info: %s", err))
+			return 1
+		}
+		c.Ui.Output(string(b))
+		return 0
 	}
 
 	var b bytes.Buffer
@@ -75,8 +285,28 @@ func (c *SnapshotInspectCommand) Run(args []string) int {
 	fmt.Fprintf(tw, "Index\t%d\n", meta.Index)
 	fmt.Fprintf(tw, "Term\t%d\n", meta.Term)
 	fmt.Fprintf(tw, "Version\t%d\n", meta.Version)
+	if sidecar != nil {
+		leader := sidecar.Leader
+		if leader == "" {
+			leader = "(none)"
+		}
+		fmt.Fprintf(tw, "\n")
+		fmt.Fprintf(tw, "Datacenter\t%s\n", sidecar.Datacenter)
+		fmt.Fprintf(tw, "Agent Version\t%s\n", sidecar.AgentVersion)
+		fmt.Fprintf(tw, "Leader\t%s\n", leader)
+		fmt.Fprintf(tw, "Saved\t%s\n", sidecar.Time.Format(time.RFC3339))
+		fmt.Fprintf(tw, "SHA-256\t%s\n", sidecar.SHA256)
+	}
+	if !*quick {
+		fmt.Fprintf(tw, "\n")
+		fmt.Fprintf(tw, "Type\tCount\tSize\n")
+		for _, s := range stats {
+			fmt.Fprintf(tw, "%s\t%d\t%d\n", s.Name, s.Count, s.Size)
+		}
+	}
 	if err = tw.Flush(); err != nil {
 		c.Ui.Error(fmt.Sprintf("Error rendering snapshot info: %s", err))
+		return 1
 	}
 
 	c.Ui.Info(b.String())
@@ -84,6 +314,366 @@ func (c *SnapshotInspectCommand) Run(args []string) int {
 	return 0
 }
 
+// writeKV writes the keys extracted from a snapshot in the kv export format,
+// to the given file or to stdout.
+func (c *SnapshotInspectCommand) writeKV(pairs api.KVPairs, prefix, path string) int {
+	var stdout io.Writer = os.Stdout
+	if c.testStdout != nil {
+		stdout = c.testStdout
+	}
+	out, err := newKVExportOutput(path, stdout, false, "")
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error! %s", err))
+		return 1
+	}
+	defer out.Abort()
+
+	w, err := newKVEntryWriter("json", true, false, out.WriteLine)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error! %s", err))
+		return 1
+	}
+	for _, pair := range pairs {
+		if err := w.WriteEntry(toExportEntry(pair)); err != nil {
+			c.Ui.Error(fmt.Sprintf("Error writing keys: %s", err))
+			return 1
+		}
+	}
+	if err := w.Flush(); err != nil {
+		c.Ui.Error(fmt.Sprintf("Error writing keys: %s", err))
+		return 1
+	}
+	if err := out.Commit(); err != nil {
+		c.Ui.Error(fmt.Sprintf("Error writing keys: %s", err))
+		return 1
+	}
+
+	if len(pairs) == 0 {
+		c.Ui.Warn(fmt.Sprintf("Warning! There are no keys under %q in the snapshot", prefix))
+	} else if path != "" {
+		c.Ui.Info(fmt.Sprintf("Extracted %d %s under %q to %s", len(pairs), pluralKeys(len(pairs)), prefix, path))
+	}
+	return 0
+}
+
 func (c *SnapshotInspectCommand) Synopsis() string {
 	return "Displays information about a Consul snapshot file"
 }
+
+// snapshotInspectOutput is the output of the inspect command when using the
+// JSON format. Metadata is left out if there's no metadata file, and Types is
+// left out with -quick.
+type snapshotInspectOutput struct {
+	ID       string
+	Size     int64
+	Index    uint64
+	Term     uint64
+	Version  int
+	Metadata *snapshotMetaFile    `json:",omitempty"`
+	Types    []*snapshotTypeStats `json:",omitempty"`
+}
+
+// snapshotTypeStats records the number of entries of a given type in a
+// snapshot's state, and their total encoded size in bytes.
+type snapshotTypeStats struct {
+	Name  string
+	Count int
+	Size  int64
+}
+
+// snapshotTypeNames are the names used to report each type of entry, in the
+// order they are reported. Entries of unknown types are counted as "Other",
+// so snapshots from newer versions of Consul can still be inspected.
+var snapshotTypeNames = []string{
+	"Node",
+	"Service",
+	"Check",
+	"KV",
+	"Tombstone",
+	"Session",
+	"ACL Token",
+	"Prepared Query",
+	"Coordinate",
+	"Other",
+}
+
+// inspectSnapshot verifies the snapshot from the given reader and decodes its
+// state to count the entries of each type. This is a single pass over the
+// reader, so it doesn't need to be seekable.
+func inspectSnapshot(in io.Reader) (*raft.SnapshotMeta, []*snapshotTypeStats, error) {
+	var stats []*snapshotTypeStats
+	meta, err := readSnapshotState(in, func(state io.Reader) error {
+		var err error
+		stats, err = decodeSnapshotState(state)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return meta, stats, nil
+}
+
+// extractSnapshotKV verifies the snapshot from the given reader and returns
+// the keys under the given prefix in its state, sorted by key.
+func extractSnapshotKV(in io.Reader, prefix string) (*raft.SnapshotMeta, api.KVPairs, error) {
+	var pairs api.KVPairs
+	meta, err := readSnapshotState(in, func(state io.Reader) error {
+		var err error
+		pairs, err = decodeSnapshotKV(state, prefix)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Sort(kvPairsByKey(pairs))
+	return meta, pairs, nil
+}
+
+// readSnapshotState verifies the snapshot from the given reader, passing its
+// state to decode as it's read. The state is read through a pipe so it never
+// has to be held in memory.
+func readSnapshotState(in io.Reader, decode func(io.Reader) error) (*raft.SnapshotMeta, error) {
+	pr, pw := io.Pipe()
+	type result struct {
+		meta *raft.SnapshotMeta
+		err  error
+	}
+	doneCh := make(chan result, 1)
+	go func() {
+		meta, err := snapshot.Read(in, pw)
+		pw.CloseWithError(err)
+		doneCh <- result{meta, err}
+	}()
+
+	decodeErr := decode(pr)
+
+	// Drain anything left over so the reader can finish its checks, which
+	// take precedence over any decoding errors.
+	io.Copy(ioutil.Discard, pr)
+	res := <-doneCh
+	if res.err != nil {
+		return nil, res.err
+	}
+	if decodeErr != nil {
+		return nil, fmt.Errorf("failed to decode snapshot state: %v", decodeErr)
+	}
+	return res.meta, nil
+}
+
+// decodeSnapshotState walks the entries in the state from a snapshot,
+// counting the number and size of the entries of each type. The state is a
+// msgpack encoded header followed by a series of entries, each of which is a
+// message type byte and a msgpack encoded value.
+func decodeSnapshotState(r io.Reader) ([]*snapshotTypeStats, error) {
+	stats := make(map[string]*snapshotTypeStats, len(snapshotTypeNames))
+	var sorted []*snapshotTypeStats
+	for _, name := range snapshotTypeNames {
+		s := &snapshotTypeStats{Name: name}
+		stats[name] = s
+		sorted = append(sorted, s)
+	}
+
+	cr := &countingReader{r: bufio.NewReader(r)}
+	dec := codec.NewDecoder(cr, &codec.MsgpackHandle{})
+
+	var header interface{}
+	if err := dec.Decode(&header); err != nil {
+		return nil, err
+	}
+
+	for {
+		start := cr.n
+		msgType, err := cr.ReadByte()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		var name string
+		switch structs.MessageType(msgType) {
+		case structs.RegisterRequestType:
+			// Nodes, services, and checks are all saved as registrations,
+			// so look inside to see which this is.
+			var req structs.RegisterRequest
+			if err := dec.Decode(&req); err != nil {
+				return nil, err
+			}
+			switch {
+			case req.Service != nil:
+				name = "Service"
+			case req.Check != nil:
+				name = "Check"
+			default:
+				name = "Node"
+			}
+		default:
+			var value interface{}
+			if err := dec.Decode(&value); err != nil {
+				return nil, err
+			}
+			name = snapshotTypeName(structs.MessageType(msgType))
+		}
+
+		s := stats[name]
+		s.Count++
+		s.Size += cr.n - start
+	}
+
+	return sorted, nil
+}
+
+// snapshotTypeName returns the name used to report entries of the given
+// message type, other than registrations.
+func snapshotTypeName(t structs.MessageType) string {
+	switch t {
+	case structs.KVSRequestType:
+		return "KV"
+	case structs.TombstoneRequestType:
+		return "Tombstone"
+	case structs.SessionRequestType:
+		return "Session"
+	case structs.ACLRequestType:
+		return "ACL Token"
+	case structs.PreparedQueryRequestType:
+		return "Prepared Query"
+	case structs.CoordinateBatchUpdateType:
+		return "Coordinate"
+	default:
+		return "Other"
+	}
+}
+
+// decodeSnapshotKV walks the entries in the state from a snapshot like
+// decodeSnapshotState, returning the keys under the given prefix. Entries of
+// every other type, including unknown ones, are skipped.
+func decodeSnapshotKV(r io.Reader, prefix string) (api.KVPairs, error) {
+	br := bufio.NewReader(r)
+	dec := codec.NewDecoder(br, &codec.MsgpackHandle{})
+
+	var header interface{}
+	if err := dec.Decode(&header); err != nil {
+		return nil, err
+	}
+
+	var pairs api.KVPairs
+	for {
+		msgType, err := br.ReadByte()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		if structs.MessageType(msgType) != structs.KVSRequestType {
+			var skip interface{}
+			if err := dec.Decode(&skip); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		var entry structs.DirEntry
+		if err := dec.Decode(&entry); err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(entry.Key, prefix) {
+			continue
+		}
+		pairs = append(pairs, &api.KVPair{
+			Key:         entry.Key,
+			CreateIndex: entry.CreateIndex,
+			ModifyIndex: entry.ModifyIndex,
+			LockIndex:   entry.LockIndex,
+			Flags:       entry.Flags,
+			Value:       entry.Value,
+			Session:     entry.Session,
+		})
+	}
+	return pairs, nil
+}
+
+// isSnapshotURL returns true if the snapshot file name is an http:// or
+// https:// URL.
+func isSnapshotURL(file string) bool {
+	return strings.HasPrefix(file, "http://") || strings.HasPrefix(file, "https://")
+}
+
+// openSnapshotURL starts downloading the snapshot at the given URL, checking
+// that the server has it. The timeout applies to connecting and to each wait
+// for more of the body, rather than to the whole download, which can take a
+// while for a large snapshot.
+func openSnapshotURL(url string, timeout time.Duration) (io.ReadCloser, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &snapshotDownload{
+		timer:   time.AfterFunc(timeout, cancel),
+		timeout: timeout,
+		cancel:  cancel,
+	}
+
+	resp, err := cleanhttp.DefaultClient().Do(req.WithContext(ctx))
+	if err != nil {
+		d.Close()
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("No response from %s within %s", url, timeout)
+		}
+		return nil, err
+	}
+	d.body = resp.Body
+	if resp.StatusCode != http.StatusOK {
+		d.Close()
+		return nil, fmt.Errorf("Unexpected response from %s: %s", url, resp.Status)
+	}
+	return d, nil
+}
+
+// snapshotDownload is the body of a snapshot being downloaded, which gives
+// up if nothing arrives for the timeout.
+type snapshotDownload struct {
+	body    io.ReadCloser
+	timer   *time.Timer
+	timeout time.Duration
+	cancel  context.CancelFunc
+}
+
+func (d *snapshotDownload) Read(p []byte) (int, error) {
+	n, err := d.body.Read(p)
+	if !d.timer.Stop() {
+		return n, fmt.Errorf("No data received for %s", d.timeout)
+	}
+	d.timer.Reset(d.timeout)
+	return n, err
+}
+
+func (d *snapshotDownload) Close() error {
+	d.timer.Stop()
+	d.cancel()
+	if d.body == nil {
+		return nil
+	}
+	return d.body.Close()
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+func (r *countingReader) ReadByte() (byte, error) {
+	b, err := r.r.ReadByte()
+	if err == nil {
+		r.n++
+	}
+	return b, err
+}
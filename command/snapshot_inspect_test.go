@@ -1,13 +1,24 @@
 package command
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"io"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/consul/structs"
+	"github.com/hashicorp/go-msgpack/codec"
 	"github.com/mitchellh/cli"
 )
 
@@ -35,6 +46,18 @@ func TestSnapshotInspectCommand_Validation(t *testing.T) {
 			[]string{"foo", "bar", "baz"},
 			"Too many arguments",
 		},
+		"output without extract": {
+			[]string{"-output=app.json", "foo"},
+			"-output can only be used with -extract-kv",
+		},
+		"extract with quick": {
+			[]string{"-extract-kv=app/", "-quick", "foo"},
+			"-quick can't be used with -extract-kv",
+		},
+		"extract with format": {
+			[]string{"-extract-kv=app/", "-format=json", "foo"},
+			"-format can't be used with -extract-kv",
+		},
 	}
 
 	for name, tc := range cases {
@@ -114,3 +137,489 @@ func TestSnapshotInspectCommand_Run(t *testing.T) {
 		}
 	}
 }
+
+func TestSnapshotInspectCommand_JSON(t *testing.T) {
+	ui := new(cli.MockUi)
+	c := &SnapshotInspectCommand{Ui: ui}
+
+	// The fixture was saved from an agent with a few keys, a service with a
+	// check, a session, and a prepared query. The output is compared exactly
+	// so any change to the schema is caught.
+	args := []string{"-format=json", "test-fixtures/snapshot/backup.snap"}
+	code := c.Run(args)
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+
+	expected := `{
+  "ID": "2-11-1792049206106",
+  "Size": 1749,
+  "Index": 11,
+  "Term": 2,
+  "Version": 1,
+  "Types": [
+    {
+      "Name": "Node",
+      "Count": 1,
+      "Size": 130
+    },
+    {
+      "Name": "Service",
+      "Count": 2,
+      "Size": 438
+    },
+    {
+      "Name": "Check",
+      "Count": 2,
+      "Size": 564
+    },
+    {
+      "Name": "KV",
+      "Count": 3,
+      "Size": 245
+    },
+    {
+      "Name": "Tombstone",
+      "Count": 0,
+      "Size": 0
+    },
+    {
+      "Name": "Session",
+      "Count": 1,
+      "Size": 152
+    },
+    {
+      "Name": "ACL Token",
+      "Count": 0,
+      "Size": 0
+    },
+    {
+      "Name": "Prepared Query",
+      "Count": 1,
+      "Size": 208
+    },
+    {
+      "Name": "Coordinate",
+      "Count": 0,
+      "Size": 0
+    },
+    {
+      "Name": "Other",
+      "Count": 0,
+      "Size": 0
+    }
+  ]
+}
+`
+	if output := ui.OutputWriter.String(); output != expected {
+		t.Fatalf("bad: %s", output)
+	}
+}
+
+func TestSnapshotInspectCommand_meta(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	data, err := ioutil.ReadFile("test-fixtures/snapshot/backup.snap")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	file := path.Join(dir, "backup.snap")
+	if err := ioutil.WriteFile(file, data, 0600); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	sum := sha256.Sum256(data)
+	m := &snapshotMetaFile{
+		Datacenter:   "dc2",
+		AgentVersion: "0.7.3",
+		Leader:       "10.0.1.5:8300",
+		Index:        11,
+		Term:         2,
+		Time:         time.Date(2017, 2, 3, 4, 5, 6, 0, time.UTC),
+		SHA256:       hex.EncodeToString(sum[:]),
+	}
+	if err := writeSnapshotMetaFile(file, m); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	run := func(args ...string) (string, string) {
+		ui := new(cli.MockUi)
+		c := &SnapshotInspectCommand{Ui: ui}
+		if code := c.Run(append(args, file)); code != 0 {
+			t.Fatalf("%v: bad: %d. %#v", args, code, ui.ErrorWriter.String())
+		}
+		return ui.OutputWriter.String(), ui.ErrorWriter.String()
+	}
+
+	output, errOut := run()
+	for _, line := range []string{
+		"Datacenter         dc2\n",
+		"Agent Version      0.7.3\n",
+		"Leader             10.0.1.5:8300\n",
+		"Saved              2017-02-03T04:05:06Z\n",
+		"SHA-256            " + m.SHA256 + "\n",
+	} {
+		if !strings.Contains(output, line) {
+			t.Fatalf("bad: %#v missing %q", output, line)
+		}
+	}
+	if errOut != "" {
+		t.Fatalf("bad: %#v", errOut)
+	}
+
+	output, _ = run("-format=json")
+	var out snapshotInspectOutput
+	if err := json.Unmarshal([]byte(output), &out); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if out.Metadata == nil || *out.Metadata != *m {
+		t.Fatalf("bad: %#v", out.Metadata)
+	}
+
+	// A metadata file for another snapshot is reported, but the checksum
+	// can only be checked when the whole snapshot is read.
+	m.SHA256 = strings.Repeat("0", 64)
+	if err := writeSnapshotMetaFile(file, m); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, errOut := run(); !strings.Contains(errOut, "doesn't match the snapshot: it has SHA-256 0000") {
+		t.Fatalf("bad: %#v", errOut)
+	}
+	if _, errOut := run("-quick"); errOut != "" {
+		t.Fatalf("bad: %#v", errOut)
+	}
+	m.Index = 12
+	if err := writeSnapshotMetaFile(file, m); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, errOut := run("-quick"); !strings.Contains(errOut, "it's for index 12 and term 2, but the snapshot is at index 11") {
+		t.Fatalf("bad: %#v", errOut)
+	}
+
+	// A metadata file which can't be parsed is ignored with a warning.
+	if err := ioutil.WriteFile(snapshotMetaPath(file), []byte("{"), 0600); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	output, errOut = run()
+	if !strings.Contains(errOut, "Warning! Ignoring the snapshot metadata file") || strings.Contains(output, "Datacenter") {
+		t.Fatalf("bad: %#v %#v", output, errOut)
+	}
+}
+
+func TestSnapshotInspectCommand_stdin(t *testing.T) {
+	const fixture = "test-fixtures/snapshot/backup.snap"
+
+	run := func(args []string, stdin io.Reader) string {
+		ui := new(cli.MockUi)
+		c := &SnapshotInspectCommand{Ui: ui, testStdin: stdin}
+		if code := c.Run(args); code != 0 {
+			t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+		}
+		return ui.OutputWriter.String()
+	}
+
+	// Feed the snapshot through a pipe, so it can't be seeked.
+	pipe := func() io.Reader {
+		pr, pw := io.Pipe()
+		go func() {
+			f, err := os.Open(fixture)
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			defer f.Close()
+			_, err = io.Copy(pw, f)
+			pw.CloseWithError(err)
+		}()
+		return pr
+	}
+
+	for _, args := range [][]string{
+		{},
+		{"-format=json"},
+		{"-quick"},
+		{"-quick", "-format=json"},
+	} {
+		expected := run(append(args, fixture), nil)
+		if actual := run(append(args, "-"), pipe()); actual != expected {
+			t.Fatalf("%v: expected %q, got %q", args, expected, actual)
+		}
+	}
+
+	// A quick look only has the metadata.
+	full := run([]string{fixture}, nil)
+	quick := run([]string{"-quick", fixture}, nil)
+	if !strings.HasPrefix(full, strings.TrimSpace(quick)) || strings.Contains(quick, "Count") {
+		t.Fatalf("bad: %q", quick)
+	}
+	quick = run([]string{"-quick", "-format=json", fixture}, nil)
+	if !strings.Contains(quick, `"Index": 11`) || strings.Contains(quick, "Types") {
+		t.Fatalf("bad: %q", quick)
+	}
+}
+
+func TestSnapshotInspectCommand_url(t *testing.T) {
+	const fixture = "test-fixtures/snapshot/backup.snap"
+
+	unblock := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/backup.snap":
+			http.ServeFile(w, r, fixture)
+		case "/stalled.snap":
+			// Send the start of the snapshot, and then nothing more.
+			data, err := ioutil.ReadFile(fixture)
+			if err != nil {
+				t.Errorf("err: %v", err)
+				return
+			}
+			w.Write(data[:512])
+			w.(http.Flusher).Flush()
+			<-unblock
+		case "/slow.snap":
+			<-unblock
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer func() {
+		// The server waits for the blocked requests before it closes.
+		close(unblock)
+		srv.Close()
+	}()
+
+	run := func(args ...string) (int, string, string) {
+		ui := new(cli.MockUi)
+		c := &SnapshotInspectCommand{Ui: ui}
+		code := c.Run(args)
+		return code, ui.OutputWriter.String(), ui.ErrorWriter.String()
+	}
+
+	for _, args := range [][]string{
+		{},
+		{"-format=json"},
+		{"-quick"},
+	} {
+		_, expected, _ := run(append(args, fixture)...)
+		code, actual, errOut := run(append(args, srv.URL+"/backup.snap")...)
+		if code != 0 {
+			t.Fatalf("%v: bad: %d. %#v", args, code, errOut)
+		}
+		if actual != expected {
+			t.Fatalf("%v: expected %q, got %q", args, expected, actual)
+		}
+	}
+
+	cases := map[string]string{
+		srv.URL + "/missing.snap": "Unexpected response from " + srv.URL + "/missing.snap: 404 Not Found",
+		srv.URL + "/slow.snap":    "No response from " + srv.URL + "/slow.snap within 100ms",
+		srv.URL + "/stalled.snap": "No data received for 100ms",
+	}
+	for url, expected := range cases {
+		code, _, errOut := run("-url-timeout=100ms", url)
+		if code != 1 {
+			t.Fatalf("%s: bad: %d", url, code)
+		}
+		if !strings.Contains(errOut, expected) {
+			t.Fatalf("%s: bad: %#v", url, errOut)
+		}
+	}
+}
+
+func TestSnapshotInspectCommand_badFormat(t *testing.T) {
+	ui := new(cli.MockUi)
+	c := &SnapshotInspectCommand{Ui: ui}
+
+	code := c.Run([]string{"-format=xml", "test-fixtures/snapshot/backup.snap"})
+	if code != 1 {
+		t.Fatalf("bad: %d", code)
+	}
+	if !strings.Contains(ui.ErrorWriter.String(), "Unsupported format") {
+		t.Fatalf("bad: %#v", ui.ErrorWriter.String())
+	}
+}
+
+func TestSnapshotInspectCommand_unknownTypes(t *testing.T) {
+	var buf bytes.Buffer
+	enc := codec.NewEncoder(&buf, &codec.MsgpackHandle{})
+	if err := enc.Encode(map[string]uint64{"LastIndex": 42}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Write a known entry followed by one from a newer version of Consul.
+	buf.WriteByte(byte(structs.KVSRequestType))
+	if err := enc.Encode(&structs.DirEntry{Key: "foo"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	start := buf.Len()
+	buf.WriteByte(byte(structs.IgnoreUnknownTypeFlag | 42))
+	if err := enc.Encode(map[string]string{"Hello": "world"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	otherSize := int64(buf.Len() - start)
+
+	stats, err := decodeSnapshotState(&buf)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	counts := make(map[string]*snapshotTypeStats)
+	for _, s := range stats {
+		counts[s.Name] = s
+	}
+	if counts["KV"].Count != 1 {
+		t.Fatalf("bad: %#v", counts["KV"])
+	}
+	if counts["Other"].Count != 1 || counts["Other"].Size != otherSize {
+		t.Fatalf("bad: %#v", counts["Other"])
+	}
+}
+
+func TestSnapshotInspectCommand_extractKV(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	keys := map[string]string{
+		"app/config/b": "2",
+		"app/config/a": "1",
+		"app/db/port":  "5432",
+		"other/key":    "x",
+	}
+	for k, v := range keys {
+		if _, err := client.KV().Put(&api.KVPair{Key: k, Flags: 42, Value: []byte(v)}, nil); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	snap, _, err := client.Snapshot().Save(nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	data, err := ioutil.ReadAll(snap)
+	snap.Close()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	file := path.Join(dir, "backup.snap")
+	if err := ioutil.WriteFile(file, data, 0600); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Lose the config, and a key written after the snapshot.
+	if _, err := client.KV().DeleteTree("app/config/", nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := client.KV().Put(&api.KVPair{Key: "app/config/c", Value: []byte("3")}, nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Extract to stdout, which is sorted and only has the prefix.
+	ui := new(cli.MockUi)
+	var stdout bytes.Buffer
+	c := &SnapshotInspectCommand{Ui: ui, testStdout: &stdout}
+	if code := c.Run([]string{"-extract-kv=app/", file}); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	var entries []*kvExportEntry
+	if err := json.Unmarshal(stdout.Bytes(), &entries); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Key)
+		if e.Flags != 42 || e.ModifyIndex == 0 {
+			t.Fatalf("bad: %#v", e)
+		}
+	}
+	if want := "app/config/a app/config/b app/db/port"; strings.Join(got, " ") != want {
+		t.Fatalf("bad: %v", got)
+	}
+
+	// Extract just the lost config to a file, and import it.
+	ui = new(cli.MockUi)
+	out := path.Join(dir, "config.json")
+	c = &SnapshotInspectCommand{Ui: ui}
+	if code := c.Run([]string{"-extract-kv=app/config/", "-output=" + out, file}); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if msg := ui.OutputWriter.String(); !strings.Contains(msg, `Extracted 2 keys under "app/config/" to `+out) {
+		t.Fatalf("bad: %#v", msg)
+	}
+
+	ui = new(cli.MockUi)
+	im := &KVImportCommand{Ui: ui}
+	if code := im.Run([]string{"-http-addr=" + srv.httpAddr, "@" + out}); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+
+	pairs, _, err := client.KV().List("", nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	actual := make(map[string]string)
+	for _, pair := range pairs {
+		actual[pair.Key] = string(pair.Value)
+	}
+	keys["app/config/c"] = "3"
+	if !reflect.DeepEqual(actual, keys) {
+		t.Fatalf("bad: %v", actual)
+	}
+
+	// A prefix with no keys still writes a valid, empty export.
+	ui = new(cli.MockUi)
+	stdout.Reset()
+	c = &SnapshotInspectCommand{Ui: ui, testStdout: &stdout}
+	if code := c.Run([]string{"-extract-kv=nope/", file}); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if stdout.String() != "[]\n" {
+		t.Fatalf("bad: %q", stdout.String())
+	}
+	if msg := ui.ErrorWriter.String(); !strings.Contains(msg, `no keys under "nope/"`) {
+		t.Fatalf("bad: %#v", msg)
+	}
+}
+
+func TestDecodeSnapshotKV(t *testing.T) {
+	var buf bytes.Buffer
+	enc := codec.NewEncoder(&buf, &codec.MsgpackHandle{})
+	if err := enc.Encode(map[string]uint64{"LastIndex": 42}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Keys are mixed with other entries, including an unknown type.
+	buf.WriteByte(byte(structs.KVSRequestType))
+	if err := enc.Encode(&structs.DirEntry{Key: "app/a", Value: []byte("1")}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	buf.WriteByte(byte(structs.SessionRequestType))
+	if err := enc.Encode(&structs.Session{ID: "abc"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	buf.WriteByte(byte(structs.IgnoreUnknownTypeFlag | 42))
+	if err := enc.Encode(map[string]string{"Key": "app/b"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	buf.WriteByte(byte(structs.KVSRequestType))
+	if err := enc.Encode(&structs.DirEntry{Key: "other"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	pairs, err := decodeSnapshotKV(&buf, "app/")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(pairs) != 1 || pairs[0].Key != "app/a" || string(pairs[0].Value) != "1" {
+		t.Fatalf("bad: %#v", pairs)
+	}
+}
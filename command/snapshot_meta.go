@@ -0,0 +1,131 @@
+package command
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/raft"
+)
+
+// snapshotMetaSuffix is added to the name of a snapshot file to get the name
+// of the metadata file saved alongside it with -meta.
+const snapshotMetaSuffix = ".meta.json"
+
+// snapshotMetaFile is the metadata saved alongside a snapshot with -meta, so
+// a snapshot can be traced back to the cluster it came from without
+// restoring it.
+type snapshotMetaFile struct {
+	Datacenter   string
+	AgentVersion string
+	Leader       string
+	Index        uint64
+	Term         uint64
+	Time         time.Time
+	SHA256       string
+}
+
+// snapshotMetaPath returns the path of the metadata file for a snapshot.
+func snapshotMetaPath(file string) string {
+	return file + snapshotMetaSuffix
+}
+
+// newSnapshotMetaFile looks up the details of the cluster a snapshot was
+// taken from, in the given datacenter or the agent's datacenter if none is
+// given. The leader is empty if the servers don't know of one.
+func newSnapshotMetaFile(client *api.Client, dc string, meta *raft.SnapshotMeta, sum []byte,
+	taken time.Time) (*snapshotMetaFile, error) {
+	self, err := client.Agent().Self()
+	if err != nil {
+		return nil, err
+	}
+	if dc == "" {
+		dc, _ = self["Config"]["Datacenter"].(string)
+	}
+	version, _ := self["Config"]["Version"].(string)
+	if pre, _ := self["Config"]["VersionPrerelease"].(string); pre != "" {
+		version += "-" + pre
+	}
+
+	leader, err := client.Status().Leader()
+	if err != nil {
+		return nil, err
+	}
+
+	return &snapshotMetaFile{
+		Datacenter:   dc,
+		AgentVersion: version,
+		Leader:       leader,
+		Index:        meta.Index,
+		Term:         meta.Term,
+		Time:         taken.UTC(),
+		SHA256:       hex.EncodeToString(sum),
+	}, nil
+}
+
+// writeSnapshotMetaFile writes the metadata file for the given snapshot file.
+// Like the snapshot, it's written to a temporary file which is renamed into
+// place once it's complete.
+func writeSnapshotMetaFile(file string, m *snapshotMetaFile) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := snapshotMetaPath(file)
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+// readSnapshotMetaFile reads the metadata file for the given snapshot file,
+// returning nil if there isn't one.
+func readSnapshotMetaFile(file string) (*snapshotMetaFile, error) {
+	b, err := ioutil.ReadFile(snapshotMetaPath(file))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var m snapshotMetaFile
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", snapshotMetaPath(file), err)
+	}
+	return &m, nil
+}
+
+// mismatch describes how the metadata differs from the snapshot it's meant
+// to describe, or returns an empty string if it matches. The checksum is
+// only compared if sum isn't nil, since it's only known if the whole
+// snapshot was read.
+func (m *snapshotMetaFile) mismatch(meta *raft.SnapshotMeta, sum []byte) string {
+	switch {
+	case m.Index != meta.Index || m.Term != meta.Term:
+		return fmt.Sprintf("it's for index %d and term %d, but the snapshot is at index %d and term %d",
+			m.Index, m.Term, meta.Index, meta.Term)
+	case sum != nil && m.SHA256 != hex.EncodeToString(sum):
+		return fmt.Sprintf("it has SHA-256 %s, but the snapshot has %s", m.SHA256, hex.EncodeToString(sum))
+	}
+	return ""
+}
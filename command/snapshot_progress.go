@@ -0,0 +1,135 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/mattn/go-isatty"
+	"github.com/mitchellh/cli"
+)
+
+// snapshotProgressInterval is how often the progress of a snapshot transfer
+// is reported.
+const snapshotProgressInterval = time.Second
+
+// snapshotProgress counts the bytes of a snapshot read through it, while it's
+// sent to or received from the servers, and reports the transfer to stderr
+// every interval. On a terminal the report is a single line which is redrawn
+// in place, and otherwise a line is logged each time.
+type snapshotProgress struct {
+	r  io.Reader
+	ui cli.Ui
+
+	// verb describes the direction of the transfer, such as "Sent".
+	verb string
+
+	// total is the expected size of the snapshot, or 0 if it's unknown.
+	total int64
+
+	// tty is where the redrawn line is written, or nil to log lines
+	// through the Ui instead.
+	tty io.Writer
+
+	// n is the number of bytes read so far. It's updated atomically, since
+	// it's reported from another goroutine.
+	n int64
+
+	start  time.Time
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+// newSnapshotProgress wraps the reader and starts reporting its progress
+// until Stop is called. The line is redrawn in place if stderr is a
+// terminal.
+func newSnapshotProgress(r io.Reader, ui cli.Ui, verb string, total int64) *snapshotProgress {
+	var tty io.Writer
+	if isatty.IsTerminal(os.Stderr.Fd()) {
+		tty = os.Stderr
+	}
+	p := &snapshotProgress{r: r, ui: ui, verb: verb, total: total, tty: tty}
+	p.run(snapshotProgressInterval)
+	return p
+}
+
+func (p *snapshotProgress) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	atomic.AddInt64(&p.n, int64(n))
+	return n, err
+}
+
+// run starts the goroutine which reports the progress every interval.
+func (p *snapshotProgress) run(interval time.Duration) {
+	p.start = time.Now()
+	p.stopCh = make(chan struct{})
+	p.doneCh = make(chan struct{})
+	go func() {
+		defer close(p.doneCh)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				line := p.line()
+				if p.tty != nil {
+					fmt.Fprintf(p.tty, "\r%s\x1b[K", line)
+				} else {
+					p.ui.Warn(line)
+				}
+			case <-p.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops reporting the progress and reports a summary of the whole
+// transfer.
+func (p *snapshotProgress) Stop() {
+	close(p.stopCh)
+	<-p.doneCh
+	if p.tty != nil {
+		fmt.Fprint(p.tty, "\r\x1b[K")
+	}
+
+	n := atomic.LoadInt64(&p.n)
+	elapsed := time.Since(p.start)
+	p.ui.Warn(fmt.Sprintf("%s %s in %s (%s/s)", p.verb, formatBytes(n),
+		elapsed-elapsed%time.Millisecond, formatBytes(bytesPerSecond(n, elapsed))))
+}
+
+// line describes the progress so far.
+func (p *snapshotProgress) line() string {
+	n := atomic.LoadInt64(&p.n)
+	rate := bytesPerSecond(n, time.Since(p.start))
+	if p.total > 0 {
+		return fmt.Sprintf("%s %s of %s (%d%%), %s/s", p.verb, formatBytes(n),
+			formatBytes(p.total), n*100/p.total, formatBytes(rate))
+	}
+	return fmt.Sprintf("%s %s, %s/s", p.verb, formatBytes(n), formatBytes(rate))
+}
+
+func bytesPerSecond(n int64, elapsed time.Duration) int64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return int64(float64(n) / elapsed.Seconds())
+}
+
+// formatBytes formats a number of bytes for people to read, such as "1.5
+// MB", using powers of 1024.
+func formatBytes(n int64) string {
+	const units = "KMGTPE"
+	if n < 1024 {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(1024), 0
+	for m := n / 1024; m >= 1024 && exp < len(units)-1; m /= 1024 {
+		div *= 1024
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), units[exp])
+}
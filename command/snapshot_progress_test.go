@@ -0,0 +1,98 @@
+package command
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mitchellh/cli"
+)
+
+// slowReader returns one byte per read, pausing before each.
+type slowReader struct {
+	r     io.Reader
+	delay time.Duration
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	time.Sleep(r.delay)
+	return r.r.Read(p[:1])
+}
+
+func TestSnapshotProgress(t *testing.T) {
+	data := strings.Repeat("x", 20)
+
+	// Without a terminal, a line is logged each time.
+	ui := new(cli.MockUi)
+	p := &snapshotProgress{
+		r:     &slowReader{strings.NewReader(data), 5 * time.Millisecond},
+		ui:    ui,
+		verb:  "Sent",
+		total: int64(len(data)),
+	}
+	p.run(20 * time.Millisecond)
+	if _, err := io.Copy(ioutil.Discard, p); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	p.Stop()
+
+	lines := strings.Split(strings.TrimSpace(ui.ErrorWriter.String()), "\n")
+	if len(lines) < 2 {
+		t.Fatalf("bad: %#v", lines)
+	}
+	for _, line := range lines[:len(lines)-1] {
+		if !strings.HasPrefix(line, "Sent ") || !strings.Contains(line, " of 20 B (") {
+			t.Fatalf("bad: %#v", lines)
+		}
+	}
+	if summary := lines[len(lines)-1]; !strings.HasPrefix(summary, "Sent 20 B in ") ||
+		!strings.HasSuffix(summary, "B/s)") {
+		t.Fatalf("bad: %#v", lines)
+	}
+
+	// On a terminal, the line is redrawn in place and cleared at the end,
+	// with an unknown total.
+	ui = new(cli.MockUi)
+	var tty bytes.Buffer
+	p = &snapshotProgress{
+		r:    &slowReader{strings.NewReader(data), 5 * time.Millisecond},
+		ui:   ui,
+		verb: "Received",
+		tty:  &tty,
+	}
+	p.run(20 * time.Millisecond)
+	if _, err := io.Copy(ioutil.Discard, p); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	p.Stop()
+
+	out := tty.String()
+	if !strings.HasPrefix(out, "\rReceived ") || strings.Contains(out, "\n") ||
+		strings.Contains(out, "%") || !strings.HasSuffix(out, "\r\x1b[K") {
+		t.Fatalf("bad: %q", out)
+	}
+	if output := ui.ErrorWriter.String(); !strings.HasPrefix(output, "Received 20 B in ") ||
+		strings.Count(output, "\n") != 1 {
+		t.Fatalf("bad: %q", output)
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	cases := map[int64]string{
+		0:               "0 B",
+		1023:            "1023 B",
+		1024:            "1.0 KB",
+		1536:            "1.5 KB",
+		5 * 1024 * 1024: "5.0 MB",
+		3 << 30:         "3.0 GB",
+		1<<63 - 1:       "8.0 EB",
+	}
+	for n, expected := range cases {
+		if actual := formatBytes(n); actual != expected {
+			t.Fatalf("%d: expected %q, got %q", n, expected, actual)
+		}
+	}
+}
@@ -1,19 +1,40 @@
 package command
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"strconv"
 	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/raft"
+	"github.com/hashicorp/serf/serf"
+	"github.com/mattn/go-isatty"
 	"github.com/mitchellh/cli"
 )
 
 // SnapshotRestoreCommand is a Command implementation that is used to restore
 // the state of the Consul servers for disaster recovery.
 type SnapshotRestoreCommand struct {
-	Ui cli.Ui
+	Ui         cli.Ui
+	ShutdownCh <-chan struct{}
+
+	// testStdin is the input for testing.
+	testStdin io.Reader
+
+	// testStdinTerminal overrides the check for whether stdin is a
+	// terminal, for testing.
+	testStdinTerminal *bool
+
+	// testVerifyInterval overrides how often the cluster is checked with
+	// -verify, for testing.
+	testVerifyInterval time.Duration
 }
 
 func (c *SnapshotRestoreCommand) Help() string {
@@ -36,20 +57,114 @@ Usage: consul snapshot restore [options] FILE
 
     $ consul snapshot restore backup.snap
 
+  To read the snapshot from stdin, use "-" as the file name. Since stdin can't
+  also be used to confirm the restore, this requires the -force option:
+
+    $ gpg --decrypt backup.snap.gpg | consul snapshot restore -force -
+
+  The snapshot is verified locally before it is sent to the servers, so a
+  truncated or corrupted file is rejected without starting a restore. A
+  snapshot encrypted by "consul snapshot save -encrypt-key" needs the same key
+  given with -decrypt-key, and is decrypted as it's sent. While
+  it's being sent, the progress is reported to stderr every second, followed
+  by a summary once it's done. If it's interrupted before the whole snapshot
+  has been sent, the servers don't restore it, and the command exits with
+  status 130.
+
+  Before restoring, a summary of the target cluster and the snapshot is shown
+  and the name of the target datacenter must be typed to confirm. The restore
+  is refused if the cluster has newer data than the snapshot. Both checks can
+  be skipped with the -force option, which is required when not running
+  interactively.
+
+  The restore is always made by the leader, so the -stale option is rejected.
+
+  Once the servers have accepted the snapshot, they still need time to elect a
+  leader and apply it. With -verify, the command waits for that, checking
+  every second that there's a leader, that the Raft applied index has reached
+  the snapshot's index, and with -expect-servers that enough servers are
+  alive, until -verify-timeout runs out:
+
+    $ consul snapshot restore -force -verify -expect-servers=3 backup.snap
+
+  If the restore succeeds but the checks don't pass in time, the command exits
+  with status 2 rather than 1, since the snapshot was still restored.
+
   For a full list of options and examples, please see the Consul documentation.
 
-` + apiOptsText
+` + apiOptsText + `
+
+Snapshot Restore Options:
+
+  -decrypt-key=<path>     Decrypt a snapshot saved with "consul snapshot save
+                          -encrypt-key", using the key or passphrase in the
+                          given file. The whole snapshot is decrypted and
+                          authenticated before any of it is sent to the
+                          servers, so a wrong key or a changed file is
+                          rejected without starting a restore.
+
+  -expect-servers=<int>   With -verify, also wait until at least this many
+                          servers are alive in the agent's gossip pool. The
+                          default value is 0, which skips this check.
+
+  -force                  Restore without asking for confirmation, even if
+                          the target cluster has newer data than the snapshot.
+                          The default value is false.
+
+  -skip-verify            Send the snapshot to the servers without verifying
+                          its contents locally first. This is only needed for
+                          snapshots in a format this version of Consul doesn't
+                          understand. The default value is false.
+
+  -verify                 After the restore, wait until the cluster has a
+                          leader and has applied the snapshot. The applied
+                          index is only available from a server agent in the
+                          target datacenter, and the snapshot's index is only
+                          known if it was verified, so this check is skipped
+                          otherwise. The default value is false.
+
+  -verify-timeout=<dur>   Maximum time to wait with -verify. The default value
+                          is 2m.
+`
 
 	return strings.TrimSpace(helpText)
 }
 
-func (c *SnapshotRestoreCommand) Run(args []string) int {
-	cmdFlags := flag.NewFlagSet("get", flag.ContinueOnError)
+func (c *SnapshotRestoreCommand) Run(args []string) (code int) {
+	cmdFlags, apiFlags := NewAPIFlagSet("get", c.Ui)
+	defer apiFlags.CheckTimeout(c.Ui, &code)
 	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
-	datacenter := cmdFlags.String("datacenter", "", "")
-	token := cmdFlags.String("token", "", "")
-	httpAddr := HTTPAddrFlag(cmdFlags)
-	if err := cmdFlags.Parse(args); err != nil {
+	skipVerify := cmdFlags.Bool("skip-verify", false, "")
+	force := cmdFlags.Bool("force", false, "")
+	verify := cmdFlags.Bool("verify", false, "")
+	expectServers := cmdFlags.Int("expect-servers", 0, "")
+	verifyTimeout := cmdFlags.Duration("verify-timeout", 2*time.Minute, "")
+	decryptKey := cmdFlags.String("decrypt-key", "", "")
+	if err := parseFlags(c.Ui, cmdFlags, args); err != nil {
+		return 1
+	}
+	if apiFlags.Stale {
+		c.Ui.Error("Error! Cannot restore with -stale, since a snapshot can only be restored by the leader")
+		return 1
+	}
+	if !*verify {
+		var set []string
+		cmdFlags.Visit(func(f *flag.Flag) {
+			if f.Name == "expect-servers" || f.Name == "verify-timeout" {
+				set = append(set, "-"+f.Name)
+			}
+		})
+		if len(set) > 0 {
+			c.Ui.Error(fmt.Sprintf("Error! Can only specify %s with -verify", strings.Join(set, " and ")))
+			return 1
+		}
+	}
+	if *expectServers < 0 {
+		c.Ui.Error("Error! -expect-servers must not be negative")
+		return 1
+	}
+	if *verifyTimeout <= 0 {
+		c.Ui.Error("Error! -verify-timeout must be positive")
 		return 1
 	}
 
@@ -67,36 +182,410 @@ func (c *SnapshotRestoreCommand) Run(args []string) int {
 		return 1
 	}
 
-	// Create and test the HTTP client
-	conf := api.DefaultConfig()
-	conf.Datacenter = *datacenter
-	conf.Address = *httpAddr
-	if *token != "" {
-		conf.Token = *token
+	var key *snapshotKey
+	var err error
+	if *decryptKey != "" {
+		if key, err = readSnapshotKey(*decryptKey); err != nil {
+			c.Ui.Error(fmt.Sprintf("Error! %s", err))
+			return 1
+		}
+	}
+
+	// Open the file. A snapshot from stdin is copied to a temporary file
+	// first, since it needs to be read twice: once to verify it and again
+	// to send it to the servers.
+	var f *os.File
+	if file == "-" {
+		f, err = ioutil.TempFile("", "snapshot")
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Error creating temporary snapshot file: %s", err))
+			return 1
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+
+		if _, err := io.Copy(f, c.stdin()); err != nil {
+			c.Ui.Error(fmt.Sprintf("Error reading snapshot from stdin: %s", err))
+			return 1
+		}
+		if _, err := f.Seek(0, 0); err != nil {
+			c.Ui.Error(fmt.Sprintf("Error rewinding temporary snapshot file: %s", err))
+			return 1
+		}
+	} else {
+		f, err = os.Open(file)
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Error opening snapshot file: %s", err))
+			return 1
+		}
+		defer f.Close()
+	}
+
+	// Verify the snapshot before we talk to the servers, since a restore is
+	// a dangerous operation that we don't want to start with a bad file. An
+	// encrypted snapshot is decrypted in full even with -skip-verify, so a
+	// wrong key or a changed file is caught before anything is sent.
+	var meta *raft.SnapshotMeta
+	if !*skipVerify {
+		meta, err = verifySnapshot(f, key)
+	} else {
+		var in io.Reader
+		if in, err = snapshotPlaintext(f, key); err == nil && key != nil {
+			_, err = io.Copy(ioutil.Discard, in)
+		}
 	}
-	client, err := api.NewClient(conf)
 	if err != nil {
-		c.Ui.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+		c.Ui.Error(fmt.Sprintf("Error verifying snapshot file: %s", err))
+		return 1
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		c.Ui.Error(fmt.Sprintf("Error rewinding snapshot file after verify: %s", err))
 		return 1
 	}
 
-	// Open the file.
-	f, err := os.Open(file)
+	// Create and test the HTTP client
+	client, err := apiFlags.Client()
 	if err != nil {
-		c.Ui.Error(fmt.Sprintf("Error opening snapshot file: %s", err))
+		c.Ui.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
 		return 1
 	}
-	defer f.Close()
 
-	// Restore the snapshot.
-	err = client.Snapshot().Restore(nil, f)
+	// Show what's about to happen and make sure it's what the user wants.
+	if !c.preflight(client, meta, apiFlags.Datacenter, *force) {
+		return 1
+	}
+
+	// Restore the snapshot, reporting the upload as it goes since a large
+	// one can take a while.
+	var in io.Reader = f
+	if !apiFlags.Quiet {
+		var total int64
+		if fi, err := f.Stat(); err == nil {
+			total = fi.Size()
+		}
+		progress := newSnapshotProgress(f, c.Ui, "Sent", total)
+		defer progress.Stop()
+		in = progress
+	}
+	if key != nil {
+		if in, err = snapshotPlaintext(in, key); err != nil {
+			c.Ui.Error(fmt.Sprintf("Error decrypting snapshot file: %s", err))
+			return 1
+		}
+	}
+	stop := apiFlags.WatchShutdown(c.ShutdownCh)
+	err = client.Snapshot().Restore(apiFlags.WriteOptions(), in)
+	stop()
 	if err != nil {
-		c.Ui.Error(fmt.Sprintf("Error restoring snapshot: %s", err))
+		if apiFlags.Cancelled() {
+			c.Ui.Error(c.cancelledMessage(f))
+			return exitCancelled
+		}
+		c.Ui.Error(apiFlags.errorMessage("Error restoring snapshot", err, snapshotDenial("restore a snapshot")))
 		return 1
 	}
 
-	c.Ui.Info("Restored snapshot")
-	return 0
+	apiFlags.report(c.Ui, "Restored snapshot")
+	if !*verify {
+		return 0
+	}
+
+	check := &snapshotRestoreCheck{
+		client:        client,
+		dc:            apiFlags.Datacenter,
+		expectServers: *expectServers,
+	}
+	if meta != nil {
+		check.index = meta.Index
+	} else {
+		apiFlags.note(c.Ui, "The snapshot's index is unknown with -skip-verify, so the applied index won't be checked")
+	}
+	return c.verify(check, apiFlags, *verifyTimeout)
+}
+
+// verify waits for the cluster to pass the check after a restore, reporting
+// what it's waiting for each time that changes. The snapshot has already been
+// restored by then, so the messages say so.
+func (c *SnapshotRestoreCommand) verify(check *snapshotRestoreCheck, apiFlags *APIFlags, timeout time.Duration) int {
+	interval := time.Second
+	if c.testVerifyInterval > 0 {
+		interval = c.testVerifyInterval
+	}
+
+	start := time.Now()
+	deadline := time.After(timeout)
+	var last string
+	for {
+		pending := check.pending()
+		if pending == "" {
+			apiFlags.report(c.Ui, fmt.Sprintf("Verified the restore after %s: %s",
+				time.Since(start)-time.Since(start)%time.Millisecond, check))
+			return 0
+		}
+		if pending != last {
+			apiFlags.note(c.Ui, fmt.Sprintf("Waiting for the cluster: %s", pending))
+			last = pending
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-deadline:
+			c.Ui.Error(fmt.Sprintf("Error! The snapshot was restored, but the cluster didn't pass "+
+				"verification within %s: %s", timeout, pending))
+			return 2
+		case <-c.ShutdownCh:
+			c.Ui.Error(fmt.Sprintf("Cancelled while verifying the restore: %s. The snapshot was restored",
+				pending))
+			return exitCancelled
+		}
+	}
+}
+
+// snapshotRestoreCheck checks whether the cluster has settled after a
+// restore: that it has a leader, that the agent's Raft applied index has
+// reached the snapshot's index, and that enough servers are alive.
+type snapshotRestoreCheck struct {
+	client *api.Client
+	dc     string
+
+	// index is the snapshot's index, or 0 if it's unknown.
+	index uint64
+
+	// expectServers is the number of servers to wait for, or 0 to skip
+	// that check.
+	expectServers int
+
+	// The state seen by the last check, for the final report.
+	leader  string
+	applied uint64
+	servers int
+}
+
+// pending describes the first check which doesn't pass yet, or returns an
+// empty string once they all do. Errors are reported as pending too, since
+// the agent may be unavailable while a new leader is elected.
+func (r *snapshotRestoreCheck) pending() string {
+	leader, err := r.client.Status().Leader()
+	if err != nil {
+		return fmt.Sprintf("unable to query the leader: %s", err)
+	}
+	if leader == "" {
+		return "no leader yet"
+	}
+	r.leader = leader
+
+	if r.index > 0 {
+		self, err := r.client.Agent().Self()
+		if err != nil {
+			return fmt.Sprintf("unable to query the agent: %s", err)
+		}
+
+		// A client agent, or a server in another datacenter, has no
+		// applied index for the target cluster.
+		agentDC, _ := self["Config"]["Datacenter"].(string)
+		isServer, _ := self["Config"]["Server"].(bool)
+		if isServer && (r.dc == "" || r.dc == agentDC) {
+			raftStats, _ := self["Stats"]["raft"].(map[string]interface{})
+			applied, _ := raftStats["applied_index"].(string)
+			index, err := strconv.ParseUint(applied, 10, 64)
+			if err != nil {
+				return fmt.Sprintf("unable to read the applied index %q", applied)
+			}
+			r.applied = index
+			if index < r.index {
+				return fmt.Sprintf("applied index %d of %d", index, r.index)
+			}
+		}
+	}
+
+	if r.expectServers > 0 {
+		members, err := r.client.Agent().Members(false)
+		if err != nil {
+			return fmt.Sprintf("unable to query the members: %s", err)
+		}
+		r.servers = 0
+		for _, m := range members {
+			if m.Tags["role"] == "consul" && m.Status == int(serf.StatusAlive) {
+				r.servers++
+			}
+		}
+		if r.servers < r.expectServers {
+			return fmt.Sprintf("%d of %d servers alive", r.servers, r.expectServers)
+		}
+	}
+	return ""
+}
+
+// String describes the state the cluster passed the check with.
+func (r *snapshotRestoreCheck) String() string {
+	parts := []string{fmt.Sprintf("leader %s", r.leader)}
+	if r.applied > 0 {
+		parts = append(parts, fmt.Sprintf("applied index %d", r.applied))
+	}
+	if r.expectServers > 0 {
+		parts = append(parts, fmt.Sprintf("%d of %d expected servers alive", r.servers, r.expectServers))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// cancelledMessage describes a restore which was cancelled while the snapshot
+// in the given file was being sent. The servers only restore a snapshot once
+// they have all of it, so one which wasn't sent completely was not restored.
+// Once all of it was sent, the restore may go ahead without the response.
+func (c *SnapshotRestoreCommand) cancelledMessage(f *os.File) string {
+	sent, err := f.Seek(0, io.SeekCurrent)
+	fi, statErr := f.Stat()
+	if err != nil || statErr != nil {
+		return "Cancelled while sending the snapshot. The servers may still restore it, " +
+			"so check the state of the cluster before trying again"
+	}
+	if sent < fi.Size() {
+		return fmt.Sprintf("Cancelled after sending %d of %d bytes, the snapshot was not restored",
+			sent, fi.Size())
+	}
+	return fmt.Sprintf("Cancelled after sending all %d bytes of the snapshot. The servers may still "+
+		"restore it, so check the state of the cluster before trying again", sent)
+}
+
+// preflight shows a summary of the target cluster and the snapshot, and
+// checks that the restore should go ahead. Unless force is set, it refuses
+// to roll the cluster back to an older index and asks the user to confirm by
+// typing the name of the target datacenter. It returns false if the restore
+// should not proceed, after reporting why.
+func (c *SnapshotRestoreCommand) preflight(client *api.Client, meta *raft.SnapshotMeta, dc string, force bool) bool {
+	state, err := querySnapshotTarget(client, dc)
+	if err != nil {
+		if force {
+			c.Ui.Warn(fmt.Sprintf("Unable to query the target cluster: %s", err))
+			return true
+		}
+		c.Ui.Error(fmt.Sprintf("Error querying the target cluster: %s", err))
+		return false
+	}
+
+	var b bytes.Buffer
+	tw := tabwriter.NewWriter(&b, 0, 2, 6, ' ', 0)
+	fmt.Fprintf(tw, "Datacenter\t%s\n", state.Datacenter)
+	fmt.Fprintf(tw, "Leader\t%s\n", state.Leader)
+	fmt.Fprintf(tw, "Servers\t%d\n", state.Servers)
+	fmt.Fprintf(tw, "Nodes\t%d\n", state.Nodes)
+	fmt.Fprintf(tw, "Cluster Index\t%d\n", state.Index)
+	if meta != nil {
+		fmt.Fprintf(tw, "Snapshot Index\t%d\n", meta.Index)
+		fmt.Fprintf(tw, "Snapshot Term\t%d\n", meta.Term)
+	} else {
+		fmt.Fprintf(tw, "Snapshot Index\tunknown (not verified)\n")
+		fmt.Fprintf(tw, "Snapshot Term\tunknown (not verified)\n")
+	}
+	if err := tw.Flush(); err != nil {
+		c.Ui.Error(fmt.Sprintf("Error rendering restore summary: %s", err))
+		return false
+	}
+	c.Ui.Output(strings.TrimSpace(b.String()))
+
+	if force {
+		return true
+	}
+
+	if meta != nil && state.Index > meta.Index {
+		c.Ui.Error(fmt.Sprintf("Error! The target cluster is at index %d, which is newer than the "+
+			"snapshot's index %d. Restoring would roll back any changes made since the snapshot "+
+			"was taken. Use -force to restore anyway.", state.Index, meta.Index))
+		return false
+	}
+
+	if !c.stdinIsTerminal() {
+		c.Ui.Error("Error! Refusing to restore without confirmation. " +
+			"Use -force to skip the prompt when not running interactively.")
+		return false
+	}
+
+	query := fmt.Sprintf("This will replace all the state in datacenter %q. "+
+		"Type the name of the datacenter to confirm:", state.Datacenter)
+	answer, err := c.Ui.Ask(query)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error reading confirmation: %s", err))
+		return false
+	}
+	if strings.TrimSpace(answer) != state.Datacenter {
+		c.Ui.Error("Restore cancelled, the snapshot was not restored")
+		return false
+	}
+	return true
+}
+
+// stdin returns the reader for a snapshot read from stdin.
+func (c *SnapshotRestoreCommand) stdin() io.Reader {
+	if c.testStdin != nil {
+		return c.testStdin
+	}
+	return os.Stdin
+}
+
+// stdinIsTerminal returns true if the command can prompt the user for input.
+func (c *SnapshotRestoreCommand) stdinIsTerminal() bool {
+	if c.testStdinTerminal != nil {
+		return *c.testStdinTerminal
+	}
+	return isatty.IsTerminal(os.Stdin.Fd())
+}
+
+// snapshotTarget describes the cluster a snapshot is being restored into.
+type snapshotTarget struct {
+	Datacenter string
+	Leader     string
+	Servers    int
+	Nodes      int
+
+	// Index is the latest Raft index of the cluster. When the agent isn't a
+	// server in the target datacenter this isn't available, so the highest
+	// index of the catalog is used instead, which is a lower bound.
+	Index uint64
+}
+
+// querySnapshotTarget looks up the state of the cluster in the given
+// datacenter, or the agent's datacenter if none is given.
+func querySnapshotTarget(client *api.Client, dc string) (*snapshotTarget, error) {
+	self, err := client.Agent().Self()
+	if err != nil {
+		return nil, err
+	}
+	agentDC, _ := self["Config"]["Datacenter"].(string)
+	if dc == "" {
+		dc = agentDC
+	}
+	state := &snapshotTarget{Datacenter: dc}
+
+	q := &api.QueryOptions{Datacenter: dc}
+	raftConf, err := client.Operator().RaftGetConfiguration(q)
+	if err != nil {
+		return nil, err
+	}
+	state.Servers = len(raftConf.Servers)
+	state.Leader = "(none)"
+	for _, s := range raftConf.Servers {
+		if s.Leader {
+			state.Leader = fmt.Sprintf("%s (%s)", s.Node, s.Address)
+		}
+	}
+
+	nodes, qm, err := client.Catalog().Nodes(q)
+	if err != nil {
+		return nil, err
+	}
+	state.Nodes = len(nodes)
+	state.Index = qm.LastIndex
+
+	if isServer, _ := self["Config"]["Server"].(bool); isServer && dc == agentDC {
+		if raftStats, ok := self["Stats"]["raft"].(map[string]interface{}); ok {
+			last, _ := raftStats["last_log_index"].(string)
+			if index, err := strconv.ParseUint(last, 10, 64); err == nil && index > state.Index {
+				state.Index = index
+			}
+		}
+	}
+
+	return state, nil
 }
 
 func (c *SnapshotRestoreCommand) Synopsis() string {
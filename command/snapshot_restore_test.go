@@ -1,13 +1,19 @@
 package command
 
 import (
+	"bytes"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/hashicorp/consul/api"
 	"github.com/mitchellh/cli"
 )
 
@@ -35,6 +41,22 @@ func TestSnapshotRestoreCommand_Validation(t *testing.T) {
 			[]string{"foo", "bar", "baz"},
 			"Too many arguments",
 		},
+		"stale": {
+			[]string{"-stale", "foo"},
+			"Cannot restore with -stale",
+		},
+		"verify options without verify": {
+			[]string{"-expect-servers=3", "-verify-timeout=1m", "foo"},
+			"Can only specify -expect-servers and -verify-timeout with -verify",
+		},
+		"negative expect-servers": {
+			[]string{"-verify", "-expect-servers=-1", "foo"},
+			"-expect-servers must not be negative",
+		},
+		"zero verify-timeout": {
+			[]string{"-verify", "-verify-timeout=0", "foo"},
+			"-verify-timeout must be positive",
+		},
 	}
 
 	for name, tc := range cases {
@@ -101,3 +123,543 @@ func TestSnapshotRestoreCommand_Run(t *testing.T) {
 		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
 	}
 }
+
+func TestSnapshotRestoreCommand_verify(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := path.Join(dir, "backup.snap")
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	snap, _, err := client.Snapshot().Save(nil)
+	if err != nil {
+		f.Close()
+		t.Fatalf("err: %v", err)
+	}
+	defer snap.Close()
+	if _, err := io.Copy(f, snap); err != nil {
+		f.Close()
+		t.Fatalf("err: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	ui := new(cli.MockUi)
+	c := &SnapshotRestoreCommand{Ui: ui, testVerifyInterval: 50 * time.Millisecond}
+	code := c.Run([]string{"-http-addr=" + srv.httpAddr, "-force", "-verify", "-expect-servers=1", file})
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	output := ui.OutputWriter.String()
+	if !strings.Contains(output, "Restored snapshot") || !strings.Contains(output, "Verified the restore after ") ||
+		!strings.Contains(output, "applied index ") || !strings.Contains(output, "1 of 1 expected servers alive") {
+		t.Fatalf("bad: %#v", output)
+	}
+
+	// The restore succeeds, but there's only one server.
+	ui = new(cli.MockUi)
+	c = &SnapshotRestoreCommand{Ui: ui, testVerifyInterval: 50 * time.Millisecond}
+	code = c.Run([]string{"-http-addr=" + srv.httpAddr, "-force", "-verify", "-expect-servers=2",
+		"-verify-timeout=500ms", file})
+	if code != 2 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if output := ui.OutputWriter.String(); !strings.Contains(output, "Restored snapshot") {
+		t.Fatalf("bad: %#v", output)
+	}
+	errs := ui.ErrorWriter.String()
+	if strings.Count(errs, "Waiting for the cluster: 1 of 2 servers alive") != 1 ||
+		!strings.Contains(errs, "Error! The snapshot was restored, but the cluster didn't pass "+
+			"verification within 500ms: 1 of 2 servers alive") {
+		t.Fatalf("bad: %#v", errs)
+	}
+}
+
+func TestSnapshotRestoreCommand_Corrupt(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	snap, _, err := client.Snapshot().Save(nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	data, err := ioutil.ReadAll(snap)
+	snap.Close()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	flipped := append([]byte{}, data...)
+	flipped[len(flipped)/2] ^= 0x01
+
+	cases := map[string][]byte{
+		"bit flip":  flipped,
+		"truncated": data[:len(data)/2],
+	}
+
+	// Any request made to this server means we contacted the agent with a
+	// bad snapshot.
+	var requests, restores int
+	fake := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Method == "PUT" && r.URL.Path == "/v1/snapshot" {
+			restores++
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer fake.Close()
+	addr := strings.TrimPrefix(fake.URL, "http://")
+
+	for name, contents := range cases {
+		file := path.Join(dir, "backup.snap")
+		if err := ioutil.WriteFile(file, contents, 0600); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+
+		ui := new(cli.MockUi)
+		c := &SnapshotRestoreCommand{Ui: ui}
+		code := c.Run([]string{"-http-addr=" + addr, file})
+		if code != 1 {
+			t.Fatalf("%s: bad: %d", name, code)
+		}
+		if !strings.Contains(ui.ErrorWriter.String(), "Error verifying snapshot file") {
+			t.Fatalf("%s: bad: %#v", name, ui.ErrorWriter.String())
+		}
+		if requests != 0 {
+			t.Fatalf("%s: bad: made %d requests", name, requests)
+		}
+	}
+
+	// With -skip-verify the file is sent as-is.
+	file := path.Join(dir, "backup.snap")
+	ui := new(cli.MockUi)
+	c := &SnapshotRestoreCommand{Ui: ui}
+	code := c.Run([]string{"-http-addr=" + addr, "-skip-verify", "-force", file})
+	if code != 1 {
+		t.Fatalf("bad: %d", code)
+	}
+	if restores != 1 {
+		t.Fatalf("bad: made %d restore requests", restores)
+	}
+}
+
+// testSnapshotTarget starts a fake agent which reports a cluster at the given
+// Raft index, and counts the snapshot restores made against it.
+func testSnapshotTarget(t *testing.T, index uint64, restores *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/agent/self":
+			fmt.Fprintf(w, `{"Config": {"Datacenter": "dc1", "Server": true},
+				"Stats": {"raft": {"last_log_index": "%d"}}}`, index)
+		case "/v1/operator/raft/configuration":
+			fmt.Fprint(w, `{"Servers": [{"Node": "node1", "Address": "127.0.0.1:8300", "Leader": true}], "Index": 1}`)
+		case "/v1/catalog/nodes":
+			w.Header().Set("X-Consul-Index", "1")
+			fmt.Fprint(w, `[{"Node": "node1"}, {"Node": "node2"}]`)
+		case "/v1/snapshot":
+			*restores++
+			io.Copy(ioutil.Discard, r.Body)
+		default:
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestSnapshotRestoreCommand_Preflight(t *testing.T) {
+	// The fixture snapshot is at index 11.
+	cases := map[string]struct {
+		index    uint64
+		args     []string
+		terminal bool
+		input    string
+		code     int
+		restored bool
+		output   string
+	}{
+		"confirmed": {
+			index:    5,
+			terminal: true,
+			input:    "dc1\n",
+			restored: true,
+			output:   "Restored snapshot",
+		},
+		"wrong datacenter": {
+			index:    5,
+			terminal: true,
+			input:    "dc2\n",
+			code:     1,
+			output:   "Restore cancelled",
+		},
+		"not interactive": {
+			index:  5,
+			code:   1,
+			output: "Refusing to restore without confirmation",
+		},
+		"newer cluster": {
+			index:    20,
+			terminal: true,
+			input:    "dc1\n",
+			code:     1,
+			output:   "newer than the snapshot's index 11",
+		},
+		"newer cluster with -force": {
+			index:    20,
+			args:     []string{"-force"},
+			restored: true,
+			output:   "Restored snapshot",
+		},
+	}
+
+	for name, tc := range cases {
+		var restores int
+		fake := testSnapshotTarget(t, tc.index, &restores)
+
+		ui := new(cli.MockUi)
+		ui.InputReader = strings.NewReader(tc.input)
+		c := &SnapshotRestoreCommand{Ui: ui, testStdinTerminal: &tc.terminal}
+
+		args := append([]string{"-http-addr=" + strings.TrimPrefix(fake.URL, "http://")}, tc.args...)
+		code := c.Run(append(args, "test-fixtures/snapshot/backup.snap"))
+		fake.Close()
+		if code != tc.code {
+			t.Fatalf("%s: bad: %d. %#v", name, code, ui.ErrorWriter.String())
+		}
+		if restored := restores == 1; restored != tc.restored {
+			t.Fatalf("%s: bad: made %d restore requests", name, restores)
+		}
+
+		output := ui.OutputWriter.String() + ui.ErrorWriter.String()
+		if !strings.Contains(output, tc.output) {
+			t.Fatalf("%s: expected %q to contain %q", name, output, tc.output)
+		}
+		if sent := strings.Contains(output, "Sent 1.0 KB in "); sent != tc.restored {
+			t.Fatalf("%s: bad: %#v", name, output)
+		}
+		for _, summary := range []string{
+			"Datacenter          dc1",
+			"Leader              node1 (127.0.0.1:8300)",
+			"Nodes               2",
+			fmt.Sprintf("Cluster Index       %d", tc.index),
+			"Snapshot Index      11",
+		} {
+			if !strings.Contains(output, summary) {
+				t.Fatalf("%s: expected %q to contain %q", name, output, summary)
+			}
+		}
+	}
+}
+
+func TestSnapshotRestoreCommand_Stdin(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	if _, err := client.KV().Put(&api.KVPair{Key: "foo", Value: []byte("bar")}, nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Save a snapshot to stdout.
+	var snap bytes.Buffer
+	saveUi := new(cli.MockUi)
+	save := &SnapshotSaveCommand{Ui: saveUi, testStdout: &snap}
+	code := save.Run([]string{"-http-addr=" + srv.httpAddr, "-"})
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, saveUi.ErrorWriter.String())
+	}
+	if saveUi.OutputWriter.String() != "" {
+		t.Fatalf("bad: %#v", saveUi.OutputWriter.String())
+	}
+	if !strings.Contains(saveUi.ErrorWriter.String(), fmt.Sprintf("(%d bytes)", snap.Len())) {
+		t.Fatalf("bad: %#v", saveUi.ErrorWriter.String())
+	}
+
+	// Change the key, then pipe the snapshot into a restore to put it back.
+	if _, err := client.KV().Put(&api.KVPair{Key: "foo", Value: []byte("baz")}, nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	restoreUi := new(cli.MockUi)
+	restore := &SnapshotRestoreCommand{Ui: restoreUi, testStdin: &snap}
+	code = restore.Run([]string{"-http-addr=" + srv.httpAddr, "-force", "-"})
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, restoreUi.ErrorWriter.String())
+	}
+
+	pair, _, err := client.KV().Get("foo", nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if pair == nil || string(pair.Value) != "bar" {
+		t.Fatalf("bad: %#v", pair)
+	}
+}
+
+func TestSnapshotRestoreCommand_Encrypted(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	if _, err := client.KV().Put(&api.KVPair{Key: "foo", Value: []byte("bar")}, nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	keyFile := path.Join(dir, "backup.key")
+	wrongKeyFile := path.Join(dir, "wrong.key")
+	if err := ioutil.WriteFile(keyFile, []byte(strings.Repeat("ab", 32)+"\n"), 0600); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := ioutil.WriteFile(wrongKeyFile, []byte(strings.Repeat("cd", 32)+"\n"), 0600); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Save an encrypted snapshot to a file, and another to stdout.
+	file := path.Join(dir, "backup.snap")
+	ui := new(cli.MockUi)
+	save := &SnapshotSaveCommand{Ui: ui}
+	if code := save.Run([]string{"-http-addr=" + srv.httpAddr, "-encrypt-key=" + keyFile, file}); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !bytes.HasPrefix(data, []byte(snapshotEncryptedMagic)) {
+		t.Fatalf("bad: %q", data[:64])
+	}
+
+	var stdout bytes.Buffer
+	ui = new(cli.MockUi)
+	save = &SnapshotSaveCommand{Ui: ui, testStdout: &stdout}
+	if code := save.Run([]string{"-http-addr=" + srv.httpAddr, "-encrypt-key=" + keyFile, "-"}); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if !bytes.HasPrefix(stdout.Bytes(), []byte(snapshotEncryptedMagic)) {
+		t.Fatalf("bad: %q", stdout.Bytes()[:64])
+	}
+
+	// Inspecting it needs the key.
+	ui = new(cli.MockUi)
+	inspect := &SnapshotInspectCommand{Ui: ui}
+	if code := inspect.Run([]string{"-decrypt-key=" + keyFile, file}); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if !strings.Contains(ui.OutputWriter.String(), "KV") {
+		t.Fatalf("bad: %#v", ui.OutputWriter.String())
+	}
+	ui = new(cli.MockUi)
+	inspect = &SnapshotInspectCommand{Ui: ui}
+	if code := inspect.Run([]string{file}); code != 1 {
+		t.Fatalf("bad: %d", code)
+	}
+	if !strings.Contains(ui.ErrorWriter.String(), "The snapshot is encrypted. Pass the key") {
+		t.Fatalf("bad: %#v", ui.ErrorWriter.String())
+	}
+
+	// Without the right key, the agent is never contacted.
+	var requests int
+	fake := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer fake.Close()
+	addr := strings.TrimPrefix(fake.URL, "http://")
+
+	cases := map[string]struct {
+		args   []string
+		output string
+	}{
+		"no key": {
+			[]string{file},
+			"The snapshot is encrypted. Pass the key",
+		},
+		"wrong key": {
+			[]string{"-decrypt-key=" + wrongKeyFile, file},
+			"Failed to decrypt the snapshot",
+		},
+		"wrong key without verify": {
+			[]string{"-decrypt-key=" + wrongKeyFile, "-skip-verify", file},
+			"Failed to decrypt the snapshot",
+		},
+		"bad key file": {
+			[]string{"-decrypt-key=" + file, file},
+			"must hold a 32-byte key",
+		},
+	}
+	for name, tc := range cases {
+		ui := new(cli.MockUi)
+		c := &SnapshotRestoreCommand{Ui: ui}
+		code := c.Run(append([]string{"-http-addr=" + addr, "-force"}, tc.args...))
+		if code != 1 {
+			t.Fatalf("%s: bad: %d", name, code)
+		}
+		if !strings.Contains(ui.ErrorWriter.String(), tc.output) {
+			t.Fatalf("%s: bad: %#v", name, ui.ErrorWriter.String())
+		}
+		if requests != 0 {
+			t.Fatalf("%s: bad: made %d requests", name, requests)
+		}
+	}
+
+	// With the key, both snapshots restore.
+	for name, args := range map[string][]string{"file": {file}, "stdin": {"-"}} {
+		if _, err := client.KV().Put(&api.KVPair{Key: "foo", Value: []byte("baz")}, nil); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+
+		ui := new(cli.MockUi)
+		c := &SnapshotRestoreCommand{Ui: ui, testStdin: bytes.NewReader(stdout.Bytes())}
+		args = append([]string{"-http-addr=" + srv.httpAddr, "-force", "-decrypt-key=" + keyFile}, args...)
+		if code := c.Run(args); code != 0 {
+			t.Fatalf("%s: bad: %d. %#v", name, code, ui.ErrorWriter.String())
+		}
+
+		pair, _, err := client.KV().Get("foo", nil)
+		if err != nil {
+			t.Fatalf("%s: err: %v", name, err)
+		}
+		if pair == nil || string(pair.Value) != "bar" {
+			t.Fatalf("%s: bad: %#v", name, pair)
+		}
+	}
+}
+
+func TestSnapshotRestoreCommand_EncryptedPassphrase(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	if _, err := client.KV().Put(&api.KVPair{Key: "foo", Value: []byte("bar")}, nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	keyFile := path.Join(dir, "backup.passphrase")
+	wrongKeyFile := path.Join(dir, "wrong.passphrase")
+	if err := ioutil.WriteFile(keyFile, []byte("correct horse battery staple\n"), 0600); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := ioutil.WriteFile(wrongKeyFile, []byte("incorrect horse battery staple\n"), 0600); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	file := path.Join(dir, "backup.snap")
+	ui := new(cli.MockUi)
+	save := &SnapshotSaveCommand{Ui: ui}
+	if code := save.Run([]string{"-http-addr=" + srv.httpAddr, "-encrypt-key=" + keyFile, file}); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !bytes.HasPrefix(data, []byte(snapshotScryptMagic)) {
+		t.Fatalf("bad: %q", data[:64])
+	}
+
+	// A wrong passphrase fails before the agent is contacted.
+	var requests int
+	fake := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer fake.Close()
+	ui = new(cli.MockUi)
+	c := &SnapshotRestoreCommand{Ui: ui}
+	args := []string{"-http-addr=" + strings.TrimPrefix(fake.URL, "http://"), "-force", "-decrypt-key=" + wrongKeyFile, file}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("bad: %d", code)
+	}
+	if !strings.Contains(ui.ErrorWriter.String(), "Failed to decrypt the snapshot") || requests != 0 {
+		t.Fatalf("bad: %d %#v", requests, ui.ErrorWriter.String())
+	}
+
+	if _, err := client.KV().Put(&api.KVPair{Key: "foo", Value: []byte("baz")}, nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	ui = new(cli.MockUi)
+	c = &SnapshotRestoreCommand{Ui: ui}
+	if code := c.Run([]string{"-http-addr=" + srv.httpAddr, "-force", "-decrypt-key=" + keyFile, file}); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	pair, _, err := client.KV().Get("foo", nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if pair == nil || string(pair.Value) != "bar" {
+		t.Fatalf("bad: %#v", pair)
+	}
+}
+
+func TestSnapshotRestoreCommand_cancel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The snapshot is large enough that it can't all be buffered on the
+	// way to the agent.
+	const size = 16 * 1024 * 1024
+	file := path.Join(dir, "backup.snap")
+	if err := ioutil.WriteFile(file, make([]byte, size), 0600); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The fake agent reads the start of the snapshot, then gets
+	// interrupted and stops reading.
+	shutdownCh := make(chan struct{})
+	doneCh := make(chan struct{})
+	fake := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/snapshot" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		io.ReadFull(r.Body, make([]byte, 1000))
+		close(shutdownCh)
+		<-doneCh
+	}))
+	defer fake.Close()
+	defer close(doneCh)
+
+	ui := new(cli.MockUi)
+	c := &SnapshotRestoreCommand{Ui: ui, ShutdownCh: shutdownCh}
+	args := []string{"-http-addr=" + strings.TrimPrefix(fake.URL, "http://"), "-skip-verify", "-force", file}
+	if code := c.Run(args); code != exitCancelled {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+
+	errs := ui.ErrorWriter.String()
+	if !strings.Contains(errs, "Cancelled after sending ") ||
+		!strings.Contains(errs, fmt.Sprintf(" of %d bytes, the snapshot was not restored", size)) {
+		t.Fatalf("bad: %#v", errs)
+	}
+}
@@ -1,21 +1,31 @@
 package command
 
 import (
-	"flag"
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/hashicorp/consul/api"
 	"github.com/hashicorp/consul/snapshot"
+	"github.com/hashicorp/raft"
 	"github.com/mitchellh/cli"
 )
 
 // SnapshotSaveCommand is a Command implementation that is used to save the
 // state of the Consul servers for disaster recovery.
 type SnapshotSaveCommand struct {
-	Ui cli.Ui
+	Ui         cli.Ui
+	ShutdownCh <-chan struct{}
+
+	// testStdout is used for writing snapshots to stdout, for testing.
+	testStdout io.Writer
 }
 
 func (c *SnapshotSaveCommand) Help() string {
@@ -38,21 +48,99 @@ Usage: consul snapshot save [options] FILE
 
     $ consul snapshot save -stale backup.snap
 
+  A stale snapshot may be missing the most recent changes, so a warning is
+  printed with how long it has been since the server last heard from the
+  leader. To take it from a particular server, point -http-addr at that
+  server's HTTP API:
+
+    $ consul snapshot save -stale -http-addr=10.0.1.5:8500 backup.snap
+
+  To write the snapshot to stdout, use "-" as the file name. The snapshot is
+  still verified as it is written, and all other output goes to stderr:
+
+    $ consul snapshot save - | gpg --encrypt > backup.snap.gpg
+
+  To encrypt the snapshot with a key kept in a file, so it can be stored
+  somewhere less trusted, such as a shared bucket:
+
+    $ openssl rand -hex 32 > backup.key
+    $ consul snapshot save -encrypt-key=backup.key backup.snap
+
+  The snapshot is written to a temporary file and verified before it is moved
+  into place, so a failed save never leaves a partial file behind. The progress
+  of the download is reported to stderr every second while it runs. If it's
+  interrupted, the download is stopped, the partial file is removed, and the
+  command exits with status 130.
+
+  With the -interval option, FILE is a directory and a snapshot is saved to it
+  periodically until the command is interrupted, keeping the newest -retain
+  snapshots:
+
+    $ consul snapshot save -interval=1h -retain=24 /var/backups/consul
+
+  With the -meta option, a JSON file describing where the snapshot came from
+  is saved next to it, such as "backup.snap.meta.json" for "backup.snap". It
+  has the datacenter, the version of the agent, the leader, the index and term
+  of the snapshot, the time it was taken, and the SHA-256 of the file. It's
+  only written once the snapshot has been verified, and "consul snapshot
+  inspect" shows it automatically:
+
+    $ consul snapshot save -meta backup.snap
+
   For a full list of options and examples, please see the Consul documentation.
 
-` + apiOptsText
+` + apiOptsText + `
+
+Snapshot Save Options:
+
+  -encrypt-key=<path>     Encrypt the snapshot with AES-256-GCM, using the key
+                          in the given file. The file holds a 32-byte key,
+                          either raw or hex or base64 encoded, such as one
+                          made with "openssl rand -hex 32", or else a
+                          passphrase of at least 8 characters, which a key is
+                          derived from with scrypt and a random salt kept in
+                          the snapshot. The snapshot is encrypted as it's
+                          received, so it never reaches the disk unencrypted.
+                          The same file must be given with -decrypt-key to
+                          restore or inspect it.
+
+  -interval=<dur>         Save a snapshot every interval until interrupted,
+                          rather than saving once. Snapshots are saved to the
+                          directory given as FILE, with names based on the
+                          time they were taken. A failed save is logged and
+                          the next one goes ahead as planned. The default
+                          value is 0, which saves a single snapshot.
+
+  -meta                   Save a metadata file next to the snapshot, named
+                          after it with a ".meta.json" suffix. This can't be
+                          used when writing the snapshot to stdout. The
+                          default value is false.
+
+  -retain=<int>           Number of snapshots to keep in the directory with
+                          -interval. After each successful save, the oldest
+                          snapshots over this number are deleted. The default
+                          value is 0, which keeps all of them.
+
+  -retries=<int>          Older name for -retry, which is kept so existing
+                          scripts keep working. Unlike other commands, a
+                          snapshot save is retried 3 times by default. The
+                          whole save is retried, including a partly received
+                          snapshot.
+`
 
 	return strings.TrimSpace(helpText)
 }
 
-func (c *SnapshotSaveCommand) Run(args []string) int {
-	cmdFlags := flag.NewFlagSet("get", flag.ContinueOnError)
+func (c *SnapshotSaveCommand) Run(args []string) (code int) {
+	cmdFlags, apiFlags := NewAPIFlagSet("get", c.Ui)
+	defer apiFlags.CheckTimeout(c.Ui, &code)
 	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
-	datacenter := cmdFlags.String("datacenter", "", "")
-	token := cmdFlags.String("token", "", "")
-	stale := cmdFlags.Bool("stale", false, "")
-	httpAddr := HTTPAddrFlag(cmdFlags)
-	if err := cmdFlags.Parse(args); err != nil {
+	cmdFlags.IntVar(&apiFlags.Retry, "retries", 3, "")
+	interval := cmdFlags.Duration("interval", 0, "")
+	retain := cmdFlags.Int("retain", 0, "")
+	meta := cmdFlags.Bool("meta", false, "")
+	encryptKey := cmdFlags.String("encrypt-key", "", "")
+	if err := parseFlags(c.Ui, cmdFlags, args); err != nil {
 		return 1
 	}
 
@@ -70,63 +158,451 @@ func (c *SnapshotSaveCommand) Run(args []string) int {
 		return 1
 	}
 
-	// Create and test the HTTP client
-	conf := api.DefaultConfig()
-	conf.Datacenter = *datacenter
-	conf.Address = *httpAddr
-	if *token != "" {
-		conf.Token = *token
+	switch {
+	case *interval < 0:
+		c.Ui.Error("-interval must not be negative")
+		return 1
+	case *retain < 0:
+		c.Ui.Error("-retain must not be negative")
+		return 1
+	case *retain > 0 && *interval == 0:
+		c.Ui.Error("-retain requires -interval")
+		return 1
+	case *interval > 0 && file == "-":
+		c.Ui.Error("Cannot write snapshots to stdout with -interval")
+		return 1
+	case *meta && file == "-":
+		c.Ui.Error("Cannot write a metadata file with -meta when writing the snapshot to stdout")
+		return 1
+	case *interval > 0:
+		if fi, err := os.Stat(file); err != nil {
+			c.Ui.Error(fmt.Sprintf("Error opening snapshot directory: %s", err))
+			return 1
+		} else if !fi.IsDir() {
+			c.Ui.Error(fmt.Sprintf("%s is not a directory (required with -interval)", file))
+			return 1
+		}
 	}
-	client, err := api.NewClient(conf)
+
+	var key *snapshotKey
+	if *encryptKey != "" {
+		var err error
+		if key, err = readSnapshotKey(*encryptKey); err != nil {
+			c.Ui.Error(fmt.Sprintf("Error! %s", err))
+			return 1
+		}
+	}
+
+	// Create and test the HTTP client
+	client, err := apiFlags.Client()
 	if err != nil {
 		c.Ui.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
 		return 1
 	}
 
-	// Take the snapshot.
+	if *interval > 0 {
+		return c.saveLoop(client, file, key, apiFlags.Stale, apiFlags, *interval, *retain, *meta)
+	}
+
+	start := time.Now()
+	ch := c.saveAsync(client, file, key, apiFlags.Stale, apiFlags, !apiFlags.Quiet)
+	var res snapshotSaveResult
+	select {
+	case res = <-ch:
+	case <-c.ShutdownCh:
+		// Stop the download, and wait for the partial file to be removed.
+		// If the save finished first, it carries on as usual.
+		apiFlags.Cancel()
+		if res = <-ch; res.err != nil {
+			c.Ui.Error(snapshotCancelledMessage(file, res.size))
+			return exitCancelled
+		}
+	}
+	if res.err != nil {
+		c.Ui.Error(res.err.Error())
+		return 1
+	}
+
+	// When the snapshot itself went to stdout, keep it clean by sending the
+	// result to stderr.
+	msg := fmt.Sprintf("Saved and verified snapshot to index %d (%d bytes)", res.meta.Index, res.size)
+	if file == "-" {
+		apiFlags.note(c.Ui, msg)
+	} else {
+		apiFlags.report(c.Ui, msg)
+	}
+	if apiFlags.Stale {
+		c.Ui.Warn(snapshotStaleWarning(res.qm))
+	}
+	if *meta {
+		if err := c.saveMeta(client, file, apiFlags, res, start); err != nil {
+			c.Ui.Error(err.Error())
+			return 1
+		}
+	}
+	return 0
+}
+
+// snapshotSaveResult is the outcome of a snapshot save. For a save which
+// failed while the snapshot was being received, size is the number of bytes
+// received so far.
+type snapshotSaveResult struct {
+	meta *raft.SnapshotMeta
+	qm   *api.QueryMeta
+	size int64
+	sum  []byte
+	err  error
+}
+
+// saveMeta writes the metadata file for a snapshot which was saved and
+// verified, reporting where it was written.
+func (c *SnapshotSaveCommand) saveMeta(client *api.Client, file string, apiFlags *APIFlags, res snapshotSaveResult,
+	taken time.Time) error {
+	m, err := newSnapshotMetaFile(client, apiFlags.Datacenter, res.meta, res.sum, taken)
+	if err != nil {
+		return fmt.Errorf("Error looking up snapshot metadata: %s", err)
+	}
+	if err := writeSnapshotMetaFile(file, m); err != nil {
+		return fmt.Errorf("Error writing snapshot metadata file: %s", err)
+	}
+	apiFlags.report(c.Ui, fmt.Sprintf("Saved snapshot metadata to %s", snapshotMetaPath(file)))
+	return nil
+}
+
+// snapshotStaleWarning describes how out of date a snapshot taken with -stale
+// may be, based on the query metadata from the server which took it.
+func snapshotStaleWarning(qm *api.QueryMeta) string {
+	var lag string
+	switch {
+	case qm == nil:
+		lag = "it's unknown how far behind the leader the server was"
+	case !qm.KnownLeader:
+		lag = "the server which took it didn't know of a leader"
+	case qm.LastContact == 0:
+		lag = "it was taken by the leader"
+	default:
+		lag = fmt.Sprintf("the server which took it last heard from the leader %s ago",
+			qm.LastContact)
+	}
+	return fmt.Sprintf("Warning! This snapshot was taken with -stale and may be missing "+
+		"recent changes: %s", lag)
+}
+
+// saveAsync takes a snapshot in the background, so a shutdown doesn't have to
+// wait for it, and sends the result on the returned channel. If a key is
+// given, the snapshot is encrypted with it. With report set, the progress of
+// the download is reported as it goes.
+func (c *SnapshotSaveCommand) saveAsync(client *api.Client, file string, key *snapshotKey, stale bool, apiFlags *APIFlags,
+	report bool) <-chan snapshotSaveResult {
+	ch := make(chan snapshotSaveResult, 1)
+	go func() {
+		ch <- c.saveRetry(client, file, key, stale, apiFlags, report)
+	}()
+	return ch
+}
+
+// saveRetry takes a snapshot, retrying with a backoff on transient errors.
+func (c *SnapshotSaveCommand) saveRetry(client *api.Client, file string, key *snapshotKey, stale bool, apiFlags *APIFlags,
+	report bool) snapshotSaveResult {
+	for attempt := 0; ; attempt++ {
+		var res snapshotSaveResult
+		if file == "-" {
+			res = c.saveStdout(client, key, stale, report)
+		} else {
+			res = c.save(client, file, key, stale, report)
+		}
+		err := res.err
+		if err == nil {
+			return res
+		}
+
+		// A cancelled save keeps the number of bytes it got, to report.
+		if apiFlags.Cancelled() {
+			return snapshotSaveResult{size: res.size, err: err}
+		}
+		_, retryable := err.(*retryableError)
+		if !retryable || !apiFlags.retryWait(apiFlags.ctx, attempt, err.Error()) {
+			if isPermissionDenied(err) {
+				err = errors.New(apiFlags.errorMessage("Error saving snapshot", err, snapshotDenial("save a snapshot")))
+			}
+			return snapshotSaveResult{err: err}
+		}
+	}
+}
+
+// saveLoop saves a snapshot to the directory every interval until it's shut
+// down, deleting the oldest ones to keep at most retain of them. With meta
+// set, a metadata file is saved next to each one. A failed save is only
+// logged. A shutdown lets a save in progress finish first,
+// unless a second one is triggered while waiting.
+func (c *SnapshotSaveCommand) saveLoop(client *api.Client, dir string, key *snapshotKey, stale bool, apiFlags *APIFlags,
+	interval time.Duration, retain int, meta bool) int {
+	for {
+		start := time.Now()
+		file := filepath.Join(dir, snapshotFileName(start))
+
+		shutdown := false
+		ch := c.saveAsync(client, file, key, stale, apiFlags, false)
+		var res snapshotSaveResult
+		select {
+		case res = <-ch:
+		case <-c.ShutdownCh:
+			c.Ui.Warn("Shutdown triggered, finishing the snapshot in progress (interrupt again to abort)")
+			select {
+			case res = <-ch:
+			case <-c.ShutdownCh:
+				apiFlags.Cancel()
+				if res = <-ch; res.err != nil {
+					c.Ui.Error(snapshotCancelledMessage(file, res.size))
+					return exitCancelled
+				}
+			}
+			shutdown = true
+		}
+
+		if res.err != nil {
+			c.Ui.Error(res.err.Error())
+		} else {
+			apiFlags.report(c.Ui, fmt.Sprintf("Saved and verified snapshot to %s at index %d (%d bytes)",
+				file, res.meta.Index, res.size))
+			if stale {
+				c.Ui.Warn(snapshotStaleWarning(res.qm))
+			}
+			if meta {
+				if err := c.saveMeta(client, file, apiFlags, res, start); err != nil {
+					c.Ui.Error(err.Error())
+				}
+			}
+			if retain > 0 {
+				c.rotate(apiFlags, dir, retain)
+			}
+		}
+		if shutdown {
+			return 0
+		}
+
+		select {
+		case <-time.After(start.Add(interval).Sub(time.Now())):
+		case <-c.ShutdownCh:
+			return 0
+		}
+	}
+}
+
+// snapshotFileName returns the name of a snapshot taken at the given time
+// with -interval. The names sort in the order the snapshots were taken.
+func snapshotFileName(t time.Time) string {
+	return "consul-" + t.UTC().Format("20060102-150405.000") + ".snap"
+}
+
+// rotate deletes the oldest snapshots in the directory, leaving the newest
+// retain of them, along with their metadata files. Only files named like
+// those saved with -interval are considered.
+func (c *SnapshotSaveCommand) rotate(apiFlags *APIFlags, dir string, retain int) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error listing old snapshots: %s", err))
+		return
+	}
+
+	var names []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.Mode().IsRegular() && strings.HasPrefix(name, "consul-") && strings.HasSuffix(name, ".snap") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for len(names) > retain {
+		file := filepath.Join(dir, names[0])
+		names = names[1:]
+		if err := os.Remove(file); err != nil {
+			c.Ui.Error(fmt.Sprintf("Error removing old snapshot: %s", err))
+			continue
+		}
+		apiFlags.report(c.Ui, fmt.Sprintf("Removed old snapshot %s", file))
+		if err := os.Remove(snapshotMetaPath(file)); err != nil && !os.IsNotExist(err) {
+			c.Ui.Error(fmt.Sprintf("Error removing old snapshot metadata: %s", err))
+		}
+	}
+}
+
+// saveStdout takes a snapshot and streams it to stdout, verifying it on the
+// way through, and encrypting it if a key is given. Since the data can't be
+// taken back once it has been written, errors are only retryable if nothing
+// was written yet.
+func (c *SnapshotSaveCommand) saveStdout(client *api.Client, key *snapshotKey, stale bool, report bool) snapshotSaveResult {
 	snap, qm, err := client.Snapshot().Save(&api.QueryOptions{
-		AllowStale: *stale,
+		AllowStale: stale,
 	})
 	if err != nil {
-		c.Ui.Error(fmt.Sprintf("Error saving snapshot: %s", err))
-		return 1
+		return snapshotSaveResult{err: retryableIf(err, "Error saving snapshot: %s")}
 	}
 	defer snap.Close()
 
-	// Save the file.
-	f, err := os.Create(file)
+	var in io.Reader = snap
+	if report {
+		progress := newSnapshotProgress(snap, c.Ui, "Received", 0)
+		defer progress.Stop()
+		in = progress
+	}
+
+	var w io.Writer = c.stdout()
+	var enc *snapshotEncrypter
+	if key != nil {
+		if enc, err = newSnapshotEncrypter(w, key); err != nil {
+			return snapshotSaveResult{err: fmt.Errorf("Error encrypting snapshot: %s", err)}
+		}
+		w = enc
+	}
+	out := &countingWriter{w: w}
+	tee := io.TeeReader(in, out)
+	meta, err := snapshot.Verify(tee)
 	if err != nil {
-		c.Ui.Error(fmt.Sprintf("Error creating snapshot file: %s", err))
-		return 1
+		return snapshotSaveResult{size: out.n, err: fmt.Errorf("Error verifying snapshot: %s", err)}
+	}
+
+	// The verifier may stop before the end of the stream, so pass along
+	// whatever is left.
+	if _, err := io.Copy(ioutil.Discard, tee); err != nil {
+		return snapshotSaveResult{size: out.n, err: fmt.Errorf("Error writing snapshot: %s", err)}
+	}
+	if enc != nil {
+		if err := enc.Close(); err != nil {
+			return snapshotSaveResult{size: out.n, err: fmt.Errorf("Error writing snapshot: %s", err)}
+		}
 	}
-	if _, err := io.Copy(f, snap); err != nil {
+	return snapshotSaveResult{meta: meta, qm: qm, size: out.n}
+}
+
+// stdout returns the writer for snapshot data written to stdout.
+func (c *SnapshotSaveCommand) stdout() io.Writer {
+	if c.testStdout != nil {
+		return c.testStdout
+	}
+	return os.Stdout
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// save takes a snapshot and writes it to the given file, encrypted if a key is
+// given. The snapshot is written to a temporary file in the same directory
+// and verified before being renamed into place, so a failed save never leaves
+// behind a partial file. The result has the snapshot's metadata, the size of
+// the snapshot, and the SHA-256 of the file. With report set, the progress of
+// the download is reported as it goes.
+func (c *SnapshotSaveCommand) save(client *api.Client, file string, key *snapshotKey, stale bool, report bool) snapshotSaveResult {
+	snap, qm, err := client.Snapshot().Save(&api.QueryOptions{
+		AllowStale: stale,
+	})
+	if err != nil {
+		return snapshotSaveResult{err: retryableIf(err, "Error saving snapshot: %s")}
+	}
+	defer snap.Close()
+
+	// Save to a temporary file, making sure it's cleaned up on failure.
+	f, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+".tmp")
+	if err != nil {
+		return snapshotSaveResult{err: fmt.Errorf("Error creating snapshot file: %s", err)}
+	}
+	tmp := f.Name()
+	success := false
+	defer func() {
+		if !success {
+			os.Remove(tmp)
+		}
+	}()
+
+	var in io.Reader = snap
+	var progress *snapshotProgress
+	if report {
+		progress = newSnapshotProgress(snap, c.Ui, "Received", 0)
+		in = progress
+	}
+	h := sha256.New()
+	var out io.Writer = io.MultiWriter(f, h)
+	var enc *snapshotEncrypter
+	if key != nil {
+		if enc, err = newSnapshotEncrypter(out, key); err != nil {
+			f.Close()
+			return snapshotSaveResult{err: fmt.Errorf("Error encrypting snapshot: %s", err)}
+		}
+		out = enc
+	}
+	size, err := io.Copy(out, in)
+	if err == nil && enc != nil {
+		err = enc.Close()
+	}
+	if progress != nil {
+		progress.Stop()
+	}
+	if err != nil {
 		f.Close()
-		c.Ui.Error(fmt.Sprintf("Error writing snapshot file: %s", err))
-		return 1
+		return snapshotSaveResult{size: size, err: retryableIf(err, "Error writing snapshot file: %s")}
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return snapshotSaveResult{err: fmt.Errorf("Error syncing snapshot file: %s", err)}
 	}
 	if err := f.Close(); err != nil {
-		c.Ui.Error(fmt.Sprintf("Error closing snapshot file after writing: %s", err))
-		return 1
+		return snapshotSaveResult{err: fmt.Errorf("Error closing snapshot file after writing: %s", err)}
 	}
 
-	// Read it back to verify.
-	f, err = os.Open(file)
+	// Read it back to verify, which also checks that it can be decrypted.
+	f, err = os.Open(tmp)
 	if err != nil {
-		c.Ui.Error(fmt.Sprintf("Error opening snapshot file for verify: %s", err))
-		return 1
+		return snapshotSaveResult{err: fmt.Errorf("Error opening snapshot file for verify: %s", err)}
 	}
-	if _, err := snapshot.Verify(f); err != nil {
+	meta, err := verifySnapshot(f, key)
+	if err != nil {
 		f.Close()
-		c.Ui.Error(fmt.Sprintf("Error verifying snapshot file: %s", err))
-		return 1
+		return snapshotSaveResult{err: fmt.Errorf("Error verifying snapshot file: %s", err)}
 	}
 	if err := f.Close(); err != nil {
-		c.Ui.Error(fmt.Sprintf("Error closing snapshot file after verify: %s", err))
-		return 1
+		return snapshotSaveResult{err: fmt.Errorf("Error closing snapshot file after verify: %s", err)}
 	}
 
-	c.Ui.Info(fmt.Sprintf("Saved and verified snapshot to index %d", qm.LastIndex))
-	return 0
+	if err := os.Rename(tmp, file); err != nil {
+		return snapshotSaveResult{err: fmt.Errorf("Error renaming snapshot file: %s", err)}
+	}
+	success = true
+	return snapshotSaveResult{meta: meta, qm: qm, size: size, sum: h.Sum(nil)}
+}
+
+// snapshotCancelledMessage describes a save which was cancelled after
+// receiving the given number of bytes. A partial file is always removed, but
+// what was already written to stdout can't be taken back.
+func snapshotCancelledMessage(file string, size int64) string {
+	if file == "-" {
+		return fmt.Sprintf("Cancelled after receiving %d bytes, the snapshot written to stdout is incomplete", size)
+	}
+	return fmt.Sprintf("Cancelled after receiving %d bytes, snapshot not saved", size)
+}
+
+// retryableError marks an error from a snapshot save that is worth retrying.
+type retryableError struct {
+	error
+}
+
+// retryableIf formats the error with the given format, marking the result as
+// retryable if the error is transient.
+func retryableIf(err error, format string) error {
+	wrapped := fmt.Errorf(format, err)
+	if isTransientError(err) {
+		return &retryableError{wrapped}
+	}
+	return wrapped
 }
 
 func (c *SnapshotSaveCommand) Synopsis() string {
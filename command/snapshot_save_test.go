@@ -1,12 +1,24 @@
 package command
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path"
+	"reflect"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/snapshot"
 	"github.com/mitchellh/cli"
 )
 
@@ -34,6 +46,30 @@ func TestSnapshotSaveCommand_Validation(t *testing.T) {
 			[]string{"foo", "bar", "baz"},
 			"Too many arguments",
 		},
+		"negative interval": {
+			[]string{"-interval=-1s", "foo"},
+			"-interval must not be negative",
+		},
+		"negative retain": {
+			[]string{"-interval=1s", "-retain=-1", "foo"},
+			"-retain must not be negative",
+		},
+		"retain without interval": {
+			[]string{"-retain=2", "foo"},
+			"-retain requires -interval",
+		},
+		"interval to stdout": {
+			[]string{"-interval=1s", "-"},
+			"Cannot write snapshots to stdout",
+		},
+		"meta to stdout": {
+			[]string{"-meta", "-"},
+			"Cannot write a metadata file with -meta",
+		},
+		"interval to file": {
+			[]string{"-interval=1s", "snapshot_save_test.go"},
+			"is not a directory",
+		},
 	}
 
 	for name, tc := range cases {
@@ -91,4 +127,431 @@ func TestSnapshotSaveCommand_Run(t *testing.T) {
 	if err := client.Snapshot().Restore(nil, f); err != nil {
 		t.Fatalf("err: %v", err)
 	}
+
+	// A stale snapshot comes with a warning.
+	ui = new(cli.MockUi)
+	c = &SnapshotSaveCommand{Ui: ui}
+	if code := c.Run([]string{"-http-addr=" + srv.httpAddr, "-stale", file}); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if output := ui.ErrorWriter.String(); !strings.Contains(output, "Warning! This snapshot was taken with -stale") ||
+		!strings.Contains(output, "it was taken by the leader") {
+		t.Fatalf("bad: %#v", output)
+	}
+}
+
+func TestSnapshotSaveCommand_Meta(t *testing.T) {
+	srv, _ := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ui := new(cli.MockUi)
+	c := &SnapshotSaveCommand{Ui: ui}
+	file := path.Join(dir, "backup.snap")
+	before := time.Now().UTC().Truncate(time.Second)
+	if code := c.Run([]string{"-http-addr=" + srv.httpAddr, "-meta", file}); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if output := ui.OutputWriter.String(); !strings.Contains(output, "Saved snapshot metadata to "+file+".meta.json") {
+		t.Fatalf("bad: %#v", output)
+	}
+
+	m, err := readSnapshotMetaFile(file)
+	if err != nil || m == nil {
+		t.Fatalf("bad: %#v %v", m, err)
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer f.Close()
+	meta, err := snapshot.Verify(f)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	sum := sha256.Sum256(data)
+
+	if m.Datacenter != "dc1" || m.Leader == "" || m.Index != meta.Index || m.Term != meta.Term ||
+		m.SHA256 != hex.EncodeToString(sum[:]) || m.Time.Before(before) || m.Time.After(time.Now()) {
+		t.Fatalf("bad: %#v", m)
+	}
+
+	// Rotating snapshots saved with -interval removes their metadata files
+	// too.
+	for _, name := range []string{"consul-1.snap", "consul-2.snap"} {
+		for _, suffix := range []string{"", ".meta.json"} {
+			if err := ioutil.WriteFile(path.Join(dir, name+suffix), []byte("x"), 0600); err != nil {
+				t.Fatalf("err: %v", err)
+			}
+		}
+	}
+	c.rotate(&APIFlags{}, dir, 1)
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+	expected := []string{"backup.snap", "backup.snap.meta.json", "consul-2.snap", "consul-2.snap.meta.json"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("bad: %v", names)
+	}
+}
+
+func TestSnapshotStaleWarning(t *testing.T) {
+	cases := []struct {
+		qm       *api.QueryMeta
+		expected string
+	}{
+		{nil, "it's unknown how far behind"},
+		{&api.QueryMeta{KnownLeader: true}, "it was taken by the leader"},
+		{&api.QueryMeta{KnownLeader: false}, "didn't know of a leader"},
+		{&api.QueryMeta{KnownLeader: true, LastContact: 1500 * time.Millisecond},
+			"last heard from the leader 1.5s ago"},
+	}
+	for _, tc := range cases {
+		if warning := snapshotStaleWarning(tc.qm); !strings.Contains(warning, tc.expected) {
+			t.Fatalf("bad: %#v %q", tc.qm, warning)
+		}
+	}
+}
+
+func TestSnapshotSaveCommand_Retry(t *testing.T) {
+	srv, client := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	snap, _, err := client.Snapshot().Save(nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	data, err := ioutil.ReadAll(snap)
+	snap.Close()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The fake agent fails with a server error, then drops the connection
+	// partway through the snapshot, then finally succeeds.
+	var requests int
+	fake := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch requests {
+		case 1:
+			w.WriteHeader(http.StatusInternalServerError)
+		case 2:
+			w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+			w.Write(data[:len(data)/2])
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Errorf("err: %v", err)
+				return
+			}
+			conn.Close()
+		default:
+			w.Write(data)
+		}
+	}))
+	defer fake.Close()
+
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ui := new(cli.MockUi)
+	c := &SnapshotSaveCommand{Ui: ui}
+
+	file := path.Join(dir, "backup.snap")
+	args := []string{
+		"-http-addr=" + strings.TrimPrefix(fake.URL, "http://"),
+		"-retry-interval=1ms",
+		file,
+	}
+
+	code := c.Run(args)
+	if code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	if requests != 3 {
+		t.Fatalf("bad: made %d requests", requests)
+	}
+	if n := strings.Count(ui.ErrorWriter.String(), "retrying in"); n != 2 {
+		t.Fatalf("bad: %d retries reported: %#v", n, ui.ErrorWriter.String())
+	}
+	if !strings.Contains(ui.OutputWriter.String(), fmt.Sprintf("(%d bytes)", len(data))) {
+		t.Fatalf("bad: %#v", ui.OutputWriter.String())
+	}
+
+	saved, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !bytes.Equal(saved, data) {
+		t.Fatalf("bad: saved snapshot doesn't match")
+	}
+
+	// Only the final file should be left behind.
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("bad: %d files left in %s", len(files), dir)
+	}
+}
+
+func TestSnapshotSaveCommand_RetryFailure(t *testing.T) {
+	cases := map[string]struct {
+		status   int
+		body     string
+		args     []string
+		requests int
+	}{
+		"retries exhausted": {
+			http.StatusInternalServerError,
+			"",
+			[]string{"-retries=2"},
+			3,
+		},
+		"retry flag": {
+			http.StatusInternalServerError,
+			"",
+			[]string{"-retry=1"},
+			2,
+		},
+		"not retryable": {
+			http.StatusForbidden,
+			"",
+			nil,
+			1,
+		},
+		"corrupt snapshot": {
+			http.StatusOK,
+			"not a snapshot",
+			nil,
+			1,
+		},
+	}
+
+	for name, tc := range cases {
+		var requests int
+		fake := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			w.WriteHeader(tc.status)
+			w.Write([]byte(tc.body))
+		}))
+
+		dir, err := ioutil.TempDir("", "snapshot")
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+
+		ui := new(cli.MockUi)
+		c := &SnapshotSaveCommand{Ui: ui}
+
+		args := []string{"-http-addr=" + strings.TrimPrefix(fake.URL, "http://"), "-retry-interval=1ms"}
+		args = append(args, tc.args...)
+		code := c.Run(append(args, path.Join(dir, "backup.snap")))
+		fake.Close()
+		if code != 1 {
+			t.Fatalf("%s: bad: %d", name, code)
+		}
+		if requests != tc.requests {
+			t.Fatalf("%s: bad: made %d requests", name, requests)
+		}
+
+		files, err := ioutil.ReadDir(dir)
+		os.RemoveAll(dir)
+		if err != nil {
+			t.Fatalf("%s: err: %v", name, err)
+		}
+		if len(files) != 0 {
+			t.Fatalf("%s: bad: %d files left behind", name, len(files))
+		}
+	}
+}
+
+func TestSnapshotSaveCommand_Interval(t *testing.T) {
+	srv, _ := testAgentWithAPIClient(t)
+	defer srv.Shutdown()
+	waitForLeader(t, srv.httpAddr)
+
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Other files in the directory are left alone.
+	other := path.Join(dir, "consul-notes.txt")
+	if err := ioutil.WriteFile(other, []byte("keep"), 0600); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	shutdownCh := make(chan struct{})
+	go func() {
+		time.Sleep(time.Second)
+		shutdownCh <- struct{}{}
+	}()
+
+	ui := new(cli.MockUi)
+	c := &SnapshotSaveCommand{Ui: ui, ShutdownCh: shutdownCh}
+	args := []string{
+		"-http-addr=" + srv.httpAddr,
+		"-interval=100ms",
+		"-retain=2",
+		dir,
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+
+	var saved, removed []string
+	for _, line := range strings.Split(strings.TrimSpace(ui.OutputWriter.String()), "\n") {
+		var file string
+		switch {
+		case strings.HasPrefix(line, "Saved and verified snapshot to "):
+			file = strings.Fields(line)[5]
+			saved = append(saved, path.Base(file))
+		case strings.HasPrefix(line, "Removed old snapshot "):
+			file = strings.Fields(line)[3]
+			removed = append(removed, path.Base(file))
+		default:
+			t.Fatalf("bad: %q", line)
+		}
+		if path.Dir(file) != dir {
+			t.Fatalf("bad: %q", line)
+		}
+	}
+	if len(saved) < 3 || len(removed) != len(saved)-2 {
+		t.Fatalf("bad: saved %v, removed %v", saved, removed)
+	}
+
+	// The oldest snapshots were removed in order, leaving the newest two.
+	for i := range removed {
+		if removed[i] != saved[i] {
+			t.Fatalf("bad: saved %v, removed %v", saved, removed)
+		}
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+	expected := append(saved[len(saved)-2:], "consul-notes.txt")
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("bad: %v", names)
+	}
+}
+
+func TestSnapshotSaveCommand_IntervalFailure(t *testing.T) {
+	// Failures are logged and the loop carries on. A shutdown during the
+	// third save waits for it to finish.
+	shutdownCh := make(chan struct{})
+	var lock sync.Mutex
+	var requests int
+	fake := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		requests++
+		if requests == 3 {
+			shutdownCh <- struct{}{}
+		}
+		lock.Unlock()
+		time.Sleep(100 * time.Millisecond)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer fake.Close()
+
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ui := new(cli.MockUi)
+	c := &SnapshotSaveCommand{Ui: ui, ShutdownCh: shutdownCh}
+	args := []string{
+		"-http-addr=" + strings.TrimPrefix(fake.URL, "http://"),
+		"-interval=10ms",
+		"-retries=0",
+		dir,
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	errors := strings.Count(ui.ErrorWriter.String(), "Error saving snapshot")
+	if requests != 3 || errors != 3 {
+		t.Fatalf("bad: %d requests, %d errors. %#v", requests, errors, ui.ErrorWriter.String())
+	}
+	if !strings.Contains(ui.ErrorWriter.String(), "finishing the snapshot in progress") {
+		t.Fatalf("bad: %#v", ui.ErrorWriter.String())
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(files) != 0 {
+		t.Fatalf("bad: %d files left behind", len(files))
+	}
+}
+
+func TestSnapshotSaveCommand_cancel(t *testing.T) {
+	// The fake agent sends the start of a snapshot, then gets interrupted
+	// before sending the rest, which never comes unless it's cancelled.
+	shutdownCh := make(chan struct{})
+	fake := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(make([]byte, 1000))
+		w.(http.Flusher).Flush()
+		close(shutdownCh)
+		<-r.Context().Done()
+	}))
+	defer fake.Close()
+
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ui := new(cli.MockUi)
+	c := &SnapshotSaveCommand{Ui: ui, ShutdownCh: shutdownCh}
+	args := []string{"-http-addr=" + strings.TrimPrefix(fake.URL, "http://"), path.Join(dir, "backup.snap")}
+	if code := c.Run(args); code != exitCancelled {
+		t.Fatalf("bad: %d. %#v", code, ui.ErrorWriter.String())
+	}
+	// The interrupt may come before any of the snapshot is received.
+	errs := ui.ErrorWriter.String()
+	if !strings.Contains(errs, "Cancelled after receiving ") || !strings.Contains(errs, " bytes, snapshot not saved") {
+		t.Fatalf("bad: %#v", errs)
+	}
+
+	// The partial file was removed.
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(files) != 0 {
+		t.Fatalf("bad: %#v", files)
+	}
 }
@@ -15,9 +15,51 @@ import (
 var Commands map[string]cli.CommandFactory
 
 func init() {
-	ui := &cli.BasicUi{Writer: os.Stdout}
+	ui := &cli.BasicUi{Writer: os.Stdout, ErrorWriter: os.Stderr}
 
 	Commands = map[string]cli.CommandFactory{
+		"acl": func() (cli.Command, error) {
+			return &command.ACLCommand{
+				Ui: ui,
+			}, nil
+		},
+
+		"acl token": func() (cli.Command, error) {
+			return &command.ACLTokenCommand{
+				Ui: ui,
+			}, nil
+		},
+
+		"acl token create": func() (cli.Command, error) {
+			return &command.ACLTokenCreateCommand{
+				Ui: ui,
+			}, nil
+		},
+
+		"acl token delete": func() (cli.Command, error) {
+			return &command.ACLTokenDeleteCommand{
+				Ui: ui,
+			}, nil
+		},
+
+		"acl token list": func() (cli.Command, error) {
+			return &command.ACLTokenListCommand{
+				Ui: ui,
+			}, nil
+		},
+
+		"acl token read": func() (cli.Command, error) {
+			return &command.ACLTokenReadCommand{
+				Ui: ui,
+			}, nil
+		},
+
+		"acl token update": func() (cli.Command, error) {
+			return &command.ACLTokenUpdateCommand{
+				Ui: ui,
+			}, nil
+		},
+
 		"agent": func() (cli.Command, error) {
 			return &agent.Command{
 				Revision:          version.GitCommit,
@@ -29,6 +71,30 @@ func init() {
 			}, nil
 		},
 
+		"catalog": func() (cli.Command, error) {
+			return &command.CatalogCommand{
+				Ui: ui,
+			}, nil
+		},
+
+		"catalog datacenters": func() (cli.Command, error) {
+			return &command.CatalogDatacentersCommand{
+				Ui: ui,
+			}, nil
+		},
+
+		"catalog nodes": func() (cli.Command, error) {
+			return &command.CatalogNodesCommand{
+				Ui: ui,
+			}, nil
+		},
+
+		"catalog services": func() (cli.Command, error) {
+			return &command.CatalogServicesCommand{
+				Ui: ui,
+			}, nil
+		},
+
 		"configtest": func() (cli.Command, error) {
 			return &command.ConfigTestCommand{
 				Ui: ui,
@@ -41,6 +107,18 @@ func init() {
 			}, nil
 		},
 
+		"event fire": func() (cli.Command, error) {
+			return &command.EventFireCommand{
+				Ui: ui,
+			}, nil
+		},
+
+		"event list": func() (cli.Command, error) {
+			return &command.EventListCommand{
+				Ui: ui,
+			}, nil
+		},
+
 		"exec": func() (cli.Command, error) {
 			return &command.ExecCommand{
 				ShutdownCh: makeShutdownCh(),
@@ -60,6 +138,24 @@ func init() {
 			}, nil
 		},
 
+		"kv backup": func() (cli.Command, error) {
+			return &command.KVBackupCommand{
+				Ui: ui,
+			}, nil
+		},
+
+		"kv checksum": func() (cli.Command, error) {
+			return &command.KVChecksumCommand{
+				Ui: ui,
+			}, nil
+		},
+
+		"kv copy": func() (cli.Command, error) {
+			return &command.KVCopyCommand{
+				Ui: ui,
+			}, nil
+		},
+
 		"kv delete": func() (cli.Command, error) {
 			return &command.KVDeleteCommand{
 				Ui: ui,
@@ -78,18 +174,82 @@ func init() {
 			}, nil
 		},
 
+		"kv diff": func() (cli.Command, error) {
+			return &command.KVDiffCommand{
+				Ui: ui,
+			}, nil
+		},
+
 		"kv export": func() (cli.Command, error) {
 			return &command.KVExportCommand{
-				Ui: ui,
+				Ui:         ui,
+				ShutdownCh: makeShutdownCh(),
 			}, nil
 		},
 
 		"kv import": func() (cli.Command, error) {
 			return &command.KVImportCommand{
+				Ui:         ui,
+				ShutdownCh: makeShutdownCh(),
+			}, nil
+		},
+
+		"kv export-dir": func() (cli.Command, error) {
+			return &command.KVExportDirCommand{
+				Ui: ui,
+			}, nil
+		},
+
+		"kv import-dir": func() (cli.Command, error) {
+			return &command.KVImportDirCommand{
+				Ui: ui,
+			}, nil
+		},
+
+		"kv patch": func() (cli.Command, error) {
+			return &command.KVPatchCommand{
+				Ui: ui,
+			}, nil
+		},
+
+		"kv prune": func() (cli.Command, error) {
+			return &command.KVPruneCommand{
+				Ui: ui,
+			}, nil
+		},
+
+		"kv render": func() (cli.Command, error) {
+			return &command.KVRenderCommand{
+				Ui: ui,
+			}, nil
+		},
+
+		"kv rollback": func() (cli.Command, error) {
+			return &command.KVRollbackCommand{
+				Ui: ui,
+			}, nil
+		},
+
+		"kv stats": func() (cli.Command, error) {
+			return &command.KVStatsCommand{
 				Ui: ui,
 			}, nil
 		},
 
+		"kv lock": func() (cli.Command, error) {
+			return &command.KVLockCommand{
+				Ui:         ui,
+				ShutdownCh: makeShutdownCh(),
+			}, nil
+		},
+
+		"kv watch": func() (cli.Command, error) {
+			return &command.KVWatchCommand{
+				Ui:         ui,
+				ShutdownCh: makeShutdownCh(),
+			}, nil
+		},
+
 		"join": func() (cli.Command, error) {
 			return &command.JoinCommand{
 				Ui: ui,
@@ -146,12 +306,96 @@ func init() {
 			}, nil
 		},
 
+		"operator raft": func() (cli.Command, error) {
+			return &command.OperatorRaftCommand{
+				Ui: ui,
+			}, nil
+		},
+
+		"operator raft list-peers": func() (cli.Command, error) {
+			return &command.OperatorRaftListCommand{
+				Ui: ui,
+			}, nil
+		},
+
+		"operator raft remove-peer": func() (cli.Command, error) {
+			return &command.OperatorRaftRemoveCommand{
+				Ui: ui,
+			}, nil
+		},
+
+		"health": func() (cli.Command, error) {
+			return &command.HealthCommand{
+				Ui: ui,
+			}, nil
+		},
+
+		"health checks": func() (cli.Command, error) {
+			return &command.HealthChecksCommand{
+				Ui: ui,
+			}, nil
+		},
+
+		"health service": func() (cli.Command, error) {
+			return &command.HealthServiceCommand{
+				Ui: ui,
+			}, nil
+		},
+
+		"health state": func() (cli.Command, error) {
+			return &command.HealthStateCommand{
+				Ui: ui,
+			}, nil
+		},
+
 		"info": func() (cli.Command, error) {
 			return &command.InfoCommand{
 				Ui: ui,
 			}, nil
 		},
 
+		"query": func() (cli.Command, error) {
+			return &command.QueryCommand{
+				Ui: ui,
+			}, nil
+		},
+
+		"query create": func() (cli.Command, error) {
+			return &command.QueryCreateCommand{
+				Ui: ui,
+			}, nil
+		},
+
+		"query delete": func() (cli.Command, error) {
+			return &command.QueryDeleteCommand{
+				Ui: ui,
+			}, nil
+		},
+
+		"query execute": func() (cli.Command, error) {
+			return &command.QueryExecuteCommand{
+				Ui: ui,
+			}, nil
+		},
+
+		"query list": func() (cli.Command, error) {
+			return &command.QueryListCommand{
+				Ui: ui,
+			}, nil
+		},
+
+		"query read": func() (cli.Command, error) {
+			return &command.QueryReadCommand{
+				Ui: ui,
+			}, nil
+		},
+
+		"query update": func() (cli.Command, error) {
+			return &command.QueryUpdateCommand{
+				Ui: ui,
+			}, nil
+		},
+
 		"reload": func() (cli.Command, error) {
 			return &command.ReloadCommand{
 				Ui: ui,
@@ -164,6 +408,42 @@ func init() {
 			}, nil
 		},
 
+		"session": func() (cli.Command, error) {
+			return &command.SessionCommand{
+				Ui: ui,
+			}, nil
+		},
+
+		"session create": func() (cli.Command, error) {
+			return &command.SessionCreateCommand{
+				Ui: ui,
+			}, nil
+		},
+
+		"session destroy": func() (cli.Command, error) {
+			return &command.SessionDestroyCommand{
+				Ui: ui,
+			}, nil
+		},
+
+		"session info": func() (cli.Command, error) {
+			return &command.SessionInfoCommand{
+				Ui: ui,
+			}, nil
+		},
+
+		"session list": func() (cli.Command, error) {
+			return &command.SessionListCommand{
+				Ui: ui,
+			}, nil
+		},
+
+		"session renew": func() (cli.Command, error) {
+			return &command.SessionRenewCommand{
+				Ui: ui,
+			}, nil
+		},
+
 		"snapshot": func() (cli.Command, error) {
 			return &command.SnapshotCommand{
 				Ui: ui,
@@ -172,13 +452,15 @@ func init() {
 
 		"snapshot restore": func() (cli.Command, error) {
 			return &command.SnapshotRestoreCommand{
-				Ui: ui,
+				Ui:         ui,
+				ShutdownCh: makeShutdownCh(),
 			}, nil
 		},
 
 		"snapshot save": func() (cli.Command, error) {
 			return &command.SnapshotSaveCommand{
-				Ui: ui,
+				Ui:         ui,
+				ShutdownCh: makeShutdownCh(),
 			}, nil
 		},
 
@@ -188,6 +470,12 @@ func init() {
 			}, nil
 		},
 
+		"snapshot diff": func() (cli.Command, error) {
+			return &command.SnapshotDiffCommand{
+				Ui: ui,
+			}, nil
+		},
+
 		"version": func() (cli.Command, error) {
 			return &command.VersionCommand{
 				HumanVersion: version.GetHumanVersion(),
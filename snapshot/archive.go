@@ -221,3 +221,23 @@ func read(in io.Reader, metadata *raft.SnapshotMeta, snap io.Writer) error {
 
 	return nil
 }
+
+// readMeta takes a reader and extracts just the snapshot metadata, which is
+// the first file in the archive, without reading the rest. Since the hashes
+// are at the end of the archive, the metadata can't be verified.
+func readMeta(in io.Reader, metadata *raft.SnapshotMeta) error {
+	archive := tar.NewReader(in)
+	hdr, err := archive.Next()
+	if err != nil {
+		return fmt.Errorf("failed reading snapshot: %v", err)
+	}
+	if hdr.Name != "meta.json" {
+		return fmt.Errorf("unexpected file %q at the start of snapshot", hdr.Name)
+	}
+
+	dec := json.NewDecoder(archive)
+	if err := dec.Decode(&metadata); err != nil {
+		return fmt.Errorf("failed to decode snapshot metadata: %v", err)
+	}
+	return nil
+}
@@ -63,6 +63,31 @@ func TestArchive(t *testing.T) {
 	}
 }
 
+func TestArchive_readMeta(t *testing.T) {
+	metadata := raft.SnapshotMeta{
+		Index: 2005,
+		Term:  2011,
+		Size:  1024,
+	}
+	var archive bytes.Buffer
+	if err := write(&archive, &metadata, io.LimitReader(rand.Reader, 1024)); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Only the metadata at the start of the archive is read.
+	total := archive.Len()
+	var newMeta raft.SnapshotMeta
+	if err := readMeta(&archive, &newMeta); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !reflect.DeepEqual(newMeta, metadata) {
+		t.Fatalf("bad: %#v", newMeta)
+	}
+	if archive.Len() < 1024 || archive.Len() == total {
+		t.Fatalf("bad: %d of %d bytes left", archive.Len(), total)
+	}
+}
+
 func TestArchive_BadData(t *testing.T) {
 	cases := []struct {
 		Name  string
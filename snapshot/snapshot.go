@@ -126,6 +126,14 @@ func (s *Snapshot) Close() error {
 
 // Verify takes the snapshot from the reader and verifies its contents.
 func Verify(in io.Reader) (*raft.SnapshotMeta, error) {
+	return Read(in, ioutil.Discard)
+}
+
+// Read takes the snapshot from the reader, verifies its contents, and writes
+// the raw state data to the given writer. The integrity checks can only be
+// completed once all the data has been read, so the caller must not trust the
+// state data until Read returns without an error.
+func Read(in io.Reader, state io.Writer) (*raft.SnapshotMeta, error) {
 	// Wrap the reader in a gzip decompressor.
 	decomp, err := gzip.NewReader(in)
 	if err != nil {
@@ -133,9 +141,27 @@ func Verify(in io.Reader) (*raft.SnapshotMeta, error) {
 	}
 	defer decomp.Close()
 
-	// Read the archive, throwing away the snapshot data.
+	// Read the archive.
+	var metadata raft.SnapshotMeta
+	if err := read(decomp, &metadata, state); err != nil {
+		return nil, fmt.Errorf("failed to read snapshot file: %v", err)
+	}
+	return &metadata, nil
+}
+
+// ReadMeta takes the snapshot from the reader and returns its metadata,
+// stopping as soon as it has been read. None of the snapshot is verified, so
+// this is only suitable for a quick look at a snapshot; use Verify to check
+// it.
+func ReadMeta(in io.Reader) (*raft.SnapshotMeta, error) {
+	decomp, err := gzip.NewReader(in)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress snapshot: %v", err)
+	}
+	defer decomp.Close()
+
 	var metadata raft.SnapshotMeta
-	if err := read(decomp, &metadata, ioutil.Discard); err != nil {
+	if err := readMeta(decomp, &metadata); err != nil {
 		return nil, fmt.Errorf("failed to read snapshot file: %v", err)
 	}
 	return &metadata, nil